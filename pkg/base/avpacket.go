@@ -21,12 +21,24 @@ type AvPacketPt int
 
 const (
 	AvPacketPtUnknown AvPacketPt = -1
-	AvPacketPtG711A   AvPacketPt = 8  // g711a
-	AvPacketPtAvc     AvPacketPt = 96 // h264
-	AvPacketPtHevc    AvPacketPt = 98 // h265
-	AvPacketPtAac     AvPacketPt = 97 // aac
+	AvPacketPtG711U   AvPacketPt = 1000 // g711u, 注意，rtp的静态payload type为0，但0是零值，所以使用rtp中不存在的值，见 RtpPayloadType
+	AvPacketPtG711A   AvPacketPt = 8    // g711a
+	AvPacketPtMp3     AvPacketPt = 14   // mp3, 与rtp的静态payload type(MPA)保持一致
+	AvPacketPtAvc     AvPacketPt = 96   // h264
+	AvPacketPtHevc    AvPacketPt = 98   // h265
+	AvPacketPtAac     AvPacketPt = 97   // aac
+	AvPacketPtOpus    AvPacketPt = 111  // opus, 与webrtc中常用的rtp payload type保持一致
 )
 
+// RtpPayloadType 打包rtp包头以及sdp时使用的payload type
+func (a AvPacketPt) RtpPayloadType() int {
+	if a == AvPacketPtG711U {
+		// rfc3551 PCMU的静态payload type
+		return 0
+	}
+	return int(a)
+}
+
 func (a AvPacketPt) ReadableString() string {
 	switch a {
 	case AvPacketPtUnknown:
@@ -37,6 +49,14 @@ func (a AvPacketPt) ReadableString() string {
 		return "h265"
 	case AvPacketPtAac:
 		return "aac"
+	case AvPacketPtG711A:
+		return "g711a"
+	case AvPacketPtG711U:
+		return "g711u"
+	case AvPacketPtMp3:
+		return "mp3"
+	case AvPacketPtOpus:
+		return "opus"
 	}
 	return ""
 }
@@ -55,7 +75,11 @@ type AvPacket struct {
 }
 
 func (packet *AvPacket) IsAudio() bool {
-	return packet.PayloadType == AvPacketPtAac
+	switch packet.PayloadType {
+	case AvPacketPtAac, AvPacketPtG711A, AvPacketPtG711U, AvPacketPtMp3, AvPacketPtOpus:
+		return true
+	}
+	return false
 }
 
 func (packet *AvPacket) IsVideo() bool {
@@ -85,6 +85,11 @@ func (session *BasicHttpSubSession) Write(b []byte) {
 	session.write(b)
 }
 
+// Flush 阻塞直到已经调用Write的数据都写入连接
+func (session *BasicHttpSubSession) Flush() error {
+	return session.conn.Flush()
+}
+
 // ---------------------------------------------------------------------------------------------------------------------
 // IObject interface
 // ---------------------------------------------------------------------------------------------------------------------
@@ -82,6 +82,22 @@ func NewBasicSessionStat(sessionType SessionType, remoteAddr string) BasicSessio
 		s.stat.SessionId = GenUkPsPubSession()
 		s.stat.BaseType = SessionBaseTypePubStr
 		s.stat.Protocol = SessionProtocolPsStr
+	case SessionTypeSrtPub:
+		s.stat.SessionId = GenUkSrtPubSession()
+		s.stat.BaseType = SessionBaseTypePubStr
+		s.stat.Protocol = SessionProtocolSrtStr
+	case SessionTypeSrtSub:
+		s.stat.SessionId = GenUkSrtSubSession()
+		s.stat.BaseType = SessionBaseTypeSubStr
+		s.stat.Protocol = SessionProtocolSrtStr
+	case SessionTypeWebrtcPub:
+		s.stat.SessionId = GenUkWebrtcPubSession()
+		s.stat.BaseType = SessionBaseTypePubStr
+		s.stat.Protocol = SessionProtocolWebrtcStr
+	case SessionTypeWebrtcSub:
+		s.stat.SessionId = GenUkWebrtcSubSession()
+		s.stat.BaseType = SessionBaseTypeSubStr
+		s.stat.Protocol = SessionProtocolWebrtcStr
 	}
 	return s
 }
@@ -137,6 +153,16 @@ func (s *BasicSessionStat) IsAliveWitchConn(conn IStatable) (readAlive, writeAli
 
 // ---------------------------------------------------------------------------------------------------------------------
 
+// ReadBytesSum 只读取原子变量，可以在其他协程中调用
+func (s *BasicSessionStat) ReadBytesSum() uint64 {
+	return s.currConnStat.ReadBytesSum.Load()
+}
+
+// WroteBytesSum 同 ReadBytesSum
+func (s *BasicSessionStat) WroteBytesSum() uint64 {
+	return s.currConnStat.WroteBytesSum.Load()
+}
+
 func (s *BasicSessionStat) BaseType() string {
 	return s.stat.BaseType
 }
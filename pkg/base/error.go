@@ -39,6 +39,10 @@ var (
 	ErrInvalidUrl = errors.New("lal.base: invalid url")
 )
 
+// ----- pkg/fmp4 ------------------------------------------------------------------------------------------------------
+
+var ErrFmp4 = errors.New("lal.fmp4: fxxk")
+
 // ----- pkg/hevc ------------------------------------------------------------------------------------------------------
 
 var ErrHevc = errors.New("lal.hevc: fxxk")
@@ -48,6 +52,10 @@ var ErrHevc = errors.New("lal.hevc: fxxk")
 var ErrHls = errors.New("lal.hls: fxxk")
 var ErrHlsSessionNotFound = errors.New("lal.hls: hls session not found")
 
+// ----- pkg/httpflv ---------------------------------------------------------------------------------------------------
+
+var ErrFlvFileInvalid = errors.New("lal.httpflv: flv file invalid")
+
 // ----- pkg/rtmp ------------------------------------------------------------------------------------------------------
 
 var (
@@ -55,8 +63,12 @@ var (
 	ErrAmfTooShort    = errors.New("lal.rtmp: too short to unmarshal amf0 data")
 	ErrAmfNotExist    = errors.New("lal.rtmp: not exist")
 
+	ErrAmf3InvalidReference = errors.New("lal.rtmp: invalid amf3 reference")
+	ErrAmf3Unsupported      = errors.New("lal.rtmp: unsupported amf3 data")
+
 	ErrRtmpShortBuffer   = errors.New("lal.rtmp: buffer too short")
 	ErrRtmpUnexpectedMsg = errors.New("lal.rtmp: unexpected msg")
+	ErrRtmpAuthFailed    = errors.New("lal.rtmp: auth failed")
 )
 
 // ----- pkg/rtprtcp ---------------------------------------------------------------------------------------------------
@@ -68,6 +80,10 @@ var ErrRtpRtcpShortBuffer = errors.New("lal.rtprtcp: buffer too short")
 var (
 	ErrRtsp                 = errors.New("lal.rtsp: fxxk")
 	ErrRtspClosedByObserver = errors.New("lal.rtsp: close by observer")
+	ErrRtspHttpTunnel       = errors.New("lal.rtsp: http tunnel invalid")
+
+	ErrRtspMulticastConfig        = errors.New("lal.rtsp: multicast config invalid")
+	ErrRtspMulticastAddrExhausted = errors.New("lal.rtsp: multicast addr exhausted")
 )
 
 // ----- pkg/sdp -------------------------------------------------------------------------------------------------------
@@ -82,6 +98,50 @@ var (
 
 	ErrSimpleAuthParamNotFound = errors.New("lal.logic: simple auth failed since url param lal_secret not found")
 	ErrSimpleAuthFailed        = errors.New("lal.logic: simple auth failed since url param lal_secret invalid")
+
+	ErrTokenAuthParamNotFound = errors.New("lal.logic: token auth failed since token not found")
+	ErrTokenAuthFailed        = errors.New("lal.logic: token auth failed since token invalid")
+	ErrMaxViewersReached      = errors.New("lal.logic: token auth failed since max viewers reached")
+
+	ErrHttpAuthFailed         = errors.New("lal.logic: http auth failed since request callback failed")
+	ErrHttpAuthRejected       = errors.New("lal.logic: http auth rejected by callback")
+	ErrAuthSessionDisposed    = errors.New("lal.logic: session disposed during authentication")
+	ErrAuthResultNotSupported = errors.New("lal.logic: auth result not supported by hls")
+
+	ErrRecordFormatInvalid   = errors.New("lal.logic: record format invalid")
+	ErrRecordAlreadyStarted  = errors.New("lal.logic: record already started")
+	ErrRecordNotStarted      = errors.New("lal.logic: record not started")
+	ErrRecordNoInStream      = errors.New("lal.logic: no in stream to record")
+	ErrRecordFilenameInvalid = errors.New("lal.logic: record filename invalid")
+	ErrVodFilenameInvalid    = errors.New("lal.logic: vod filename invalid")
+
+	ErrConfigInvalid      = errors.New("lal.logic: config invalid")
+	ErrConfigCannotReload = errors.New("lal.logic: config can not reload since it is not loaded from file")
+)
+
+// ----- pkg/srt -------------------------------------------------------------------------------------------------------
+
+var (
+	ErrSrt                 = errors.New("lal.srt: fxxk")
+	ErrSrtClosedByObserver = errors.New("lal.srt: close by observer")
+	ErrSrtPeerShutdown     = errors.New("lal.srt: peer shutdown")
+	ErrSrtPeerIdleTimeout  = errors.New("lal.srt: peer idle timeout")
+	ErrSrtInvalidPacket    = errors.New("lal.srt: invalid packet")
+	ErrSrtInvalidStreamId  = errors.New("lal.srt: invalid stream id")
+)
+
+// ----- pkg/webrtc ----------------------------------------------------------------------------------------------------
+
+var (
+	ErrWebrtc                 = errors.New("lal.webrtc: fxxk")
+	ErrWebrtcInvalidSdp       = errors.New("lal.webrtc: invalid sdp")
+	ErrWebrtcNoCodec          = errors.New("lal.webrtc: no supported codec in sdp")
+	ErrWebrtcStun             = errors.New("lal.webrtc: invalid stun message")
+	ErrWebrtcDtls             = errors.New("lal.webrtc: dtls handshake failed")
+	ErrWebrtcDtlsAlert        = errors.New("lal.webrtc: dtls alert received")
+	ErrWebrtcSrtpAuthFailed   = errors.New("lal.webrtc: srtp auth failed")
+	ErrWebrtcPeerIdleTimeout  = errors.New("lal.webrtc: peer idle timeout")
+	ErrWebrtcClosedByObserver = errors.New("lal.webrtc: close by observer")
 )
 
 // ----- pkg/gb28181 ---------------------------------------------------------------------------------------------------
@@ -99,3 +159,19 @@ func NewErrAmfInvalidType(b byte) error {
 func NewErrRtmpShortBuffer(need, actual int, msg string) error {
 	return fmt.Errorf("%w. need=%d, actual=%d, msg=%s", ErrRtmpShortBuffer, need, actual, msg)
 }
+
+func NewErrSrtInvalidPacket(length int) error {
+	return fmt.Errorf("%w. length=%d", ErrSrtInvalidPacket, length)
+}
+
+func NewErrSrtInvalidStreamId(streamId string) error {
+	return fmt.Errorf("%w. stream id=%s", ErrSrtInvalidStreamId, streamId)
+}
+
+func NewErrWebrtcDtls(reason string) error {
+	return fmt.Errorf("%w. reason=%s", ErrWebrtcDtls, reason)
+}
+
+func NewErrWebrtcDtlsAlert(level, desc byte) error {
+	return fmt.Errorf("%w. level=%d, desc=%d", ErrWebrtcDtlsAlert, level, desc)
+}
@@ -34,6 +34,12 @@ type LocalAddrCtx struct {
 
 type HttpServerManager struct {
 	addr2ServerCtx map[string]*ServerCtx
+	interceptors   []interceptor
+}
+
+type interceptor struct {
+	match   func(r *http.Request) bool
+	handler Handler
 }
 
 type ServerCtx struct {
@@ -92,7 +98,7 @@ func (s *HttpServerManager) AddListen(addrCtx LocalAddrCtx, pattern string, hand
 			addrCtx:  addrCtx,
 			listener: l,
 			httpServer: http.Server{
-				Handler: mux,
+				Handler: s.wrapInterceptors(mux),
 			},
 			mux:             mux,
 			pattern2Handler: make(map[string]Handler),
@@ -116,6 +122,16 @@ func (s *HttpServerManager) AddListen(addrCtx LocalAddrCtx, pattern string, hand
 	return nil
 }
 
+// AddInterceptor
+//
+// 所有监听地址上的请求，在按`pattern`路由之前，先依次交给`match`判断，返回true时由`handler`处理，不再走路由。
+// 用于一些不能通过路径区分的请求，比如RTSP over HTTP的请求路径和http-flv的相同，只能通过header区分。
+//
+// 注意，需要在 RunLoop 之前调用
+func (s *HttpServerManager) AddInterceptor(match func(r *http.Request) bool, handler Handler) {
+	s.interceptors = append(s.interceptors, interceptor{match: match, handler: handler})
+}
+
 func (s *HttpServerManager) RunLoop() error {
 	errChan := make(chan error, len(s.addr2ServerCtx))
 
@@ -142,6 +158,18 @@ func (s *HttpServerManager) Dispose() error {
 
 // ---------------------------------------------------------------------------------------------------------------------
 
+func (s *HttpServerManager) wrapInterceptors(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, item := range s.interceptors {
+			if item.match(r) {
+				item.handler(w, r)
+				return
+			}
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
 // 为传入的`Addr`地址创建http或https监听
 func listen(ctx LocalAddrCtx) (net.Listener, error) {
 	if ctx.Network == "" {
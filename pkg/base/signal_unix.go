@@ -27,3 +27,13 @@ func RunSignalHandler(cb func()) {
 	Log.Infof("recv signal. s=%+v", s)
 	cb()
 }
+
+// RunReloadSignalHandler 监听SIGHUP信号并回调，每次收到信号都会回调
+func RunReloadSignalHandler(cb func()) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+	for s := range c {
+		Log.Infof("recv signal. s=%+v", s)
+		cb()
+	}
+}
@@ -14,3 +14,7 @@ package base
 func RunSignalHandler(cb func()) {
 	// noop
 }
+
+func RunReloadSignalHandler(cb func()) {
+	// noop
+}
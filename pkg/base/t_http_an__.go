@@ -15,11 +15,17 @@ package base
 
 const (
 	// AudioCodecAac StatGroup.AudioCodec
-	AudioCodecAac = "AAC"
+	AudioCodecAac   = "AAC"
+	AudioCodecG711A = "PCMA"
+	AudioCodecG711U = "PCMU"
+	AudioCodecMp3   = "MP3"
+	AudioCodecOpus  = "OPUS"
 
 	// VideoCodecAvc StatGroup.VideoCodec
 	VideoCodecAvc  = "H264"
 	VideoCodecHevc = "H265"
+	VideoCodecAv1  = "AV1"
+	VideoCodecVp9  = "VP9"
 )
 
 type LalInfo struct {
@@ -33,15 +39,35 @@ type LalInfo struct {
 }
 
 type StatGroup struct {
-	StreamName  string    `json:"stream_name"`
-	AppName     string    `json:"app_name"`
-	AudioCodec  string    `json:"audio_codec"`
-	VideoCodec  string    `json:"video_codec"`
-	VideoWidth  int       `json:"video_width"`
-	VideoHeight int       `json:"video_height"`
-	StatPub     StatPub   `json:"pub"`
-	StatSubs    []StatSub `json:"subs"` // TODO(chef): [opt] 增加数量字段，因为这里不一定全部放入
-	StatPull    StatPull  `json:"pull"`
+	StreamName     string          `json:"stream_name"`
+	AppName        string          `json:"app_name"`
+	AudioCodec     string          `json:"audio_codec"`
+	VideoCodec     string          `json:"video_codec"`
+	VideoWidth     int             `json:"video_width"`
+	VideoHeight    int             `json:"video_height"`
+	StatPub        StatPub         `json:"pub"`
+	StatSubs       []StatSub       `json:"subs"` // TODO(chef): [opt] 增加数量字段，因为这里不一定全部放入
+	StatPull       StatPull        `json:"pull"`
+	StatRecords    []StatRecord    `json:"records"`    // 正在进行的录制
+	StatTranscodes []StatTranscode `json:"transcodes"` // 正在进行的转码
+}
+
+type StatRecord struct {
+	Format         string `json:"format"`
+	OutPath        string `json:"out_path"`
+	Filename       string `json:"filename"` // 当前正在写入的文件
+	StartTime      string `json:"start_time"`
+	MaxDurationSec int    `json:"max_duration_sec"`
+}
+
+type StatTranscode struct {
+	Profile       string `json:"profile"`
+	OutStreamName string `json:"out_stream_name"`
+	Command       string `json:"command"`
+	Pid           int    `json:"pid"` // 进程不在运行时（比如等待重启）为0
+	StartTime     string `json:"start_time"`
+	RestartCount  int    `json:"restart_count"`
+	LastError     string `json:"last_error"` // 上一次进程退出的原因
 }
 
 type StatSession struct {
@@ -58,6 +84,11 @@ type StatSession struct {
 	ReadBitrateKbits  int    `json:"read_bitrate_kbits"`
 	WriteBitrateKbits int    `json:"write_bitrate_kbits"`
 
+	// 以下为rtsp sub、push session根据对端回复的rtcp rr得到的统计，音视频两路合并，其他类型的session为0
+	RtcpLost     uint32 `json:"rtcp_lost"`      // 对端统计的累计丢包数，音视频之和
+	RtcpJitterMs int    `json:"rtcp_jitter_ms"` // 对端统计的抖动，音视频取较大值
+	RtcpRttMs    int    `json:"rtcp_rtt_ms"`    // 音视频取较大值
+
 	typ SessionType
 }
 
@@ -46,6 +46,18 @@ type ApiCtrlStartRtpPubReq struct {
 	DebugDumpPacket string `json:"debug_dump_packet"`
 }
 
+type ApiCtrlStartRecordReq struct {
+	StreamName     string `json:"stream_name"`
+	Format         string `json:"format"`           // flv, ts, mp4, hls
+	OutPath        string `json:"out_path"`         // 为空时使用配置文件中对应格式的路径，hls格式必须填写
+	MaxDurationSec int    `json:"max_duration_sec"` // 录制时长达到后自动停止，为0时表示不限制
+}
+
+type ApiCtrlStopRecordReq struct {
+	StreamName string `json:"stream_name"`
+	Format     string `json:"format"`
+}
+
 // ----- response ------------------------------------------------------------------------------------------------------
 
 const (
@@ -61,9 +73,13 @@ const (
 	DespParamMissing         = "param missing"
 	ErrorCodeSessionNotFound = 1003
 	DespSessionNotFound      = "session not found"
+	ErrorCodeRecordNotFound  = 1004
+	DespRecordNotFound       = "record not found"
 
 	ErrorCodeStartRelayPullFail = 2001
 	ErrorCodeListenUdpPortFail  = 2002
+	ErrorCodeStartRecordFail    = 2003
+	ErrorCodeReloadConfigFail   = 2004
 )
 
 type ApiRespBasic struct {
@@ -120,3 +136,24 @@ type ApiCtrlStartRtpPubResp struct {
 		Port       int    `json:"port"`
 	} `json:"data"`
 }
+
+type ApiCtrlStartRecordResp struct {
+	ApiRespBasic
+	Data struct {
+		StreamName string `json:"stream_name"`
+		Format     string `json:"format"`
+		Filename   string `json:"filename"` // 当前正在写入的文件，hls格式时为录制的m3u8文件
+	} `json:"data"`
+}
+
+type ApiCtrlStopRecordResp struct {
+	ApiRespBasic
+}
+
+type ApiCtrlReloadConfigResp struct {
+	ApiRespBasic
+	Data struct {
+		Applied         []string `json:"applied"`          // 已生效的配置项
+		RestartRequired []string `json:"restart_required"` // 有变化，但需要重启才能生效的配置项
+	} `json:"data"`
+}
@@ -29,6 +29,8 @@ type SessionEventCommonInfo struct {
 	StreamName string `json:"stream_name"`
 	UrlParam   string `json:"url_param"`
 
+	Header map[string][]string `json:"-"` // 请求的header，比如http-flv、http-ts、rtsp，不对外通知
+
 	HasInSession  bool `json:"has_in_session"`
 	HasOutSession bool `json:"has_out_session"`
 
@@ -97,6 +99,20 @@ type HlsMakeTsInfo struct {
 	Duration       float64 `json:"duration"`
 }
 
+// RecordFileClosedInfo 录制文件写入完成
+type RecordFileClosedInfo struct {
+	EventCommonInfo
+
+	Format     string  `json:"format"` // 录制格式，比如 "mp4"
+	AppName    string  `json:"app_name"`
+	StreamName string  `json:"stream_name"`
+	Filename   string  `json:"filename"`   // 录制文件的路径
+	StartTime  string  `json:"start_time"` // 文件开始写入的时间
+	EndTime    string  `json:"end_time"`   // 文件结束写入的时间
+	Duration   float64 `json:"duration"`   // 文件中音视频数据的时长，单位秒
+	FileSize   int64   `json:"file_size"`
+}
+
 // ---------------------------------------------------------------------------------------------------------------------
 
 func Session2PubStartInfo(session ISession) PubStartInfo {
@@ -150,6 +166,7 @@ func session2EventCommonInfo(session ISession) SessionEventCommonInfo {
 	info.StreamName = session.StreamName()
 	info.Url = session.Url()
 	info.UrlParam = session.RawQuery()
+	info.Header = session.Header()
 	info.ReadBytesSum = stat.ReadBytesSum
 	info.WroteBytesSum = stat.WroteBytesSum
 	return info
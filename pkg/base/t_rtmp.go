@@ -21,6 +21,7 @@ const (
 	RtmpTypeIdAudio              uint8 = 8
 	RtmpTypeIdVideo              uint8 = 9
 	RtmpTypeIdMetadata           uint8 = 18 // RtmpTypeIdDataMessageAmf0
+	RtmpTypeIdMetadataAmf3       uint8 = 15 // RtmpTypeIdDataMessageAmf3
 	RtmpTypeIdSetChunkSize       uint8 = 1
 	RtmpTypeIdAck                uint8 = 3
 	RtmpTypeIdUserControl        uint8 = 4
@@ -70,6 +71,30 @@ const (
 	RtmpAvcInterFrame  = RtmpFrameTypeInter<<4 | RtmpCodecIdAvc
 	RtmpHevcInterFrame = RtmpFrameTypeInter<<4 | RtmpCodecIdHevc
 
+	// RtmpExHeaderFlag enhanced-rtmp-v1.pdf
+	// ExVideoTagHeader
+	//   IsExHeader      UB[1]
+	//   FrameType       UB[3]
+	//   PacketType      UB[4]
+	//   FourCC          UI32
+	//   CompositionTime SI24 // 只有hvc1的CodedFrames才有
+	//   Data            UI8[n]
+	//
+	// 注意，IsExHeader为1时，后4位不再是CodecId，而是PacketType
+	//
+	RtmpExHeaderFlag uint8 = 0x80
+
+	RtmpExPacketTypeSequenceStart        uint8 = 0
+	RtmpExPacketTypeCodedFrames          uint8 = 1
+	RtmpExPacketTypeSequenceEnd          uint8 = 2
+	RtmpExPacketTypeCodedFramesX         uint8 = 3 // 没有CompositionTime字段，cts为0
+	RtmpExPacketTypeMetadata             uint8 = 4
+	RtmpExPacketTypeMpeg2TsSequenceStart uint8 = 5
+
+	RtmpFourCcHevc = "hvc1"
+	RtmpFourCcAv1  = "av01"
+	RtmpFourCcVp9  = "vp09"
+
 	// RtmpSoundFormatAac spec-video_file_format_spec_v10.pdf
 	// Audio tags
 	//   AUDIODATA
@@ -80,9 +105,24 @@ const (
 	//   AACAUDIODATA
 	//     AACPacketType UI8
 	//     Data          UI8[n]
+	RtmpSoundFormatMp3         uint8 = 2
+	RtmpSoundFormatG711A       uint8 = 7
+	RtmpSoundFormatG711U       uint8 = 8
 	RtmpSoundFormatAac         uint8 = 10 // 注意，视频的CodecId是后4位，音频是前4位
 	RtmpAacPacketTypeSeqHeader       = 0
 	RtmpAacPacketTypeRaw             = 1
+
+	// RtmpSoundFormatExHeader enhanced-rtmp-v2.pdf
+	// ExAudioTagHeader
+	//   SoundFormat     UB[4] 固定为9
+	//   AudioPacketType UB[4] 取值与 RtmpExPacketTypeXxx 的SequenceStart，CodedFrames，SequenceEnd相同
+	//   AudioFourCc     FOURCC
+	//   Data            UI8[n]
+	//
+	// Opus只有Enhanced RTMP格式
+	RtmpSoundFormatExHeader uint8 = 9
+
+	RtmpFourCcOpus = "Opus"
 )
 
 type RtmpHeader struct {
@@ -106,8 +146,9 @@ func (msg RtmpMsg) IsHevcKeySeqHeader() bool {
 	return msg.Header.MsgTypeId == RtmpTypeIdVideo && msg.Payload[0] == RtmpHevcKeyFrame && msg.Payload[1] == RtmpHevcPacketTypeSeqHeader
 }
 
+// IsVideoKeySeqHeader AVC或HEVC的seq header，或者Enhanced RTMP格式的seq header
 func (msg RtmpMsg) IsVideoKeySeqHeader() bool {
-	return msg.IsAvcKeySeqHeader() || msg.IsHevcKeySeqHeader()
+	return msg.IsAvcKeySeqHeader() || msg.IsHevcKeySeqHeader() || msg.IsEnhancedKeySeqHeader()
 }
 
 func (msg RtmpMsg) IsAvcKeyNalu() bool {
@@ -118,15 +159,101 @@ func (msg RtmpMsg) IsHevcKeyNalu() bool {
 	return msg.Header.MsgTypeId == RtmpTypeIdVideo && msg.Payload[0] == RtmpHevcKeyFrame && msg.Payload[1] == RtmpHevcPacketTypeNalu
 }
 
+// IsVideoKeyNalu AVC或HEVC的关键帧，或者Enhanced RTMP格式的关键帧
 func (msg RtmpMsg) IsVideoKeyNalu() bool {
-	return msg.IsAvcKeyNalu() || msg.IsHevcKeyNalu()
+	return msg.IsAvcKeyNalu() || msg.IsHevcKeyNalu() || msg.IsEnhancedKeyNalu()
+}
+
+// IsEnhanced 是否为Enhanced RTMP格式的视频数据，也即使用了ExVideoTagHeader
+//
+// 注意，IsAvcXxx，IsHevcXxx系列函数只判断传统格式（比如CodecId为12的HEVC），不包含Enhanced RTMP格式
+func (msg RtmpMsg) IsEnhanced() bool {
+	return msg.Header.MsgTypeId == RtmpTypeIdVideo && len(msg.Payload) >= 5 && msg.Payload[0]&RtmpExHeaderFlag != 0
+}
+
+// VideoFourCc Enhanced RTMP格式时返回FourCC，比如 RtmpFourCcHevc，否则返回空字符串
+func (msg RtmpMsg) VideoFourCc() string {
+	if !msg.IsEnhanced() {
+		return ""
+	}
+	return string(msg.Payload[1:5])
+}
+
+// ExPacketType Enhanced RTMP格式的PacketType，调用方需保证 IsEnhanced 或 IsEnhancedAudio 为true
+func (msg RtmpMsg) ExPacketType() uint8 {
+	return msg.Payload[0] & 0xF
+}
+
+func (msg RtmpMsg) IsEnhancedKeySeqHeader() bool {
+	return msg.IsEnhanced() && (msg.Payload[0]>>4)&0x7 == RtmpFrameTypeKey && msg.ExPacketType() == RtmpExPacketTypeSequenceStart
+}
+
+func (msg RtmpMsg) IsEnhancedKeyNalu() bool {
+	if !msg.IsEnhanced() || (msg.Payload[0]>>4)&0x7 != RtmpFrameTypeKey {
+		return false
+	}
+	pt := msg.ExPacketType()
+	return pt == RtmpExPacketTypeCodedFrames || pt == RtmpExPacketTypeCodedFramesX
 }
 
 func (msg RtmpMsg) IsAacSeqHeader() bool {
 	return msg.Header.MsgTypeId == RtmpTypeIdAudio && (msg.Payload[0]>>4) == RtmpSoundFormatAac && msg.Payload[1] == RtmpAacPacketTypeSeqHeader
 }
 
+// IsEnhancedAudio 是否为Enhanced RTMP格式的音频数据，也即使用了ExAudioTagHeader
+func (msg RtmpMsg) IsEnhancedAudio() bool {
+	return msg.Header.MsgTypeId == RtmpTypeIdAudio && len(msg.Payload) >= 5 && msg.Payload[0]>>4 == RtmpSoundFormatExHeader
+}
+
+// AudioFourCc Enhanced RTMP格式时返回FourCC，比如 RtmpFourCcOpus，否则返回空字符串
+func (msg RtmpMsg) AudioFourCc() string {
+	if !msg.IsEnhancedAudio() {
+		return ""
+	}
+	return string(msg.Payload[1:5])
+}
+
+func (msg RtmpMsg) IsEnhancedAudioSeqHeader() bool {
+	return msg.IsEnhancedAudio() && msg.ExPacketType() == RtmpExPacketTypeSequenceStart
+}
+
+// IsAudioSeqHeader AAC的seq header，或者Enhanced RTMP格式的音频seq header（比如Opus的OpusHead）
+func (msg RtmpMsg) IsAudioSeqHeader() bool {
+	return msg.IsAacSeqHeader() || msg.IsEnhancedAudioSeqHeader()
+}
+
+// AudioPayloadType 音频数据对应的 AvPacketPt，不是音频或者不支持的音频格式时返回 AvPacketPtUnknown
+func (msg RtmpMsg) AudioPayloadType() AvPacketPt {
+	if msg.Header.MsgTypeId != RtmpTypeIdAudio || len(msg.Payload) == 0 {
+		return AvPacketPtUnknown
+	}
+	switch msg.Payload[0] >> 4 {
+	case RtmpSoundFormatAac:
+		return AvPacketPtAac
+	case RtmpSoundFormatG711A:
+		return AvPacketPtG711A
+	case RtmpSoundFormatG711U:
+		return AvPacketPtG711U
+	case RtmpSoundFormatMp3:
+		return AvPacketPtMp3
+	case RtmpSoundFormatExHeader:
+		if msg.AudioFourCc() == RtmpFourCcOpus {
+			return AvPacketPtOpus
+		}
+	}
+	return AvPacketPtUnknown
+}
+
+// VideoCodecId
+//
+// 注意，Enhanced RTMP格式的HEVC也返回 RtmpCodecIdHevc，其他Enhanced RTMP格式的编码（比如AV1，VP9）没有对应的CodecId，返回0
 func (msg RtmpMsg) VideoCodecId() uint8 {
+	if msg.IsEnhanced() {
+		if msg.VideoFourCc() == RtmpFourCcHevc {
+			return RtmpCodecIdHevc
+		}
+		return 0
+	}
 	return msg.Payload[0] & 0xF
 }
 
@@ -145,6 +272,12 @@ func (msg RtmpMsg) Dts() uint32 {
 //
 // 注意，只有视频才能调用该函数获取pts，音频的dts和pts都直接使用 RtmpMsg.Header.TimestampAbs
 func (msg RtmpMsg) Pts() uint32 {
+	if msg.IsEnhanced() {
+		if msg.VideoFourCc() == RtmpFourCcHevc && msg.ExPacketType() == RtmpExPacketTypeCodedFrames && len(msg.Payload) >= 8 {
+			return msg.Header.TimestampAbs + bele.BeUint24(msg.Payload[5:])
+		}
+		return msg.Header.TimestampAbs
+	}
 	return msg.Header.TimestampAbs + bele.BeUint24(msg.Payload[2:])
 }
 
@@ -10,8 +10,8 @@ package base
 
 // ----- 所有session -----
 //
-// server.pub:  rtmp(ServerSession), rtsp(PubSession), customize(CustomizePubSessionContext), ps(gb28181.PubSession)
-// server.sub:  rtmp(ServerSession), rtsp(SubSession), flv(SubSession), ts(SubSession), 还有一个比较特殊的hls
+// server.pub:  rtmp(ServerSession), rtsp(PubSession), customize(CustomizePubSessionContext), ps(gb28181.PubSession), srt(PubSession)
+// server.sub:  rtmp(ServerSession), rtsp(SubSession), flv(SubSession), ts(SubSession), srt(SubSession), 还有一个比较特殊的hls
 //
 // client.push: rtmp(PushSession), rtsp(PushSession)
 // client.pull: rtmp(PullSession), rtsp(PullSession), flv(PullSession)
@@ -40,6 +40,10 @@ const (
 	SessionTypeTsSub             SessionType = SessionProtocolTs<<8 | SessionBaseTypeSub
 	SessionTypePsPub             SessionType = SessionProtocolPs<<8 | SessionBaseTypePub
 	SessionTypeHlsSub            SessionType = SessionProtocolHls<<8 | SessionBaseTypeSub
+	SessionTypeSrtPub            SessionType = SessionProtocolSrt<<8 | SessionBaseTypePub
+	SessionTypeSrtSub            SessionType = SessionProtocolSrt<<8 | SessionBaseTypeSub
+	SessionTypeWebrtcPub         SessionType = SessionProtocolWebrtc<<8 | SessionBaseTypePub
+	SessionTypeWebrtcSub         SessionType = SessionProtocolWebrtc<<8 | SessionBaseTypeSub
 
 	SessionProtocolCustomize = 1
 	SessionProtocolRtmp      = 2
@@ -48,6 +52,8 @@ const (
 	SessionProtocolTs        = 5
 	SessionProtocolPs        = 6
 	SessionProtocolHls       = 7
+	SessionProtocolSrt       = 8
+	SessionProtocolWebrtc    = 9
 
 	SessionBaseTypePubSub = 1
 	SessionBaseTypePub    = 2
@@ -62,6 +68,8 @@ const (
 	SessionProtocolTsStr        = "TS"
 	SessionProtocolPsStr        = "PS"
 	SessionProtocolHlsStr       = "HLS"
+	SessionProtocolSrtStr       = "SRT"
+	SessionProtocolWebrtcStr    = "WEBRTC"
 
 	SessionBaseTypePubSubStr = "PUBSUB"
 	SessionBaseTypePubStr    = "PUB"
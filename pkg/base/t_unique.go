@@ -24,12 +24,18 @@ const (
 	UkPreTsSubSession               = SessionProtocolTsStr + SessionBaseTypePubSubStr     // "TSSUB"
 	UkPrePsPubSession               = SessionProtocolPsStr + SessionBaseTypePubStr        // "PSPUB"
 	UkPreHlsSubSession              = SessionProtocolHlsStr + SessionBaseTypeSubStr       // "HLSSUB"
+	UkPreSrtPubSession              = SessionProtocolSrtStr + SessionBaseTypePubStr       // "SRTPUB"
+	UkPreSrtSubSession              = SessionProtocolSrtStr + SessionBaseTypeSubStr       // "SRTSUB"
+	UkPreWebrtcPubSession           = SessionProtocolWebrtcStr + SessionBaseTypePubStr    // "WEBRTCPUB"
+	UkPreWebrtcSubSession           = SessionProtocolWebrtcStr + SessionBaseTypeSubStr    // "WEBRTCSUB"
 
 	UkPreRtspServerCommandSession = "RTSPSRVCMD" // 这个不暴露给上层
 
 	UkPreGroup              = "GROUP"
 	UkPreHlsMuxer           = "HLSMUXER"
 	UkPreRtmp2MpegtsRemuxer = "RTMP2MPEGTS"
+	UkPreRtmp2Fmp4Remuxer   = "RTMP2FMP4"
+	UkPreTranscoder         = "TRANSCODER"
 )
 
 //func GenUk(prefix string) string {
@@ -92,6 +98,22 @@ func GenUkPsPubSession() string {
 	return siUkPsPubSession.GenUniqueKey()
 }
 
+func GenUkSrtPubSession() string {
+	return siUkSrtPubSession.GenUniqueKey()
+}
+
+func GenUkSrtSubSession() string {
+	return siUkSrtSubSession.GenUniqueKey()
+}
+
+func GenUkWebrtcPubSession() string {
+	return siUkWebrtcPubSession.GenUniqueKey()
+}
+
+func GenUkWebrtcSubSession() string {
+	return siUkWebrtcSubSession.GenUniqueKey()
+}
+
 func GenUkGroup() string {
 	return siUkGroup.GenUniqueKey()
 }
@@ -104,6 +126,14 @@ func GenUkRtmp2MpegtsRemuxer() string {
 	return siUkRtmp2MpegtsRemuxer.GenUniqueKey()
 }
 
+func GenUkRtmp2Fmp4Remuxer() string {
+	return siUkRtmp2Fmp4Remuxer.GenUniqueKey()
+}
+
+func GenUkTranscoder() string {
+	return siUkTranscoder.GenUniqueKey()
+}
+
 var (
 	siUkCustomizePubSession      *unique.SingleGenerator
 	siUkRtmpServerSession        *unique.SingleGenerator
@@ -119,10 +149,16 @@ var (
 	siUkFlvPullSession           *unique.SingleGenerator
 	siUkPsPubSession             *unique.SingleGenerator
 	siUkHlsSubSession            *unique.SingleGenerator
+	siUkSrtPubSession            *unique.SingleGenerator
+	siUkSrtSubSession            *unique.SingleGenerator
+	siUkWebrtcPubSession         *unique.SingleGenerator
+	siUkWebrtcSubSession         *unique.SingleGenerator
 
 	siUkGroup              *unique.SingleGenerator
 	siUkHlsMuxer           *unique.SingleGenerator
 	siUkRtmp2MpegtsRemuxer *unique.SingleGenerator
+	siUkRtmp2Fmp4Remuxer   *unique.SingleGenerator
+	siUkTranscoder         *unique.SingleGenerator
 )
 
 func init() {
@@ -140,8 +176,14 @@ func init() {
 	siUkFlvPullSession = unique.NewSingleGenerator(UkPreFlvPullSession)
 	siUkPsPubSession = unique.NewSingleGenerator(UkPrePsPubSession)
 	siUkHlsSubSession = unique.NewSingleGenerator(UkPreHlsSubSession)
+	siUkSrtPubSession = unique.NewSingleGenerator(UkPreSrtPubSession)
+	siUkSrtSubSession = unique.NewSingleGenerator(UkPreSrtSubSession)
+	siUkWebrtcPubSession = unique.NewSingleGenerator(UkPreWebrtcPubSession)
+	siUkWebrtcSubSession = unique.NewSingleGenerator(UkPreWebrtcSubSession)
 
 	siUkGroup = unique.NewSingleGenerator(UkPreGroup)
 	siUkHlsMuxer = unique.NewSingleGenerator(UkPreHlsMuxer)
 	siUkRtmp2MpegtsRemuxer = unique.NewSingleGenerator(UkPreRtmp2MpegtsRemuxer)
+	siUkRtmp2Fmp4Remuxer = unique.NewSingleGenerator(UkPreRtmp2Fmp4Remuxer)
+	siUkTranscoder = unique.NewSingleGenerator(UkPreTranscoder)
 }
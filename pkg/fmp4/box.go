@@ -0,0 +1,79 @@
+// Copyright 2023, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package fmp4
+
+import (
+	"github.com/q191201771/naza/pkg/bele"
+)
+
+// ISO_IEC_14496-12 4.2 Object Structure
+//
+// box的结构为 4字节size + 4字节type + payload，full box在payload前再增加1字节version和3字节flags
+
+func box(typ string, payloads ...[]byte) []byte {
+	size := 8
+	for _, p := range payloads {
+		size += len(p)
+	}
+	out := make([]byte, 8, size)
+	bele.BePutUint32(out, uint32(size))
+	copy(out[4:], typ)
+	for _, p := range payloads {
+		out = append(out, p...)
+	}
+	return out
+}
+
+func fullBox(typ string, version uint8, flags uint32, payloads ...[]byte) []byte {
+	vf := make([]byte, 4)
+	bele.BePutUint32(vf, flags&0xFFFFFF)
+	vf[0] = version
+	return box(typ, append([][]byte{vf}, payloads...)...)
+}
+
+// boxWriter 用于拼接box的payload部分
+type boxWriter struct {
+	b []byte
+}
+
+func (w *boxWriter) u8(v uint8) *boxWriter {
+	w.b = append(w.b, v)
+	return w
+}
+
+func (w *boxWriter) u16(v uint16) *boxWriter {
+	w.b = append(w.b, byte(v>>8), byte(v))
+	return w
+}
+
+func (w *boxWriter) u32(v uint32) *boxWriter {
+	w.b = append(w.b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+	return w
+}
+
+func (w *boxWriter) u64(v uint64) *boxWriter {
+	return w.u32(uint32(v >> 32)).u32(uint32(v))
+}
+
+func (w *boxWriter) zero(n int) *boxWriter {
+	w.b = append(w.b, make([]byte, n)...)
+	return w
+}
+
+func (w *boxWriter) bytes(b []byte) *boxWriter {
+	w.b = append(w.b, b...)
+	return w
+}
+
+// unityMatrix tkhd和mvhd中的单位矩阵
+var unityMatrix = []byte{
+	0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x40, 0x00, 0x00, 0x00,
+}
@@ -0,0 +1,397 @@
+// Copyright 2023, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package fmp4
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"os"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/naza/pkg/bele"
+	"github.com/q191201771/naza/pkg/nazaerrors"
+)
+
+// FileWriter 录制mp4文件
+//
+// 录制过程中写入的是fmp4（init segment + 多个media segment），进程异常退出时文件依然可以播放。
+// 录制结束时调用 FileWriter.Finalize ，将文件转换为moov在文件头部的普通mp4文件（faststart），便于点播时拖动。
+//
+// 为了能在结束时生成moov，内部会在内存中记录所有sample的索引信息（不包含sample数据）
+type FileWriter struct {
+	fp       *os.File
+	filename string
+	offset   int64
+
+	sequenceNumber uint32
+	tracks         []*Track
+	samples        [][]sampleIndex // 与tracks一一对应
+	chunks         []chunkIndex    // 一个media segment中的一个track的所有sample对应一个chunk
+}
+
+type sampleIndex struct {
+	duration uint32
+	size     uint32
+	cto      int32 // pts - dts
+	key      bool
+}
+
+type chunkIndex struct {
+	trackIndex  int
+	sampleCount int
+	offset      int64 // 在fmp4文件中的位置
+	size        int64
+}
+
+func (fw *FileWriter) Create(filename string) (err error) {
+	fw.fp, err = os.Create(filename)
+	fw.filename = filename
+	return
+}
+
+// WriteInitSegment 只能调用一次，并且必须在 WriteMediaSegment 之前调用
+func (fw *FileWriter) WriteInitSegment(tracks []*Track) error {
+	if fw.fp == nil {
+		return base.ErrFileNotExist
+	}
+	if fw.tracks != nil {
+		return nazaerrors.Wrap(base.ErrFmp4)
+	}
+	b, err := PackInitSegment(tracks)
+	if err != nil {
+		return err
+	}
+	if err = fw.write(b); err != nil {
+		return err
+	}
+	fw.tracks = tracks
+	fw.samples = make([][]sampleIndex, len(tracks))
+	return nil
+}
+
+// WriteMediaSegment
+//
+// @param fragments: Track 必须是 WriteInitSegment 传入的 Track ，其他的会被忽略
+func (fw *FileWriter) WriteMediaSegment(fragments []TrackFragment) error {
+	if fw.fp == nil {
+		return base.ErrFileNotExist
+	}
+	if fw.tracks == nil {
+		return nazaerrors.Wrap(base.ErrFmp4)
+	}
+
+	var valid []TrackFragment
+	var dataSize int64
+	for _, f := range fragments {
+		if fw.trackIndex(f.Track) == -1 || len(f.Samples) == 0 {
+			continue
+		}
+		valid = append(valid, f)
+		for _, s := range f.Samples {
+			dataSize += int64(len(s.Data))
+		}
+	}
+	if len(valid) == 0 {
+		return nil
+	}
+
+	fw.sequenceNumber++
+	b, err := PackMediaSegment(fw.sequenceNumber, valid)
+	if err != nil {
+		return err
+	}
+
+	// mdat位于media segment的末尾
+	offset := fw.offset + int64(len(b)) - dataSize
+	if err = fw.write(b); err != nil {
+		return err
+	}
+
+	for _, f := range valid {
+		index := fw.trackIndex(f.Track)
+		chunk := chunkIndex{
+			trackIndex:  index,
+			sampleCount: len(f.Samples),
+			offset:      offset,
+		}
+		for _, s := range f.Samples {
+			fw.samples[index] = append(fw.samples[index], sampleIndex{
+				duration: s.Duration,
+				size:     uint32(len(s.Data)),
+				cto:      int32(int64(s.Pts) - int64(s.Dts)),
+				key:      s.Key,
+			})
+			chunk.size += int64(len(s.Data))
+		}
+		offset += chunk.size
+		fw.chunks = append(fw.chunks, chunk)
+	}
+	return nil
+}
+
+// Duration 已写入数据的时长，单位秒
+func (fw *FileWriter) Duration() float64 {
+	var duration float64
+	for i, t := range fw.tracks {
+		if d := float64(fw.trackDuration(i)) / float64(t.Timescale); d > duration {
+			duration = d
+		}
+	}
+	return duration
+}
+
+// Finalize 结束录制，将fmp4文件转换为moov在前的普通mp4文件
+//
+// 转换时先写入临时文件，完成后再替换原文件，转换失败时原fmp4文件依然保留
+//
+// 注意，文件较大时比较耗时，调用方可以考虑在单独的协程中调用
+func (fw *FileWriter) Finalize() error {
+	if err := fw.Dispose(); err != nil {
+		return err
+	}
+	if len(fw.chunks) == 0 {
+		return nil
+	}
+
+	src, err := os.Open(fw.filename)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmpFilename := fw.filename + ".tmp"
+	dst, err := os.Create(tmpFilename)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if dst != nil {
+			_ = dst.Close()
+			_ = os.Remove(tmpFilename)
+		}
+	}()
+
+	var dataSize int64
+	for _, c := range fw.chunks {
+		dataSize += c.size
+	}
+	largeSize := dataSize+8 > math.MaxUint32
+
+	// ftyp + moov + mdat
+	ftyp := box("ftyp", (&boxWriter{}).
+		bytes([]byte("isom")). // major_brand
+		u32(0x200).            // minor_version
+		bytes([]byte("isomiso2avc1mp41")).b)
+	mdatHeader := make([]byte, 8)
+	if largeSize {
+		mdatHeader = make([]byte, 16)
+		bele.BePutUint32(mdatHeader, 1)
+		bele.BePutUint64(mdatHeader[8:], uint64(dataSize+16))
+	} else {
+		bele.BePutUint32(mdatHeader, uint32(dataSize+8))
+	}
+	copy(mdatHeader[4:], "mdat")
+
+	// chunk offset依赖moov的大小，而moov的大小与offset的值无关，所以先计算一次moov的大小
+	moov := fw.packMoov(0, largeSize)
+	moov = fw.packMoov(int64(len(ftyp)+len(moov)+len(mdatHeader)), largeSize)
+
+	w := bufio.NewWriterSize(dst, 1024*1024)
+	for _, b := range [][]byte{ftyp, moov, mdatHeader} {
+		if _, err = w.Write(b); err != nil {
+			return err
+		}
+	}
+	for _, c := range fw.chunks {
+		if _, err = io.Copy(w, io.NewSectionReader(src, c.offset, c.size)); err != nil {
+			return err
+		}
+	}
+	if err = w.Flush(); err != nil {
+		return err
+	}
+	if err = dst.Close(); err != nil {
+		dst = nil
+		_ = os.Remove(tmpFilename)
+		return err
+	}
+	dst = nil
+	return os.Rename(tmpFilename, fw.filename)
+}
+
+// Dispose 结束录制，不做转换，文件保留为fmp4格式
+func (fw *FileWriter) Dispose() error {
+	if fw.fp == nil {
+		return base.ErrFileNotExist
+	}
+	err := fw.fp.Close()
+	fw.fp = nil
+	return err
+}
+
+func (fw *FileWriter) Name() string {
+	return fw.filename
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func (fw *FileWriter) write(b []byte) error {
+	n, err := fw.fp.Write(b)
+	fw.offset += int64(n)
+	return err
+}
+
+func (fw *FileWriter) trackIndex(t *Track) int {
+	for i := range fw.tracks {
+		if fw.tracks[i] == t {
+			return i
+		}
+	}
+	return -1
+}
+
+func (fw *FileWriter) trackDuration(index int) uint64 {
+	var duration uint64
+	for _, s := range fw.samples[index] {
+		duration += uint64(s.duration)
+	}
+	return duration
+}
+
+// packMoov
+//
+// @param dataOffset: mdat中第一个sample在新文件中的位置
+func (fw *FileWriter) packMoov(dataOffset int64, largeSize bool) []byte {
+	// 每个track的chunk在新文件中的位置
+	chunkOffsets := make([][]int64, len(fw.tracks))
+	chunkSampleCounts := make([][]int, len(fw.tracks))
+	offset := dataOffset
+	for _, c := range fw.chunks {
+		chunkOffsets[c.trackIndex] = append(chunkOffsets[c.trackIndex], offset)
+		chunkSampleCounts[c.trackIndex] = append(chunkSampleCounts[c.trackIndex], c.sampleCount)
+		offset += c.size
+	}
+
+	var (
+		tracks        []*Track
+		traks         [][]byte
+		movieDuration uint32
+	)
+	for i, t := range fw.tracks {
+		if len(fw.samples[i]) == 0 {
+			continue
+		}
+		mediaDuration := fw.trackDuration(i)
+		d := uint32(mediaDuration * movieTimescale / uint64(t.Timescale))
+		if d > movieDuration {
+			movieDuration = d
+		}
+		tables := packSampleTables(t, fw.samples[i], chunkSampleCounts[i], chunkOffsets[i], largeSize)
+		tracks = append(tracks, t)
+		traks = append(traks, packTrak(t, d, uint32(mediaDuration), tables))
+	}
+	return box("moov", append([][]byte{packMvhd(tracks, movieDuration)}, traks...)...)
+}
+
+// packSampleTables 生成stts, ctts, stss, stsc, stsz, stco(co64)
+func packSampleTables(t *Track, samples []sampleIndex, chunkSampleCounts []int, chunkOffsets []int64, largeSize bool) [][]byte {
+	var tables [][]byte
+
+	// stts，时长相同的连续sample合并成一项
+	var stts boxWriter
+	var entryCount uint32
+	for i := 0; i < len(samples); {
+		j := i + 1
+		for j < len(samples) && samples[j].duration == samples[i].duration {
+			j++
+		}
+		stts.u32(uint32(j - i)).u32(samples[i].duration)
+		entryCount++
+		i = j
+	}
+	tables = append(tables, fullBox("stts", 0, 0, (&boxWriter{}).u32(entryCount).bytes(stts.b).b))
+
+	// ctts，只有存在pts与dts不同的sample时才需要
+	hasCto, negativeCto := false, false
+	for _, s := range samples {
+		if s.cto != 0 {
+			hasCto = true
+		}
+		if s.cto < 0 {
+			negativeCto = true
+		}
+	}
+	if hasCto {
+		var ctts boxWriter
+		entryCount = 0
+		for i := 0; i < len(samples); {
+			j := i + 1
+			for j < len(samples) && samples[j].cto == samples[i].cto {
+				j++
+			}
+			ctts.u32(uint32(j - i)).u32(uint32(samples[i].cto))
+			entryCount++
+			i = j
+		}
+		var version uint8
+		if negativeCto {
+			version = 1
+		}
+		tables = append(tables, fullBox("ctts", version, 0, (&boxWriter{}).u32(entryCount).bytes(ctts.b).b))
+	}
+
+	// stss，视频的关键帧列表，序号从1开始
+	if t.IsVideo() {
+		var stss boxWriter
+		entryCount = 0
+		for i, s := range samples {
+			if s.key {
+				stss.u32(uint32(i + 1))
+				entryCount++
+			}
+		}
+		tables = append(tables, fullBox("stss", 0, 0, (&boxWriter{}).u32(entryCount).bytes(stss.b).b))
+	}
+
+	// stsc，sample数量相同的连续chunk合并成一项，chunk序号从1开始
+	var stsc boxWriter
+	entryCount = 0
+	for i := range chunkSampleCounts {
+		if i == 0 || chunkSampleCounts[i] != chunkSampleCounts[i-1] {
+			stsc.u32(uint32(i + 1)).u32(uint32(chunkSampleCounts[i])).u32(1)
+			entryCount++
+		}
+	}
+	tables = append(tables, fullBox("stsc", 0, 0, (&boxWriter{}).u32(entryCount).bytes(stsc.b).b))
+
+	// stsz
+	stsz := (&boxWriter{}).u32(0).u32(uint32(len(samples)))
+	for _, s := range samples {
+		stsz.u32(s.size)
+	}
+	tables = append(tables, fullBox("stsz", 0, 0, stsz.b))
+
+	// stco，文件超过4G时使用co64
+	co := (&boxWriter{}).u32(uint32(len(chunkOffsets)))
+	for _, o := range chunkOffsets {
+		if largeSize {
+			co.u64(uint64(o))
+		} else {
+			co.u32(uint32(o))
+		}
+	}
+	if largeSize {
+		tables = append(tables, fullBox("co64", 0, 0, co.b))
+	} else {
+		tables = append(tables, fullBox("stco", 0, 0, co.b))
+	}
+
+	return tables
+}
@@ -0,0 +1,91 @@
+// Copyright 2023, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package fmp4
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/q191201771/naza/pkg/assert"
+	"github.com/q191201771/naza/pkg/bele"
+)
+
+func TestFileWriter(t *testing.T) {
+	video, _ := NewAvcTrack(1, goldenAvcSeqHeader)
+	audio, _ := NewAacTrack(2, goldenAsc)
+
+	filename := filepath.Join(t.TempDir(), "test.mp4")
+	var fw FileWriter
+	assert.Equal(t, nil, fw.Create(filename))
+	assert.Equal(t, nil, fw.WriteInitSegment([]*Track{video, audio}))
+	assert.IsNotNil(t, fw.WriteInitSegment([]*Track{video, audio}))
+
+	for i := 0; i < 2; i++ {
+		base := uint64(i) * 7200
+		err := fw.WriteMediaSegment([]TrackFragment{
+			{
+				Track: video,
+				Samples: []Sample{
+					{Dts: base, Pts: base + 3600, Duration: 3600, Key: true, Data: []byte{1, 2, 3}},
+					{Dts: base + 3600, Pts: base + 3600, Duration: 3600, Data: []byte{4, 5}},
+				},
+			},
+			{
+				Track: audio,
+				Samples: []Sample{
+					{Dts: uint64(i) * 3528, Pts: uint64(i) * 3528, Duration: 3528, Key: true, Data: []byte{6}},
+				},
+			},
+		})
+		assert.Equal(t, nil, err)
+	}
+	assert.Equal(t, 0.16, fw.Duration())
+
+	// 录制过程中是fmp4
+	b, err := os.ReadFile(filename)
+	assert.Equal(t, nil, err)
+	assert.IsNotNil(t, findBox(b, "moov", "mvex"))
+	assert.IsNotNil(t, findBox(b, "moof"))
+
+	assert.Equal(t, nil, fw.Finalize())
+	_, err = os.Stat(filename + ".tmp")
+	assert.Equal(t, true, os.IsNotExist(err))
+
+	// 结束后是moov在前的普通mp4
+	b, err = os.ReadFile(filename)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "ftyp", string(b[4:8]))
+	ftypSize := int(bele.BeUint32(b))
+	assert.Equal(t, "moov", string(b[ftypSize+4:ftypSize+8]))
+	assert.Equal(t, []byte(nil), findBox(b, "moov", "mvex"))
+	assert.Equal(t, []byte(nil), findBox(b, "moof"))
+	assert.Equal(t, []byte{1, 2, 3, 4, 5, 6, 1, 2, 3, 4, 5, 6}, findBox(b, "mdat"))
+
+	mvhd := findBox(b, "moov", "mvhd")
+	assert.Equal(t, uint32(160), bele.BeUint32(mvhd[16:])) // duration，单位毫秒
+
+	stbl := []string{"moov", "trak", "mdia", "minf", "stbl"}
+	stsz := findBox(b, append(stbl, "stsz")...)
+	assert.Equal(t, uint32(4), bele.BeUint32(stsz[8:]))
+	assert.Equal(t, uint32(3), bele.BeUint32(stsz[12:]))
+	stss := findBox(b, append(stbl, "stss")...)
+	assert.Equal(t, uint32(2), bele.BeUint32(stss[4:]))
+	assert.Equal(t, uint32(3), bele.BeUint32(stss[12:]))
+	ctts := findBox(b, append(stbl, "ctts")...)
+	assert.Equal(t, uint32(4), bele.BeUint32(ctts[4:]))
+
+	// chunk offset指向mdat中对应的数据
+	stco := findBox(b, append(stbl, "stco")...)
+	assert.Equal(t, uint32(2), bele.BeUint32(stco[4:]))
+	assert.Equal(t, byte(1), b[bele.BeUint32(stco[8:])])
+	assert.Equal(t, byte(1), b[bele.BeUint32(stco[12:])])
+
+	assert.IsNotNil(t, fw.Dispose())
+}
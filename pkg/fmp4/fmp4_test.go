@@ -0,0 +1,143 @@
+// Copyright 2023, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package fmp4
+
+import (
+	"testing"
+
+	"github.com/q191201771/naza/pkg/assert"
+	"github.com/q191201771/naza/pkg/bele"
+)
+
+var goldenAvcSeqHeader = []byte{
+	0x17, 0x00, 0x00, 0x00, 0x00,
+	0x01, 0x64, 0x00, 0x1F, 0xFF,
+	0xE1, 0x00, 0x0A,
+	0x27, 0x64, 0x00, 0x1F, 0xAC, 0x56, 0x80, 0xB4, 0x0A, 0x19,
+	0x01, 0x00, 0x04,
+	0x28, 0xEE, 0x3C, 0xB0,
+}
+
+var goldenAsc = []byte{0x12, 0x10} // AAC-LC, 44100, 2 channels
+
+// findBox 按路径查找box，返回box的payload部分（不包含8字节头）
+func findBox(b []byte, path ...string) []byte {
+	for len(b) >= 8 {
+		size := int(bele.BeUint32(b))
+		if size < 8 || size > len(b) {
+			return nil
+		}
+		if string(b[4:8]) == path[0] {
+			if len(path) == 1 {
+				return b[8:size]
+			}
+			payload := b[8:size]
+			// 这几个box的子box前有固定长度的字段
+			switch path[0] {
+			case "stsd":
+				payload = payload[8:]
+			case "avc1":
+				payload = payload[78:]
+			case "mp4a":
+				payload = payload[28:]
+			}
+			return findBox(payload, path[1:]...)
+		}
+		b = b[size:]
+	}
+	return nil
+}
+
+func TestTrack(t *testing.T) {
+	video, err := NewAvcTrack(1, goldenAvcSeqHeader)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "avc1.64001f", video.Codec)
+	assert.Equal(t, uint32(720), video.Width)
+	assert.Equal(t, uint32(1280), video.Height)
+	assert.Equal(t, true, video.IsVideo())
+
+	audio, err := NewAacTrack(2, goldenAsc)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "mp4a.40.2", audio.Codec)
+	assert.Equal(t, uint32(44100), audio.Timescale)
+	assert.Equal(t, 2, audio.Channels)
+	assert.Equal(t, uint32(1024), audio.SampleDuration())
+
+	assert.Equal(t, "avc1.64001f,mp4a.40.2", Codecs([]*Track{video, audio}))
+
+	_, err = NewAvcTrack(1, goldenAvcSeqHeader[:8])
+	assert.IsNotNil(t, err)
+
+	// Main profile, level 3.1, 示例来自ISO_IEC_14496-15 E.3
+	hvcc := []byte{0x01, 0x01, 0x60, 0x00, 0x00, 0x00, 0x90, 0x00, 0x00, 0x00, 0x00, 0x00, 0x5D}
+	assert.Equal(t, "hvc1.1.6.L93.90", hevcCodecString(hvcc))
+	hvcc[1] = 0x22 // high tier, Main10
+	hvcc[2] = 0x20
+	assert.Equal(t, "hvc1.2.4.H93.90", hevcCodecString(hvcc))
+}
+
+func TestInitSegment(t *testing.T) {
+	video, _ := NewAvcTrack(1, goldenAvcSeqHeader)
+	audio, _ := NewAacTrack(2, goldenAsc)
+	b, err := PackInitSegment([]*Track{video, audio})
+	assert.Equal(t, nil, err)
+
+	assert.Equal(t, "iso6", string(findBox(b, "ftyp")[:4]))
+	mvhd := findBox(b, "moov", "mvhd")
+	assert.Equal(t, uint32(3), bele.BeUint32(mvhd[len(mvhd)-4:])) // next_track_ID
+
+	avcc := findBox(b, "moov", "trak", "mdia", "minf", "stbl", "stsd", "avc1", "avcC")
+	assert.Equal(t, goldenAvcSeqHeader[5:], avcc)
+
+	trex := findBox(b, "moov", "mvex", "trex")
+	assert.Equal(t, uint32(1), bele.BeUint32(trex[4:]))
+
+	_, err = PackInitSegment(nil)
+	assert.IsNotNil(t, err)
+}
+
+func TestMediaSegment(t *testing.T) {
+	video, _ := NewAvcTrack(1, goldenAvcSeqHeader)
+	audio, _ := NewAacTrack(2, goldenAsc)
+
+	b, err := PackMediaSegment(7, []TrackFragment{
+		{
+			Track: video,
+			Samples: []Sample{
+				{Dts: 9000, Pts: 12600, Duration: 3600, Key: true, Data: []byte{1, 2, 3}},
+				{Dts: 12600, Pts: 12600, Duration: 3600, Data: []byte{4, 5}},
+			},
+		},
+		{
+			Track: audio,
+			Samples: []Sample{
+				{Dts: 4410, Pts: 4410, Duration: 1024, Data: []byte{6}},
+			},
+		},
+	})
+	assert.Equal(t, nil, err)
+
+	assert.Equal(t, "styp", string(b[4:8]))
+	mfhd := findBox(b, "moof", "mfhd")
+	assert.Equal(t, uint32(7), bele.BeUint32(mfhd[4:]))
+	tfdt := findBox(b, "moof", "traf", "tfdt")
+	assert.Equal(t, uint64(9000), bele.BeUint64(tfdt[4:]))
+
+	mdat := findBox(b, "mdat")
+	assert.Equal(t, []byte{1, 2, 3, 4, 5, 6}, mdat)
+
+	// data_offset相对于moof的起始位置，应该正好指向mdat中该track的第一个sample
+	moofStart := int(bele.BeUint32(b)) // styp的大小
+	trun := findBox(b, "moof", "traf", "trun")
+	assert.Equal(t, uint32(2), bele.BeUint32(trun[4:]))
+	dataOffset := int(bele.BeUint32(trun[8:]))
+	assert.Equal(t, byte(1), b[moofStart+dataOffset])
+	// 第一个sample的cts
+	assert.Equal(t, uint32(3600), bele.BeUint32(trun[12+12:]))
+}
@@ -0,0 +1,323 @@
+// Copyright 2023, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package fmp4
+
+import (
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/naza/pkg/bele"
+)
+
+// 参考：
+// ISO_IEC_14496-12 ISO base media file format
+// ISO_IEC_23000-19 Common media application format (CMAF)
+//
+// init segment: ftyp + moov
+// media segment: styp + moof + mdat
+
+const (
+	movieTimescale = 1000
+
+	sampleFlagsKey    = 0x02000000 // sample_depends_on=2，不依赖其他帧
+	sampleFlagsNonKey = 0x01010000 // sample_depends_on=1, sample_is_non_sync_sample=1
+
+	trunFlagDataOffset            = 0x000001
+	trunFlagSampleDuration        = 0x000100
+	trunFlagSampleSize            = 0x000200
+	trunFlagSampleFlags           = 0x000400
+	trunFlagSampleCompositionTime = 0x000800
+
+	tfhdFlagDefaultBaseIsMoof = 0x020000
+)
+
+// Sample 时间戳的单位为对应 Track 的Timescale
+type Sample struct {
+	Dts      uint64
+	Pts      uint64
+	Duration uint32
+	Key      bool
+	Data     []byte // 视频为AVCC格式，音频为去掉adts头的裸数据
+}
+
+// TrackFragment 一个media segment中某一路track的所有sample
+type TrackFragment struct {
+	Track   *Track
+	Samples []Sample
+}
+
+// PackInitSegment 生成init segment，也即m3u8中EXT-X-MAP指向的文件
+func PackInitSegment(tracks []*Track) ([]byte, error) {
+	if err := checkTracks(tracks); err != nil {
+		return nil, err
+	}
+
+	// fmp4的sample信息都在moof中，所以moov中的sample table都为空
+	emptySampleTables := [][]byte{
+		fullBox("stts", 0, 0, make([]byte, 4)),
+		fullBox("stsc", 0, 0, make([]byte, 4)),
+		fullBox("stsz", 0, 0, make([]byte, 8)),
+		fullBox("stco", 0, 0, make([]byte, 4)),
+	}
+
+	traks := make([][]byte, 0, len(tracks))
+	trexs := make([][]byte, 0, len(tracks))
+	for _, t := range tracks {
+		traks = append(traks, packTrak(t, 0, 0, emptySampleTables))
+		trexs = append(trexs, fullBox("trex", 0, 0, (&boxWriter{}).
+			u32(t.Id). // track_ID
+			u32(1).    // default_sample_description_index
+			u32(0).    // default_sample_duration
+			u32(0).    // default_sample_size
+			u32(0).b)) // default_sample_flags
+	}
+
+	moov := box("moov", append(append([][]byte{packMvhd(tracks, 0)}, traks...), box("mvex", trexs...))...)
+
+	out := packFtyp("ftyp")
+	out = append(out, moov...)
+	return out, nil
+}
+
+// PackMediaSegment 生成media segment，也即m3u8中的.m4s文件
+//
+// @param sequenceNumber: mfhd中的序号，从1开始递增
+//
+// 所有track的sample按顺序依次写入同一个mdat中
+func PackMediaSegment(sequenceNumber uint32, fragments []TrackFragment) ([]byte, error) {
+	for _, f := range fragments {
+		if err := checkTracks([]*Track{f.Track}); err != nil {
+			return nil, err
+		}
+	}
+
+	// trun中的data_offset依赖moof的大小，所以先用0占位计算出moof大小，再生成一遍
+	dataOffsets := make([]uint32, len(fragments))
+	moof := packMoof(sequenceNumber, fragments, dataOffsets)
+	offset := uint32(len(moof)) + 8
+	var mdatSize int
+	for i, f := range fragments {
+		dataOffsets[i] = offset
+		for _, s := range f.Samples {
+			offset += uint32(len(s.Data))
+			mdatSize += len(s.Data)
+		}
+	}
+	moof = packMoof(sequenceNumber, fragments, dataOffsets)
+
+	out := packFtyp("styp")
+	out = append(out, moof...)
+	mdatHeader := make([]byte, 8)
+	bele.BePutUint32(mdatHeader, uint32(8+mdatSize))
+	copy(mdatHeader[4:], "mdat")
+	out = append(out, mdatHeader...)
+	for _, f := range fragments {
+		for _, s := range f.Samples {
+			out = append(out, s.Data...)
+		}
+	}
+	return out, nil
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func packFtyp(typ string) []byte {
+	return box(typ, (&boxWriter{}).
+		bytes([]byte("iso6")). // major_brand
+		u32(0).                // minor_version
+		bytes([]byte("iso6cmfcmp41")).b)
+}
+
+// packMvhd
+//
+// @param duration: 单位为 movieTimescale
+func packMvhd(tracks []*Track, duration uint32) []byte {
+	var nextTrackId uint32
+	for _, t := range tracks {
+		if t.Id >= nextTrackId {
+			nextTrackId = t.Id + 1
+		}
+	}
+	return fullBox("mvhd", 0, 0, (&boxWriter{}).
+		u32(0).              // creation_time
+		u32(0).              // modification_time
+		u32(movieTimescale). // timescale
+		u32(duration).
+		u32(0x00010000). // rate
+		u16(0x0100).     // volume
+		zero(10).        // reserved
+		bytes(unityMatrix).
+		zero(24).           // pre_defined
+		u32(nextTrackId).b) // next_track_ID
+}
+
+// packTrak
+//
+// @param movieDuration: tkhd中的时长，单位为 movieTimescale
+//
+// @param mediaDuration: mdhd中的时长，单位为 Track.Timescale
+//
+// @param sampleTables: stbl中除stsd以外的box
+func packTrak(t *Track, movieDuration uint32, mediaDuration uint32, sampleTables [][]byte) []byte {
+	var volume uint16
+	var width, height uint32
+	if t.IsVideo() {
+		width, height = t.Width, t.Height
+	} else {
+		volume = 0x0100
+	}
+
+	// flags: track_enabled | track_in_movie
+	tkhd := fullBox("tkhd", 0, 0x000003, (&boxWriter{}).
+		u32(0).    // creation_time
+		u32(0).    // modification_time
+		u32(t.Id). // track_ID
+		u32(0).    // reserved
+		u32(movieDuration).
+		zero(8). // reserved
+		u16(0).  // layer
+		u16(0).  // alternate_group
+		u16(volume).
+		u16(0). // reserved
+		bytes(unityMatrix).
+		u32(width<<16).
+		u32(height<<16).b)
+
+	mdhd := fullBox("mdhd", 0, 0, (&boxWriter{}).
+		u32(0). // creation_time
+		u32(0). // modification_time
+		u32(t.Timescale).
+		u32(mediaDuration).
+		u16(0x55C4). // language: und
+		u16(0).b)    // pre_defined
+
+	handlerType, handlerName, mhd := "soun", "SoundHandler", fullBox("smhd", 0, 0, make([]byte, 4))
+	if t.IsVideo() {
+		handlerType, handlerName, mhd = "vide", "VideoHandler", fullBox("vmhd", 0, 1, make([]byte, 8))
+	}
+	hdlr := fullBox("hdlr", 0, 0, (&boxWriter{}).
+		u32(0). // pre_defined
+		bytes([]byte(handlerType)).
+		zero(12). // reserved
+		bytes([]byte(handlerName)).
+		u8(0).b)
+
+	dinf := box("dinf", fullBox("dref", 0, 0, (&boxWriter{}).u32(1).b, fullBox("url ", 0, 1)))
+
+	stsd := fullBox("stsd", 0, 0, (&boxWriter{}).u32(1).b, packSampleEntry(t))
+	stbl := box("stbl", append([][]byte{stsd}, sampleTables...)...)
+
+	return box("trak", tkhd, box("mdia", mdhd, hdlr, box("minf", mhd, dinf, stbl)))
+}
+
+func packSampleEntry(t *Track) []byte {
+	switch t.PayloadType {
+	case base.AvPacketPtAvc, base.AvPacketPtHevc:
+		typ, configType := "avc1", "avcC"
+		if t.PayloadType == base.AvPacketPtHevc {
+			typ, configType = "hvc1", "hvcC"
+		}
+		return box(typ, (&boxWriter{}).
+			zero(6).  // reserved
+			u16(1).   // data_reference_index
+			zero(16). // pre_defined, reserved
+			u16(uint16(t.Width)).
+			u16(uint16(t.Height)).
+			u32(0x00480000). // horizresolution 72dpi
+			u32(0x00480000). // vertresolution 72dpi
+			u32(0).          // reserved
+			u16(1).          // frame_count
+			zero(32).        // compressorname
+			u16(0x0018).     // depth
+			u16(0xFFFF).b,   // pre_defined
+			box(configType, t.Config))
+	}
+
+	// AAC
+	return box("mp4a", (&boxWriter{}).
+		zero(6). // reserved
+		u16(1).  // data_reference_index
+		zero(8). // reserved
+		u16(uint16(t.Channels)).
+		u16(16). // samplesize
+		u32(0).  // pre_defined, reserved
+		u32(uint32(t.SampleRate)<<16).b,
+		packEsds(t))
+}
+
+// packEsds
+//
+// ISO_IEC_14496-1 7.2.6 Object Descriptor Components
+func packEsds(t *Track) []byte {
+	decSpecificInfo := packDescriptor(0x05, t.Config)
+	decoderConfig := packDescriptor(0x04, (&boxWriter{}).
+		u8(0x40). // objectTypeIndication: Audio ISO/IEC 14496-3
+		u8(0x15). // streamType: AudioStream, upStream=0, reserved=1
+		zero(3).  // bufferSizeDB
+		u32(0).   // maxBitrate
+		u32(0).   // avgBitrate
+		bytes(decSpecificInfo).b)
+	slConfig := packDescriptor(0x06, []byte{0x02})
+	esDescriptor := packDescriptor(0x03, (&boxWriter{}).
+		u16(uint16(t.Id)). // ES_ID
+		u8(0).             // flags
+		bytes(decoderConfig).
+		bytes(slConfig).b)
+	return fullBox("esds", 0, 0, esDescriptor)
+}
+
+// packDescriptor 长度字段固定使用4字节的扩展格式
+func packDescriptor(tag uint8, payload []byte) []byte {
+	l := len(payload)
+	return (&boxWriter{}).
+		u8(tag).
+		u8(0x80 | uint8(l>>21&0x7F)).
+		u8(0x80 | uint8(l>>14&0x7F)).
+		u8(0x80 | uint8(l>>7&0x7F)).
+		u8(uint8(l & 0x7F)).
+		bytes(payload).b
+}
+
+func packMoof(sequenceNumber uint32, fragments []TrackFragment, dataOffsets []uint32) []byte {
+	mfhd := fullBox("mfhd", 0, 0, (&boxWriter{}).u32(sequenceNumber).b)
+	payloads := [][]byte{mfhd}
+	for i, f := range fragments {
+		payloads = append(payloads, packTraf(f, dataOffsets[i]))
+	}
+	return box("moof", payloads...)
+}
+
+func packTraf(f TrackFragment, dataOffset uint32) []byte {
+	tfhd := fullBox("tfhd", 0, tfhdFlagDefaultBaseIsMoof, (&boxWriter{}).u32(f.Track.Id).b)
+
+	var baseMediaDecodeTime uint64
+	if len(f.Samples) > 0 {
+		baseMediaDecodeTime = f.Samples[0].Dts
+	}
+	tfdt := fullBox("tfdt", 1, 0, (&boxWriter{}).u64(baseMediaDecodeTime).b)
+
+	flags := uint32(trunFlagDataOffset | trunFlagSampleDuration | trunFlagSampleSize)
+	if f.Track.IsVideo() {
+		flags |= trunFlagSampleFlags | trunFlagSampleCompositionTime
+	}
+	w := (&boxWriter{}).u32(uint32(len(f.Samples))).u32(dataOffset)
+	for _, s := range f.Samples {
+		w.u32(s.Duration).u32(uint32(len(s.Data)))
+		if f.Track.IsVideo() {
+			if s.Key {
+				w.u32(sampleFlagsKey)
+			} else {
+				w.u32(sampleFlagsNonKey)
+			}
+			// version 1，composition time offset为有符号数
+			w.u32(uint32(int32(int64(s.Pts) - int64(s.Dts))))
+		}
+	}
+	trun := fullBox("trun", 1, flags, w.b)
+
+	return box("traf", tfhd, tfdt, trun)
+}
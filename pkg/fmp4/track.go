@@ -0,0 +1,195 @@
+// Copyright 2023, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package fmp4
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/q191201771/lal/pkg/aac"
+	"github.com/q191201771/lal/pkg/avc"
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/hevc"
+	"github.com/q191201771/naza/pkg/bele"
+	"github.com/q191201771/naza/pkg/nazaerrors"
+)
+
+const (
+	VideoTimescale = 90000
+
+	aacSamplesPerFrame = 1024
+)
+
+// Track 描述fmp4中的一路音频或视频
+type Track struct {
+	Id          uint32
+	PayloadType base.AvPacketPt
+	Timescale   uint32
+
+	// 视频
+	Width  uint32
+	Height uint32
+
+	// 音频
+	SampleRate int
+	Channels   int
+
+	// Config avcC或hvcC的内容，或者AAC的AudioSpecificConfig
+	Config []byte
+
+	// Codec rfc6381格式的codecs字符串，比如 avc1.64001f, hvc1.1.6.L93.B0, mp4a.40.2
+	Codec string
+}
+
+// NewAvcTrack
+//
+// @param seqHeader: rtmp message或flv tag的payload，注意，包含了头部2字节类型以及3字节的cts
+func NewAvcTrack(id uint32, seqHeader []byte) (*Track, error) {
+	sps, _, err := avc.ParseSpsPpsFromSeqHeader(seqHeader)
+	if err != nil {
+		return nil, err
+	}
+	t := &Track{
+		Id:          id,
+		PayloadType: base.AvPacketPtAvc,
+		Timescale:   VideoTimescale,
+		Config:      append([]byte(nil), seqHeader[5:]...),
+	}
+	var ctx avc.Context
+	if err = avc.ParseSps(sps, &ctx); err != nil {
+		Log.Warnf("parse sps failed. err=%+v", err)
+	}
+	t.Width, t.Height = ctx.Width, ctx.Height
+
+	// AVCDecoderConfigurationRecord: configurationVersion, AVCProfileIndication, profile_compatibility, AVCLevelIndication
+	t.Codec = fmt.Sprintf("avc1.%02x%02x%02x", t.Config[1], t.Config[2], t.Config[3])
+	return t, nil
+}
+
+// NewHevcTrack
+//
+// @param seqHeader: rtmp message或flv tag的payload，注意，包含了头部2字节类型以及3字节的cts
+func NewHevcTrack(id uint32, seqHeader []byte) (*Track, error) {
+	_, sps, _, err := hevc.ParseVpsSpsPpsFromSeqHeader(seqHeader)
+	if err != nil {
+		return nil, err
+	}
+	t := &Track{
+		Id:          id,
+		PayloadType: base.AvPacketPtHevc,
+		Timescale:   VideoTimescale,
+		Config:      append([]byte(nil), seqHeader[5:]...),
+	}
+	var ctx hevc.Context
+	if err = hevc.ParseSps(sps, &ctx); err != nil {
+		Log.Warnf("parse sps failed. err=%+v", err)
+	}
+	t.Width, t.Height = ctx.PicWidthInLumaSamples, ctx.PicHeightInLumaSamples
+	t.Codec = hevcCodecString(t.Config)
+	return t, nil
+}
+
+// NewAacTrack
+//
+// @param asc: AudioSpecificConfig，也即rtmp aac seq header去掉头部2字节的部分
+func NewAacTrack(id uint32, asc []byte) (*Track, error) {
+	ascCtx, err := aac.NewAscContext(asc)
+	if err != nil {
+		return nil, err
+	}
+	sampleRate, err := ascCtx.GetSamplingFrequency()
+	if err != nil {
+		return nil, err
+	}
+	return &Track{
+		Id:          id,
+		PayloadType: base.AvPacketPtAac,
+		Timescale:   uint32(sampleRate),
+		SampleRate:  sampleRate,
+		Channels:    int(ascCtx.ChannelConfiguration),
+		Config:      append([]byte(nil), asc...),
+		Codec:       fmt.Sprintf("mp4a.40.%d", ascCtx.AudioObjectType),
+	}, nil
+}
+
+func (t *Track) IsVideo() bool {
+	return t.PayloadType == base.AvPacketPtAvc || t.PayloadType == base.AvPacketPtHevc
+}
+
+// SampleDuration AAC每帧固定1024个采样点，视频没有固定值，返回0
+func (t *Track) SampleDuration() uint32 {
+	if t.PayloadType == base.AvPacketPtAac {
+		return aacSamplesPerFrame
+	}
+	return 0
+}
+
+// Codecs 将多个track的codecs字符串拼接起来，用于m3u8的CODECS属性或mpd的codecs属性
+func Codecs(tracks []*Track) string {
+	var codecs []string
+	for _, t := range tracks {
+		codecs = append(codecs, t.Codec)
+	}
+	return strings.Join(codecs, ",")
+}
+
+// hevcCodecString
+//
+// ISO_IEC_14496-15 E.3 Codecs parameter for HEVC
+//
+// hvc1.[profile_space][profile_idc].[compatibility_flags逆序].[tier][level_idc].[constraint_flags]
+func hevcCodecString(hvcc []byte) string {
+	if len(hvcc) < 13 {
+		return "hvc1"
+	}
+
+	var sb strings.Builder
+	sb.WriteString("hvc1.")
+	if space := hvcc[1] >> 6; space > 0 {
+		sb.WriteByte('A' + space - 1)
+	}
+	sb.WriteString(fmt.Sprintf("%d.", hvcc[1]&0x1F))
+
+	compat := bele.BeUint32(hvcc[2:])
+	var reversed uint32
+	for i := 0; i < 32; i++ {
+		reversed = reversed<<1 | (compat>>i)&1
+	}
+	sb.WriteString(fmt.Sprintf("%X.", reversed))
+
+	if hvcc[1]&0x20 != 0 {
+		sb.WriteByte('H')
+	} else {
+		sb.WriteByte('L')
+	}
+	sb.WriteString(fmt.Sprintf("%d", hvcc[12]))
+
+	// constraint_flags为6个字节，末尾为0的字节省略
+	constraint := hvcc[6:12]
+	n := len(constraint)
+	for n > 0 && constraint[n-1] == 0 {
+		n--
+	}
+	for i := 0; i < n; i++ {
+		sb.WriteString(fmt.Sprintf(".%X", constraint[i]))
+	}
+	return sb.String()
+}
+
+func checkTracks(tracks []*Track) error {
+	if len(tracks) == 0 {
+		return nazaerrors.Wrap(base.ErrFmp4)
+	}
+	for _, t := range tracks {
+		if t == nil || t.Timescale == 0 || len(t.Config) == 0 {
+			return nazaerrors.Wrap(base.ErrFmp4)
+		}
+	}
+	return nil
+}
@@ -59,6 +59,10 @@ func NewPsUnpacker() *PsUnpacker {
 		preVideoRtpts: -1,
 		preAudioRtpts: -1,
 		waitSpsFlag:   true,
+
+		// 注意，未知类型需要显式初始化为 base.AvPacketPtUnknown
+		audioPayloadType: base.AvPacketPtUnknown,
+		videoPayloadType: base.AvPacketPtUnknown,
 	}
 	p.list.InitMaxSize(maxUnpackRtpListSize)
 
@@ -321,6 +325,10 @@ func (p *PsUnpacker) parsePsm(rb []byte, index int) int {
 			switch p.audioStreamType {
 			case StreamTypeAAC:
 				p.audioPayloadType = base.AvPacketPtAac
+			case StreamTypeG711A:
+				p.audioPayloadType = base.AvPacketPtG711A
+			case StreamTypeG711U:
+				p.audioPayloadType = base.AvPacketPtG711U
 			default:
 				p.audioPayloadType = base.AvPacketPtUnknown
 			}
@@ -375,7 +383,7 @@ func (p *PsUnpacker) parseAvStream(code int, rtpts uint32, rb []byte, index int)
 
 	if code == psPackStartCodeAudioStream {
 		// 注意，处理音频的逻辑和处理视频的类似，参考处理视频的注释
-		if p.audioStreamType == StreamTypeAAC {
+		if p.audioPayloadType != base.AvPacketPtUnknown {
 			//nazalog.Debugf("audio code=%d, length=%d, ptsDtsFlag=%d, phdl=%d, pts=%d, dts=%d,type=%d", code, length, ptsDtsFlag, phdl, pts, dts, p.audioStreamType)
 			if pts == -1 {
 				if p.preAudioPts == -1 {
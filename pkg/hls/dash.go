@@ -0,0 +1,128 @@
+// Copyright 2023, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package hls
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/q191201771/lal/pkg/fmp4"
+)
+
+// DASH(ISO_IEC_23009-1)
+//
+// fmp4模式下可以同时生成mpd文件，与m3u8共用init segment和m4s分片文件
+//
+// 使用SegmentList+SegmentTimeline的方式描述分片，音视频复用在同一个Representation中，
+// 时间戳的单位与视频track相同，为90000
+//
+// 注意，mpd中只包含使用当前init segment的分片，也即流的编码参数发生变化后，之前的分片不再出现在mpd中
+
+const dashTimescale = fmp4.VideoTimescale
+
+func (m *Muxer) writeMpd(isLast bool) {
+	ctx := m.fmp4
+
+	var frags []*fragmentInfo
+	m.iterateFragsInPlaylist(func(frag *fragmentInfo) {
+		if frag.initFilename == ctx.initFilename {
+			frags = append(frags, frag)
+		}
+	})
+	if len(frags) == 0 {
+		return
+	}
+
+	var (
+		tracks      []*fmp4.Track
+		maxDuration float64
+		sumDuration float64
+		bandwidth   int
+	)
+	if ctx.videoTrack != nil {
+		tracks = append(tracks, ctx.videoTrack)
+	}
+	if ctx.audioTrack != nil {
+		tracks = append(tracks, ctx.audioTrack)
+	}
+	for _, frag := range frags {
+		sumDuration += frag.duration
+		if frag.duration > maxDuration {
+			maxDuration = frag.duration
+		}
+		if frag.duration > 0 {
+			if bw := int(float64(frag.size*8) / frag.duration); bw > bandwidth {
+				bandwidth = bw
+			}
+		}
+	}
+	fragDuration := float64(m.config.FragmentDurationMs) / 1000
+	if maxDuration < fragDuration {
+		maxDuration = fragDuration
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("<?xml version=\"1.0\" encoding=\"utf-8\"?>\n")
+	if isLast {
+		buf.WriteString(fmt.Sprintf("<MPD xmlns=\"urn:mpeg:dash:schema:mpd:2011\" profiles=\"urn:mpeg:dash:profile:isoff-live:2011\" "+
+			"type=\"static\" mediaPresentationDuration=\"PT%.3fS\" minBufferTime=\"PT%.3fS\">\n",
+			sumDuration, maxDuration))
+	} else {
+		buf.WriteString(fmt.Sprintf("<MPD xmlns=\"urn:mpeg:dash:schema:mpd:2011\" profiles=\"urn:mpeg:dash:profile:isoff-live:2011\" "+
+			"type=\"dynamic\" availabilityStartTime=\"%s\" publishTime=\"%s\" minimumUpdatePeriod=\"PT%.3fS\" "+
+			"minBufferTime=\"PT%.3fS\" timeShiftBufferDepth=\"PT%.3fS\" suggestedPresentationDelay=\"PT%.3fS\">\n",
+			formatMpdTime(ctx.availabilityStartTime), formatMpdTime(Clock.Now()), fragDuration,
+			maxDuration, sumDuration, maxDuration*2))
+	}
+	buf.WriteString("  <Period id=\"0\" start=\"PT0S\">\n")
+
+	mimeType := "audio/mp4"
+	if ctx.videoTrack != nil {
+		mimeType = "video/mp4"
+	}
+	buf.WriteString(fmt.Sprintf("    <AdaptationSet id=\"0\" mimeType=\"%s\" segmentAlignment=\"true\" startWithSAP=\"1\">\n", mimeType))
+	buf.WriteString(fmt.Sprintf("      <Representation id=\"0\" codecs=\"%s\" bandwidth=\"%d\"", fmp4.Codecs(tracks), bandwidth))
+	if ctx.videoTrack != nil && ctx.videoTrack.Width > 0 && ctx.videoTrack.Height > 0 {
+		buf.WriteString(fmt.Sprintf(" width=\"%d\" height=\"%d\"", ctx.videoTrack.Width, ctx.videoTrack.Height))
+	}
+	if ctx.audioTrack != nil {
+		buf.WriteString(fmt.Sprintf(" audioSamplingRate=\"%d\"", ctx.audioTrack.SampleRate))
+	}
+	buf.WriteString(">\n")
+
+	// 点播时，时间轴从第一个分片开始
+	if isLast {
+		buf.WriteString(fmt.Sprintf("        <SegmentList timescale=\"%d\" presentationTimeOffset=\"%d\">\n", dashTimescale, frags[0].startTs))
+	} else {
+		buf.WriteString(fmt.Sprintf("        <SegmentList timescale=\"%d\">\n", dashTimescale))
+	}
+	buf.WriteString(fmt.Sprintf("          <Initialization sourceURL=\"%s\"/>\n", ctx.initFilename))
+	buf.WriteString("          <SegmentTimeline>\n")
+	for _, frag := range frags {
+		buf.WriteString(fmt.Sprintf("            <S t=\"%d\" d=\"%d\"/>\n", frag.startTs, uint64(frag.duration*dashTimescale)))
+	}
+	buf.WriteString("          </SegmentTimeline>\n")
+	for _, frag := range frags {
+		buf.WriteString(fmt.Sprintf("          <SegmentURL media=\"%s\"/>\n", frag.filename))
+	}
+	buf.WriteString("        </SegmentList>\n")
+	buf.WriteString("      </Representation>\n")
+	buf.WriteString("    </AdaptationSet>\n")
+	buf.WriteString("  </Period>\n")
+	buf.WriteString("</MPD>\n")
+
+	if err := writeM3u8File(buf.Bytes(), m.mpdFilename, m.mpdFilename+".bak"); err != nil {
+		Log.Errorf("[%s] write mpd file error. err=%+v", m.UniqueKey, err)
+	}
+}
+
+func formatMpdTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000Z")
+}
@@ -0,0 +1,297 @@
+// Copyright 2023, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package hls
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/naza/pkg/nazaerrors"
+)
+
+// DVR(时移)
+//
+// 开启 MuxerConfig.DvrWindowSec 后，Muxer除了直播m3u8，还会维护一个dvr.m3u8，包含最近DvrWindowSec秒内的所有分片，
+// 并且每个分片前都携带`#EXT-X-PROGRAM-DATE-TIME`，记录分片开始时的墙上时间。
+//
+// 播放端可以通过以下方式请求时移播放列表，ServerHandler 会根据dvr.m3u8筛选出对应时间范围内的分片：
+//
+// /hls/test110/playlist.m3u8?start=1690000000&end=1690000600 -> VOD类型的m3u8，end为空时为EVENT类型
+// /hls/test110/playlist.m3u8?offset=-600s                    -> 302跳转至 ?start=<当前时间-600秒>，保证EVENT类型列表刷新时起始位置不变
+//
+// 除CleanupModeNever外，分片文件在移出DVR窗口后被删除（CleanupModeAsap模式下也不会提前删除）。
+// 流结束后，lalserver在DVR窗口时长之后才清理整个目录，使得结束后的流依然可以回看。
+
+const dvrProgramDateTimeLayout = "2006-01-02T15:04:05.000Z07:00"
+
+type dvrFragment struct {
+	id              int // 对应 fragmentInfo.id，从历史dvr.m3u8中恢复的分片为-1
+	seq             int // dvr.m3u8中的序号，Muxer重建后依然连续
+	duration        float64
+	discont         bool
+	filename        string
+	initFilename    string
+	programDateTime time.Time
+}
+
+func (f *dvrFragment) endTime() time.Time {
+	return f.programDateTime.Add(time.Duration(f.duration * float64(time.Second)))
+}
+
+func (m *Muxer) isDvr() bool {
+	return m.config.DvrWindowSec > 0
+}
+
+// loadDvrPlaylist 从上一次推流遗留的dvr.m3u8中恢复分片列表，使得流重连后时移窗口依然连续
+func (m *Muxer) loadDvrPlaylist() {
+	content, err := fslCtx.ReadFile(m.dvrPlaylistFilename)
+	if err != nil {
+		return
+	}
+	frags, _, err := parseDvrPlaylist(content)
+	if err != nil {
+		Log.Warnf("[%s] parse dvr m3u8 failed. filename=%s, err=%+v", m.UniqueKey, m.dvrPlaylistFilename, err)
+		return
+	}
+	for i := range frags {
+		frags[i].id = -1
+	}
+	m.dvrFrags = frags
+	if len(frags) > 0 {
+		m.dvrSeq = frags[len(frags)-1].seq + 1
+	}
+}
+
+// updateDvr 将刚关闭的分片加入dvr列表，淘汰窗口外的分片，并更新dvr.m3u8，incrFrag()后调用
+func (m *Muxer) updateDvr(isLast bool) {
+	currFrag := m.getClosedFrag()
+	m.dvrFrags = append(m.dvrFrags, dvrFragment{
+		id:       currFrag.id,
+		seq:      m.dvrSeq,
+		duration: currFrag.duration,
+		// 与上一次推流的分片之间总是不连续的
+		discont:         currFrag.discont || (len(m.dvrFrags) > 0 && m.dvrFrags[len(m.dvrFrags)-1].id == -1),
+		filename:        currFrag.filename,
+		initFilename:    currFrag.initFilename,
+		programDateTime: currFrag.programDateTime,
+	})
+	m.dvrSeq++
+
+	// 注意，还在直播列表（包括DeleteThreshold）中的分片不淘汰
+	deadline := Clock.Now().Add(-time.Duration(m.config.DvrWindowSec) * time.Second)
+	n := 0
+	for ; n < len(m.dvrFrags); n++ {
+		frag := &m.dvrFrags[n]
+		if !frag.endTime().Before(deadline) || (frag.id >= 0 && frag.id+m.config.DeleteThreshold >= m.extXMediaSeq()) {
+			break
+		}
+		if m.config.CleanupMode != CleanupModeNever {
+			filenameWithPath := PathStrategy.GetTsFileNameWithPath(m.outPath, frag.filename)
+			if err := fslCtx.Remove(filenameWithPath); err != nil {
+				Log.Warnf("[%s] remove dvr expired fragment file failed. filename=%s, err=%+v", m.UniqueKey, filenameWithPath, err)
+			}
+		}
+	}
+	m.dvrFrags = m.dvrFrags[n:]
+
+	content := makeDvrPlaylist(m.dvrFrags, isLast)
+	if err := writeM3u8File(content, m.dvrPlaylistFilename, m.dvrPlaylistFilenameBak); err != nil {
+		Log.Errorf("[%s] write dvr m3u8 file error. err=%+v", m.UniqueKey, err)
+	}
+}
+
+// makeDvrPlaylist
+//
+// @param isEnd 是否在末尾写入`#EXT-X-ENDLIST`
+func makeDvrPlaylist(frags []dvrFragment, isEnd bool) []byte {
+	return makeDvrPlaylistWithType(frags, "", isEnd)
+}
+
+// makeDvrPlaylistWithType
+//
+// @param playlistType `#EXT-X-PLAYLIST-TYPE`的值，为空时不写
+func makeDvrPlaylistWithType(frags []dvrFragment, playlistType string, isEnd bool) []byte {
+	version := 3
+	maxFrag := float64(0)
+	seq := 0
+	for i := range frags {
+		if frags[i].initFilename != "" {
+			version = fmp4PlaylistVersion
+		}
+		if frags[i].duration > maxFrag {
+			maxFrag = frags[i].duration + 0.5
+		}
+	}
+	if len(frags) > 0 {
+		seq = frags[0].seq
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("#EXTM3U\n")
+	buf.WriteString(fmt.Sprintf("#EXT-X-VERSION:%d\n", version))
+	if playlistType != "" {
+		buf.WriteString(fmt.Sprintf("#EXT-X-PLAYLIST-TYPE:%s\n", playlistType))
+	}
+	buf.WriteString(fmt.Sprintf("#EXT-X-TARGETDURATION:%d\n", int(maxFrag)))
+	buf.WriteString(fmt.Sprintf("#EXT-X-MEDIA-SEQUENCE:%d\n\n", seq))
+
+	for i := range frags {
+		frag := &frags[i]
+		if frag.discont && i != 0 {
+			buf.WriteString("#EXT-X-DISCONTINUITY\n")
+		}
+		if frag.initFilename != "" && (i == 0 || frag.discont || frag.initFilename != frags[i-1].initFilename) {
+			buf.WriteString(fmt.Sprintf("#EXT-X-MAP:URI=\"%s\"\n", frag.initFilename))
+		}
+		buf.WriteString(fmt.Sprintf("#EXT-X-PROGRAM-DATE-TIME:%s\n", frag.programDateTime.Format(dvrProgramDateTimeLayout)))
+		buf.WriteString(fmt.Sprintf("#EXTINF:%.3f,\n%s\n", frag.duration, frag.filename))
+	}
+
+	if isEnd {
+		buf.WriteString("#EXT-X-ENDLIST\n")
+	}
+	return buf.Bytes()
+}
+
+// parseDvrPlaylist 解析 makeDvrPlaylist 生成的m3u8
+//
+// @return isEnd: 是否包含`#EXT-X-ENDLIST`
+func parseDvrPlaylist(content []byte) (frags []dvrFragment, isEnd bool, err error) {
+	var (
+		seq  int
+		curr dvrFragment
+		init string
+	)
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"):
+			if seq, err = strconv.Atoi(strings.TrimPrefix(line, "#EXT-X-MEDIA-SEQUENCE:")); err != nil {
+				return nil, false, err
+			}
+		case line == "#EXT-X-DISCONTINUITY":
+			curr.discont = true
+		case strings.HasPrefix(line, "#EXT-X-MAP:URI="):
+			init = strings.Trim(strings.TrimPrefix(line, "#EXT-X-MAP:URI="), "\"")
+		case strings.HasPrefix(line, "#EXT-X-PROGRAM-DATE-TIME:"):
+			if curr.programDateTime, err = time.Parse(dvrProgramDateTimeLayout, strings.TrimPrefix(line, "#EXT-X-PROGRAM-DATE-TIME:")); err != nil {
+				return nil, false, err
+			}
+		case strings.HasPrefix(line, "#EXTINF:"):
+			d := strings.TrimPrefix(line, "#EXTINF:")
+			if i := strings.IndexByte(d, ','); i != -1 {
+				d = d[:i]
+			}
+			if curr.duration, err = strconv.ParseFloat(d, 64); err != nil {
+				return nil, false, err
+			}
+		case line == "#EXT-X-ENDLIST":
+			isEnd = true
+		case strings.HasPrefix(line, "#"):
+		default:
+			if curr.programDateTime.IsZero() {
+				return nil, false, nazaerrors.Wrap(base.ErrHls)
+			}
+			curr.filename = line
+			curr.initFilename = init
+			curr.seq = seq + len(frags)
+			frags = append(frags, curr)
+			curr = dvrFragment{}
+		}
+	}
+	return frags, isEnd, scanner.Err()
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// isDvrRequest m3u8请求中是否携带了时移参数
+func isDvrRequest(query url.Values) bool {
+	return query.Get("start") != "" || query.Get("end") != "" || query.Get("offset") != ""
+}
+
+// parseDvrOffset 解析`offset`参数，支持`-600s`、`-10m`这种带单位的格式，以及不带单位的秒数
+func parseDvrOffset(offset string) (time.Duration, error) {
+	if d, err := time.ParseDuration(offset); err == nil {
+		return d, nil
+	}
+	sec, err := strconv.ParseInt(offset, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(sec) * time.Second, nil
+}
+
+// parseDvrTimeRange 解析`start`、`end`参数，单位为unix秒，end为空时返回零值
+func parseDvrTimeRange(query url.Values) (start, end time.Time, err error) {
+	if s := query.Get("start"); s != "" {
+		var sec int64
+		if sec, err = strconv.ParseInt(s, 10, 64); err != nil {
+			return
+		}
+		start = time.Unix(sec, 0)
+	}
+	if s := query.Get("end"); s != "" {
+		var sec int64
+		if sec, err = strconv.ParseInt(s, 10, 64); err != nil {
+			return
+		}
+		end = time.Unix(sec, 0)
+		if !start.IsZero() && !end.After(start) {
+			err = nazaerrors.Wrap(base.ErrHls)
+		}
+	}
+	return
+}
+
+// buildDvrPlaylist 从dvr.m3u8中筛选出与[start, end)有交集的分片，生成时移播放列表
+//
+// end为零值，或者end晚于当前时间并且流还未结束时，生成EVENT类型的列表，否则生成VOD类型的列表
+//
+// @return 没有满足条件的分片时返回错误
+func buildDvrPlaylist(content []byte, start, end time.Time) ([]byte, error) {
+	frags, isEnd, err := parseDvrPlaylist(content)
+	if err != nil {
+		return nil, err
+	}
+
+	var selected []dvrFragment
+	for i := range frags {
+		frag := &frags[i]
+		if !start.IsZero() && !frag.endTime().After(start) {
+			continue
+		}
+		if !end.IsZero() && !frag.programDateTime.Before(end) {
+			break
+		}
+		selected = append(selected, *frag)
+	}
+	if len(selected) == 0 {
+		return nil, nazaerrors.Wrap(base.ErrHls)
+	}
+
+	if isEnd || (!end.IsZero() && !end.After(Clock.Now())) {
+		return makeDvrPlaylistWithType(selected, "VOD", true), nil
+	}
+	return makeDvrPlaylistWithType(selected, "EVENT", false), nil
+}
+
+func dvrPathStrategy() IDvrPathStrategy {
+	if s, ok := PathStrategy.(IDvrPathStrategy); ok {
+		return s
+	}
+	return &DefaultPathStrategy{}
+}
@@ -0,0 +1,146 @@
+// Copyright 2023, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package hls_test
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/hls"
+	"github.com/q191201771/lal/pkg/mpegts"
+	"github.com/q191201771/naza/pkg/assert"
+	"github.com/q191201771/naza/pkg/mock"
+)
+
+func TestDvr(t *testing.T) {
+	oldClock := hls.Clock
+	defer func() { hls.Clock = oldClock }()
+	hls.Clock = mock.NewFakeClock()
+	t0 := time.Unix(1690000000, 0)
+	hls.Clock.Set(t0)
+
+	outPath := t.TempDir()
+	config := hls.MuxerConfig{
+		OutPath:            outPath,
+		FragmentDurationMs: 1000,
+		FragmentNum:        3,
+		DeleteThreshold:    1,
+		CleanupMode:        hls.CleanupModeAsap,
+		DvrWindowSec:       10,
+	}
+
+	// 25fps，每秒一个关键帧，时钟与时间戳同步增长
+	var dts uint64
+	feed := func(m *hls.Muxer, n int) {
+		for i := 0; i < n; i++ {
+			key := (dts/3600)%25 == 0
+			frame := &mpegts.Frame{Sid: mpegts.StreamIdVideo, Dts: dts, Pts: dts, Key: key}
+			m.FeedMpegts(make([]byte, 188), frame, key)
+			dts += 3600
+			hls.Clock.Add(40 * time.Millisecond)
+		}
+	}
+
+	m := hls.NewMuxer("dvr", &config, &llHlsObserver{})
+	m.Start()
+	m.FeedPatPmt(make([]byte, 188))
+	feed(m, 25*30)
+
+	// 窗口外的分片被删除，窗口内的分片依然保留在磁盘上
+	dvrFilename := fmt.Sprintf("%s/dvr/dvr.m3u8", outPath)
+	content, err := hls.ReadFile(dvrFilename)
+	assert.Equal(t, nil, err)
+	files := regexp.MustCompile(`(?m)^dvr-.*\.ts$`).FindAll(content, -1)
+	assert.Equal(t, 11, len(files))
+	assert.Equal(t, 11, bytes.Count(content, []byte("#EXT-X-PROGRAM-DATE-TIME:")))
+	assert.Equal(t, true, bytes.Contains(content, []byte("#EXT-X-MEDIA-SEQUENCE:18\n")))
+	for _, f := range files {
+		_, err = os.Stat(fmt.Sprintf("%s/dvr/%s", outPath, f))
+		assert.Equal(t, nil, err)
+	}
+	// 另外还有一个正在写入的分片
+	tsFiles, _ := filepath.Glob(fmt.Sprintf("%s/dvr/*.ts", outPath))
+	assert.Equal(t, len(files)+1, len(tsFiles))
+
+	sh := hls.NewServerHandler(outPath, "/hls/", "", 0, false, nil, nil)
+	get := func(query string) *httptest.ResponseRecorder {
+		rawUrl := "http://127.0.0.1/hls/dvr/playlist.m3u8?" + query
+		urlCtx, err := base.ParseUrl(rawUrl, 80)
+		assert.Equal(t, nil, err)
+		req := httptest.NewRequest(http.MethodGet, rawUrl, nil)
+		w := httptest.NewRecorder()
+		sh.ServeHTTP(w, req, urlCtx)
+		return w
+	}
+
+	// 过去的时间段，返回VOD
+	w := get(fmt.Sprintf("start=%d&end=%d", t0.Unix()+22, t0.Unix()+25))
+	assert.Equal(t, http.StatusOK, w.Code)
+	body := w.Body.Bytes()
+	assert.Equal(t, true, bytes.Contains(body, []byte("#EXT-X-PLAYLIST-TYPE:VOD\n")))
+	assert.Equal(t, true, bytes.HasSuffix(body, []byte("#EXT-X-ENDLIST\n")))
+	assert.Equal(t, 3, bytes.Count(body, []byte("#EXTINF:")))
+	assert.Equal(t, true, bytes.Contains(body, []byte("#EXT-X-MEDIA-SEQUENCE:22\n")))
+
+	// 没有end，返回EVENT
+	w = get(fmt.Sprintf("start=%d", t0.Unix()+25))
+	assert.Equal(t, http.StatusOK, w.Code)
+	body = w.Body.Bytes()
+	assert.Equal(t, true, bytes.Contains(body, []byte("#EXT-X-PLAYLIST-TYPE:EVENT\n")))
+	assert.Equal(t, false, bytes.Contains(body, []byte("#EXT-X-ENDLIST")))
+	assert.Equal(t, 4, bytes.Count(body, []byte("#EXTINF:")))
+
+	// offset跳转为start
+	w = get("offset=-600s")
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, fmt.Sprintf("/hls/dvr/playlist.m3u8?start=%d", hls.Clock.Now().Unix()-600), w.Header().Get("Location"))
+
+	// 窗口外以及非法参数
+	assert.Equal(t, http.StatusNotFound, get(fmt.Sprintf("start=%d&end=%d", t0.Unix(), t0.Unix()+10)).Code)
+	assert.Equal(t, http.StatusBadRequest, get("start=abc").Code)
+	assert.Equal(t, http.StatusBadRequest, get("offset=600s").Code)
+
+	// 流重连后，时移窗口从dvr.m3u8中恢复，序号连续并标记不连续
+	m.Dispose()
+	m = hls.NewMuxer("dvr", &config, &llHlsObserver{})
+	m.Start()
+	m.FeedPatPmt(make([]byte, 188))
+	feed(m, 25*3)
+	m.Dispose()
+	content, err = hls.ReadFile(dvrFilename)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, bytes.Count(content, []byte("#EXT-X-DISCONTINUITY\n")))
+	assert.Equal(t, true, bytes.HasSuffix(content, []byte("#EXT-X-ENDLIST\n")))
+
+	w = get(fmt.Sprintf("start=%d", t0.Unix()+25))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, true, bytes.Contains(w.Body.Bytes(), []byte("#EXT-X-PLAYLIST-TYPE:VOD\n")))
+
+	// CleanupModeInTheEnd模式下，直播期间窗口外的分片同样被删除
+	config.OutPath = t.TempDir()
+	config.CleanupMode = hls.CleanupModeInTheEnd
+	m = hls.NewMuxer("dvr", &config, &llHlsObserver{})
+	m.Start()
+	m.FeedPatPmt(make([]byte, 188))
+	feed(m, 25*30)
+	content, err = hls.ReadFile(fmt.Sprintf("%s/dvr/dvr.m3u8", config.OutPath))
+	assert.Equal(t, nil, err)
+	files = regexp.MustCompile(`(?m)^dvr-.*\.ts$`).FindAll(content, -1)
+	tsFiles, _ = filepath.Glob(fmt.Sprintf("%s/dvr/*.ts", config.OutPath))
+	assert.Equal(t, len(files)+1, len(tsFiles))
+	m.Dispose()
+}
@@ -37,6 +37,11 @@ func RemoveAll(path string) error {
 	return fslCtx.RemoveAll(path)
 }
 
+// newPartFileSystemLayer LL-HLS的部分切片时长很短，并且很快过期，所以总是存放在内存中
+func newPartFileSystemLayer() filesystemlayer.IFileSystemLayer {
+	return filesystemlayer.NewFslMemory()
+}
+
 func init() {
 	fslCtx = filesystemlayer.FslFactory(filesystemlayer.FslTypeDisk, nil)
 }
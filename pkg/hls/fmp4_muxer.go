@@ -0,0 +1,306 @@
+// Copyright 2023, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package hls
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/fmp4"
+	"github.com/q191201771/lal/pkg/mpegts"
+)
+
+// fmp4(CMAF)模式的HLS
+//
+// 与TS模式共用 Muxer 的分片、m3u8、过期清理等逻辑，区别在于：
+//
+// - 输入为rtmp message，而不是mpegts
+// - 分片文件为.m4s，m3u8中通过`#EXT-X-MAP`指定init segment
+// - HEVC可以在Safari/iOS上播放
+// - 可以同时生成DASH的mpd文件，与m3u8共用分片文件
+//
+// #EXTM3U
+// #EXT-X-VERSION:7
+// #EXT-X-TARGETDURATION:3
+// #EXT-X-MEDIA-SEQUENCE:0
+//
+// #EXT-X-DISCONTINUITY
+// #EXT-X-MAP:URI="test110-1620540712084-init.mp4"
+// #EXTINF:3.000,
+// test110-1620540712084-0.m4s
+
+const (
+	fmp4PlaylistVersion = 7
+
+	fmp4VideoTrackId = 1
+	fmp4AudioTrackId = 2
+
+	// fmp4DefaultVideoSampleDuration 无法计算视频帧时长时使用的默认值，单位为 fmp4.VideoTimescale
+	fmp4DefaultVideoSampleDuration = 3600
+)
+
+type fmp4Context struct {
+	videoTrack *fmp4.Track
+	audioTrack *fmp4.Track
+
+	initFilename string
+	initDirty    bool // track发生变化，需要在下一个分片开始前重新生成init segment
+
+	// 每个sample的时长需要等到下一个sample到来才能确定，所以先缓存在pending中
+	videoPending      *fmp4.Sample
+	audioPending      *fmp4.Sample
+	videoLastDuration uint32
+	audioLastDuration uint32
+
+	// 当前分片中已经确定时长的sample
+	videoSamples []fmp4.Sample
+	audioSamples []fmp4.Sample
+
+	// DASH mpd的availabilityStartTime，每次生成新的init segment时更新
+	availabilityStartTime time.Time
+}
+
+// NewFmp4Muxer 创建fmp4模式的 Muxer ，通过 Muxer.FeedRtmpMessage 输入数据
+//
+// 注意，fmp4模式下不支持LL-HLS
+func NewFmp4Muxer(streamName string, config *MuxerConfig, observer IMuxerObserver) *Muxer {
+	m := NewMuxer(streamName, config, observer)
+	m.fmp4 = &fmp4Context{}
+	if config.DashEnable {
+		m.mpdFilename = fmp4PathStrategy().GetMpdFileName(m.outPath, streamName)
+	}
+	Log.Infof("[%s] fmp4 mode. dash=%t", m.UniqueKey, config.DashEnable)
+	return m
+}
+
+// FeedRtmpMessage fmp4模式下的数据输入，TS模式下调用无效
+//
+// @param msg: 内部不持有msg.Payload内存块
+func (m *Muxer) FeedRtmpMessage(msg base.RtmpMsg) {
+	if !m.isFmp4() {
+		return
+	}
+	ctx := m.fmp4
+
+	switch msg.Header.MsgTypeId {
+	case base.RtmpTypeIdVideo:
+		// 注意，Enhanced RTMP格式的HEVC在进入group时已经转换为传统格式
+		if len(msg.Payload) <= 5 || msg.IsEnhanced() {
+			return
+		}
+		if msg.IsAvcKeySeqHeader() || msg.IsHevcKeySeqHeader() {
+			var t *fmp4.Track
+			var err error
+			if msg.IsAvcKeySeqHeader() {
+				t, err = fmp4.NewAvcTrack(fmp4VideoTrackId, msg.Payload)
+			} else {
+				t, err = fmp4.NewHevcTrack(fmp4VideoTrackId, msg.Payload)
+			}
+			if err != nil {
+				Log.Errorf("[%s] create video track failed. err=%+v", m.UniqueKey, err)
+				return
+			}
+			m.updateFmp4Track(&ctx.videoTrack, t)
+			return
+		}
+		// 注意，avc和hevc的nalu类型都是1
+		if ctx.videoTrack == nil || msg.Payload[1] != base.RtmpAvcPacketTypeNalu {
+			return
+		}
+		key := msg.IsVideoKeyNalu()
+		sample := fmp4.Sample{
+			Dts:  uint64(msg.Dts()) * 90,
+			Pts:  uint64(msg.Pts()) * 90,
+			Key:  key,
+			Data: append([]byte(nil), msg.Payload[5:]...),
+		}
+		m.feedFmp4Sample(true, sample, sample.Dts, key)
+
+	case base.RtmpTypeIdAudio:
+		if len(msg.Payload) <= 2 || msg.Payload[0]>>4 != base.RtmpSoundFormatAac {
+			return
+		}
+		if msg.IsAacSeqHeader() {
+			t, err := fmp4.NewAacTrack(fmp4AudioTrackId, msg.Payload[2:])
+			if err != nil {
+				Log.Errorf("[%s] create audio track failed. err=%+v", m.UniqueKey, err)
+				return
+			}
+			m.updateFmp4Track(&ctx.audioTrack, t)
+			return
+		}
+		if ctx.audioTrack == nil {
+			return
+		}
+		dts := uint64(msg.Dts()) * uint64(ctx.audioTrack.Timescale) / 1000
+		sample := fmp4.Sample{
+			Dts:  dts,
+			Pts:  dts,
+			Key:  true,
+			Data: append([]byte(nil), msg.Payload[2:]...),
+		}
+		// 纯音频时，每一帧都可以作为分片的开始
+		m.feedFmp4Sample(false, sample, uint64(msg.Dts())*90, ctx.videoTrack == nil)
+	}
+}
+
+// updateFmp4Track track发生变化时，结束当前分片，下个分片使用新的init segment
+func (m *Muxer) updateFmp4Track(curr **fmp4.Track, t *fmp4.Track) {
+	if *curr != nil && bytes.Equal((*curr).Config, t.Config) {
+		return
+	}
+	Log.Infof("[%s] fmp4 track changed. id=%d, codec=%s", m.UniqueKey, t.Id, t.Codec)
+	*curr = t
+	m.fmp4.initDirty = true
+	if err := m.closeFragment(false); err != nil {
+		Log.Errorf("[%s] close fragment error. err=%+v", m.UniqueKey, err)
+	}
+}
+
+// feedFmp4Sample
+//
+// @param ts: 用于切片判断的时间戳，单位毫秒*90
+func (m *Muxer) feedFmp4Sample(isVideo bool, sample fmp4.Sample, ts uint64, boundary bool) {
+	ctx := m.fmp4
+	pending, lastDuration, samples := &ctx.audioPending, &ctx.audioLastDuration, &ctx.audioSamples
+	sid := uint8(mpegts.StreamIdAudio)
+	if isVideo {
+		pending, lastDuration, samples = &ctx.videoPending, &ctx.videoLastDuration, &ctx.videoSamples
+		sid = mpegts.StreamIdVideo
+	}
+
+	// 上一个sample的时长确定了，它属于当前分片（切换分片之前）
+	if *pending != nil {
+		p := *pending
+		if sample.Dts > p.Dts {
+			p.Duration = uint32(sample.Dts - p.Dts)
+			*lastDuration = p.Duration
+		} else {
+			p.Duration = m.estimateFmp4SampleDuration(isVideo)
+		}
+		*samples = append(*samples, *p)
+		*pending = nil
+	}
+
+	// 只在打日志时使用
+	frame := &mpegts.Frame{Sid: sid, Dts: ts, Pts: ts, Key: sample.Key}
+	if err := m.updateFragment(ts, boundary, frame); err != nil {
+		Log.Errorf("[%s] update fragment error. err=%+v", m.UniqueKey, err)
+		return
+	}
+	if !m.opened {
+		return
+	}
+	*pending = &sample
+}
+
+func (m *Muxer) estimateFmp4SampleDuration(isVideo bool) uint32 {
+	ctx := m.fmp4
+	if isVideo {
+		if ctx.videoLastDuration > 0 {
+			return ctx.videoLastDuration
+		}
+		return fmp4DefaultVideoSampleDuration
+	}
+	if ctx.audioLastDuration > 0 {
+		return ctx.audioLastDuration
+	}
+	return ctx.audioTrack.SampleDuration()
+}
+
+// openFmp4Fragment 在 openFragment 中调用，必要时生成新的init segment
+//
+// @return discont: 生成了新的init segment时，分片强制设置为不连续
+func (m *Muxer) openFmp4Fragment(ts uint64, discont bool) (bool, error) {
+	ctx := m.fmp4
+	if ctx.initDirty || ctx.initFilename == "" {
+		var tracks []*fmp4.Track
+		if ctx.videoTrack != nil {
+			tracks = append(tracks, ctx.videoTrack)
+		}
+		if ctx.audioTrack != nil {
+			tracks = append(tracks, ctx.audioTrack)
+		}
+		b, err := fmp4.PackInitSegment(tracks)
+		if err != nil {
+			return discont, err
+		}
+		now := Clock.Now()
+		filename := fmp4PathStrategy().GetFmp4InitFileName(m.streamName, int(now.UnixNano()/1e6))
+		if err = fslCtx.WriteFile(PathStrategy.GetTsFileNameWithPath(m.outPath, filename), b, 0666); err != nil {
+			return discont, err
+		}
+		Log.Infof("[%s] write fmp4 init segment. filename=%s, codecs=%s", m.UniqueKey, filename, fmp4.Codecs(tracks))
+
+		ctx.initFilename = filename
+		ctx.initDirty = false
+		ctx.availabilityStartTime = now.Add(-time.Duration(ts) * time.Millisecond / 90)
+		discont = true
+	}
+	return discont, nil
+}
+
+// closeFmp4Fragment 在 closeFragment 中调用，将当前分片的所有sample写入m4s文件
+func (m *Muxer) closeFmp4Fragment() error {
+	ctx := m.fmp4
+	if ctx.videoPending != nil {
+		ctx.videoPending.Duration = m.estimateFmp4SampleDuration(true)
+		ctx.videoSamples = append(ctx.videoSamples, *ctx.videoPending)
+		ctx.videoPending = nil
+	}
+	if ctx.audioPending != nil {
+		ctx.audioPending.Duration = m.estimateFmp4SampleDuration(false)
+		ctx.audioSamples = append(ctx.audioSamples, *ctx.audioPending)
+		ctx.audioPending = nil
+	}
+
+	var fragments []fmp4.TrackFragment
+	if ctx.videoTrack != nil && len(ctx.videoSamples) > 0 {
+		fragments = append(fragments, fmp4.TrackFragment{Track: ctx.videoTrack, Samples: ctx.videoSamples})
+	}
+	if ctx.audioTrack != nil && len(ctx.audioSamples) > 0 {
+		fragments = append(fragments, fmp4.TrackFragment{Track: ctx.audioTrack, Samples: ctx.audioSamples})
+	}
+	ctx.videoSamples = nil
+	ctx.audioSamples = nil
+
+	frag := m.getCurrFrag()
+	b, err := fmp4.PackMediaSegment(uint32(frag.id+1), fragments)
+	if err != nil {
+		return err
+	}
+	frag.size = len(b)
+	return fslCtx.WriteFile(PathStrategy.GetTsFileNameWithPath(m.outPath, frag.filename), b, 0666)
+}
+
+func (m *Muxer) getFmp4SegmentFileName(id int) string {
+	return fmp4PathStrategy().GetFmp4SegmentFileName(m.streamName, id, int(Clock.Now().UnixNano()/1e6))
+}
+
+func (m *Muxer) isFmp4() bool {
+	return m.fmp4 != nil
+}
+
+func (m *Muxer) isDash() bool {
+	return m.mpdFilename != ""
+}
+
+// writeFmp4Map 在分片前写入`#EXT-X-MAP`
+func writeFmp4Map(buf *bytes.Buffer, frag *fragmentInfo) {
+	buf.WriteString(fmt.Sprintf("#EXT-X-MAP:URI=\"%s\"\n", frag.initFilename))
+}
+
+func fmp4PathStrategy() IFmp4PathWriteStrategy {
+	if s, ok := PathStrategy.(IFmp4PathWriteStrategy); ok {
+		return s
+	}
+	return &DefaultPathStrategy{}
+}
@@ -0,0 +1,141 @@
+// Copyright 2023, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package hls_test
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/hls"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+var goldenAvcSeqHeader = []byte{
+	0x17, 0x00, 0x00, 0x00, 0x00,
+	0x01, 0x64, 0x00, 0x1F, 0xFF,
+	0xE1, 0x00, 0x0A,
+	0x27, 0x64, 0x00, 0x1F, 0xAC, 0x56, 0x80, 0xB4, 0x0A, 0x19,
+	0x01, 0x00, 0x04,
+	0x28, 0xEE, 0x3C, 0xB0,
+}
+
+func newRtmpMsg(typeId uint8, ts uint32, payload []byte) base.RtmpMsg {
+	return base.RtmpMsg{
+		Header: base.RtmpHeader{
+			MsgTypeId:    typeId,
+			MsgLen:       uint32(len(payload)),
+			TimestampAbs: ts,
+		},
+		Payload: payload,
+	}
+}
+
+func TestFmp4Muxer(t *testing.T) {
+	outPath := t.TempDir()
+	config := hls.MuxerConfig{
+		OutPath:            outPath,
+		FragmentDurationMs: 1000,
+		FragmentNum:        4,
+		DeleteThreshold:    4,
+		CleanupMode:        hls.CleanupModeNever,
+		DashEnable:         true,
+	}
+	m := hls.NewFmp4Muxer("fmp4", &config, &llHlsObserver{})
+	m.Start()
+
+	// TS模式的输入被忽略
+	m.FeedMpegts(make([]byte, 188), nil, true)
+
+	m.FeedRtmpMessage(newRtmpMsg(base.RtmpTypeIdVideo, 0, goldenAvcSeqHeader))
+	m.FeedRtmpMessage(newRtmpMsg(base.RtmpTypeIdAudio, 0, []byte{0xAF, 0x00, 0x12, 0x10}))
+
+	// 视频25fps，每秒一个关键帧，音频44100，每帧1024个采样点
+	var audioTs float64
+	for i := 0; i < 25*3+10; i++ {
+		ts := uint32(i * 40)
+		for audioTs <= float64(ts) {
+			m.FeedRtmpMessage(newRtmpMsg(base.RtmpTypeIdAudio, uint32(audioTs), []byte{0xAF, 0x01, 0x21, 0x00}))
+			audioTs += 1024 * 1000 / 44100.0
+		}
+		head := byte(0x27)
+		if i%25 == 0 {
+			head = 0x17
+		}
+		m.FeedRtmpMessage(newRtmpMsg(base.RtmpTypeIdVideo, ts, []byte{head, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x65}))
+	}
+
+	content, err := hls.ReadFile(fmt.Sprintf("%s/fmp4/playlist.m3u8", outPath))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, bytes.Contains(content, []byte("#EXT-X-VERSION:7\n")))
+	assert.Equal(t, 3, bytes.Count(content, []byte("#EXTINF:1.000,\n")))
+	assert.Equal(t, 1, bytes.Count(content, []byte("#EXT-X-MAP:")))
+	init := regexp.MustCompile(`#EXT-X-MAP:URI="(fmp4-\d+-init\.mp4)"`).FindSubmatch(content)
+	assert.IsNotNil(t, init)
+	segments := regexp.MustCompile(`fmp4-\d+-\d+\.m4s`).FindAll(content, -1)
+	assert.Equal(t, 3, len(segments))
+
+	mpd, err := hls.ReadFile(fmt.Sprintf("%s/fmp4/playlist.mpd", outPath))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, bytes.Contains(mpd, []byte(`type="dynamic"`)))
+	assert.Equal(t, true, bytes.Contains(mpd, []byte(`codecs="avc1.64001f,mp4a.40.2"`)))
+	assert.Equal(t, true, bytes.Contains(mpd, []byte(fmt.Sprintf(`<Initialization sourceURL="%s"/>`, init[1]))))
+	assert.Equal(t, true, bytes.Contains(mpd, []byte(`<S t="90000" d="90000"/>`)))
+	for _, s := range segments {
+		assert.Equal(t, true, bytes.Contains(mpd, []byte(fmt.Sprintf(`<SegmentURL media="%s"/>`, s))))
+	}
+
+	sh := hls.NewServerHandler(outPath, "/hls/", "", 0, false, nil, nil)
+	get := func(uri string) *httptest.ResponseRecorder {
+		rawUrl := "http://127.0.0.1/hls/fmp4/" + uri
+		urlCtx, err := base.ParseUrl(rawUrl, 80)
+		assert.Equal(t, nil, err)
+		w := httptest.NewRecorder()
+		sh.ServeHTTP(w, httptest.NewRequest(http.MethodGet, rawUrl, nil), urlCtx)
+		return w
+	}
+	w := get(string(init[1]))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "video/mp4", w.Header().Get("Content-Type"))
+	assert.Equal(t, "ftyp", string(w.Body.Bytes()[4:8]))
+	w = get(string(segments[0]))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "video/iso.segment", w.Header().Get("Content-Type"))
+	assert.Equal(t, "styp", string(w.Body.Bytes()[4:8]))
+	w = get("playlist.mpd")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/dash+xml", w.Header().Get("Content-Type"))
+
+	// 编码参数变化，生成新的init segment，并且分片不连续
+	seqHeader := append([]byte(nil), goldenAvcSeqHeader...)
+	seqHeader[len(seqHeader)-1] ^= 0xFF
+	m.FeedRtmpMessage(newRtmpMsg(base.RtmpTypeIdVideo, 3400, seqHeader))
+	m.FeedRtmpMessage(newRtmpMsg(base.RtmpTypeIdVideo, 3400, []byte{0x17, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x65}))
+	m.FeedRtmpMessage(newRtmpMsg(base.RtmpTypeIdVideo, 4400, []byte{0x17, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x65}))
+	content, err = hls.ReadFile(fmt.Sprintf("%s/fmp4/playlist.m3u8", outPath))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, bytes.Count(content, []byte("#EXT-X-MAP:")))
+	assert.Equal(t, true, bytes.Contains(content, []byte("#EXT-X-DISCONTINUITY\n#EXT-X-MAP:")))
+
+	m.Dispose()
+	content, err = hls.ReadFile(fmt.Sprintf("%s/fmp4/playlist.m3u8", outPath))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, bytes.HasSuffix(content, []byte("#EXT-X-ENDLIST\n")))
+	mpd, err = hls.ReadFile(fmt.Sprintf("%s/fmp4/playlist.mpd", outPath))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, bytes.Contains(mpd, []byte(`type="static"`)))
+	record, err := hls.ReadFile(fmt.Sprintf("%s/fmp4/record.m3u8", outPath))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, bytes.Contains(record, []byte("#EXT-X-VERSION:7\n")))
+	assert.Equal(t, 2, bytes.Count(record, []byte("#EXT-X-MAP:")))
+}
@@ -0,0 +1,303 @@
+// Copyright 2023, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package hls
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LL-HLS(Low-Latency HLS)
+//
+// https://datatracker.ietf.org/doc/html/draft-pantos-hls-rfc8216bis
+//
+// 开启后，在生成完整ts分片的同时，将分片再切成多个部分切片(partial segment)，m3u8中增加以下标签：
+//
+// #EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=1.500 // 支持阻塞请求，播放端距离直播末尾的最小距离
+// #EXT-X-PART-INF:PART-TARGET=0.500                              // 部分切片的最大时长
+// #EXT-X-PART:DURATION=0.500,URI="test110-1620540712084-0_part0.ts",INDEPENDENT=YES
+// #EXT-X-PRELOAD-HINT:TYPE=PART,URI="test110-1620540712084-1_part2.ts" // 下一个即将生成的部分切片
+//
+// 部分切片文件只存放在内存中，不落盘
+//
+// 播放端可以在m3u8请求中携带`_HLS_msn`和`_HLS_part`参数，服务端会阻塞直到m3u8中包含对应的分片后再返回
+
+const (
+	llHlsPlaylistVersion = 6
+
+	// llHlsKeepPartFragNum m3u8中保留多少个已完成分片的部分切片信息，更早的部分切片会从内存中删除
+	llHlsKeepPartFragNum = 2
+
+	// llHlsBlockingTimeoutFactor 阻塞请求的最长等待时间为分片时长的倍数
+	llHlsBlockingTimeoutFactor = 3
+
+	partFileNameSuffix = "_part%d.ts"
+)
+
+var partFslCtx = newPartFileSystemLayer()
+
+type partInfo struct {
+	duration    float64 // 单位秒
+	independent bool    // 是否以关键帧开始
+	filename    string
+}
+
+// getPartFileName
+//
+// 由所属ts分片的文件名生成，格式为{ts文件名去掉.ts}_part{index}.ts，
+// 这样 DefaultPathStrategy 可以像处理ts文件一样获取流名称以及文件路径
+func getPartFileName(tsFileName string, index int) string {
+	return strings.TrimSuffix(tsFileName, ".ts") + fmt.Sprintf(partFileNameSuffix, index)
+}
+
+func isPartFileName(filename string) bool {
+	i := strings.LastIndex(filename, "_part")
+	if i == -1 || !strings.HasSuffix(filename, ".ts") {
+		return false
+	}
+	var index int
+	_, err := fmt.Sscanf(filename[i:], partFileNameSuffix, &index)
+	return err == nil
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// updatePart 在写入当前帧之前调用，决定是否结束当前的部分切片并开启新的部分切片
+func (m *Muxer) updatePart(ts uint64, boundary bool) {
+	if m.partOpened {
+		m.updatePartDuration(ts)
+
+		// 保证部分切片的时长不超过PART-TARGET，所以用上一帧的间隔预估加入当前帧之后的时长
+		target := float64(m.config.PartDurationMs) / 1000
+		if m.partDuration >= target || m.partDuration+m.partFrameGap > target {
+			m.closePart()
+			m.writePlaylist(false)
+		}
+	}
+
+	if !m.partOpened {
+		m.partOpened = true
+		m.partTs = ts
+		m.partLastTs = ts
+		m.partDuration = 0
+		m.partIndependent = boundary
+		m.partBuf = append(m.partBuf[:0], m.patpmt...)
+	}
+
+	if ts > m.partLastTs {
+		m.partFrameGap = float64(ts-m.partLastTs) / 90000
+	}
+	m.partLastTs = ts
+}
+
+func (m *Muxer) updatePartDuration(ts uint64) {
+	if ts > m.partTs {
+		duration := float64(ts-m.partTs) / 90000
+		if duration > m.partDuration {
+			m.partDuration = duration
+		}
+	}
+}
+
+// closePart 将当前的部分切片写入内存，注意，不会更新m3u8
+func (m *Muxer) closePart() {
+	if !m.partOpened {
+		return
+	}
+	m.partOpened = false
+
+	frag := m.getCurrFrag()
+	part := partInfo{
+		duration:    m.partDuration,
+		independent: m.partIndependent,
+		filename:    getPartFileName(frag.filename, len(frag.parts)),
+	}
+	filenameWithPath := PathStrategy.GetTsFileNameWithPath(m.outPath, part.filename)
+	if err := partFslCtx.WriteFile(filenameWithPath, m.partBuf, 0666); err != nil {
+		Log.Errorf("[%s] write part file failed. filename=%s, err=%+v", m.UniqueKey, filenameWithPath, err)
+		return
+	}
+	frag.parts = append(frag.parts, part)
+}
+
+// removeStaleParts 分片关闭并且incrFrag()后调用，删除已经不在m3u8中展示的部分切片
+func (m *Muxer) removeStaleParts() {
+	if m.nfrags <= llHlsKeepPartFragNum {
+		return
+	}
+	m.removeParts(m.getFrag(m.nfrags - llHlsKeepPartFragNum - 1))
+}
+
+func (m *Muxer) removeParts(frag *fragmentInfo) {
+	for _, part := range frag.parts {
+		_ = partFslCtx.Remove(PathStrategy.GetTsFileNameWithPath(m.outPath, part.filename))
+	}
+	frag.parts = nil
+}
+
+func (m *Muxer) removeAllParts() {
+	for i := range m.frags {
+		m.removeParts(&m.frags[i])
+	}
+}
+
+// writeLowLatencyPlaylist 与 writePlaylist 对应，生成LL-HLS格式的m3u8
+func (m *Muxer) writeLowLatencyPlaylist(buf *bytes.Buffer, maxFrag float64, isLast bool) {
+	partTarget := float64(m.config.PartDurationMs) / 1000
+
+	buf.WriteString("#EXTM3U\n")
+	buf.WriteString(fmt.Sprintf("#EXT-X-VERSION:%d\n", llHlsPlaylistVersion))
+	buf.WriteString(fmt.Sprintf("#EXT-X-TARGETDURATION:%d\n", int(maxFrag)))
+	buf.WriteString(fmt.Sprintf("#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=%.3f\n", partTarget*3))
+	buf.WriteString(fmt.Sprintf("#EXT-X-PART-INF:PART-TARGET=%.3f\n", partTarget))
+	buf.WriteString(fmt.Sprintf("#EXT-X-MEDIA-SEQUENCE:%d\n\n", m.extXMediaSeq()))
+
+	writeParts := func(frag *fragmentInfo) {
+		for _, part := range frag.parts {
+			buf.WriteString(fmt.Sprintf("#EXT-X-PART:DURATION=%.3f,URI=\"%s\"", part.duration, part.filename))
+			if part.independent {
+				buf.WriteString(",INDEPENDENT=YES")
+			}
+			buf.WriteString("\n")
+		}
+	}
+
+	i := 0
+	m.iterateFragsInPlaylist(func(frag *fragmentInfo) {
+		if frag.discont {
+			buf.WriteString("#EXT-X-DISCONTINUITY\n")
+		}
+		if !isLast && i >= m.nfrags-llHlsKeepPartFragNum {
+			writeParts(frag)
+		}
+		buf.WriteString(fmt.Sprintf("#EXTINF:%.3f,\n%s\n", frag.duration, frag.filename))
+		i++
+	})
+
+	if isLast {
+		buf.WriteString("#EXT-X-ENDLIST\n")
+		return
+	}
+
+	// 正在生成中的分片，只有部分切片
+	if m.opened {
+		frag := m.getCurrFrag()
+		if frag.discont {
+			buf.WriteString("#EXT-X-DISCONTINUITY\n")
+		}
+		writeParts(frag)
+		buf.WriteString(fmt.Sprintf("#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\"%s\"\n", getPartFileName(frag.filename, len(frag.parts))))
+	}
+}
+
+// notifyPlaylistUpdated m3u8更新后调用，唤醒等待中的阻塞请求
+func (m *Muxer) notifyPlaylistUpdated(isLast bool) {
+	if isLast {
+		blockingReloadHub.remove(m.playlistFilename)
+		return
+	}
+
+	msn, part := m.getFragmentId(), -1
+	if m.opened {
+		part = len(m.getCurrFrag().parts) - 1
+	}
+	timeout := time.Duration(m.config.FragmentDurationMs*llHlsBlockingTimeoutFactor) * time.Millisecond
+	blockingReloadHub.update(m.playlistFilename, msn, part, timeout)
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+var blockingReloadHub = newPlaylistHub()
+
+// playlistHub 记录每个LL-HLS m3u8的最新状态，用于实现阻塞请求
+type playlistHub struct {
+	mutex   sync.Mutex
+	entries map[string]*playlistEntry // key为m3u8文件名
+}
+
+type playlistEntry struct {
+	msn     int // 正在生成中的分片序号，比它小的分片都已完成
+	part    int // msn分片中已经完成的最后一个部分切片的序号，没有时为-1
+	timeout time.Duration
+	ch      chan struct{} // 状态更新时close，并替换成新的
+}
+
+type blockingResult int
+
+const (
+	blockingResultReady blockingResult = iota
+	blockingResultBadRequest
+	blockingResultTimeout
+)
+
+func newPlaylistHub() *playlistHub {
+	return &playlistHub{
+		entries: make(map[string]*playlistEntry),
+	}
+}
+
+func (h *playlistHub) update(filename string, msn, part int, timeout time.Duration) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	e, ok := h.entries[filename]
+	if !ok {
+		e = &playlistEntry{ch: make(chan struct{})}
+		h.entries[filename] = e
+	}
+	e.msn, e.part, e.timeout = msn, part, timeout
+	close(e.ch)
+	e.ch = make(chan struct{})
+}
+
+func (h *playlistHub) remove(filename string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if e, ok := h.entries[filename]; ok {
+		close(e.ch)
+		delete(h.entries, filename)
+	}
+}
+
+// wait 阻塞直到m3u8中包含msn分片（part为-1时）或msn分片的第part个部分切片
+//
+// m3u8不存在或已经结束时直接返回 blockingResultReady ，由调用方按普通请求处理
+func (h *playlistHub) wait(filename string, msn, part int) blockingResult {
+	var timer <-chan time.Time
+	for {
+		h.mutex.Lock()
+		e, ok := h.entries[filename]
+		if !ok {
+			h.mutex.Unlock()
+			return blockingResultReady
+		}
+		// rfc8216bis 6.2.5.2，请求的分片比m3u8中最后的分片大2以上时，返回400
+		if msn > e.msn+1 {
+			h.mutex.Unlock()
+			return blockingResultBadRequest
+		}
+		if msn < e.msn || (msn == e.msn && part != -1 && part <= e.part) {
+			h.mutex.Unlock()
+			return blockingResultReady
+		}
+		ch := e.ch
+		if timer == nil {
+			timer = time.After(e.timeout)
+		}
+		h.mutex.Unlock()
+
+		select {
+		case <-ch:
+		case <-timer:
+			return blockingResultTimeout
+		}
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright 2023, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package hls_test
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/hls"
+	"github.com/q191201771/lal/pkg/mpegts"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+type llHlsObserver struct{}
+
+func (o *llHlsObserver) OnHlsMakeTs(info base.HlsMakeTsInfo) {}
+func (o *llHlsObserver) OnFragmentOpen()                     {}
+
+func TestLowLatencyHls(t *testing.T) {
+	outPath := t.TempDir()
+	config := hls.MuxerConfig{
+		OutPath:            outPath,
+		FragmentDurationMs: 1000,
+		FragmentNum:        4,
+		DeleteThreshold:    4,
+		CleanupMode:        hls.CleanupModeNever,
+		LowLatencyEnable:   true,
+		PartDurationMs:     200,
+	}
+	m := hls.NewMuxer("llhls", &config, &llHlsObserver{})
+	m.Start()
+	m.FeedPatPmt(make([]byte, 188))
+
+	// 25fps，每秒一个关键帧
+	var dts uint64
+	feed := func(n int) {
+		for i := 0; i < n; i++ {
+			key := (dts/3600)%25 == 0
+			frame := &mpegts.Frame{Sid: mpegts.StreamIdVideo, Dts: dts, Pts: dts, Key: key}
+			m.FeedMpegts(make([]byte, 188), frame, key)
+			dts += 3600
+		}
+	}
+	feed(25*3 + 10)
+
+	playlistFilename := fmt.Sprintf("%s/llhls/playlist.m3u8", outPath)
+	content, err := hls.ReadFile(playlistFilename)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, bytes.Contains(content, []byte("#EXT-X-VERSION:6\n")))
+	assert.Equal(t, true, bytes.Contains(content, []byte("#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=0.600\n")))
+	assert.Equal(t, true, bytes.Contains(content, []byte("#EXT-X-PART-INF:PART-TARGET=0.200\n")))
+	assert.Equal(t, true, bytes.Contains(content, []byte("#EXT-X-PRELOAD-HINT:TYPE=PART,URI=")))
+	assert.Equal(t, 3, bytes.Count(content, []byte("#EXTINF:")))
+
+	// 部分切片的时长不超过PART-TARGET，并且每个分片的首个部分切片以关键帧开始
+	parts := regexp.MustCompile(`#EXT-X-PART:DURATION=([0-9.]+),URI="([^"]+)"(,INDEPENDENT=YES)?`).FindAllSubmatch(content, -1)
+	assert.Equal(t, true, len(parts) > 0)
+	for _, p := range parts {
+		assert.Equal(t, true, string(p[1]) <= "0.200")
+		if bytes.HasSuffix(p[2], []byte("_part0.ts")) {
+			assert.Equal(t, ",INDEPENDENT=YES", string(p[3]))
+		}
+	}
+
+	sh := hls.NewServerHandler(outPath, "/hls/", "", 0, false, nil, nil)
+	get := func(uri string) *httptest.ResponseRecorder {
+		rawUrl := "http://127.0.0.1/hls/llhls/" + uri
+		urlCtx, err := base.ParseUrl(rawUrl, 80)
+		assert.Equal(t, nil, err)
+		w := httptest.NewRecorder()
+		sh.ServeHTTP(w, httptest.NewRequest(http.MethodGet, rawUrl, nil), urlCtx)
+		return w
+	}
+
+	// 部分切片从内存中读取
+	w := get(string(parts[len(parts)-1][2]))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, true, w.Body.Len() > 0)
+
+	// 已经存在的部分切片，立即返回
+	w = get("playlist.m3u8?_HLS_msn=3&_HLS_part=0")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// 超出范围
+	w = get("playlist.m3u8?_HLS_msn=10")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = get("playlist.m3u8?_HLS_part=1")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// 阻塞直到下一个分片生成
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		done <- get("playlist.m3u8?_HLS_msn=4&_HLS_part=0")
+	}()
+	select {
+	case <-done:
+		t.Fatal("blocking request returned too early")
+	case <-time.After(100 * time.Millisecond):
+	}
+	feed(25)
+	w = <-done
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, true, bytes.Contains(w.Body.Bytes(), []byte("-4_part0.ts")))
+
+	m.Dispose()
+	content, err = hls.ReadFile(playlistFilename)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, bytes.HasSuffix(content, []byte("#EXT-X-ENDLIST\n")))
+	assert.Equal(t, false, bytes.Contains(content, []byte("#EXT-X-PART:")))
+}
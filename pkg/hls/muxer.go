@@ -11,6 +11,7 @@ package hls
 import (
 	"bytes"
 	"fmt"
+	"time"
 
 	"github.com/q191201771/naza/pkg/nazaerrors"
 
@@ -40,6 +41,10 @@ type MuxerConfig struct {
 	FragmentNum        int    `json:"fragment_num"`
 	DeleteThreshold    int    `json:"delete_threshold"`
 	CleanupMode        int    `json:"cleanup_mode"` // TODO chef: lalserver的模式1的逻辑是在上层做的，应该重构到hls模块中
+	LowLatencyEnable   bool   `json:"low_latency_enable"`
+	PartDurationMs     int    `json:"part_duration_ms"`
+	DashEnable         bool   `json:"dash_enable"`    // 只在fmp4模式下生效
+	DvrWindowSec       int    `json:"dvr_window_sec"` // DVR时移窗口，单位秒，0表示不开启，详见 dvr.go
 }
 
 const (
@@ -51,6 +56,8 @@ const (
 // Muxer
 //
 // 输入mpegts流，输出hls(m3u8+ts)至文件中
+//
+// 使用 NewFmp4Muxer 创建时，输入rtmp message，输出hls(m3u8+init.mp4+m4s)以及DASH(mpd)至文件中
 type Muxer struct {
 	UniqueKey string
 
@@ -81,6 +88,25 @@ type Muxer struct {
 	frags  []fragmentInfo // frags TS文件的固定大小环形队列，记录TS的信息
 
 	patpmt []byte
+
+	// 以下字段只在开启LL-HLS时使用
+	partOpened      bool
+	partTs          uint64  // 当前部分切片的首个时间戳，毫秒 * 90
+	partLastTs      uint64  // 当前部分切片最后一帧的时间戳，毫秒 * 90
+	partDuration    float64 // 单位秒
+	partFrameGap    float64 // 最近两帧的时间间隔，单位秒
+	partIndependent bool
+	partBuf         []byte
+
+	// 以下字段只在fmp4模式下使用
+	fmp4        *fmp4Context
+	mpdFilename string // const after init, 为空时表示不生成mpd
+
+	// 以下字段只在开启DVR时使用
+	dvrPlaylistFilename    string // const after init
+	dvrPlaylistFilenameBak string // const after init
+	dvrFrags               []dvrFragment
+	dvrSeq                 int
 }
 
 // 记录fragment的一些信息，注意，写m3u8文件时可能还需要用到历史fragment的信息
@@ -89,6 +115,13 @@ type fragmentInfo struct {
 	duration float64 // 当前fragment中数据的时长，单位秒
 	discont  bool    // #EXT-X-DISCONTINUITY
 	filename string
+	parts    []partInfo // LL-HLS的部分切片
+
+	startTs      uint64 // fragment的首个时间戳，毫秒 * 90
+	size         int    // fragment文件的大小，fmp4模式下使用
+	initFilename string // fmp4模式下fragment对应的init segment
+
+	programDateTime time.Time // fragment创建时的墙上时间，DVR使用
 }
 
 // NewMuxer
@@ -112,6 +145,10 @@ func NewMuxer(streamName string, config *MuxerConfig, observer IMuxerObserver) *
 		config:                    config,
 		observer:                  observer,
 	}
+	if config.DvrWindowSec > 0 {
+		m.dvrPlaylistFilename = dvrPathStrategy().GetDvrM3u8FileName(op, streamName)
+		m.dvrPlaylistFilenameBak = fmt.Sprintf("%s.bak", m.dvrPlaylistFilename)
+	}
 	m.makeFrags()
 	Log.Infof("[%s] lifecycle new hls muxer. muxer=%p, streamName=%s", uk, m, streamName)
 	return m
@@ -120,6 +157,9 @@ func NewMuxer(streamName string, config *MuxerConfig, observer IMuxerObserver) *
 func (m *Muxer) Start() {
 	Log.Infof("[%s] start hls muxer.", m.UniqueKey)
 	m.ensureDir()
+	if m.isDvr() {
+		m.loadDvrPlaylist()
+	}
 }
 
 func (m *Muxer) Dispose() {
@@ -127,6 +167,9 @@ func (m *Muxer) Dispose() {
 	if err := m.closeFragment(true); err != nil {
 		Log.Errorf("[%s] close fragment error. err=%+v", m.UniqueKey, err)
 	}
+	if m.isLowLatency() {
+		m.removeAllParts()
+	}
 }
 
 // ---------------------------------------------------------------------------------------------------------------------
@@ -149,10 +192,23 @@ func (m *Muxer) FeedPatPmt(b []byte) {
 }
 
 func (m *Muxer) FeedMpegts(tsPackets []byte, frame *mpegts.Frame, boundary bool) {
+	if m.isFmp4() {
+		return
+	}
+
 	//Log.Debugf("> FeedMpegts. boundary=%v, frame=%p, sid=%d", boundary, frame, frame.Sid)
+	// TODO(chef): 为什么音频用pts，视频用dts
+	ts := frame.Dts
+	if frame.Sid == mpegts.StreamIdAudio {
+		ts = frame.Pts
+	}
+	if m.isLowLatency() && m.partOpened {
+		// 分片可能在 updateFragment 中关闭，所以先用当前帧的时间戳更新部分切片的时长
+		m.updatePartDuration(ts)
+	}
+
 	if frame.Sid == mpegts.StreamIdAudio {
-		// TODO(chef): 为什么音频用pts，视频用dts
-		if err := m.updateFragment(frame.Pts, boundary, frame); err != nil {
+		if err := m.updateFragment(ts, boundary, frame); err != nil {
 			Log.Errorf("[%s] update fragment error. err=%+v", m.UniqueKey, err)
 			return
 		}
@@ -162,7 +218,7 @@ func (m *Muxer) FeedMpegts(tsPackets []byte, frame *mpegts.Frame, boundary bool)
 		}
 		//Log.Debugf("[%s] WriteFrame A. dts=%d, len=%d", m.UniqueKey, frame.DTS, len(frame.Raw))
 	} else {
-		if err := m.updateFragment(frame.Dts, boundary, frame); err != nil {
+		if err := m.updateFragment(ts, boundary, frame); err != nil {
 			Log.Errorf("[%s] update fragment error. err=%+v", m.UniqueKey, err)
 			return
 		}
@@ -174,10 +230,18 @@ func (m *Muxer) FeedMpegts(tsPackets []byte, frame *mpegts.Frame, boundary bool)
 		//Log.Debugf("[%s] WriteFrame V. dts=%d, len=%d", m.UniqueKey, frame.Dts, len(frame.Raw))
 	}
 
+	if m.isLowLatency() {
+		m.updatePart(ts, boundary)
+	}
+
 	if err := m.fragment.WriteFile(tsPackets); err != nil {
 		Log.Errorf("[%s] fragment write error. err=%+v", m.UniqueKey, err)
 		return
 	}
+
+	if m.isLowLatency() {
+		m.partBuf = append(m.partBuf, tsPackets...)
+	}
 }
 
 // ---------------------------------------------------------------------------------------------------------------------
@@ -272,15 +336,27 @@ func (m *Muxer) openFragment(ts uint64, discont bool) error {
 
 	id := m.getFragmentId()
 
-	filename := PathStrategy.GetTsFileName(m.streamName, id, int(Clock.Now().UnixNano()/1e6))
+	var filename string
+	if m.isFmp4() {
+		// fmp4模式下，分片数据先缓存在内存中，关闭分片时再一次性写入文件
+		var err error
+		if discont, err = m.openFmp4Fragment(ts, discont); err != nil {
+			return err
+		}
+		filename = m.getFmp4SegmentFileName(id)
+	} else {
+		filename = PathStrategy.GetTsFileName(m.streamName, id, int(Clock.Now().UnixNano()/1e6))
+	}
 	filenameWithPath := PathStrategy.GetTsFileNameWithPath(m.outPath, filename)
 
-	if err := m.fragment.OpenFile(filenameWithPath); err != nil {
-		return err
-	}
+	if !m.isFmp4() {
+		if err := m.fragment.OpenFile(filenameWithPath); err != nil {
+			return err
+		}
 
-	if err := m.fragment.WriteFile(m.patpmt); err != nil {
-		return err
+		if err := m.fragment.WriteFile(m.patpmt); err != nil {
+			return err
+		}
 	}
 
 	m.opened = true
@@ -290,9 +366,21 @@ func (m *Muxer) openFragment(ts uint64, discont bool) error {
 	frag.id = id
 	frag.filename = filename
 	frag.duration = 0
+	frag.parts = nil
+	frag.startTs = ts
+	frag.size = 0
+	frag.programDateTime = Clock.Now()
+	if m.isFmp4() {
+		frag.initFilename = m.fmp4.initFilename
+	}
 
 	m.fragTs = ts
 
+	if m.isLowLatency() {
+		// 更新m3u8中的EXT-X-PRELOAD-HINT
+		m.writePlaylist(false)
+	}
+
 	// nrm said: start fragment with audio to make iPhone happy
 	m.observer.OnFragmentOpen()
 
@@ -319,7 +407,16 @@ func (m *Muxer) closeFragment(isLast bool) error {
 		return nil
 	}
 
-	if err := m.fragment.CloseFile(); err != nil {
+	if m.isLowLatency() {
+		m.closePart()
+	}
+
+	if m.isFmp4() {
+		if err := m.closeFmp4Fragment(); err != nil {
+			// 注意，即使写文件失败，也继续走后续流程，保证分片序号以及m3u8正常更新
+			Log.Errorf("[%s] write fmp4 fragment failed. err=%+v", m.UniqueKey, err)
+		}
+	} else if err := m.fragment.CloseFile(); err != nil {
 		return err
 	}
 
@@ -329,12 +426,22 @@ func (m *Muxer) closeFragment(isLast bool) error {
 	// 注意，后面使用序号的逻辑，都依赖该处
 	m.incrFrag()
 
-	m.writePlaylist(isLast)
+	// LL-HLS时，中途切换分片会立即调用openFragment，在openFragment中再更新m3u8，使得m3u8中总是有EXT-X-PRELOAD-HINT
+	if !m.isLowLatency() || isLast {
+		m.writePlaylist(isLast)
+	}
+	if m.isLowLatency() {
+		m.removeStaleParts()
+	}
 
-	if m.config.CleanupMode == CleanupModeNever || m.config.CleanupMode == CleanupModeInTheEnd {
+	// 开启DVR时，CleanupModeInTheEnd模式下的分片在移出DVR窗口后就会被删除，所以不再写record m3u8
+	if m.config.CleanupMode == CleanupModeNever || (m.config.CleanupMode == CleanupModeInTheEnd && !m.isDvr()) {
 		m.writeRecordPlaylist()
 	}
-	if m.config.CleanupMode == CleanupModeAsap {
+	if m.isDvr() {
+		// 开启DVR时，分片删除由DVR窗口决定
+		m.updateDvr(isLast)
+	} else if m.config.CleanupMode == CleanupModeAsap {
 		frag := m.getDeleteFrag()
 		if frag.filename != "" {
 			filenameWithPath := PathStrategy.GetTsFileNameWithPath(m.outPath, frag.filename)
@@ -379,6 +486,9 @@ func (m *Muxer) writeRecordPlaylist() {
 
 		if currFrag.discont {
 			content = append(content, []byte("#EXT-X-DISCONTINUITY\n")...)
+			if m.isFmp4() {
+				content = append(content, []byte(fmt.Sprintf("#EXT-X-MAP:URI=\"%s\"\n", currFrag.initFilename))...)
+			}
 		}
 
 		content = append(content, []byte(fragLines)...)
@@ -387,13 +497,16 @@ func (m *Muxer) writeRecordPlaylist() {
 		// m3u8文件不存在
 		var buf bytes.Buffer
 		buf.WriteString("#EXTM3U\n")
-		buf.WriteString("#EXT-X-VERSION:3\n")
+		buf.WriteString(fmt.Sprintf("#EXT-X-VERSION:%d\n", m.playlistVersion()))
 		buf.WriteString(fmt.Sprintf("#EXT-X-TARGETDURATION:%d\n", int(m.recordMaxFragDuration)))
 		buf.WriteString(fmt.Sprintf("#EXT-X-MEDIA-SEQUENCE:%d\n\n", 0))
 
 		if currFrag.discont {
 			buf.WriteString("#EXT-X-DISCONTINUITY\n")
 		}
+		if m.isFmp4() {
+			writeFmp4Map(&buf, currFrag)
+		}
 
 		buf.WriteString(fragLines)
 		buf.WriteString("#EXT-X-ENDLIST\n")
@@ -417,16 +530,31 @@ func (m *Muxer) writePlaylist(isLast bool) {
 
 	// TODO chef 优化这块buffer的构造
 	var buf bytes.Buffer
+	if m.isLowLatency() {
+		m.writeLowLatencyPlaylist(&buf, maxFrag, isLast)
+		if err := writeM3u8File(buf.Bytes(), m.playlistFilename, m.playlistFilenameBak); err != nil {
+			Log.Errorf("[%s] write live m3u8 file error. err=%+v", m.UniqueKey, err)
+		}
+		m.notifyPlaylistUpdated(isLast)
+		return
+	}
+
 	buf.WriteString("#EXTM3U\n")
-	buf.WriteString("#EXT-X-VERSION:3\n")
+	buf.WriteString(fmt.Sprintf("#EXT-X-VERSION:%d\n", m.playlistVersion()))
 	buf.WriteString("#EXT-X-ALLOW-CACHE:NO\n")
 	buf.WriteString(fmt.Sprintf("#EXT-X-TARGETDURATION:%d\n", int(maxFrag)))
 	buf.WriteString(fmt.Sprintf("#EXT-X-MEDIA-SEQUENCE:%d\n\n", m.extXMediaSeq()))
 
+	first := true
 	m.iterateFragsInPlaylist(func(frag *fragmentInfo) {
 		if frag.discont {
 			buf.WriteString("#EXT-X-DISCONTINUITY\n")
 		}
+		// fmp4模式下，init segment只在变化时才会更换，也即总是伴随着不连续标志
+		if m.isFmp4() && (first || frag.discont) {
+			writeFmp4Map(&buf, frag)
+		}
+		first = false
 
 		buf.WriteString(fmt.Sprintf("#EXTINF:%.3f,\n%s\n", frag.duration, frag.filename))
 	})
@@ -438,6 +566,10 @@ func (m *Muxer) writePlaylist(isLast bool) {
 	if err := writeM3u8File(buf.Bytes(), m.playlistFilename, m.playlistFilenameBak); err != nil {
 		Log.Errorf("[%s] write live m3u8 file error. err=%+v", m.UniqueKey, err)
 	}
+
+	if m.isDash() {
+		m.writeMpd(isLast)
+	}
 }
 
 func (m *Muxer) ensureDir() {
@@ -448,6 +580,17 @@ func (m *Muxer) ensureDir() {
 
 // ---------------------------------------------------------------------------------------------------------------------
 
+func (m *Muxer) isLowLatency() bool {
+	return m.config.LowLatencyEnable && m.config.PartDurationMs > 0 && !m.isFmp4()
+}
+
+func (m *Muxer) playlistVersion() int {
+	if m.isFmp4() {
+		return fmp4PlaylistVersion
+	}
+	return 3
+}
+
 func (m *Muxer) fragsCapacity() int {
 	return m.config.FragmentNum + m.config.DeleteThreshold + 1
 }
@@ -69,11 +69,39 @@ type IPathWriteStrategy interface {
 	GetTsFileName(streamName string, index int, timestamp int) string
 }
 
+// IFmp4PathWriteStrategy fmp4模式下的落盘策略
+//
+// 可选接口，如果 PathStrategy 没有实现该接口，则使用 DefaultPathStrategy 的实现
+type IFmp4PathWriteStrategy interface {
+	// GetFmp4InitFileName init segment文件名的生成策略，也即m3u8中EXT-X-MAP指向的文件
+	GetFmp4InitFileName(streamName string, timestamp int) string
+
+	// GetFmp4SegmentFileName m4s文件名的生成策略
+	GetFmp4SegmentFileName(streamName string, index int, timestamp int) string
+
+	// GetMpdFileName 获取单个流对应的DASH mpd文件路径
+	//
+	// @param outPath: func GetMuxerOutPath的结果
+	GetMpdFileName(outPath string, streamName string) string
+}
+
+// IDvrPathStrategy DVR时移的落盘策略
+//
+// 可选接口，如果 PathStrategy 没有实现该接口，则使用 DefaultPathStrategy 的实现
+type IDvrPathStrategy interface {
+	// GetDvrM3u8FileName 获取单个流对应的DVR m3u8文件路径
+	//
+	// @param outPath: func GetMuxerOutPath的结果
+	GetDvrM3u8FileName(outPath string, streamName string) string
+}
+
 // ---------------------------------------------------------------------------------------------------------------------
 
 const (
 	playlistM3u8FileName = "playlist.m3u8"
 	recordM3u8FileName   = "record.m3u8"
+	playlistMpdFileName  = "playlist.mpd"
+	dvrM3u8FileName      = "dvr.m3u8"
 )
 
 // DefaultPathStrategy 默认的路由，落盘策略
@@ -82,10 +110,17 @@ const (
 //
 // - playlist.m3u8              实时的HLS文件，定期刷新，写入当前最新的TS文件列表，淘汰过期的TS文件列表
 // - record.m3u8                录制回放的HLS文件，包含了从流开始至今的所有TS文件
+// - dvr.m3u8                   开启DVR时生成，包含时移窗口内的所有TS文件
 // - test110-1620540712084-0.ts TS分片文件，命名格式为{liveid}-{timestamp}-{index}.ts
 // - test110-1620540716095-1.ts
 // - ...                        一系列的TS文件
 //
+// fmp4模式下，TS文件替换为：
+//
+// - test110-1620540712084-init.mp4 init segment文件，命名格式为{liveid}-{timestamp}-init.mp4
+// - test110-1620540712084-0.m4s    fmp4分片文件，命名格式为{liveid}-{timestamp}-{index}.m4s
+// - playlist.mpd                   开启DASH时生成，与m3u8共用分片文件
+//
 // 假设
 // 流名称="test110"
 // rootPath="/tmp/lal/hls/"
@@ -98,6 +133,10 @@ const (
 // http://127.0.0.1:8080/hls/test110.m3u8                       -> /tmp/lal/hls/test110/playlist.m3u8
 // http://127.0.0.1:8080/hls/test110-1620540712084-0.ts         -> /tmp/lal/hls/test110/test110-1620540712084-0.ts
 // 最下面这两个做了特殊映射
+//
+// http://127.0.0.1:8080/hls/test110/playlist.mpd               -> /tmp/lal/hls/test110/playlist.mpd
+// http://127.0.0.1:8080/hls/test110.mpd                        -> /tmp/lal/hls/test110/playlist.mpd
+// m4s和mp4文件与ts文件的映射规则相同
 type DefaultPathStrategy struct {
 }
 
@@ -110,13 +149,15 @@ type DefaultPathStrategy struct {
 // /hls/test110/record.m3u8               -> record.m3u8               test110    m3u8     {rootOutPath}/test110/record.m3u8
 // /hls/test110/test110-1620540712084-.ts -> test110-1620540712084-.ts test110    ts       {rootOutPath/test110/test110-1620540712084-.ts
 // /hls/test110-1620540712084-.ts         -> test110-1620540712084-.ts test110    ts       {rootOutPath/test110/test110-1620540712084-.ts
+// /hls/test110.mpd                       -> test110.mpd               test110    mpd      {rootOutPath}/test110/playlist.mpd
+// /hls/test110/playlist.mpd              -> playlist.mpd              test110    mpd      {rootOutPath}/test110/playlist.mpd
 func (dps *DefaultPathStrategy) GetRequestInfo(urlCtx base.UrlContext, rootOutPath string) (ri RequestInfo) {
 	filename := urlCtx.LastItemOfPath
 	filetype := urlCtx.GetFileType()
 	fileNameWithoutType := urlCtx.GetFilenameWithoutType()
 
 	if filetype == "m3u8" {
-		if filename == playlistM3u8FileName || filename == recordM3u8FileName {
+		if filename == playlistM3u8FileName || filename == recordM3u8FileName || filename == dvrM3u8FileName {
 			uriItems := strings.Split(urlCtx.Path, "/")
 			ri.StreamName = uriItems[len(uriItems)-2]
 			ri.FileNameWithPath = filepath.Join(rootOutPath, ri.StreamName, filename)
@@ -124,7 +165,15 @@ func (dps *DefaultPathStrategy) GetRequestInfo(urlCtx base.UrlContext, rootOutPa
 			ri.StreamName = fileNameWithoutType
 			ri.FileNameWithPath = filepath.Join(rootOutPath, ri.StreamName, playlistM3u8FileName)
 		}
-	} else if filetype == "ts" {
+	} else if filetype == "mpd" {
+		if filename == playlistMpdFileName {
+			uriItems := strings.Split(urlCtx.Path, "/")
+			ri.StreamName = uriItems[len(uriItems)-2]
+		} else {
+			ri.StreamName = fileNameWithoutType
+		}
+		ri.FileNameWithPath = filepath.Join(rootOutPath, ri.StreamName, playlistMpdFileName)
+	} else if filetype == "ts" || filetype == "m4s" || filetype == "mp4" {
 		ri.StreamName = dps.getStreamNameFromTsFileName(filename)
 		ri.FileNameWithPath = filepath.Join(rootOutPath, ri.StreamName, filename)
 	}
@@ -145,6 +194,10 @@ func (*DefaultPathStrategy) GetRecordM3u8FileName(outPath string, streamName str
 	return filepath.Join(outPath, recordM3u8FileName)
 }
 
+func (*DefaultPathStrategy) GetDvrM3u8FileName(outPath string, streamName string) string {
+	return filepath.Join(outPath, dvrM3u8FileName)
+}
+
 func (*DefaultPathStrategy) GetTsFileNameWithPath(outPath string, fileName string) string {
 	return filepath.Join(outPath, fileName)
 }
@@ -153,6 +206,18 @@ func (*DefaultPathStrategy) GetTsFileName(streamName string, index int, timestam
 	return fmt.Sprintf("%s-%d-%d.ts", streamName, timestamp, index)
 }
 
+func (*DefaultPathStrategy) GetFmp4InitFileName(streamName string, timestamp int) string {
+	return fmt.Sprintf("%s-%d-init.mp4", streamName, timestamp)
+}
+
+func (*DefaultPathStrategy) GetFmp4SegmentFileName(streamName string, index int, timestamp int) string {
+	return fmt.Sprintf("%s-%d-%d.m4s", streamName, timestamp, index)
+}
+
+func (*DefaultPathStrategy) GetMpdFileName(outPath string, streamName string) string {
+	return filepath.Join(outPath, playlistMpdFileName)
+}
+
 func (*DefaultPathStrategy) getStreamNameFromTsFileName(fileName string) string {
 	sum := 0
 	index := strings.LastIndexFunc(fileName, func(r rune) bool {
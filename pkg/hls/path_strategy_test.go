@@ -96,6 +96,34 @@ func TestDefaultPathStrategy_GetRequestInfo(t *testing.T) {
 			wantFileNameWithPath:    "/tmp/lal/hls/中文测试-1620540712084.ts/中文测试-1620540712084.ts",
 			wantWinFileNameWithPath: "\\tmp\\lal\\hls\\中文测试-1620540712084.ts\\中文测试-1620540712084.ts",
 		},
+		{
+			name:                    "11 hls/[].mpd 格式测试",
+			url:                     "http://127.0.0.1:8080/hls/test11.mpd",
+			wantStreamName:          "test11",
+			wantFileNameWithPath:    "/tmp/lal/hls/test11/playlist.mpd",
+			wantWinFileNameWithPath: "\\tmp\\lal\\hls\\test11\\playlist.mpd",
+		},
+		{
+			name:                    "12 hls/[]/playlist.mpd 格式测试",
+			url:                     "http://127.0.0.1:8080/hls/test12/playlist.mpd",
+			wantStreamName:          "test12",
+			wantFileNameWithPath:    "/tmp/lal/hls/test12/playlist.mpd",
+			wantWinFileNameWithPath: "\\tmp\\lal\\hls\\test12\\playlist.mpd",
+		},
+		{
+			name:                    "13 hls/[]/[]-timestamp-seq.m4s 格式测试",
+			url:                     "http://127.0.0.1:8080/hls/test13/test13-1620540712084-0.m4s",
+			wantStreamName:          "test13",
+			wantFileNameWithPath:    "/tmp/lal/hls/test13/test13-1620540712084-0.m4s",
+			wantWinFileNameWithPath: "\\tmp\\lal\\hls\\test13\\test13-1620540712084-0.m4s",
+		},
+		{
+			name:                    "14 hls/[]-timestamp-init.mp4 格式测试",
+			url:                     "http://127.0.0.1:8080/hls/test14-1620540712084-init.mp4",
+			wantStreamName:          "test14",
+			wantFileNameWithPath:    "/tmp/lal/hls/test14/test14-1620540712084-init.mp4",
+			wantWinFileNameWithPath: "\\tmp\\lal\\hls\\test14\\test14-1620540712084-init.mp4",
+		},
 	}
 
 	dps := &hls.DefaultPathStrategy{}
@@ -15,6 +15,7 @@ import (
 	"github.com/q191201771/lal/pkg/util"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -87,7 +88,7 @@ func (s *ServerHandler) ServeHTTPWithUrlCtx(resp http.ResponseWriter, req *http.
 	// 如果开启了hls sub session功能
 	if s.isSubSessionModeEnable() {
 		sessionIdHash = urlObj.Query().Get("session_id")
-		if filetype == "ts" && sessionIdHash != "" {
+		if isSegmentFileType(filetype) && sessionIdHash != "" {
 			// 注意，为了增强容错性，不管是session_id字段无效，还是session_id为空，我们都依然返回ts文件内容给播放端
 			if sessionIdHash != "" {
 				err = s.keepSessionAlive(sessionIdHash)
@@ -126,14 +127,57 @@ func (s *ServerHandler) ServeHTTPWithUrlCtx(resp http.ResponseWriter, req *http.
 	ri := PathStrategy.GetRequestInfo(urlCtx, s.outPath)
 	//Log.Debugf("%+v", ri)
 
-	if filename == "" || (filetype != "m3u8" && filetype != "ts") || ri.StreamName == "" || ri.FileNameWithPath == "" {
+	if filename == "" || (filetype != "m3u8" && filetype != "mpd" && !isSegmentFileType(filetype)) || ri.StreamName == "" || ri.FileNameWithPath == "" {
 		err = errors.New(fmt.Sprintf("invalid hls request. url=%+v, request=%+v", urlCtx, ri))
 		Log.Warnf(err.Error())
 		resp.WriteHeader(http.StatusFound)
 		return
 	}
 
-	content, _err := ReadFile(ri.FileNameWithPath)
+	// DVR时移请求
+	// `offset`是相对当前时间的，先跳转为绝对时间的`start`，否则EVENT类型的列表每次刷新时起始位置都会变化
+	dvr := filetype == "m3u8" && isDvrRequest(urlObj.Query())
+	if dvr && urlObj.Query().Get("offset") != "" {
+		query := urlObj.Query()
+		offset, _err := parseDvrOffset(query.Get("offset"))
+		if _err != nil || offset > 0 {
+			Log.Warnf("invalid dvr offset. url=%s", urlCtx.Url)
+			resp.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		query.Del("offset")
+		query.Set("start", strconv.FormatInt(Clock.Now().Add(offset).Unix(), 10))
+		redirectUrl := urlObj.Path + "?" + query.Encode()
+		resp.Header().Add("Access-Control-Allow-Origin", "*")
+		http.Redirect(resp, req, redirectUrl, http.StatusFound)
+		return
+	}
+
+	// LL-HLS的阻塞请求
+	if filetype == "m3u8" && !dvr {
+		if code := waitBlockingPlaylist(urlObj.Query(), ri.FileNameWithPath); code != http.StatusOK {
+			Log.Warnf("blocking playlist request failed. url=%s, code=%d", urlCtx.Url, code)
+			resp.WriteHeader(code)
+			return
+		}
+	}
+
+	var (
+		content []byte
+		_err    error
+	)
+	if dvr {
+		var code int
+		if content, code = s.readDvrPlaylist(urlObj.Query(), ri.StreamName); code != http.StatusOK {
+			Log.Warnf("dvr playlist request failed. url=%s, code=%d", urlCtx.Url, code)
+			resp.WriteHeader(code)
+			return
+		}
+	} else if filetype == "ts" && isPartFileName(filename) {
+		content, _err = partFslCtx.ReadFile(ri.FileNameWithPath)
+	} else {
+		content, _err = ReadFile(ri.FileNameWithPath)
+	}
 	if _err != nil {
 		err = errors.New(fmt.Sprintf("read hls file failed. request=%+v, err=%+v", ri, _err))
 		Log.Warnf(err.Error())
@@ -148,6 +192,8 @@ func (s *ServerHandler) ServeHTTPWithUrlCtx(resp http.ResponseWriter, req *http.
 		// 给ts文件都携带上session_id字段
 		if sessionIdHash != "" {
 			content = bytes.ReplaceAll(content, []byte(".ts"), []byte(".ts?session_id="+sessionIdHash))
+			content = bytes.ReplaceAll(content, []byte(".m4s"), []byte(".m4s?session_id="+sessionIdHash))
+			content = bytes.ReplaceAll(content, []byte(".mp4\""), []byte(".mp4?session_id="+sessionIdHash+"\""))
 		}
 		if s.BeforeWriteM3u8 != nil {
 			content, err = s.BeforeWriteM3u8(ri.StreamName, req.Header, content)
@@ -162,6 +208,17 @@ func (s *ServerHandler) ServeHTTPWithUrlCtx(resp http.ResponseWriter, req *http.
 		resp.Header().Add("Content-Type", "video/mp2t")
 		resp.Header().Add("Server", base.LalHlsTsServer)
 		resp.Header().Add("Cache-Control", "max-age=3600")
+	case "m4s":
+		resp.Header().Add("Content-Type", "video/iso.segment")
+		resp.Header().Add("Server", base.LalHlsTsServer)
+		resp.Header().Add("Cache-Control", "max-age=3600")
+	case "mp4":
+		resp.Header().Add("Content-Type", "video/mp4")
+		resp.Header().Add("Server", base.LalHlsTsServer)
+		resp.Header().Add("Cache-Control", "max-age=3600")
+	case "mpd":
+		resp.Header().Add("Content-Type", "application/dash+xml")
+		resp.Header().Add("Server", base.LalHlsM3u8Server)
 	}
 
 	resp.Header().Add("Access-Control-Allow-Origin", "*")
@@ -186,6 +243,62 @@ func (s *ServerHandler) ServeHTTPWithUrlCtx(resp http.ResponseWriter, req *http.
 	return
 }
 
+// isSegmentFileType ts分片，以及fmp4模式下的m4s分片和init segment
+func isSegmentFileType(filetype string) bool {
+	return filetype == "ts" || filetype == "m4s" || filetype == "mp4"
+}
+
+// waitBlockingPlaylist 如果请求中携带了`_HLS_msn`，则阻塞直到m3u8中包含请求的分片
+//
+// @return 应该返回给播放端的HTTP状态码，http.StatusOK表示可以继续读取m3u8并返回
+func waitBlockingPlaylist(query url.Values, playlistFilename string) int {
+	msnStr, partStr := query.Get("_HLS_msn"), query.Get("_HLS_part")
+	if msnStr == "" {
+		if partStr != "" {
+			return http.StatusBadRequest
+		}
+		return http.StatusOK
+	}
+
+	msn, err := strconv.Atoi(msnStr)
+	if err != nil || msn < 0 {
+		return http.StatusBadRequest
+	}
+	part := -1
+	if partStr != "" {
+		if part, err = strconv.Atoi(partStr); err != nil || part < 0 {
+			return http.StatusBadRequest
+		}
+	}
+
+	switch blockingReloadHub.wait(playlistFilename, msn, part) {
+	case blockingResultBadRequest:
+		return http.StatusBadRequest
+	case blockingResultTimeout:
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusOK
+}
+
+// readDvrPlaylist 根据请求中的`start`、`end`参数生成时移播放列表
+//
+// @return 应该返回给播放端的HTTP状态码，http.StatusOK表示content有效
+func (s *ServerHandler) readDvrPlaylist(query url.Values, streamName string) (content []byte, code int) {
+	start, end, err := parseDvrTimeRange(query)
+	if err != nil {
+		return nil, http.StatusBadRequest
+	}
+	outPath := PathStrategy.GetMuxerOutPath(s.outPath, streamName)
+	dvrContent, err := ReadFile(dvrPathStrategy().GetDvrM3u8FileName(outPath, streamName))
+	if err != nil {
+		return nil, http.StatusNotFound
+	}
+	if content, err = buildDvrPlaylist(dvrContent, start, end); err != nil {
+		return nil, http.StatusNotFound
+	}
+	return content, http.StatusOK
+}
+
 // getSubSession 获取 SubSession，如果不存在，返回nil
 func (s *ServerHandler) getSubSession(sessionIdHash string) *SubSession {
 	s.mutex.Lock()
@@ -0,0 +1,263 @@
+// Copyright 2023, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package httpflv
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+
+	"github.com/q191201771/lal/pkg/base"
+)
+
+// 点播使用的flv文件
+//
+// FlvVodIndex 扫描一次文件，只记录seek点以及seq header在文件中的位置，不保存tag数据，
+// 同一个文件的多个播放端可以共用一个索引。
+// FlvVodFile 每个播放端一个，按照索引从文件中逐个读取tag。
+//
+// 注意，读取出的tag的时间戳都会被修改为以0开始
+
+// flvVodAudioSeekIntervalMs 纯音频文件没有关键帧，每隔这么长时间记录一个音频tag作为seek点
+const flvVodAudioSeekIntervalMs = 1000
+
+// flvVodProbeSize 判断tag类型需要读取的payload的长度
+const flvVodProbeSize = 5
+
+const (
+	flvVodSeqHeaderMetadata = iota
+	flvVodSeqHeaderVideo
+	flvVodSeqHeaderAudio
+)
+
+type flvVodPoint struct {
+	offset int64  // tag在文件中的位置
+	ts     uint32 // 修改后的时间戳
+	kind   int    // 只有seq header使用
+}
+
+type FlvVodIndex struct {
+	size       int64  // 索引覆盖的文件长度，建立索引时不完整的tag（比如还在录制中的文件）不会被读取
+	baseTs     uint32 // 第一个非metadata tag的原始时间戳
+	durationMs uint32
+
+	seekPoints []flvVodPoint // 视频关键帧，纯音频文件则是音频tag
+	seqHeaders []flvVodPoint // metadata，视频seq header，音频seq header
+}
+
+// NewFlvVodIndex 读取flv文件的所有tag header建立索引，tag body不读取
+//
+// @param r:    flv文件，包含flv header
+// @param size: 文件长度
+func NewFlvVodIndex(r io.ReaderAt, size int64) (*FlvVodIndex, error) {
+	header := make([]byte, flvHeaderSize)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(header[:3], FlvHeader[:3]) {
+		return nil, base.ErrFlvFileInvalid
+	}
+
+	idx := &FlvVodIndex{
+		size: int64(flvHeaderSize),
+	}
+
+	var (
+		hasBaseTs   bool
+		audioPoints []flvVodPoint
+		lastAudioTs uint32
+	)
+	br := bufio.NewReader(io.NewSectionReader(r, int64(flvHeaderSize), size-int64(flvHeaderSize)))
+	probe := Tag{Raw: make([]byte, TagHeaderSize+flvVodProbeSize)}
+	for {
+		offset := idx.size
+		if _, err := io.ReadFull(br, probe.Raw[:TagHeaderSize]); err != nil {
+			break
+		}
+		probe.Header = parseTagHeader(probe.Raw)
+		tagSize := int64(TagHeaderSize) + int64(probe.Header.DataSize) + int64(PrevTagSizeFieldSize)
+		if offset+tagSize > size {
+			break
+		}
+
+		// payload不足 flvVodProbeSize 的部分填充0xff，避免被误判为seq header
+		n := int(probe.Header.DataSize)
+		if n > flvVodProbeSize {
+			n = flvVodProbeSize
+		}
+		if _, err := io.ReadFull(br, probe.Raw[TagHeaderSize:TagHeaderSize+n]); err != nil {
+			break
+		}
+		for i := TagHeaderSize + n; i < len(probe.Raw); i++ {
+			probe.Raw[i] = 0xff
+		}
+		if _, err := br.Discard(int(tagSize) - TagHeaderSize - n); err != nil {
+			break
+		}
+		idx.size = offset + tagSize
+
+		isMetadata := probe.IsMetadata()
+		if !isMetadata && !hasBaseTs {
+			hasBaseTs = true
+			idx.baseTs = probe.Header.Timestamp
+		}
+		ts := idx.rebase(probe.Header.Timestamp, isMetadata)
+		if ts > idx.durationMs {
+			idx.durationMs = ts
+		}
+
+		switch {
+		case isMetadata:
+			idx.seqHeaders = append(idx.seqHeaders, flvVodPoint{offset: offset, ts: ts, kind: flvVodSeqHeaderMetadata})
+		case probe.IsVideoKeySeqHeader():
+			idx.seqHeaders = append(idx.seqHeaders, flvVodPoint{offset: offset, ts: ts, kind: flvVodSeqHeaderVideo})
+		case probe.IsAudioSeqHeader():
+			idx.seqHeaders = append(idx.seqHeaders, flvVodPoint{offset: offset, ts: ts, kind: flvVodSeqHeaderAudio})
+		case probe.IsVideoKeyNalu():
+			idx.seekPoints = append(idx.seekPoints, flvVodPoint{offset: offset, ts: ts})
+		case probe.Header.Type == TagTypeAudio:
+			if len(audioPoints) == 0 || ts < lastAudioTs || ts-lastAudioTs >= flvVodAudioSeekIntervalMs {
+				audioPoints = append(audioPoints, flvVodPoint{offset: offset, ts: ts})
+				lastAudioTs = ts
+			}
+		}
+	}
+
+	if len(idx.seekPoints) == 0 {
+		idx.seekPoints = audioPoints
+	}
+	return idx, nil
+}
+
+// Size 索引覆盖的文件长度
+func (idx *FlvVodIndex) Size() int64 {
+	return idx.size
+}
+
+// DurationMs 文件的时长，单位毫秒
+func (idx *FlvVodIndex) DurationMs() uint32 {
+	return idx.durationMs
+}
+
+// seek 找到离ms最近的seek点
+func (idx *FlvVodIndex) seek(ms uint32) (point flvVodPoint, ok bool) {
+	if ms > idx.durationMs || len(idx.seekPoints) == 0 {
+		return
+	}
+
+	point = idx.seekPoints[0]
+	for _, p := range idx.seekPoints[1:] {
+		if absDiff(p.ts, ms) < absDiff(point.ts, ms) {
+			point = p
+		}
+	}
+	return point, true
+}
+
+func (idx *FlvVodIndex) rebase(ts uint32, isMetadata bool) uint32 {
+	if isMetadata || ts < idx.baseTs {
+		return 0
+	}
+	return ts - idx.baseTs
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+type FlvVodFile struct {
+	index *FlvVodIndex
+	r     io.ReaderAt
+
+	br      *bufio.Reader
+	seekPos int64 // 最近一次seek的位置
+}
+
+// NewFlvVodFile 创建后从文件的第一个tag开始读取
+//
+// @param r:     flv文件，注意，生命周期由调用方管理
+// @param index: 该文件的索引
+func NewFlvVodFile(r io.ReaderAt, index *FlvVodIndex) *FlvVodFile {
+	f := &FlvVodFile{
+		index: index,
+		r:     r,
+	}
+	f.resetTo(int64(flvHeaderSize))
+	return f
+}
+
+// DurationMs 文件的时长，单位毫秒
+func (f *FlvVodFile) DurationMs() uint32 {
+	return f.index.DurationMs()
+}
+
+// Seek 跳转到离ms最近的视频关键帧，纯音频文件则是离ms最近的音频tag
+//
+// @return ts: seek点的时间戳
+// @return ok: 如果ms超过了文件时长，返回false，之后 ReadTag 将返回 io.EOF
+func (f *FlvVodFile) Seek(ms uint32) (ts uint32, ok bool) {
+	point, ok := f.index.seek(ms)
+	if !ok {
+		f.resetTo(f.index.size)
+		return 0, false
+	}
+	f.resetTo(point.offset)
+	return point.ts, true
+}
+
+// SeqHeaders 获取最近一次seek的位置之前最新的metadata，视频seq header，音频seq header，seek后需要先发送这些tag
+//
+// @param ts: 返回的tag的时间戳
+func (f *FlvVodFile) SeqHeaders(ts uint32) (tags []Tag, err error) {
+	latest := make(map[int]flvVodPoint)
+	for _, p := range f.index.seqHeaders {
+		if p.offset >= f.seekPos {
+			break
+		}
+		latest[p.kind] = p
+	}
+	for _, kind := range []int{flvVodSeqHeaderMetadata, flvVodSeqHeaderVideo, flvVodSeqHeaderAudio} {
+		p, ok := latest[kind]
+		if !ok {
+			continue
+		}
+		tag, err := ReadTag(io.NewSectionReader(f.r, p.offset, f.index.size-p.offset))
+		if err != nil {
+			return nil, err
+		}
+		tag.ModTagTimestamp(ts)
+		tags = append(tags, tag)
+	}
+	return
+}
+
+// ReadTag 读取下一个tag，时间戳已修改为以0开始
+//
+// @return err: 索引范围内的tag都读取完毕时返回 io.EOF
+func (f *FlvVodFile) ReadTag() (tag Tag, err error) {
+	tag, err = ReadTag(f.br)
+	if err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
+		return
+	}
+	tag.ModTagTimestamp(f.index.rebase(tag.Header.Timestamp, tag.IsMetadata()))
+	return
+}
+
+func (f *FlvVodFile) resetTo(pos int64) {
+	f.seekPos = pos
+	f.br = bufio.NewReader(io.NewSectionReader(f.r, pos, f.index.size-pos))
+}
+
+func absDiff(a, b uint32) uint32 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
@@ -52,4 +52,15 @@ const (
 
 const (
 	SoundFormatAac uint8 = 10
+
+	soundFormatExHeader uint8 = 9 // Enhanced RTMP的ExAudioTagHeader，见 base.RtmpSoundFormatExHeader
+)
+
+// Enhanced RTMP的ExVideoTagHeader，见 base.RtmpExHeaderFlag
+const (
+	exHeaderFlag uint8 = 0x80
+
+	exPacketTypeSequenceStart uint8 = 0
+	exPacketTypeCodedFrames   uint8 = 1
+	exPacketTypeCodedFramesX  uint8 = 3
 )
@@ -9,7 +9,9 @@
 package httpflv_test
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"os"
 	"testing"
 
@@ -57,3 +59,73 @@ func TestFlvFilePump(t *testing.T) {
 	assert.Equal(t, "ab7f75d2491711cc9a8d0ccd5d56280b", nazamd5.Md5(allRaw))
 	assert.Equal(t, "2a1cd1bd99f725c19bbd45d81d436e59", nazamd5.Md5(allHeader))
 }
+
+func TestFlvVodFile(t *testing.T) {
+	// 时间戳从1000开始，每秒一个关键帧，共5秒
+	buf := append([]byte{}, httpflv.FlvHeader...)
+	buf = append(buf, httpflv.PackHttpflvTag(httpflv.TagTypeMetadata, 0, []byte{0x02})...)
+	buf = append(buf, httpflv.PackHttpflvTag(httpflv.TagTypeVideo, 1000, []byte{httpflv.AvcKeyFrame, httpflv.AvcPacketTypeSeqHeader})...)
+	buf = append(buf, httpflv.PackHttpflvTag(httpflv.TagTypeAudio, 1000, []byte{0xaf, httpflv.AacPacketTypeSeqHeader})...)
+	for ts := uint32(1000); ts < 6000; ts += 100 {
+		if ts%1000 == 0 {
+			buf = append(buf, httpflv.PackHttpflvTag(httpflv.TagTypeVideo, ts, []byte{httpflv.AvcKeyFrame, httpflv.AvcPacketTypeNalu})...)
+		} else {
+			buf = append(buf, httpflv.PackHttpflvTag(httpflv.TagTypeVideo, ts, []byte{httpflv.AvcInterFrame, httpflv.AvcPacketTypeNalu})...)
+		}
+		buf = append(buf, httpflv.PackHttpflvTag(httpflv.TagTypeAudio, ts, []byte{0xaf, httpflv.AacPacketTypeRaw})...)
+	}
+	full := len(buf)
+	// 模拟还在录制中的文件，末尾不完整的tag不被索引
+	buf = append(buf, httpflv.PackHttpflvTag(httpflv.TagTypeVideo, 6000, []byte{httpflv.AvcKeyFrame, httpflv.AvcPacketTypeNalu})[:8]...)
+
+	index, err := httpflv.NewFlvVodIndex(bytes.NewReader(buf), int64(len(buf)))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, uint32(4900), index.DurationMs())
+	assert.Equal(t, int64(full), index.Size())
+
+	f := httpflv.NewFlvVodFile(bytes.NewReader(buf), index)
+	var n int
+	for {
+		tag, err := f.ReadTag()
+		if err != nil {
+			assert.Equal(t, io.EOF, err)
+			break
+		}
+		if n == 3 {
+			assert.Equal(t, uint32(0), tag.Header.Timestamp)
+		}
+		n++
+	}
+	assert.Equal(t, 103, n)
+
+	// 离目标位置最近的关键帧
+	for _, item := range []struct {
+		ms     uint32
+		expect uint32
+	}{{0, 0}, {400, 0}, {600, 1000}, {2300, 2000}, {4900, 4000}} {
+		ts, ok := f.Seek(item.ms)
+		assert.Equal(t, true, ok)
+		assert.Equal(t, item.expect, ts)
+		tag, err := f.ReadTag()
+		assert.Equal(t, nil, err)
+		assert.Equal(t, true, tag.IsVideoKeyNalu())
+		assert.Equal(t, item.expect, tag.Header.Timestamp)
+	}
+	_, ok := f.Seek(5000)
+	assert.Equal(t, false, ok)
+	_, err = f.ReadTag()
+	assert.Equal(t, io.EOF, err)
+
+	_, _ = f.Seek(2000)
+	headers, err := f.SeqHeaders(2000)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 3, len(headers))
+	assert.Equal(t, true, headers[0].IsMetadata())
+	assert.Equal(t, true, headers[1].IsVideoKeySeqHeader())
+	assert.Equal(t, true, headers[2].IsAacSeqHeader())
+	assert.Equal(t, uint32(2000), headers[1].Header.Timestamp)
+
+	// 非flv文件
+	_, err = httpflv.NewFlvVodIndex(bytes.NewReader(make([]byte, 16)), 16)
+	assert.IsNotNil(t, err)
+}
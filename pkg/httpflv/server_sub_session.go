@@ -80,6 +80,10 @@ func (session *SubSession) Write(b []byte) {
 	session.core.Write(b)
 }
 
+func (session *SubSession) Flush() error {
+	return session.core.Flush()
+}
+
 // ---------------------------------------------------------------------------------------------------------------------
 // IObject interface
 // ---------------------------------------------------------------------------------------------------------------------
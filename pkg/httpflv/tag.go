@@ -51,9 +51,9 @@ func (tag *Tag) IsHevcKeySeqHeader() bool {
 	return tag.Header.Type == TagTypeVideo && tag.Raw[TagHeaderSize] == HevcKeyFrame && tag.Raw[TagHeaderSize+1] == HevcPacketTypeSeqHeader
 }
 
-// IsVideoKeySeqHeader AVC或HEVC的seq header
+// IsVideoKeySeqHeader AVC或HEVC的seq header，或者Enhanced RTMP格式的seq header
 func (tag *Tag) IsVideoKeySeqHeader() bool {
-	return tag.IsAvcKeySeqHeader() || tag.IsHevcKeySeqHeader()
+	return tag.IsAvcKeySeqHeader() || tag.IsHevcKeySeqHeader() || tag.IsEnhancedKeySeqHeader()
 }
 
 func (tag *Tag) IsAvcKeyNalu() bool {
@@ -64,15 +64,52 @@ func (tag *Tag) IsHevcKeyNalu() bool {
 	return tag.Header.Type == TagTypeVideo && tag.Raw[TagHeaderSize] == HevcKeyFrame && tag.Raw[TagHeaderSize+1] == HevcPacketTypeNalu
 }
 
-// IsVideoKeyNalu AVC或HEVC的关键帧
+// IsVideoKeyNalu AVC或HEVC的关键帧，或者Enhanced RTMP格式的关键帧
 func (tag *Tag) IsVideoKeyNalu() bool {
-	return tag.IsAvcKeyNalu() || tag.IsHevcKeyNalu()
+	return tag.IsAvcKeyNalu() || tag.IsHevcKeyNalu() || tag.IsEnhancedKeyNalu()
+}
+
+// IsEnhanced 是否为Enhanced RTMP格式的视频tag，也即使用了ExVideoTagHeader
+//
+// 注意，IsAvcXxx，IsHevcXxx系列函数只判断传统格式，不包含Enhanced RTMP格式
+func (tag *Tag) IsEnhanced() bool {
+	return tag.Header.Type == TagTypeVideo && tag.Header.DataSize >= 5 && tag.Raw[TagHeaderSize]&exHeaderFlag != 0
+}
+
+// VideoFourCc Enhanced RTMP格式时返回FourCC，比如`hvc1`，`av01`，`vp09`，否则返回空字符串
+func (tag *Tag) VideoFourCc() string {
+	if !tag.IsEnhanced() {
+		return ""
+	}
+	return string(tag.Raw[TagHeaderSize+1 : TagHeaderSize+5])
+}
+
+func (tag *Tag) IsEnhancedKeySeqHeader() bool {
+	return tag.IsEnhanced() && (tag.Raw[TagHeaderSize]>>4)&0x7 == frameTypeKey && tag.Raw[TagHeaderSize]&0xF == exPacketTypeSequenceStart
+}
+
+func (tag *Tag) IsEnhancedKeyNalu() bool {
+	if !tag.IsEnhanced() || (tag.Raw[TagHeaderSize]>>4)&0x7 != frameTypeKey {
+		return false
+	}
+	pt := tag.Raw[TagHeaderSize] & 0xF
+	return pt == exPacketTypeCodedFrames || pt == exPacketTypeCodedFramesX
 }
 
 func (tag *Tag) IsAacSeqHeader() bool {
 	return tag.Header.Type == TagTypeAudio && tag.Raw[TagHeaderSize]>>4 == SoundFormatAac && tag.Raw[TagHeaderSize+1] == AacPacketTypeSeqHeader
 }
 
+func (tag *Tag) IsEnhancedAudioSeqHeader() bool {
+	return tag.Header.Type == TagTypeAudio && tag.Header.DataSize >= 5 &&
+		tag.Raw[TagHeaderSize]>>4 == soundFormatExHeader && tag.Raw[TagHeaderSize]&0xF == exPacketTypeSequenceStart
+}
+
+// IsAudioSeqHeader AAC的seq header，或者Enhanced RTMP格式的音频seq header
+func (tag *Tag) IsAudioSeqHeader() bool {
+	return tag.IsAacSeqHeader() || tag.IsEnhancedAudioSeqHeader()
+}
+
 func (tag *Tag) clone() (out Tag) {
 	out.Header = tag.Header
 	out.Raw = append(out.Raw, tag.Raw...)
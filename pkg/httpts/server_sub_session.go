@@ -21,9 +21,10 @@ type SubSession struct {
 	core               *base.BasicHttpSubSession
 	IsFresh            bool
 	ShouldWaitBoundary bool
+	header             map[string][]string
 }
 
-func NewSubSession(conn net.Conn, urlCtx base.UrlContext, isWebSocket bool, websocketKey string) *SubSession {
+func NewSubSession(conn net.Conn, urlCtx base.UrlContext, isWebSocket bool, websocketKey string, header map[string][]string) *SubSession {
 	s := &SubSession{
 		core: base.NewBasicHttpSubSession(base.BasicHttpSubSessionOption{
 			Conn: conn,
@@ -38,6 +39,7 @@ func NewSubSession(conn net.Conn, urlCtx base.UrlContext, isWebSocket bool, webs
 		}),
 		IsFresh:            true,
 		ShouldWaitBoundary: true,
+		header:             header,
 	}
 	Log.Infof("[%s] lifecycle new httpts SubSession. session=%p, remote addr=%s", s.UniqueKey(), s, conn.RemoteAddr().String())
 	return s
@@ -83,7 +85,7 @@ func (session *SubSession) Url() string {
 	return session.core.Url()
 }
 func (session *SubSession) Header() map[string][]string {
-	return nil
+	return session.header
 }
 
 func (session *SubSession) AppName() string {
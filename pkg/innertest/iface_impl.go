@@ -17,6 +17,8 @@ import (
 	"github.com/q191201771/lal/pkg/remux"
 	"github.com/q191201771/lal/pkg/rtmp"
 	"github.com/q191201771/lal/pkg/rtsp"
+	"github.com/q191201771/lal/pkg/srt"
+	"github.com/q191201771/lal/pkg/webrtc"
 	"github.com/q191201771/naza/pkg/connection"
 )
 
@@ -29,6 +31,10 @@ var (
 	_ base.ISession = &rtsp.SubSession{}
 	_ base.ISession = &httpflv.SubSession{}
 	_ base.ISession = &httpts.SubSession{}
+	_ base.ISession = &srt.PubSession{}
+	_ base.ISession = &srt.SubSession{}
+	_ base.ISession = &webrtc.PubSession{}
+	_ base.ISession = &webrtc.SubSession{}
 
 	_ base.ISession = &rtmp.PushSession{}
 	_ base.ISession = &rtmp.PullSession{}
@@ -53,6 +59,10 @@ var (
 	_ base.IServerSession = &rtsp.SubSession{}
 	_ base.IServerSession = &httpflv.SubSession{}
 	_ base.IServerSession = &httpts.SubSession{}
+	_ base.IServerSession = &srt.PubSession{}
+	_ base.IServerSession = &srt.SubSession{}
+	_ base.IServerSession = &webrtc.PubSession{}
+	_ base.IServerSession = &webrtc.SubSession{}
 )
 
 // IClientSessionLifecycle: 所有Client Session都满足
@@ -77,6 +87,10 @@ var (
 	_ base.IServerSessionLifecycle = &rtsp.SubSession{}
 	_ base.IServerSessionLifecycle = &httpflv.SubSession{}
 	_ base.IServerSessionLifecycle = &httpts.SubSession{}
+	_ base.IServerSessionLifecycle = &srt.PubSession{}
+	_ base.IServerSessionLifecycle = &srt.SubSession{}
+	_ base.IServerSessionLifecycle = &webrtc.PubSession{}
+	_ base.IServerSessionLifecycle = &webrtc.SubSession{}
 
 	// other
 	_ base.IServerSessionLifecycle = &base.BasicHttpSubSession{}
@@ -97,6 +111,10 @@ var (
 	_ base.ISessionStat = &rtsp.SubSession{}
 	_ base.ISessionStat = &httpflv.SubSession{}
 	_ base.ISessionStat = &httpts.SubSession{}
+	_ base.ISessionStat = &srt.PubSession{}
+	_ base.ISessionStat = &srt.SubSession{}
+	_ base.ISessionStat = &webrtc.PubSession{}
+	_ base.ISessionStat = &webrtc.SubSession{}
 	// other
 	_ base.ISessionStat = &base.BasicHttpSubSession{}
 	_ base.ISessionStat = &rtmp.ClientSession{}
@@ -119,6 +137,10 @@ var (
 	_ base.ISessionUrlContext = &rtsp.SubSession{}
 	_ base.ISessionUrlContext = &httpflv.SubSession{}
 	_ base.ISessionUrlContext = &httpts.SubSession{}
+	_ base.ISessionUrlContext = &srt.PubSession{}
+	_ base.ISessionUrlContext = &srt.SubSession{}
+	_ base.ISessionUrlContext = &webrtc.PubSession{}
+	_ base.ISessionUrlContext = &webrtc.SubSession{}
 	// other
 	_ base.ISessionUrlContext = &base.BasicHttpSubSession{}
 	_ base.ISessionUrlContext = &rtmp.ClientSession{}
@@ -139,6 +161,10 @@ var (
 	_ base.IObject = &rtsp.SubSession{}
 	_ base.IObject = &httpflv.SubSession{}
 	_ base.IObject = &httpts.SubSession{}
+	_ base.IObject = &srt.PubSession{}
+	_ base.IObject = &srt.SubSession{}
+	_ base.IObject = &webrtc.PubSession{}
+	_ base.IObject = &webrtc.SubSession{}
 	//// other
 	_ base.IObject = &base.BasicHttpSubSession{}
 	_ base.IObject = &rtmp.ClientSession{}
@@ -159,10 +185,21 @@ var _ logic.ILalServer = &logic.ServerManager{}
 var _ rtmp.IServerObserver = &logic.ServerManager{}
 var _ logic.IHttpServerHandlerObserver = &logic.ServerManager{}
 var _ rtsp.IServerObserver = &logic.ServerManager{}
+var _ srt.IServerObserver = &logic.ServerManager{}
+var _ webrtc.IServerObserver = &logic.ServerManager{}
 var _ logic.IGroupCreator = &logic.ServerManager{}
 var _ logic.IGroupObserver = &logic.ServerManager{}
 
 var _ logic.INotifyHandler = &logic.HttpNotify{}
+var _ logic.IAuthentication = &logic.SimpleAuthCtx{}
+var _ rtmp.IServerAuthProvider = &logic.SimpleAuthCtx{}
+var _ logic.IAuthentication = &logic.TokenAuthCtx{}
+var _ logic.IHlsRequestAuthentication = &logic.TokenAuthCtx{}
+var _ rtmp.IServerAuthProvider = &logic.TokenAuthCtx{}
+var _ logic.IAuthentication = &logic.HttpAuthCtx{}
+var _ logic.IAuthenticationWithResult = &logic.HttpAuthCtx{}
+var _ logic.IHlsRequestAuthentication = &logic.HttpAuthCtx{}
+var _ rtmp.IServerAuthProvider = &logic.HttpAuthCtx{}
 var _ logic.IGroupManager = &logic.SimpleGroupManager{}
 var _ logic.IGroupManager = &logic.ComplexGroupManager{}
 
@@ -175,6 +212,7 @@ var _ hls.IMuxerObserver = &logic.Group{}
 var _ rtsp.IBaseInSessionObserver = &logic.Group{} //
 var _ rtsp.IBaseInSessionObserver = &remux.AvPacket2RtmpRemuxer{}
 var _ remux.IRtmp2MpegtsRemuxerObserver = &hls.Muxer{}
+var _ remux.IRtmp2Fmp4RemuxerObserver = &logic.Group{}
 
 var _ rtmp.IServerSessionObserver = &rtmp.Server{}
 var _ rtmp.IHandshakeClient = &rtmp.HandshakeClientSimple{}
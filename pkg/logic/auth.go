@@ -14,8 +14,30 @@ import (
 
 // TODO(chef): [refactor] 将simple_auth.go的内容合并过来，没必要弄两个文件 202209
 
+// IAuthentication
+//
+// 如果实现同时实现了 rtmp.IServerAuthProvider ，rtmp推流在connect阶段会进行adobe或limelight鉴权，可参考 SimpleAuthCtx
+//
+// 如果实现同时实现了 IHlsRequestAuthentication ，hls使用该接口鉴权，可参考 TokenAuthCtx
 type IAuthentication interface {
 	OnPubStart(info base.PubStartInfo) error
 	OnSubStart(info base.SubStartInfo) error
 	OnHls(streamName, urlParam string) error
 }
+
+// AuthResult 鉴权通过后，对session的额外控制
+type AuthResult struct {
+	StreamName    string // 不为空时，session使用该流名称加入group
+	MaxDurationMs int    // 大于0时，session持续该时长后被关闭
+	MaxViewers    int    // 大于0时，如果session加入group时流的观看数已经达到该值，拒绝该session，只对sub生效
+}
+
+// IAuthenticationWithResult
+//
+// 可选接口，如果 IAuthentication 的实现同时实现了该接口，pub和sub使用该接口鉴权，可参考 HttpAuthCtx 和 TokenAuthCtx
+//
+// 注意，hls的sub不支持 AuthResult.StreamName 和 AuthResult.MaxDurationMs ，返回这两个字段时hls播放被拒绝
+type IAuthenticationWithResult interface {
+	OnPubStartWithResult(info base.PubStartInfo) (AuthResult, error)
+	OnSubStartWithResult(info base.SubStartInfo) (AuthResult, error)
+}
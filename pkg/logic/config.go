@@ -17,15 +17,31 @@ import (
 	"github.com/q191201771/lal/pkg/base"
 	"github.com/q191201771/lal/pkg/hls"
 	"github.com/q191201771/lal/pkg/rtsp"
+	"github.com/q191201771/lal/pkg/srt"
+	"github.com/q191201771/lal/pkg/webrtc"
 	"github.com/q191201771/naza/pkg/nazajson"
 	"github.com/q191201771/naza/pkg/nazalog"
 )
 
 const (
 	defaultHlsCleanupMode    = hls.CleanupModeInTheEnd
+	defaultHlsPartDurationMs = 500
 	defaultHttpflvUrlPattern = "/live/"
 	defaultHttptsUrlPattern  = "/live/"
 	defaultHlsUrlPattern     = "/hls/"
+	defaultWhipUrlPattern    = "/whip/"
+	defaultWhepUrlPattern    = "/whep/"
+	defaultVodAppName        = "vod"
+
+	defaultTranscodeRestartIntervalMs    = 1000
+	defaultTranscodeMaxRestartIntervalMs = 30000
+
+	defaultRelayPushAggregateMaxDurationMs = 100
+
+	defaultTokenAuthParamName  = "token"
+	defaultTokenAuthHeaderName = "Authorization"
+
+	defaultHttpAuthTimeoutMs = 3000
 )
 
 type Config struct {
@@ -37,14 +53,19 @@ type Config struct {
 	HlsConfig             HlsConfig             `json:"hls"`
 	HttptsConfig          HttptsConfig          `json:"httpts"`
 	RtspConfig            RtspConfig            `json:"rtsp"`
+	SrtConfig             SrtConfig             `json:"srt"`
+	WebrtcConfig          WebrtcConfig          `json:"webrtc"`
 	RecordConfig          RecordConfig          `json:"record"`
 	RelayPushConfig       RelayPushConfig       `json:"relay_push"`
 	StaticRelayPullConfig StaticRelayPullConfig `json:"static_relay_pull"`
+	TranscodeConfig       TranscodeConfig       `json:"transcode"`
 
 	HttpApiConfig    HttpApiConfig    `json:"http_api"`
 	ServerId         string           `json:"server_id"`
 	HttpNotifyConfig HttpNotifyConfig `json:"http_notify"`
 	SimpleAuthConfig SimpleAuthConfig `json:"simple_auth"`
+	TokenAuthConfig  TokenAuthConfig  `json:"token_auth"`
+	HttpAuthConfig   HttpAuthConfig   `json:"http_auth"`
 	PprofConfig      PprofConfig      `json:"pprof"`
 	LogConfig        nazalog.Option   `json:"log"`
 	DebugConfig      DebugConfig      `json:"debug"`
@@ -90,10 +111,12 @@ type HlsConfig struct {
 
 	UseMemoryAsDiskFlag bool `json:"use_memory_as_disk_flag"`
 	hls.MuxerConfig
-	EnableCache         bool   `json:"enable_cache"`
-	SubSessionTimeoutMs int    `json:"sub_session_timeout_ms"`
-	SubSessionHashKey   string `json:"sub_session_hash_key"`
-	CacheFlag           uint8  `json:"cache_flag"` //缓存类型 0 file,1 memory 2自定义接口
+	Fmp4Enable          bool     `json:"fmp4_enable"`           // 所有流都使用fmp4格式的分片
+	Fmp4StreamNameList  []string `json:"fmp4_stream_name_list"` // 只有这些流使用fmp4格式的分片，fmp4_enable为true时忽略
+	EnableCache         bool     `json:"enable_cache"`
+	SubSessionTimeoutMs int      `json:"sub_session_timeout_ms"`
+	SubSessionHashKey   string   `json:"sub_session_hash_key"`
+	CacheFlag           uint8    `json:"cache_flag"` //缓存类型 0 file,1 memory 2自定义接口
 }
 
 type RtspConfig struct {
@@ -104,7 +127,28 @@ type RtspConfig struct {
 	RtspsCertFile       string `json:"rtsps_cert_file"`
 	RtspsKeyFile        string `json:"rtsps_key_file"`
 	OutWaitKeyFrameFlag bool   `json:"out_wait_key_frame_flag"`
+	SessionTimeoutSec   int    `json:"session_timeout_sec"` // 小于等于0时不做超时检查
+	HttpTunnelEnable    bool   `json:"http_tunnel_enable"`  // RTSP over HTTP，复用httpflv等的http监听端口
 	rtsp.ServerAuthConfig
+	rtsp.MulticastConfig
+	rtsp.InLossConfig
+}
+
+type SrtConfig struct {
+	Enable bool   `json:"enable"`
+	Addr   string `json:"addr"`
+	srt.ServerConfig
+}
+
+type WebrtcConfig struct {
+	CommonHttpAddrConfig
+
+	Enable         bool   `json:"enable"`
+	EnableHttps    bool   `json:"enable_https"`
+	WhipUrlPattern string `json:"whip_url_pattern"`
+	WhepUrlPattern string `json:"whep_url_pattern"`
+	UdpAddr        string `json:"udp_addr"` // 媒体数据（ICE、DTLS、SRTP）使用的udp监听地址，所有session共用
+	webrtc.ServerConfig
 }
 
 type RecordConfig struct {
@@ -112,11 +156,29 @@ type RecordConfig struct {
 	FlvOutPath    string `json:"flv_out_path"`
 	EnableMpegts  bool   `json:"enable_mpegts"`
 	MpegtsOutPath string `json:"mpegts_out_path"`
+	EnableMp4     bool   `json:"enable_mp4"` // 录制过程中写入fmp4，结束时转换为moov在前的mp4
+	Mp4OutPath    string `json:"mp4_out_path"`
+
+	// flv和mpegts录制的文件切分，满足任意一个条件时，在下一个视频关键帧处切分出新文件。为0时表示不使用该条件
+	SegmentDurationSec int   `json:"segment_duration_sec"`
+	SegmentMaxBytes    int64 `json:"segment_max_bytes"`
+
+	// FilenameTemplate 录制文件名模板，不包含扩展名，可以包含子目录，比如 "{app}/{stream}/{yyyy}/{mm}/{dd}/{HHMMSS}"
+	//
+	// 支持的变量：{app} {stream} {yyyy} {mm} {dd} {HH} {HHMMSS} {unix}
+	// 为空时使用 "{stream}-{unix}"
+	FilenameTemplate string `json:"filename_template"`
+
+	// EnableVod 点播，appName为VodAppName的rtmp和httpflv拉流请求，会读取FlvOutPath目录下的flv文件进行播放，详见 vod.go
+	EnableVod  bool   `json:"enable_vod"`
+	VodAppName string `json:"vod_app_name"`
 }
 
 type RelayPushConfig struct {
-	Enable   bool     `json:"enable"`
-	AddrList []string `json:"addr_list"`
+	Enable                 bool     `json:"enable"`
+	AddrList               []string `json:"addr_list"`
+	AggregateEnable        bool     `json:"aggregate_enable"`          // 转推时是否将音视频message合并为aggregate message，以减少chunk头的开销
+	AggregateMaxDurationMs int      `json:"aggregate_max_duration_ms"` // 合并的最大时间跨度
 }
 
 type StaticRelayPullConfig struct {
@@ -124,24 +186,39 @@ type StaticRelayPullConfig struct {
 	Addr   string `json:"addr"`
 }
 
+type TranscodeConfig struct {
+	Enable               bool               `json:"enable"`
+	RestartIntervalMs    int                `json:"restart_interval_ms"`     // 转码进程退出后重启的初始间隔，之后每次翻倍
+	MaxRestartIntervalMs int                `json:"max_restart_interval_ms"` // 重启间隔的上限
+	Profiles             []TranscodeProfile `json:"profiles"`
+}
+
+// TranscodeProfile 转码配置，详见 transcode.go
+type TranscodeProfile struct {
+	Name            string `json:"name"`
+	OutStreamSuffix string `json:"out_stream_suffix"` // 转码后的流名称为 <stream><out_stream_suffix>，不能为空，不能包含空白字符和`/`
+	Command         string `json:"command"`
+}
+
 type HttpApiConfig struct {
 	Enable bool   `json:"enable"`
 	Addr   string `json:"addr"`
 }
 
 type HttpNotifyConfig struct {
-	Enable            bool   `json:"enable"`
-	UpdateIntervalSec int    `json:"update_interval_sec"`
-	OnServerStart     string `json:"on_server_start"`
-	OnUpdate          string `json:"on_update"`
-	OnPubStart        string `json:"on_pub_start"`
-	OnPubStop         string `json:"on_pub_stop"`
-	OnSubStart        string `json:"on_sub_start"`
-	OnSubStop         string `json:"on_sub_stop"`
-	OnRelayPullStart  string `json:"on_relay_pull_start"`
-	OnRelayPullStop   string `json:"on_relay_pull_stop"`
-	OnRtmpConnect     string `json:"on_rtmp_connect"`
-	OnHlsMakeTs       string `json:"on_hls_make_ts"`
+	Enable             bool   `json:"enable"`
+	UpdateIntervalSec  int    `json:"update_interval_sec"`
+	OnServerStart      string `json:"on_server_start"`
+	OnUpdate           string `json:"on_update"`
+	OnPubStart         string `json:"on_pub_start"`
+	OnPubStop          string `json:"on_pub_stop"`
+	OnSubStart         string `json:"on_sub_start"`
+	OnSubStop          string `json:"on_sub_stop"`
+	OnRelayPullStart   string `json:"on_relay_pull_start"`
+	OnRelayPullStop    string `json:"on_relay_pull_stop"`
+	OnRtmpConnect      string `json:"on_rtmp_connect"`
+	OnHlsMakeTs        string `json:"on_hls_make_ts"`
+	OnRecordFileClosed string `json:"on_record_file_closed"`
 }
 
 type SimpleAuthConfig struct {
@@ -154,6 +231,26 @@ type SimpleAuthConfig struct {
 	PubRtspEnable      bool   `json:"pub_rtsp_enable"`
 	SubRtspEnable      bool   `json:"sub_rtsp_enable"`
 	HlsM3u8Enable      bool   `json:"hls_m3u8_enable"`
+
+	RtmpPubAuthMod   string            `json:"rtmp_pub_auth_mod"`   // rtmp推流在connect阶段的鉴权方式，"adobe"或"llnw"，为空表示不开启
+	RtmpPubAuthUsers map[string]string `json:"rtmp_pub_auth_users"` // rtmp推流鉴权的用户名和密码
+}
+
+type TokenAuthConfig struct {
+	Enable     bool   `json:"enable"`
+	Secret     string `json:"secret"`      // 校验token签名的密钥
+	ParamName  string `json:"param_name"`  // url参数中token的名称
+	HeaderName string `json:"header_name"` // header中token的名称，rtmp没有header
+	PubEnable  bool   `json:"pub_enable"`
+	SubEnable  bool   `json:"sub_enable"`
+	HlsEnable  bool   `json:"hls_enable"`
+}
+
+type HttpAuthConfig struct {
+	Enable     bool   `json:"enable"`
+	OnPubStart string `json:"on_pub_start"` // 为空表示pub不做http回调鉴权
+	OnSubStart string `json:"on_sub_start"` // 为空表示sub不做http回调鉴权
+	TimeoutMs  int    `json:"timeout_ms"`   // 等待回调响应的超时时间
 }
 
 type PprofConfig struct {
@@ -184,62 +281,17 @@ type CommonHttpAddrConfig struct {
 }
 
 func LoadConfAndInitLog(rawContent []byte) *Config {
-	var config *Config
-
 	// 读取配置并解析原始内容
-	if err := json.Unmarshal(rawContent, &config); err != nil {
-		_, _ = fmt.Fprintf(os.Stderr, "unmarshal conf file failed. raw content=%s err=%+v", rawContent, err)
-		base.OsExitAndWaitPressIfWindows(1)
-	}
-
-	j, err := nazajson.New(rawContent)
+	config, j, err := unmarshalConf(rawContent)
 	if err != nil {
-		_, _ = fmt.Fprintf(os.Stderr, "nazajson unmarshal conf file failed. raw content=%s err=%+v", rawContent, err)
+		_, _ = fmt.Fprintf(os.Stderr, "unmarshal conf file failed. raw content=%s err=%+v", rawContent, err)
 		base.OsExitAndWaitPressIfWindows(1)
 	}
 
 	// 初始化日志模块，注意，这一步尽量提前，使得后续的日志内容按我们的日志配置输出
 	//
-	// 日志配置项不存在时，设置默认值
-	//
 	// 注意，由于此时日志模块还没有初始化，所以有日志需要打印时，我们采用先缓存后打印（日志模块初始化成功后再打印）的方式
-	var cacheLog []string
-	if !j.Exist("log.level") {
-		config.LogConfig.Level = nazalog.LevelDebug
-		cacheLog = append(cacheLog, fmt.Sprintf("log.level=%s", config.LogConfig.Level.ReadableString()))
-	}
-	if !j.Exist("log.filename") {
-		config.LogConfig.Filename = "./logs/lalserver.log"
-		cacheLog = append(cacheLog, fmt.Sprintf("log.filename=%s", config.LogConfig.Filename))
-	}
-	if !j.Exist("log.is_to_stdout") {
-		config.LogConfig.IsToStdout = true
-		cacheLog = append(cacheLog, fmt.Sprintf("log.is_to_stdout=%v", config.LogConfig.IsToStdout))
-	}
-	if !j.Exist("log.is_rotate_daily") {
-		config.LogConfig.IsRotateDaily = true
-		cacheLog = append(cacheLog, fmt.Sprintf("log.is_rotate_daily=%v", config.LogConfig.IsRotateDaily))
-	}
-	if !j.Exist("log.short_file_flag") {
-		config.LogConfig.ShortFileFlag = true
-		cacheLog = append(cacheLog, fmt.Sprintf("log.short_file_flag=%v", config.LogConfig.ShortFileFlag))
-	}
-	if !j.Exist("log.timestamp_flag") {
-		config.LogConfig.TimestampFlag = true
-		cacheLog = append(cacheLog, fmt.Sprintf("log.timestamp_flag=%v", config.LogConfig.TimestampFlag))
-	}
-	if !j.Exist("log.timestamp_with_ms_flag") {
-		config.LogConfig.TimestampWithMsFlag = true
-		cacheLog = append(cacheLog, fmt.Sprintf("log.timestamp_with_ms_flag=%v", config.LogConfig.TimestampWithMsFlag))
-	}
-	if !j.Exist("log.level_flag") {
-		config.LogConfig.LevelFlag = true
-		cacheLog = append(cacheLog, fmt.Sprintf("log.level_flag=%v", config.LogConfig.LevelFlag))
-	}
-	if !j.Exist("log.assert_behavior") {
-		config.LogConfig.AssertBehavior = nazalog.AssertError
-		cacheLog = append(cacheLog, fmt.Sprintf("log.assert_behavior=%s", config.LogConfig.AssertBehavior.ReadableString()))
-	}
+	cacheLog := fillLogConfDefault(config, j)
 
 	if err := Log.Init(func(option *nazalog.Option) {
 		*option = config.LogConfig
@@ -271,6 +323,7 @@ func LoadConfAndInitLog(rawContent []byte) *Config {
 		"httpflv.http_listen_addr", "httpflv.https_listen_addr", "httpflv.https_cert_file", "httpflv.https_key_file",
 		"hls.http_listen_addr", "hls.https_listen_addr", "hls.https_cert_file", "hls.https_key_file",
 		"httpts.http_listen_addr", "httpts.https_listen_addr", "httpts.https_cert_file", "httpts.https_key_file",
+		"webrtc.http_listen_addr", "webrtc.https_listen_addr", "webrtc.https_cert_file", "webrtc.https_key_file",
 	)
 	if err != nil {
 		Log.Warnf("config nazajson collect not exist fields failed. err=%+v", err)
@@ -284,10 +337,120 @@ func LoadConfAndInitLog(rawContent []byte) *Config {
 		Log.Warnf("config some log fields do not exist which have been set to default value. %s", strings.Join(cacheLog, ", "))
 	}
 
+	fillConfDefault(config, j)
+	if err := checkConf(config); err != nil {
+		Log.Errorf("check conf failed. err=%+v", err)
+		base.OsExitAndWaitPressIfWindows(1)
+	}
+
+	// 打印配置文件中的元素内容，以及解析后的最终值
+	// 把配置文件原始内容中的换行去掉，使得打印日志时紧凑一些
+	lines := strings.Split(string(rawContent), "\n")
+	if len(lines) == 1 {
+		lines = strings.Split(string(rawContent), "\r\n")
+	}
+	var tlines []string
+	for _, l := range lines {
+		tlines = append(tlines, strings.TrimSpace(l))
+	}
+	compactRawContent := strings.Join(tlines, " ")
+	Log.Infof("load conf succ. raw content=%s parsed=%+v", compactRawContent, config)
+
+	return config
+}
+
+// LoadConf 解析配置，但不初始化日志模块，并且出错时不退出程序，用于运行时重新加载配置
+func LoadConf(rawContent []byte) (*Config, error) {
+	config, j, err := unmarshalConf(rawContent)
+	if err != nil {
+		return nil, err
+	}
+	fillLogConfDefault(config, j)
+	fillConfDefault(config, j)
+	if err = checkConf(config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func unmarshalConf(rawContent []byte) (*Config, *nazajson.Json, error) {
+	var config *Config
+	if err := json.Unmarshal(rawContent, &config); err != nil {
+		return nil, nil, err
+	}
+	if config == nil {
+		return nil, nil, base.ErrConfigInvalid
+	}
+	j, err := nazajson.New(rawContent)
+	if err != nil {
+		return nil, nil, err
+	}
+	return config, &j, nil
+}
+
+// fillLogConfDefault 日志配置项不存在时，设置默认值
+//
+// @return 设置了默认值的字段，供日志模块初始化后打印
+func fillLogConfDefault(config *Config, j *nazajson.Json) []string {
+	var cacheLog []string
+	if !j.Exist("log.level") {
+		config.LogConfig.Level = nazalog.LevelDebug
+		cacheLog = append(cacheLog, fmt.Sprintf("log.level=%s", config.LogConfig.Level.ReadableString()))
+	}
+	if !j.Exist("log.filename") {
+		config.LogConfig.Filename = "./logs/lalserver.log"
+		cacheLog = append(cacheLog, fmt.Sprintf("log.filename=%s", config.LogConfig.Filename))
+	}
+	if !j.Exist("log.is_to_stdout") {
+		config.LogConfig.IsToStdout = true
+		cacheLog = append(cacheLog, fmt.Sprintf("log.is_to_stdout=%v", config.LogConfig.IsToStdout))
+	}
+	if !j.Exist("log.is_rotate_daily") {
+		config.LogConfig.IsRotateDaily = true
+		cacheLog = append(cacheLog, fmt.Sprintf("log.is_rotate_daily=%v", config.LogConfig.IsRotateDaily))
+	}
+	if !j.Exist("log.short_file_flag") {
+		config.LogConfig.ShortFileFlag = true
+		cacheLog = append(cacheLog, fmt.Sprintf("log.short_file_flag=%v", config.LogConfig.ShortFileFlag))
+	}
+	if !j.Exist("log.timestamp_flag") {
+		config.LogConfig.TimestampFlag = true
+		cacheLog = append(cacheLog, fmt.Sprintf("log.timestamp_flag=%v", config.LogConfig.TimestampFlag))
+	}
+	if !j.Exist("log.timestamp_with_ms_flag") {
+		config.LogConfig.TimestampWithMsFlag = true
+		cacheLog = append(cacheLog, fmt.Sprintf("log.timestamp_with_ms_flag=%v", config.LogConfig.TimestampWithMsFlag))
+	}
+	if !j.Exist("log.level_flag") {
+		config.LogConfig.LevelFlag = true
+		cacheLog = append(cacheLog, fmt.Sprintf("log.level_flag=%v", config.LogConfig.LevelFlag))
+	}
+	if !j.Exist("log.assert_behavior") {
+		config.LogConfig.AssertBehavior = nazalog.AssertError
+		cacheLog = append(cacheLog, fmt.Sprintf("log.assert_behavior=%s", config.LogConfig.AssertBehavior.ReadableString()))
+	}
+
+	return cacheLog
+}
+
+// checkConf 检查无法设置默认值的配置项，检查失败时不能使用该配置
+func checkConf(config *Config) error {
+	if config.TokenAuthConfig.Enable && config.TokenAuthConfig.Secret == "" {
+		// 密钥为空时，任何人都可以签发合法的token
+		return fmt.Errorf("%w. token_auth.secret is empty", base.ErrConfigInvalid)
+	}
+	return nil
+}
+
+// fillConfDefault 为缺失或无效的字段设置默认值，并修复一些常见的格式错误
+func fillConfDefault(config *Config, j *nazajson.Json) {
 	// 如果具体的HTTP应用没有设置HTTP监听相关的配置，则尝试使用全局配置
 	mergeCommonHttpAddrConfig(&config.HttpflvConfig.CommonHttpAddrConfig, &config.DefaultHttpConfig.CommonHttpAddrConfig)
 	mergeCommonHttpAddrConfig(&config.HttptsConfig.CommonHttpAddrConfig, &config.DefaultHttpConfig.CommonHttpAddrConfig)
 	mergeCommonHttpAddrConfig(&config.HlsConfig.CommonHttpAddrConfig, &config.DefaultHttpConfig.CommonHttpAddrConfig)
+	mergeCommonHttpAddrConfig(&config.WebrtcConfig.CommonHttpAddrConfig, &config.DefaultHttpConfig.CommonHttpAddrConfig)
 
 	// 为缺失的字段中的一些特定字段，设置特定默认值
 	if config.HlsConfig.Enable && !j.Exist("hls.cleanup_mode") {
@@ -299,6 +462,13 @@ func LoadConfAndInitLog(rawContent []byte) *Config {
 			config.HlsConfig.FragmentNum)
 		config.HlsConfig.DeleteThreshold = config.HlsConfig.FragmentNum
 	}
+	if config.HlsConfig.LowLatencyEnable && config.HlsConfig.PartDurationMs <= 0 {
+		Log.Warnf("config hls.part_duration_ms invalid. set to default which is %d", defaultHlsPartDurationMs)
+		config.HlsConfig.PartDurationMs = defaultHlsPartDurationMs
+	}
+	if config.HlsConfig.DashEnable && !config.HlsConfig.Fmp4Enable && len(config.HlsConfig.Fmp4StreamNameList) == 0 {
+		Log.Warnf("config hls.dash_enable only works for fmp4 streams, but hls.fmp4_enable is false and hls.fmp4_stream_name_list is empty")
+	}
 	if config.HlsConfig.SubSessionHashKey != "" && config.HlsConfig.SubSessionTimeoutMs == 0 {
 		// 没有设置超时值，或者超时为0时
 		Log.Warnf("config hls.sub_session_timeout_ms is 0. set to %d(which is fragment_num * fragment_duration_ms * 2)",
@@ -317,6 +487,55 @@ func LoadConfAndInitLog(rawContent []byte) *Config {
 		Log.Warnf("config hls.url_pattern not exist. set to default which is %s", defaultHlsUrlPattern)
 		config.HttpflvConfig.UrlPattern = defaultHlsUrlPattern
 	}
+	if (config.WebrtcConfig.Enable || config.WebrtcConfig.EnableHttps) && !j.Exist("webrtc.whip_url_pattern") {
+		Log.Warnf("config webrtc.whip_url_pattern not exist. set to default which is %s", defaultWhipUrlPattern)
+		config.WebrtcConfig.WhipUrlPattern = defaultWhipUrlPattern
+	}
+	if (config.WebrtcConfig.Enable || config.WebrtcConfig.EnableHttps) && !j.Exist("webrtc.whep_url_pattern") {
+		Log.Warnf("config webrtc.whep_url_pattern not exist. set to default which is %s", defaultWhepUrlPattern)
+		config.WebrtcConfig.WhepUrlPattern = defaultWhepUrlPattern
+	}
+	if config.RecordConfig.EnableVod && config.RecordConfig.VodAppName == "" {
+		Log.Warnf("config record.vod_app_name is empty. set to default which is %s", defaultVodAppName)
+		config.RecordConfig.VodAppName = defaultVodAppName
+	}
+	if config.RelayPushConfig.AggregateEnable && config.RelayPushConfig.AggregateMaxDurationMs <= 0 {
+		Log.Warnf("config relay_push.aggregate_max_duration_ms is invalid. set to default which is %d", defaultRelayPushAggregateMaxDurationMs)
+		config.RelayPushConfig.AggregateMaxDurationMs = defaultRelayPushAggregateMaxDurationMs
+	}
+	if config.TokenAuthConfig.Enable && config.TokenAuthConfig.ParamName == "" {
+		Log.Warnf("config token_auth.param_name is empty. set to default which is %s", defaultTokenAuthParamName)
+		config.TokenAuthConfig.ParamName = defaultTokenAuthParamName
+	}
+	if config.TokenAuthConfig.Enable && !j.Exist("token_auth.header_name") {
+		Log.Warnf("config token_auth.header_name not exist. set to default which is %s", defaultTokenAuthHeaderName)
+		config.TokenAuthConfig.HeaderName = defaultTokenAuthHeaderName
+	}
+	if (config.RtspConfig.Enable || config.RtspConfig.RtspsEnable) && !j.Exist("rtsp.session_timeout_sec") {
+		Log.Warnf("config rtsp.session_timeout_sec not exist. set to default which is %d", rtsp.DefaultSessionTimeoutSec)
+		config.RtspConfig.SessionTimeoutSec = rtsp.DefaultSessionTimeoutSec
+	}
+	if config.HttpAuthConfig.Enable && config.HttpAuthConfig.TimeoutMs <= 0 {
+		Log.Warnf("config http_auth.timeout_ms is invalid. set to default which is %d", defaultHttpAuthTimeoutMs)
+		config.HttpAuthConfig.TimeoutMs = defaultHttpAuthTimeoutMs
+	}
+	if config.TranscodeConfig.Enable {
+		if config.TranscodeConfig.RestartIntervalMs <= 0 {
+			config.TranscodeConfig.RestartIntervalMs = defaultTranscodeRestartIntervalMs
+		}
+		if config.TranscodeConfig.MaxRestartIntervalMs < config.TranscodeConfig.RestartIntervalMs {
+			config.TranscodeConfig.MaxRestartIntervalMs = defaultTranscodeMaxRestartIntervalMs
+		}
+		var profiles []TranscodeProfile
+		for _, p := range config.TranscodeConfig.Profiles {
+			if !isValidTranscodeProfile(p) {
+				Log.Warnf("config transcode.profiles invalid, ignore it. profile=%+v", p)
+				continue
+			}
+			profiles = append(profiles, p)
+		}
+		config.TranscodeConfig.Profiles = profiles
+	}
 
 	// 对一些常见的格式错误做修复
 	// 确保url pattern以`/`开始，并以`/`结束
@@ -332,21 +551,15 @@ func LoadConfAndInitLog(rawContent []byte) *Config {
 		Log.Warnf("fix config. hls.url_pattern %s -> %s", config.HlsConfig.UrlPattern, urlPattern)
 		config.HttpflvConfig.UrlPattern = urlPattern
 	}
-
-	// 打印配置文件中的元素内容，以及解析后的最终值
-	// 把配置文件原始内容中的换行去掉，使得打印日志时紧凑一些
-	lines := strings.Split(string(rawContent), "\n")
-	if len(lines) == 1 {
-		lines = strings.Split(string(rawContent), "\r\n")
+	if urlPattern, changed := ensureStartAndEndWithSlash(config.WebrtcConfig.WhipUrlPattern); changed {
+		Log.Warnf("fix config. webrtc.whip_url_pattern %s -> %s", config.WebrtcConfig.WhipUrlPattern, urlPattern)
+		config.WebrtcConfig.WhipUrlPattern = urlPattern
 	}
-	var tlines []string
-	for _, l := range lines {
-		tlines = append(tlines, strings.TrimSpace(l))
+	if urlPattern, changed := ensureStartAndEndWithSlash(config.WebrtcConfig.WhepUrlPattern); changed {
+		Log.Warnf("fix config. webrtc.whep_url_pattern %s -> %s", config.WebrtcConfig.WhepUrlPattern, urlPattern)
+		config.WebrtcConfig.WhepUrlPattern = urlPattern
 	}
-	compactRawContent := strings.Join(tlines, " ")
-	Log.Infof("load conf succ. raw content=%s parsed=%+v", compactRawContent, config)
 
-	return config
 }
 
 // ---------------------------------------------------------------------------------------------------------------------
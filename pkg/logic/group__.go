@@ -16,6 +16,7 @@ import (
 	"github.com/q191201771/lal/pkg/gb28181"
 
 	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/fmp4"
 	"github.com/q191201771/lal/pkg/hls"
 	"github.com/q191201771/lal/pkg/httpflv"
 	"github.com/q191201771/lal/pkg/httpts"
@@ -24,6 +25,8 @@ import (
 	"github.com/q191201771/lal/pkg/rtmp"
 	"github.com/q191201771/lal/pkg/rtsp"
 	"github.com/q191201771/lal/pkg/sdp"
+	"github.com/q191201771/lal/pkg/srt"
+	"github.com/q191201771/lal/pkg/webrtc"
 )
 
 // ---------------------------------------------------------------------------------------------------------------------
@@ -31,22 +34,22 @@ import (
 // TODO(chef): [refactor] 考虑抽象出通用接口 202208
 //
 // checklist表格
-// | .                                           | rtmp pub | ps pub |
-// | 添加到group中                                | Y        | Y      |
-// | 到输出流的转换路径关系                         | Y        | Y      |
-// | 删除                                        | Y        | Y      |
-// | group.hasPubSession()                       | Y        | Y      |
-// | group.disposeInactiveSessions()检查超时并清理 | Y        | Y      |
-// | group.Dispose()时销毁                        | Y        | Y      |
-// | group.GetStat()时获取信息                     | Y        | Y      |
-// | group.KickSession()时踢出                    | Y        | Y      |
-// | group.updateAllSessionStat()更新信息         | Y        | Y      |
-// | group.inSessionUniqueKey()                  | Y        | Y      |
+// | .                                           | rtmp pub | ps pub | srt pub | webrtc pub |
+// | 添加到group中                                | Y        | Y      | Y       | Y          |
+// | 到输出流的转换路径关系                         | Y        | Y      | Y       | Y          |
+// | 删除                                        | Y        | Y      | Y       | Y          |
+// | group.hasPubSession()                       | Y        | Y      | Y       | Y          |
+// | group.disposeInactiveSessions()检查超时并清理 | Y        | Y      | Y       | Y          |
+// | group.Dispose()时销毁                        | Y        | Y      | Y       | Y          |
+// | group.GetStat()时获取信息                     | Y        | Y      | Y       | Y          |
+// | group.KickSession()时踢出                    | Y        | Y      | Y       | Y          |
+// | group.updateAllSessionStat()更新信息         | Y        | Y      | Y       | Y          |
+// | group.inSessionUniqueKey()                  | Y        | Y      | Y       | Y          |
 
 // TODO(chef): [refactor] 整理sub类型流接入需要做的事情的文档 202211
 
 // ---------------------------------------------------------------------------------------------------------------------
-// 输入流到输出流的转换路径关系（一共6种输入）：
+// 输入流到输出流的转换路径关系（一共8种输入）：
 //
 // rtmpPullSession.WithOnReadRtmpAvMsg  ->
 // rtmpPubSession.SetPubSessionObserver ->
@@ -64,10 +67,19 @@ import (
 // psPubSession -> OnAvPacketFromPsPubSession(enter Lock) -> rtsp2RtmpRemuxer -> onRtmpMsgFromRemux -> [dummyAudioFilter] -> broadcastByRtmpMsg -> ...
 //                                                                                                                                              -> ...
 //                                                                                                                                              -> ...
+//
+// ---------------------------------------------------------------------------------------------------------------------
+// srtPubSession -> OnAvPacketFromSrtPubSession(enter Lock) -> rtsp2RtmpRemuxer -> onRtmpMsgFromRemux -> [dummyAudioFilter] -> broadcastByRtmpMsg -> ...
+//                                                                                                                                                -> ...
+//                                                                                                                                                -> rtmp2MpegtsRemuxer -> ts, hls, srt
+//
+// ---------------------------------------------------------------------------------------------------------------------
+// webrtcPubSession 与 rtspPubSession 相同，都是通过 OnSdp OnRtpPacket OnAvPacket 回调数据
 
 type IGroupObserver interface {
 	CleanupHlsIfNeeded(appName string, streamName string, path string)
 	OnHlsMakeTs(info base.HlsMakeTsInfo)
+	OnRecordFileClosed(info base.RecordFileClosedInfo)
 	OnRelayPullStart(info base.PullStartInfo) // TODO(chef): refactor me
 	OnRelayPullStop(info base.PullStopInfo)
 	BeforeRelayPush(info *base.RepayPushInfo)
@@ -88,11 +100,16 @@ type Group struct {
 	rtspPubSession      *rtsp.PubSession
 	customizePubSession *CustomizePubSessionContext
 	psPubSession        *gb28181.PubSession
+	srtPubSession       *srt.PubSession
+	webrtcPubSession    *webrtc.PubSession
 	rtsp2RtmpRemuxer    *remux.AvPacket2RtmpRemuxer // TODO(chef): [refactor] 重命名为avPacket2RtmpRemuxer，因为除了rtsp，customize pub和gb28181 pub都是 202208
 	rtmp2RtspRemuxer    *remux.Rtmp2RtspRemuxer
 	rtmp2MpegtsRemuxer  *remux.Rtmp2MpegtsRemuxer
+	rtmp2Fmp4Remuxer    *remux.Rtmp2Fmp4Remuxer
 	// pull
 	pullProxy *pullProxy
+	// 转码
+	transcoders []*transcoder
 	// rtmp pub使用 TODO(chef): [doc] 更新这个注释，是共同使用 202210
 	dummyAudioFilter *remux.DummyAudioFilter
 	// ps pub使用
@@ -102,6 +119,10 @@ type Group struct {
 	rtmpGopCache *remux.GopCache
 	// httpflv sub使用
 	httpflvGopCache *remux.GopCache
+	// Enhanced RTMP格式的rtmp sub和httpflv sub使用，只有输入流为HEVC时才使用
+	hevcInput               bool
+	rtmpEnhancedGopCache    *remux.GopCache
+	httpflvEnhancedGopCache *remux.GopCache
 	// httpts sub使用
 	httptsGopCache *remux.GopCacheMpegts
 	// rtsp使用
@@ -109,12 +130,19 @@ type Group struct {
 	// mpegts使用
 	patpmt []byte
 	// sub
-	rtmpSubSessionSet     map[*rtmp.ServerSession]struct{}
-	httpflvSubSessionSet  map[*httpflv.SubSession]struct{}
-	httptsSubSessionSet   map[*httpts.SubSession]struct{}
-	rtspSubSessionSet     map[*rtsp.SubSession]struct{}
-	waitRtspSubSessionSet map[*rtsp.SubSession]struct{}
-	hlsSubSessionSet      map[*hls.SubSession]struct{}
+	rtmpSubSessionSet    map[*rtmp.ServerSession]struct{}
+	httpflvSubSessionSet map[*httpflv.SubSession]struct{}
+	// httpflvSubSessionSet中需要Enhanced RTMP格式的session
+	httpflvEnhancedSubSessionSet map[*httpflv.SubSession]struct{}
+	httptsSubSessionSet          map[*httpts.SubSession]struct{}
+	rtspSubSessionSet            map[*rtsp.SubSession]struct{}
+	waitRtspSubSessionSet        map[*rtsp.SubSession]struct{}
+	hlsSubSessionSet             map[*hls.SubSession]struct{}
+	srtSubSessionSet             map[*srt.SubSession]struct{}
+	webrtcSubSessionSet          map[*webrtc.SubSession]struct{}
+	// rtsp组播使用
+	rtspMulticastSubSessionSet map[*rtsp.SubSession]struct{} // rtspSubSessionSet中使用组播的session
+	rtspMulticastSender        *rtsp.MulticastSender
 	// push
 	pushEnable bool
 	//url2PushProxy map[string]*pushProxy
@@ -122,12 +150,25 @@ type Group struct {
 	// hls
 	hlsMuxer *hls.Muxer
 	// record
-	recordFlv    *httpflv.FlvFileWriter
-	recordMpegts *mpegts.FileWriter
+	recordOptions        map[string]*recordOption // 正在进行的录制，key为录制格式
+	recordFlv            *httpflv.FlvFileWriter
+	recordFlvSegment     *recordSegment
+	recordMpegts         *mpegts.FileWriter
+	recordMpegtsSegment  *recordSegment
+	recordMp4            *fmp4.FileWriter
+	recordMp4Segment     *recordSegment
+	recordHls            *hls.Muxer
+	recordHlsSegment     *recordSegment
+	recordMetadata       *base.RtmpMsg // 中途开启录制或切分出新文件时使用
+	recordVideoSeqHeader *base.RtmpMsg
+	recordAudioSeqHeader *base.RtmpMsg
 	// rtmp sub使用
 	rtmpMergeWriter *base.MergeWriter // TODO(chef): 后面可以在业务层加一个定时Flush
 	//
 	stat base.StatGroup
+	// metrics使用
+	counter         groupCounter
+	pushStartedKeys map[string]struct{} // 启动过转推的key，用于统计转推重试
 	//
 	hlsCalcSessionStatIntervalSec uint32
 	//
@@ -151,12 +192,20 @@ func NewGroup(appName string, streamName string, config *Config, observer IGroup
 		exitChan:                      make(chan struct{}, 1),
 		rtmpSubSessionSet:             make(map[*rtmp.ServerSession]struct{}),
 		httpflvSubSessionSet:          make(map[*httpflv.SubSession]struct{}),
+		httpflvEnhancedSubSessionSet:  make(map[*httpflv.SubSession]struct{}),
 		httptsSubSessionSet:           make(map[*httpts.SubSession]struct{}),
 		rtspSubSessionSet:             make(map[*rtsp.SubSession]struct{}),
 		waitRtspSubSessionSet:         make(map[*rtsp.SubSession]struct{}),
 		hlsSubSessionSet:              make(map[*hls.SubSession]struct{}),
+		srtSubSessionSet:              make(map[*srt.SubSession]struct{}),
+		webrtcSubSessionSet:           make(map[*webrtc.SubSession]struct{}),
+		rtspMulticastSubSessionSet:    make(map[*rtsp.SubSession]struct{}),
+		recordOptions:                 make(map[string]*recordOption),
+		pushStartedKeys:               make(map[string]struct{}),
 		rtmpGopCache:                  remux.NewGopCache("rtmp", uk, config.RtmpConfig.GopNum, config.RtmpConfig.SingleGopMaxFrameNum),
 		httpflvGopCache:               remux.NewGopCache("httpflv", uk, config.HttpflvConfig.GopNum, config.HttpflvConfig.SingleGopMaxFrameNum),
+		rtmpEnhancedGopCache:          remux.NewGopCache("rtmp-enhanced", uk, config.RtmpConfig.GopNum, config.RtmpConfig.SingleGopMaxFrameNum),
+		httpflvEnhancedGopCache:       remux.NewGopCache("httpflv-enhanced", uk, config.HttpflvConfig.GopNum, config.HttpflvConfig.SingleGopMaxFrameNum),
 		httptsGopCache:                remux.NewGopCacheMpegts(uk, config.HttptsConfig.GopNum, config.HttptsConfig.SingleGopMaxFrameNum),
 		psPubPrevInactiveCheckTick:    -1,
 		hlsCalcSessionStatIntervalSec: uint32(config.HlsConfig.FragmentDurationMs/1000) * 10,
@@ -186,6 +235,7 @@ func (group *Group) Tick(tickCount uint32) {
 
 	group.tickPullModule()
 	group.startPushIfNeeded()
+	group.tickRecord()
 
 	// 定时关闭没有数据的session
 	group.disposeInactiveSessions(tickCount)
@@ -221,6 +271,12 @@ func (group *Group) Dispose() {
 	if group.psPubSession != nil {
 		group.psPubSession.Dispose()
 	}
+	if group.srtPubSession != nil {
+		group.srtPubSession.Dispose()
+	}
+	if group.webrtcPubSession != nil {
+		group.webrtcPubSession.Dispose()
+	}
 
 	for session := range group.rtmpSubSessionSet {
 		session.Dispose()
@@ -231,6 +287,8 @@ func (group *Group) Dispose() {
 		session.Dispose()
 	}
 	group.rtspSubSessionSet = nil
+	group.rtspMulticastSubSessionSet = nil
+	group.disposeRtspMulticastSender()
 	for session := range group.waitRtspSubSessionSet {
 		session.Dispose()
 	}
@@ -246,6 +304,16 @@ func (group *Group) Dispose() {
 	}
 	group.httptsSubSessionSet = nil
 
+	for session := range group.srtSubSessionSet {
+		session.Dispose()
+	}
+	group.srtSubSessionSet = nil
+
+	for session := range group.webrtcSubSessionSet {
+		session.Dispose()
+	}
+	group.webrtcSubSessionSet = nil
+
 	group.delIn()
 }
 
@@ -257,10 +325,13 @@ func (group *Group) StringifyDebugStats(maxsub int) string {
 }
 
 func (group *Group) GetStat(maxsub int) base.StatGroup {
-	// TODO(chef): [refactor] param maxsub
-
 	group.mutex.Lock()
 	defer group.mutex.Unlock()
+	return group.getStat(maxsub)
+}
+
+func (group *Group) getStat(maxsub int) base.StatGroup {
+	// TODO(chef): [refactor] param maxsub
 
 	if group.rtmpPubSession != nil {
 		group.stat.StatPub = base.Session2StatPub(group.rtmpPubSession)
@@ -268,11 +339,17 @@ func (group *Group) GetStat(maxsub int) base.StatGroup {
 		group.stat.StatPub = base.Session2StatPub(group.rtspPubSession)
 	} else if group.psPubSession != nil {
 		group.stat.StatPub = base.Session2StatPub(group.psPubSession)
+	} else if group.srtPubSession != nil {
+		group.stat.StatPub = base.Session2StatPub(group.srtPubSession)
+	} else if group.webrtcPubSession != nil {
+		group.stat.StatPub = base.Session2StatPub(group.webrtcPubSession)
 	} else {
 		group.stat.StatPub = base.StatPub{}
 	}
 
 	group.stat.StatPull = group.getStatPull()
+	group.stat.StatRecords = group.getStatRecords()
+	group.stat.StatTranscodes = group.getStatTranscodes()
 
 	group.stat.StatSubs = nil
 	var statSubCount int
@@ -318,6 +395,20 @@ func (group *Group) GetStat(maxsub int) base.StatGroup {
 		}
 		group.stat.StatSubs = append(group.stat.StatSubs, base.Session2StatSub(s))
 	}
+	for s := range group.srtSubSessionSet {
+		statSubCount++
+		if statSubCount > maxsub {
+			break
+		}
+		group.stat.StatSubs = append(group.stat.StatSubs, base.Session2StatSub(s))
+	}
+	for s := range group.webrtcSubSessionSet {
+		statSubCount++
+		if statSubCount > maxsub {
+			break
+		}
+		group.stat.StatSubs = append(group.stat.StatSubs, base.Session2StatSub(s))
+	}
 
 	return group.stat
 }
@@ -351,6 +442,30 @@ func (group *Group) KickSession(sessionId string) bool {
 			group.psPubSession.Dispose()
 			return true
 		}
+	} else if strings.HasPrefix(sessionId, base.UkPreSrtPubSession) {
+		if group.srtPubSession != nil && group.srtPubSession.UniqueKey() == sessionId {
+			group.srtPubSession.Dispose()
+			return true
+		}
+	} else if strings.HasPrefix(sessionId, base.UkPreSrtSubSession) {
+		for s := range group.srtSubSessionSet {
+			if s.UniqueKey() == sessionId {
+				s.Dispose()
+				return true
+			}
+		}
+	} else if strings.HasPrefix(sessionId, base.UkPreWebrtcPubSession) {
+		if group.webrtcPubSession != nil && group.webrtcPubSession.UniqueKey() == sessionId {
+			group.webrtcPubSession.Dispose()
+			return true
+		}
+	} else if strings.HasPrefix(sessionId, base.UkPreWebrtcSubSession) {
+		for s := range group.webrtcSubSessionSet {
+			if s.UniqueKey() == sessionId {
+				s.Dispose()
+				return true
+			}
+		}
 	} else if strings.HasPrefix(sessionId, base.UkPreFlvSubSession) {
 		// TODO chef: 考虑数据结构改成sessionIdzuokey的map
 		for s := range group.httpflvSubSessionSet {
@@ -404,6 +519,15 @@ func (group *Group) HasOutSession() bool {
 	return group.hasOutSession()
 }
 
+// SubSessionNum 播放者的个数，不包含转推
+func (group *Group) SubSessionNum() int {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+	return len(group.rtmpSubSessionSet) + len(group.rtspSubSessionSet) + len(group.waitRtspSubSessionSet) +
+		len(group.httpflvSubSessionSet) + len(group.httptsSubSessionSet) + len(group.hlsSubSessionSet) +
+		len(group.srtSubSessionSet) + len(group.webrtcSubSessionSet)
+}
+
 func (group *Group) OutSessionNum() int {
 	// TODO(chef): 没有包含hls的播放者
 
@@ -426,7 +550,7 @@ func (group *Group) OutSessionNum() int {
 	//	}
 	//}
 	return len(group.rtmpSubSessionSet) + len(group.rtspSubSessionSet) + len(group.waitRtspSubSessionSet) +
-		len(group.httpflvSubSessionSet) + len(group.httptsSubSessionSet) + pushNum
+		len(group.httpflvSubSessionSet) + len(group.httptsSubSessionSet) + len(group.srtSubSessionSet) + len(group.webrtcSubSessionSet) + pushNum
 }
 
 // ---------------------------------------------------------------------------------------------------------------------
@@ -471,6 +595,18 @@ func (group *Group) disposeInactiveSessions(tickCount uint32) {
 			group.rtspPubSession.Dispose()
 		}
 	}
+	if group.srtPubSession != nil {
+		if readAlive, _ := group.srtPubSession.IsAlive(); !readAlive {
+			Log.Warnf("[%s] session timeout. session=%s", group.UniqueKey, group.srtPubSession.UniqueKey())
+			group.srtPubSession.Dispose()
+		}
+	}
+	if group.webrtcPubSession != nil {
+		if readAlive, _ := group.webrtcPubSession.IsAlive(); !readAlive {
+			Log.Warnf("[%s] session timeout. session=%s", group.UniqueKey, group.webrtcPubSession.UniqueKey())
+			group.webrtcPubSession.Dispose()
+		}
+	}
 
 	group.disposeInactivePullSession()
 
@@ -504,6 +640,18 @@ func (group *Group) disposeInactiveSessions(tickCount uint32) {
 			session.Dispose()
 		}
 	}
+	for session := range group.srtSubSessionSet {
+		if _, writeAlive := session.IsAlive(); !writeAlive {
+			Log.Warnf("[%s] session timeout. session=%s", group.UniqueKey, session.UniqueKey())
+			session.Dispose()
+		}
+	}
+	for session := range group.webrtcSubSessionSet {
+		if _, writeAlive := session.IsAlive(); !writeAlive {
+			Log.Warnf("[%s] session timeout. session=%s", group.UniqueKey, session.UniqueKey())
+			session.Dispose()
+		}
+	}
 	group.url2PushProxy.Range(func(key, value any) bool {
 		item := value.(*pushProxy)
 		session := item.pushSession
@@ -537,6 +685,12 @@ func (group *Group) updateAllSessionStat() {
 	if group.psPubSession != nil {
 		group.psPubSession.UpdateStat(calcSessionStatIntervalSec)
 	}
+	if group.srtPubSession != nil {
+		group.srtPubSession.UpdateStat(calcSessionStatIntervalSec)
+	}
+	if group.webrtcPubSession != nil {
+		group.webrtcPubSession.UpdateStat(calcSessionStatIntervalSec)
+	}
 
 	group.updatePullSessionStat()
 
@@ -555,6 +709,12 @@ func (group *Group) updateAllSessionStat() {
 	for session := range group.waitRtspSubSessionSet {
 		session.UpdateStat(calcSessionStatIntervalSec)
 	}
+	for session := range group.srtSubSessionSet {
+		session.UpdateStat(calcSessionStatIntervalSec)
+	}
+	for session := range group.webrtcSubSessionSet {
+		session.UpdateStat(calcSessionStatIntervalSec)
+	}
 	group.url2PushProxy.Range(func(key, value any) bool {
 		item := value.(*pushProxy)
 		session := item.pushSession
@@ -573,7 +733,7 @@ func (group *Group) updateAllSessionStat() {
 
 func (group *Group) hasPubSession() bool {
 	return group.rtmpPubSession != nil || group.rtspPubSession != nil || group.customizePubSession != nil ||
-		group.psPubSession != nil
+		group.psPubSession != nil || group.srtPubSession != nil || group.webrtcPubSession != nil
 }
 
 func (group *Group) hasSubSession() bool {
@@ -582,7 +742,9 @@ func (group *Group) hasSubSession() bool {
 		len(group.httptsSubSessionSet) != 0 ||
 		len(group.rtspSubSessionSet) != 0 ||
 		len(group.waitRtspSubSessionSet) != 0 ||
-		len(group.hlsSubSessionSet) != 0
+		len(group.hlsSubSessionSet) != 0 ||
+		len(group.srtSubSessionSet) != 0 ||
+		len(group.webrtcSubSessionSet) != 0
 }
 
 func (group *Group) hasPushSession() bool {
@@ -627,19 +789,30 @@ func (group *Group) inSessionUniqueKey() string {
 	if group.psPubSession != nil {
 		return group.psPubSession.UniqueKey()
 	}
+	if group.srtPubSession != nil {
+		return group.srtPubSession.UniqueKey()
+	}
+	if group.webrtcPubSession != nil {
+		return group.webrtcPubSession.UniqueKey()
+	}
 	return group.pullSessionUniqueKey()
 }
 
 func (group *Group) shouldStartRtspRemuxer() bool {
-	return group.config.RtspConfig.Enable || group.config.RtspConfig.RtspsEnable
+	return group.config.RtspConfig.Enable || group.config.RtspConfig.RtspsEnable ||
+		group.config.WebrtcConfig.Enable
 }
 
 func (group *Group) shouldStartMpegtsRemuxer() bool {
 	return (group.config.HlsConfig.Enable || group.config.HlsConfig.EnableHttps) ||
 		(group.config.HttptsConfig.Enable || group.config.HttptsConfig.EnableHttps) ||
-		group.config.RecordConfig.EnableMpegts
+		group.config.RecordConfig.EnableMpegts ||
+		group.config.SrtConfig.Enable
 }
 
 func (group *Group) OnHlsMakeTs(info base.HlsMakeTsInfo) {
+	if info.Event == "close" {
+		group.counter.hlsFragment.Increment()
+	}
 	group.observer.OnHlsMakeTs(info)
 }
@@ -0,0 +1,116 @@
+// Copyright 2023, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"net/url"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/httpflv"
+	"github.com/q191201771/lal/pkg/rtmp"
+)
+
+// group__core_enhanced_rtmp.go
+//
+// Enhanced RTMP
+//
+// 输入的Enhanced RTMP格式的HEVC，进入group时统一转换为CodecId为12的传统格式，其他协议的转封装都基于传统格式。
+//
+// 输出HEVC时，按拉流端决定格式：
+// - rtmp拉流端在connect信令的`fourCcList`中声明了`hvc1`，则输出Enhanced RTMP格式
+// - httpflv拉流端在url参数中带上`enhanced=1`，则输出Enhanced RTMP格式，比如 http://127.0.0.1:8080/live/test110.flv?enhanced=1
+// - 其他情况输出传统格式
+//
+// AV1，VP9，以及音频Opus只有Enhanced RTMP格式，所有拉流端都直接透传。
+//
+
+func (group *Group) isEnhancedRtmpSubSession(session *rtmp.ServerSession) bool {
+	return group.hevcInput && session.SupportFourCc(base.RtmpFourCcHevc)
+}
+
+func (group *Group) isEnhancedHttpflvSubSession(session *httpflv.SubSession) bool {
+	if !group.hevcInput {
+		return false
+	}
+	_, ok := group.httpflvEnhancedSubSessionSet[session]
+	return ok
+}
+
+// wantEnhancedRtmp httpflv拉流端的url参数中是否带有`enhanced=1`
+func wantEnhancedRtmp(rawQuery string) bool {
+	values, _ := url.ParseQuery(rawQuery)
+	return values.Get("enhanced") == "1"
+}
+
+// writeEnhancedRtmpSubSession 逻辑与普通的rtmp拉流端相同，区别是使用Enhanced RTMP格式的gop缓存和数据
+//
+// 注意，不使用 rtmpMergeWriter
+func (group *Group) writeEnhancedRtmpSubSession(session *rtmp.ServerSession, msg base.RtmpMsg, chunks []byte) {
+	if session.IsFresh {
+		if group.rtmpGopCache.MetadataEnsureWithoutSetDataFrame != nil {
+			_ = session.Write(group.rtmpGopCache.MetadataEnsureWithoutSetDataFrame)
+		}
+		if group.rtmpEnhancedGopCache.VideoSeqHeader != nil {
+			_ = session.Write(group.rtmpEnhancedGopCache.VideoSeqHeader)
+		}
+		if group.rtmpGopCache.AacSeqHeader != nil {
+			_ = session.Write(group.rtmpGopCache.AacSeqHeader)
+		}
+		gopCount := group.rtmpEnhancedGopCache.GetGopCount()
+		if gopCount > 0 {
+			session.ShouldWaitVideoKeyFrame = false
+		}
+		for i := 0; i < gopCount; i++ {
+			for _, item := range group.rtmpEnhancedGopCache.GetGopDataAt(i) {
+				_ = session.Write(item)
+			}
+		}
+		session.IsFresh = false
+	}
+
+	if session.ShouldWaitVideoKeyFrame {
+		if !msg.IsVideoKeyNalu() {
+			return
+		}
+		session.ShouldWaitVideoKeyFrame = false
+	}
+	_ = session.Write(chunks)
+}
+
+func (group *Group) writeEnhancedHttpflvSubSession(session *httpflv.SubSession, msg base.RtmpMsg, tag []byte) {
+	if session.IsFresh {
+		if group.httpflvGopCache.MetadataEnsureWithoutSetDataFrame != nil {
+			session.Write(group.httpflvGopCache.MetadataEnsureWithoutSetDataFrame)
+		}
+		if group.httpflvEnhancedGopCache.VideoSeqHeader != nil {
+			session.Write(group.httpflvEnhancedGopCache.VideoSeqHeader)
+		}
+		if group.httpflvGopCache.AacSeqHeader != nil {
+			session.Write(group.httpflvGopCache.AacSeqHeader)
+		}
+		gopCount := group.httpflvEnhancedGopCache.GetGopCount()
+		if gopCount > 0 {
+			session.ShouldWaitVideoKeyFrame = false
+		}
+		for i := 0; i < gopCount; i++ {
+			for _, item := range group.httpflvEnhancedGopCache.GetGopDataAt(i) {
+				session.Write(item)
+			}
+		}
+		session.IsFresh = false
+	}
+
+	if session.ShouldWaitVideoKeyFrame {
+		if !msg.IsVideoKeyNalu() {
+			return
+		}
+		session.ShouldWaitVideoKeyFrame = false
+	}
+	session.Write(tag)
+}
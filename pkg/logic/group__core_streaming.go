@@ -38,6 +38,13 @@ import (
 func (group *Group) OnReadRtmpAvMsg(msg base.RtmpMsg) {
 	group.mutex.Lock()
 	defer group.mutex.Unlock()
+
+	// Enhanced RTMP格式的HEVC转换为传统格式，见 group__core_enhanced_rtmp.go
+	msg, ok := remux.EnhancedRtmpMsg2Legacy(msg)
+	if !ok {
+		return
+	}
+
 	if group.dummyAudioFilter != nil {
 		group.dummyAudioFilter.Feed(msg)
 	} else {
@@ -56,6 +63,7 @@ func (group *Group) OnSdp(sdpCtx sdp.LogicContext) {
 	defer group.mutex.Unlock()
 	group.sdpCtx = &sdpCtx
 	group.feedWaitRtspSubSessions()
+	group.feedWebrtcSubSessionsSdp()
 	if group.rtsp2RtmpRemuxer != nil {
 		group.rtsp2RtmpRemuxer.OnSdp(sdpCtx)
 	}
@@ -105,6 +113,18 @@ func (group *Group) OnAvPacketFromPsPubSession(pkt *base.AvPacket) {
 	}
 }
 
+// OnAvPacketFromSrtPubSession
+//
+// 来自 srt.PubSession 的回调.
+func (group *Group) OnAvPacketFromSrtPubSession(pkt *base.AvPacket) {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+
+	if group.rtsp2RtmpRemuxer != nil {
+		group.rtsp2RtmpRemuxer.OnAvPacket(*pkt)
+	}
+}
+
 // ---------------------------------------------------------------------------------------------------------------------
 
 // OnPatPmt OnTsPackets
@@ -118,10 +138,12 @@ func (group *Group) OnPatPmt(b []byte) {
 		group.hlsMuxer.FeedPatPmt(b)
 	}
 
+	if group.recordHls != nil {
+		group.recordHls.FeedPatPmt(b)
+	}
+
 	if group.recordMpegts != nil {
-		if err := group.recordMpegts.Write(b); err != nil {
-			Log.Errorf("[%s] record mpegts write fragment header error. err=%+v", group.UniqueKey, err)
-		}
+		group.doWriteRecordMpegts(b)
 	}
 }
 
@@ -153,6 +175,7 @@ func (group *Group) onRtmpMsgFromRemux(msg base.RtmpMsg) {
 func (group *Group) onSdpFromRemux(sdpCtx sdp.LogicContext) {
 	group.sdpCtx = &sdpCtx
 	group.feedWaitRtspSubSessions()
+	group.feedWebrtcSubSessionsSdp()
 }
 
 // onRtpPacketFromRemux ...
@@ -203,6 +226,20 @@ func (group *Group) broadcastByRtmpMsg(msg base.RtmpMsg) {
 		return
 	}
 
+	// # Enhanced RTMP格式的输出，只有HEVC视频数据需要转换，其他数据与传统格式共用
+	if msg.IsHevcKeySeqHeader() {
+		group.hevcInput = true
+	}
+	lazyEnhancedRtmpChunkDivider := &lazyRtmpChunkDivider
+	lazyEnhancedRtmpMsg2FlvTag := &lazyRtmpMsg2FlvTag
+	if group.hevcInput && msg.Header.MsgTypeId == base.RtmpTypeIdVideo {
+		enhancedMsg := remux.LegacyRtmpMsg2Enhanced(msg)
+		lazyEnhancedRtmpChunkDivider = &remux.LazyRtmpChunkDivider{}
+		lazyEnhancedRtmpChunkDivider.Init(enhancedMsg)
+		lazyEnhancedRtmpMsg2FlvTag = &remux.LazyRtmpMsg2FlvTag{}
+		lazyEnhancedRtmpMsg2FlvTag.Init(enhancedMsg)
+	}
+
 	// TODO(chef): 暂时不打开，因为过滤掉了innertest中rtmp和flv的输出和输入就不完全相同了
 	//if msg.Header.MsgTypeId == base.RtmpTypeIdAudio {
 	//	if len(msg.Payload) <= 2 {
@@ -228,11 +265,18 @@ func (group *Group) broadcastByRtmpMsg(msg base.RtmpMsg) {
 	//	}
 	//}
 
+	group.cacheRecordSeqHeader(msg)
+
 	// # mpegts remuxer
 	if group.rtmp2MpegtsRemuxer != nil {
 		group.rtmp2MpegtsRemuxer.FeedRtmpMessage(msg)
 	}
 
+	// # fmp4格式的hls，TS格式的hls通过 rtmp2MpegtsRemuxer 输入
+	if group.hlsMuxer != nil {
+		group.hlsMuxer.FeedRtmpMessage(msg)
+	}
+
 	// # rtsp
 	if group.rtmp2RtspRemuxer != nil {
 		group.rtmp2RtspRemuxer.FeedRtmpMsg(msg)
@@ -241,6 +285,11 @@ func (group *Group) broadcastByRtmpMsg(msg base.RtmpMsg) {
 	// # 广播。遍历所有 rtmp sub session，转发数据
 	// ## 如果是新的 sub session，发送已缓存的信息
 	for session := range group.rtmpSubSessionSet {
+		if group.isEnhancedRtmpSubSession(session) {
+			group.writeEnhancedRtmpSubSession(session, msg, lazyEnhancedRtmpChunkDivider.GetEnsureWithoutSdf())
+			continue
+		}
+
 		if session.IsFresh {
 			// TODO chef: 头信息和full gop也可以在SubSession刚加入时发送
 			if group.rtmpGopCache.MetadataEnsureWithoutSetDataFrame != nil {
@@ -326,6 +375,14 @@ func (group *Group) broadcastByRtmpMsg(msg base.RtmpMsg) {
 				v.pushSession.IsFresh = false
 			}
 
+			if v.aggregateMerger != nil {
+				if msg.Header.MsgTypeId == base.RtmpTypeIdAudio || msg.Header.MsgTypeId == base.RtmpTypeIdVideo {
+					v.aggregateMerger.Feed(msg)
+					return true
+				}
+				// 非音视频数据，先把已合并的音视频数据发送出去，保证顺序
+				v.aggregateMerger.Flush()
+			}
 			_ = v.pushSession.Write(lazyRtmpChunkDivider.GetEnsureWithSdf())
 			return true
 		})
@@ -359,6 +416,11 @@ func (group *Group) broadcastByRtmpMsg(msg base.RtmpMsg) {
 
 	// # 广播。遍历所有 httpflv sub session，转发数据
 	for session := range group.httpflvSubSessionSet {
+		if group.isEnhancedHttpflvSubSession(session) {
+			group.writeEnhancedHttpflvSubSession(session, msg, lazyEnhancedRtmpMsg2FlvTag.GetEnsureWithoutSdf())
+			continue
+		}
+
 		if session.IsFresh {
 			if group.httpflvGopCache.MetadataEnsureWithoutSetDataFrame != nil {
 				session.Write(group.httpflvGopCache.MetadataEnsureWithoutSetDataFrame)
@@ -396,9 +458,12 @@ func (group *Group) broadcastByRtmpMsg(msg base.RtmpMsg) {
 
 	// # 录制flv文件
 	if group.recordFlv != nil {
-		if err := group.recordFlv.WriteRaw(lazyRtmpMsg2FlvTag.GetEnsureWithoutSdf()); err != nil {
-			Log.Errorf("[%s] record flv write error. err=%+v", group.UniqueKey, err)
-		}
+		group.writeRecordFlv(msg, lazyRtmpMsg2FlvTag.GetEnsureWithoutSdf())
+	}
+
+	// # 录制mp4文件
+	if group.rtmp2Fmp4Remuxer != nil {
+		group.rtmp2Fmp4Remuxer.FeedRtmpMessage(msg)
 	}
 
 	// # 缓存关键信息，以及gop
@@ -415,12 +480,30 @@ func (group *Group) broadcastByRtmpMsg(msg base.RtmpMsg) {
 			group.httpflvGopCache.SetMetadata(lazyRtmpMsg2FlvTag.GetEnsureWithoutSdf(), lazyRtmpMsg2FlvTag.GetEnsureWithoutSdf())
 		}
 	}
+	if group.hevcInput {
+		if group.config.RtmpConfig.Enable || group.config.RtmpConfig.RtmpsEnable {
+			group.rtmpEnhancedGopCache.Feed(msg, lazyEnhancedRtmpChunkDivider.GetEnsureWithoutSdf())
+		}
+		if group.config.HttpflvConfig.Enable {
+			group.httpflvEnhancedGopCache.Feed(msg, lazyEnhancedRtmpMsg2FlvTag.GetEnsureWithoutSdf())
+		}
+	}
 
 	// # 记录stat
 	if group.stat.AudioCodec == "" {
 		if msg.IsAacSeqHeader() {
 			group.stat.AudioCodec = base.AudioCodecAac
 		}
+		switch msg.AudioPayloadType() {
+		case base.AvPacketPtG711A:
+			group.stat.AudioCodec = base.AudioCodecG711A
+		case base.AvPacketPtG711U:
+			group.stat.AudioCodec = base.AudioCodecG711U
+		case base.AvPacketPtMp3:
+			group.stat.AudioCodec = base.AudioCodecMp3
+		case base.AvPacketPtOpus:
+			group.stat.AudioCodec = base.AudioCodecOpus
+		}
 	}
 	if group.stat.VideoCodec == "" {
 		if msg.IsAvcKeySeqHeader() {
@@ -429,6 +512,14 @@ func (group *Group) broadcastByRtmpMsg(msg base.RtmpMsg) {
 		if msg.IsHevcKeySeqHeader() {
 			group.stat.VideoCodec = base.VideoCodecHevc
 		}
+		if msg.IsEnhancedKeySeqHeader() {
+			switch msg.VideoFourCc() {
+			case base.RtmpFourCcAv1:
+				group.stat.VideoCodec = base.VideoCodecAv1
+			case base.RtmpFourCcVp9:
+				group.stat.VideoCodec = base.VideoCodecVp9
+			}
+		}
 	}
 	if group.stat.VideoHeight == 0 || group.stat.VideoWidth == 0 {
 		if msg.IsAvcKeySeqHeader() {
@@ -459,30 +550,11 @@ func (group *Group) broadcastByRtmpMsg(msg base.RtmpMsg) {
 // ---------------------------------------------------------------------------------------------------------------------
 
 func (group *Group) feedRtpPacket(pkt rtprtcp.RtpPacket) {
-	// 如果配置项 OutWaitKeyFrameFlag 为false，则音频和视频都直接发送。（音频和视频都不等待视频关键帧，都不等待任何数据）
-	if !group.config.RtspConfig.OutWaitKeyFrameFlag {
-		for s := range group.rtspSubSessionSet {
-			s.WriteRtpPacket(pkt)
-		}
-		return
-	}
-
 	var (
 		boundary        bool // 是否是视频GOP起始位置
 		boundaryChecked bool // 保证遍历sub session时，只在必要时检查0次或1次，减少性能开销
 	)
-
-	for s := range group.rtspSubSessionSet {
-		// session的 ShouldWaitVideoKeyFrame 为false，那么可能有两种情况：
-		// 1. 对输入流做智能检测时，判定为流内没有视频
-		// 2. 该输出流已经发送过了GOP起始数据
-		//
-		// 这两种情况下，音频或视频数据都直接发送，不需要等了
-		if !s.ShouldWaitVideoKeyFrame {
-			s.WriteRtpPacket(pkt)
-			continue
-		}
-
+	checkBoundary := func() bool {
 		if !boundaryChecked {
 			switch group.sdpCtx.GetVideoPayloadTypeBase() {
 			case base.AvPacketPtAvc:
@@ -495,10 +567,45 @@ func (group *Group) feedRtpPacket(pkt rtprtcp.RtpPacket) {
 			}
 			boundaryChecked = true
 		}
+		return boundary
+	}
 
-		if boundary {
+	// 如果配置项 OutWaitKeyFrameFlag 为false，则音频和视频都直接发送。（音频和视频都不等待视频关键帧，都不等待任何数据）
+	if !group.config.RtspConfig.OutWaitKeyFrameFlag {
+		for s := range group.rtspSubSessionSet {
 			s.WriteRtpPacket(pkt)
+		}
+	} else {
+		for s := range group.rtspSubSessionSet {
+			// session的 ShouldWaitVideoKeyFrame 为false，那么可能有两种情况：
+			// 1. 对输入流做智能检测时，判定为流内没有视频
+			// 2. 该输出流已经发送过了GOP起始数据
+			//
+			// 这两种情况下，音频或视频数据都直接发送，不需要等了
+			if !s.ShouldWaitVideoKeyFrame {
+				s.WriteRtpPacket(pkt)
+				continue
+			}
+
+			if checkBoundary() {
+				s.WriteRtpPacket(pkt)
+				s.ShouldWaitVideoKeyFrame = false
+			}
+		}
+	}
+
+	group.feedRtspMulticast(pkt, checkBoundary)
+
+	// webrtc拉流端（浏览器）必须从关键帧开始解码，所以不受 OutWaitKeyFrameFlag 控制，总是等待关键帧
+	for s := range group.webrtcSubSessionSet {
+		if !s.ShouldWaitVideoKeyFrame {
+			s.WriteRtpPacket(pkt)
+			continue
+		}
+
+		if checkBoundary() {
 			s.ShouldWaitVideoKeyFrame = false
+			s.WriteRtpPacket(pkt)
 		}
 	}
 }
@@ -511,6 +618,11 @@ func (group *Group) feedTsPackets(tsPackets []byte, frame *mpegts.Frame, boundar
 		group.hlsMuxer.FeedMpegts(tsPackets, frame, boundary)
 	}
 
+	if group.recordHls != nil {
+		group.recordHls.FeedMpegts(tsPackets, frame, boundary)
+		group.recordHlsSegment.updateTs(uint32(frame.Dts / 90))
+	}
+
 	// # 遍历 httpts sub session
 	for session := range group.httptsSubSessionSet {
 		if session.IsFresh {
@@ -549,12 +661,39 @@ func (group *Group) feedTsPackets(tsPackets []byte, frame *mpegts.Frame, boundar
 		}
 	} // for loop iterate httptsSubSessionSet
 
-	if group.recordMpegts != nil {
-		if err := group.recordMpegts.Write(tsPackets); err != nil {
-			Log.Errorf("[%s] record mpegts write error. err=%+v", group.UniqueKey, err)
+	// # 遍历 srt sub session，逻辑与httpts相同
+	for session := range group.srtSubSessionSet {
+		if session.IsFresh {
+			session.Write(group.patpmt)
+
+			gopCount := group.httptsGopCache.GetGopCount()
+			for i := 0; i < gopCount; i++ {
+				for _, item := range group.httptsGopCache.GetGopDataAt(i) {
+					session.Write(item)
+				}
+			}
+			if gopCount > 0 {
+				session.ShouldWaitBoundary = false
+			}
+
+			session.IsFresh = false
+		}
+
+		if session.ShouldWaitBoundary {
+			if boundary {
+				session.Write(tsPackets)
+
+				session.ShouldWaitBoundary = false
+			}
+		} else {
+			session.Write(tsPackets)
 		}
 	}
 
+	if group.recordMpegts != nil {
+		group.writeRecordMpegts(tsPackets, frame, boundary)
+	}
+
 	group.httptsGopCache.Feed(tsPackets, boundary)
 }
 
@@ -562,7 +701,7 @@ func (group *Group) feedTsPackets(tsPackets []byte, frame *mpegts.Frame, boundar
 
 func (group *Group) write2RtmpSubSessions(b []byte) {
 	for session := range group.rtmpSubSessionSet {
-		if session.IsFresh || session.ShouldWaitVideoKeyFrame {
+		if session.IsFresh || session.ShouldWaitVideoKeyFrame || group.isEnhancedRtmpSubSession(session) {
 			continue
 		}
 		_ = session.Write(b)
@@ -571,7 +710,7 @@ func (group *Group) write2RtmpSubSessions(b []byte) {
 
 func (group *Group) writev2RtmpSubSessions(bs net.Buffers) {
 	for session := range group.rtmpSubSessionSet {
-		if session.IsFresh || session.ShouldWaitVideoKeyFrame {
+		if session.IsFresh || session.ShouldWaitVideoKeyFrame || group.isEnhancedRtmpSubSession(session) {
 			continue
 		}
 		_ = session.Writev(bs)
@@ -585,3 +724,10 @@ func (group *Group) feedWaitRtspSubSessions() {
 		session.FeedSdp(*group.sdpCtx)
 	}
 }
+
+// feedWebrtcSubSessionsSdp 输入流的sdp确定或改变时，webrtc拉流端需要重新选择发送的音视频
+func (group *Group) feedWebrtcSubSessionsSdp() {
+	for session := range group.webrtcSubSessionSet {
+		session.FeedSdp(*group.sdpCtx)
+	}
+}
@@ -17,6 +17,8 @@ import (
 	"github.com/q191201771/lal/pkg/remux"
 	"github.com/q191201771/lal/pkg/rtmp"
 	"github.com/q191201771/lal/pkg/rtsp"
+	"github.com/q191201771/lal/pkg/srt"
+	"github.com/q191201771/lal/pkg/webrtc"
 )
 
 func (group *Group) AddCustomizePubSession(streamName string) (ICustomizePubSessionContext, error) {
@@ -159,6 +161,65 @@ func (group *Group) StartRtpPub(req base.ApiCtrlStartRtpPubReq) (ret base.ApiCtr
 	return
 }
 
+func (group *Group) AddSrtPubSession(session *srt.PubSession) error {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+
+	if group.hasInSession() {
+		Log.Errorf("[%s] in stream already exist at group. add=%s, exist=%s",
+			group.UniqueKey, session.UniqueKey(), group.inSessionUniqueKey())
+		return base.ErrDupInStream
+	}
+
+	Log.Debugf("[%s] [%s] add srt PubSession into group.", group.UniqueKey, session.UniqueKey())
+
+	group.srtPubSession = session
+	group.addIn()
+
+	// srt的负载是mpegts，解析后视频为Annexb格式，音频为ADTS格式
+	group.rtsp2RtmpRemuxer = remux.NewAvPacket2RtmpRemuxer()
+	group.rtsp2RtmpRemuxer.WithOption(func(option *base.AvPacketStreamOption) {
+		option.VideoFormat = base.AvPacketStreamVideoFormatAnnexb
+		option.AudioFormat = base.AvPacketStreamAudioFormatAdtsAac
+	})
+	group.rtsp2RtmpRemuxer.WithOnRtmpMsg(group.onRtmpMsgFromRemux)
+
+	if group.shouldStartRtspRemuxer() {
+		group.rtmp2RtspRemuxer = remux.NewRtmp2RtspRemuxer(
+			group.onSdpFromRemux,
+			group.onRtpPacketFromRemux,
+		)
+	}
+
+	session.WithOnAvPacket(group.OnAvPacketFromSrtPubSession)
+
+	return nil
+}
+
+// AddWebrtcPubSession
+//
+// webrtc.PubSession 内部使用 rtsp.BaseInSession ，所以后续流程和rtsp推流相同
+func (group *Group) AddWebrtcPubSession(session *webrtc.PubSession) error {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+
+	if group.hasInSession() {
+		Log.Errorf("[%s] in stream already exist at group. add=%s, exist=%s",
+			group.UniqueKey, session.UniqueKey(), group.inSessionUniqueKey())
+		return base.ErrDupInStream
+	}
+
+	Log.Debugf("[%s] [%s] add webrtc PubSession into group.", group.UniqueKey, session.UniqueKey())
+
+	group.webrtcPubSession = session
+	group.addIn()
+
+	group.rtsp2RtmpRemuxer = remux.NewAvPacket2RtmpRemuxer().WithOnRtmpMsg(group.onRtmpMsgFromRemux)
+	session.SetObserver(group)
+
+	return nil
+}
+
 func (group *Group) AddRtmpPullSession(session *rtmp.PullSession) error {
 	group.mutex.Lock()
 	defer group.mutex.Unlock()
@@ -252,6 +313,18 @@ func (group *Group) DelRtspPubSession(session *rtsp.PubSession) {
 	group.delRtspPubSession(session)
 }
 
+func (group *Group) DelSrtPubSession(session *srt.PubSession) {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+	group.delSrtPubSession(session)
+}
+
+func (group *Group) DelWebrtcPubSession(session *webrtc.PubSession) {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+	group.delWebrtcPubSession(session)
+}
+
 func (group *Group) DelRtmpPullSession(session *rtmp.PullSession) {
 	group.mutex.Lock()
 	defer group.mutex.Unlock()
@@ -339,6 +412,30 @@ func (group *Group) delRtspPubSession(session *rtsp.PubSession) {
 	group.delIn()
 }
 
+func (group *Group) delSrtPubSession(session *srt.PubSession) {
+	Log.Debugf("[%s] [%s] del srt PubSession from group.", group.UniqueKey, session.UniqueKey())
+
+	if session != group.srtPubSession {
+		Log.Warnf("[%s] del srt pub session but not match. del session=%s, group session=%p",
+			group.UniqueKey, session.UniqueKey(), group.srtPubSession)
+		return
+	}
+
+	group.delIn()
+}
+
+func (group *Group) delWebrtcPubSession(session *webrtc.PubSession) {
+	Log.Debugf("[%s] [%s] del webrtc PubSession from group.", group.UniqueKey, session.UniqueKey())
+
+	if session != group.webrtcPubSession {
+		Log.Warnf("[%s] del webrtc pub session but not match. del session=%s, group session=%p",
+			group.UniqueKey, session.UniqueKey(), group.webrtcPubSession)
+		return
+	}
+
+	group.delIn()
+}
+
 func (group *Group) delPullSession(session base.IObject) {
 	Log.Debugf("[%s] [%s] del PullSession from group.", group.UniqueKey, session.UniqueKey())
 
@@ -365,6 +462,8 @@ func (group *Group) addIn() {
 	group.startHlsIfNeeded()
 	group.startRecordFlvIfNeeded(now)
 	group.startRecordMpegtsIfNeeded(now)
+	group.startRecordMp4IfNeeded(now)
+	group.startTranscodeIfNeeded()
 }
 
 // delIn 有pub或pull的输入型session离开时，需要调用该函数
@@ -375,18 +474,28 @@ func (group *Group) delIn() {
 		group.rtmp2MpegtsRemuxer = nil
 	}
 
+	group.stopTranscodeIfNeeded()
 	group.stopPushIfNeeded()
 	group.stopHlsIfNeeded()
 	group.stopRecordFlvIfNeeded()
 	group.stopRecordMpegtsIfNeeded()
+	group.stopRecordMp4IfNeeded()
+	group.stopRecordHlsIfNeeded()
+	group.recordMetadata = nil
+	group.recordVideoSeqHeader = nil
+	group.recordAudioSeqHeader = nil
 
 	group.rtmpPubSession = nil
 	group.rtspPubSession = nil
 	group.customizePubSession = nil
 	group.psPubSession = nil
+	group.srtPubSession = nil
+	group.webrtcPubSession = nil
 	group.rtsp2RtmpRemuxer = nil
 	group.rtmp2RtspRemuxer = nil
 	group.dummyAudioFilter = nil
+	// 下次输入流的sdp可能不同，需要时重新创建
+	group.disposeRtspMulticastSender()
 
 	if group.psPubDumpFile != nil {
 		group.psPubDumpFile.Close()
@@ -395,6 +504,9 @@ func (group *Group) delIn() {
 	group.rtmpGopCache.Clear()
 	group.httpflvGopCache.Clear()
 	group.httptsGopCache.Clear()
+	group.rtmpEnhancedGopCache.Clear()
+	group.httpflvEnhancedGopCache.Clear()
+	group.hevcInput = false
 	group.sdpCtx = nil
 	group.patpmt = nil
 }
@@ -0,0 +1,95 @@
+// Copyright 2023, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"math"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/rtprtcp"
+	"github.com/q191201771/naza/pkg/nazaatomic"
+)
+
+// groupCounter group生命周期内的累计计数，使用原子变量，累加时不需要额外加锁
+type groupCounter struct {
+	pullRetry   nazaatomic.Uint64 // relay pull重试次数，不包含第一次pull
+	pushRetry   nazaatomic.Uint64 // relay push重试次数，同一个转推地址再次启动转推时累加
+	hlsFragment nazaatomic.Uint64 // 生成的hls分片数量，包含hls录制生成的分片
+}
+
+// groupMetrics 用于 /metrics 导出的group快照
+type groupMetrics struct {
+	stat      base.StatGroup
+	pushStats []base.StatSession
+
+	pullRetryCount   uint64
+	pushRetryCount   uint64
+	hlsFragmentCount uint64
+
+	gopCacheCounts []gopCacheCount
+	rtpRecvStats   []rtpRecvStat
+}
+
+type gopCacheCount struct {
+	name  string
+	count int
+}
+
+type rtpRecvStat struct {
+	sessionId string
+	protocol  string
+	media     string // "audio" 或 "video"
+	stat      rtprtcp.RrStat
+}
+
+// getMetrics 获取group的快照
+//
+// 只加一次group锁，并且锁内只做拷贝，格式化在锁外进行
+func (group *Group) getMetrics() groupMetrics {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+
+	m := groupMetrics{
+		stat:             group.getStat(math.MaxInt32),
+		pullRetryCount:   group.counter.pullRetry.Load(),
+		pushRetryCount:   group.counter.pushRetry.Load(),
+		hlsFragmentCount: group.counter.hlsFragment.Load(),
+		gopCacheCounts: []gopCacheCount{
+			{"rtmp", group.rtmpGopCache.GetGopCount()},
+			{"httpflv", group.httpflvGopCache.GetGopCount()},
+			{"rtmp_enhanced", group.rtmpEnhancedGopCache.GetGopCount()},
+			{"httpflv_enhanced", group.httpflvEnhancedGopCache.GetGopCount()},
+			{"httpts", group.httptsGopCache.GetGopCount()},
+		},
+	}
+
+	group.url2PushProxy.Range(func(key, value any) bool {
+		item := value.(*pushProxy)
+		if item.isPushing && item.pushSession != nil {
+			m.pushStats = append(m.pushStats, item.pushSession.GetStat())
+		}
+		return true
+	})
+
+	if group.rtspPubSession != nil {
+		audio, video := group.rtspPubSession.GetRtpRecvStat()
+		m.appendRtpRecvStat(group.rtspPubSession.GetStat(), audio, video)
+	}
+	if group.pullProxy.rtspSession != nil {
+		audio, video := group.pullProxy.rtspSession.GetRtpRecvStat()
+		m.appendRtpRecvStat(group.pullProxy.rtspSession.GetStat(), audio, video)
+	}
+	return m
+}
+
+func (m *groupMetrics) appendRtpRecvStat(session base.StatSession, audio, video rtprtcp.RrStat) {
+	m.rtpRecvStats = append(m.rtpRecvStats,
+		rtpRecvStat{sessionId: session.SessionId, protocol: session.Protocol, media: "audio", stat: audio},
+		rtpRecvStat{sessionId: session.SessionId, protocol: session.Protocol, media: "video", stat: video})
+}
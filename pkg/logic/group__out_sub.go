@@ -14,6 +14,8 @@ import (
 	"github.com/q191201771/lal/pkg/httpts"
 	"github.com/q191201771/lal/pkg/rtmp"
 	"github.com/q191201771/lal/pkg/rtsp"
+	"github.com/q191201771/lal/pkg/srt"
+	"github.com/q191201771/lal/pkg/webrtc"
 )
 
 func (group *Group) AddRtmpSubSession(session *rtmp.ServerSession) {
@@ -41,6 +43,9 @@ func (group *Group) AddHttpflvSubSession(session *httpflv.SubSession) {
 	group.mutex.Lock()
 	defer group.mutex.Unlock()
 	group.httpflvSubSessionSet[session] = struct{}{}
+	if wantEnhancedRtmp(session.RawQuery()) {
+		group.httpflvEnhancedSubSessionSet[session] = struct{}{}
+	}
 	// 加入时，如果上行还没有推流过，就不需要等待关键帧了
 	if group.stat.VideoCodec == "" {
 		session.ShouldWaitVideoKeyFrame = false
@@ -61,6 +66,35 @@ func (group *Group) AddHttptsSubSession(session *httpts.SubSession) {
 	group.addSub()
 }
 
+// AddSrtSubSession ...
+func (group *Group) AddSrtSubSession(session *srt.SubSession) {
+	Log.Debugf("[%s] [%s] add srt SubSession into group.", group.UniqueKey, session.UniqueKey())
+
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+	group.srtSubSessionSet[session] = struct{}{}
+
+	group.addSub()
+}
+
+// AddWebrtcSubSession ...
+func (group *Group) AddWebrtcSubSession(session *webrtc.SubSession) {
+	Log.Debugf("[%s] [%s] add webrtc SubSession into group.", group.UniqueKey, session.UniqueKey())
+
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+	group.webrtcSubSessionSet[session] = struct{}{}
+	// 还没有sdp时，等 OnSdp 或 onSdpFromRemux 时再设置
+	if group.sdpCtx != nil {
+		session.FeedSdp(*group.sdpCtx)
+	}
+	if group.stat.VideoCodec == "" {
+		session.ShouldWaitVideoKeyFrame = false
+	}
+
+	group.addSub()
+}
+
 // AddHlsSubSession ...
 func (group *Group) AddHlsSubSession(session *hls.SubSession) {
 	Log.Debugf("[%s] [%s] add hls SubSession into group.", group.UniqueKey, session.UniqueKey())
@@ -77,6 +111,10 @@ func (group *Group) HandleNewRtspSubSessionDescribe(session *rtsp.SubSession) (o
 
 	group.mutex.Lock()
 	defer group.mutex.Unlock()
+
+	// 鉴权可能修改了流名称，组播地址按group分配
+	session.SetMulticastKey(group.UniqueKey)
+
 	if group.sdpCtx == nil {
 		Log.Warnf("[%s] [%s] rtsp subSession describe but sdp not exist.", group.UniqueKey, session.UniqueKey())
 
@@ -94,6 +132,7 @@ func (group *Group) HandleNewRtspSubSessionPlay(session *rtsp.SubSession) {
 	defer group.mutex.Unlock()
 	delete(group.waitRtspSubSessionSet, session)
 	group.rtspSubSessionSet[session] = struct{}{}
+	group.addRtspMulticastSubSession(session)
 	if group.stat.VideoCodec == "" {
 		session.ShouldWaitVideoKeyFrame = false
 	}
@@ -119,6 +158,18 @@ func (group *Group) DelHttptsSubSession(session *httpts.SubSession) {
 	group.delHttptsSubSession(session)
 }
 
+func (group *Group) DelSrtSubSession(session *srt.SubSession) {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+	group.delSrtSubSession(session)
+}
+
+func (group *Group) DelWebrtcSubSession(session *webrtc.SubSession) {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+	group.delWebrtcSubSession(session)
+}
+
 func (group *Group) DelRtspSubSession(session *rtsp.SubSession) {
 	group.mutex.Lock()
 	defer group.mutex.Unlock()
@@ -141,6 +192,7 @@ func (group *Group) delRtmpSubSession(session *rtmp.ServerSession) {
 func (group *Group) delHttpflvSubSession(session *httpflv.SubSession) {
 	Log.Debugf("[%s] [%s] del httpflv SubSession from group.", group.UniqueKey, session.UniqueKey())
 	delete(group.httpflvSubSessionSet, session)
+	delete(group.httpflvEnhancedSubSessionSet, session)
 }
 
 func (group *Group) delHttptsSubSession(session *httpts.SubSession) {
@@ -148,9 +200,20 @@ func (group *Group) delHttptsSubSession(session *httpts.SubSession) {
 	delete(group.httptsSubSessionSet, session)
 }
 
+func (group *Group) delSrtSubSession(session *srt.SubSession) {
+	Log.Debugf("[%s] [%s] del srt SubSession from group.", group.UniqueKey, session.UniqueKey())
+	delete(group.srtSubSessionSet, session)
+}
+
+func (group *Group) delWebrtcSubSession(session *webrtc.SubSession) {
+	Log.Debugf("[%s] [%s] del webrtc SubSession from group.", group.UniqueKey, session.UniqueKey())
+	delete(group.webrtcSubSessionSet, session)
+}
+
 func (group *Group) delRtspSubSession(session *rtsp.SubSession) {
 	Log.Debugf("[%s] [%s] del rtsp SubSession from group.", group.UniqueKey, session.UniqueKey())
 	delete(group.rtspSubSessionSet, session)
+	group.delRtspMulticastSubSession(session)
 }
 
 func (group *Group) delHlsSubSession(session *hls.SubSession) {
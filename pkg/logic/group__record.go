@@ -0,0 +1,321 @@
+// Copyright 2023, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/remux"
+	"github.com/q191201771/naza/pkg/nazahttp"
+)
+
+// 各录制格式共用的逻辑：文件名模板、文件切分、文件关闭通知、运行时开启和关闭录制
+
+const (
+	recordFormatFlv    = "flv"
+	recordFormatMpegts = "ts"
+	recordFormatMp4    = "mp4"
+	recordFormatHls    = "hls"
+
+	defaultRecordFilenameTemplate = "{stream}-{unix}"
+
+	recordTimeLayout = "2006-01-02 15:04:05.999"
+)
+
+// recordOption 一个格式的录制的参数，来自配置文件或者http api
+type recordOption struct {
+	outPath        string
+	maxDurationSec int // 为0时表示不限制
+	startTime      time.Time
+}
+
+// recordSegment 一个录制文件的信息
+type recordSegment struct {
+	format    string
+	filename  string
+	startTime time.Time
+
+	waitBoundary bool // 新文件从边界处（视频关键帧，或纯音频时的任意音频帧）开始写入音视频数据
+
+	hasTs   bool
+	startTs uint32 // 文件中第一帧音视频数据的时间戳，单位毫秒
+	endTs   uint32 // 文件中最后一帧音视频数据的时间戳，单位毫秒
+	size    int64
+}
+
+func (seg *recordSegment) updateTs(ts uint32) {
+	if !seg.hasTs {
+		seg.startTs = ts
+		seg.hasTs = true
+	}
+	seg.endTs = ts
+}
+
+func (seg *recordSegment) duration() float64 {
+	if seg.endTs < seg.startTs {
+		return 0
+	}
+	return float64(seg.endTs-seg.startTs) / 1000
+}
+
+// newRecordSegment 根据文件名模板生成录制文件名，必要时创建子目录
+//
+// 文件已存在时（比如同一秒内切分出多个文件），在文件名后追加序号，避免覆盖
+func (group *Group) newRecordSegment(format string, outPath string, ext string, now time.Time) (*recordSegment, error) {
+	filename, err := group.makeRecordFilename(now)
+	if err != nil {
+		return nil, err
+	}
+	name := filepath.Join(outPath, filename)
+	if err := os.MkdirAll(filepath.Dir(name), 0777); err != nil {
+		return nil, err
+	}
+	filename = name + "." + ext
+	for i := 1; ; i++ {
+		if _, err := os.Stat(filename); os.IsNotExist(err) {
+			break
+		}
+		filename = fmt.Sprintf("%s-%d.%s", name, i, ext)
+	}
+	return &recordSegment{
+		format:       format,
+		filename:     filename,
+		startTime:    now,
+		waitBoundary: true,
+	}, nil
+}
+
+// makeRecordFilename
+//
+// 流名称来自客户端，包含路径分隔符或者".."时返回错误，避免录制文件写到录制目录之外
+func (group *Group) makeRecordFilename(now time.Time) (string, error) {
+	for _, name := range []string{group.appName, group.streamName} {
+		if strings.ContainsAny(name, `/\`) || strings.Contains(name, "..") {
+			return "", fmt.Errorf("%w. app=%s, stream=%s", base.ErrRecordFilenameInvalid, group.appName, group.streamName)
+		}
+	}
+
+	tmpl := group.config.RecordConfig.FilenameTemplate
+	if tmpl == "" {
+		tmpl = defaultRecordFilenameTemplate
+	}
+	return strings.NewReplacer(
+		"{app}", group.appName,
+		"{stream}", group.streamName,
+		"{yyyy}", now.Format("2006"),
+		"{mm}", now.Format("01"),
+		"{dd}", now.Format("02"),
+		"{HHMMSS}", now.Format("150405"),
+		"{HH}", now.Format("15"),
+		"{unix}", strconv.FormatInt(now.Unix(), 10),
+	).Replace(tmpl), nil
+}
+
+// shouldRotateRecord 当前文件是否达到了切分条件，调用方负责判断是否处于关键帧位置
+//
+// @param ts: 即将写入的帧的时间戳，单位毫秒
+func (group *Group) shouldRotateRecord(seg *recordSegment, ts uint32) bool {
+	c := group.config.RecordConfig
+	if c.SegmentDurationSec > 0 && seg.hasTs {
+		// 时间戳回退时（比如推流端重连），新的时间戳从新文件开始，否则相减会溢出
+		if ts < seg.startTs || ts-seg.startTs >= uint32(c.SegmentDurationSec)*1000 {
+			return true
+		}
+	}
+	if c.SegmentMaxBytes > 0 && seg.size >= c.SegmentMaxBytes {
+		return true
+	}
+	return false
+}
+
+func (group *Group) recordFileClosedInfo(seg *recordSegment) base.RecordFileClosedInfo {
+	return base.RecordFileClosedInfo{
+		Format:     seg.format,
+		AppName:    group.appName,
+		StreamName: group.streamName,
+		Filename:   seg.filename,
+		StartTime:  seg.startTime.Format(recordTimeLayout),
+		EndTime:    time.Now().Format(recordTimeLayout),
+		Duration:   seg.duration(),
+		FileSize:   seg.size,
+	}
+}
+
+func (group *Group) notifyRecordFileClosed(seg *recordSegment) {
+	info := group.recordFileClosedInfo(seg)
+	Log.Infof("[%s] record file closed. format=%s, filename=%s, duration=%.3f, size=%d",
+		group.UniqueKey, info.Format, info.Filename, info.Duration, info.FileSize)
+	group.observer.OnRecordFileClosed(info)
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// StartRecord 对正在输入的流开启录制，不影响输入流
+func (group *Group) StartRecord(info base.ApiCtrlStartRecordReq) (filename string, err error) {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+
+	if !group.hasInSession() {
+		return "", base.ErrRecordNoInStream
+	}
+	if _, ok := group.recordOptions[info.Format]; ok {
+		return "", base.ErrRecordAlreadyStarted
+	}
+
+	opt := &recordOption{
+		outPath:        info.OutPath,
+		maxDurationSec: info.MaxDurationSec,
+		startTime:      time.Now(),
+	}
+	if opt.outPath == "" {
+		switch info.Format {
+		case recordFormatFlv:
+			opt.outPath = group.config.RecordConfig.FlvOutPath
+		case recordFormatMpegts:
+			opt.outPath = group.config.RecordConfig.MpegtsOutPath
+		case recordFormatMp4:
+			opt.outPath = group.config.RecordConfig.Mp4OutPath
+		}
+	}
+	if opt.outPath == "" {
+		return "", nazahttp.ErrParamMissing
+	}
+
+	Log.Infof("[%s] start record. format=%s, outPath=%s, maxDurationSec=%d", group.UniqueKey, info.Format, opt.outPath, opt.maxDurationSec)
+	switch info.Format {
+	case recordFormatFlv:
+		err = group.startRecordFlv(opt)
+	case recordFormatMpegts:
+		err = group.startRecordMpegts(opt)
+	case recordFormatMp4:
+		err = group.startRecordMp4(opt)
+	case recordFormatHls:
+		err = group.startRecordHls(opt)
+	default:
+		err = base.ErrRecordFormatInvalid
+	}
+	if err != nil {
+		return "", err
+	}
+	return group.recordFilename(info.Format), nil
+}
+
+// StopRecord 停止录制，包括通过配置文件开启的录制
+func (group *Group) StopRecord(format string) error {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+
+	if _, ok := group.recordOptions[format]; !ok {
+		return base.ErrRecordNotStarted
+	}
+	Log.Infof("[%s] stop record. format=%s", group.UniqueKey, format)
+	group.stopRecord(format)
+	return nil
+}
+
+func (group *Group) stopRecord(format string) {
+	switch format {
+	case recordFormatFlv:
+		group.stopRecordFlvIfNeeded()
+	case recordFormatMpegts:
+		group.stopRecordMpegtsIfNeeded()
+	case recordFormatMp4:
+		group.stopRecordMp4IfNeeded()
+	case recordFormatHls:
+		group.stopRecordHlsIfNeeded()
+	}
+}
+
+// tickRecord 达到最大录制时长时停止录制
+func (group *Group) tickRecord() {
+	for format, opt := range group.recordOptions {
+		if opt.maxDurationSec > 0 && time.Since(opt.startTime) >= time.Duration(opt.maxDurationSec)*time.Second {
+			Log.Infof("[%s] record reach max duration, stop. format=%s", group.UniqueKey, format)
+			group.stopRecord(format)
+		}
+	}
+}
+
+func (group *Group) getStatRecords() []base.StatRecord {
+	var ret []base.StatRecord
+	for format, opt := range group.recordOptions {
+		ret = append(ret, base.StatRecord{
+			Format:         format,
+			OutPath:        opt.outPath,
+			Filename:       group.recordFilename(format),
+			StartTime:      opt.startTime.Format(recordTimeLayout),
+			MaxDurationSec: opt.maxDurationSec,
+		})
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Format < ret[j].Format
+	})
+	return ret
+}
+
+func (group *Group) recordFilename(format string) string {
+	var seg *recordSegment
+	switch format {
+	case recordFormatFlv:
+		seg = group.recordFlvSegment
+	case recordFormatMpegts:
+		seg = group.recordMpegtsSegment
+	case recordFormatMp4:
+		seg = group.recordMp4Segment
+	case recordFormatHls:
+		seg = group.recordHlsSegment
+	}
+	if seg == nil {
+		return ""
+	}
+	return seg.filename
+}
+
+// cacheRecordSeqHeader 缓存metadata和seq header，中途开启录制或切分出新文件时，需要先写入这些数据
+func (group *Group) cacheRecordSeqHeader(msg base.RtmpMsg) {
+	switch {
+	case msg.Header.MsgTypeId == base.RtmpTypeIdMetadata:
+		m := msg.Clone()
+		group.recordMetadata = &m
+	case len(msg.Payload) > 1 && msg.IsVideoKeySeqHeader():
+		m := msg.Clone()
+		group.recordVideoSeqHeader = &m
+	case len(msg.Payload) > 1 && msg.IsAudioSeqHeader():
+		m := msg.Clone()
+		group.recordAudioSeqHeader = &m
+	}
+}
+
+func (group *Group) cachedRecordSeqHeaders() []base.RtmpMsg {
+	var ret []base.RtmpMsg
+	for _, m := range []*base.RtmpMsg{group.recordMetadata, group.recordVideoSeqHeader, group.recordAudioSeqHeader} {
+		if m != nil {
+			ret = append(ret, *m)
+		}
+	}
+	return ret
+}
+
+// ensureMpegtsRemuxer 中途开启ts或hls录制时，mpegts remuxer可能还没有创建
+func (group *Group) ensureMpegtsRemuxer() {
+	if group.rtmp2MpegtsRemuxer != nil {
+		return
+	}
+	group.rtmp2MpegtsRemuxer = remux.NewRtmp2MpegtsRemuxer(group)
+	for _, m := range group.cachedRecordSeqHeaders() {
+		group.rtmp2MpegtsRemuxer.FeedRtmpMessage(m)
+	}
+}
@@ -9,10 +9,11 @@
 package logic
 
 import (
-	"fmt"
-	"path/filepath"
+	"time"
 
+	"github.com/q191201771/lal/pkg/base"
 	"github.com/q191201771/lal/pkg/httpflv"
+	"github.com/q191201771/lal/pkg/remux"
 )
 
 // startRecordFlvIfNeeded 必要时开启flv录制
@@ -21,32 +22,106 @@ func (group *Group) startRecordFlvIfNeeded(nowUnix int64) {
 		return
 	}
 
-	// 构造文件名
-	filename := fmt.Sprintf("%s-%d.flv", group.streamName, nowUnix)
-	filenameWithPath := filepath.Join(group.config.RecordConfig.FlvOutPath, filename)
+	_ = group.startRecordFlv(&recordOption{
+		outPath:   group.config.RecordConfig.FlvOutPath,
+		startTime: time.Unix(nowUnix, 0),
+	})
+}
+
+func (group *Group) startRecordFlv(opt *recordOption) error {
+	if err := group.openRecordFlv(opt.outPath, opt.startTime); err != nil {
+		return err
+	}
+	group.recordOptions[recordFormatFlv] = opt
+	return nil
+}
+
+func (group *Group) stopRecordFlvIfNeeded() {
+	group.closeRecordFlv()
+	delete(group.recordOptions, recordFormatFlv)
+}
+
+// writeRecordFlv 写入flv录制文件，必要时在视频关键帧处切分出新文件
+//
+// @param tag: 不包含rtmp message的flv tag
+func (group *Group) writeRecordFlv(msg base.RtmpMsg, tag []byte) {
+	if msg.Header.MsgTypeId == base.RtmpTypeIdVideo || msg.Header.MsgTypeId == base.RtmpTypeIdAudio {
+		if len(msg.Payload) > 1 && !msg.IsVideoKeySeqHeader() && !msg.IsAudioSeqHeader() {
+			// 有视频时在视频关键帧处切分，纯音频时在任意音频帧处切分
+			var boundary bool
+			if group.recordVideoSeqHeader != nil {
+				boundary = msg.IsVideoKeyNalu()
+			} else {
+				boundary = msg.Header.MsgTypeId == base.RtmpTypeIdAudio
+			}
+			if boundary && group.shouldRotateRecord(group.recordFlvSegment, msg.Dts()) {
+				group.closeRecordFlv()
+				if err := group.openRecordFlv(group.recordOptions[recordFormatFlv].outPath, time.Now()); err != nil {
+					delete(group.recordOptions, recordFormatFlv)
+					return
+				}
+			}
+
+			seg := group.recordFlvSegment
+			if seg.waitBoundary {
+				if !boundary {
+					return
+				}
+				seg.waitBoundary = false
+			}
+			seg.updateTs(msg.Dts())
+		}
+	}
+
+	group.doWriteRecordFlv(tag)
+}
+
+// openRecordFlv 打开新的录制文件，并写入flv头，以及已缓存的metadata和seq header
+func (group *Group) openRecordFlv(outPath string, now time.Time) error {
+	seg, err := group.newRecordSegment(recordFormatFlv, outPath, "flv", now)
+	if err != nil {
+		Log.Errorf("[%s] record flv create dir failed. err=%+v", group.UniqueKey, err)
+		return err
+	}
 
 	// 初始化录制
 	group.recordFlv = &httpflv.FlvFileWriter{}
-	if err := group.recordFlv.Open(filenameWithPath); err != nil {
+	if err = group.recordFlv.Open(seg.filename); err != nil {
 		Log.Errorf("[%s] record flv open file failed. filename=%s, err=%+v",
-			group.UniqueKey, filenameWithPath, err)
+			group.UniqueKey, seg.filename, err)
 		group.recordFlv = nil
-		return
+		return err
 	}
-	if err := group.recordFlv.WriteFlvHeader(); err != nil {
+	if err = group.recordFlv.WriteFlvHeader(); err != nil {
 		Log.Errorf("[%s] record flv write flv header failed. filename=%s, err=%+v",
-			group.UniqueKey, filenameWithPath, err)
+			group.UniqueKey, seg.filename, err)
+		_ = group.recordFlv.Dispose()
 		group.recordFlv = nil
+		return err
+	}
+	seg.size = int64(len(httpflv.FlvHeader))
+	group.recordFlvSegment = seg
+
+	for _, m := range group.cachedRecordSeqHeaders() {
+		group.doWriteRecordFlv(remux.RtmpMsg2FlvTag(m).Raw)
 	}
+	return nil
 }
 
-func (group *Group) stopRecordFlvIfNeeded() {
-	if !group.config.RecordConfig.EnableFlv {
+func (group *Group) closeRecordFlv() {
+	if group.recordFlv == nil {
 		return
 	}
+	_ = group.recordFlv.Dispose()
+	group.recordFlv = nil
+	group.notifyRecordFileClosed(group.recordFlvSegment)
+	group.recordFlvSegment = nil
+}
 
-	if group.recordFlv != nil {
-		_ = group.recordFlv.Dispose()
-		group.recordFlv = nil
+func (group *Group) doWriteRecordFlv(b []byte) {
+	if err := group.recordFlv.WriteRaw(b); err != nil {
+		Log.Errorf("[%s] record flv write error. err=%+v", group.UniqueKey, err)
+		return
 	}
+	group.recordFlvSegment.size += int64(len(b))
 }
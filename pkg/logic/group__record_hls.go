@@ -8,7 +8,11 @@
 
 package logic
 
-import "github.com/q191201771/lal/pkg/hls"
+import (
+	"time"
+
+	"github.com/q191201771/lal/pkg/hls"
+)
 
 func (group *Group) IsHlsMuxerAlive() bool {
 	group.mutex.Lock()
@@ -22,10 +26,27 @@ func (group *Group) startHlsIfNeeded() {
 		return
 	}
 
-	group.hlsMuxer = hls.NewMuxer(group.streamName, &group.config.HlsConfig.MuxerConfig, group)
+	if group.isHlsFmp4() {
+		group.hlsMuxer = hls.NewFmp4Muxer(group.streamName, &group.config.HlsConfig.MuxerConfig, group)
+	} else {
+		group.hlsMuxer = hls.NewMuxer(group.streamName, &group.config.HlsConfig.MuxerConfig, group)
+	}
 	group.hlsMuxer.Start()
 }
 
+// isHlsFmp4 当前流的hls是否使用fmp4格式的分片
+func (group *Group) isHlsFmp4() bool {
+	if group.config.HlsConfig.Fmp4Enable {
+		return true
+	}
+	for _, name := range group.config.HlsConfig.Fmp4StreamNameList {
+		if name == group.streamName {
+			return true
+		}
+	}
+	return false
+}
+
 func (group *Group) stopHlsIfNeeded() {
 	if !group.config.HlsConfig.Enable && !group.config.HlsConfig.EnableHttps {
 		return
@@ -37,3 +58,47 @@ func (group *Group) stopHlsIfNeeded() {
 		group.hlsMuxer = nil
 	}
 }
+
+// startRecordHls 通过http api开启的hls录制
+//
+// 与直播的hls相互独立，使用单独的输出目录，并且不删除分片文件，录制结果见record类型的m3u8文件
+func (group *Group) startRecordHls(opt *recordOption) error {
+	config := group.config.HlsConfig.MuxerConfig
+	config.OutPath = opt.outPath
+	config.CleanupMode = hls.CleanupModeNever
+	config.LowLatencyEnable = false
+	config.DashEnable = false
+	config.DvrWindowSec = 0
+	if config.FragmentDurationMs <= 0 {
+		config.FragmentDurationMs = 3000
+	}
+	if config.FragmentNum <= 0 {
+		config.FragmentNum = 6
+	}
+
+	group.ensureMpegtsRemuxer()
+	group.recordHls = hls.NewMuxer(group.streamName, &config, group)
+	group.recordHls.Start()
+	if group.patpmt != nil {
+		group.recordHls.FeedPatPmt(group.patpmt)
+	}
+
+	group.recordHlsSegment = &recordSegment{
+		format:    recordFormatHls,
+		filename:  hls.PathStrategy.GetRecordM3u8FileName(group.recordHls.OutPath(), group.streamName),
+		startTime: time.Now(),
+	}
+	group.recordOptions[recordFormatHls] = opt
+	return nil
+}
+
+func (group *Group) stopRecordHlsIfNeeded() {
+	delete(group.recordOptions, recordFormatHls)
+
+	if group.recordHls != nil {
+		group.recordHls.Dispose()
+		group.recordHls = nil
+		group.notifyRecordFileClosed(group.recordHlsSegment)
+		group.recordHlsSegment = nil
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright 2023, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"os"
+	"time"
+
+	"github.com/q191201771/lal/pkg/fmp4"
+	"github.com/q191201771/lal/pkg/remux"
+)
+
+// startRecordMp4IfNeeded 必要时开启mp4录制
+//
+// 录制过程中写入的是fmp4，进程异常退出时文件依然可以播放；
+// 输入流结束时，转换为moov在前的mp4文件，并通过 IGroupObserver.OnRecordFileClosed 通知上层
+func (group *Group) startRecordMp4IfNeeded(nowUnix int64) {
+	if !group.config.RecordConfig.EnableMp4 {
+		return
+	}
+
+	_ = group.startRecordMp4(&recordOption{
+		outPath:   group.config.RecordConfig.Mp4OutPath,
+		startTime: time.Unix(nowUnix, 0),
+	})
+}
+
+func (group *Group) startRecordMp4(opt *recordOption) error {
+	seg, err := group.newRecordSegment(recordFormatMp4, opt.outPath, "mp4", opt.startTime)
+	if err != nil {
+		Log.Errorf("[%s] record mp4 create dir failed. err=%+v", group.UniqueKey, err)
+		return err
+	}
+
+	group.recordMp4 = &fmp4.FileWriter{}
+	if err = group.recordMp4.Create(seg.filename); err != nil {
+		Log.Errorf("[%s] record mp4 open file failed. filename=%s, err=%+v",
+			group.UniqueKey, seg.filename, err)
+		group.recordMp4 = nil
+		return err
+	}
+	group.recordMp4Segment = seg
+	group.recordOptions[recordFormatMp4] = opt
+
+	// 中途开启录制时，remuxer需要先获取seq header
+	group.rtmp2Fmp4Remuxer = remux.NewRtmp2Fmp4Remuxer(group)
+	for _, m := range group.cachedRecordSeqHeaders() {
+		group.rtmp2Fmp4Remuxer.FeedRtmpMessage(m)
+	}
+	return nil
+}
+
+func (group *Group) stopRecordMp4IfNeeded() {
+	delete(group.recordOptions, recordFormatMp4)
+
+	// 注意，remuxer放前面，使得有机会将内部缓存的数据吐出来
+	if group.rtmp2Fmp4Remuxer != nil {
+		group.rtmp2Fmp4Remuxer.Dispose()
+		group.rtmp2Fmp4Remuxer = nil
+	}
+
+	if group.recordMp4 != nil {
+		// 文件较大时转换比较耗时，所以放在单独的协程中，避免阻塞group
+		fw := group.recordMp4
+		info := group.recordFileClosedInfo(group.recordMp4Segment)
+		go func() {
+			if err := fw.Finalize(); err != nil {
+				Log.Errorf("[%s] record mp4 finalize failed. filename=%s, err=%+v", group.UniqueKey, fw.Name(), err)
+			}
+			info.Duration = fw.Duration()
+			if fi, err := os.Stat(fw.Name()); err == nil {
+				info.FileSize = fi.Size()
+			}
+			Log.Infof("[%s] record file closed. format=%s, filename=%s, duration=%.3f, size=%d",
+				group.UniqueKey, info.Format, info.Filename, info.Duration, info.FileSize)
+			group.observer.OnRecordFileClosed(info)
+		}()
+		group.recordMp4 = nil
+		group.recordMp4Segment = nil
+	}
+}
+
+// ----- implement IRtmp2Fmp4RemuxerObserver of remux.Rtmp2Fmp4Remuxer ------------------------------------------------
+
+func (group *Group) OnFmp4InitSegment(tracks []*fmp4.Track) {
+	if group.recordMp4 == nil {
+		return
+	}
+	if err := group.recordMp4.WriteInitSegment(tracks); err != nil {
+		// 一个mp4文件只能有一个init segment，track发生变化后的数据不再写入
+		Log.Warnf("[%s] record mp4 write init segment failed. err=%+v", group.UniqueKey, err)
+	}
+}
+
+func (group *Group) OnFmp4MediaSegment(fragments []fmp4.TrackFragment) {
+	if group.recordMp4 == nil {
+		return
+	}
+	if err := group.recordMp4.WriteMediaSegment(fragments); err != nil {
+		Log.Errorf("[%s] record mp4 write media segment failed. err=%+v", group.UniqueKey, err)
+	}
+}
@@ -9,8 +9,7 @@
 package logic
 
 import (
-	"fmt"
-	"path/filepath"
+	"time"
 
 	"github.com/q191201771/lal/pkg/mpegts"
 )
@@ -21,25 +20,85 @@ func (group *Group) startRecordMpegtsIfNeeded(nowUnix int64) {
 		return
 	}
 
-	// 构造文件名
-	filename := fmt.Sprintf("%s-%d.ts", group.streamName, nowUnix)
-	filenameWithPath := filepath.Join(group.config.RecordConfig.MpegtsOutPath, filename)
+	_ = group.startRecordMpegts(&recordOption{
+		outPath:   group.config.RecordConfig.MpegtsOutPath,
+		startTime: time.Unix(nowUnix, 0),
+	})
+}
+
+func (group *Group) startRecordMpegts(opt *recordOption) error {
+	if err := group.openRecordMpegts(opt.outPath, opt.startTime); err != nil {
+		return err
+	}
+	group.ensureMpegtsRemuxer()
+	group.recordOptions[recordFormatMpegts] = opt
+	return nil
+}
+
+func (group *Group) stopRecordMpegtsIfNeeded() {
+	group.closeRecordMpegts()
+	delete(group.recordOptions, recordFormatMpegts)
+}
+
+// writeRecordMpegts 写入ts录制文件，必要时在边界处（视频关键帧，或纯音频时的任意音频帧）切分出新文件
+func (group *Group) writeRecordMpegts(tsPackets []byte, frame *mpegts.Frame, boundary bool) {
+	ts := uint32(frame.Dts / 90)
+	if boundary && group.shouldRotateRecord(group.recordMpegtsSegment, ts) {
+		group.closeRecordMpegts()
+		if err := group.openRecordMpegts(group.recordOptions[recordFormatMpegts].outPath, time.Now()); err != nil {
+			delete(group.recordOptions, recordFormatMpegts)
+			return
+		}
+	}
+
+	seg := group.recordMpegtsSegment
+	if seg.waitBoundary {
+		if !boundary {
+			return
+		}
+		seg.waitBoundary = false
+	}
+	seg.updateTs(ts)
+	group.doWriteRecordMpegts(tsPackets)
+}
+
+// openRecordMpegts 打开新的录制文件，并写入已缓存的pat和pmt
+func (group *Group) openRecordMpegts(outPath string, now time.Time) error {
+	seg, err := group.newRecordSegment(recordFormatMpegts, outPath, "ts", now)
+	if err != nil {
+		Log.Errorf("[%s] record mpegts create dir failed. err=%+v", group.UniqueKey, err)
+		return err
+	}
 
 	group.recordMpegts = &mpegts.FileWriter{}
-	if err := group.recordMpegts.Create(filenameWithPath); err != nil {
+	if err = group.recordMpegts.Create(seg.filename); err != nil {
 		Log.Errorf("[%s] record mpegts open file failed. filename=%s, err=%+v",
-			group.UniqueKey, filenameWithPath, err)
+			group.UniqueKey, seg.filename, err)
 		group.recordMpegts = nil
+		return err
+	}
+	group.recordMpegtsSegment = seg
+
+	if group.patpmt != nil {
+		group.doWriteRecordMpegts(group.patpmt)
 	}
+	return nil
 }
 
-func (group *Group) stopRecordMpegtsIfNeeded() {
-	if !group.config.RecordConfig.EnableMpegts {
+func (group *Group) closeRecordMpegts() {
+	if group.recordMpegts == nil {
 		return
 	}
+	_ = group.recordMpegts.Dispose()
+	group.recordMpegts = nil
+	group.notifyRecordFileClosed(group.recordMpegtsSegment)
+	group.recordMpegtsSegment = nil
+}
 
-	if group.recordMpegts != nil {
-		_ = group.recordMpegts.Dispose()
-		group.recordMpegts = nil
+func (group *Group) doWriteRecordMpegts(b []byte) {
+	if err := group.recordMpegts.Write(b); err != nil {
+		Log.Errorf("[%s] record mpegts write error. err=%+v", group.UniqueKey, err)
+		return
 	}
+	group.recordMpegtsSegment.size += int64(len(b))
 }
@@ -0,0 +1,193 @@
+// Copyright 2023, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/httpflv"
+	"github.com/q191201771/lal/pkg/remux"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+type mockGroupObserver struct {
+	recordFileClosedInfos []base.RecordFileClosedInfo
+}
+
+func (m *mockGroupObserver) CleanupHlsIfNeeded(appName string, streamName string, path string) {}
+func (m *mockGroupObserver) OnHlsMakeTs(info base.HlsMakeTsInfo)                               {}
+func (m *mockGroupObserver) OnRelayPullStart(info base.PullStartInfo)                          {}
+func (m *mockGroupObserver) OnRelayPullStop(info base.PullStopInfo)                            {}
+func (m *mockGroupObserver) BeforeRelayPush(info *base.RepayPushInfo)                          {}
+func (m *mockGroupObserver) OnRecordFileClosed(info base.RecordFileClosedInfo) {
+	m.recordFileClosedInfos = append(m.recordFileClosedInfos, info)
+}
+
+func TestMakeRecordFilename(t *testing.T) {
+	var config Config
+	group := NewGroup("live", "test110", &config, nil)
+	now := time.Date(2023, 5, 6, 7, 8, 9, 0, time.Local)
+
+	makeFilename := func(group *Group) string {
+		filename, err := group.makeRecordFilename(now)
+		assert.Equal(t, nil, err)
+		return filename
+	}
+	assert.Equal(t, "test110-"+strconv.FormatInt(now.Unix(), 10), makeFilename(group))
+	config.RecordConfig.FilenameTemplate = "{app}/{stream}/{yyyy}/{mm}/{dd}/{HHMMSS}"
+	assert.Equal(t, "live/test110/2023/05/06/070809", makeFilename(group))
+	config.RecordConfig.FilenameTemplate = "{stream}_{HH}"
+	assert.Equal(t, "test110_07", makeFilename(group))
+
+	// 流名称不能跳出录制目录
+	for _, streamName := range []string{"../test110", "a/b", `a\b`, ".."} {
+		_, err := NewGroup("live", streamName, &config, nil).makeRecordFilename(now)
+		assert.Equal(t, true, errors.Is(err, base.ErrRecordFilenameInvalid))
+	}
+}
+
+func TestShouldRotateRecord(t *testing.T) {
+	var config Config
+	config.RecordConfig.SegmentDurationSec = 2
+	group := NewGroup("live", "test110", &config, nil)
+
+	seg := &recordSegment{}
+	assert.Equal(t, false, group.shouldRotateRecord(seg, 0))
+	seg.updateTs(5000)
+	assert.Equal(t, false, group.shouldRotateRecord(seg, 6999))
+	assert.Equal(t, true, group.shouldRotateRecord(seg, 7000))
+	// 时间戳回退
+	assert.Equal(t, true, group.shouldRotateRecord(seg, 100))
+}
+
+func TestRecordFlvRotate(t *testing.T) {
+	var config Config
+	config.RecordConfig.EnableFlv = true
+	config.RecordConfig.FlvOutPath = t.TempDir()
+	config.RecordConfig.SegmentDurationSec = 2
+	config.RecordConfig.FilenameTemplate = "{app}/{stream}/{unix}"
+	observer := &mockGroupObserver{}
+	group := NewGroup("live", "test110", &config, observer)
+
+	write := func(typeId uint8, ts uint32, payload []byte) {
+		msg := base.RtmpMsg{
+			Header:  base.RtmpHeader{MsgTypeId: typeId, MsgLen: uint32(len(payload)), TimestampAbs: ts},
+			Payload: payload,
+		}
+		group.cacheRecordSeqHeader(msg)
+		group.writeRecordFlv(msg, remux.RtmpMsg2FlvTag(msg).Raw)
+	}
+
+	group.startRecordFlvIfNeeded(1000)
+	write(base.RtmpTypeIdVideo, 0, []byte{0x17, 0x00, 0x00, 0x00, 0x00, 0x01})
+	write(base.RtmpTypeIdAudio, 0, []byte{0xAF, 0x00, 0x12, 0x10})
+	// 每秒一个关键帧，每两秒切分一个文件，不在非关键帧处切分
+	for i := 0; i <= 50; i++ {
+		head := byte(0x27)
+		if i%10 == 0 {
+			head = 0x17
+		}
+		write(base.RtmpTypeIdVideo, uint32(i*100), []byte{head, 0x01, 0x00, 0x00, 0x00, 0x01})
+		write(base.RtmpTypeIdAudio, uint32(i*100), []byte{0xAF, 0x01, 0x21})
+	}
+	assert.Equal(t, 2, len(observer.recordFileClosedInfos))
+	group.stopRecordFlvIfNeeded()
+	assert.Equal(t, 3, len(observer.recordFileClosedInfos))
+
+	first := observer.recordFileClosedInfos[0]
+	assert.Equal(t, "flv", first.Format)
+	assert.Equal(t, "live", first.AppName)
+	assert.Equal(t, filepath.Join(config.RecordConfig.FlvOutPath, "live", "test110", "1000.flv"), first.Filename)
+	assert.Equal(t, 1.9, first.Duration)
+	assert.Equal(t, 1.9, observer.recordFileClosedInfos[1].Duration)
+	assert.Equal(t, 1.0, observer.recordFileClosedInfos[2].Duration)
+
+	// 切分出的新文件以flv头、seq header开始，第一个音视频帧为关键帧
+	for _, info := range observer.recordFileClosedInfos {
+		b, err := os.ReadFile(info.Filename)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, info.FileSize, int64(len(b)))
+		assert.Equal(t, httpflv.FlvHeader, b[:len(httpflv.FlvHeader)])
+		tags := b[len(httpflv.FlvHeader):]
+		assert.Equal(t, []byte{0x17, 0x00}, tags[httpflv.TagHeaderSize:httpflv.TagHeaderSize+2])
+	}
+}
+
+func TestStartRecordAtRuntime(t *testing.T) {
+	var config Config
+	config.RecordConfig.FlvOutPath = t.TempDir()
+	observer := &mockGroupObserver{}
+	group := NewGroup("live", "test110", &config, observer)
+
+	_, err := group.StartRecord(base.ApiCtrlStartRecordReq{StreamName: "test110", Format: "flv"})
+	assert.Equal(t, base.ErrRecordNoInStream, err)
+	assert.Equal(t, base.ErrRecordNotStarted, group.StopRecord("flv"))
+
+	newMsg := func(typeId uint8, ts uint32, payload []byte) base.RtmpMsg {
+		return base.RtmpMsg{
+			Header:  base.RtmpHeader{MsgTypeId: typeId, MsgLen: uint32(len(payload)), TimestampAbs: ts},
+			Payload: payload,
+		}
+	}
+	write := func(msg base.RtmpMsg) {
+		group.cacheRecordSeqHeader(msg)
+		if group.recordFlv != nil {
+			group.writeRecordFlv(msg, remux.RtmpMsg2FlvTag(msg).Raw)
+		}
+	}
+
+	// 流已经开始后，再开启录制
+	write(newMsg(base.RtmpTypeIdVideo, 0, []byte{0x17, 0x00, 0x00, 0x00, 0x00, 0x01}))
+	write(newMsg(base.RtmpTypeIdAudio, 0, []byte{0xAF, 0x00, 0x12, 0x10}))
+	write(newMsg(base.RtmpTypeIdVideo, 0, []byte{0x17, 0x01, 0x00, 0x00, 0x00, 0x01}))
+	assert.Equal(t, nil, group.startRecordFlv(&recordOption{
+		outPath:        config.RecordConfig.FlvOutPath,
+		maxDurationSec: 10,
+		startTime:      time.Now(),
+	}))
+	records := group.getStatRecords()
+	assert.Equal(t, 1, len(records))
+	assert.Equal(t, "flv", records[0].Format)
+	assert.Equal(t, 10, records[0].MaxDurationSec)
+
+	// 等待关键帧
+	write(newMsg(base.RtmpTypeIdVideo, 40, []byte{0x27, 0x01, 0x00, 0x00, 0x00, 0x02}))
+	write(newMsg(base.RtmpTypeIdAudio, 40, []byte{0xAF, 0x01, 0x21}))
+	write(newMsg(base.RtmpTypeIdVideo, 80, []byte{0x17, 0x01, 0x00, 0x00, 0x00, 0x03}))
+	write(newMsg(base.RtmpTypeIdAudio, 80, []byte{0xAF, 0x01, 0x22}))
+
+	// 达到最大录制时长后自动停止
+	group.tickRecord()
+	assert.Equal(t, 1, len(group.recordOptions))
+	group.recordOptions["flv"].startTime = time.Now().Add(-10 * time.Second)
+	group.tickRecord()
+	assert.Equal(t, 0, len(group.recordOptions))
+	assert.Equal(t, 0, len(group.getStatRecords()))
+	assert.Equal(t, 1, len(observer.recordFileClosedInfos))
+
+	b, err := os.ReadFile(observer.recordFileClosedInfos[0].Filename)
+	assert.Equal(t, nil, err)
+	var payloads [][]byte
+	for b = b[len(httpflv.FlvHeader):]; len(b) > 0; {
+		size := int(b[1])<<16 | int(b[2])<<8 | int(b[3])
+		payloads = append(payloads, b[httpflv.TagHeaderSize:httpflv.TagHeaderSize+size])
+		b = b[httpflv.TagHeaderSize+size+httpflv.PrevTagSizeFieldSize:]
+	}
+	assert.Equal(t, 4, len(payloads))
+	assert.Equal(t, []byte{0x17, 0x00, 0x00, 0x00, 0x00, 0x01}, payloads[0])
+	assert.Equal(t, []byte{0xAF, 0x00, 0x12, 0x10}, payloads[1])
+	assert.Equal(t, []byte{0x17, 0x01, 0x00, 0x00, 0x00, 0x03}, payloads[2])
+	assert.Equal(t, []byte{0xAF, 0x01, 0x22}, payloads[3])
+}
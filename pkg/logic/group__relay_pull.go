@@ -218,6 +218,9 @@ func (group *Group) pullIfNeeded() (string, error) {
 
 	group.pullProxy.isSessionPulling = true
 	group.pullProxy.startCount++
+	if group.pullProxy.startCount > 1 {
+		group.counter.pullRetry.Increment()
+	}
 
 	isPullByRtmp := strings.HasPrefix(group.pullProxy.pullUrl, "rtmp")
 
@@ -241,6 +244,7 @@ func (group *Group) pullIfNeeded() (string, error) {
 		rtspSession = rtsp.NewPullSession(group, func(option *rtsp.PullSessionOption) {
 			option.PullTimeoutMs = group.pullProxy.pullTimeoutMs
 			option.OverTcp = group.pullProxy.rtspMode == 0
+			option.LossConfig = group.config.RtspConfig.InLossConfig
 		}).WithOnDescribeResponse(func() {
 			err := group.AddRtspPullSession(rtspSession)
 			if err != nil {
@@ -69,9 +69,10 @@ func (group *Group) StopRtmpPush(key string) {
 // ---------------------------------------------------------------------------------------------------------------------
 
 type pushProxy struct {
-	isPushing   bool
-	pushSession *rtmp.PushSession
-	pushUrl     string
+	isPushing       bool
+	pushSession     *rtmp.PushSession
+	pushUrl         string
+	aggregateMerger *rtmp.AggregateMessageMerger // 开启aggregate时有效
 }
 
 func (group *Group) initRelayPushByConfig() {
@@ -99,6 +100,39 @@ func (group *Group) initRelayPushByConfig() {
 	group.url2PushProxy = url2PushProxy
 }
 
+// ReloadRelayPushConfig 配置热加载时调用
+//
+// 只增删配置文件中的转推地址，通过api添加的转推不受影响，新增的地址由 Tick 触发转推。
+// 聚合相关的配置对之后开始的转推生效
+func (group *Group) ReloadRelayPushConfig(oldConfig, newConfig RelayPushConfig) {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+
+	// group.config可能和其他group共用，所以拷贝后再修改
+	config := *group.config
+	config.RelayPushConfig = newConfig
+	group.config = &config
+
+	newAddrs := make(map[string]struct{})
+	if newConfig.Enable {
+		for _, addr := range newConfig.AddrList {
+			newAddrs[addr] = struct{}{}
+		}
+	}
+	if oldConfig.Enable {
+		for _, addr := range oldConfig.AddrList {
+			if _, ok := newAddrs[addr]; !ok {
+				Log.Infof("[%s] stop relay push by reload config. addr=%s", group.UniqueKey, addr)
+				group.StopRtmpPush(addr)
+			}
+		}
+	}
+	for addr := range newAddrs {
+		group.AddRtmpPush(addr, addr)
+	}
+	group.pushEnable = newConfig.Enable
+}
+
 // startPushIfNeeded 必要时进行replay push转推
 func (group *Group) startPushIfNeeded() {
 	// push转推功能没开
@@ -135,6 +169,12 @@ func (group *Group) startPushIfNeeded() {
 		Log.Debugf("增加转推: %v", *v)
 		v.isPushing = true
 
+		if _, ok := group.pushStartedKeys[info.Key]; ok {
+			group.counter.pushRetry.Increment()
+		} else {
+			group.pushStartedKeys[info.Key] = struct{}{}
+		}
+
 		Log.Infof("[%s] start relay push. url=%s", group.UniqueKey, url)
 
 		pushSession := rtmp.NewPushSession(func(option *rtmp.PushSessionOption) {
@@ -149,6 +189,11 @@ func (group *Group) startPushIfNeeded() {
 			return true
 		}
 		v.pushSession = pushSession
+		if group.config.RelayPushConfig.AggregateEnable {
+			v.aggregateMerger = rtmp.NewAggregateMessageMerger(group.config.RelayPushConfig.AggregateMaxDurationMs, relayPushAggregateMaxSize, func(msg base.RtmpMsg) {
+				_ = pushSession.Write(rtmp.Message2Chunks(msg.Payload, &msg.Header))
+			})
+		}
 		go func(u string, v *pushProxy) {
 			err = <-v.pushSession.WaitChan()
 			Log.Errorf("[%s] relay push done. err=%v", pushSession.UniqueKey(), err)
@@ -198,6 +243,7 @@ func (group *Group) stopPushIfNeeded() {
 			v.pushSession.Dispose()
 		}
 		v.pushSession = nil
+		v.aggregateMerger = nil
 		return true
 	})
 	//for _, v := range group.url2PushProxy {
@@ -0,0 +1,84 @@
+// Copyright 2023, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"github.com/q191201771/lal/pkg/rtprtcp"
+	"github.com/q191201771/lal/pkg/rtsp"
+)
+
+// group__rtsp_multicast.go
+//
+// rtsp sub session的组播
+//
+// 组播sub session同时也在 rtspSubSessionSet 中，生命周期和统计与普通的rtsp sub session相同，只是自身不发送数据。
+// 同一个group的所有组播sub session共用一个 rtsp.MulticastSender ，在 feedRtpPacket 中按需创建，
+// 输入流离开或者最后一个组播sub session离开时销毁
+
+func (group *Group) addRtspMulticastSubSession(session *rtsp.SubSession) {
+	if _, ok := session.MulticastDest(); !ok {
+		return
+	}
+	group.rtspMulticastSubSessionSet[session] = struct{}{}
+}
+
+func (group *Group) delRtspMulticastSubSession(session *rtsp.SubSession) {
+	if _, ok := group.rtspMulticastSubSessionSet[session]; !ok {
+		return
+	}
+	delete(group.rtspMulticastSubSessionSet, session)
+	if len(group.rtspMulticastSubSessionSet) == 0 {
+		group.disposeRtspMulticastSender()
+	}
+}
+
+func (group *Group) feedRtspMulticast(pkt rtprtcp.RtpPacket, checkBoundary func() bool) {
+	if len(group.rtspMulticastSubSessionSet) == 0 || group.sdpCtx == nil {
+		return
+	}
+
+	if group.rtspMulticastSender == nil {
+		var dest rtsp.MulticastDest
+		for s := range group.rtspMulticastSubSessionSet {
+			dest, _ = s.MulticastDest()
+			break
+		}
+		sender, err := rtsp.NewMulticastSender(dest, *group.sdpCtx)
+		if err != nil {
+			// 关闭所有组播sub session，避免后续每个包都重试
+			Log.Errorf("[%s] create rtsp multicast sender failed. dest=%s, err=%+v", group.UniqueKey, dest, err)
+			for s := range group.rtspMulticastSubSessionSet {
+				s.Dispose()
+			}
+			group.rtspMulticastSubSessionSet = make(map[*rtsp.SubSession]struct{})
+			return
+		}
+		sender.ShouldWaitVideoKeyFrame = group.stat.VideoCodec != ""
+		group.rtspMulticastSender = sender
+	}
+
+	// 和普通的rtsp sub session相同，受配置项 OutWaitKeyFrameFlag 控制
+	sender := group.rtspMulticastSender
+	if !group.config.RtspConfig.OutWaitKeyFrameFlag || !sender.ShouldWaitVideoKeyFrame {
+		_ = sender.WriteRtpPacket(pkt)
+		return
+	}
+	if checkBoundary() {
+		_ = sender.WriteRtpPacket(pkt)
+		sender.ShouldWaitVideoKeyFrame = false
+	}
+}
+
+func (group *Group) disposeRtspMulticastSender() {
+	if group.rtspMulticastSender == nil {
+		return
+	}
+	_ = group.rtspMulticastSender.Dispose()
+	group.rtspMulticastSender = nil
+}
@@ -62,6 +62,11 @@ func (h *HttpApiServer) RunLoop() error {
 	mux.HandleFunc("/api/ctrl/stop_relay_pull", h.ctrlStopRelayPullHandler)
 	mux.HandleFunc("/api/ctrl/kick_session", h.ctrlKickSessionHandler)
 	mux.HandleFunc("/api/ctrl/start_rtp_pub", h.ctrlStartRtpPubHandler)
+	mux.HandleFunc("/api/ctrl/start_record", h.ctrlStartRecordHandler)
+	mux.HandleFunc("/api/ctrl/stop_record", h.ctrlStopRecordHandler)
+	mux.HandleFunc("/api/ctrl/reload_config", h.ctrlReloadConfigHandler)
+
+	mux.HandleFunc("/metrics", h.metricsHandler)
 	// 所有没有注册路由的走下面这个处理函数
 	mux.HandleFunc("/", h.notFoundHandler)
 
@@ -115,6 +120,12 @@ func (h *HttpApiServer) statGroupHandler(w http.ResponseWriter, req *http.Reques
 	feedback(v, w)
 }
 
+func (h *HttpApiServer) metricsHandler(w http.ResponseWriter, req *http.Request) {
+	w.Header().Add("Server", base.LalHttpApiServer)
+	w.Header().Set("Content-Type", metricsContentType)
+	_, _ = w.Write(h.sm.Metrics())
+}
+
 // ---------------------------------------------------------------------------------------------------------------------
 
 func (h *HttpApiServer) ctrlStartRelayPullHandler(w http.ResponseWriter, req *http.Request) {
@@ -216,6 +227,51 @@ func (h *HttpApiServer) ctrlStartRtpPubHandler(w http.ResponseWriter, req *http.
 	feedback(resp, w)
 }
 
+func (h *HttpApiServer) ctrlStartRecordHandler(w http.ResponseWriter, req *http.Request) {
+	var v base.ApiCtrlStartRecordResp
+	var info base.ApiCtrlStartRecordReq
+
+	_, err := unmarshalRequestJsonBody(req, &info, "stream_name", "format")
+	if err != nil {
+		Log.Warnf("http api start record error. err=%+v", err)
+		v.ErrorCode = base.ErrorCodeParamMissing
+		v.Desp = base.DespParamMissing
+		feedback(v, w)
+		return
+	}
+
+	Log.Infof("http api start record. req info=%+v", info)
+
+	resp := h.sm.CtrlStartRecord(info)
+	feedback(resp, w)
+}
+
+func (h *HttpApiServer) ctrlStopRecordHandler(w http.ResponseWriter, req *http.Request) {
+	var v base.ApiCtrlStopRecordResp
+	var info base.ApiCtrlStopRecordReq
+
+	_, err := unmarshalRequestJsonBody(req, &info, "stream_name", "format")
+	if err != nil {
+		Log.Warnf("http api stop record error. err=%+v", err)
+		v.ErrorCode = base.ErrorCodeParamMissing
+		v.Desp = base.DespParamMissing
+		feedback(v, w)
+		return
+	}
+
+	Log.Infof("http api stop record. req info=%+v", info)
+
+	resp := h.sm.CtrlStopRecord(info)
+	feedback(resp, w)
+}
+
+func (h *HttpApiServer) ctrlReloadConfigHandler(w http.ResponseWriter, req *http.Request) {
+	Log.Infof("http api reload config.")
+
+	resp := h.sm.CtrlReloadConfig()
+	feedback(resp, w)
+}
+
 func (h *HttpApiServer) webUIHandler(w http.ResponseWriter, req *http.Request) {
 	t, err := template.New("webUI").Parse(webUITpl)
 	if err != nil {
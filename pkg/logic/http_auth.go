@@ -0,0 +1,182 @@
+// Copyright 2023, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/rtmp"
+	"github.com/q191201771/naza/pkg/nazahttp"
+)
+
+// http_auth.go
+// 同步的http回调鉴权，类似nginx-rtmp的on_publish和on_play
+//
+// lalserver将 base.PubStartInfo 或 base.SubStartInfo 以json格式POST给业务方，
+// 业务方返回2xx表示允许，其他状态码表示拒绝，请求失败或超时也视为拒绝
+//
+// 允许时，业务方可以在body中返回json(可选)：
+//
+//	{
+//	  "stream_name": "test220", // 不为空时，替换流名称
+//	  "max_duration_ms": 60000  // 大于0时，session持续该时长后被关闭
+//	}
+
+const httpAuthMaxBodySize = 64 * 1024
+
+type httpAuthResponse struct {
+	StreamName    string `json:"stream_name"`
+	MaxDurationMs int    `json:"max_duration_ms"`
+}
+
+// HttpAuthCtx
+//
+// 先执行next的鉴权，再执行http回调鉴权
+type HttpAuthCtx struct {
+	cfg      HttpAuthConfig
+	serverId string
+	next     IAuthentication
+
+	client *http.Client
+}
+
+func NewHttpAuthCtx(cfg HttpAuthConfig, serverId string, next IAuthentication) *HttpAuthCtx {
+	return &HttpAuthCtx{
+		cfg:      cfg,
+		serverId: serverId,
+		next:     next,
+		client: &http.Client{
+			Timeout: time.Duration(cfg.TimeoutMs) * time.Millisecond,
+		},
+	}
+}
+
+func (h *HttpAuthCtx) OnPubStart(info base.PubStartInfo) error {
+	_, err := h.OnPubStartWithResult(info)
+	return err
+}
+
+func (h *HttpAuthCtx) OnSubStart(info base.SubStartInfo) error {
+	_, err := h.OnSubStartWithResult(info)
+	return err
+}
+
+func (h *HttpAuthCtx) OnHls(streamName string, urlParam string) error {
+	return h.next.OnHls(streamName, urlParam)
+}
+
+// OnHlsRequest 实现 IHlsRequestAuthentication ，注意，m3u8请求比较频繁，所以不做http回调
+func (h *HttpAuthCtx) OnHlsRequest(streamName, urlParam, remoteAddr string, header map[string][]string) error {
+	if a, ok := h.next.(IHlsRequestAuthentication); ok {
+		return a.OnHlsRequest(streamName, urlParam, remoteAddr, header)
+	}
+	return h.next.OnHls(streamName, urlParam)
+}
+
+// OnPubStartWithResult 实现 IAuthenticationWithResult
+func (h *HttpAuthCtx) OnPubStartWithResult(info base.PubStartInfo) (AuthResult, error) {
+	var nextResult AuthResult
+	var err error
+	if a, ok := h.next.(IAuthenticationWithResult); ok {
+		nextResult, err = a.OnPubStartWithResult(info)
+	} else {
+		err = h.next.OnPubStart(info)
+	}
+	if err != nil {
+		return AuthResult{}, err
+	}
+	info.ServerId = h.serverId
+	result, err := h.post(h.cfg.OnPubStart, info)
+	if err != nil {
+		return AuthResult{}, err
+	}
+	return mergeAuthResult(nextResult, result), nil
+}
+
+// OnSubStartWithResult 实现 IAuthenticationWithResult
+func (h *HttpAuthCtx) OnSubStartWithResult(info base.SubStartInfo) (AuthResult, error) {
+	var nextResult AuthResult
+	var err error
+	if a, ok := h.next.(IAuthenticationWithResult); ok {
+		nextResult, err = a.OnSubStartWithResult(info)
+	} else {
+		err = h.next.OnSubStart(info)
+	}
+	if err != nil {
+		return AuthResult{}, err
+	}
+	info.ServerId = h.serverId
+	result, err := h.post(h.cfg.OnSubStart, info)
+	if err != nil {
+		return AuthResult{}, err
+	}
+	return mergeAuthResult(nextResult, result), nil
+}
+
+// AuthMod 实现 rtmp.IServerAuthProvider
+func (h *HttpAuthCtx) AuthMod(appName string) string {
+	if p, ok := h.next.(rtmp.IServerAuthProvider); ok {
+		return p.AuthMod(appName)
+	}
+	return ""
+}
+
+// Password 实现 rtmp.IServerAuthProvider
+func (h *HttpAuthCtx) Password(appName string, user string) (string, bool) {
+	if p, ok := h.next.(rtmp.IServerAuthProvider); ok {
+		return p.Password(appName, user)
+	}
+	return "", false
+}
+
+// mergeAuthResult http回调返回的字段优先，没有返回的字段使用next鉴权的结果
+func mergeAuthResult(next AuthResult, result AuthResult) AuthResult {
+	if result.StreamName == "" {
+		result.StreamName = next.StreamName
+	}
+	if result.MaxDurationMs <= 0 {
+		result.MaxDurationMs = next.MaxDurationMs
+	}
+	if result.MaxViewers <= 0 {
+		result.MaxViewers = next.MaxViewers
+	}
+	return result
+}
+
+func (h *HttpAuthCtx) post(url string, info interface{}) (result AuthResult, err error) {
+	if url == "" {
+		return
+	}
+
+	resp, err := nazahttp.PostJson(url, info, h.client)
+	if err != nil {
+		Log.Errorf("http auth post error. err=%+v, url=%s, info=%+v", err, url, info)
+		return result, fmt.Errorf("%w. err=%v", base.ErrHttpAuthFailed, err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, httpAuthMaxBodySize))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		Log.Warnf("http auth rejected. status=%d, url=%s, info=%+v", resp.StatusCode, url, info)
+		return result, fmt.Errorf("%w. status=%d", base.ErrHttpAuthRejected, resp.StatusCode)
+	}
+
+	// body为空或者不是json时，只表示允许
+	var r httpAuthResponse
+	if len(body) != 0 && json.Unmarshal(body, &r) == nil {
+		result.StreamName = r.StreamName
+		result.MaxDurationMs = r.MaxDurationMs
+	}
+	return result, nil
+}
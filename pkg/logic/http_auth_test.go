@@ -0,0 +1,83 @@
+// Copyright 2023, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestHttpAuthCtx(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/on_pub_auth", func(w http.ResponseWriter, r *http.Request) {
+		var info base.PubStartInfo
+		_ = json.NewDecoder(r.Body).Decode(&info)
+		switch info.StreamName {
+		case "test110":
+			_, _ = w.Write([]byte(`{"stream_name":"test220","max_duration_ms":60000}`))
+		case "test111":
+			_, _ = w.Write([]byte("ok"))
+		case "slow":
+			time.Sleep(500 * time.Millisecond)
+		default:
+			w.WriteHeader(http.StatusForbidden)
+		}
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	ctx := NewHttpAuthCtx(HttpAuthConfig{
+		Enable:     true,
+		OnPubStart: server.URL + "/on_pub_auth",
+		TimeoutMs:  100,
+	}, "1", NewSimpleAuthCtx(SimpleAuthConfig{
+		Key:           "q191201771",
+		PubRtmpEnable: true,
+	}))
+
+	var info base.PubStartInfo
+	info.Protocol = base.SessionProtocolRtmpStr
+	info.UrlParam = "lal_secret=" + SimpleAuthCalcSecret("q191201771", "test110")
+	info.StreamName = "test110"
+	result, err := ctx.OnPubStartWithResult(info)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, AuthResult{StreamName: "test220", MaxDurationMs: 60000}, result)
+
+	// body不是json
+	info.StreamName = "test111"
+	info.UrlParam = "lal_secret=" + SimpleAuthCalcSecret("q191201771", "test111")
+	result, err = ctx.OnPubStartWithResult(info)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, AuthResult{}, result)
+
+	// 先执行simple auth
+	info.UrlParam = ""
+	assert.Equal(t, base.ErrSimpleAuthParamNotFound, ctx.OnPubStart(info))
+
+	info.StreamName = "test112"
+	info.UrlParam = "lal_secret=" + SimpleAuthCalcSecret("q191201771", "test112")
+	assert.Equal(t, true, errors.Is(ctx.OnPubStart(info), base.ErrHttpAuthRejected))
+
+	info.StreamName = "slow"
+	info.UrlParam = "lal_secret=" + SimpleAuthCalcSecret("q191201771", "slow")
+	assert.Equal(t, true, errors.Is(ctx.OnPubStart(info), base.ErrHttpAuthFailed))
+
+	// 没有配置on_sub_start，不做回调
+	var subInfo base.SubStartInfo
+	subInfo.Protocol = base.SessionProtocolFlvStr
+	subInfo.StreamName = "test113"
+	assert.Equal(t, nil, ctx.OnSubStart(subInfo))
+}
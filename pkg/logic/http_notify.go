@@ -10,6 +10,7 @@ package logic
 
 import (
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/q191201771/lal/pkg/base"
@@ -29,7 +30,8 @@ type PostTask struct {
 }
 
 type HttpNotify struct {
-	cfg HttpNotifyConfig
+	cfgMutex sync.Mutex
+	cfg      HttpNotifyConfig
 
 	serverId string
 
@@ -53,56 +55,74 @@ func NewHttpNotify(cfg HttpNotifyConfig, serverId string) *HttpNotify {
 
 // TODO(chef): Dispose
 
+// UpdateConfig 配置热加载时调用，对之后的通知生效
+func (h *HttpNotify) UpdateConfig(cfg HttpNotifyConfig) {
+	h.cfgMutex.Lock()
+	defer h.cfgMutex.Unlock()
+	h.cfg = cfg
+}
+
+func (h *HttpNotify) config() HttpNotifyConfig {
+	h.cfgMutex.Lock()
+	defer h.cfgMutex.Unlock()
+	return h.cfg
+}
+
 // ---------------------------------------------------------------------------------------------------------------------
 
 func (h *HttpNotify) NotifyServerStart(info base.LalInfo) {
 	info.ServerId = h.serverId
-	h.asyncPost(h.cfg.OnServerStart, info)
+	h.asyncPost(h.config().OnServerStart, info)
 }
 
 func (h *HttpNotify) NotifyUpdate(info base.UpdateInfo) {
 	info.ServerId = h.serverId
-	h.asyncPost(h.cfg.OnUpdate, info)
+	h.asyncPost(h.config().OnUpdate, info)
 }
 
 func (h *HttpNotify) NotifyPubStart(info base.PubStartInfo) {
 	info.ServerId = h.serverId
-	h.asyncPost(h.cfg.OnPubStart, info)
+	h.asyncPost(h.config().OnPubStart, info)
 }
 
 func (h *HttpNotify) NotifyPubStop(info base.PubStopInfo) {
 	info.ServerId = h.serverId
-	h.asyncPost(h.cfg.OnPubStop, info)
+	h.asyncPost(h.config().OnPubStop, info)
 }
 
 func (h *HttpNotify) NotifySubStart(info base.SubStartInfo) {
 	info.ServerId = h.serverId
-	h.asyncPost(h.cfg.OnSubStart, info)
+	h.asyncPost(h.config().OnSubStart, info)
 }
 
 func (h *HttpNotify) NotifySubStop(info base.SubStopInfo) {
 	info.ServerId = h.serverId
-	h.asyncPost(h.cfg.OnSubStop, info)
+	h.asyncPost(h.config().OnSubStop, info)
 }
 
 func (h *HttpNotify) NotifyPullStart(info base.PullStartInfo) {
 	info.ServerId = h.serverId
-	h.asyncPost(h.cfg.OnRelayPullStart, info)
+	h.asyncPost(h.config().OnRelayPullStart, info)
 }
 
 func (h *HttpNotify) NotifyPullStop(info base.PullStopInfo) {
 	info.ServerId = h.serverId
-	h.asyncPost(h.cfg.OnRelayPullStop, info)
+	h.asyncPost(h.config().OnRelayPullStop, info)
 }
 
 func (h *HttpNotify) NotifyRtmpConnect(info base.RtmpConnectInfo) {
 	info.ServerId = h.serverId
-	h.asyncPost(h.cfg.OnRtmpConnect, info)
+	h.asyncPost(h.config().OnRtmpConnect, info)
 }
 
 func (h *HttpNotify) NotifyOnHlsMakeTs(info base.HlsMakeTsInfo) {
 	info.ServerId = h.serverId
-	h.asyncPost(h.cfg.OnHlsMakeTs, info)
+	h.asyncPost(h.config().OnHlsMakeTs, info)
+}
+
+func (h *HttpNotify) NotifyRecordFileClosed(info base.RecordFileClosedInfo) {
+	info.ServerId = h.serverId
+	h.asyncPost(h.config().OnRecordFileClosed, info)
 }
 
 // ----- implement INotifyHandler interface ----------------------------------------------------------------------------
@@ -147,6 +167,10 @@ func (h *HttpNotify) OnHlsMakeTs(info base.HlsMakeTsInfo) {
 	h.NotifyOnHlsMakeTs(info)
 }
 
+func (h *HttpNotify) OnRecordFileClosed(info base.RecordFileClosedInfo) {
+	h.NotifyRecordFileClosed(info)
+}
+
 // ---------------------------------------------------------------------------------------------------------------------
 
 func (h *HttpNotify) RunLoop() {
@@ -161,7 +185,7 @@ func (h *HttpNotify) RunLoop() {
 // ---------------------------------------------------------------------------------------------------------------------
 
 func (h *HttpNotify) asyncPost(url string, info interface{}) {
-	if !h.cfg.Enable || url == "" {
+	if !h.config().Enable || url == "" {
 		return
 	}
 
@@ -98,7 +98,7 @@ func (h *HttpServerHandler) ServeSubSession(writer http.ResponseWriter, req *htt
 	}
 
 	if strings.HasSuffix(urlCtx.LastItemOfPath, ".ts") {
-		session := httpts.NewSubSession(conn, urlCtx, isWebSocket, webSocketKey)
+		session := httpts.NewSubSession(conn, urlCtx, isWebSocket, webSocketKey, req.Header)
 		Log.Debugf("[%s] < read http request. url=%s", session.UniqueKey(), session.Url())
 		if err = h.observer.OnNewHttptsSubSession(session); err != nil {
 			Log.Infof("[%s] dispose by observer. err=%+v", session.UniqueKey(), err)
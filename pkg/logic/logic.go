@@ -60,6 +60,9 @@ type ILalServer interface {
 	CtrlStartRelayPull(info base.ApiCtrlStartRelayPullReq) base.ApiCtrlStartRelayPullResp
 	CtrlStopRelayPull(streamName string) base.ApiCtrlStopRelayPullResp
 	CtrlKickSession(info base.ApiCtrlKickSessionReq) base.ApiCtrlKickSessionResp
+	CtrlStartRecord(info base.ApiCtrlStartRecordReq) base.ApiCtrlStartRecordResp
+	CtrlStopRecord(info base.ApiCtrlStopRecordReq) base.ApiCtrlStopRecordResp
+	CtrlReloadConfig() base.ApiCtrlReloadConfigResp
 	KickFlvByCond(KickFlvFunc func(streamName string, flvHeader map[string][]string) bool)
 }
 
@@ -97,6 +100,7 @@ type INotifyHandler interface {
 	OnRelayPullStop(info base.PullStopInfo)
 	OnRtmpConnect(info base.RtmpConnectInfo)
 	OnHlsMakeTs(info base.HlsMakeTsInfo)
+	OnRecordFileClosed(info base.RecordFileClosedInfo)
 }
 
 type Option struct {
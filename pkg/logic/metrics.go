@@ -0,0 +1,226 @@
+// Copyright 2023, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"bytes"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/q191201771/lal/pkg/base"
+)
+
+// metrics.go
+// 以Prometheus文本格式(text/plain; version=0.0.4)导出统计信息，对应http api `/metrics`
+//
+// 数据来源与 `/api/stat/*` 相同，另外包含relay pull/push重试次数、hls分片数、gop缓存数、rtsp输入流的rtp丢包数
+
+const metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Metrics 生成Prometheus文本格式的统计信息
+//
+// 持有ServerManager的锁时只收集各group的快照，格式化在锁外进行
+func (sm *ServerManager) Metrics() []byte {
+	lalInfo := sm.StatLalInfo()
+
+	var groups []groupMetrics
+	sm.mutex.Lock()
+	sm.groupManager.Iterate(func(group *Group) bool {
+		groups = append(groups, group.getMetrics())
+		return true
+	})
+	sm.mutex.Unlock()
+
+	return renderMetrics(lalInfo, groups)
+}
+
+func renderMetrics(lalInfo base.LalInfo, groups []groupMetrics) []byte {
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].stat.AppName != groups[j].stat.AppName {
+			return groups[i].stat.AppName < groups[j].stat.AppName
+		}
+		return groups[i].stat.StreamName < groups[j].stat.StreamName
+	})
+
+	var w metricsWriter
+
+	w.header("lal_info", "gauge", "Information about the lalserver.")
+	w.sample("lal_info", 1,
+		"lal_version", lalInfo.LalVersion,
+		"api_version", lalInfo.ApiVersion,
+		"server_id", lalInfo.ServerId,
+		"start_time", lalInfo.StartTime)
+
+	w.header("lal_groups", "gauge", "Number of groups.")
+	w.sample("lal_groups", float64(len(groups)))
+
+	// session相关
+	type sessionItem struct {
+		appName    string
+		streamName string
+		stat       base.StatSession
+	}
+	var sessions []sessionItem
+	for _, g := range groups {
+		for _, s := range groupSessionStats(g) {
+			sessions = append(sessions, sessionItem{g.stat.AppName, g.stat.StreamName, s})
+		}
+	}
+
+	type protocolKey struct {
+		protocol string
+		baseType string
+	}
+	sessionCount := make(map[protocolKey]int)
+	var protocolKeys []protocolKey
+	for _, s := range sessions {
+		k := protocolKey{s.stat.Protocol, s.stat.BaseType}
+		if _, ok := sessionCount[k]; !ok {
+			protocolKeys = append(protocolKeys, k)
+		}
+		sessionCount[k]++
+	}
+	sort.Slice(protocolKeys, func(i, j int) bool {
+		if protocolKeys[i].protocol != protocolKeys[j].protocol {
+			return protocolKeys[i].protocol < protocolKeys[j].protocol
+		}
+		return protocolKeys[i].baseType < protocolKeys[j].baseType
+	})
+	w.header("lal_sessions", "gauge", "Number of sessions by protocol and base type.")
+	for _, k := range protocolKeys {
+		w.sample("lal_sessions", float64(sessionCount[k]), "protocol", k.protocol, "base_type", k.baseType)
+	}
+
+	sessionLabels := func(s sessionItem) []string {
+		return []string{
+			"app_name", s.appName,
+			"stream_name", s.streamName,
+			"session_id", s.stat.SessionId,
+			"protocol", s.stat.Protocol,
+			"base_type", s.stat.BaseType,
+		}
+	}
+	w.header("lal_session_read_bytes_total", "counter", "Bytes read by the session.")
+	for _, s := range sessions {
+		w.sample("lal_session_read_bytes_total", float64(s.stat.ReadBytesSum), sessionLabels(s)...)
+	}
+	w.header("lal_session_wrote_bytes_total", "counter", "Bytes written by the session.")
+	for _, s := range sessions {
+		w.sample("lal_session_wrote_bytes_total", float64(s.stat.WroteBytesSum), sessionLabels(s)...)
+	}
+	w.header("lal_session_read_bitrate_kbits", "gauge", "Read bitrate of the session in kbit/s.")
+	for _, s := range sessions {
+		w.sample("lal_session_read_bitrate_kbits", float64(s.stat.ReadBitrateKbits), sessionLabels(s)...)
+	}
+	w.header("lal_session_write_bitrate_kbits", "gauge", "Write bitrate of the session in kbit/s.")
+	for _, s := range sessions {
+		w.sample("lal_session_write_bitrate_kbits", float64(s.stat.WriteBitrateKbits), sessionLabels(s)...)
+	}
+
+	// group相关
+	groupLabels := func(g groupMetrics, kv ...string) []string {
+		return append([]string{"app_name", g.stat.AppName, "stream_name", g.stat.StreamName}, kv...)
+	}
+	w.header("lal_group_pull_retries_total", "counter", "Relay pull retries of the group.")
+	for _, g := range groups {
+		w.sample("lal_group_pull_retries_total", float64(g.pullRetryCount), groupLabels(g)...)
+	}
+	w.header("lal_group_push_retries_total", "counter", "Relay push retries of the group.")
+	for _, g := range groups {
+		w.sample("lal_group_push_retries_total", float64(g.pushRetryCount), groupLabels(g)...)
+	}
+	w.header("lal_group_hls_fragments_total", "counter", "HLS fragments produced by the group.")
+	for _, g := range groups {
+		w.sample("lal_group_hls_fragments_total", float64(g.hlsFragmentCount), groupLabels(g)...)
+	}
+	w.header("lal_group_gop_cache_gops", "gauge", "Number of GOPs in the group's GOP cache.")
+	for _, g := range groups {
+		for _, c := range g.gopCacheCounts {
+			w.sample("lal_group_gop_cache_gops", float64(c.count), groupLabels(g, "cache", c.name)...)
+		}
+	}
+
+	// rtp接收统计
+	w.header("lal_rtp_received_packets_total", "counter", "RTP packets received by the input session.")
+	for _, g := range groups {
+		for _, r := range g.rtpRecvStats {
+			w.sample("lal_rtp_received_packets_total", float64(r.stat.Received),
+				groupLabels(g, "session_id", r.sessionId, "protocol", r.protocol, "media", r.media)...)
+		}
+	}
+	w.header("lal_rtp_lost_packets_total", "counter", "RTP packets lost by the input session, calculated by sequence number.")
+	for _, g := range groups {
+		for _, r := range g.rtpRecvStats {
+			w.sample("lal_rtp_lost_packets_total", float64(r.stat.Lost),
+				groupLabels(g, "session_id", r.sessionId, "protocol", r.protocol, "media", r.media)...)
+		}
+	}
+
+	return w.buf.Bytes()
+}
+
+// groupSessionStats group中所有session的统计，包含pub、pull、sub、push
+func groupSessionStats(g groupMetrics) []base.StatSession {
+	var ret []base.StatSession
+	if g.stat.StatPub.SessionId != "" {
+		ret = append(ret, g.stat.StatPub.StatSession)
+	}
+	if g.stat.StatPull.SessionId != "" {
+		ret = append(ret, g.stat.StatPull.StatSession)
+	}
+	for _, s := range g.stat.StatSubs {
+		ret = append(ret, s.StatSession)
+	}
+	ret = append(ret, g.pushStats...)
+	return ret
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+type metricsWriter struct {
+	buf bytes.Buffer
+}
+
+func (w *metricsWriter) header(name, typ, help string) {
+	w.buf.WriteString("# HELP ")
+	w.buf.WriteString(name)
+	w.buf.WriteByte(' ')
+	w.buf.WriteString(help)
+	w.buf.WriteString("\n# TYPE ")
+	w.buf.WriteString(name)
+	w.buf.WriteByte(' ')
+	w.buf.WriteString(typ)
+	w.buf.WriteByte('\n')
+}
+
+// sample
+//
+// @param labels: 依次为label的key和value
+func (w *metricsWriter) sample(name string, value float64, labels ...string) {
+	w.buf.WriteString(name)
+	if len(labels) != 0 {
+		w.buf.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i != 0 {
+				w.buf.WriteByte(',')
+			}
+			w.buf.WriteString(labels[i])
+			w.buf.WriteString(`="`)
+			w.buf.WriteString(metricsLabelEscaper.Replace(labels[i+1]))
+			w.buf.WriteByte('"')
+		}
+		w.buf.WriteByte('}')
+	}
+	w.buf.WriteByte(' ')
+	w.buf.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+	w.buf.WriteByte('\n')
+}
+
+var metricsLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
@@ -0,0 +1,67 @@
+// Copyright 2023, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/rtprtcp"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestRenderMetrics(t *testing.T) {
+	g := groupMetrics{
+		pullRetryCount:   2,
+		pushRetryCount:   1,
+		hlsFragmentCount: 10,
+		gopCacheCounts:   []gopCacheCount{{"rtmp", 3}},
+		rtpRecvStats: []rtpRecvStat{
+			{sessionId: "RTSPPUBSUB1", protocol: base.SessionProtocolRtspStr, media: "video", stat: rtprtcp.RrStat{Received: 98, Lost: 2}},
+		},
+	}
+	g.stat.AppName = "live"
+	g.stat.StreamName = `te"st`
+	g.stat.StatPub.StatSession = base.StatSession{
+		SessionId:        "RTSPPUBSUB1",
+		Protocol:         base.SessionProtocolRtspStr,
+		BaseType:         base.SessionBaseTypePubStr,
+		ReadBytesSum:     1024,
+		ReadBitrateKbits: 800,
+	}
+	g.stat.StatSubs = []base.StatSub{
+		{StatSession: base.StatSession{SessionId: "RTMPSUB1", Protocol: base.SessionProtocolRtmpStr, BaseType: base.SessionBaseTypeSubStr, WroteBytesSum: 512}},
+		{StatSession: base.StatSession{SessionId: "RTMPSUB2", Protocol: base.SessionProtocolRtmpStr, BaseType: base.SessionBaseTypeSubStr, WroteBytesSum: 256}},
+	}
+
+	out := string(renderMetrics(base.LalInfo{LalVersion: "v0.0.1", ServerId: "1"}, []groupMetrics{g}))
+
+	lines := []string{
+		`# TYPE lal_groups gauge`,
+		`lal_groups 1`,
+		`lal_info{lal_version="v0.0.1",api_version="",server_id="1",start_time=""} 1`,
+		`lal_sessions{protocol="RTMP",base_type="SUB"} 2`,
+		`lal_sessions{protocol="RTSP",base_type="PUB"} 1`,
+		`lal_session_read_bytes_total{app_name="live",stream_name="te\"st",session_id="RTSPPUBSUB1",protocol="RTSP",base_type="PUB"} 1024`,
+		`lal_session_wrote_bytes_total{app_name="live",stream_name="te\"st",session_id="RTMPSUB1",protocol="RTMP",base_type="SUB"} 512`,
+		`lal_session_read_bitrate_kbits{app_name="live",stream_name="te\"st",session_id="RTSPPUBSUB1",protocol="RTSP",base_type="PUB"} 800`,
+		`lal_group_pull_retries_total{app_name="live",stream_name="te\"st"} 2`,
+		`lal_group_push_retries_total{app_name="live",stream_name="te\"st"} 1`,
+		`lal_group_hls_fragments_total{app_name="live",stream_name="te\"st"} 10`,
+		`lal_group_gop_cache_gops{app_name="live",stream_name="te\"st",cache="rtmp"} 3`,
+		`lal_rtp_received_packets_total{app_name="live",stream_name="te\"st",session_id="RTSPPUBSUB1",protocol="RTSP",media="video"} 98`,
+		`lal_rtp_lost_packets_total{app_name="live",stream_name="te\"st",session_id="RTSPPUBSUB1",protocol="RTSP",media="video"} 2`,
+	}
+	for _, line := range lines {
+		assert.Equal(t, true, strings.Contains(out, line+"\n"), line)
+	}
+	// 没有pull session时不导出
+	assert.Equal(t, false, strings.Contains(out, `base_type="PULL"`))
+}
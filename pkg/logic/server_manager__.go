@@ -16,7 +16,9 @@ import (
 	_ "net/http/pprof"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/q191201771/lal/pkg/base"
@@ -25,6 +27,8 @@ import (
 	"github.com/q191201771/lal/pkg/httpts"
 	"github.com/q191201771/lal/pkg/rtmp"
 	"github.com/q191201771/lal/pkg/rtsp"
+	"github.com/q191201771/lal/pkg/srt"
+	"github.com/q191201771/lal/pkg/webrtc"
 	"github.com/q191201771/naza/pkg/defertaskthread"
 	"github.com/q191201771/naza/pkg/nazalog"
 	//"github.com/felixge/fgprof"
@@ -33,7 +37,9 @@ import (
 type ServerManager struct {
 	option          Option
 	serverStartTime string
-	config          *Config
+	config          atomic.Pointer[Config] // 热加载时整体替换，不修改已有的Config，所以可以在不持有锁时读取
+	confFilename    string                 // 配置从文件读取时有效，用于热加载
+	authFromConfig  bool                   // Authentication 是否根据配置创建，是的话热加载时重新创建
 
 	httpServerManager *base.HttpServerManager
 	httpServerHandler *HttpServerHandler
@@ -43,18 +49,39 @@ type ServerManager struct {
 	rtmpsServer   *rtmp.Server
 	rtspServer    *rtsp.Server
 	rtspsServer   *rtsp.Server
+	srtServer     *srt.Server
+	webrtcServer  *webrtc.Server
 	httpApiServer *HttpApiServer
 	pprofServer   *http.Server
 	exitChan      chan struct{}
 
 	mutex        sync.Mutex
 	groupManager IGroupManager
+	vodPlayers   map[string]*vodPlayer // key: session的UniqueKey
+
+	vodIndexCache *vodIndexCache
+
+	authResultMutex sync.Mutex
+	authResults     map[string]*sessionAuthResult // key: session的UniqueKey
+	authing         map[string]bool               // 正在鉴权的session，value表示鉴权期间session是否已经结束，key: session的UniqueKey
+}
+
+// sessionAuthResult 鉴权返回的 AuthResult 在session上的生效状态
+type sessionAuthResult struct {
+	streamName    string
+	maxDurationMs int
+	timer         *time.Timer
+	maxViewers    int
 }
 
 func NewServerManager(modOption ...ModOption) *ServerManager {
 	sm := &ServerManager{
 		serverStartTime: base.ReadableNowTime(),
 		exitChan:        make(chan struct{}, 1),
+		vodPlayers:      make(map[string]*vodPlayer),
+		vodIndexCache:   newVodIndexCache(),
+		authResults:     make(map[string]*sessionAuthResult),
+		authing:         make(map[string]bool),
 	}
 	sm.groupManager = NewSimpleGroupManager(sm)
 
@@ -92,67 +119,110 @@ Doc: %s
 			_, _ = fmt.Fprintf(os.Stderr, "read conf file failed. file=%s err=%+v", confFile, err)
 			base.OsExitAndWaitPressIfWindows(1)
 		}
+		sm.confFilename = confFile
 	}
-	sm.config = LoadConfAndInitLog(rawContent)
+	config := LoadConfAndInitLog(rawContent)
+	sm.config.Store(config)
 	base.LogoutStartInfo()
 
-	if sm.config.HlsConfig.EnableCache {
+	if config.HlsConfig.EnableCache {
 		Log.Infof("hls use memory as disk.")
-		hls.SetUseMemoryAsDiskFlag(sm.config.HlsConfig.CacheFlag, sm.option.NewHlsCache)
+		hls.SetUseMemoryAsDiskFlag(config.HlsConfig.CacheFlag, sm.option.NewHlsCache)
+	}
+
+	if config.RecordConfig.EnableFlv {
+		if err := os.MkdirAll(config.RecordConfig.FlvOutPath, 0777); err != nil {
+			Log.Errorf("record flv mkdir error. path=%s, err=%+v", config.RecordConfig.FlvOutPath, err)
+		}
 	}
 
-	if sm.config.RecordConfig.EnableFlv {
-		if err := os.MkdirAll(sm.config.RecordConfig.FlvOutPath, 0777); err != nil {
-			Log.Errorf("record flv mkdir error. path=%s, err=%+v", sm.config.RecordConfig.FlvOutPath, err)
+	if config.RecordConfig.EnableMpegts {
+		if err := os.MkdirAll(config.RecordConfig.MpegtsOutPath, 0777); err != nil {
+			Log.Errorf("record mpegts mkdir error. path=%s, err=%+v", config.RecordConfig.MpegtsOutPath, err)
 		}
 	}
 
-	if sm.config.RecordConfig.EnableMpegts {
-		if err := os.MkdirAll(sm.config.RecordConfig.MpegtsOutPath, 0777); err != nil {
-			Log.Errorf("record mpegts mkdir error. path=%s, err=%+v", sm.config.RecordConfig.MpegtsOutPath, err)
+	if config.RecordConfig.EnableMp4 {
+		if err := os.MkdirAll(config.RecordConfig.Mp4OutPath, 0777); err != nil {
+			Log.Errorf("record mp4 mkdir error. path=%s, err=%+v", config.RecordConfig.Mp4OutPath, err)
 		}
 	}
 
 	if sm.option.NotifyHandler == nil {
-		sm.option.NotifyHandler = NewHttpNotify(sm.config.HttpNotifyConfig, sm.config.ServerId)
+		sm.option.NotifyHandler = NewHttpNotify(config.HttpNotifyConfig, config.ServerId)
 	}
 
-	if sm.config.HttpflvConfig.Enable || sm.config.HttpflvConfig.EnableHttps ||
-		sm.config.HttptsConfig.Enable || sm.config.HttptsConfig.EnableHttps ||
-		sm.config.HlsConfig.Enable || sm.config.HlsConfig.EnableHttps {
+	if config.HttpflvConfig.Enable || config.HttpflvConfig.EnableHttps ||
+		config.HttptsConfig.Enable || config.HttptsConfig.EnableHttps ||
+		config.HlsConfig.Enable || config.HlsConfig.EnableHttps ||
+		config.WebrtcConfig.Enable || config.WebrtcConfig.EnableHttps {
 		sm.httpServerManager = base.NewHttpServerManager()
 		sm.httpServerHandler = NewHttpServerHandler(sm, sm.option)
-		sm.hlsServerHandler = hls.NewServerHandler(sm.config.HlsConfig.OutPath,
-			sm.config.HlsConfig.UrlPattern,
-			sm.config.HlsConfig.SubSessionHashKey,
-			sm.config.HlsConfig.SubSessionTimeoutMs,
-			sm.config.DefaultHttpConfig.HttpGZip,
+		sm.hlsServerHandler = hls.NewServerHandler(config.HlsConfig.OutPath,
+			config.HlsConfig.UrlPattern,
+			config.HlsConfig.SubSessionHashKey,
+			config.HlsConfig.SubSessionTimeoutMs,
+			config.DefaultHttpConfig.HttpGZip,
 			sm,
 			sm.option.BeforeWriteM3u8)
 	}
 
-	if sm.config.RtmpConfig.Enable {
-		sm.rtmpServer = rtmp.NewServer(sm.config.RtmpConfig.Addr, sm)
+	if config.RtmpConfig.Enable {
+		sm.rtmpServer = rtmp.NewServer(config.RtmpConfig.Addr, sm)
+	}
+	if config.RtmpConfig.RtmpsEnable {
+		sm.rtmpsServer = rtmp.NewServer(config.RtmpConfig.RtmpsAddr, sm)
 	}
-	if sm.config.RtmpConfig.RtmpsEnable {
-		sm.rtmpsServer = rtmp.NewServer(sm.config.RtmpConfig.RtmpsAddr, sm)
+	var rtspMulticastPool *rtsp.MulticastAddrPool
+	if config.RtspConfig.MulticastEnable {
+		var err error
+		if rtspMulticastPool, err = rtsp.NewMulticastAddrPool(config.RtspConfig.MulticastConfig); err != nil {
+			Log.Errorf("create rtsp multicast addr pool failed, multicast disabled. err=%+v", err)
+		}
 	}
-	if sm.config.RtspConfig.Enable {
-		sm.rtspServer = rtsp.NewServer(sm.config.RtspConfig.Addr, sm, sm.config.RtspConfig.ServerAuthConfig)
+	if config.RtspConfig.Enable {
+		sm.rtspServer = rtsp.NewServer(config.RtspConfig.Addr, sm, config.RtspConfig.ServerAuthConfig)
+		sm.rtspServer.SetSessionTimeoutSec(config.RtspConfig.SessionTimeoutSec)
+		sm.rtspServer.SetInLossConfig(config.RtspConfig.InLossConfig)
+		if rtspMulticastPool != nil {
+			sm.rtspServer.SetMulticastAddrPool(rtspMulticastPool)
+		}
 	}
-	if sm.config.RtspConfig.RtspsEnable {
-		sm.rtspsServer = rtsp.NewServer(sm.config.RtspConfig.RtspsAddr, sm, sm.config.RtspConfig.ServerAuthConfig)
+	if config.RtspConfig.RtspsEnable {
+		sm.rtspsServer = rtsp.NewServer(config.RtspConfig.RtspsAddr, sm, config.RtspConfig.ServerAuthConfig)
+		sm.rtspsServer.SetSessionTimeoutSec(config.RtspConfig.SessionTimeoutSec)
+		sm.rtspsServer.SetInLossConfig(config.RtspConfig.InLossConfig)
+		if rtspMulticastPool != nil {
+			sm.rtspsServer.SetMulticastAddrPool(rtspMulticastPool)
+		}
 	}
-	if sm.config.HttpApiConfig.Enable {
-		sm.httpApiServer = NewHttpApiServer(sm.config.HttpApiConfig.Addr, sm)
+	if config.SrtConfig.Enable {
+		sm.srtServer = srt.NewServer(config.SrtConfig.Addr, sm, config.SrtConfig.ServerConfig)
+	}
+	if config.WebrtcConfig.Enable || config.WebrtcConfig.EnableHttps {
+		sm.webrtcServer = webrtc.NewServer(config.WebrtcConfig.UdpAddr, sm, config.WebrtcConfig.ServerConfig)
+	}
+	if config.HttpApiConfig.Enable {
+		sm.httpApiServer = NewHttpApiServer(config.HttpApiConfig.Addr, sm)
 	}
 
-	if sm.config.PprofConfig.Enable {
-		sm.pprofServer = &http.Server{Addr: sm.config.PprofConfig.Addr, Handler: nil}
+	if config.PprofConfig.Enable {
+		sm.pprofServer = &http.Server{Addr: config.PprofConfig.Addr, Handler: nil}
 	}
 
 	if sm.option.Authentication == nil {
-		sm.option.Authentication = NewSimpleAuthCtx(sm.config.SimpleAuthConfig)
+		sm.authFromConfig = true
+		sm.option.Authentication = sm.newAuthenticationByConfig(config)
+	}
+	if _, ok := sm.option.Authentication.(rtmp.IServerAuthProvider); ok {
+		// 热加载时 Authentication 可能被替换，所以这里不直接使用 Authentication
+		provider := &authProviderProxy{sm: sm}
+		if sm.rtmpServer != nil {
+			sm.rtmpServer.SetAuthProvider(provider)
+		}
+		if sm.rtmpsServer != nil {
+			sm.rtmpsServer.SetAuthProvider(provider)
+		}
 	}
 
 	return sm
@@ -165,11 +235,14 @@ func (sm *ServerManager) RunLoop() error {
 
 	sm.option.NotifyHandler.OnServerStart(sm.StatLalInfo())
 
+	// 监听地址等配置热加载时不生效，启动时读取一次
+	config := sm.Config()
+
 	if sm.pprofServer != nil {
 		go func() {
 			//Log.Warn("start fgprof.")
 			//http.DefaultServeMux.Handle("/debug/fgprof", fgprof.Handler())
-			Log.Infof("start web pprof listen. addr=%s", sm.config.PprofConfig.Addr)
+			Log.Infof("start web pprof listen. addr=%s", config.PprofConfig.Addr)
 			if err := sm.pprofServer.ListenAndServe(); err != nil {
 				Log.Error(err)
 			}
@@ -180,6 +253,11 @@ func (sm *ServerManager) RunLoop() error {
 		sm.Dispose()
 	})
 
+	go base.RunReloadSignalHandler(func() {
+		ret := sm.CtrlReloadConfig()
+		Log.Infof("reload config by signal. ret=%+v", ret)
+	})
+
 	var addMux = func(config CommonHttpServerConfig, handler base.Handler, name string) error {
 		if config.Enable {
 			err := sm.httpServerManager.AddListen(
@@ -208,18 +286,66 @@ func (sm *ServerManager) RunLoop() error {
 		return nil
 	}
 
-	if err := addMux(sm.config.HttpflvConfig.CommonHttpServerConfig, sm.httpServerHandler.ServeSubSession, "httpflv"); err != nil {
+	if err := addMux(config.HttpflvConfig.CommonHttpServerConfig, sm.httpServerHandler.ServeSubSession, "httpflv"); err != nil {
 		return err
 	}
-	if err := addMux(sm.config.HttptsConfig.CommonHttpServerConfig, sm.httpServerHandler.ServeSubSession, "httpts"); err != nil {
+	if config.RecordConfig.EnableVod {
+		// httpflv的url pattern没有覆盖点播的路径时，单独监听
+		vod := config.HttpflvConfig.CommonHttpServerConfig
+		vod.UrlPattern = "/" + config.RecordConfig.VodAppName + "/"
+		if !strings.HasPrefix(vod.UrlPattern, config.HttpflvConfig.UrlPattern) {
+			if err := addMux(vod, sm.httpServerHandler.ServeSubSession, "vod"); err != nil {
+				return err
+			}
+		}
+	}
+	if err := addMux(config.HttptsConfig.CommonHttpServerConfig, sm.httpServerHandler.ServeSubSession, "httpts"); err != nil {
 		return err
 	}
-	a := sm.config.HlsConfig.CommonHttpServerConfig
-	a.Enable = a.Enable || sm.config.HlsConfig.EnableCache
+	a := config.HlsConfig.CommonHttpServerConfig
+	a.Enable = a.Enable || config.HlsConfig.EnableCache
 	if err := addMux(a, sm.serveHls, "hls"); err != nil {
 		return err
 	}
 
+	// webrtc的信令（WHIP、WHEP）使用http，媒体数据使用单独的udp端口
+	if sm.webrtcServer != nil {
+		if err := sm.webrtcServer.Listen(); err != nil {
+			return err
+		}
+		go func() {
+			if err := sm.webrtcServer.RunLoop(); err != nil {
+				Log.Error(err)
+			}
+		}()
+
+		c := config.WebrtcConfig
+		whip := CommonHttpServerConfig{
+			CommonHttpAddrConfig: c.CommonHttpAddrConfig,
+			Enable:               c.Enable,
+			EnableHttps:          c.EnableHttps,
+			UrlPattern:           c.WhipUrlPattern,
+		}
+		if err := addMux(whip, sm.webrtcServer.WhipHandler(whip.UrlPattern), "whip"); err != nil {
+			return err
+		}
+		whep := whip
+		whep.UrlPattern = c.WhepUrlPattern
+		if err := addMux(whep, sm.webrtcServer.WhepHandler(whep.UrlPattern), "whep"); err != nil {
+			return err
+		}
+	}
+
+	// RTSP over HTTP的请求路径和rtsp的相同，可能和httpflv等的url pattern冲突，所以通过header区分
+	if config.RtspConfig.HttpTunnelEnable && sm.rtspServer != nil {
+		if sm.httpServerManager != nil {
+			sm.httpServerManager.AddInterceptor(rtsp.IsHttpTunnelRequest, sm.rtspServer.ServeHttpTunnel)
+			Log.Infof("enable rtsp http tunnel.")
+		} else {
+			Log.Warnf("rtsp http tunnel enabled but no http listen. enable httpflv, httpts or hls first.")
+		}
+	}
+
 	if sm.httpServerManager != nil {
 		go func() {
 			if err := sm.httpServerManager.RunLoop(); err != nil {
@@ -240,7 +366,7 @@ func (sm *ServerManager) RunLoop() error {
 	}
 
 	if sm.rtmpsServer != nil {
-		err := sm.rtmpsServer.ListenWithTLS(sm.config.RtmpConfig.RtmpsCertFile, sm.config.RtmpConfig.RtmpsKeyFile)
+		err := sm.rtmpsServer.ListenWithTLS(config.RtmpConfig.RtmpsCertFile, config.RtmpConfig.RtmpsKeyFile)
 		// rtmps启动失败影响降级：当rtmps启动时我们并不返回错误，保证不因为rtmps影响其他服务
 		if err == nil {
 			go func() {
@@ -263,7 +389,7 @@ func (sm *ServerManager) RunLoop() error {
 	}
 
 	if sm.rtspsServer != nil {
-		err := sm.rtspsServer.ListenWithTLS(sm.config.RtspConfig.RtspsCertFile, sm.config.RtspConfig.RtspsKeyFile)
+		err := sm.rtspsServer.ListenWithTLS(config.RtspConfig.RtspsCertFile, config.RtspConfig.RtspsKeyFile)
 		// rtsps启动失败影响降级：当rtsps启动时我们并不返回错误，保证不因为rtsps影响其他服务
 		if err == nil {
 			go func() {
@@ -274,6 +400,17 @@ func (sm *ServerManager) RunLoop() error {
 		}
 	}
 
+	if sm.srtServer != nil {
+		if err := sm.srtServer.Listen(); err != nil {
+			return err
+		}
+		go func() {
+			if err := sm.srtServer.RunLoop(); err != nil {
+				Log.Error(err)
+			}
+		}()
+	}
+
 	if sm.httpApiServer != nil {
 		if err := sm.httpApiServer.Listen(); err != nil {
 			return err
@@ -285,7 +422,7 @@ func (sm *ServerManager) RunLoop() error {
 		}()
 	}
 
-	uis := uint32(sm.config.HttpNotifyConfig.UpdateIntervalSec)
+	uis := uint32(config.HttpNotifyConfig.UpdateIntervalSec)
 	var updateInfo base.UpdateInfo
 	updateInfo.Groups = sm.StatAllGroup()
 	sm.option.NotifyHandler.OnUpdate(updateInfo)
@@ -313,16 +450,17 @@ func (sm *ServerManager) RunLoop() error {
 			})
 
 			// 定时打印一些group相关的debug日志
-			if sm.config.DebugConfig.LogGroupIntervalSec > 0 &&
-				tickCount%uint32(sm.config.DebugConfig.LogGroupIntervalSec) == 0 {
+			debugConfig := sm.Config().DebugConfig
+			if debugConfig.LogGroupIntervalSec > 0 &&
+				tickCount%uint32(debugConfig.LogGroupIntervalSec) == 0 {
 				groupNum := sm.groupManager.Len()
 				Log.Debugf("DEBUG_GROUP_LOG: group size=%d", groupNum)
-				if sm.config.DebugConfig.LogGroupMaxGroupNum > 0 {
+				if debugConfig.LogGroupMaxGroupNum > 0 {
 					var loggedGroupCount int
 					sm.groupManager.Iterate(func(group *Group) bool {
 						loggedGroupCount++
-						if loggedGroupCount <= sm.config.DebugConfig.LogGroupMaxGroupNum {
-							Log.Debugf("DEBUG_GROUP_LOG: %d %s", loggedGroupCount, group.StringifyDebugStats(sm.config.DebugConfig.LogGroupMaxSubNumPerGroup))
+						if loggedGroupCount <= debugConfig.LogGroupMaxGroupNum {
+							Log.Debugf("DEBUG_GROUP_LOG: %d %s", loggedGroupCount, group.StringifyDebugStats(debugConfig.LogGroupMaxSubNumPerGroup))
 						}
 						return true
 					})
@@ -361,6 +499,14 @@ func (sm *ServerManager) Dispose() {
 		sm.rtspsServer.Dispose()
 	}
 
+	if sm.srtServer != nil {
+		sm.srtServer.Dispose()
+	}
+
+	if sm.webrtcServer != nil {
+		sm.webrtcServer.Dispose()
+	}
+
 	if sm.httpServerManager != nil {
 		sm.httpServerManager.Dispose()
 	}
@@ -418,18 +564,23 @@ func (sm *ServerManager) OnRtmpConnect(session *rtmp.ServerSession, opa rtmp.Obj
 }
 
 func (sm *ServerManager) OnNewRtmpPubSession(session *rtmp.ServerSession) error {
-	sm.mutex.Lock()
-	defer sm.mutex.Unlock()
-
 	info := base.Session2PubStartInfo(session)
 
-	// 先做simple auth鉴权
-	if err := sm.option.Authentication.OnPubStart(info); err != nil {
+	// 鉴权可能是同步的http回调，不能持有锁
+	if err := sm.authPubStart(session, &info); err != nil {
 		return err
 	}
 
-	group := sm.getOrCreateGroup(session.AppName(), session.StreamName())
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	if !sm.checkAuthedSession(session) {
+		return base.ErrAuthSessionDisposed
+	}
+
+	group := sm.getOrCreateGroup(session.AppName(), sm.streamNameOf(session))
 	if err := group.AddRtmpPubSession(session); err != nil {
+		sm.releaseAuthResult(session)
 		return err
 	}
 
@@ -452,13 +603,15 @@ func (sm *ServerManager) OnDelRtmpPubSession(session *rtmp.ServerSession) {
 		Log.Infof("端流后续操作 info=%v", info)
 		sm.option.OnDelRtmpPubSession(info)
 	}
+	streamName := sm.releaseAuthResult(session)
 	if session.DisposeByObserverFlag {
 		return
 	}
-	group := sm.getGroup(session.AppName(), session.StreamName())
+	group := sm.getGroup(session.AppName(), streamName)
 	if group == nil {
 		return
 	}
+	info.StreamName = streamName
 	group.DelRtmpPubSession(session)
 	info.HasInSession = group.HasInSession()
 	info.HasOutSession = group.HasOutSession()
@@ -467,16 +620,29 @@ func (sm *ServerManager) OnDelRtmpPubSession(session *rtmp.ServerSession) {
 }
 
 func (sm *ServerManager) OnNewRtmpSubSession(session *rtmp.ServerSession) error {
+	info := base.Session2SubStartInfo(session)
+
+	// 鉴权可能是同步的http回调，不能持有锁
+	if err := sm.authSubStart(session, &info); err != nil {
+		return err
+	}
+
+	if sm.isVodApp(session.AppName()) {
+		return sm.addVodRtmpSubSession(session, info)
+	}
+
 	sm.mutex.Lock()
 	defer sm.mutex.Unlock()
 
-	info := base.Session2SubStartInfo(session)
+	if !sm.checkAuthedSession(session) {
+		return base.ErrAuthSessionDisposed
+	}
 
-	if err := sm.option.Authentication.OnSubStart(info); err != nil {
+	group := sm.getOrCreateGroup(session.AppName(), sm.streamNameOf(session))
+	if err := sm.checkMaxViewers(session, group); err != nil {
+		sm.releaseAuthResult(session)
 		return err
 	}
-
-	group := sm.getOrCreateGroup(session.AppName(), session.StreamName())
 	group.AddRtmpSubSession(session)
 
 	info.HasInSession = group.HasInSession()
@@ -487,13 +653,18 @@ func (sm *ServerManager) OnNewRtmpSubSession(session *rtmp.ServerSession) error
 }
 
 func (sm *ServerManager) OnDelRtmpSubSession(session *rtmp.ServerSession) {
+	streamName := sm.releaseAuthResult(session)
 	if session.DisposeByObserverFlag {
 		return
 	}
 	sm.mutex.Lock()
 	defer sm.mutex.Unlock()
 
-	group := sm.getGroup(session.AppName(), session.StreamName())
+	if sm.delVodSubSession(session, streamName) {
+		return
+	}
+
+	group := sm.getGroup(session.AppName(), streamName)
 	if group == nil {
 		return
 	}
@@ -501,6 +672,7 @@ func (sm *ServerManager) OnDelRtmpSubSession(session *rtmp.ServerSession) {
 	group.DelRtmpSubSession(session)
 
 	info := base.Session2SubStopInfo(session)
+	info.StreamName = streamName
 	info.HasInSession = group.HasInSession()
 	info.HasOutSession = group.HasOutSession()
 	sm.option.NotifyHandler.OnSubStop(info)
@@ -509,16 +681,29 @@ func (sm *ServerManager) OnDelRtmpSubSession(session *rtmp.ServerSession) {
 // ----- implement IHttpServerHandlerObserver interface -----------------------------------------------------------------
 
 func (sm *ServerManager) OnNewHttpflvSubSession(session *httpflv.SubSession) error {
+	info := base.Session2SubStartInfo(session)
+
+	// 鉴权可能是同步的http回调，不能持有锁
+	if err := sm.authSubStart(session, &info); err != nil {
+		return err
+	}
+
+	if sm.isVodApp(session.AppName()) {
+		return sm.addVodHttpflvSubSession(session, info)
+	}
+
 	sm.mutex.Lock()
 	defer sm.mutex.Unlock()
 
-	info := base.Session2SubStartInfo(session)
+	if !sm.checkAuthedSession(session) {
+		return base.ErrAuthSessionDisposed
+	}
 
-	if err := sm.option.Authentication.OnSubStart(info); err != nil {
+	group := sm.getOrCreateGroup(session.AppName(), sm.streamNameOf(session))
+	if err := sm.checkMaxViewers(session, group); err != nil {
+		sm.releaseAuthResult(session)
 		return err
 	}
-
-	group := sm.getOrCreateGroup(session.AppName(), session.StreamName())
 	group.AddHttpflvSubSession(session)
 
 	info.HasInSession = group.HasInSession()
@@ -532,7 +717,12 @@ func (sm *ServerManager) OnDelHttpflvSubSession(session *httpflv.SubSession) {
 	sm.mutex.Lock()
 	defer sm.mutex.Unlock()
 
-	group := sm.getGroup(session.AppName(), session.StreamName())
+	streamName := sm.releaseAuthResult(session)
+	if sm.delVodSubSession(session, streamName) {
+		return
+	}
+
+	group := sm.getGroup(session.AppName(), streamName)
 	if group == nil {
 		return
 	}
@@ -540,22 +730,32 @@ func (sm *ServerManager) OnDelHttpflvSubSession(session *httpflv.SubSession) {
 	group.DelHttpflvSubSession(session)
 
 	info := base.Session2SubStopInfo(session)
+	info.StreamName = streamName
 	info.HasInSession = group.HasInSession()
 	info.HasOutSession = group.HasOutSession()
 	sm.option.NotifyHandler.OnSubStop(info)
 }
 
 func (sm *ServerManager) OnNewHttptsSubSession(session *httpts.SubSession) error {
+	info := base.Session2SubStartInfo(session)
+
+	// 鉴权可能是同步的http回调，不能持有锁
+	if err := sm.authSubStart(session, &info); err != nil {
+		return err
+	}
+
 	sm.mutex.Lock()
 	defer sm.mutex.Unlock()
 
-	info := base.Session2SubStartInfo(session)
+	if !sm.checkAuthedSession(session) {
+		return base.ErrAuthSessionDisposed
+	}
 
-	if err := sm.option.Authentication.OnSubStart(info); err != nil {
+	group := sm.getOrCreateGroup(session.AppName(), sm.streamNameOf(session))
+	if err := sm.checkMaxViewers(session, group); err != nil {
+		sm.releaseAuthResult(session)
 		return err
 	}
-
-	group := sm.getOrCreateGroup(session.AppName(), session.StreamName())
 	group.AddHttptsSubSession(session)
 
 	info.HasInSession = group.HasInSession()
@@ -570,7 +770,8 @@ func (sm *ServerManager) OnDelHttptsSubSession(session *httpts.SubSession) {
 	sm.mutex.Lock()
 	defer sm.mutex.Unlock()
 
-	group := sm.getGroup(session.AppName(), session.StreamName())
+	streamName := sm.releaseAuthResult(session)
+	group := sm.getGroup(session.AppName(), streamName)
 	if group == nil {
 		return
 	}
@@ -578,6 +779,7 @@ func (sm *ServerManager) OnDelHttptsSubSession(session *httpts.SubSession) {
 	group.DelHttptsSubSession(session)
 
 	info := base.Session2SubStopInfo(session)
+	info.StreamName = streamName
 	info.HasInSession = group.HasInSession()
 	info.HasOutSession = group.HasOutSession()
 	sm.option.NotifyHandler.OnSubStop(info)
@@ -594,17 +796,23 @@ func (sm *ServerManager) OnDelRtspSession(session *rtsp.ServerCommandSession) {
 }
 
 func (sm *ServerManager) OnNewRtspPubSession(session *rtsp.PubSession) error {
-	sm.mutex.Lock()
-	defer sm.mutex.Unlock()
-
 	info := base.Session2PubStartInfo(session)
 
-	if err := sm.option.Authentication.OnPubStart(info); err != nil {
+	// 鉴权可能是同步的http回调，不能持有锁
+	if err := sm.authPubStart(session, &info); err != nil {
 		return err
 	}
 
-	group := sm.getOrCreateGroup(session.AppName(), session.StreamName())
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	if !sm.checkAuthedSession(session) {
+		return base.ErrAuthSessionDisposed
+	}
+
+	group := sm.getOrCreateGroup(session.AppName(), sm.streamNameOf(session))
 	if err := group.AddRtspPubSession(session); err != nil {
+		sm.releaseAuthResult(session)
 		return err
 	}
 
@@ -618,7 +826,8 @@ func (sm *ServerManager) OnNewRtspPubSession(session *rtsp.PubSession) error {
 func (sm *ServerManager) OnDelRtspPubSession(session *rtsp.PubSession) {
 	sm.mutex.Lock()
 	defer sm.mutex.Unlock()
-	group := sm.getGroup(session.AppName(), session.StreamName())
+	streamName := sm.releaseAuthResult(session)
+	group := sm.getGroup(session.AppName(), streamName)
 	if group == nil {
 		return
 	}
@@ -626,22 +835,32 @@ func (sm *ServerManager) OnDelRtspPubSession(session *rtsp.PubSession) {
 	group.DelRtspPubSession(session)
 
 	info := base.Session2PubStopInfo(session)
+	info.StreamName = streamName
 	info.HasInSession = group.HasInSession()
 	info.HasOutSession = group.HasOutSession()
 	sm.option.NotifyHandler.OnPubStop(info)
 }
 
 func (sm *ServerManager) OnNewRtspSubSessionDescribe(session *rtsp.SubSession) (ok bool, sdp []byte) {
+	info := base.Session2SubStartInfo(session)
+
+	// 鉴权可能是同步的http回调，不能持有锁
+	if err := sm.authSubStart(session, &info); err != nil {
+		return false, nil
+	}
+
 	sm.mutex.Lock()
 	defer sm.mutex.Unlock()
 
-	info := base.Session2SubStartInfo(session)
-
-	if err := sm.option.Authentication.OnSubStart(info); err != nil {
+	if !sm.checkAuthedSession(session) {
 		return false, nil
 	}
 
-	group := sm.getOrCreateGroup(session.AppName(), session.StreamName())
+	group := sm.getOrCreateGroup(session.AppName(), sm.streamNameOf(session))
+	if err := sm.checkMaxViewers(session, group); err != nil {
+		sm.releaseAuthResult(session)
+		return false, nil
+	}
 	ok, sdp = group.HandleNewRtspSubSessionDescribe(session)
 	if !ok {
 		return
@@ -658,7 +877,7 @@ func (sm *ServerManager) OnNewRtspSubSessionPlay(session *rtsp.SubSession) error
 	sm.mutex.Lock()
 	defer sm.mutex.Unlock()
 
-	group := sm.getOrCreateGroup(session.AppName(), session.StreamName())
+	group := sm.getOrCreateGroup(session.AppName(), sm.streamNameOf(session))
 	group.HandleNewRtspSubSessionPlay(session)
 	return nil
 }
@@ -666,7 +885,8 @@ func (sm *ServerManager) OnNewRtspSubSessionPlay(session *rtsp.SubSession) error
 func (sm *ServerManager) OnDelRtspSubSession(session *rtsp.SubSession) {
 	sm.mutex.Lock()
 	defer sm.mutex.Unlock()
-	group := sm.getGroup(session.AppName(), session.StreamName())
+	streamName := sm.releaseAuthResult(session)
+	group := sm.getGroup(session.AppName(), streamName)
 	if group == nil {
 		return
 	}
@@ -674,22 +894,36 @@ func (sm *ServerManager) OnDelRtspSubSession(session *rtsp.SubSession) {
 	group.DelRtspSubSession(session)
 
 	info := base.Session2SubStopInfo(session)
+	info.StreamName = streamName
 	info.HasInSession = group.HasInSession()
 	info.HasOutSession = group.HasOutSession()
 	sm.option.NotifyHandler.OnSubStop(info)
 }
 
 func (sm *ServerManager) OnNewHlsSubSession(session *hls.SubSession) error {
+	info := base.Session2SubStartInfo(session)
+
+	// 鉴权可能是同步的http回调，不能持有锁
+	if err := sm.authSubStart(session, &info); err != nil {
+		return err
+	}
+
 	sm.mutex.Lock()
 	defer sm.mutex.Unlock()
 
-	info := base.Session2SubStartInfo(session)
-
-	if err := sm.option.Authentication.OnSubStart(info); err != nil {
+	if !sm.checkAuthedSession(session) {
+		return base.ErrAuthSessionDisposed
+	}
+	if err := sm.checkHlsAuthResult(session); err != nil {
+		sm.releaseAuthResult(session)
 		return err
 	}
 
 	group := sm.getOrCreateGroup(session.AppName(), session.StreamName())
+	if err := sm.checkMaxViewers(session, group); err != nil {
+		sm.releaseAuthResult(session)
+		return err
+	}
 	group.AddHlsSubSession(session)
 
 	info.HasInSession = group.HasInSession()
@@ -704,6 +938,7 @@ func (sm *ServerManager) OnDelHlsSubSession(session *hls.SubSession) {
 	sm.mutex.Lock()
 	defer sm.mutex.Unlock()
 
+	sm.releaseAuthResult(session)
 	group := sm.getGroup(session.AppName(), session.StreamName())
 	if group == nil {
 		return
@@ -717,16 +952,208 @@ func (sm *ServerManager) OnDelHlsSubSession(session *hls.SubSession) {
 	sm.option.NotifyHandler.OnSubStop(info)
 }
 
+// ----- implement srt.IServerObserver interface ------------------------------------------------------------------------
+
+func (sm *ServerManager) OnNewSrtPubSession(session *srt.PubSession) error {
+	info := base.Session2PubStartInfo(session)
+
+	// 鉴权可能是同步的http回调，不能持有锁
+	if err := sm.authPubStart(session, &info); err != nil {
+		return err
+	}
+
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	if !sm.checkAuthedSession(session) {
+		return base.ErrAuthSessionDisposed
+	}
+
+	group := sm.getOrCreateGroup(session.AppName(), sm.streamNameOf(session))
+	if err := group.AddSrtPubSession(session); err != nil {
+		sm.releaseAuthResult(session)
+		return err
+	}
+
+	info.HasInSession = group.HasInSession()
+	info.HasOutSession = group.HasOutSession()
+
+	sm.option.NotifyHandler.OnPubStart(info)
+	return nil
+}
+
+func (sm *ServerManager) OnDelSrtPubSession(session *srt.PubSession) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	streamName := sm.releaseAuthResult(session)
+	group := sm.getGroup(session.AppName(), streamName)
+	if group == nil {
+		return
+	}
+
+	group.DelSrtPubSession(session)
+
+	info := base.Session2PubStopInfo(session)
+	info.StreamName = streamName
+	info.HasInSession = group.HasInSession()
+	info.HasOutSession = group.HasOutSession()
+	sm.option.NotifyHandler.OnPubStop(info)
+}
+
+func (sm *ServerManager) OnNewSrtSubSession(session *srt.SubSession) error {
+	info := base.Session2SubStartInfo(session)
+
+	// 鉴权可能是同步的http回调，不能持有锁
+	if err := sm.authSubStart(session, &info); err != nil {
+		return err
+	}
+
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	if !sm.checkAuthedSession(session) {
+		return base.ErrAuthSessionDisposed
+	}
+
+	group := sm.getOrCreateGroup(session.AppName(), sm.streamNameOf(session))
+	if err := sm.checkMaxViewers(session, group); err != nil {
+		sm.releaseAuthResult(session)
+		return err
+	}
+	group.AddSrtSubSession(session)
+
+	info.HasInSession = group.HasInSession()
+	info.HasOutSession = group.HasOutSession()
+
+	sm.option.NotifyHandler.OnSubStart(info)
+	return nil
+}
+
+func (sm *ServerManager) OnDelSrtSubSession(session *srt.SubSession) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	streamName := sm.releaseAuthResult(session)
+	group := sm.getGroup(session.AppName(), streamName)
+	if group == nil {
+		return
+	}
+
+	group.DelSrtSubSession(session)
+
+	info := base.Session2SubStopInfo(session)
+	info.StreamName = streamName
+	info.HasInSession = group.HasInSession()
+	info.HasOutSession = group.HasOutSession()
+	sm.option.NotifyHandler.OnSubStop(info)
+}
+
+// ----- implement webrtc.IServerObserver interface ---------------------------------------------------------------------
+
+func (sm *ServerManager) OnNewWebrtcPubSession(session *webrtc.PubSession) error {
+	info := base.Session2PubStartInfo(session)
+
+	// 鉴权可能是同步的http回调，不能持有锁
+	if err := sm.authPubStart(session, &info); err != nil {
+		return err
+	}
+
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	if !sm.checkAuthedSession(session) {
+		return base.ErrAuthSessionDisposed
+	}
+
+	group := sm.getOrCreateGroup(session.AppName(), sm.streamNameOf(session))
+	if err := group.AddWebrtcPubSession(session); err != nil {
+		sm.releaseAuthResult(session)
+		return err
+	}
+
+	info.HasInSession = group.HasInSession()
+	info.HasOutSession = group.HasOutSession()
+
+	sm.option.NotifyHandler.OnPubStart(info)
+	return nil
+}
+
+func (sm *ServerManager) OnDelWebrtcPubSession(session *webrtc.PubSession) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	streamName := sm.releaseAuthResult(session)
+	group := sm.getGroup(session.AppName(), streamName)
+	if group == nil {
+		return
+	}
+
+	group.DelWebrtcPubSession(session)
+
+	info := base.Session2PubStopInfo(session)
+	info.StreamName = streamName
+	info.HasInSession = group.HasInSession()
+	info.HasOutSession = group.HasOutSession()
+	sm.option.NotifyHandler.OnPubStop(info)
+}
+
+func (sm *ServerManager) OnNewWebrtcSubSession(session *webrtc.SubSession) error {
+	info := base.Session2SubStartInfo(session)
+
+	// 鉴权可能是同步的http回调，不能持有锁
+	if err := sm.authSubStart(session, &info); err != nil {
+		return err
+	}
+
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	if !sm.checkAuthedSession(session) {
+		return base.ErrAuthSessionDisposed
+	}
+
+	group := sm.getOrCreateGroup(session.AppName(), sm.streamNameOf(session))
+	if err := sm.checkMaxViewers(session, group); err != nil {
+		sm.releaseAuthResult(session)
+		return err
+	}
+	group.AddWebrtcSubSession(session)
+
+	info.HasInSession = group.HasInSession()
+	info.HasOutSession = group.HasOutSession()
+
+	sm.option.NotifyHandler.OnSubStart(info)
+	return nil
+}
+
+func (sm *ServerManager) OnDelWebrtcSubSession(session *webrtc.SubSession) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	streamName := sm.releaseAuthResult(session)
+	group := sm.getGroup(session.AppName(), streamName)
+	if group == nil {
+		return
+	}
+
+	group.DelWebrtcSubSession(session)
+
+	info := base.Session2SubStopInfo(session)
+	info.StreamName = streamName
+	info.HasInSession = group.HasInSession()
+	info.HasOutSession = group.HasOutSession()
+	sm.option.NotifyHandler.OnSubStop(info)
+}
+
 // ----- implement IGroupCreator interface -----------------------------------------------------------------------------
 
 func (sm *ServerManager) CreateGroup(appName string, streamName string) *Group {
 	var config *Config
 	if sm.option.ModConfigGroupCreator != nil {
-		cloneConfig := *sm.config
+		cloneConfig := *sm.Config()
 		sm.option.ModConfigGroupCreator(appName, streamName, &cloneConfig)
 		config = &cloneConfig
 	} else {
-		config = sm.config
+		config = sm.Config()
 	}
 	return NewGroup(appName, streamName, config, sm)
 }
@@ -734,10 +1161,16 @@ func (sm *ServerManager) CreateGroup(appName string, streamName string) *Group {
 // ----- implement IGroupObserver interface -----------------------------------------------------------------------------
 
 func (sm *ServerManager) CleanupHlsIfNeeded(appName string, streamName string, path string) {
-	if sm.config.HlsConfig.Enable &&
-		(sm.config.HlsConfig.CleanupMode == hls.CleanupModeInTheEnd || sm.config.HlsConfig.CleanupMode == hls.CleanupModeAsap) {
+	hlsConfig := sm.Config().HlsConfig
+	if hlsConfig.Enable &&
+		(hlsConfig.CleanupMode == hls.CleanupModeInTheEnd || hlsConfig.CleanupMode == hls.CleanupModeAsap) {
+		delayMs := hlsConfig.FragmentDurationMs * (hlsConfig.FragmentNum + hlsConfig.DeleteThreshold)
+		if hlsConfig.DvrWindowSec > 0 {
+			// 流结束后，DVR窗口内的分片依然可以回看，所以等DVR窗口过去后再清理
+			delayMs += hlsConfig.DvrWindowSec * 1000
+		}
 		defertaskthread.Go(
-			sm.config.HlsConfig.FragmentDurationMs*(sm.config.HlsConfig.FragmentNum+sm.config.HlsConfig.DeleteThreshold),
+			delayMs,
 			func(param ...interface{}) {
 				an := param[0].(string)
 				sn := param[1].(string)
@@ -774,6 +1207,10 @@ func (sm *ServerManager) OnHlsMakeTs(info base.HlsMakeTsInfo) {
 	sm.option.NotifyHandler.OnHlsMakeTs(info)
 }
 
+func (sm *ServerManager) OnRecordFileClosed(info base.RecordFileClosedInfo) {
+	sm.option.NotifyHandler.OnRecordFileClosed(info)
+}
+
 func (sm *ServerManager) BeforeRelayPush(info *base.RepayPushInfo) {
 	if sm.option.BeforeRelayPush != nil {
 		sm.option.BeforeRelayPush(info)
@@ -803,8 +1240,9 @@ func (sm *ServerManager) KickFlvByCond(KickFlvFunc func(streamName string, flvHe
 
 // ---------------------------------------------------------------------------------------------------------------------
 
+// Config 注意，返回的配置不能修改，热加载时会被整体替换
 func (sm *ServerManager) Config() *Config {
-	return sm.config
+	return sm.config.Load()
 }
 
 func (sm *ServerManager) GetGroup(appName string, streamName string) *Group {
@@ -828,6 +1266,214 @@ func (sm *ServerManager) getGroup(appName string, streamName string) *Group {
 	return sm.groupManager.GetGroup(appName, streamName)
 }
 
+func (sm *ServerManager) newAuthenticationByConfig(config *Config) IAuthentication {
+	var a IAuthentication
+	if config.TokenAuthConfig.Enable {
+		a = NewTokenAuthCtx(config.TokenAuthConfig, NewSimpleAuthCtx(config.SimpleAuthConfig), sm.subSessionNum)
+	} else {
+		a = NewSimpleAuthCtx(config.SimpleAuthConfig)
+	}
+	if config.HttpAuthConfig.Enable {
+		a = NewHttpAuthCtx(config.HttpAuthConfig, config.ServerId, a)
+	}
+	return a
+}
+
+// authProviderProxy 将rtmp connect阶段的鉴权转发给当前的 Authentication
+type authProviderProxy struct {
+	sm *ServerManager
+}
+
+func (p *authProviderProxy) provider() (rtmp.IServerAuthProvider, bool) {
+	p.sm.mutex.Lock()
+	defer p.sm.mutex.Unlock()
+	provider, ok := p.sm.option.Authentication.(rtmp.IServerAuthProvider)
+	return provider, ok
+}
+
+func (p *authProviderProxy) AuthMod(appName string) string {
+	if provider, ok := p.provider(); ok {
+		return provider.AuthMod(appName)
+	}
+	return ""
+}
+
+func (p *authProviderProxy) Password(appName string, user string) (string, bool) {
+	if provider, ok := p.provider(); ok {
+		return provider.Password(appName, user)
+	}
+	return "", false
+}
+
+// authentication 热加载会替换 Option.Authentication ，所以加锁获取
+func (sm *ServerManager) authentication() IAuthentication {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	return sm.option.Authentication
+}
+
+// authPubStart 如果 IAuthentication 实现了 IAuthenticationWithResult ，记录鉴权结果，并更新info中的流名称
+//
+// 鉴权可能是同步的http回调，所以调用方不能持有sm.mutex
+// 鉴权成功后，调用方加锁并调用 checkAuthedSession ，确认鉴权期间session没有被删除
+func (sm *ServerManager) authPubStart(session base.ISession, info *base.PubStartInfo) error {
+	sm.beginAuth(session)
+	a := sm.authentication()
+	var err error
+	if ar, ok := a.(IAuthenticationWithResult); ok {
+		var result AuthResult
+		if result, err = ar.OnPubStartWithResult(*info); err == nil {
+			info.StreamName = sm.applyAuthResult(session, result)
+		}
+	} else {
+		err = a.OnPubStart(*info)
+	}
+	if err != nil {
+		sm.checkAuthedSession(session)
+	}
+	return err
+}
+
+// authSubStart 同 authPubStart
+func (sm *ServerManager) authSubStart(session base.ISession, info *base.SubStartInfo) error {
+	sm.beginAuth(session)
+	a := sm.authentication()
+	var err error
+	if ar, ok := a.(IAuthenticationWithResult); ok {
+		var result AuthResult
+		if result, err = ar.OnSubStartWithResult(*info); err == nil {
+			info.StreamName = sm.applyAuthResult(session, result)
+		}
+	} else {
+		err = a.OnSubStart(*info)
+	}
+	if err != nil {
+		sm.checkAuthedSession(session)
+	}
+	return err
+}
+
+// beginAuth 标记session正在鉴权，鉴权期间session结束时(见 releaseAuthResult)，由 checkAuthedSession 发现
+func (sm *ServerManager) beginAuth(session base.ISession) {
+	sm.authResultMutex.Lock()
+	defer sm.authResultMutex.Unlock()
+	sm.authing[session.UniqueKey()] = false
+}
+
+// checkAuthedSession 鉴权结束后调用
+//
+// @return 鉴权期间session已经结束时返回false，并清理鉴权结果
+func (sm *ServerManager) checkAuthedSession(session base.ISession) bool {
+	sm.authResultMutex.Lock()
+	released, ok := sm.authing[session.UniqueKey()]
+	delete(sm.authing, session.UniqueKey())
+	sm.authResultMutex.Unlock()
+	if ok && released {
+		Log.Warnf("[%s] session disposed during authentication.", session.UniqueKey())
+		sm.releaseAuthResult(session)
+		return false
+	}
+	return true
+}
+
+func (sm *ServerManager) applyAuthResult(session base.ISession, result AuthResult) string {
+	if result.StreamName == "" && result.MaxDurationMs <= 0 && result.MaxViewers <= 0 {
+		return session.StreamName()
+	}
+
+	ar := &sessionAuthResult{
+		streamName:    session.StreamName(),
+		maxDurationMs: result.MaxDurationMs,
+		maxViewers:    result.MaxViewers,
+	}
+	if result.StreamName != "" {
+		Log.Infof("[%s] rewrite stream name by authentication. %s -> %s", session.UniqueKey(), ar.streamName, result.StreamName)
+		ar.streamName = result.StreamName
+	}
+	if lifecycle, ok := session.(base.IServerSessionLifecycle); ok && result.MaxDurationMs > 0 {
+		ar.timer = time.AfterFunc(time.Duration(result.MaxDurationMs)*time.Millisecond, func() {
+			Log.Infof("[%s] reach max duration by authentication, dispose it. duration=%dms", session.UniqueKey(), result.MaxDurationMs)
+			_ = lifecycle.Dispose()
+		})
+	}
+
+	sm.authResultMutex.Lock()
+	sm.authResults[session.UniqueKey()] = ar
+	sm.authResultMutex.Unlock()
+	return ar.streamName
+}
+
+// streamNameOf 获取session所属group的流名称，鉴权可能修改了流名称
+func (sm *ServerManager) streamNameOf(session base.ISession) string {
+	sm.authResultMutex.Lock()
+	defer sm.authResultMutex.Unlock()
+	if ar, ok := sm.authResults[session.UniqueKey()]; ok {
+		return ar.streamName
+	}
+	return session.StreamName()
+}
+
+// releaseAuthResult session结束时调用，返回值同 streamNameOf
+func (sm *ServerManager) releaseAuthResult(session base.ISession) string {
+	sm.authResultMutex.Lock()
+	defer sm.authResultMutex.Unlock()
+	if _, ok := sm.authing[session.UniqueKey()]; ok {
+		sm.authing[session.UniqueKey()] = true
+	}
+	ar, ok := sm.authResults[session.UniqueKey()]
+	if !ok {
+		return session.StreamName()
+	}
+	delete(sm.authResults, session.UniqueKey())
+	if ar.timer != nil {
+		ar.timer.Stop()
+	}
+	return ar.streamName
+}
+
+// checkHlsAuthResult hls按url中的流名称读取文件，并且hls session无法被主动关闭，所以鉴权结果修改了流名称或者设置了最长时长时，拒绝该session
+func (sm *ServerManager) checkHlsAuthResult(session *hls.SubSession) error {
+	sm.authResultMutex.Lock()
+	defer sm.authResultMutex.Unlock()
+	ar, ok := sm.authResults[session.UniqueKey()]
+	if !ok {
+		return nil
+	}
+	if ar.streamName != session.StreamName() || ar.maxDurationMs > 0 {
+		Log.Warnf("[%s] auth result not supported by hls. streamName=%s, maxDurationMs=%d", session.UniqueKey(), ar.streamName, ar.maxDurationMs)
+		return base.ErrAuthResultNotSupported
+	}
+	return nil
+}
+
+// checkMaxViewers 鉴权限制了流的最大观看数时，在持有sm.mutex添加sub session之前调用
+//
+// 鉴权时不持有锁，并发的播放请求可能同时通过鉴权，所以这里再检查一次
+func (sm *ServerManager) checkMaxViewers(session base.ISession, group *Group) error {
+	sm.authResultMutex.Lock()
+	ar, ok := sm.authResults[session.UniqueKey()]
+	sm.authResultMutex.Unlock()
+	if !ok || ar.maxViewers <= 0 {
+		return nil
+	}
+	if n := group.SubSessionNum(); n >= ar.maxViewers {
+		Log.Warnf("[%s] max viewers reached. num=%d, max=%d", session.UniqueKey(), n, ar.maxViewers)
+		return base.ErrMaxViewersReached
+	}
+	return nil
+}
+
+// subSessionNum 供鉴权使用，鉴权时不持有sm.mutex，所以内部加锁
+func (sm *ServerManager) subSessionNum(appName string, streamName string) int {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	group := sm.getGroup(appName, streamName)
+	if group == nil {
+		return 0
+	}
+	return group.SubSessionNum()
+}
+
 func (sm *ServerManager) serveHls(writer http.ResponseWriter, req *http.Request) {
 	// 重新parse url
 	u := base.ParseHttpRequest(req)
@@ -847,8 +1493,16 @@ func (sm *ServerManager) serveHls(writer http.ResponseWriter, req *http.Request)
 	}
 	if urlCtx.GetFileType() == "m3u8" {
 		// TODO(chef): [refactor] 需要整理，这里使用 hls.PathStrategy 不太好 202207
-		streamName := hls.PathStrategy.GetRequestInfo(urlCtx, sm.config.HlsConfig.OutPath).StreamName
-		if err = sm.option.Authentication.OnHls(streamName, urlCtx.RawQuery); err != nil {
+		// 热加载时Authentication可能被替换
+		outPath := sm.Config().HlsConfig.OutPath
+		authentication := sm.authentication()
+		streamName := hls.PathStrategy.GetRequestInfo(urlCtx, outPath).StreamName
+		if a, ok := authentication.(IHlsRequestAuthentication); ok {
+			err = a.OnHlsRequest(streamName, urlCtx.RawQuery, req.RemoteAddr, req.Header)
+		} else {
+			err = authentication.OnHls(streamName, urlCtx.RawQuery)
+		}
+		if err != nil {
 			Log.Errorf("simple auth failed. err=%+v", err)
 			return
 		}
@@ -27,7 +27,7 @@ func (sm *ServerManager) StatLalInfo() base.LalInfo {
 	lalInfo.NotifyVersion = base.HttpNotifyVersion
 	lalInfo.WebUiVersion = base.HttpWebUiVersion
 	lalInfo.StartTime = sm.serverStartTime
-	lalInfo.ServerId = sm.config.ServerId
+	lalInfo.ServerId = sm.Config().ServerId
 	return lalInfo
 }
 
@@ -175,6 +175,55 @@ func (sm *ServerManager) CtrlKickSession(info base.ApiCtrlKickSessionReq) (ret b
 	return
 }
 
+// CtrlStartRecord 对正在输入的流开启录制
+func (sm *ServerManager) CtrlStartRecord(info base.ApiCtrlStartRecordReq) (ret base.ApiCtrlStartRecordResp) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	g := sm.getGroup("", info.StreamName)
+	if g == nil {
+		ret.ErrorCode = base.ErrorCodeGroupNotFound
+		ret.Desp = base.DespGroupNotFound
+		return
+	}
+
+	filename, err := g.StartRecord(info)
+	if err != nil {
+		ret.ErrorCode = base.ErrorCodeStartRecordFail
+		ret.Desp = err.Error()
+		return
+	}
+
+	ret.ErrorCode = base.ErrorCodeSucc
+	ret.Desp = base.DespSucc
+	ret.Data.StreamName = info.StreamName
+	ret.Data.Format = info.Format
+	ret.Data.Filename = filename
+	return
+}
+
+func (sm *ServerManager) CtrlStopRecord(info base.ApiCtrlStopRecordReq) (ret base.ApiCtrlStopRecordResp) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	g := sm.getGroup("", info.StreamName)
+	if g == nil {
+		ret.ErrorCode = base.ErrorCodeGroupNotFound
+		ret.Desp = base.DespGroupNotFound
+		return
+	}
+
+	if err := g.StopRecord(info.Format); err != nil {
+		ret.ErrorCode = base.ErrorCodeRecordNotFound
+		ret.Desp = base.DespRecordNotFound
+		return
+	}
+
+	ret.ErrorCode = base.ErrorCodeSucc
+	ret.Desp = base.DespSucc
+	return
+}
+
 func (sm *ServerManager) CtrlStartRtpPub(info base.ApiCtrlStartRtpPubReq) (ret base.ApiCtrlStartRtpPubResp) {
 	sm.mutex.Lock()
 	defer sm.mutex.Unlock()
@@ -0,0 +1,169 @@
+// Copyright 2023, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"io/ioutil"
+	"reflect"
+	"strings"
+
+	"github.com/q191201771/lal/pkg/base"
+)
+
+// server_manager__reload.go
+//
+// 配置热加载，通过http api `/api/ctrl/reload_config` 或SIGHUP信号触发
+//
+// 可热加载的配置项见 reloadableConfigList ，对之后新创建的group生效，另外：
+//   - relay_push对已存在的group也生效，其中聚合相关的配置对之后开始的转推生效
+//   - simple_auth、token_auth、http_auth对之后的鉴权生效(通过 Option.Authentication 传入自定义鉴权时除外)
+//   - http_notify对之后的通知生效(通过 Option.NotifyHandler 传入自定义通知时除外)
+//
+// 其他配置项有变化时(比如监听地址)，只在返回结果中提示需要重启
+
+type reloadableConfig struct {
+	name  string
+	apply func(dst, src *Config)
+}
+
+var reloadableConfigList = []reloadableConfig{
+	{"rtmp.gop_num", func(dst, src *Config) {
+		dst.RtmpConfig.GopNum = src.RtmpConfig.GopNum
+		dst.RtmpConfig.SingleGopMaxFrameNum = src.RtmpConfig.SingleGopMaxFrameNum
+		dst.RtmpConfig.MergeWriteSize = src.RtmpConfig.MergeWriteSize
+	}},
+	{"in_session", func(dst, src *Config) {
+		dst.InSessionConfig = src.InSessionConfig
+	}},
+	{"httpflv.gop_num", func(dst, src *Config) {
+		dst.HttpflvConfig.GopNum = src.HttpflvConfig.GopNum
+		dst.HttpflvConfig.SingleGopMaxFrameNum = src.HttpflvConfig.SingleGopMaxFrameNum
+	}},
+	{"httpts.gop_num", func(dst, src *Config) {
+		dst.HttptsConfig.GopNum = src.HttptsConfig.GopNum
+		dst.HttptsConfig.SingleGopMaxFrameNum = src.HttptsConfig.SingleGopMaxFrameNum
+	}},
+	{"hls.fragment", func(dst, src *Config) {
+		// hls文件的输出目录和http服务绑定，需要重启
+		outPath := dst.HlsConfig.OutPath
+		dst.HlsConfig.MuxerConfig = src.HlsConfig.MuxerConfig
+		dst.HlsConfig.OutPath = outPath
+		dst.HlsConfig.Fmp4Enable = src.HlsConfig.Fmp4Enable
+		dst.HlsConfig.Fmp4StreamNameList = src.HlsConfig.Fmp4StreamNameList
+	}},
+	{"relay_push", func(dst, src *Config) {
+		dst.RelayPushConfig = src.RelayPushConfig
+	}},
+	{"static_relay_pull", func(dst, src *Config) {
+		dst.StaticRelayPullConfig = src.StaticRelayPullConfig
+	}},
+	{"transcode", func(dst, src *Config) {
+		dst.TranscodeConfig = src.TranscodeConfig
+	}},
+	{"http_notify", func(dst, src *Config) {
+		// 定时通知的间隔在启动时确定
+		updateIntervalSec := dst.HttpNotifyConfig.UpdateIntervalSec
+		dst.HttpNotifyConfig = src.HttpNotifyConfig
+		dst.HttpNotifyConfig.UpdateIntervalSec = updateIntervalSec
+	}},
+	{"simple_auth", func(dst, src *Config) {
+		dst.SimpleAuthConfig = src.SimpleAuthConfig
+	}},
+	{"token_auth", func(dst, src *Config) {
+		dst.TokenAuthConfig = src.TokenAuthConfig
+	}},
+	{"http_auth", func(dst, src *Config) {
+		dst.HttpAuthConfig = src.HttpAuthConfig
+	}},
+	{"debug", func(dst, src *Config) {
+		dst.DebugConfig = src.DebugConfig
+	}},
+}
+
+// diffConfig 对比新旧配置
+//
+// @return applied:         在旧配置的基础上，合入了可热加载部分后的配置
+// @return appliedList:     可热加载并且有变化的配置项
+// @return restartRequired: 有变化，但需要重启才能生效的配置项，为json中的一级字段名
+func diffConfig(oldConfig, newConfig *Config) (applied *Config, appliedList []string, restartRequired []string) {
+	c := *oldConfig
+	applied = &c
+	for _, item := range reloadableConfigList {
+		before := *applied
+		item.apply(applied, newConfig)
+		if !reflect.DeepEqual(before, *applied) {
+			appliedList = append(appliedList, item.name)
+		}
+	}
+
+	av := reflect.ValueOf(applied).Elem()
+	nv := reflect.ValueOf(newConfig).Elem()
+	t := av.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if reflect.DeepEqual(av.Field(i).Interface(), nv.Field(i).Interface()) {
+			continue
+		}
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		restartRequired = append(restartRequired, name)
+	}
+	return
+}
+
+// CtrlReloadConfig 重新读取配置文件，并应用其中可热加载的部分
+func (sm *ServerManager) CtrlReloadConfig() (ret base.ApiCtrlReloadConfigResp) {
+	if sm.confFilename == "" {
+		ret.ErrorCode = base.ErrorCodeReloadConfigFail
+		ret.Desp = base.ErrConfigCannotReload.Error()
+		return
+	}
+	rawContent, err := ioutil.ReadFile(sm.confFilename)
+	if err != nil {
+		Log.Errorf("reload config failed. file=%s, err=%+v", sm.confFilename, err)
+		ret.ErrorCode = base.ErrorCodeReloadConfigFail
+		ret.Desp = err.Error()
+		return
+	}
+	newConfig, err := LoadConf(rawContent)
+	if err != nil {
+		Log.Errorf("reload config failed. file=%s, err=%+v", sm.confFilename, err)
+		ret.ErrorCode = base.ErrorCodeReloadConfigFail
+		ret.Desp = err.Error()
+		return
+	}
+
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	oldConfig := sm.Config()
+	applied, appliedList, restartRequired := diffConfig(oldConfig, newConfig)
+	sm.config.Store(applied)
+
+	if !reflect.DeepEqual(oldConfig.RelayPushConfig, applied.RelayPushConfig) {
+		sm.groupManager.Iterate(func(group *Group) bool {
+			group.ReloadRelayPushConfig(oldConfig.RelayPushConfig, applied.RelayPushConfig)
+			return true
+		})
+	}
+	if sm.authFromConfig &&
+		(!reflect.DeepEqual(oldConfig.SimpleAuthConfig, applied.SimpleAuthConfig) ||
+			!reflect.DeepEqual(oldConfig.TokenAuthConfig, applied.TokenAuthConfig) ||
+			!reflect.DeepEqual(oldConfig.HttpAuthConfig, applied.HttpAuthConfig)) {
+		sm.option.Authentication = sm.newAuthenticationByConfig(applied)
+	}
+	if notify, ok := sm.option.NotifyHandler.(*HttpNotify); ok {
+		notify.UpdateConfig(applied.HttpNotifyConfig)
+	}
+
+	Log.Infof("reload config succ. applied=%+v, restart required=%+v", appliedList, restartRequired)
+	ret.ErrorCode = base.ErrorCodeSucc
+	ret.Desp = base.DespSucc
+	ret.Data.Applied = appliedList
+	ret.Data.RestartRequired = restartRequired
+	return
+}
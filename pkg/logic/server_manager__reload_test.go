@@ -0,0 +1,82 @@
+// Copyright 2023, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestDiffConfig(t *testing.T) {
+	rawContent, err := ioutil.ReadFile("../../conf/lalserver.conf.json")
+	assert.Equal(t, nil, err)
+
+	oldConfig, err := LoadConf(rawContent)
+	assert.Equal(t, nil, err)
+	newConfig, err := LoadConf(rawContent)
+	assert.Equal(t, nil, err)
+
+	applied, appliedList, restartRequired := diffConfig(oldConfig, newConfig)
+	assert.Equal(t, 0, len(appliedList))
+	assert.Equal(t, 0, len(restartRequired))
+	assert.Equal(t, *oldConfig, *applied)
+
+	newConfig.RtmpConfig.GopNum = oldConfig.RtmpConfig.GopNum + 1
+	newConfig.RtmpConfig.Addr = ":19350"
+	newConfig.HlsConfig.FragmentNum = oldConfig.HlsConfig.FragmentNum + 1
+	newConfig.HlsConfig.OutPath = "/tmp/lal_reload_test/"
+	newConfig.RelayPushConfig.AddrList = []string{"127.0.0.1:19351"}
+	newConfig.SimpleAuthConfig.Key = "reload"
+	newConfig.HttpNotifyConfig.OnPubStart = "http://127.0.0.1:10101/reload"
+	newConfig.HttpApiConfig.Addr = ":18083"
+
+	applied, appliedList, restartRequired = diffConfig(oldConfig, newConfig)
+	assert.Equal(t, []string{"rtmp.gop_num", "hls.fragment", "relay_push", "http_notify", "simple_auth"}, appliedList)
+	assert.Equal(t, []string{"rtmp", "hls", "http_api"}, restartRequired)
+
+	assert.Equal(t, newConfig.RtmpConfig.GopNum, applied.RtmpConfig.GopNum)
+	assert.Equal(t, oldConfig.RtmpConfig.Addr, applied.RtmpConfig.Addr)
+	assert.Equal(t, newConfig.HlsConfig.FragmentNum, applied.HlsConfig.FragmentNum)
+	assert.Equal(t, oldConfig.HlsConfig.OutPath, applied.HlsConfig.OutPath)
+	assert.Equal(t, newConfig.RelayPushConfig, applied.RelayPushConfig)
+	assert.Equal(t, "reload", applied.SimpleAuthConfig.Key)
+	assert.Equal(t, oldConfig.HttpApiConfig, applied.HttpApiConfig)
+
+	// 旧配置不受影响
+	assert.Equal(t, false, oldConfig.SimpleAuthConfig.Key == "reload")
+	assert.Equal(t, false, oldConfig.RtmpConfig.GopNum == applied.RtmpConfig.GopNum)
+
+	_, err = LoadConf([]byte("{"))
+	assert.IsNotNil(t, err)
+}
+
+func TestReloadRelayPushConfig(t *testing.T) {
+	var config Config
+	config.RelayPushConfig = RelayPushConfig{Enable: true, AddrList: []string{"127.0.0.1:19351"}}
+	group := NewGroup("live", "test110", &config, &mockGroupObserver{})
+	assert.Equal(t, true, group.pushEnable)
+
+	group.ReloadRelayPushConfig(config.RelayPushConfig, RelayPushConfig{Enable: false})
+	assert.Equal(t, false, group.pushEnable)
+	_, ok := group.url2PushProxy.Load("127.0.0.1:19351")
+	assert.Equal(t, false, ok)
+
+	newConfig := config.RelayPushConfig
+	newConfig.AggregateEnable = true
+	newConfig.AggregateMaxDurationMs = 100
+	group.ReloadRelayPushConfig(RelayPushConfig{Enable: false}, newConfig)
+	assert.Equal(t, true, group.pushEnable)
+	_, ok = group.url2PushProxy.Load("127.0.0.1:19351")
+	assert.Equal(t, true, ok)
+	// 聚合配置同样生效，并且不修改共用的旧配置
+	assert.Equal(t, newConfig, group.config.RelayPushConfig)
+	assert.Equal(t, false, config.RelayPushConfig.AggregateEnable)
+}
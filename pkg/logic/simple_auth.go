@@ -61,6 +61,17 @@ func (s *SimpleAuthCtx) OnHls(streamName string, urlParam string) error {
 	return nil
 }
 
+// AuthMod 实现 rtmp.IServerAuthProvider
+func (s *SimpleAuthCtx) AuthMod(appName string) string {
+	return s.config.RtmpPubAuthMod
+}
+
+// Password 实现 rtmp.IServerAuthProvider
+func (s *SimpleAuthCtx) Password(appName string, user string) (string, bool) {
+	password, ok := s.config.RtmpPubAuthUsers[user]
+	return password, ok
+}
+
 func (s *SimpleAuthCtx) check(streamName string, urlParam string) error {
 	q, err := url.ParseQuery(urlParam)
 	if err != nil {
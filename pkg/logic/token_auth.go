@@ -0,0 +1,283 @@
+// Copyright 2023, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"net"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+)
+
+// token_auth.go
+// 基于签名token(JWT，HS256/HS384/HS512)的鉴权
+//
+// token从url参数(默认为token)或header(默认为Authorization，可带"Bearer "前缀)中读取，url参数优先
+// payload中支持的字段见 TokenAuthClaims
+
+const (
+	TokenAuthActionPublish = "publish"
+	TokenAuthActionPlay    = "play"
+)
+
+// TokenAuthClaims token的payload
+type TokenAuthClaims struct {
+	Exp        int64  `json:"exp"`                   // 过期时间，unix时间戳，单位秒，必须
+	Nbf        int64  `json:"nbf,omitempty"`         // 生效时间，unix时间戳，单位秒，可选
+	Action     string `json:"action,omitempty"`      // "publish"或"play"，为空表示不限制
+	Stream     string `json:"stream,omitempty"`      // 流名称，支持 path.Match 的通配符，比如"test*"，为空表示不限制
+	Ip         string `json:"ip,omitempty"`          // 客户端ip，为空表示不限制
+	MaxViewers int    `json:"max_viewers,omitempty"` // 该流的最大观看数，只对play生效，0表示不限制
+}
+
+// TokenAuthSign 使用HS256生成token，供业务方签发token时参考
+func TokenAuthSign(secret string, claims TokenAuthClaims) (string, error) {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+	signingInput := header + "." + base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(signingInput))
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// IHlsRequestAuthentication
+//
+// 可选接口，如果 IAuthentication 的实现同时实现了该接口，hls的m3u8请求使用该接口鉴权，而不是 IAuthentication.OnHls
+type IHlsRequestAuthentication interface {
+	OnHlsRequest(streamName, urlParam, remoteAddr string, header map[string][]string) error
+}
+
+// TokenAuthCtx
+//
+// 先执行 SimpleAuthCtx 的鉴权，再执行token鉴权
+type TokenAuthCtx struct {
+	config TokenAuthConfig
+	simple *SimpleAuthCtx
+
+	// 获取流当前的观看数，只用于提前拒绝，注意，实现方需要自行加锁。
+	// 鉴权期间可能有其他播放者加入，所以 ServerManager 在加锁添加session时会按 AuthResult.MaxViewers 再检查一次
+	subSessionNum func(appName, streamName string) int
+
+	now func() time.Time
+}
+
+func NewTokenAuthCtx(config TokenAuthConfig, simple *SimpleAuthCtx, subSessionNum func(appName, streamName string) int) *TokenAuthCtx {
+	return &TokenAuthCtx{
+		config:        config,
+		simple:        simple,
+		subSessionNum: subSessionNum,
+		now:           time.Now,
+	}
+}
+
+func (t *TokenAuthCtx) OnPubStart(info base.PubStartInfo) error {
+	_, err := t.OnPubStartWithResult(info)
+	return err
+}
+
+func (t *TokenAuthCtx) OnSubStart(info base.SubStartInfo) error {
+	_, err := t.OnSubStartWithResult(info)
+	return err
+}
+
+// OnPubStartWithResult 实现 IAuthenticationWithResult
+func (t *TokenAuthCtx) OnPubStartWithResult(info base.PubStartInfo) (AuthResult, error) {
+	if err := t.simple.OnPubStart(info); err != nil {
+		return AuthResult{}, err
+	}
+	if !t.config.PubEnable {
+		return AuthResult{}, nil
+	}
+	_, err := t.check(TokenAuthActionPublish, info.StreamName, info.UrlParam, info.RemoteAddr, info.Header)
+	return AuthResult{}, err
+}
+
+// OnSubStartWithResult 实现 IAuthenticationWithResult ，token中的max_viewers通过 AuthResult.MaxViewers 返回
+func (t *TokenAuthCtx) OnSubStartWithResult(info base.SubStartInfo) (AuthResult, error) {
+	if err := t.simple.OnSubStart(info); err != nil {
+		return AuthResult{}, err
+	}
+	if !t.config.SubEnable {
+		return AuthResult{}, nil
+	}
+	claims, err := t.check(TokenAuthActionPlay, info.StreamName, info.UrlParam, info.RemoteAddr, info.Header)
+	if err != nil {
+		return AuthResult{}, err
+	}
+	if claims.MaxViewers > 0 && t.subSessionNum != nil {
+		if n := t.subSessionNum(info.AppName, info.StreamName); n >= claims.MaxViewers {
+			return AuthResult{}, fmt.Errorf("%w. max viewers reached, num=%d, max=%d", base.ErrTokenAuthFailed, n, claims.MaxViewers)
+		}
+	}
+	return AuthResult{MaxViewers: claims.MaxViewers}, nil
+}
+
+// OnHls 没有客户端地址和header，只能从url参数中读取token，并且token中的ip不为空时鉴权失败
+func (t *TokenAuthCtx) OnHls(streamName string, urlParam string) error {
+	return t.OnHlsRequest(streamName, urlParam, "", nil)
+}
+
+// OnHlsRequest 实现 IHlsRequestAuthentication
+//
+// 注意，hls无法统计观看数，所以不检查max_viewers
+func (t *TokenAuthCtx) OnHlsRequest(streamName, urlParam, remoteAddr string, header map[string][]string) error {
+	if err := t.simple.OnHls(streamName, urlParam); err != nil {
+		return err
+	}
+	if !t.config.HlsEnable {
+		return nil
+	}
+	_, err := t.check(TokenAuthActionPlay, streamName, urlParam, remoteAddr, header)
+	return err
+}
+
+// AuthMod 实现 rtmp.IServerAuthProvider
+func (t *TokenAuthCtx) AuthMod(appName string) string {
+	return t.simple.AuthMod(appName)
+}
+
+// Password 实现 rtmp.IServerAuthProvider
+func (t *TokenAuthCtx) Password(appName string, user string) (string, bool) {
+	return t.simple.Password(appName, user)
+}
+
+func (t *TokenAuthCtx) check(action, streamName, urlParam, remoteAddr string, header map[string][]string) (claims TokenAuthClaims, err error) {
+	token := t.readToken(urlParam, header)
+	if token == "" {
+		return claims, base.ErrTokenAuthParamNotFound
+	}
+	claims, err = t.verify(token)
+	if err != nil {
+		Log.Warnf("[%p] TokenAuthCtx::check failed. streamName=%s, remoteAddr=%s, err=%+v", t, streamName, remoteAddr, err)
+		return claims, err
+	}
+
+	if claims.Action != "" && claims.Action != action {
+		return claims, fmt.Errorf("%w. action mismatch, expected=%s, actual=%s", base.ErrTokenAuthFailed, claims.Action, action)
+	}
+	if claims.Stream != "" {
+		if ok, _ := path.Match(claims.Stream, streamName); !ok {
+			return claims, fmt.Errorf("%w. stream mismatch, pattern=%s, streamName=%s", base.ErrTokenAuthFailed, claims.Stream, streamName)
+		}
+	}
+	if claims.Ip != "" {
+		host, _, splitErr := net.SplitHostPort(remoteAddr)
+		if splitErr != nil {
+			host = remoteAddr
+		}
+		if host != claims.Ip {
+			return claims, fmt.Errorf("%w. ip mismatch, expected=%s, remoteAddr=%s", base.ErrTokenAuthFailed, claims.Ip, remoteAddr)
+		}
+	}
+	return claims, nil
+}
+
+func (t *TokenAuthCtx) readToken(urlParam string, header map[string][]string) string {
+	if q, err := url.ParseQuery(urlParam); err == nil {
+		if v := q.Get(t.config.ParamName); v != "" {
+			return v
+		}
+	}
+	if v := readHeader(header, t.config.HeaderName); v != "" {
+		if len(v) > 7 && strings.EqualFold(v[:7], "Bearer ") {
+			v = v[7:]
+		}
+		return strings.TrimSpace(v)
+	}
+	return ""
+}
+
+func (t *TokenAuthCtx) verify(token string) (claims TokenAuthClaims, err error) {
+	if t.config.Secret == "" {
+		// 密钥为空时任何人都可以签发token，所以直接拒绝
+		return claims, fmt.Errorf("%w. secret is empty", base.ErrTokenAuthFailed)
+	}
+
+	items := strings.Split(token, ".")
+	if len(items) != 3 {
+		return claims, fmt.Errorf("%w. invalid format", base.ErrTokenAuthFailed)
+	}
+
+	headerBytes, err := base64.RawURLEncoding.DecodeString(items[0])
+	if err != nil {
+		return claims, fmt.Errorf("%w. decode header failed", base.ErrTokenAuthFailed)
+	}
+	var jwtHeader struct {
+		Alg string `json:"alg"`
+	}
+	if err = json.Unmarshal(headerBytes, &jwtHeader); err != nil {
+		return claims, fmt.Errorf("%w. unmarshal header failed", base.ErrTokenAuthFailed)
+	}
+	var fn func() hash.Hash
+	switch jwtHeader.Alg {
+	case "HS256":
+		fn = sha256.New
+	case "HS384":
+		fn = sha512.New384
+	case "HS512":
+		fn = sha512.New
+	default:
+		return claims, fmt.Errorf("%w. unsupported alg=%s", base.ErrTokenAuthFailed, jwtHeader.Alg)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(items[2])
+	if err != nil {
+		return claims, fmt.Errorf("%w. decode signature failed", base.ErrTokenAuthFailed)
+	}
+	mac := hmac.New(fn, []byte(t.config.Secret))
+	mac.Write([]byte(items[0] + "." + items[1]))
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return claims, fmt.Errorf("%w. signature mismatch", base.ErrTokenAuthFailed)
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(items[1])
+	if err != nil {
+		return claims, fmt.Errorf("%w. decode payload failed", base.ErrTokenAuthFailed)
+	}
+	if err = json.Unmarshal(payload, &claims); err != nil {
+		return claims, fmt.Errorf("%w. unmarshal payload failed", base.ErrTokenAuthFailed)
+	}
+
+	now := t.now().Unix()
+	if claims.Exp == 0 || now >= claims.Exp {
+		return claims, fmt.Errorf("%w. expired, exp=%d, now=%d", base.ErrTokenAuthFailed, claims.Exp, now)
+	}
+	if claims.Nbf != 0 && now < claims.Nbf {
+		return claims, fmt.Errorf("%w. not valid yet, nbf=%d, now=%d", base.ErrTokenAuthFailed, claims.Nbf, now)
+	}
+	return claims, nil
+}
+
+// readHeader header的key不区分大小写
+func readHeader(header map[string][]string, key string) string {
+	if key == "" {
+		return ""
+	}
+	for k, v := range header {
+		if strings.EqualFold(k, key) && len(v) > 0 {
+			return v[0]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,117 @@
+// Copyright 2023, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestTokenAuthCtx(t *testing.T) {
+	viewers := 0
+	ctx := NewTokenAuthCtx(TokenAuthConfig{
+		Enable:     true,
+		Secret:     "q191201771",
+		ParamName:  "token",
+		HeaderName: "Authorization",
+		PubEnable:  true,
+		SubEnable:  true,
+		HlsEnable:  true,
+	}, NewSimpleAuthCtx(SimpleAuthConfig{}), func(appName, streamName string) int {
+		return viewers
+	})
+	now := time.Unix(1700000000, 0)
+	ctx.now = func() time.Time {
+		return now
+	}
+
+	sign := func(secret string, claims TokenAuthClaims) string {
+		token, err := TokenAuthSign(secret, claims)
+		assert.Equal(t, nil, err)
+		return token
+	}
+	pubToken := sign("q191201771", TokenAuthClaims{
+		Exp:    now.Unix() + 60,
+		Action: TokenAuthActionPublish,
+		Stream: "test*",
+		Ip:     "127.0.0.1",
+	})
+
+	var pubInfo base.PubStartInfo
+	pubInfo.Protocol = base.SessionProtocolRtmpStr
+	pubInfo.StreamName = "test110"
+	pubInfo.RemoteAddr = "127.0.0.1:51234"
+	pubInfo.UrlParam = "token=" + pubToken
+	assert.Equal(t, nil, ctx.OnPubStart(pubInfo))
+
+	// token从header中读取
+	pubInfo.UrlParam = ""
+	pubInfo.Header = map[string][]string{"Authorization": {"Bearer " + pubToken}}
+	assert.Equal(t, nil, ctx.OnPubStart(pubInfo))
+
+	pubInfo.Header = nil
+	assert.Equal(t, base.ErrTokenAuthParamNotFound, ctx.OnPubStart(pubInfo))
+
+	// ip不匹配
+	pubInfo.UrlParam = "token=" + pubToken
+	pubInfo.RemoteAddr = "127.0.0.2:51234"
+	assert.Equal(t, true, errors.Is(ctx.OnPubStart(pubInfo), base.ErrTokenAuthFailed))
+	pubInfo.RemoteAddr = "127.0.0.1:51234"
+
+	// 流名称不匹配
+	pubInfo.StreamName = "demo110"
+	assert.Equal(t, true, errors.Is(ctx.OnPubStart(pubInfo), base.ErrTokenAuthFailed))
+	pubInfo.StreamName = "test110"
+
+	// 签名错误
+	pubInfo.UrlParam = "token=" + sign("wrong", TokenAuthClaims{Exp: now.Unix() + 60})
+	assert.Equal(t, true, errors.Is(ctx.OnPubStart(pubInfo), base.ErrTokenAuthFailed))
+
+	// 过期和未生效
+	pubInfo.UrlParam = "token=" + sign("q191201771", TokenAuthClaims{Exp: now.Unix()})
+	assert.Equal(t, true, errors.Is(ctx.OnPubStart(pubInfo), base.ErrTokenAuthFailed))
+	pubInfo.UrlParam = "token=" + sign("q191201771", TokenAuthClaims{Exp: now.Unix() + 60, Nbf: now.Unix() + 10})
+	assert.Equal(t, true, errors.Is(ctx.OnPubStart(pubInfo), base.ErrTokenAuthFailed))
+
+	// publish的token不能用于play
+	var subInfo base.SubStartInfo
+	subInfo.Protocol = base.SessionProtocolFlvStr
+	subInfo.StreamName = "test110"
+	subInfo.RemoteAddr = "127.0.0.1:51234"
+	subInfo.UrlParam = "token=" + pubToken
+	assert.Equal(t, true, errors.Is(ctx.OnSubStart(subInfo), base.ErrTokenAuthFailed))
+
+	playToken := sign("q191201771", TokenAuthClaims{
+		Exp:        now.Unix() + 60,
+		Action:     TokenAuthActionPlay,
+		MaxViewers: 2,
+	})
+	subInfo.UrlParam = "token=" + playToken
+	viewers = 1
+	result, err := ctx.OnSubStartWithResult(subInfo)
+	assert.Equal(t, nil, err)
+	// ServerManager 加锁添加session时会再次检查
+	assert.Equal(t, 2, result.MaxViewers)
+	viewers = 2
+	assert.Equal(t, true, errors.Is(ctx.OnSubStart(subInfo), base.ErrTokenAuthFailed))
+
+	assert.Equal(t, nil, ctx.OnHlsRequest("test110", "token="+playToken, "127.0.0.1:51234", nil))
+	assert.Equal(t, true, errors.Is(ctx.OnHls("test110", "token="+pubToken), base.ErrTokenAuthFailed))
+
+	// 密钥为空时，即使签名匹配也拒绝
+	ctx.config.Secret = ""
+	subInfo.UrlParam = "token=" + sign("", TokenAuthClaims{Exp: now.Unix() + 60})
+	assert.Equal(t, true, errors.Is(ctx.OnSubStart(subInfo), base.ErrTokenAuthFailed))
+	_, err = LoadConf([]byte(`{"token_auth": {"enable": true, "secret": ""}}`))
+	assert.Equal(t, true, errors.Is(err, base.ErrConfigInvalid))
+}
@@ -0,0 +1,316 @@
+// Copyright 2023, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"bytes"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+)
+
+// 转码
+//
+// lal本身不做编解码。开启 TranscodeConfig 后，group有输入流时，会为每个 TranscodeProfile 启动一个外部进程（比如ffmpeg），
+// 由外部进程从本服务拉流，转码后再推回本服务，生成名为 <stream><out_stream_suffix> 的新流。
+// 外部进程的生命周期跟随group的输入流，进程异常退出时按退避间隔重启，进程的标准输出和标准错误会打印到日志中。
+//
+// command中支持的变量：{app} {stream} {out_stream} {rtmp_addr}
+// 比如：
+//
+// ffmpeg -i rtmp://{rtmp_addr}/{app}/{stream} -c:v libx264 -s 1280x720 -c:a copy -f flv rtmp://{rtmp_addr}/{app}/{out_stream}
+//
+// 注意，command先按空白字符切分为参数，再替换每个参数中的变量，不经过shell，所以不支持引号，管道等shell语法
+
+// ITranscodeProcess 外部转码进程
+type ITranscodeProcess interface {
+	Start() error
+	// Wait 阻塞直到进程退出
+	Wait() error
+	Kill() error
+	Pid() int
+}
+
+// newTranscodeProcess 测试时可以替换为假的进程
+var newTranscodeProcess = func(args []string, stdout, stderr io.Writer) ITranscodeProcess {
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	return &execTranscodeProcess{cmd: cmd}
+}
+
+type execTranscodeProcess struct {
+	cmd *exec.Cmd
+}
+
+func (p *execTranscodeProcess) Start() error {
+	return p.cmd.Start()
+}
+
+func (p *execTranscodeProcess) Wait() error {
+	return p.cmd.Wait()
+}
+
+func (p *execTranscodeProcess) Kill() error {
+	if p.cmd.Process == nil {
+		return nil
+	}
+	return p.cmd.Process.Kill()
+}
+
+func (p *execTranscodeProcess) Pid() int {
+	if p.cmd.Process == nil {
+		return 0
+	}
+	return p.cmd.Process.Pid
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+type transcoder struct {
+	uk            string
+	profile       TranscodeProfile
+	args          []string // 替换变量后的命令参数
+	command       string   // args拼接后的命令，用于日志和统计
+	outStreamName string
+
+	restartInterval    time.Duration
+	maxRestartInterval time.Duration
+
+	mutex        sync.Mutex
+	proc         ITranscodeProcess
+	startTime    string
+	restartCount int
+	lastError    string
+	disposed     bool
+	doneChan     chan struct{}
+}
+
+func newTranscoder(appName, streamName, rtmpAddr string, profile TranscodeProfile, config TranscodeConfig) *transcoder {
+	outStreamName := streamName + profile.OutStreamSuffix
+	replacer := strings.NewReplacer(
+		"{app}", appName,
+		"{stream}", streamName,
+		"{out_stream}", outStreamName,
+		"{rtmp_addr}", rtmpAddr,
+	)
+	// 先切分再替换，流名称中包含空白字符时也不会改变参数的个数
+	args := strings.Fields(profile.Command)
+	for i := range args {
+		args[i] = replacer.Replace(args[i])
+	}
+
+	return &transcoder{
+		uk:                 base.GenUkTranscoder(),
+		profile:            profile,
+		args:               args,
+		command:            strings.Join(args, " "),
+		outStreamName:      outStreamName,
+		restartInterval:    time.Duration(config.RestartIntervalMs) * time.Millisecond,
+		maxRestartInterval: time.Duration(config.MaxRestartIntervalMs) * time.Millisecond,
+		startTime:          base.ReadableNowTime(),
+		doneChan:           make(chan struct{}),
+	}
+}
+
+func (t *transcoder) Start() {
+	Log.Infof("[%s] lifecycle new transcoder. profile=%s, command=%s", t.uk, t.profile.Name, t.command)
+	go t.runLoop()
+}
+
+func (t *transcoder) Dispose() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.disposed {
+		return
+	}
+	Log.Infof("[%s] lifecycle dispose transcoder.", t.uk)
+	t.disposed = true
+	close(t.doneChan)
+	if t.proc != nil {
+		_ = t.proc.Kill()
+	}
+}
+
+func (t *transcoder) GetStat() base.StatTranscode {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	stat := base.StatTranscode{
+		Profile:       t.profile.Name,
+		OutStreamName: t.outStreamName,
+		Command:       t.command,
+		StartTime:     t.startTime,
+		RestartCount:  t.restartCount,
+		LastError:     t.lastError,
+	}
+	if t.proc != nil {
+		stat.Pid = t.proc.Pid()
+	}
+	return stat
+}
+
+func (t *transcoder) runLoop() {
+	if len(t.args) == 0 {
+		return
+	}
+
+	interval := t.restartInterval
+	for {
+		begin := time.Now()
+		proc := newTranscodeProcess(t.args, newTranscodeLogWriter(t.uk, "stdout"), newTranscodeLogWriter(t.uk, "stderr"))
+
+		// 注意，持有锁启动进程，避免和Dispose并发时进程启动后没有被kill
+		t.mutex.Lock()
+		if t.disposed {
+			t.mutex.Unlock()
+			return
+		}
+		err := proc.Start()
+		if err == nil {
+			t.proc = proc
+		}
+		t.mutex.Unlock()
+
+		if err == nil {
+			Log.Infof("[%s] transcode process started. pid=%d", t.uk, proc.Pid())
+			err = proc.Wait()
+		}
+
+		t.mutex.Lock()
+		t.proc = nil
+		if err != nil {
+			t.lastError = err.Error()
+		} else {
+			t.lastError = "exit"
+		}
+		disposed := t.disposed
+		t.mutex.Unlock()
+		if disposed {
+			return
+		}
+
+		// 进程运行了足够长的时间，认为之前是正常运行的，重置重启间隔
+		if time.Since(begin) > t.maxRestartInterval {
+			interval = t.restartInterval
+		}
+		Log.Warnf("[%s] transcode process exited, restart after %v. err=%v", t.uk, interval, err)
+		select {
+		case <-t.doneChan:
+			return
+		case <-time.After(interval):
+		}
+		if interval *= 2; interval > t.maxRestartInterval {
+			interval = t.maxRestartInterval
+		}
+
+		t.mutex.Lock()
+		t.restartCount++
+		t.startTime = base.ReadableNowTime()
+		t.mutex.Unlock()
+	}
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// transcodeLogWriter 将外部进程的输出按行打印到日志中
+type transcodeLogWriter struct {
+	uk   string
+	name string
+	buf  []byte
+}
+
+const transcodeLogLineMaxLen = 4096
+
+func newTranscodeLogWriter(uk, name string) *transcodeLogWriter {
+	return &transcodeLogWriter{
+		uk:   uk,
+		name: name,
+	}
+}
+
+func (w *transcodeLogWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		// ffmpeg的进度信息使用`\r`换行
+		i := bytes.IndexAny(w.buf, "\r\n")
+		if i == -1 {
+			break
+		}
+		if i > 0 {
+			Log.Infof("[%s] [%s] %s", w.uk, w.name, w.buf[:i])
+		}
+		w.buf = w.buf[i+1:]
+	}
+	if len(w.buf) > transcodeLogLineMaxLen {
+		Log.Infof("[%s] [%s] %s", w.uk, w.name, w.buf)
+		w.buf = nil
+	}
+	return len(p), nil
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func (group *Group) startTranscodeIfNeeded() {
+	c := group.config.TranscodeConfig
+	if !c.Enable || group.isTranscodeOutStream() {
+		return
+	}
+
+	rtmpAddr := group.config.RtmpConfig.Addr
+	if strings.HasPrefix(rtmpAddr, ":") {
+		rtmpAddr = "127.0.0.1" + rtmpAddr
+	}
+	for _, profile := range c.Profiles {
+		// 配置不是从文件加载时，没有经过 fillConfDefault 的检查
+		if !isValidTranscodeProfile(profile) {
+			continue
+		}
+		t := newTranscoder(group.appName, group.streamName, rtmpAddr, profile, c)
+		t.Start()
+		group.transcoders = append(group.transcoders, t)
+	}
+}
+
+func (group *Group) stopTranscodeIfNeeded() {
+	for _, t := range group.transcoders {
+		t.Dispose()
+	}
+	group.transcoders = nil
+}
+
+// isTranscodeOutStream 转码后推回来的流不再转码
+func (group *Group) isTranscodeOutStream() bool {
+	for _, profile := range group.config.TranscodeConfig.Profiles {
+		if isValidTranscodeProfile(profile) && strings.HasSuffix(group.streamName, profile.OutStreamSuffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// isValidTranscodeProfile 后缀为空时，转码后的流和输入流同名，
+// 后缀包含空白字符或`/`时，转码后推回来的流名称无法和输入流对应
+func isValidTranscodeProfile(profile TranscodeProfile) bool {
+	if profile.OutStreamSuffix == "" || strings.TrimSpace(profile.Command) == "" {
+		return false
+	}
+	return !strings.ContainsAny(profile.OutStreamSuffix, " \t\r\n/")
+}
+
+func (group *Group) getStatTranscodes() []base.StatTranscode {
+	var out []base.StatTranscode
+	for _, t := range group.transcoders {
+		out = append(out, t.GetStat())
+	}
+	return out
+}
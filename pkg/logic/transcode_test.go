@@ -0,0 +1,136 @@
+// Copyright 2023, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+type fakeTranscodeProcess struct {
+	args     []string
+	stderr   io.Writer
+	exitChan chan error
+}
+
+func (p *fakeTranscodeProcess) Start() error {
+	_, _ = p.stderr.Write([]byte("frame=1 fps=25\rframe=2 fps=25\n"))
+	return nil
+}
+
+func (p *fakeTranscodeProcess) Wait() error {
+	return <-p.exitChan
+}
+
+func (p *fakeTranscodeProcess) Kill() error {
+	select {
+	case p.exitChan <- errors.New("killed"):
+	default:
+	}
+	return nil
+}
+
+func (p *fakeTranscodeProcess) Pid() int {
+	return 1000
+}
+
+func TestTranscode(t *testing.T) {
+	var (
+		mutex sync.Mutex
+		procs []*fakeTranscodeProcess
+	)
+	oldNewTranscodeProcess := newTranscodeProcess
+	defer func() { newTranscodeProcess = oldNewTranscodeProcess }()
+	newTranscodeProcess = func(args []string, stdout, stderr io.Writer) ITranscodeProcess {
+		mutex.Lock()
+		defer mutex.Unlock()
+		p := &fakeTranscodeProcess{args: args, stderr: stderr, exitChan: make(chan error, 1)}
+		procs = append(procs, p)
+		return p
+	}
+	getProcs := func() []*fakeTranscodeProcess {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return append([]*fakeTranscodeProcess(nil), procs...)
+	}
+
+	var config Config
+	config.RtmpConfig.Addr = ":1935"
+	config.TranscodeConfig = TranscodeConfig{
+		Enable:               true,
+		RestartIntervalMs:    10,
+		MaxRestartIntervalMs: 1000,
+		Profiles: []TranscodeProfile{
+			{Name: "720p", OutStreamSuffix: "_720p", Command: "ffmpeg -i rtmp://{rtmp_addr}/{app}/{stream} -f flv rtmp://{rtmp_addr}/{app}/{out_stream}"},
+		},
+	}
+
+	// 转码输出的流不再转码
+	group := NewGroup("live", "test110_720p", &config, &mockGroupObserver{})
+	group.startTranscodeIfNeeded()
+	assert.Equal(t, 0, len(group.transcoders))
+
+	group = NewGroup("live", "test110", &config, &mockGroupObserver{})
+	group.startTranscodeIfNeeded()
+	assert.Equal(t, 1, len(group.transcoders))
+	time.Sleep(20 * time.Millisecond)
+	ps := getProcs()
+	assert.Equal(t, 1, len(ps))
+	assert.Equal(t, []string{"ffmpeg", "-i", "rtmp://127.0.0.1:1935/live/test110", "-f", "flv", "rtmp://127.0.0.1:1935/live/test110_720p"}, ps[0].args)
+
+	stats := group.getStatTranscodes()
+	assert.Equal(t, 1, len(stats))
+	assert.Equal(t, "720p", stats[0].Profile)
+	assert.Equal(t, "test110_720p", stats[0].OutStreamName)
+	assert.Equal(t, 1000, stats[0].Pid)
+	assert.Equal(t, 0, stats[0].RestartCount)
+
+	// 进程退出后按退避间隔重启
+	ps[0].exitChan <- errors.New("exit status 1")
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 2, len(getProcs()))
+	stats = group.getStatTranscodes()
+	assert.Equal(t, 1, stats[0].RestartCount)
+	assert.Equal(t, "exit status 1", stats[0].LastError)
+
+	getProcs()[1].exitChan <- errors.New("exit status 1")
+	time.Sleep(10 * time.Millisecond)
+	// 第二次重启的间隔为20毫秒，此时还没有重启
+	assert.Equal(t, 0, group.getStatTranscodes()[0].Pid)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 3, len(getProcs()))
+
+	// 输入流结束后，进程被kill，并且不再重启
+	group.stopTranscodeIfNeeded()
+	assert.Equal(t, 0, len(group.transcoders))
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 3, len(getProcs()))
+}
+
+func TestNewTranscoder(t *testing.T) {
+	profile := TranscodeProfile{Name: "720p", OutStreamSuffix: "_720p", Command: "ffmpeg -i rtmp://{rtmp_addr}/{app}/{stream} -f flv rtmp://{rtmp_addr}/{app}/{out_stream}"}
+
+	// 流名称中的空白字符不会导致参数错位
+	tr := newTranscoder("live", "a -y b", "127.0.0.1:1935", profile, TranscodeConfig{})
+	assert.Equal(t, []string{"ffmpeg", "-i", "rtmp://127.0.0.1:1935/live/a -y b", "-f", "flv", "rtmp://127.0.0.1:1935/live/a -y b_720p"}, tr.args)
+
+	// 后缀非法的配置被忽略
+	config, err := LoadConf([]byte(`{"transcode": {"enable": true, "profiles": [
+		{"name": "a", "out_stream_suffix": "", "command": "ffmpeg"},
+		{"name": "b", "out_stream_suffix": "_b c", "command": "ffmpeg"},
+		{"name": "c", "out_stream_suffix": "_c", "command": "ffmpeg"}]}}`))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(config.TranscodeConfig.Profiles))
+	assert.Equal(t, "c", config.TranscodeConfig.Profiles[0].Name)
+}
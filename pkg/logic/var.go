@@ -16,6 +16,10 @@ var (
 	relayPushTimeoutMs        = 5000
 	relayPushWriteAvTimeoutMs = 5000
 
+	// relayPushAggregateMaxSize 转推时合并的aggregate message包体的最大大小
+	//
+	relayPushAggregateMaxSize = 64 * 1024
+
 	// calcSessionStatIntervalSec 计算所有session收发码率的时间间隔
 	//
 	calcSessionStatIntervalSec uint32 = 5
@@ -0,0 +1,364 @@
+// Copyright 2023, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/httpflv"
+	"github.com/q191201771/lal/pkg/remux"
+	"github.com/q191201771/lal/pkg/rtmp"
+)
+
+// 点播
+//
+// 开启 RecordConfig.EnableVod 后，appName为 RecordConfig.VodAppName 的拉流请求不再进入group，
+// 而是读取 RecordConfig.FlvOutPath 目录下的flv录制文件，并按实时速度发送给拉流端：
+//
+// http://127.0.0.1:8080/vod/test110-1690000000.flv?start=30 -> 从第30秒附近的关键帧开始播放
+// rtmp://127.0.0.1/vod/test110-1690000000                   -> 支持播放器发送的seek，pause信令
+
+type vodCtrlType int
+
+const (
+	vodCtrlSeek vodCtrlType = iota + 1
+	vodCtrlPause
+	vodCtrlResume
+)
+
+type vodCtrl struct {
+	t  vodCtrlType
+	ms int
+}
+
+// vodPlayer 按tag的时间戳间隔，将flv文件中的tag发送给拉流端，支持seek和pause
+type vodPlayer struct {
+	uk        string
+	file      *httpflv.FlvVodFile
+	onTag     func(tag *httpflv.Tag)
+	waitAtEnd bool // 文件发送完毕后是否继续等待seek信令，否则结束播放
+
+	ctrlChan    chan vodCtrl
+	doneChan    chan struct{}
+	disposeOnce sync.Once
+}
+
+func newVodPlayer(uk string, file *httpflv.FlvVodFile, waitAtEnd bool, onTag func(tag *httpflv.Tag)) *vodPlayer {
+	return &vodPlayer{
+		uk:        uk,
+		file:      file,
+		onTag:     onTag,
+		waitAtEnd: waitAtEnd,
+		ctrlChan:  make(chan vodCtrl, 8),
+		doneChan:  make(chan struct{}),
+	}
+}
+
+// OnSeek OnPause
+//
+// 实现 rtmp.ISubSessionCtrlObserver
+func (p *vodPlayer) OnSeek(ms int) {
+	p.ctrl(vodCtrl{t: vodCtrlSeek, ms: ms})
+}
+
+func (p *vodPlayer) OnPause(pause bool, ms int) {
+	if pause {
+		p.ctrl(vodCtrl{t: vodCtrlPause, ms: ms})
+	} else {
+		p.ctrl(vodCtrl{t: vodCtrlResume, ms: ms})
+	}
+}
+
+func (p *vodPlayer) Dispose() {
+	p.disposeOnce.Do(func() {
+		close(p.doneChan)
+	})
+}
+
+// Run 阻塞直到文件发送完毕，或者调用了 Dispose
+//
+// @return 文件发送完毕时返回true
+func (p *vodPlayer) Run(startMs int) bool {
+	var (
+		next     *httpflv.Tag // 下一个要发送的tag，为nil表示文件已经读取完毕
+		baseTs   int64        // 当前播放段的首个tag的时间戳
+		baseTick time.Time    // 当前播放段开始时的物理时间
+		paused   bool
+	)
+	readNext := func() {
+		tag, err := p.file.ReadTag()
+		if err != nil {
+			if err != io.EOF {
+				Log.Warnf("[%s] vod read tag failed. err=%+v", p.uk, err)
+			}
+			next = nil
+			return
+		}
+		next = &tag
+	}
+	seek := func(ms int) {
+		if ms < 0 {
+			ms = 0
+		}
+		ts, ok := p.file.Seek(uint32(ms))
+		baseTs = int64(ms)
+		next = nil
+		if ok {
+			baseTs = int64(ts)
+			headers, err := p.file.SeqHeaders(ts)
+			if err != nil {
+				Log.Warnf("[%s] vod read seq headers failed. err=%+v", p.uk, err)
+			}
+			for i := range headers {
+				p.onTag(&headers[i])
+			}
+			readNext()
+		}
+		baseTick = time.Now()
+		Log.Infof("[%s] vod seek. ms=%d, ts=%d", p.uk, ms, baseTs)
+	}
+	seek(startMs)
+
+	for {
+		var timeout <-chan time.Time
+		if !paused && next != nil {
+			due := baseTick.Add(time.Duration(int64(next.Header.Timestamp)-baseTs) * time.Millisecond)
+			if d := time.Until(due); d > 0 {
+				timeout = time.After(d)
+			} else {
+				p.onTag(next)
+				readNext()
+				continue
+			}
+		} else if next == nil && !p.waitAtEnd {
+			return true
+		}
+
+		select {
+		case <-p.doneChan:
+			return false
+		case <-timeout:
+		case c := <-p.ctrlChan:
+			switch c.t {
+			case vodCtrlSeek:
+				seek(c.ms)
+			case vodCtrlPause:
+				paused = true
+			case vodCtrlResume:
+				paused = false
+				if next != nil {
+					baseTs = int64(next.Header.Timestamp)
+				}
+				baseTick = time.Now()
+			}
+		}
+	}
+}
+
+func (p *vodPlayer) ctrl(c vodCtrl) {
+	select {
+	case p.ctrlChan <- c:
+	case <-p.doneChan:
+	}
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func (sm *ServerManager) isVodApp(appName string) bool {
+	c := sm.Config().RecordConfig
+	return c.EnableVod && appName == c.VodAppName
+}
+
+// openVodFile 注意，为了安全，只允许访问录制目录下的文件
+//
+// 第一次打开文件时需要读取所有tag header建立索引，调用方不能持有sm.mutex
+//
+// @return fp: 播放结束后由调用方关闭
+func (sm *ServerManager) openVodFile(streamName string) (file *httpflv.FlvVodFile, fp *os.File, err error) {
+	name := strings.TrimSuffix(streamName, ".flv")
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return nil, nil, base.ErrVodFilenameInvalid
+	}
+	fp, err = os.Open(filepath.Join(sm.Config().RecordConfig.FlvOutPath, name+".flv"))
+	if err != nil {
+		return nil, nil, err
+	}
+	index, err := sm.vodIndexCache.Get(fp)
+	if err != nil {
+		_ = fp.Close()
+		return nil, nil, err
+	}
+	return httpflv.NewFlvVodFile(fp, index), fp, nil
+}
+
+// parseVodStartMs 解析url参数中的`start`，单位秒
+func parseVodStartMs(rawQuery string) int {
+	values, _ := url.ParseQuery(rawQuery)
+	sec, err := strconv.ParseFloat(values.Get("start"), 64)
+	if err != nil || sec < 0 {
+		return 0
+	}
+	return int(sec * 1000)
+}
+
+// addVodHttpflvSubSession 调用方不能持有sm.mutex，并且已经完成鉴权
+//
+// info.StreamName 是鉴权修改后的流名称
+func (sm *ServerManager) addVodHttpflvSubSession(session *httpflv.SubSession, info base.SubStartInfo) error {
+	file, fp, err := sm.openVodFile(info.StreamName)
+
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	if !sm.checkAuthedSession(session) {
+		if fp != nil {
+			_ = fp.Close()
+		}
+		return base.ErrAuthSessionDisposed
+	}
+	if err != nil {
+		Log.Warnf("[%s] open vod file failed. stream=%s, err=%+v", session.UniqueKey(), info.StreamName, err)
+		return err
+	}
+
+	session.WriteHttpResponseHeader()
+	session.WriteFlvHeader()
+	p := newVodPlayer(session.UniqueKey(), file, false, session.WriteTag)
+	sm.vodPlayers[session.UniqueKey()] = p
+	go func() {
+		defer fp.Close()
+		if p.Run(parseVodStartMs(session.RawQuery())) {
+			Log.Infof("[%s] vod finished.", session.UniqueKey())
+			_ = session.Flush()
+			_ = session.Dispose()
+		}
+	}()
+
+	sm.option.NotifyHandler.OnSubStart(info)
+	return nil
+}
+
+// addVodRtmpSubSession 同 addVodHttpflvSubSession
+func (sm *ServerManager) addVodRtmpSubSession(session *rtmp.ServerSession, info base.SubStartInfo) error {
+	file, fp, err := sm.openVodFile(info.StreamName)
+
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	if !sm.checkAuthedSession(session) {
+		if fp != nil {
+			_ = fp.Close()
+		}
+		return base.ErrAuthSessionDisposed
+	}
+	if err != nil {
+		Log.Warnf("[%s] open vod file failed. stream=%s, err=%+v", session.UniqueKey(), info.StreamName, err)
+		return err
+	}
+
+	// rtmp文件发送完毕后不断开，播放端还可以seek
+	p := newVodPlayer(session.UniqueKey(), file, true, func(tag *httpflv.Tag) {
+		_ = session.Write(remux.FlvTag2RtmpChunks(*tag))
+	})
+	session.SetSubSessionCtrlObserver(p)
+	sm.vodPlayers[session.UniqueKey()] = p
+	go func() {
+		defer fp.Close()
+		p.Run(parseVodStartMs(session.RawQuery()))
+	}()
+
+	sm.option.NotifyHandler.OnSubStart(info)
+	return nil
+}
+
+// delVodSubSession 调用方需要持有sm.mutex
+//
+// @param streamName: 鉴权修改后的流名称
+//
+// @return 如果不是点播的session，返回false
+func (sm *ServerManager) delVodSubSession(session base.ISession, streamName string) bool {
+	p, ok := sm.vodPlayers[session.UniqueKey()]
+	if !ok {
+		return false
+	}
+	p.Dispose()
+	delete(sm.vodPlayers, session.UniqueKey())
+	info := base.Session2SubStopInfo(session)
+	info.StreamName = streamName
+	sm.option.NotifyHandler.OnSubStop(info)
+	return true
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// vodIndexCacheMaxNum 最多缓存的文件索引个数，超过后随机淘汰
+const vodIndexCacheMaxNum = 128
+
+// vodIndexCache 缓存点播文件的索引，同一个文件的多个播放端共用
+//
+// 文件长度或修改时间变化后（比如还在录制中的文件）重新建立索引
+type vodIndexCache struct {
+	mutex sync.Mutex
+	items map[string]vodIndexCacheItem // key: 文件路径
+}
+
+type vodIndexCacheItem struct {
+	size    int64
+	modTime time.Time
+	index   *httpflv.FlvVodIndex
+}
+
+func newVodIndexCache() *vodIndexCache {
+	return &vodIndexCache{
+		items: make(map[string]vodIndexCacheItem),
+	}
+}
+
+// Get 建立索引时不持有锁，同一个文件并发建立索引时，以最后建立的为准
+func (c *vodIndexCache) Get(fp *os.File) (*httpflv.FlvVodIndex, error) {
+	fi, err := fp.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	c.mutex.Lock()
+	item, ok := c.items[fp.Name()]
+	c.mutex.Unlock()
+	if ok && item.size == fi.Size() && item.modTime.Equal(fi.ModTime()) {
+		return item.index, nil
+	}
+
+	index, err := httpflv.NewFlvVodIndex(fp, fi.Size())
+	if err != nil {
+		return nil, err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if _, ok := c.items[fp.Name()]; !ok && len(c.items) >= vodIndexCacheMaxNum {
+		for k := range c.items {
+			delete(c.items, k)
+			break
+		}
+	}
+	c.items[fp.Name()] = vodIndexCacheItem{
+		size:    fi.Size(),
+		modTime: fi.ModTime(),
+		index:   index,
+	}
+	return index, nil
+}
@@ -0,0 +1,88 @@
+// Copyright 2023, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/q191201771/lal/pkg/httpflv"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func makeVodTestFile(t *testing.T) *httpflv.FlvVodFile {
+	// 每100毫秒一个关键帧，共500毫秒
+	buf := append([]byte{}, httpflv.FlvHeader...)
+	buf = append(buf, httpflv.PackHttpflvTag(httpflv.TagTypeVideo, 0, []byte{httpflv.AvcKeyFrame, httpflv.AvcPacketTypeSeqHeader})...)
+	for ts := uint32(0); ts < 500; ts += 20 {
+		if ts%100 == 0 {
+			buf = append(buf, httpflv.PackHttpflvTag(httpflv.TagTypeVideo, ts, []byte{httpflv.AvcKeyFrame, httpflv.AvcPacketTypeNalu})...)
+		} else {
+			buf = append(buf, httpflv.PackHttpflvTag(httpflv.TagTypeVideo, ts, []byte{httpflv.AvcInterFrame, httpflv.AvcPacketTypeNalu})...)
+		}
+	}
+	index, err := httpflv.NewFlvVodIndex(bytes.NewReader(buf), int64(len(buf)))
+	assert.Equal(t, nil, err)
+	return httpflv.NewFlvVodFile(bytes.NewReader(buf), index)
+}
+
+func TestVodPlayer(t *testing.T) {
+	// 从指定位置开始播放，播放完毕后结束
+	var tss []uint32
+	p := newVodPlayer("test", makeVodTestFile(t), false, func(tag *httpflv.Tag) {
+		tss = append(tss, tag.Header.Timestamp)
+	})
+	b := time.Now()
+	assert.Equal(t, true, p.Run(270))
+	assert.Equal(t, true, time.Since(b) >= 150*time.Millisecond)
+	assert.Equal(t, []uint32{300, 300, 320, 340, 360, 380, 400, 420, 440, 460, 480}, tss)
+
+	// 暂停时不发送数据，seek后从新的位置开始发送
+	var (
+		mutex sync.Mutex
+		n     int
+		last  uint32
+	)
+	p = newVodPlayer("test", makeVodTestFile(t), true, func(tag *httpflv.Tag) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		n++
+		last = tag.Header.Timestamp
+	})
+	doneChan := make(chan bool)
+	go func() {
+		doneChan <- p.Run(0)
+	}()
+	get := func() (int, uint32) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return n, last
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	p.OnPause(true, 0)
+	time.Sleep(20 * time.Millisecond)
+	n1, _ := get()
+	time.Sleep(100 * time.Millisecond)
+	n2, _ := get()
+	assert.Equal(t, n1, n2)
+
+	p.OnSeek(400)
+	p.OnPause(false, 400)
+	time.Sleep(150 * time.Millisecond)
+	n3, ts := get()
+	assert.Equal(t, n2+1+5, n3)
+	assert.Equal(t, uint32(480), ts)
+
+	// 播放完毕后依然等待seek
+	p.Dispose()
+	assert.Equal(t, false, <-doneChan)
+}
@@ -0,0 +1,103 @@
+// Copyright 2023, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package mpegts
+
+import (
+	"github.com/q191201771/lal/pkg/base"
+)
+
+// NewFixedFragmentHeader 根据音视频类型生成每个TS文件开头的PAT，PMT
+//
+// 视频支持AVC，HEVC，音频支持AAC，G711A，G711U，MP3，Opus，其他类型（包括未知）分别按AVC，AAC处理，与 FixedFragmentHeader 保持一致
+func NewFixedFragmentHeader(videoPt base.AvPacketPt, audioPt base.AvPacketPt) []byte {
+	videoStreamType := streamTypeAvc
+	if videoPt == base.AvPacketPtHevc {
+		videoStreamType = streamTypeHevc
+	}
+	audioStreamType := streamTypeAac
+	var audioEsInfo []byte
+	switch audioPt {
+	case base.AvPacketPtG711A:
+		audioStreamType = streamTypeG711A
+	case base.AvPacketPtG711U:
+		audioStreamType = streamTypeG711U
+	case base.AvPacketPtMp3:
+		audioStreamType = streamTypeMp3
+	case base.AvPacketPtOpus:
+		audioStreamType = streamTypePrivateData
+		audioEsInfo = opusEsInfo
+	}
+
+	out := make([]byte, 2*188)
+	// PAT与 FixedFragmentHeader 相同
+	copy(out, FixedFragmentHeader[:188])
+
+	pmt := out[188:]
+	for i := range pmt {
+		pmt[i] = 0xff
+	}
+
+	var elements []byte
+	elements = appendPmtProgramElement(elements, videoStreamType, PidVideo, nil)
+	elements = appendPmtProgramElement(elements, audioStreamType, PidAudio, audioEsInfo)
+	// section_length: program_number至CRC的字节数
+	sectionLength := 5 + 4 + len(elements) + 4
+
+	section := []byte{
+		/* TS */
+		0x47, 0x50, 0x01, 0x10, 0x00,
+		/* PSI */
+		0x02, 0xb0 | uint8(sectionLength>>8), uint8(sectionLength), 0x00, 0x01, 0xc1, 0x00, 0x00,
+		/* PMT */
+		0xe1, 0x00,
+		0xf0, 0x00,
+	}
+	section = append(section, elements...)
+	n := copy(pmt, section)
+	// crc从table_id开始计算，跳过TS头以及pointer_field
+	crc := calcCrc32Mpeg2(pmt[5:n])
+	pmt[n] = uint8(crc >> 24)
+	pmt[n+1] = uint8(crc >> 16)
+	pmt[n+2] = uint8(crc >> 8)
+	pmt[n+3] = uint8(crc)
+	return out
+}
+
+// opusEsInfo Opus的描述符，与ffmpeg的实现保持一致（见ffmpeg mpegtsenc.c）
+//
+// registration_descriptor: tag 0x05，format_identifier为`Opus`
+// extension_descriptor:    tag 0x7f，extension_descriptor_tag 0x80，channel_config_code为2，也即双声道
+var opusEsInfo = []byte{
+	0x05, 0x04, 'O', 'p', 'u', 's',
+	0x7f, 0x02, 0x80, 0x02,
+}
+
+func appendPmtProgramElement(out []byte, streamType uint8, pid uint16, esInfo []byte) []byte {
+	out = append(out,
+		streamType,
+		0xe0|uint8(pid>>8), uint8(pid),
+		0xf0|uint8(len(esInfo)>>8), uint8(len(esInfo)))
+	return append(out, esInfo...)
+}
+
+// calcCrc32Mpeg2 CRC-32/MPEG-2，多项式0x04C11DB7，初始值0xFFFFFFFF，不反转，结果不异或
+func calcCrc32Mpeg2(b []byte) uint32 {
+	crc := uint32(0xFFFFFFFF)
+	for _, v := range b {
+		crc ^= uint32(v) << 24
+		for i := 0; i < 8; i++ {
+			if crc&0x80000000 != 0 {
+				crc = (crc << 1) ^ 0x04C11DB7
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
@@ -156,10 +156,21 @@ const (
 	// 0x0F AAC  (ISO/IEC 13818-7 Audio with ADTS transport syntax)
 	// 0x1B AVC  (video stream as defined in ITU-T Rec. H.264 | ISO/IEC 14496-10 Video)
 	// 0x24 HEVC (HEVC video stream as defined in Rec. ITU-T H.265 | ISO/IEC 23008-2  MPEG-H Part 2)
+	//
+	// 0x03 MP3  (ISO/IEC 11172-3 Audio)
+	// 0x04 MP3  (ISO/IEC 13818-3 Audio)
+	// 0x06 PES packets containing private data，Opus使用该类型，并通过PMT中的描述符标识
+	//
+	// 0x90 G711A，0x91 G711U 为私有类型（User Private），与GB28181等常见实现保持一致
 	// -----------------------------------------------------------------------------
-	streamTypeAac  uint8 = 0x0F
-	streamTypeAvc  uint8 = 0x1B
-	streamTypeHevc uint8 = 0x24
+	streamTypeMp3         uint8 = 0x03
+	streamTypeMp3Mpeg2    uint8 = 0x04
+	streamTypePrivateData uint8 = 0x06
+	streamTypeAac         uint8 = 0x0F
+	streamTypeAvc         uint8 = 0x1B
+	streamTypeHevc        uint8 = 0x24
+	streamTypeG711A       uint8 = 0x90
+	streamTypeG711U       uint8 = 0x91
 )
 
 // PES
@@ -11,7 +11,9 @@ package mpegts_test
 import (
 	"testing"
 
+	"github.com/q191201771/lal/pkg/base"
 	"github.com/q191201771/lal/pkg/innertest"
+	"github.com/q191201771/naza/pkg/assert"
 
 	"github.com/q191201771/lal/pkg/mpegts"
 )
@@ -31,3 +33,86 @@ func TestParseFixedTsPacket(t *testing.T) {
 	pmt := mpegts.ParsePmt(mpegts.FixedFragmentHeader[188+5:])
 	mpegts.Log.Debugf("%+v", pmt)
 }
+
+func TestTsUnpacker(t *testing.T) {
+	video := []byte{0, 0, 0, 1, 0x65, 1, 2, 3}
+	for i := 0; i < 500; i++ {
+		video = append(video, byte(i))
+	}
+	// 44100Hz，双声道，AAC LC
+	audio := []byte{0xff, 0xf1, 0x50, 0x80, 0x01, 0xff, 0xfc, 0xde, 0x02, 0x00, 0x4c, 0x61, 0x76, 0x63, 0x35}
+
+	var out []*base.AvPacket
+	unpacker := mpegts.NewTsUnpacker().WithOnAvPacket(func(packet *base.AvPacket) {
+		p := *packet
+		p.Payload = append([]byte(nil), packet.Payload...)
+		out = append(out, &p)
+	})
+
+	var stream []byte
+	stream = append(stream, mpegts.FixedFragmentHeader...)
+	vf := mpegts.Frame{Pts: 200 * 90, Dts: 160 * 90, Pid: mpegts.PidVideo, Sid: mpegts.StreamIdVideo, Key: true, Raw: video}
+	stream = append(stream, vf.Pack()...)
+	af := mpegts.Frame{Pts: 180 * 90, Dts: 180 * 90, Pid: mpegts.PidAudio, Sid: mpegts.StreamIdAudio, Raw: audio}
+	stream = append(stream, af.Pack()...)
+
+	// 故意不按188字节对齐喂入
+	for len(stream) > 0 {
+		n := 100
+		if n > len(stream) {
+			n = len(stream)
+		}
+		unpacker.FeedMpegts(stream[:n])
+		stream = stream[n:]
+	}
+	unpacker.Flush()
+
+	// 注意，打包时pts和dts都增加了700毫秒的延迟
+	assert.Equal(t, 2, len(out))
+	for _, p := range out {
+		switch p.PayloadType {
+		case base.AvPacketPtAvc:
+			assert.Equal(t, int64(160+700), p.Timestamp)
+			assert.Equal(t, int64(200+700), p.Pts)
+			assert.Equal(t, video, p.Payload)
+		case base.AvPacketPtAac:
+			assert.Equal(t, int64(180+700), p.Timestamp)
+			assert.Equal(t, audio, p.Payload)
+		default:
+			t.Fatalf("unexpected payload type. %s", p.PayloadType.ReadableString())
+		}
+	}
+}
+
+func TestNewFixedFragmentHeader(t *testing.T) {
+	assert.Equal(t, mpegts.FixedFragmentHeader, mpegts.NewFixedFragmentHeader(base.AvPacketPtAvc, base.AvPacketPtAac))
+	assert.Equal(t, mpegts.FixedFragmentHeaderHevc, mpegts.NewFixedFragmentHeader(base.AvPacketPtHevc, base.AvPacketPtAac))
+
+	b := mpegts.NewFixedFragmentHeader(base.AvPacketPtAvc, base.AvPacketPtG711A)
+	assert.Equal(t, 2*188, len(b))
+	pmt := mpegts.ParsePmt(b[188+5:])
+	assert.Equal(t, 2, len(pmt.ProgramElements))
+	assert.Equal(t, uint8(0x1b), pmt.ProgramElements[0].StreamType)
+	assert.Equal(t, uint8(0x90), pmt.ProgramElements[1].StreamType)
+}
+
+func TestNewFixedFragmentHeaderOpus(t *testing.T) {
+	b := mpegts.NewFixedFragmentHeader(base.AvPacketPtHevc, base.AvPacketPtOpus)
+	assert.Equal(t, 2*188, len(b))
+	pmt := mpegts.ParsePmt(b[188+5:])
+	assert.Equal(t, 2, len(pmt.ProgramElements))
+	assert.Equal(t, uint8(0x24), pmt.ProgramElements[0].StreamType)
+	assert.Equal(t, uint8(0x06), pmt.ProgramElements[1].StreamType)
+	assert.Equal(t, mpegts.PidAudio, pmt.ProgramElements[1].Pid)
+	assert.Equal(t, uint16(10), pmt.ProgramElements[1].Length)
+
+	b = mpegts.NewFixedFragmentHeader(base.AvPacketPtAvc, base.AvPacketPtMp3)
+	pmt = mpegts.ParsePmt(b[188+5:])
+	assert.Equal(t, uint8(0x03), pmt.ProgramElements[1].StreamType)
+}
+
+func TestAppendOpusControlHeader(t *testing.T) {
+	assert.Equal(t, []byte{0x7f, 0xe0, 100}, mpegts.AppendOpusControlHeader(nil, 100))
+	assert.Equal(t, []byte{0x7f, 0xe0, 0xff, 0}, mpegts.AppendOpusControlHeader(nil, 255))
+	assert.Equal(t, []byte{0x7f, 0xe0, 0xff, 0xff, 2}, mpegts.AppendOpusControlHeader(nil, 512))
+}
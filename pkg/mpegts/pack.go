@@ -281,3 +281,19 @@ func packPts(out []byte, fb uint8, pts uint64) {
 	out[3] = uint8(val >> 8)
 	out[4] = uint8(val)
 }
+
+// AppendOpusControlHeader TS中每个Opus packet前需要加上control header，与ffmpeg的实现保持一致（见ffmpeg mpegtsenc.c）
+//
+// control_header_prefix [11b] 固定为0x3ff
+// start_trim_flag       [1b]
+// end_trim_flag         [1b]
+// control_extension_flag[1b]
+// reserved              [2b]
+// au_size               [n*8b] 值为0xff的字节个数加上最后一个字节的值
+func AppendOpusControlHeader(out []byte, auSize int) []byte {
+	out = append(out, 0x7f, 0xe0)
+	for ; auSize >= 0xff; auSize -= 0xff {
+		out = append(out, 0xff)
+	}
+	return append(out, uint8(auSize))
+}
@@ -80,11 +80,12 @@ func ParsePmt(b []byte) (pmt Pmt) {
 	_, _ = br.ReadBits8(4)
 	pmt.pil, _ = br.ReadBits16(12)
 	if pmt.pil != 0 {
-		Log.Warn(pmt.pil)
 		_, _ = br.ReadBytes(uint(pmt.pil))
+		length -= pmt.pil
 	}
 
-	for i := uint16(0); i < length; i += 5 {
+	// 注意，ES_info_length的单位是字节，描述信息需要整体跳过，并计入循环长度
+	for i := uint16(0); i+5 <= length; {
 		var ppe PmtProgramElement
 		ppe.StreamType, _ = br.ReadBits8(8)
 		_, _ = br.ReadBits8(3)
@@ -92,10 +93,10 @@ func ParsePmt(b []byte) (pmt Pmt) {
 		_, _ = br.ReadBits8(4)
 		ppe.Length, _ = br.ReadBits16(12)
 		if ppe.Length != 0 {
-			Log.Warn(ppe.Length)
-			_, _ = br.ReadBits32(uint(ppe.Length))
+			_, _ = br.ReadBytes(uint(ppe.Length))
 		}
 		pmt.ProgramElements = append(pmt.ProgramElements, ppe)
+		i += 5 + ppe.Length
 	}
 
 	return
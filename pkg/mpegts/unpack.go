@@ -0,0 +1,250 @@
+// Copyright 2023, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package mpegts
+
+import (
+	"github.com/q191201771/lal/pkg/aac"
+	"github.com/q191201771/lal/pkg/base"
+)
+
+// TsUnpacker 解析mpegts流，将PES合帧后通过回调返回音视频数据
+//
+// 回调的 base.AvPacket 各字段含义：
+//
+//   - PayloadType: 音频AAC为 base.AvPacketPtAac ，视频为 base.AvPacketPtAvc 或 base.AvPacketPtHevc
+//   - Timestamp:   dts，单位毫秒
+//   - Pts:         pts，单位毫秒
+//   - Payload:     视频为Annexb格式，可能包含多个nal；音频为单个带ADTS头的AAC帧
+//
+// 注意，回调结束后，内部会复用 Payload 的内存块，上层如需持有请自行拷贝
+//
+// TODO(chef): 33位时间戳回绕没有处理
+type TsUnpacker struct {
+	onAvPacket base.OnAvPacketFunc
+
+	hasPat  bool
+	pmtPid  uint16
+	streams map[uint16]*tsUnpackerStream
+
+	remain []byte // 上次调用 FeedMpegts 时，不足188字节的数据
+}
+
+type tsUnpackerStream struct {
+	payloadType base.AvPacketPt
+
+	started     bool
+	pts         int64 // 单位 毫秒*90
+	dts         int64
+	expectedLen int // PES_packet_length不为0时，根据它计算出的payload长度，达到后立即回调，不用等下一个PES的开始
+	buf         []byte
+}
+
+func NewTsUnpacker() *TsUnpacker {
+	return &TsUnpacker{
+		streams: make(map[uint16]*tsUnpackerStream),
+	}
+}
+
+func (u *TsUnpacker) WithOnAvPacket(onAvPacket base.OnAvPacketFunc) *TsUnpacker {
+	u.onAvPacket = onAvPacket
+	return u
+}
+
+// FeedMpegts
+//
+// @param b: 一个或多个188字节的ts packet，不要求按188字节对齐。函数调用结束后，内部不持有该内存块
+func (u *TsUnpacker) FeedMpegts(b []byte) {
+	if len(u.remain) != 0 {
+		u.remain = append(u.remain, b...)
+		b = u.remain
+	}
+
+	for len(b) >= 188 {
+		if b[0] != syncByte {
+			// 丢弃数据，直到找到下一个同步字节
+			i := 1
+			for ; i < len(b) && b[i] != syncByte; i++ {
+			}
+			b = b[i:]
+			continue
+		}
+		u.feedTsPacket(b[:188])
+		b = b[188:]
+	}
+
+	u.remain = append(u.remain[:0], b...)
+}
+
+// Flush 将内部缓存的还没有回调的帧数据回调给上层，一般在流结束时调用
+func (u *TsUnpacker) Flush() {
+	for _, s := range u.streams {
+		if s.started {
+			u.emit(s)
+		}
+	}
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func (u *TsUnpacker) feedTsPacket(packet []byte) {
+	h := ParseTsPacketHeader(packet)
+
+	pos := 4
+	switch h.Adaptation {
+	case AdaptationFieldControlNo:
+		// noop
+	case AdaptationFieldControlFollowed:
+		pos += 1 + int(packet[4])
+	default:
+		return
+	}
+	if pos >= len(packet) {
+		return
+	}
+	payload := packet[pos:]
+
+	if h.Pid == PidPat {
+		if h.PayloadUnitStart == 1 {
+			payload = u.skipPointerField(payload)
+		}
+		if payload == nil {
+			return
+		}
+		pat := ParsePat(payload)
+		for _, ppe := range pat.ppes {
+			// program_number为0时，是network_PID
+			if ppe.pn != 0 {
+				u.pmtPid = ppe.pmpid
+				u.hasPat = true
+				break
+			}
+		}
+		return
+	}
+
+	if u.hasPat && h.Pid == u.pmtPid {
+		if h.PayloadUnitStart == 1 {
+			payload = u.skipPointerField(payload)
+		}
+		if payload == nil {
+			return
+		}
+		pmt := ParsePmt(payload)
+		for _, ppe := range pmt.ProgramElements {
+			if _, ok := u.streams[ppe.Pid]; ok {
+				continue
+			}
+			var pt base.AvPacketPt
+			switch ppe.StreamType {
+			case streamTypeAvc:
+				pt = base.AvPacketPtAvc
+			case streamTypeHevc:
+				pt = base.AvPacketPtHevc
+			case streamTypeAac:
+				pt = base.AvPacketPtAac
+			case streamTypeG711A:
+				pt = base.AvPacketPtG711A
+			case streamTypeG711U:
+				pt = base.AvPacketPtG711U
+			case streamTypeMp3, streamTypeMp3Mpeg2:
+				pt = base.AvPacketPtMp3
+			default:
+				Log.Warnf("unsupported stream type in pmt. type=%d, pid=%d", ppe.StreamType, ppe.Pid)
+				continue
+			}
+			u.streams[ppe.Pid] = &tsUnpackerStream{payloadType: pt}
+		}
+		return
+	}
+
+	s, ok := u.streams[h.Pid]
+	if !ok {
+		return
+	}
+
+	if h.PayloadUnitStart == 1 {
+		if s.started {
+			u.emit(s)
+		}
+
+		pes, length := ParsePes(payload)
+		if length > len(payload) {
+			return
+		}
+		s.started = true
+		s.pts = int64(pes.pts)
+		s.dts = int64(pes.dts)
+		s.buf = append(s.buf[:0], payload[length:]...)
+		s.expectedLen = 0
+		if pes.ppl != 0 {
+			// PES_packet_length是PES_packet_length字段之后的长度
+			s.expectedLen = int(pes.ppl) + 6 - length
+		}
+	} else {
+		if !s.started {
+			return
+		}
+		s.buf = append(s.buf, payload...)
+	}
+
+	if s.expectedLen > 0 && len(s.buf) >= s.expectedLen {
+		s.buf = s.buf[:s.expectedLen]
+		u.emit(s)
+	}
+}
+
+func (u *TsUnpacker) skipPointerField(payload []byte) []byte {
+	pointer := int(payload[0])
+	if 1+pointer >= len(payload) {
+		return nil
+	}
+	return payload[1+pointer:]
+}
+
+func (u *TsUnpacker) emit(s *tsUnpackerStream) {
+	s.started = false
+	if len(s.buf) == 0 || u.onAvPacket == nil {
+		return
+	}
+
+	if s.payloadType != base.AvPacketPtAac {
+		u.onAvPacket(&base.AvPacket{
+			PayloadType: s.payloadType,
+			Timestamp:   s.dts / 90,
+			Pts:         s.pts / 90,
+			Payload:     s.buf,
+		})
+		return
+	}
+
+	// 一个音频PES中可能包含多个ADTS帧，拆开后逐个回调，并根据采样率推算每一帧的时间戳
+	var ctx aac.AdtsHeaderContext
+	for i, n := 0, 0; len(s.buf)-i >= aac.AdtsHeaderLength; n++ {
+		if err := ctx.Unpack(s.buf[i:]); err != nil {
+			return
+		}
+		length := int(ctx.AdtsLength)
+		if length < aac.AdtsHeaderLength || i+length > len(s.buf) {
+			Log.Warnf("invalid adts frame. length=%d, remain=%d", length, len(s.buf)-i)
+			return
+		}
+
+		ts := s.dts / 90
+		if sf, err := ctx.AscCtx.GetSamplingFrequency(); err == nil && sf > 0 {
+			ts += int64(n) * 1024 * 1000 / int64(sf)
+		}
+		u.onAvPacket(&base.AvPacket{
+			PayloadType: base.AvPacketPtAac,
+			Timestamp:   ts,
+			Pts:         ts,
+			Payload:     s.buf[i : i+length],
+		})
+		i += length
+	}
+}
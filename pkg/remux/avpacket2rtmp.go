@@ -40,6 +40,10 @@ type AvPacket2RtmpRemuxer struct {
 	pps []byte
 
 	hasAdts2Asc bool
+
+	hasEmittedOpusSeqHeader bool
+
+	enhancedRtmp bool // 是否输出Enhanced RTMP格式的HEVC
 }
 
 func NewAvPacket2RtmpRemuxer() *AvPacket2RtmpRemuxer {
@@ -59,6 +63,14 @@ func (r *AvPacket2RtmpRemuxer) WithOption(modOption func(option *base.AvPacketSt
 	// TODO(chef): [log] 打印所有option 202301
 }
 
+// WithEnhancedRtmp 设置为true时，HEVC输出Enhanced RTMP格式（FourCC为`hvc1`），否则输出CodecId为12的传统格式
+//
+// 默认为false
+func (r *AvPacket2RtmpRemuxer) WithEnhancedRtmp(enable bool) *AvPacket2RtmpRemuxer {
+	r.enhancedRtmp = enable
+	return r
+}
+
 func (r *AvPacket2RtmpRemuxer) WithOnRtmpMsg(onRtmpMsg rtmp.OnReadRtmpAvMsg) *AvPacket2RtmpRemuxer {
 	r.onRtmpMsg = onRtmpMsg
 	return r
@@ -73,6 +85,11 @@ func (r *AvPacket2RtmpRemuxer) OnRtpPacket(pkt rtprtcp.RtpPacket) {
 	// noop
 }
 func (r *AvPacket2RtmpRemuxer) OnSdp(sdpCtx sdp.LogicContext) {
+	// G711，Opus，MP3没有类似asc的音频头，只能从sdp中获取音频类型
+	switch sdpCtx.GetAudioPayloadTypeBase() {
+	case base.AvPacketPtG711A, base.AvPacketPtG711U, base.AvPacketPtOpus, base.AvPacketPtMp3:
+		r.audioType = sdpCtx.GetAudioPayloadTypeBase()
+	}
 	r.InitWithAvConfig(sdpCtx.Asc, sdpCtx.Vps, sdpCtx.Sps, sdpCtx.Pps)
 }
 func (r *AvPacket2RtmpRemuxer) OnAvPacket(pkt base.AvPacket) {
@@ -106,7 +123,7 @@ func (r *AvPacket2RtmpRemuxer) InitWithAvConfig(asc, vps, sps, pps []byte) {
 		return
 	}
 
-	if r.audioType != base.AvPacketPtUnknown {
+	if r.audioType == base.AvPacketPtAac {
 		bAsh, err = aac.MakeAudioDataSeqHeaderWithAsc(asc)
 		if err != nil {
 			Log.Errorf("build aac seq header failed. err=%+v", err)
@@ -129,7 +146,8 @@ func (r *AvPacket2RtmpRemuxer) InitWithAvConfig(asc, vps, sps, pps []byte) {
 		}
 	}
 
-	if r.audioType != base.AvPacketPtUnknown {
+	// 注意，G711没有音频头
+	if r.audioType == base.AvPacketPtAac {
 		r.emitRtmpAvMsg(true, bAsh, 0)
 	}
 
@@ -144,6 +162,7 @@ func (r *AvPacket2RtmpRemuxer) InitWithAvConfig(asc, vps, sps, pps []byte) {
 //
 // @param pkt:
 //   - 如果是aac，格式是裸数据或带adts头，具体取决于前面的配置。
+//   - 如果是g711a或g711u，格式是裸数据。
 //   - 如果是h264，格式是avcc或Annexb，具体取决于前面的配置。
 //     内部不持有该内存块。
 func (r *AvPacket2RtmpRemuxer) FeedAvPacket(pkt base.AvPacket) {
@@ -306,6 +325,39 @@ func (r *AvPacket2RtmpRemuxer) FeedAvPacket(pkt base.AvPacket) {
 			r.emitRtmpAvMsg(true, payload, pkt.Timestamp)
 		}
 
+	case base.AvPacketPtG711A, base.AvPacketPtG711U:
+		// 没有sdp时（比如gb28181，customize pub），由音频数据确定音频类型
+		r.audioType = pkt.PayloadType
+
+		// rtmp中G711的SoundRate字段无意义，固定为8000Hz，16bit，单声道
+		soundFormat := base.RtmpSoundFormatG711A
+		if pkt.PayloadType == base.AvPacketPtG711U {
+			soundFormat = base.RtmpSoundFormatG711U
+		}
+		payload := make([]byte, len(pkt.Payload)+1)
+		payload[0] = soundFormat<<4 | 0x2
+		copy(payload[1:], pkt.Payload)
+		r.emitRtmpAvMsg(true, payload, pkt.Timestamp)
+
+	case base.AvPacketPtMp3:
+		r.audioType = pkt.PayloadType
+
+		// 和大部分推流工具一样，固定为44100Hz，16bit，双声道，播放器会以mp3帧头中的信息为准
+		payload := make([]byte, len(pkt.Payload)+1)
+		payload[0] = base.RtmpSoundFormatMp3<<4 | 0xF
+		copy(payload[1:], pkt.Payload)
+		r.emitRtmpAvMsg(true, payload, pkt.Timestamp)
+
+	case base.AvPacketPtOpus:
+		r.audioType = pkt.PayloadType
+
+		// Opus只有Enhanced RTMP格式，先发送OpusHead作为seq header
+		if !r.hasEmittedOpusSeqHeader {
+			r.emitRtmpAvMsg(true, packEnhancedAudio(base.RtmpExPacketTypeSequenceStart, base.RtmpFourCcOpus, makeOpusHead()), pkt.Timestamp)
+			r.hasEmittedOpusSeqHeader = true
+		}
+		r.emitRtmpAvMsg(true, packEnhancedAudio(base.RtmpExPacketTypeCodedFrames, base.RtmpFourCcOpus, pkt.Payload), pkt.Timestamp)
+
 	default:
 		Log.Warnf("unsupported packet. type=%d", pkt.PayloadType)
 	}
@@ -318,14 +370,28 @@ func (r *AvPacket2RtmpRemuxer) emitRtmpAvMsg(isAudio bool, payload []byte, times
 		// TODO(chef): 此处简化了从sps中获取宽高写入metadata的逻辑
 		audiocodecid := -1
 		videocodecid := -1
-		if r.audioType == base.AvPacketPtAac {
+		switch r.audioType {
+		case base.AvPacketPtAac:
 			audiocodecid = int(base.RtmpSoundFormatAac)
+		case base.AvPacketPtG711A:
+			audiocodecid = int(base.RtmpSoundFormatG711A)
+		case base.AvPacketPtG711U:
+			audiocodecid = int(base.RtmpSoundFormatG711U)
+		case base.AvPacketPtMp3:
+			audiocodecid = int(base.RtmpSoundFormatMp3)
+		case base.AvPacketPtOpus:
+			// Enhanced RTMP中，metadata的audiocodecid为FourCC
+			audiocodecid = int(bele.BeUint32([]byte(base.RtmpFourCcOpus)))
 		}
 		switch r.videoType {
 		case base.AvPacketPtAvc:
 			videocodecid = int(base.RtmpCodecIdAvc)
 		case base.AvPacketPtHevc:
 			videocodecid = int(base.RtmpCodecIdHevc)
+			if r.enhancedRtmp {
+				// Enhanced RTMP中，metadata的videocodecid为FourCC
+				videocodecid = int(bele.BeUint32([]byte(base.RtmpFourCcHevc)))
+			}
 		}
 		bMetadata, err := rtmp.BuildMetadata(-1, -1, audiocodecid, videocodecid)
 		if err != nil {
@@ -360,6 +426,10 @@ func (r *AvPacket2RtmpRemuxer) emitRtmpAvMsg(isAudio bool, payload []byte, times
 	msg.Header.TimestampAbs = uint32(timestamp)
 	msg.Payload = payload
 
+	if r.enhancedRtmp && !isAudio {
+		msg = LegacyRtmpMsg2Enhanced(msg)
+	}
+
 	r.onRtmpMsg(msg)
 }
 
@@ -14,6 +14,7 @@ import (
 
 	"github.com/q191201771/lal/pkg/base"
 	"github.com/q191201771/lal/pkg/remux"
+	"github.com/q191201771/naza/pkg/assert"
 )
 
 // #85
@@ -89,3 +90,65 @@ func TestCase2(t *testing.T) {
 		remuxer.FeedAvPacket(p)
 	}
 }
+
+func TestAvPacket2RtmpRemuxerG711(t *testing.T) {
+	var msgs []base.RtmpMsg
+	remuxer := remux.NewAvPacket2RtmpRemuxer().WithOnRtmpMsg(func(msg base.RtmpMsg) {
+		msgs = append(msgs, msg.Clone())
+	})
+	remuxer.FeedAvPacket(base.AvPacket{
+		Timestamp:   20,
+		PayloadType: base.AvPacketPtG711A,
+		Payload:     []byte{1, 2, 3},
+	})
+	// metadata + 音频数据
+	assert.Equal(t, 2, len(msgs))
+	assert.Equal(t, base.RtmpTypeIdMetadata, msgs[0].Header.MsgTypeId)
+	assert.Equal(t, base.RtmpTypeIdAudio, msgs[1].Header.MsgTypeId)
+	assert.Equal(t, []byte{0x72, 1, 2, 3}, msgs[1].Payload)
+
+	remuxer.FeedAvPacket(base.AvPacket{
+		Timestamp:   40,
+		PayloadType: base.AvPacketPtG711U,
+		Payload:     []byte{4, 5},
+	})
+	assert.Equal(t, []byte{0x82, 4, 5}, msgs[2].Payload)
+}
+
+func TestAvPacket2RtmpRemuxerOpusMp3(t *testing.T) {
+	var msgs []base.RtmpMsg
+	remuxer := remux.NewAvPacket2RtmpRemuxer().WithOnRtmpMsg(func(msg base.RtmpMsg) {
+		msgs = append(msgs, msg.Clone())
+	})
+	remuxer.FeedAvPacket(base.AvPacket{
+		Timestamp:   20,
+		PayloadType: base.AvPacketPtOpus,
+		Payload:     []byte{1, 2, 3},
+	})
+	remuxer.FeedAvPacket(base.AvPacket{
+		Timestamp:   40,
+		PayloadType: base.AvPacketPtOpus,
+		Payload:     []byte{4, 5},
+	})
+	// metadata + OpusHead + 两个音频数据
+	assert.Equal(t, 4, len(msgs))
+	assert.Equal(t, true, msgs[1].IsAudioSeqHeader())
+	assert.Equal(t, base.RtmpFourCcOpus, msgs[1].AudioFourCc())
+	assert.Equal(t, []byte("OpusHead"), msgs[1].Payload[5:13])
+	assert.Equal(t, base.AvPacketPtOpus, msgs[2].AudioPayloadType())
+	assert.Equal(t, []byte{0x91, 'O', 'p', 'u', 's', 1, 2, 3}, msgs[2].Payload)
+	assert.Equal(t, false, msgs[3].IsAudioSeqHeader())
+
+	msgs = nil
+	remuxer = remux.NewAvPacket2RtmpRemuxer().WithOnRtmpMsg(func(msg base.RtmpMsg) {
+		msgs = append(msgs, msg.Clone())
+	})
+	remuxer.FeedAvPacket(base.AvPacket{
+		Timestamp:   20,
+		PayloadType: base.AvPacketPtMp3,
+		Payload:     []byte{0xff, 0xfb, 1},
+	})
+	assert.Equal(t, 2, len(msgs))
+	assert.Equal(t, base.AvPacketPtMp3, msgs[1].AudioPayloadType())
+	assert.Equal(t, []byte{0x2f, 0xff, 0xfb, 1}, msgs[1].Payload)
+}
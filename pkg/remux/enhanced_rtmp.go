@@ -0,0 +1,133 @@
+// Copyright 2023, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package remux
+
+import (
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/naza/pkg/bele"
+)
+
+// Enhanced RTMP
+//
+// HEVC有两种格式：
+// - 传统格式（非标准，国内CDN的约定）：CodecId为12，后续结构与AVC相同
+// - Enhanced RTMP格式：使用ExVideoTagHeader，FourCC为`hvc1`
+//
+// lal内部统一使用传统格式，输入的Enhanced RTMP格式的HEVC在进入group时转换为传统格式，
+// 输出时再按拉流端的能力决定是否转换回Enhanced RTMP格式。
+// AV1，VP9只有Enhanced RTMP格式，不做转换，直接透传。
+// 音频方面，Opus只有Enhanced RTMP格式，同样直接透传。
+
+// EnhancedRtmpMsg2Legacy 将Enhanced RTMP格式的HEVC转换为传统格式
+//
+// 不是Enhanced RTMP格式的HEVC时，直接返回原msg，否则返回的msg使用新申请的内存块
+//
+// @return ok 为false时表示该msg在传统格式中没有对应的表示（比如PacketType为Metadata），应该丢弃
+func EnhancedRtmpMsg2Legacy(msg base.RtmpMsg) (out base.RtmpMsg, ok bool) {
+	if msg.VideoFourCc() != base.RtmpFourCcHevc {
+		return msg, true
+	}
+
+	frameType := (msg.Payload[0] >> 4) & 0x7
+	var (
+		packetType uint8
+		cts        []byte
+		data       []byte
+	)
+	switch msg.ExPacketType() {
+	case base.RtmpExPacketTypeSequenceStart:
+		packetType = base.RtmpHevcPacketTypeSeqHeader
+		data = msg.Payload[5:]
+	case base.RtmpExPacketTypeCodedFrames:
+		if len(msg.Payload) < 8 {
+			return msg, false
+		}
+		packetType = base.RtmpHevcPacketTypeNalu
+		cts = msg.Payload[5:8]
+		data = msg.Payload[8:]
+	case base.RtmpExPacketTypeCodedFramesX:
+		packetType = base.RtmpHevcPacketTypeNalu
+		data = msg.Payload[5:]
+	case base.RtmpExPacketTypeSequenceEnd:
+		packetType = 2
+	default:
+		return msg, false
+	}
+
+	payload := make([]byte, 5+len(data))
+	payload[0] = frameType<<4 | base.RtmpCodecIdHevc
+	payload[1] = packetType
+	copy(payload[2:5], cts)
+	copy(payload[5:], data)
+
+	out.Header = msg.Header
+	out.Header.MsgLen = uint32(len(payload))
+	out.Payload = payload
+	return out, true
+}
+
+// LegacyRtmpMsg2Enhanced 将传统格式的HEVC转换为Enhanced RTMP格式
+//
+// 不是传统格式的HEVC时，直接返回原msg，否则返回的msg使用新申请的内存块
+func LegacyRtmpMsg2Enhanced(msg base.RtmpMsg) base.RtmpMsg {
+	if msg.Header.MsgTypeId != base.RtmpTypeIdVideo || len(msg.Payload) < 5 || msg.IsEnhanced() ||
+		msg.Payload[0]&0xF != base.RtmpCodecIdHevc {
+		return msg
+	}
+
+	frameType := msg.Payload[0] >> 4
+	var packetType uint8
+	var payload []byte
+	switch msg.Payload[1] {
+	case base.RtmpHevcPacketTypeSeqHeader:
+		packetType = base.RtmpExPacketTypeSequenceStart
+		payload = make([]byte, len(msg.Payload))
+		copy(payload[5:], msg.Payload[5:])
+	case base.RtmpHevcPacketTypeNalu:
+		// 注意，带上CompositionTime，也即总是使用CodedFrames而不是CodedFramesX
+		packetType = base.RtmpExPacketTypeCodedFrames
+		payload = make([]byte, len(msg.Payload)+3)
+		copy(payload[5:], msg.Payload[2:])
+	default:
+		packetType = base.RtmpExPacketTypeSequenceEnd
+		payload = make([]byte, 5)
+	}
+	payload[0] = base.RtmpExHeaderFlag | frameType<<4 | packetType
+	copy(payload[1:5], base.RtmpFourCcHevc)
+
+	var out base.RtmpMsg
+	out.Header = msg.Header
+	out.Header.MsgLen = uint32(len(payload))
+	out.Payload = payload
+	return out
+}
+
+// packEnhancedAudio 打包Enhanced RTMP格式的音频数据，见 base.RtmpSoundFormatExHeader
+func packEnhancedAudio(packetType uint8, fourCc string, data []byte) []byte {
+	payload := make([]byte, 5+len(data))
+	payload[0] = base.RtmpSoundFormatExHeader<<4 | packetType
+	copy(payload[1:5], fourCc)
+	copy(payload[5:], data)
+	return payload
+}
+
+// makeOpusHead 生成Opus的ID Header，见rfc7845 5.1
+//
+// 注意，rtp中的opus没有携带ID Header，这里声道数固定为2，采样率固定为48000，pre-skip为0
+func makeOpusHead() []byte {
+	b := make([]byte, 19)
+	copy(b, "OpusHead")
+	b[8] = 1 // version
+	b[9] = 2 // channel count
+	// b[10:12] pre-skip
+	bele.LePutUint32(b[12:], 48000)
+	// b[16:18] output gain
+	// b[18] channel mapping family
+	return b
+}
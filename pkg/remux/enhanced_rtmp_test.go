@@ -0,0 +1,75 @@
+// Copyright 2023, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package remux
+
+import (
+	"testing"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestEnhancedRtmp(t *testing.T) {
+	newMsg := func(payload ...byte) base.RtmpMsg {
+		return base.RtmpMsg{
+			Header: base.RtmpHeader{
+				MsgTypeId:    base.RtmpTypeIdVideo,
+				MsgLen:       uint32(len(payload)),
+				TimestampAbs: 1000,
+			},
+			Payload: payload,
+		}
+	}
+
+	// seq header
+	legacy := newMsg(base.RtmpHevcKeyFrame, base.RtmpHevcPacketTypeSeqHeader, 0, 0, 0, 0x01, 0x02)
+	enhanced := LegacyRtmpMsg2Enhanced(legacy)
+	assert.Equal(t, []byte{0x90, 'h', 'v', 'c', '1', 0x01, 0x02}, enhanced.Payload)
+	assert.Equal(t, uint32(7), enhanced.Header.MsgLen)
+	assert.Equal(t, true, enhanced.IsEnhanced())
+	assert.Equal(t, base.RtmpFourCcHevc, enhanced.VideoFourCc())
+	assert.Equal(t, base.RtmpCodecIdHevc, enhanced.VideoCodecId())
+	assert.Equal(t, true, enhanced.IsVideoKeySeqHeader())
+	assert.Equal(t, false, enhanced.IsHevcKeySeqHeader())
+	out, ok := EnhancedRtmpMsg2Legacy(enhanced)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, legacy.Payload, out.Payload)
+	assert.Equal(t, true, out.IsHevcKeySeqHeader())
+
+	// 关键帧，带cts
+	legacy = newMsg(base.RtmpHevcKeyFrame, base.RtmpHevcPacketTypeNalu, 0, 0, 40, 0, 0, 0, 1, 0x26)
+	enhanced = LegacyRtmpMsg2Enhanced(legacy)
+	assert.Equal(t, []byte{0x91, 'h', 'v', 'c', '1', 0, 0, 40, 0, 0, 0, 1, 0x26}, enhanced.Payload)
+	assert.Equal(t, true, enhanced.IsVideoKeyNalu())
+	assert.Equal(t, uint32(1040), enhanced.Pts())
+	out, ok = EnhancedRtmpMsg2Legacy(enhanced)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, legacy.Payload, out.Payload)
+	assert.Equal(t, uint32(1040), out.Pts())
+
+	// CodedFramesX，没有cts
+	out, ok = EnhancedRtmpMsg2Legacy(newMsg(0xa3, 'h', 'v', 'c', '1', 0, 0, 0, 1, 0x02))
+	assert.Equal(t, true, ok)
+	assert.Equal(t, []byte{base.RtmpHevcInterFrame, base.RtmpHevcPacketTypeNalu, 0, 0, 0, 0, 0, 0, 1, 0x02}, out.Payload)
+
+	// Metadata在传统格式中没有对应的表示
+	_, ok = EnhancedRtmpMsg2Legacy(newMsg(0x94, 'h', 'v', 'c', '1', 0x02))
+	assert.Equal(t, false, ok)
+
+	// AVC，AV1不做转换
+	avc := newMsg(base.RtmpAvcKeyFrame, base.RtmpAvcPacketTypeNalu, 0, 0, 0, 0x65)
+	assert.Equal(t, avc.Payload, LegacyRtmpMsg2Enhanced(avc).Payload)
+	av1 := newMsg(0x91, 'a', 'v', '0', '1', 0x12, 0x00)
+	out, ok = EnhancedRtmpMsg2Legacy(av1)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, av1.Payload, out.Payload)
+	assert.Equal(t, true, av1.IsVideoKeyNalu())
+	assert.Equal(t, uint8(0), av1.VideoCodecId())
+	assert.Equal(t, uint32(1000), av1.Pts())
+}
@@ -57,7 +57,7 @@ type GopCache struct {
 	MetadataEnsureWithSetDataFrame    []byte
 	MetadataEnsureWithoutSetDataFrame []byte
 	VideoSeqHeader                    []byte
-	AacSeqHeader                      []byte
+	AacSeqHeader                      []byte // 除了AAC，也可能是Enhanced RTMP格式的音频seq header（比如Opus）
 
 	gopRing              []Gop
 	gopRingFirst         int
@@ -104,7 +104,7 @@ func (gc *GopCache) Feed(msg base.RtmpMsg, b []byte) {
 		// noop
 		return
 	case base.RtmpTypeIdAudio:
-		if msg.IsAacSeqHeader() {
+		if msg.IsAudioSeqHeader() {
 			gc.AacSeqHeader = b
 			Log.Debugf("[%s] cache %s aac seq header. size:%d", gc.uniqueKey, gc.t, len(gc.AacSeqHeader))
 			return
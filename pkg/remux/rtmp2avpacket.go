@@ -67,11 +67,17 @@ func (r *Rtmp2AvPacketRemuxer) FeedRtmpMsg(msg base.RtmpMsg, arg interface{}) er
 // ---------------------------------------------------------------------------------------------------------------------
 
 func (r *Rtmp2AvPacketRemuxer) feedVideo(msg base.RtmpMsg, arg interface{}) error {
-	if len(msg.Payload) <= 5 {
+	// Enhanced RTMP格式的HEVC先转换为传统格式
+	msg, ok := EnhancedRtmpMsg2Legacy(msg)
+	if !ok || len(msg.Payload) <= 5 {
 		return nil
 	}
 
-	isH264 := msg.VideoCodecId() == base.RtmpCodecIdAvc
+	codecId := msg.VideoCodecId()
+	if codecId != base.RtmpCodecIdAvc && codecId != base.RtmpCodecIdHevc {
+		return nil
+	}
+	isH264 := codecId == base.RtmpCodecIdAvc
 
 	var err error
 	if msg.IsVideoKeySeqHeader() {
@@ -0,0 +1,262 @@
+// Copyright 2023, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package remux
+
+import (
+	"bytes"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/fmp4"
+)
+
+const (
+	rtmp2Fmp4VideoTrackId = 1
+	rtmp2Fmp4AudioTrackId = 2
+
+	// rtmp2Fmp4AudioFragmentDurationMs 纯音频时，分片的时长
+	rtmp2Fmp4AudioFragmentDurationMs = 1000
+
+	// rtmp2Fmp4DefaultVideoSampleDuration 无法计算视频帧时长时使用的默认值，单位为 fmp4.VideoTimescale
+	rtmp2Fmp4DefaultVideoSampleDuration = 3600
+)
+
+type IRtmp2Fmp4RemuxerObserver interface {
+	// OnFmp4InitSegment 在第一个分片之前回调，track发生变化时会再次回调
+	//
+	// @param tracks: 回调结束后，上层可以持有，但是不允许修改
+	//
+	OnFmp4InitSegment(tracks []*fmp4.Track)
+
+	// OnFmp4MediaSegment
+	//
+	// @param fragments: 视频分片以关键帧开始，纯音频时每个分片约1秒。回调结束后，上层可以持有
+	//
+	OnFmp4MediaSegment(fragments []fmp4.TrackFragment)
+}
+
+// Rtmp2Fmp4Remuxer 输入rtmp流，输出fmp4的init segment和media segment
+//
+// 目前支持H264、H265、AAC
+type Rtmp2Fmp4Remuxer struct {
+	uk       string
+	observer IRtmp2Fmp4RemuxerObserver
+
+	videoTrack *fmp4.Track
+	audioTrack *fmp4.Track
+	opened     bool // 是否已经回调了init segment，并开始了第一个分片
+
+	fragmentStartMs uint32
+
+	// 每个sample的时长需要等到下一个sample到来才能确定，所以先缓存在pending中
+	videoPending      *fmp4.Sample
+	audioPending      *fmp4.Sample
+	videoLastDuration uint32
+	audioLastDuration uint32
+
+	// 当前分片中已经确定时长的sample
+	videoSamples []fmp4.Sample
+	audioSamples []fmp4.Sample
+}
+
+func NewRtmp2Fmp4Remuxer(observer IRtmp2Fmp4RemuxerObserver) *Rtmp2Fmp4Remuxer {
+	r := &Rtmp2Fmp4Remuxer{
+		uk:       base.GenUkRtmp2Fmp4Remuxer(),
+		observer: observer,
+	}
+	Log.Debugf("[%s] NewRtmp2Fmp4Remuxer", r.uk)
+	return r
+}
+
+// FeedRtmpMessage
+//
+// @param msg: msg.Payload 调用结束后，函数内部不会持有这块内存
+func (r *Rtmp2Fmp4Remuxer) FeedRtmpMessage(msg base.RtmpMsg) {
+	switch msg.Header.MsgTypeId {
+	case base.RtmpTypeIdVideo:
+		r.feedVideo(msg)
+	case base.RtmpTypeIdAudio:
+		r.feedAudio(msg)
+	}
+}
+
+// Dispose 输入流结束，将缓存的数据作为最后一个分片回调给上层
+func (r *Rtmp2Fmp4Remuxer) Dispose() {
+	r.flushPending()
+	r.flushFragment()
+}
+
+func (r *Rtmp2Fmp4Remuxer) UniqueKey() string {
+	return r.uk
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func (r *Rtmp2Fmp4Remuxer) feedVideo(msg base.RtmpMsg) {
+	msg, ok := EnhancedRtmpMsg2Legacy(msg)
+	if !ok || len(msg.Payload) <= 5 || msg.IsEnhanced() {
+		return
+	}
+	if msg.IsAvcKeySeqHeader() || msg.IsHevcKeySeqHeader() {
+		var t *fmp4.Track
+		var err error
+		if msg.IsAvcKeySeqHeader() {
+			t, err = fmp4.NewAvcTrack(rtmp2Fmp4VideoTrackId, msg.Payload)
+		} else {
+			t, err = fmp4.NewHevcTrack(rtmp2Fmp4VideoTrackId, msg.Payload)
+		}
+		if err != nil {
+			Log.Errorf("[%s] create video track failed. err=%+v", r.uk, err)
+			return
+		}
+		r.updateTrack(&r.videoTrack, t)
+		return
+	}
+	// 注意，avc和hevc的nalu类型都是1
+	if r.videoTrack == nil || msg.Payload[1] != base.RtmpAvcPacketTypeNalu {
+		return
+	}
+
+	key := msg.IsVideoKeyNalu()
+	sample := fmp4.Sample{
+		Dts:  uint64(msg.Dts()) * 90,
+		Pts:  uint64(msg.Pts()) * 90,
+		Key:  key,
+		Data: append([]byte(nil), msg.Payload[5:]...),
+	}
+	r.feedSample(true, sample, msg.Dts(), key)
+}
+
+func (r *Rtmp2Fmp4Remuxer) feedAudio(msg base.RtmpMsg) {
+	if len(msg.Payload) <= 2 || msg.Payload[0]>>4 != base.RtmpSoundFormatAac {
+		return
+	}
+	if msg.IsAacSeqHeader() {
+		t, err := fmp4.NewAacTrack(rtmp2Fmp4AudioTrackId, msg.Payload[2:])
+		if err != nil {
+			Log.Errorf("[%s] create audio track failed. err=%+v", r.uk, err)
+			return
+		}
+		r.updateTrack(&r.audioTrack, t)
+		return
+	}
+	if r.audioTrack == nil {
+		return
+	}
+
+	dts := uint64(msg.Dts()) * uint64(r.audioTrack.Timescale) / 1000
+	sample := fmp4.Sample{
+		Dts:  dts,
+		Pts:  dts,
+		Key:  true,
+		Data: append([]byte(nil), msg.Payload[2:]...),
+	}
+	boundary := r.videoTrack == nil && (!r.opened || msg.Dts()-r.fragmentStartMs >= rtmp2Fmp4AudioFragmentDurationMs)
+	r.feedSample(false, sample, msg.Dts(), boundary)
+}
+
+// updateTrack track发生变化时，结束当前分片，等到下一个边界处回调新的init segment
+func (r *Rtmp2Fmp4Remuxer) updateTrack(curr **fmp4.Track, t *fmp4.Track) {
+	if *curr != nil && bytes.Equal((*curr).Config, t.Config) {
+		return
+	}
+	Log.Infof("[%s] fmp4 track changed. id=%d, codec=%s", r.uk, t.Id, t.Codec)
+	r.flushPending()
+	r.flushFragment()
+	*curr = t
+	r.opened = false
+}
+
+// feedSample
+//
+// @param boundary: 是否可以作为分片的开始
+func (r *Rtmp2Fmp4Remuxer) feedSample(isVideo bool, sample fmp4.Sample, dtsMs uint32, boundary bool) {
+	pending, lastDuration, samples := &r.audioPending, &r.audioLastDuration, &r.audioSamples
+	if isVideo {
+		pending, lastDuration, samples = &r.videoPending, &r.videoLastDuration, &r.videoSamples
+	}
+
+	// 上一个sample的时长确定了，它属于当前分片（切换分片之前）
+	if *pending != nil {
+		p := *pending
+		if sample.Dts > p.Dts {
+			p.Duration = uint32(sample.Dts - p.Dts)
+			*lastDuration = p.Duration
+		} else {
+			p.Duration = r.estimateSampleDuration(isVideo)
+		}
+		*samples = append(*samples, *p)
+		*pending = nil
+	}
+
+	if boundary {
+		// 注意，另一个track的pending sample留到下一个分片，保证时间戳连续
+		r.flushFragment()
+		if !r.opened {
+			r.observer.OnFmp4InitSegment(r.tracks())
+			r.opened = true
+		}
+		r.fragmentStartMs = dtsMs
+	}
+	if !r.opened {
+		return
+	}
+	*pending = &sample
+}
+
+func (r *Rtmp2Fmp4Remuxer) flushPending() {
+	if r.videoPending != nil {
+		r.videoPending.Duration = r.estimateSampleDuration(true)
+		r.videoSamples = append(r.videoSamples, *r.videoPending)
+		r.videoPending = nil
+	}
+	if r.audioPending != nil {
+		r.audioPending.Duration = r.estimateSampleDuration(false)
+		r.audioSamples = append(r.audioSamples, *r.audioPending)
+		r.audioPending = nil
+	}
+}
+
+func (r *Rtmp2Fmp4Remuxer) flushFragment() {
+	var fragments []fmp4.TrackFragment
+	if r.videoTrack != nil && len(r.videoSamples) > 0 {
+		fragments = append(fragments, fmp4.TrackFragment{Track: r.videoTrack, Samples: r.videoSamples})
+	}
+	if r.audioTrack != nil && len(r.audioSamples) > 0 {
+		fragments = append(fragments, fmp4.TrackFragment{Track: r.audioTrack, Samples: r.audioSamples})
+	}
+	r.videoSamples = nil
+	r.audioSamples = nil
+	if len(fragments) > 0 {
+		r.observer.OnFmp4MediaSegment(fragments)
+	}
+}
+
+func (r *Rtmp2Fmp4Remuxer) tracks() []*fmp4.Track {
+	var tracks []*fmp4.Track
+	if r.videoTrack != nil {
+		tracks = append(tracks, r.videoTrack)
+	}
+	if r.audioTrack != nil {
+		tracks = append(tracks, r.audioTrack)
+	}
+	return tracks
+}
+
+func (r *Rtmp2Fmp4Remuxer) estimateSampleDuration(isVideo bool) uint32 {
+	if isVideo {
+		if r.videoLastDuration > 0 {
+			return r.videoLastDuration
+		}
+		return rtmp2Fmp4DefaultVideoSampleDuration
+	}
+	if r.audioLastDuration > 0 {
+		return r.audioLastDuration
+	}
+	return r.audioTrack.SampleDuration()
+}
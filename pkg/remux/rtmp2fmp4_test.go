@@ -0,0 +1,91 @@
+// Copyright 2023, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package remux_test
+
+import (
+	"testing"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/fmp4"
+	"github.com/q191201771/lal/pkg/remux"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+type fmp4Observer struct {
+	inits     [][]*fmp4.Track
+	fragments [][]fmp4.TrackFragment
+}
+
+func (o *fmp4Observer) OnFmp4InitSegment(tracks []*fmp4.Track) {
+	o.inits = append(o.inits, tracks)
+}
+
+func (o *fmp4Observer) OnFmp4MediaSegment(fragments []fmp4.TrackFragment) {
+	o.fragments = append(o.fragments, fragments)
+}
+
+func TestRtmp2Fmp4Remuxer(t *testing.T) {
+	avcSeqHeader := []byte{
+		0x17, 0x00, 0x00, 0x00, 0x00,
+		0x01, 0x64, 0x00, 0x1F, 0xFF,
+		0xE1, 0x00, 0x0A,
+		0x27, 0x64, 0x00, 0x1F, 0xAC, 0x56, 0x80, 0xB4, 0x0A, 0x19,
+		0x01, 0x00, 0x04,
+		0x28, 0xEE, 0x3C, 0xB0,
+	}
+	newMsg := func(typeId uint8, ts uint32, payload []byte) base.RtmpMsg {
+		return base.RtmpMsg{
+			Header:  base.RtmpHeader{MsgTypeId: typeId, MsgLen: uint32(len(payload)), TimestampAbs: ts},
+			Payload: payload,
+		}
+	}
+
+	var o fmp4Observer
+	r := remux.NewRtmp2Fmp4Remuxer(&o)
+	r.FeedRtmpMessage(newMsg(base.RtmpTypeIdVideo, 0, avcSeqHeader))
+	r.FeedRtmpMessage(newMsg(base.RtmpTypeIdAudio, 0, []byte{0xAF, 0x00, 0x12, 0x10}))
+
+	// 关键帧之前的数据被丢弃
+	r.FeedRtmpMessage(newMsg(base.RtmpTypeIdVideo, 0, []byte{0x27, 0x01, 0x00, 0x00, 0x00, 0x01}))
+	assert.Equal(t, 0, len(o.inits))
+
+	// 视频25fps，每秒一个关键帧
+	for i := 0; i < 50; i++ {
+		ts := uint32(40 + i*40)
+		r.FeedRtmpMessage(newMsg(base.RtmpTypeIdAudio, ts, []byte{0xAF, 0x01, 0x21}))
+		head := byte(0x27)
+		if i%25 == 0 {
+			head = 0x17
+		}
+		r.FeedRtmpMessage(newMsg(base.RtmpTypeIdVideo, ts, []byte{head, 0x01, 0x00, 0x00, 0x00, 0x01}))
+	}
+	assert.Equal(t, 1, len(o.inits))
+	assert.Equal(t, 2, len(o.inits[0]))
+	assert.Equal(t, "avc1.64001f", o.inits[0][0].Codec)
+	assert.Equal(t, 1, len(o.fragments))
+	assert.Equal(t, 25, len(o.fragments[0][0].Samples))
+	assert.Equal(t, true, o.fragments[0][0].Samples[0].Key)
+	assert.Equal(t, uint32(3600), o.fragments[0][0].Samples[0].Duration)
+
+	r.Dispose()
+	assert.Equal(t, 2, len(o.fragments))
+	assert.Equal(t, 25, len(o.fragments[1][0].Samples))
+	assert.Equal(t, uint32(3600), o.fragments[1][0].Samples[24].Duration)
+
+	// 纯音频
+	o = fmp4Observer{}
+	r = remux.NewRtmp2Fmp4Remuxer(&o)
+	r.FeedRtmpMessage(newMsg(base.RtmpTypeIdAudio, 0, []byte{0xAF, 0x00, 0x12, 0x10}))
+	for i := 0; i < 100; i++ {
+		r.FeedRtmpMessage(newMsg(base.RtmpTypeIdAudio, uint32(i*23), []byte{0xAF, 0x01, 0x21}))
+	}
+	r.Dispose()
+	assert.Equal(t, 1, len(o.inits))
+	assert.Equal(t, 3, len(o.fragments))
+}
@@ -59,6 +59,7 @@ type Rtmp2MpegtsRemuxer struct {
 	videoOut      []byte // Annexb
 	spspps        []byte // Annexb 也可能是vps+sps+pps
 	ascCtx        *aac.AscContext
+	rawAudio      bool // 音频是否为G711A，G711U，MP3，Opus，这些音频不需要通过音频头打包
 	audioCc       uint8
 	videoCc       uint8
 	basicAudioDts uint64
@@ -130,6 +131,10 @@ func NewRtmp2MpegtsRemuxer(observer IRtmp2MpegtsRemuxerObserver) *Rtmp2MpegtsRem
 //
 // @param msg: msg.Payload 调用结束后，函数内部不会持有这块内存
 func (s *Rtmp2MpegtsRemuxer) FeedRtmpMessage(msg base.RtmpMsg) {
+	msg, ok := EnhancedRtmpMsg2Legacy(msg)
+	if !ok {
+		return
+	}
 	s.filter.Push(msg)
 }
 
@@ -197,7 +202,7 @@ func (s *Rtmp2MpegtsRemuxer) feedVideo(msg base.RtmpMsg) {
 		return
 	}
 
-	codecId := msg.Payload[0] & 0xF
+	codecId := msg.VideoCodecId()
 	if codecId != base.RtmpCodecIdAvc && codecId != base.RtmpCodecIdHevc {
 		return
 	}
@@ -381,7 +386,21 @@ func (s *Rtmp2MpegtsRemuxer) feedAudio(msg base.RtmpMsg) {
 		Log.Warnf("[%s] rtmp msg too short, ignore. header=%+v, payload=%s", s.uk, msg.Header, hex.Dump(msg.Payload))
 		return
 	}
-	if msg.Payload[0]>>4 != base.RtmpSoundFormatAac {
+	switch msg.AudioPayloadType() {
+	case base.AvPacketPtAac:
+		// 继续处理
+	case base.AvPacketPtG711A, base.AvPacketPtG711U, base.AvPacketPtMp3:
+		s.rawAudio = true
+		s.cacheAudioFrame(msg, msg.Payload[1:])
+		return
+	case base.AvPacketPtOpus:
+		if msg.ExPacketType() != base.RtmpExPacketTypeCodedFrames {
+			return
+		}
+		s.rawAudio = true
+		s.cacheAudioFrame(msg, mpegts.AppendOpusControlHeader(nil, len(msg.Payload)-5), msg.Payload[5:])
+		return
+	default:
 		return
 	}
 
@@ -399,6 +418,12 @@ func (s *Rtmp2MpegtsRemuxer) feedAudio(msg base.RtmpMsg) {
 		return
 	}
 
+	adtsHeader := s.ascCtx.PackAdtsHeader(int(msg.Header.MsgLen - 2))
+	s.cacheAudioFrame(msg, adtsHeader, msg.Payload[2:])
+}
+
+// cacheAudioFrame 将音频帧数据追加到音频缓存中，必要时先吐出已缓存的音频数据
+func (s *Rtmp2MpegtsRemuxer) cacheAudioFrame(msg base.RtmpMsg, data ...[]byte) {
 	pts := uint64(msg.Header.TimestampAbs) * 90
 
 	if !s.audioCacheEmpty() && s.audioCacheFirstFramePts+maxAudioCacheDelayByAudio < pts {
@@ -409,9 +434,9 @@ func (s *Rtmp2MpegtsRemuxer) feedAudio(msg base.RtmpMsg) {
 		s.audioCacheFirstFramePts = pts
 	}
 
-	adtsHeader := s.ascCtx.PackAdtsHeader(int(msg.Header.MsgLen - 2))
-	s.audioCacheFrames = append(s.audioCacheFrames, adtsHeader...)
-	s.audioCacheFrames = append(s.audioCacheFrames, msg.Payload[2:]...)
+	for _, item := range data {
+		s.audioCacheFrames = append(s.audioCacheFrames, item...)
+	}
 }
 
 func (s *Rtmp2MpegtsRemuxer) cacheAacSeqHeader(msg base.RtmpMsg) error {
@@ -421,7 +446,7 @@ func (s *Rtmp2MpegtsRemuxer) cacheAacSeqHeader(msg base.RtmpMsg) error {
 }
 
 func (s *Rtmp2MpegtsRemuxer) audioSeqHeaderCached() bool {
-	return s.ascCtx != nil
+	return s.ascCtx != nil || s.rawAudio
 }
 
 func (s *Rtmp2MpegtsRemuxer) appendSpsPps(out []byte) ([]byte, error) {
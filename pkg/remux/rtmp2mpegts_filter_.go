@@ -23,7 +23,8 @@ type rtmp2MpegtsFilter struct {
 	data       []base.RtmpMsg
 	observer   iRtmp2MpegtsFilterObserver
 
-	audioCodecId int
+	hasAudio     bool
+	audioPt      base.AvPacketPt
 	videoCodecId int
 	done         bool
 }
@@ -50,7 +51,7 @@ func newRtmp2MpegtsFilter(maxMsgSize int, observer iRtmp2MpegtsFilterObserver) *
 		maxMsgSize:   maxMsgSize,
 		data:         make([]base.RtmpMsg, maxMsgSize)[0:0],
 		observer:     observer,
-		audioCodecId: -1,
+		audioPt:      base.AvPacketPtUnknown,
 		videoCodecId: -1,
 		done:         false,
 	}
@@ -69,12 +70,13 @@ func (q *rtmp2MpegtsFilter) Push(msg base.RtmpMsg) {
 
 	switch msg.Header.MsgTypeId {
 	case base.RtmpTypeIdAudio:
-		q.audioCodecId = int(msg.Payload[0] >> 4)
+		q.hasAudio = true
+		q.audioPt = msg.AudioPayloadType()
 	case base.RtmpTypeIdVideo:
-		q.videoCodecId = int(msg.Payload[0] & 0xF)
+		q.videoCodecId = int(msg.VideoCodecId())
 	}
 
-	if q.videoCodecId != -1 && q.audioCodecId != -1 {
+	if q.videoCodecId != -1 && q.hasAudio {
 		q.drain()
 		return
 	}
@@ -88,15 +90,12 @@ func (q *rtmp2MpegtsFilter) Push(msg base.RtmpMsg) {
 // ---------------------------------------------------------------------------------------------------------------------
 
 func (q *rtmp2MpegtsFilter) drain() {
-	switch q.videoCodecId {
-	case int(base.RtmpCodecIdAvc):
-		q.observer.onPatPmt(mpegts.FixedFragmentHeader)
-	case int(base.RtmpCodecIdHevc):
-		q.observer.onPatPmt(mpegts.FixedFragmentHeaderHevc)
-	default:
-		// TODO(chef) 正确处理只有音频或只有视频的情况 #56
-		q.observer.onPatPmt(mpegts.FixedFragmentHeader)
+	// TODO(chef) 正确处理只有音频或只有视频的情况 #56
+	videoPt := base.AvPacketPtAvc
+	if q.videoCodecId == int(base.RtmpCodecIdHevc) {
+		videoPt = base.AvPacketPtHevc
 	}
+	q.observer.onPatPmt(mpegts.NewFixedFragmentHeader(videoPt, q.audioPt))
 	for i := range q.data {
 		q.observer.onPop(q.data[i])
 	}
@@ -66,6 +66,12 @@ func NewRtmp2RtspRemuxer(onSdp OnSdp, onRtpPacket OnRtpPacket) *Rtmp2RtspRemuxer
 func (r *Rtmp2RtspRemuxer) FeedRtmpMsg(msg base.RtmpMsg) {
 	var err error
 
+	// Enhanced RTMP格式的HEVC转换为传统格式，其他Enhanced RTMP格式的编码（AV1，VP9）暂不支持转换为rtsp
+	msg, ok := EnhancedRtmpMsg2Legacy(msg)
+	if !ok || msg.IsEnhanced() {
+		return
+	}
+
 	switch msg.Header.MsgTypeId {
 	case base.RtmpTypeIdMetadata:
 		return
@@ -104,6 +110,11 @@ func (r *Rtmp2RtspRemuxer) FeedRtmpMsg(msg base.RtmpMsg) {
 			return
 		}
 
+		// G711，Opus，MP3不需要音频头，由音频数据确定音频类型
+		if pt := msg.AudioPayloadType(); pt != base.AvPacketPtAac && pt != base.AvPacketPtUnknown {
+			r.audioPt = pt
+		}
+
 		r.msgCache = append(r.msgCache, msg.Clone())
 		r.doAnalyze()
 		return
@@ -113,7 +124,7 @@ func (r *Rtmp2RtspRemuxer) FeedRtmpMsg(msg base.RtmpMsg) {
 
 	// 音视频头已通过sdp回调，rtp数据中不再包含音视频头
 	// TODO(chef): [opt] RtspRemuxerAddSpsPps2KeyFrameFlag 开启时，考虑更新sps 202207
-	if msg.IsAvcKeySeqHeader() || msg.IsHevcKeySeqHeader() || msg.IsAacSeqHeader() {
+	if msg.IsAvcKeySeqHeader() || msg.IsHevcKeySeqHeader() || msg.IsAudioSeqHeader() {
 		return
 	}
 
@@ -136,7 +147,7 @@ func (r *Rtmp2RtspRemuxer) doAnalyze() {
 		}
 
 		// 回调sdp
-		ctx, err := sdp.Pack(r.vps, r.sps, r.pps, r.asc)
+		ctx, err := sdp.PackWithAudioPt(r.vps, r.sps, r.pps, r.audioPt, r.asc)
 		Log.Assert(nil, err)
 		r.onSdp(ctx)
 
@@ -154,7 +165,7 @@ func (r *Rtmp2RtspRemuxer) doAnalyze() {
 func (r *Rtmp2RtspRemuxer) isAnalyzeEnough() bool {
 	// 音视频头都收集好了
 	// 注意，这里故意只判断sps和pps，从而同时支持h264和2h65的情况
-	if r.sps != nil && r.pps != nil && r.asc != nil {
+	if r.sps != nil && r.pps != nil && (r.asc != nil || r.isNonAacAudio()) {
 		return true
 	}
 
@@ -173,10 +184,23 @@ func (r *Rtmp2RtspRemuxer) remux(msg base.RtmpMsg) {
 	case base.RtmpTypeIdAudio:
 		packer = r.getAudioPacker()
 		if packer != nil {
+			// 注意，G711和MP3的rtmp音频头只有1字节，Opus的rtmp音频头为5字节
+			var payload []byte
+			switch r.audioPt {
+			case base.AvPacketPtAac:
+				payload = msg.Payload[2:]
+			case base.AvPacketPtOpus:
+				if !msg.IsEnhancedAudio() || msg.ExPacketType() != base.RtmpExPacketTypeCodedFrames {
+					return
+				}
+				payload = msg.Payload[5:]
+			default:
+				payload = msg.Payload[1:]
+			}
 			rtppkts = packer.Pack(base.AvPacket{
 				Timestamp:   int64(msg.Header.TimestampAbs),
 				PayloadType: r.audioPt,
-				Payload:     msg.Payload[2:],
+				Payload:     payload,
 			})
 		}
 	case base.RtmpTypeIdVideo:
@@ -206,6 +230,21 @@ func (r *Rtmp2RtspRemuxer) remux(msg base.RtmpMsg) {
 }
 
 func (r *Rtmp2RtspRemuxer) getAudioPacker() *rtprtcp.RtpPacker {
+	if r.isNonAacAudio() {
+		if r.audioPacker == nil {
+			r.audioSsrc = rand.Uint32()
+			switch r.audioPt {
+			case base.AvPacketPtOpus:
+				r.audioPacker = rtprtcp.NewRtpPacker(rtprtcp.NewRtpPackerPayloadRaw(), 48000, r.audioSsrc)
+			case base.AvPacketPtMp3:
+				r.audioPacker = rtprtcp.NewRtpPacker(rtprtcp.NewRtpPackerPayloadMpa(), 90000, r.audioSsrc)
+			default:
+				r.audioPacker = rtprtcp.NewRtpPacker(rtprtcp.NewRtpPackerPayloadRaw(), 8000, r.audioSsrc)
+			}
+		}
+		return r.audioPacker
+	}
+
 	if r.asc == nil {
 		return nil
 	}
@@ -230,6 +269,15 @@ func (r *Rtmp2RtspRemuxer) getAudioPacker() *rtprtcp.RtpPacker {
 	return r.audioPacker
 }
 
+// isNonAacAudio 音频是否为G711A，G711U，Opus，MP3，这些音频不需要从seq header中获取asc
+func (r *Rtmp2RtspRemuxer) isNonAacAudio() bool {
+	switch r.audioPt {
+	case base.AvPacketPtG711A, base.AvPacketPtG711U, base.AvPacketPtOpus, base.AvPacketPtMp3:
+		return true
+	}
+	return false
+}
+
 func (r *Rtmp2RtspRemuxer) getVideoPacker() *rtprtcp.RtpPacker {
 	if r.sps == nil {
 		return nil
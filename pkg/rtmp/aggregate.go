@@ -0,0 +1,105 @@
+// Copyright 2023, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package rtmp
+
+import (
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/naza/pkg/bele"
+)
+
+// Aggregate Message(type 22)
+//
+// 包体由多个sub message组成，每个sub message的格式与flv tag相同：
+//
+//	Type      [1B]
+//	Length    [3B] Data的大小
+//	Timestamp [3B] 低24位
+//	TsExt     [1B] 高8位
+//	StreamId  [3B]
+//	Data      [Length B]
+//	PrevSize  [4B] 11+Length
+//
+// 注意，sub message的时间戳只用于计算与第一个sub message的差值，第一个sub message的时间戳等于aggregate message的时间戳。
+//
+// 读取方向的拆分见 ChunkComposer.RunLoop
+
+const aggregateSubMessageHeaderSize = 11
+
+// AggregateMessageMerger 将多个音视频message合并为一个aggregate message，用于减少chunk头的开销
+type AggregateMessageMerger struct {
+	maxDurationMs uint32
+	maxSize       int
+	onAggregate   func(msg base.RtmpMsg)
+
+	buf     []byte
+	firstTs uint32
+}
+
+// NewAggregateMessageMerger
+//
+// @param maxDurationMs: 缓存的message的时间跨度达到该值时，吐出aggregate message
+//
+// @param maxSize: 吐出的aggregate message包体的最大大小，注意，单个message超过该值时，依然会作为一个aggregate message吐出
+//
+// @param onAggregate: 回调结束后，内部不再使用`msg`内存块
+func NewAggregateMessageMerger(maxDurationMs int, maxSize int, onAggregate func(msg base.RtmpMsg)) *AggregateMessageMerger {
+	return &AggregateMessageMerger{
+		maxDurationMs: uint32(maxDurationMs),
+		maxSize:       maxSize,
+		onAggregate:   onAggregate,
+	}
+}
+
+// Feed 只应该输入音视频message
+//
+// @param msg: 函数调用结束后，内部不持有`msg`内存块
+func (m *AggregateMessageMerger) Feed(msg base.RtmpMsg) {
+	ts := msg.Header.TimestampAbs
+	if len(m.buf) != 0 {
+		// 时间戳回退，时间跨度或大小达到阈值时，先吐出已缓存的数据
+		if ts < m.firstTs || ts-m.firstTs >= m.maxDurationMs ||
+			len(m.buf)+aggregateSubMessageHeaderSize+len(msg.Payload)+4 > m.maxSize {
+			m.Flush()
+		}
+	}
+
+	if len(m.buf) == 0 {
+		m.firstTs = ts
+	}
+
+	var h [aggregateSubMessageHeaderSize]byte
+	h[0] = msg.Header.MsgTypeId
+	bele.BePutUint24(h[1:], uint32(len(msg.Payload)))
+	bele.BePutUint24(h[4:], ts&0xFFFFFF)
+	h[7] = uint8(ts >> 24)
+	bele.BePutUint24(h[8:], uint32(msg.Header.MsgStreamId))
+	m.buf = append(m.buf, h[:]...)
+	m.buf = append(m.buf, msg.Payload...)
+	var prevSize [4]byte
+	bele.BePutUint32(prevSize[:], uint32(aggregateSubMessageHeaderSize+len(msg.Payload)))
+	m.buf = append(m.buf, prevSize[:]...)
+}
+
+// Flush 吐出已缓存的数据
+func (m *AggregateMessageMerger) Flush() {
+	if len(m.buf) == 0 {
+		return
+	}
+
+	var msg base.RtmpMsg
+	msg.Header.Csid = CsidVideo
+	msg.Header.MsgLen = uint32(len(m.buf))
+	msg.Header.MsgTypeId = base.RtmpTypeIdAggregateMessage
+	msg.Header.MsgStreamId = Msid1
+	msg.Header.TimestampAbs = m.firstTs
+	msg.Payload = m.buf
+	m.onAggregate(msg)
+
+	m.buf = m.buf[0:0]
+}
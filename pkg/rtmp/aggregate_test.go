@@ -0,0 +1,81 @@
+// Copyright 2023, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package rtmp
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestAggregateMessage(t *testing.T) {
+	makeMsg := func(typeId uint8, ts uint32, n int) base.RtmpMsg {
+		payload := make([]byte, n)
+		for i := range payload {
+			payload[i] = byte(int(ts) + i)
+		}
+		return base.RtmpMsg{
+			Header: base.RtmpHeader{
+				Csid:         CsidVideo,
+				MsgLen:       uint32(n),
+				MsgTypeId:    typeId,
+				MsgStreamId:  Msid1,
+				TimestampAbs: ts,
+			},
+			Payload: payload,
+		}
+	}
+
+	var in []base.RtmpMsg
+	in = append(in, makeMsg(base.RtmpTypeIdVideo, 1000, 100))
+	in = append(in, makeMsg(base.RtmpTypeIdAudio, 1010, 20))
+	in = append(in, makeMsg(base.RtmpTypeIdVideo, 1040, 200))
+	in = append(in, makeMsg(base.RtmpTypeIdAudio, 1150, 20)) // 时间跨度达到阈值
+	in = append(in, makeMsg(base.RtmpTypeIdVideo, 1160, 3000))
+	in = append(in, makeMsg(base.RtmpTypeIdVideo, 1170, 3000)) // 大小超过阈值
+	in = append(in, makeMsg(base.RtmpTypeIdVideo, 1100, 10))   // 时间戳回退
+
+	var chunks []byte
+	var aggregateCount int
+	merger := NewAggregateMessageMerger(100, 4096, func(msg base.RtmpMsg) {
+		assert.Equal(t, base.RtmpTypeIdAggregateMessage, msg.Header.MsgTypeId)
+		aggregateCount++
+		chunks = append(chunks, Message2Chunks(msg.Payload, &msg.Header)...)
+	})
+	for _, msg := range in {
+		merger.Feed(msg)
+	}
+	merger.Flush()
+	assert.Equal(t, 4, aggregateCount)
+
+	for _, reuse := range []bool{true, false} {
+		var out []base.RtmpMsg
+		composer := NewChunkComposer()
+		composer.SetPeerChunkSize(uint32(LocalChunkSize))
+		composer.SetReuseBufferFlag(reuse)
+		_ = composer.RunLoop(bytes.NewReader(chunks), func(stream *Stream) error {
+			msg := stream.toAvMsg()
+			if reuse {
+				msg = msg.Clone()
+			}
+			out = append(out, msg)
+			return nil
+		})
+
+		assert.Equal(t, len(in), len(out))
+		for i := range in {
+			assert.Equal(t, in[i].Header.MsgTypeId, out[i].Header.MsgTypeId)
+			assert.Equal(t, in[i].Header.MsgLen, out[i].Header.MsgLen)
+			assert.Equal(t, in[i].Header.TimestampAbs, out[i].Header.TimestampAbs)
+			assert.Equal(t, in[i].Payload, out[i].Payload)
+		}
+	}
+}
@@ -27,20 +27,23 @@ import (
 )
 
 const (
-	Amf0TypeMarkerNumber     = uint8(0x00)
-	Amf0TypeMarkerBoolean    = uint8(0x01)
-	Amf0TypeMarkerString     = uint8(0x02)
-	Amf0TypeMarkerObject     = uint8(0x03)
-	Amf0TypeMarkerNull       = uint8(0x05)
-	Amf0TypeMarkerEcmaArray  = uint8(0x08)
-	Amf0TypeMarkerObjectEnd  = uint8(0x09) // end for both Object and Array
-	Amf0TypeMarkerLongString = uint8(0x0c)
+	Amf0TypeMarkerNumber      = uint8(0x00)
+	Amf0TypeMarkerBoolean     = uint8(0x01)
+	Amf0TypeMarkerString      = uint8(0x02)
+	Amf0TypeMarkerObject      = uint8(0x03)
+	Amf0TypeMarkerNull        = uint8(0x05)
+	Amf0TypeMarkerEcmaArray   = uint8(0x08)
+	Amf0TypeMarkerObjectEnd   = uint8(0x09) // end for both Object and Array
+	Amf0TypeMarkerStrictArray = uint8(0x0a)
+	Amf0TypeMarkerLongString  = uint8(0x0c)
+
+	// Amf0TypeMarkerAvmplusObject 后面跟着的value是amf3编码的，见amf3.go
+	Amf0TypeMarkerAvmplusObject = uint8(0x11)
 
 	// 还没用到的类型
 	//Amf0TypeMarkerMovieclip   = uint8(0x04)
 	//Amf0TypeMarkerUndefined   = uint8(0x06)
 	//Amf0TypeMarkerReference   = uint8(0x07)
-	//Amf0TypeMarkerStrictArray = uint8(0x0a)
 	//Amf0TypeMarkerData        = uint8(0x0b)
 	//Amf0TypeMarkerUnsupported = uint8(0x0d)
 	//Amf0TypeMarkerRecordset   = uint8(0x0e)
@@ -183,6 +186,10 @@ func (amf0) WriteObject(writer io.Writer, opa ObjectPairArray) error {
 			if err := Amf0.WriteBoolean(writer, opa[i].Value.(bool)); err != nil {
 				return err
 			}
+		case ObjectPairArray:
+			if err := Amf0.WriteObject(writer, opa[i].Value.(ObjectPairArray)); err != nil {
+				return err
+			}
 		default:
 			Log.Panicf("unknown value type. i=%d, v=%+v", i, opa[i].Value)
 		}
@@ -235,6 +242,9 @@ func (amf0) ReadString(b []byte) (val string, l int, err error) {
 	case Amf0TypeMarkerLongString:
 		val, l, err = Amf0.ReadLongStringWithoutType(b[1:])
 		l++
+	case Amf0TypeMarkerAvmplusObject:
+		val, l, err = Amf3.ReadString(b[1:])
+		l++
 	default:
 		err = base.NewErrAmfInvalidType(b[0])
 	}
@@ -242,6 +252,13 @@ func (amf0) ReadString(b []byte) (val string, l int, err error) {
 }
 
 func (amf0) ReadNumber(b []byte) (float64, int, error) {
+	if len(b) >= 1 && b[0] == Amf0TypeMarkerAvmplusObject {
+		v, l, err := Amf3.ReadNumber(b[1:])
+		if err != nil {
+			return 0, 0, err
+		}
+		return v, l + 1, nil
+	}
 	if len(b) < 9 {
 		return 0, 0, nazaerrors.Wrap(base.ErrAmfTooShort)
 	}
@@ -252,6 +269,13 @@ func (amf0) ReadNumber(b []byte) (float64, int, error) {
 }
 
 func (amf0) ReadBoolean(b []byte) (bool, int, error) {
+	if len(b) >= 1 && b[0] == Amf0TypeMarkerAvmplusObject {
+		v, l, err := Amf3.ReadBoolean(b[1:])
+		if err != nil {
+			return false, 0, err
+		}
+		return v, l + 1, nil
+	}
 	if len(b) < 2 {
 		return false, 0, nazaerrors.Wrap(base.ErrAmfTooShort)
 	}
@@ -265,6 +289,13 @@ func (amf0) ReadNull(b []byte) (int, error) {
 	if len(b) < 1 {
 		return 0, nazaerrors.Wrap(base.ErrAmfTooShort)
 	}
+	if b[0] == Amf0TypeMarkerAvmplusObject {
+		l, err := Amf3.ReadNull(b[1:])
+		if err != nil {
+			return 0, err
+		}
+		return l + 1, nil
+	}
 	if b[0] != Amf0TypeMarkerNull {
 		return 0, base.NewErrAmfInvalidType(b[0])
 	}
@@ -275,6 +306,13 @@ func (amf0) ReadObject(b []byte) (ObjectPairArray, int, error) {
 	if len(b) < 1 {
 		return nil, 0, nazaerrors.Wrap(base.ErrAmfTooShort)
 	}
+	if b[0] == Amf0TypeMarkerAvmplusObject {
+		v, l, err := Amf3.ReadObject(b[1:])
+		if err != nil {
+			return nil, 0, err
+		}
+		return v, l + 1, nil
+	}
 	if b[0] != Amf0TypeMarkerObject {
 		return nil, 0, base.NewErrAmfInvalidType(b[0])
 	}
@@ -337,6 +375,20 @@ func (amf0) ReadObject(b []byte) (ObjectPairArray, int, error) {
 			}
 			ops = append(ops, ObjectPair{k, v})
 			index += l
+		case Amf0TypeMarkerStrictArray:
+			v, l, err := Amf0.ReadStrictArray(b[index:])
+			if err != nil {
+				return nil, 0, err
+			}
+			ops = append(ops, ObjectPair{k, v})
+			index += l
+		case Amf0TypeMarkerAvmplusObject:
+			v, l, err := Amf3.ReadValue(b[index+1:])
+			if err != nil {
+				return nil, 0, err
+			}
+			ops = append(ops, ObjectPair{k, v})
+			index += l + 1
 		default:
 			Log.Panicf("unknown type. vt=%d, hex=%s", vt, hex.Dump(nazabytes.Prefix(b, 4096)))
 		}
@@ -396,6 +448,13 @@ func (amf0) ReadArray(b []byte) (ObjectPairArray, int, error) {
 				return nil, 0, err
 			}
 			index += l
+		case Amf0TypeMarkerAvmplusObject:
+			v, l, err := Amf3.ReadValue(b[index+1:])
+			if err != nil {
+				return nil, 0, err
+			}
+			ops = append(ops, ObjectPair{k, v})
+			index += l + 1
 		default:
 			Log.Panicf("unknown type. vt=%d", vt)
 		}
@@ -409,6 +468,77 @@ func (amf0) ReadArray(b []byte) (ObjectPairArray, int, error) {
 	return ops, index, nil
 }
 
+// ReadStrictArray
+//
+// 比如Enhanced RTMP的connect信令中的`fourCcList`字段
+//
+// @return 数组元素目前支持string, float64, bool, ObjectPairArray类型，null元素会被忽略
+func (amf0) ReadStrictArray(b []byte) ([]interface{}, int, error) {
+	if len(b) < 5 {
+		return nil, 0, nazaerrors.Wrap(base.ErrAmfTooShort)
+	}
+	if b[0] != Amf0TypeMarkerStrictArray {
+		return nil, 0, base.NewErrAmfInvalidType(b[0])
+	}
+	count := int(bele.BeUint32(b[1:]))
+
+	index := 5
+	var vs []interface{}
+	for i := 0; i < count; i++ {
+		if len(b)-index < 1 {
+			return nil, 0, nazaerrors.Wrap(base.ErrAmfTooShort)
+		}
+		switch b[index] {
+		case Amf0TypeMarkerString:
+			v, l, err := Amf0.ReadString(b[index:])
+			if err != nil {
+				return nil, 0, err
+			}
+			vs = append(vs, v)
+			index += l
+		case Amf0TypeMarkerNumber:
+			v, l, err := Amf0.ReadNumber(b[index:])
+			if err != nil {
+				return nil, 0, err
+			}
+			vs = append(vs, v)
+			index += l
+		case Amf0TypeMarkerBoolean:
+			v, l, err := Amf0.ReadBoolean(b[index:])
+			if err != nil {
+				return nil, 0, err
+			}
+			vs = append(vs, v)
+			index += l
+		case Amf0TypeMarkerObject:
+			v, l, err := Amf0.ReadObject(b[index:])
+			if err != nil {
+				return nil, 0, err
+			}
+			vs = append(vs, v)
+			index += l
+		case Amf0TypeMarkerNull:
+			l, err := Amf0.ReadNull(b[index:])
+			if err != nil {
+				return nil, 0, err
+			}
+			index += l
+		case Amf0TypeMarkerAvmplusObject:
+			v, l, err := Amf3.ReadValue(b[index+1:])
+			if err != nil {
+				return nil, 0, err
+			}
+			if v != nil {
+				vs = append(vs, v)
+			}
+			index += l + 1
+		default:
+			return nil, 0, base.NewErrAmfInvalidType(b[index])
+		}
+	}
+	return vs, index, nil
+}
+
 func (amf0) ReadObjectOrArray(b []byte) (ObjectPairArray, int, error) {
 	if len(b) < 1 {
 		return nil, 0, nazaerrors.Wrap(base.ErrAmfTooShort)
@@ -418,6 +548,8 @@ func (amf0) ReadObjectOrArray(b []byte) (ObjectPairArray, int, error) {
 		return Amf0.ReadObject(b)
 	case Amf0TypeMarkerEcmaArray:
 		return Amf0.ReadArray(b)
+	case Amf0TypeMarkerAvmplusObject:
+		return Amf0.ReadObject(b)
 	}
 	return nil, 0, base.NewErrAmfInvalidType(b[0])
 }
@@ -119,6 +119,23 @@ func TestAmf0_ReadArray(t *testing.T) {
 	Log.Debug(ops)
 }
 
+func TestAmf0_ReadStrictArray(t *testing.T) {
+	// Enhanced RTMP connect中的fourCcList
+	out := &bytes.Buffer{}
+	out.Write([]byte{Amf0TypeMarkerObject, 0x00, 0x0a})
+	out.WriteString("fourCcList")
+	out.Write([]byte{Amf0TypeMarkerStrictArray, 0x00, 0x00, 0x00, 0x03})
+	_ = Amf0.WriteString(out, "av01")
+	_ = Amf0.WriteString(out, "vp09")
+	_ = Amf0.WriteString(out, "hvc1")
+	out.Write(Amf0TypeMarkerObjectEndBytes)
+
+	ops, l, err := Amf0.ReadObject(out.Bytes())
+	assert.Equal(t, nil, err)
+	assert.Equal(t, out.Len(), l)
+	assert.Equal(t, []interface{}{"av01", "vp09", "hvc1"}, ops.Find("fourCcList"))
+}
+
 func TestAmf0_ReadCase1(t *testing.T) {
 	// ZLMediaKit connect result的object中存在null type
 	// https://github.com/q191201771/lal/issues/102
@@ -0,0 +1,595 @@
+// Copyright 2023, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package rtmp
+
+// amf3.go
+// @pure
+// 提供amf3格式的编码与解码的操作
+//
+// rtmp中amf3的使用方式：
+// - CommandMessageAmf3(17)和DataMessageAmf3(15)的包体，第一个字节为0，后面依然是amf0编码的数据
+// - amf0数据中，类型为Amf0TypeMarkerAvmplusObject的value，后面跟着的是amf3编码的数据
+//
+// amf0中的相关读取函数已经处理了Amf0TypeMarkerAvmplusObject的情况，上层一般不需要直接使用amf3
+
+import (
+	"io"
+	"math"
+	"strconv"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/naza/pkg/bele"
+	"github.com/q191201771/naza/pkg/nazaerrors"
+)
+
+const (
+	Amf3TypeMarkerUndefined = uint8(0x00)
+	Amf3TypeMarkerNull      = uint8(0x01)
+	Amf3TypeMarkerFalse     = uint8(0x02)
+	Amf3TypeMarkerTrue      = uint8(0x03)
+	Amf3TypeMarkerInteger   = uint8(0x04)
+	Amf3TypeMarkerDouble    = uint8(0x05)
+	Amf3TypeMarkerString    = uint8(0x06)
+	Amf3TypeMarkerXmlDoc    = uint8(0x07)
+	Amf3TypeMarkerDate      = uint8(0x08)
+	Amf3TypeMarkerArray     = uint8(0x09)
+	Amf3TypeMarkerObject    = uint8(0x0a)
+	Amf3TypeMarkerXml       = uint8(0x0b)
+	Amf3TypeMarkerByteArray = uint8(0x0c)
+
+	// 还没用到的类型
+	//Amf3TypeMarkerVectorInt    = uint8(0x0d)
+	//Amf3TypeMarkerVectorUint   = uint8(0x0e)
+	//Amf3TypeMarkerVectorDouble = uint8(0x0f)
+	//Amf3TypeMarkerVectorObject = uint8(0x10)
+	//Amf3TypeMarkerDictionary   = uint8(0x11)
+)
+
+const (
+	amf3IntegerMax = 0x0FFFFFFF
+	amf3IntegerMin = -0x10000000
+)
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+type amf3 struct{}
+
+var Amf3 amf3
+
+func (amf3) WriteNull(writer io.Writer) error {
+	_, err := writer.Write([]byte{Amf3TypeMarkerNull})
+	return err
+}
+
+func (amf3) WriteBoolean(writer io.Writer, b bool) error {
+	v := Amf3TypeMarkerFalse
+	if b {
+		v = Amf3TypeMarkerTrue
+	}
+	_, err := writer.Write([]byte{v})
+	return err
+}
+
+// WriteNumber
+//
+// 整数并且在amf3 integer的范围内时，使用integer类型，否则使用double类型
+func (amf3) WriteNumber(writer io.Writer, val float64) error {
+	if val == math.Trunc(val) && val >= amf3IntegerMin && val <= amf3IntegerMax {
+		_, err := writer.Write(amf3AppendU29([]byte{Amf3TypeMarkerInteger}, uint32(int32(val))))
+		return err
+	}
+	if _, err := writer.Write([]byte{Amf3TypeMarkerDouble}); err != nil {
+		return err
+	}
+	return bele.WriteBe(writer, val)
+}
+
+func (amf3) WriteString(writer io.Writer, val string) error {
+	_, err := writer.Write(amf3AppendStringWithoutType([]byte{Amf3TypeMarkerString}, val))
+	return err
+}
+
+// WriteObject 写入匿名的dynamic object
+//
+// 注意，写入时不使用引用
+//
+// @param opa: value支持string, int, float64, bool, ObjectPairArray, nil类型
+func (amf3) WriteObject(writer io.Writer, opa ObjectPairArray) error {
+	// 0x0b: U29O-traits, 不是引用，traits不是引用，不是externalizable，dynamic，0个sealed member
+	if _, err := writer.Write([]byte{Amf3TypeMarkerObject, 0x0b}); err != nil {
+		return err
+	}
+	// 匿名对象的class name为空字符串
+	if _, err := writer.Write(amf3AppendStringWithoutType(nil, "")); err != nil {
+		return err
+	}
+	if err := amf3WritePairs(writer, opa); err != nil {
+		return err
+	}
+	_, err := writer.Write(amf3AppendStringWithoutType(nil, ""))
+	return err
+}
+
+// WriteEcmaArray 写入只包含关联部分的array
+//
+// @param opa: 同 WriteObject
+func (amf3) WriteEcmaArray(writer io.Writer, opa ObjectPairArray) error {
+	// dense部分的元素个数为0
+	if _, err := writer.Write(amf3AppendU29([]byte{Amf3TypeMarkerArray}, 0<<1|1)); err != nil {
+		return err
+	}
+	if err := amf3WritePairs(writer, opa); err != nil {
+		return err
+	}
+	_, err := writer.Write(amf3AppendStringWithoutType(nil, ""))
+	return err
+}
+
+func amf3WritePairs(writer io.Writer, opa ObjectPairArray) error {
+	for i := 0; i < len(opa); i++ {
+		if _, err := writer.Write(amf3AppendStringWithoutType(nil, opa[i].Key)); err != nil {
+			return err
+		}
+		var err error
+		switch v := opa[i].Value.(type) {
+		case string:
+			err = Amf3.WriteString(writer, v)
+		case int:
+			err = Amf3.WriteNumber(writer, float64(v))
+		case float64:
+			err = Amf3.WriteNumber(writer, v)
+		case bool:
+			err = Amf3.WriteBoolean(writer, v)
+		case ObjectPairArray:
+			err = Amf3.WriteObject(writer, v)
+		case nil:
+			err = Amf3.WriteNull(writer)
+		default:
+			Log.Panicf("unknown value type. i=%d, v=%+v", i, opa[i].Value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ----------------------------------------------------------------------------
+// read类型的方法集合
+//
+// 与amf0相同，从输入参数<b>切片中读取函数名所指定的amf类型数据
+// 注意，方法内部不会修改输入参数<b>切片的内容
+//
+// 每次调用使用独立的引用表，这与amf0中Amf0TypeMarkerAvmplusObject的语义一致
+//
+// amf3类型与返回的go类型的对应关系：
+// - undefined, null: nil
+// - false, true: bool
+// - integer, double, date: float64，date为距离1970的毫秒数
+// - string, xml-doc, xml: string
+// - byte-array: []byte，为独立申请的内存块
+// - object: ObjectPairArray，包含sealed和dynamic成员
+// - array: 只有dense部分时为[]interface{}，否则为ObjectPairArray，dense部分的key为元素下标
+
+func (amf3) ReadValue(b []byte) (interface{}, int, error) {
+	r := amf3Reader{b: b}
+	v, err := r.readValue()
+	if err != nil {
+		return nil, 0, err
+	}
+	return v, r.pos, nil
+}
+
+func (amf3) ReadString(b []byte) (string, int, error) {
+	if len(b) < 1 {
+		return "", 0, nazaerrors.Wrap(base.ErrAmfTooShort)
+	}
+	switch b[0] {
+	case Amf3TypeMarkerString, Amf3TypeMarkerXmlDoc, Amf3TypeMarkerXml:
+	default:
+		return "", 0, base.NewErrAmfInvalidType(b[0])
+	}
+	v, l, err := Amf3.ReadValue(b)
+	if err != nil {
+		return "", 0, err
+	}
+	s, _ := v.(string)
+	return s, l, nil
+}
+
+func (amf3) ReadNumber(b []byte) (float64, int, error) {
+	if len(b) < 1 {
+		return 0, 0, nazaerrors.Wrap(base.ErrAmfTooShort)
+	}
+	if b[0] != Amf3TypeMarkerInteger && b[0] != Amf3TypeMarkerDouble {
+		return 0, 0, base.NewErrAmfInvalidType(b[0])
+	}
+	v, l, err := Amf3.ReadValue(b)
+	if err != nil {
+		return 0, 0, err
+	}
+	return v.(float64), l, nil
+}
+
+func (amf3) ReadBoolean(b []byte) (bool, int, error) {
+	if len(b) < 1 {
+		return false, 0, nazaerrors.Wrap(base.ErrAmfTooShort)
+	}
+	switch b[0] {
+	case Amf3TypeMarkerFalse:
+		return false, 1, nil
+	case Amf3TypeMarkerTrue:
+		return true, 1, nil
+	}
+	return false, 0, base.NewErrAmfInvalidType(b[0])
+}
+
+func (amf3) ReadNull(b []byte) (int, error) {
+	if len(b) < 1 {
+		return 0, nazaerrors.Wrap(base.ErrAmfTooShort)
+	}
+	if b[0] != Amf3TypeMarkerNull && b[0] != Amf3TypeMarkerUndefined {
+		return 0, base.NewErrAmfInvalidType(b[0])
+	}
+	return 1, nil
+}
+
+// ReadObject 读取object或array
+func (amf3) ReadObject(b []byte) (ObjectPairArray, int, error) {
+	if len(b) < 1 {
+		return nil, 0, nazaerrors.Wrap(base.ErrAmfTooShort)
+	}
+	if b[0] != Amf3TypeMarkerObject && b[0] != Amf3TypeMarkerArray {
+		return nil, 0, base.NewErrAmfInvalidType(b[0])
+	}
+	v, l, err := Amf3.ReadValue(b)
+	if err != nil {
+		return nil, 0, err
+	}
+	switch vv := v.(type) {
+	case ObjectPairArray:
+		return vv, l, nil
+	case []interface{}:
+		// 只有dense部分的array
+		ops := make(ObjectPairArray, 0, len(vv))
+		for i, item := range vv {
+			ops = append(ops, ObjectPair{Key: strconv.Itoa(i), Value: item})
+		}
+		return ops, l, nil
+	}
+	// 引用到了自身或者还未读取完的对象
+	return nil, l, nil
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+type amf3Traits struct {
+	className      string
+	externalizable bool
+	dynamic        bool
+	members        []string
+}
+
+type amf3Reader struct {
+	b   []byte
+	pos int
+
+	stringRefs []string
+	objectRefs []interface{}
+	traitsRefs []amf3Traits
+}
+
+func (r *amf3Reader) readValue() (interface{}, error) {
+	if len(r.b)-r.pos < 1 {
+		return nil, nazaerrors.Wrap(base.ErrAmfTooShort)
+	}
+	marker := r.b[r.pos]
+	r.pos++
+
+	switch marker {
+	case Amf3TypeMarkerUndefined, Amf3TypeMarkerNull:
+		return nil, nil
+	case Amf3TypeMarkerFalse:
+		return false, nil
+	case Amf3TypeMarkerTrue:
+		return true, nil
+	case Amf3TypeMarkerInteger:
+		u, err := r.readU29()
+		if err != nil {
+			return nil, err
+		}
+		// 29位有符号整型
+		v := int32(u<<3) >> 3
+		return float64(v), nil
+	case Amf3TypeMarkerDouble:
+		return r.readDouble()
+	case Amf3TypeMarkerString:
+		return r.readStringWithoutType()
+	case Amf3TypeMarkerXmlDoc, Amf3TypeMarkerXml:
+		return r.readXml()
+	case Amf3TypeMarkerDate:
+		return r.readDate()
+	case Amf3TypeMarkerArray:
+		return r.readArray()
+	case Amf3TypeMarkerObject:
+		return r.readObject()
+	case Amf3TypeMarkerByteArray:
+		return r.readByteArray()
+	}
+	return nil, base.NewErrAmfInvalidType(marker)
+}
+
+func (r *amf3Reader) readU29() (uint32, error) {
+	var v uint32
+	for i := 0; i < 4; i++ {
+		if len(r.b)-r.pos < 1 {
+			return 0, nazaerrors.Wrap(base.ErrAmfTooShort)
+		}
+		c := r.b[r.pos]
+		r.pos++
+		// 第4个字节的8位都是数据
+		if i == 3 {
+			return v<<8 | uint32(c), nil
+		}
+		v = v<<7 | uint32(c&0x7F)
+		if c&0x80 == 0 {
+			break
+		}
+	}
+	return v, nil
+}
+
+func (r *amf3Reader) readDouble() (float64, error) {
+	if len(r.b)-r.pos < 8 {
+		return 0, nazaerrors.Wrap(base.ErrAmfTooShort)
+	}
+	v := bele.BeFloat64(r.b[r.pos:])
+	r.pos += 8
+	return v, nil
+}
+
+// readRef 读取U29，最低位为0时表示引用
+//
+// @return isRef为true时，val为引用的下标，否则为去掉标志位后的值
+func (r *amf3Reader) readRef() (val uint32, isRef bool, err error) {
+	u, err := r.readU29()
+	if err != nil {
+		return 0, false, err
+	}
+	return u >> 1, u&1 == 0, nil
+}
+
+func (r *amf3Reader) readBytes(n uint32) ([]byte, error) {
+	if uint32(len(r.b)-r.pos) < n {
+		return nil, nazaerrors.Wrap(base.ErrAmfTooShort)
+	}
+	v := r.b[r.pos : r.pos+int(n)]
+	r.pos += int(n)
+	return v, nil
+}
+
+func (r *amf3Reader) getObjectRef(index uint32) (interface{}, error) {
+	if int(index) >= len(r.objectRefs) {
+		return nil, base.ErrAmf3InvalidReference
+	}
+	return r.objectRefs[index], nil
+}
+
+func (r *amf3Reader) readStringWithoutType() (string, error) {
+	v, isRef, err := r.readRef()
+	if err != nil {
+		return "", err
+	}
+	if isRef {
+		if int(v) >= len(r.stringRefs) {
+			return "", base.ErrAmf3InvalidReference
+		}
+		return r.stringRefs[v], nil
+	}
+	b, err := r.readBytes(v)
+	if err != nil {
+		return "", err
+	}
+	s := string(b)
+	// 空字符串不加入引用表
+	if s != "" {
+		r.stringRefs = append(r.stringRefs, s)
+	}
+	return s, nil
+}
+
+func (r *amf3Reader) readXml() (interface{}, error) {
+	v, isRef, err := r.readRef()
+	if err != nil {
+		return nil, err
+	}
+	if isRef {
+		return r.getObjectRef(v)
+	}
+	b, err := r.readBytes(v)
+	if err != nil {
+		return nil, err
+	}
+	s := string(b)
+	r.objectRefs = append(r.objectRefs, s)
+	return s, nil
+}
+
+func (r *amf3Reader) readDate() (interface{}, error) {
+	v, isRef, err := r.readRef()
+	if err != nil {
+		return nil, err
+	}
+	if isRef {
+		return r.getObjectRef(v)
+	}
+	d, err := r.readDouble()
+	if err != nil {
+		return nil, err
+	}
+	r.objectRefs = append(r.objectRefs, d)
+	return d, nil
+}
+
+func (r *amf3Reader) readByteArray() (interface{}, error) {
+	v, isRef, err := r.readRef()
+	if err != nil {
+		return nil, err
+	}
+	if isRef {
+		return r.getObjectRef(v)
+	}
+	b, err := r.readBytes(v)
+	if err != nil {
+		return nil, err
+	}
+	ret := append([]byte(nil), b...)
+	r.objectRefs = append(r.objectRefs, ret)
+	return ret, nil
+}
+
+// readPairs 读取key-value对，直到key为空字符串
+func (r *amf3Reader) readPairs(ops ObjectPairArray) (ObjectPairArray, error) {
+	for {
+		k, err := r.readStringWithoutType()
+		if err != nil {
+			return nil, err
+		}
+		if k == "" {
+			return ops, nil
+		}
+		v, err := r.readValue()
+		if err != nil {
+			return nil, err
+		}
+		ops = append(ops, ObjectPair{Key: k, Value: v})
+	}
+}
+
+func (r *amf3Reader) readArray() (interface{}, error) {
+	count, isRef, err := r.readRef()
+	if err != nil {
+		return nil, err
+	}
+	if isRef {
+		return r.getObjectRef(count)
+	}
+
+	// 先占位，读取完成后再填充
+	index := len(r.objectRefs)
+	r.objectRefs = append(r.objectRefs, nil)
+
+	ops, err := r.readPairs(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var ret interface{}
+	if len(ops) == 0 {
+		var vs []interface{}
+		for i := uint32(0); i < count; i++ {
+			v, err := r.readValue()
+			if err != nil {
+				return nil, err
+			}
+			vs = append(vs, v)
+		}
+		ret = vs
+	} else {
+		for i := uint32(0); i < count; i++ {
+			v, err := r.readValue()
+			if err != nil {
+				return nil, err
+			}
+			ops = append(ops, ObjectPair{Key: strconv.Itoa(int(i)), Value: v})
+		}
+		ret = ops
+	}
+	r.objectRefs[index] = ret
+	return ret, nil
+}
+
+func (r *amf3Reader) readObject() (interface{}, error) {
+	v, isRef, err := r.readRef()
+	if err != nil {
+		return nil, err
+	}
+	if isRef {
+		return r.getObjectRef(v)
+	}
+
+	var traits amf3Traits
+	if v&1 == 0 {
+		// traits引用
+		index := v >> 1
+		if int(index) >= len(r.traitsRefs) {
+			return nil, base.ErrAmf3InvalidReference
+		}
+		traits = r.traitsRefs[index]
+	} else {
+		traits.externalizable = v&2 != 0
+		traits.dynamic = v&4 != 0
+		memberCount := v >> 3
+		if traits.className, err = r.readStringWithoutType(); err != nil {
+			return nil, err
+		}
+		for i := uint32(0); i < memberCount; i++ {
+			member, err := r.readStringWithoutType()
+			if err != nil {
+				return nil, err
+			}
+			traits.members = append(traits.members, member)
+		}
+		r.traitsRefs = append(r.traitsRefs, traits)
+	}
+
+	// externalizable对象的格式由具体的类自定义，无法解析
+	if traits.externalizable {
+		return nil, nazaerrors.Wrap(base.ErrAmf3Unsupported)
+	}
+
+	index := len(r.objectRefs)
+	r.objectRefs = append(r.objectRefs, nil)
+
+	var ops ObjectPairArray
+	for _, member := range traits.members {
+		mv, err := r.readValue()
+		if err != nil {
+			return nil, err
+		}
+		ops = append(ops, ObjectPair{Key: member, Value: mv})
+	}
+	if traits.dynamic {
+		if ops, err = r.readPairs(ops); err != nil {
+			return nil, err
+		}
+	}
+	r.objectRefs[index] = ops
+	return ops, nil
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func amf3AppendU29(out []byte, v uint32) []byte {
+	v &= 0x1FFFFFFF
+	switch {
+	case v < 0x80:
+		return append(out, byte(v))
+	case v < 0x4000:
+		return append(out, byte(v>>7|0x80), byte(v&0x7F))
+	case v < 0x200000:
+		return append(out, byte(v>>14|0x80), byte(v>>7|0x80), byte(v&0x7F))
+	}
+	return append(out, byte(v>>22|0x80), byte(v>>15|0x80), byte(v>>8|0x80), byte(v))
+}
+
+func amf3AppendStringWithoutType(out []byte, val string) []byte {
+	out = amf3AppendU29(out, uint32(len(val))<<1|1)
+	return append(out, val...)
+}
@@ -0,0 +1,163 @@
+// Copyright 2023, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package rtmp_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/q191201771/lal/pkg/base"
+	. "github.com/q191201771/lal/pkg/rtmp"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestAmf3_WriteNumber_ReadNumber(t *testing.T) {
+	cases := []float64{
+		0,
+		1,
+		127,
+		128,
+		300,
+		-1,
+		0x0FFFFFFF,
+		-0x10000000,
+		0x10000000,
+		1e10,
+		1.5,
+	}
+	for _, item := range cases {
+		out := &bytes.Buffer{}
+		err := Amf3.WriteNumber(out, item)
+		assert.Equal(t, nil, err)
+		v, l, err := Amf3.ReadNumber(out.Bytes())
+		assert.Equal(t, nil, err)
+		assert.Equal(t, item, v)
+		assert.Equal(t, out.Len(), l)
+	}
+}
+
+func TestAmf3_WriteObject_ReadObject(t *testing.T) {
+	opa := ObjectPairArray{
+		{Key: "app", Value: "live"},
+		{Key: "objectEncoding", Value: float64(3)},
+		{Key: "fpad", Value: false},
+		{Key: "sub", Value: ObjectPairArray{{Key: "x", Value: 1.5}}},
+		{Key: "none", Value: nil},
+	}
+
+	out := &bytes.Buffer{}
+	err := Amf3.WriteObject(out, opa)
+	assert.Equal(t, nil, err)
+	v, l, err := Amf3.ReadObject(out.Bytes())
+	assert.Equal(t, nil, err)
+	assert.Equal(t, out.Len(), l)
+	assert.Equal(t, opa, v)
+
+	out.Reset()
+	err = Amf3.WriteEcmaArray(out, opa)
+	assert.Equal(t, nil, err)
+	v, l, err = Amf3.ReadObject(out.Bytes())
+	assert.Equal(t, nil, err)
+	assert.Equal(t, out.Len(), l)
+	assert.Equal(t, opa, v)
+}
+
+func TestAmf3_Reference(t *testing.T) {
+	b := []byte{
+		0x09, 0x0b, 0x01, // array, dense count 5, 关联部分为空
+		0x06, 0x07, 'a', 'b', 'c', // "abc"
+		0x06, 0x00, // string引用0
+		0x0a, 0x13, 0x03, 'C', 0x03, 'a', 0x04, 0xff, 0xff, 0xff, 0xff, // sealed object C{a: -1}
+		0x0a, 0x01, 0x06, 0x04, // traits引用0, a为string引用2
+		0x0a, 0x02, // object引用1
+	}
+	v, l, err := Amf3.ReadValue(b)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, len(b), l)
+	assert.Equal(t, []interface{}{
+		"abc",
+		"abc",
+		ObjectPairArray{{Key: "a", Value: float64(-1)}},
+		ObjectPairArray{{Key: "a", Value: "a"}},
+		ObjectPairArray{{Key: "a", Value: float64(-1)}},
+	}, v)
+
+	_, _, err = Amf3.ReadValue([]byte{0x06, 0x02})
+	assert.Equal(t, true, errors.Is(err, base.ErrAmf3InvalidReference))
+	_, _, err = Amf3.ReadValue([]byte{0x06, 0x07, 'a'})
+	assert.Equal(t, true, errors.Is(err, base.ErrAmfTooShort))
+	_, _, err = Amf3.ReadValue([]byte{0x0a, 0x07, 0x03, 'C'})
+	assert.Equal(t, true, errors.Is(err, base.ErrAmf3Unsupported))
+}
+
+func TestAmf0_AvmplusObject(t *testing.T) {
+	// connect命令中的command object为amf3编码
+	out := &bytes.Buffer{}
+	_ = Amf0.WriteString(out, "connect")
+	_ = Amf0.WriteNumber(out, 1)
+	out.WriteByte(Amf0TypeMarkerAvmplusObject)
+	_ = Amf3.WriteObject(out, ObjectPairArray{
+		{Key: "app", Value: "live"},
+		{Key: "objectEncoding", Value: 3},
+	})
+	out.WriteByte(Amf0TypeMarkerAvmplusObject)
+	_ = Amf3.WriteString(out, "test110")
+	b := out.Bytes()
+
+	cmd, l, err := Amf0.ReadString(b)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "connect", cmd)
+	b = b[l:]
+	_, l, err = Amf0.ReadNumber(b)
+	assert.Equal(t, nil, err)
+	b = b[l:]
+	opa, l, err := Amf0.ReadObject(b)
+	assert.Equal(t, nil, err)
+	app, _ := opa.FindString("app")
+	assert.Equal(t, "live", app)
+	oe, _ := opa.FindNumber("objectEncoding")
+	assert.Equal(t, 3, oe)
+	b = b[l:]
+	str, l, err := Amf0.ReadString(b)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "test110", str)
+	assert.Equal(t, len(b), l)
+}
+
+func TestAmf3DataMessage2Metadata(t *testing.T) {
+	out := &bytes.Buffer{}
+	out.WriteByte(0)
+	_ = Amf0.WriteString(out, "@setDataFrame")
+	_ = Amf0.WriteString(out, "onMetaData")
+	out.WriteByte(Amf0TypeMarkerAvmplusObject)
+	_ = Amf3.WriteEcmaArray(out, ObjectPairArray{
+		{Key: "width", Value: 1280},
+		{Key: "height", Value: 720},
+		{Key: "encoder", Value: "Lavf"},
+		{Key: "none", Value: nil},
+	})
+
+	b, err := Amf3DataMessage2Metadata(out.Bytes())
+	assert.Equal(t, nil, err)
+	opa, err := ParseMetadata(b)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, ObjectPairArray{
+		{Key: "width", Value: float64(1280)},
+		{Key: "height", Value: float64(720)},
+		{Key: "encoder", Value: "Lavf"},
+	}, opa)
+
+	out.Reset()
+	out.WriteByte(0)
+	_ = Amf0.WriteString(out, "|RtmpSampleAccess")
+	b, err = Amf3DataMessage2Metadata(out.Bytes())
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, b)
+}
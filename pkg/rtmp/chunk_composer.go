@@ -25,7 +25,7 @@ import (
 // 读取chunk，并合并chunk，生成message返回给上层
 type ChunkComposer struct {
 	peerChunkSize   uint32
-	reuseBufferFlag bool // TODO(chef): [fix] RtmpTypeIdAggregateMessage时，reuseBufferFlag==false的处理 202206
+	reuseBufferFlag bool
 
 	csid2stream map[int]*Stream
 }
@@ -241,7 +241,9 @@ func (c *ChunkComposer) RunLoop(reader io.Reader, cb OnCompleteMessage) error {
 					if stream.msg.Len() < aggregateStream.header.MsgLen {
 						return base.NewErrRtmpShortBuffer(int(aggregateStream.header.MsgLen), int(stream.msg.Len()), "parse rtmp aggregate sub message body")
 					}
+					// 注意，NewBufferRefBytes得到的Buffer中可读数据长度为0，需要Flush
 					aggregateStream.msg.buff = nazabytes.NewBufferRefBytes(stream.msg.buff.Peek(int(aggregateStream.header.MsgLen)))
+					aggregateStream.msg.Flush(aggregateStream.header.MsgLen)
 					stream.msg.Skip(aggregateStream.header.MsgLen)
 
 					// sub message回调给上层
@@ -255,6 +257,14 @@ func (c *ChunkComposer) RunLoop(reader io.Reader, cb OnCompleteMessage) error {
 					}
 					stream.msg.Skip(4)
 				}
+
+				// 注意，sub message的内存块引用的是aggregate message的内存块，
+				// 所以reuseBufferFlag为false时，需要释放整块内存，而不是复用
+				if c.reuseBufferFlag {
+					stream.msg.Reset()
+				} else {
+					stream.msg.ResetAndFree()
+				}
 			} else {
 				if err := cb(stream); err != nil {
 					return err
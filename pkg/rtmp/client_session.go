@@ -367,8 +367,12 @@ func (s *ClientSession) doMsg(stream *Stream) error {
 		return s.doProtocolControlMessage(stream)
 	case base.RtmpTypeIdCommandMessageAmf0:
 		return s.doCommandMessage(stream)
+	case base.RtmpTypeIdCommandMessageAmf3:
+		return s.doCommandAmf3Message(stream)
 	case base.RtmpTypeIdMetadata:
 		return s.doDataMessageAmf0(stream)
+	case base.RtmpTypeIdMetadataAmf3:
+		return s.doDataMessageAmf3(stream)
 	case base.RtmpTypeIdAck:
 		return s.doAck(stream)
 	case base.RtmpTypeIdUserControl:
@@ -421,6 +425,33 @@ func (s *ClientSession) doDataMessageAmf0(stream *Stream) error {
 	return nil
 }
 
+// doDataMessageAmf3 转换为amf0的metadata后，再交给上层
+func (s *ClientSession) doDataMessageAmf3(stream *Stream) error {
+	b, err := Amf3DataMessage2Metadata(stream.msg.buff.Bytes())
+	if err != nil {
+		return err
+	}
+	if b == nil {
+		Log.Debugf("[%s] < R data message amf3, ignore. %s", s.UniqueKey(), stream.toDebugString())
+		return nil
+	}
+
+	msg := stream.toAvMsg()
+	msg.Header.MsgTypeId = base.RtmpTypeIdMetadata
+	msg.Header.MsgLen = uint32(len(b))
+	msg.Payload = b
+	s.onReadRtmpAvMsg(msg)
+	return nil
+}
+
+func (s *ClientSession) doCommandAmf3Message(stream *Stream) error {
+	// 去除前面的0就是amf0的数据，其中的value可能切换为amf3编码，amf0的读取函数内部会处理
+	if stream.msg.Len() > 0 && stream.msg.buff.Bytes()[0] == 0 {
+		stream.msg.Skip(1)
+	}
+	return s.doCommandMessage(stream)
+}
+
 func (s *ClientSession) doCommandMessage(stream *Stream) error {
 	cmd, err := stream.msg.readStringWithType()
 	if err != nil {
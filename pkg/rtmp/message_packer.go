@@ -149,6 +149,23 @@ func (packer *MessagePacker) writeConnectResult(writer io.Writer, tid int, objec
 	return packer.ChunkAndWrite(writer, csidOverConnection, base.RtmpTypeIdCommandMessageAmf0, 0)
 }
 
+// writeConnectError connect信令被拒绝，比如adobe与limelight鉴权
+func (packer *MessagePacker) writeConnectError(writer io.Writer, tid int, description string) error {
+	packer.b.ModWritePos(12)
+
+	_ = Amf0.WriteString(packer.b, "_error")
+	_ = Amf0.WriteNumber(packer.b, float64(tid))
+	_ = Amf0.WriteNull(packer.b)
+	objs := []ObjectPair{
+		{Key: "level", Value: "error"},
+		{Key: "code", Value: "NetConnection.Connect.Rejected"},
+		{Key: "description", Value: description},
+	}
+	_ = Amf0.WriteObject(packer.b, objs)
+
+	return packer.ChunkAndWrite(writer, csidOverConnection, base.RtmpTypeIdCommandMessageAmf0, 0)
+}
+
 func (packer *MessagePacker) writeCreateStream(writer io.Writer) error {
 	packer.b.ModWritePos(12)
 
@@ -236,6 +253,23 @@ func (packer *MessagePacker) writeOnStatusPlay(writer io.Writer, streamid int) e
 	return packer.ChunkAndWrite(writer, csidOverStream, base.RtmpTypeIdCommandMessageAmf0, streamid)
 }
 
+// writeOnStatus 通用的onStatus信令，目前用于点播的seek，pause
+func (packer *MessagePacker) writeOnStatus(writer io.Writer, streamid int, code string, description string) error {
+	packer.b.ModWritePos(12)
+
+	_ = Amf0.WriteString(packer.b, "onStatus")
+	_ = Amf0.WriteNumber(packer.b, 0)
+	_ = Amf0.WriteNull(packer.b)
+	objs := []ObjectPair{
+		{Key: "level", Value: "status"},
+		{Key: "code", Value: code},
+		{Key: "description", Value: description},
+	}
+	_ = Amf0.WriteObject(packer.b, objs)
+
+	return packer.ChunkAndWrite(writer, csidOverStream, base.RtmpTypeIdCommandMessageAmf0, streamid)
+}
+
 func (packer *MessagePacker) writeStreamIsRecorded(writer io.Writer, streamid uint32) error {
 	packer.b.ModWritePos(12)
 
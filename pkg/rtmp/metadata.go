@@ -34,6 +34,63 @@ func ParseMetadata(b []byte) (ObjectPairArray, error) {
 	return opa, err
 }
 
+// Amf3DataMessage2Metadata 将DataMessageAmf3(15)的包体转换为amf0编码的metadata
+//
+// 注意，amf0中不支持的类型的字段会被丢弃
+//
+// @return 返回的内存块为新申请的独立内存块，不包含@setDataFrame
+//
+//	如果不是metadata，返回nil, nil
+func Amf3DataMessage2Metadata(b []byte) ([]byte, error) {
+	// 第一个字节为0时，后面是amf0编码的数据
+	if len(b) > 0 && b[0] == 0 {
+		b = b[1:]
+	}
+
+	pos := 0
+	v, l, err := Amf0.ReadString(b[pos:])
+	if err != nil {
+		return nil, err
+	}
+	pos += l
+	if v == "@setDataFrame" {
+		v, l, err = Amf0.ReadString(b[pos:])
+		if err != nil {
+			return nil, err
+		}
+		pos += l
+	}
+	if v != "onMetaData" {
+		return nil, nil
+	}
+	opa, _, err := Amf0.ReadObjectOrArray(b[pos:])
+	if err != nil {
+		return nil, err
+	}
+
+	buf := nazabytes.NewBuffer(len(b))
+	if err = Amf0.WriteString(buf, "onMetaData"); err != nil {
+		return nil, err
+	}
+	if err = Amf0.WriteObject(buf, amf0CompatibleObject(opa)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func amf0CompatibleObject(opa ObjectPairArray) ObjectPairArray {
+	var ret ObjectPairArray
+	for _, op := range opa {
+		switch v := op.Value.(type) {
+		case string, int, float64, bool:
+			ret = append(ret, op)
+		case ObjectPairArray:
+			ret = append(ret, ObjectPair{Key: op.Key, Value: amf0CompatibleObject(v)})
+		}
+	}
+	return ret
+}
+
 // TODO(chef): [test] MetadataEnsureWithSetDataFrame 这两个函数增加单元测试 202207
 
 // MetadataEnsureWithSdf
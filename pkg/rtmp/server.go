@@ -37,9 +37,10 @@ type IServerObserver interface {
 }
 
 type Server struct {
-	addr     string
-	observer IServerObserver
-	ln       net.Listener
+	addr          string
+	observer      IServerObserver
+	ln            net.Listener
+	authenticator *serverAuthenticator
 }
 
 func NewServer(addr string, observer IServerObserver) *Server {
@@ -49,6 +50,13 @@ func NewServer(addr string, observer IServerObserver) *Server {
 	}
 }
 
+// SetAuthProvider 设置后，推流端在connect阶段需要进行adobe或limelight鉴权
+//
+// 注意，需要在 RunLoop 之前调用
+func (server *Server) SetAuthProvider(provider IServerAuthProvider) {
+	server.authenticator = newServerAuthenticator(provider)
+}
+
 func (server *Server) Listen() (err error) {
 	if server.ln, err = net.Listen("tcp", server.addr); err != nil {
 		return
@@ -94,6 +102,7 @@ func (server *Server) Dispose() {
 func (server *Server) handleTcpConnect(conn net.Conn) {
 	Log.Infof("accept a rtmp connection. remoteAddr=%s", conn.RemoteAddr().String())
 	session := NewServerSession(server, conn)
+	session.authenticator = server.authenticator
 	_ = session.RunLoop()
 	Log.Debugf("rtmp断开, type=%v, streamName=%v", session.sessionStat.BaseType(), session.StreamName())
 	switch session.sessionStat.BaseType() {
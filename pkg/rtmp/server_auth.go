@@ -0,0 +1,242 @@
+// Copyright 2023, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package rtmp
+
+// server_auth.go
+// 服务端在connect阶段的adobe与limelight鉴权
+//
+// adobe:
+//
+//	1. C connect('app')                                                  S _error('code=403 need auth; authmod=adobe')
+//	2. C connect('app?authmod=adobe&user=U')                             S _error('?reason=needauth&user=U&salt=S&challenge=C&opaque=O')
+//	3. C connect('app?authmod=adobe&user=U&challenge=C2&response=R&opaque=O')
+//	   R = base64(md5(base64(md5(U + S + password)) + O + C2))
+//
+// limelight(llnw):
+//
+//	1. C connect('app')                                                  S _error('code=403 need auth; authmod=llnw')
+//	2. C connect('app?authmod=llnw&user=U')                              S _error('?reason=needauth&user=U&nonce=N')
+//	3. C connect('app?authmod=llnw&user=U&nonce=N&cnonce=CN&nc=NC&response=R')
+//	   HA1 = md5hex(U:live:password)
+//	   HA2 = md5hex(publish:/app/_definst_)，app中包含'/'时不加'/_definst_'
+//	   R   = md5hex(HA1:N:NC:CN:auth:HA2)
+//
+// 每个challenge只能使用一次
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/q191201771/naza/pkg/nazamd5"
+)
+
+const (
+	AuthModAdobe = "adobe"
+	AuthModLlnw  = "llnw"
+)
+
+// IServerAuthProvider 服务端adobe与limelight鉴权的用户密码来源
+type IServerAuthProvider interface {
+	// AuthMod
+	//
+	// @return 推流到`appName`时要求的鉴权方式，AuthModAdobe 或 AuthModLlnw，返回空字符串表示不需要鉴权
+	AuthMod(appName string) string
+
+	// Password
+	//
+	// @return ok为false表示用户不存在
+	Password(appName string, user string) (password string, ok bool)
+}
+
+const (
+	serverAuthChallengeExpireMs = 60000
+	serverAuthLlnwRealm         = "live"
+	serverAuthLlnwMethod        = "publish"
+	serverAuthLlnwQop           = "auth"
+)
+
+type serverAuthChallenge struct {
+	user      string
+	salt      string
+	challenge string
+	expireAt  time.Time
+}
+
+type serverAuthResult int
+
+const (
+	serverAuthResultNotRequired serverAuthResult = iota
+	serverAuthResultPass
+	serverAuthResultReject
+)
+
+// serverAuthenticator 由同一个Server下的所有ServerSession共享
+type serverAuthenticator struct {
+	provider IServerAuthProvider
+
+	mutex sync.Mutex
+	// adobe的key为opaque，llnw的key为nonce
+	challenges map[string]serverAuthChallenge
+}
+
+func newServerAuthenticator(provider IServerAuthProvider) *serverAuthenticator {
+	return &serverAuthenticator{
+		provider:   provider,
+		challenges: make(map[string]serverAuthChallenge),
+	}
+}
+
+func (a *serverAuthenticator) authMod(appName string) string {
+	if a == nil {
+		return ""
+	}
+	return a.provider.AuthMod(appName)
+}
+
+// check
+//
+// @param appName:  不包含鉴权参数的app
+// @param rawQuery: connect信令中app携带的参数
+//
+// @return description: 拒绝时，_error信令中的description
+func (a *serverAuthenticator) check(appName string, rawQuery string) (result serverAuthResult, description string) {
+	authMod := a.provider.AuthMod(appName)
+	if authMod != AuthModAdobe && authMod != AuthModLlnw {
+		return serverAuthResultNotRequired, ""
+	}
+
+	q := parseAuthQuery(rawQuery)
+	user := q["user"]
+	if q["authmod"] != authMod || user == "" {
+		return serverAuthResultReject, fmt.Sprintf("[ AccessManager.Reject ] : [ code=403 need auth; authmod=%s ] : ", authMod)
+	}
+
+	password, ok := a.provider.Password(appName, user)
+	if !ok {
+		return serverAuthResultReject, fmt.Sprintf("[ AccessManager.Reject ] : [ authmod=%s ] : ?reason=nosuchuser", authMod)
+	}
+
+	if q["response"] == "" {
+		return serverAuthResultReject, a.newChallenge(authMod, user)
+	}
+
+	var pass bool
+	switch authMod {
+	case AuthModAdobe:
+		pass = a.checkAdobe(user, password, q)
+	case AuthModLlnw:
+		pass = a.checkLlnw(appName, user, password, q)
+	}
+	if !pass {
+		return serverAuthResultReject, fmt.Sprintf("[ AccessManager.Reject ] : [ authmod=%s ] : ?reason=authfailed", authMod)
+	}
+	return serverAuthResultPass, ""
+}
+
+func (a *serverAuthenticator) newChallenge(authMod string, user string) string {
+	c := serverAuthChallenge{
+		user:      user,
+		salt:      randomAuthString(),
+		challenge: randomAuthString(),
+		expireAt:  time.Now().Add(serverAuthChallengeExpireMs * time.Millisecond),
+	}
+	key := randomAuthString()
+
+	a.mutex.Lock()
+	now := time.Now()
+	for k, v := range a.challenges {
+		if now.After(v.expireAt) {
+			delete(a.challenges, k)
+		}
+	}
+	a.challenges[key] = c
+	a.mutex.Unlock()
+
+	if authMod == AuthModAdobe {
+		return fmt.Sprintf("[ AccessManager.Reject ] : [ authmod=adobe ] : ?reason=needauth&user=%s&salt=%s&challenge=%s&opaque=%s",
+			user, c.salt, c.challenge, key)
+	}
+	return fmt.Sprintf("[ AccessManager.Reject ] : [ authmod=llnw ] : ?reason=needauth&user=%s&nonce=%s", user, key)
+}
+
+// takeChallenge 取出并删除challenge
+func (a *serverAuthenticator) takeChallenge(key string, user string) (serverAuthChallenge, bool) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	c, ok := a.challenges[key]
+	if !ok {
+		return c, false
+	}
+	delete(a.challenges, key)
+	if c.user != user || time.Now().After(c.expireAt) {
+		return c, false
+	}
+	return c, true
+}
+
+func (a *serverAuthenticator) checkAdobe(user string, password string, q map[string]string) bool {
+	opaque := q["opaque"]
+	c, ok := a.takeChallenge(opaque, user)
+	if !ok {
+		return false
+	}
+
+	mds := md5.Sum([]byte(user + c.salt + password))
+	salt1 := base64.StdEncoding.EncodeToString(mds[:])
+	mds = md5.Sum([]byte(salt1 + opaque + q["challenge"]))
+	return secureEqual(base64.StdEncoding.EncodeToString(mds[:]), q["response"])
+}
+
+func (a *serverAuthenticator) checkLlnw(appName string, user string, password string, q map[string]string) bool {
+	nonce := q["nonce"]
+	if _, ok := a.takeChallenge(nonce, user); !ok {
+		return false
+	}
+
+	app := appName
+	if i := strings.IndexByte(app, '/'); i != -1 {
+		app = app[:i]
+	}
+	if !strings.Contains(appName, "/") {
+		app += "/_definst_"
+	}
+	ha1 := nazamd5.Md5([]byte(fmt.Sprintf("%s:%s:%s", user, serverAuthLlnwRealm, password)))
+	ha2 := nazamd5.Md5([]byte(fmt.Sprintf("%s:/%s", serverAuthLlnwMethod, app)))
+	response := nazamd5.Md5([]byte(fmt.Sprintf("%s:%s:%s:%s:%s:%s", ha1, nonce, q["nc"], q["cnonce"], serverAuthLlnwQop, ha2)))
+	return secureEqual(response, q["response"])
+}
+
+// secureEqual 比较耗时与内容无关，避免通过响应时间猜测出正确的response
+func secureEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// parseAuthQuery 注意，adobe的response等字段为base64，客户端一般不做url转义，所以这里也不做反转义
+func parseAuthQuery(rawQuery string) map[string]string {
+	ret := make(map[string]string)
+	for _, item := range strings.Split(rawQuery, "&") {
+		if pos := strings.IndexByte(item, '='); pos > 0 {
+			ret[item[:pos]] = item[pos+1:]
+		}
+	}
+	return ret
+}
+
+func randomAuthString() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,111 @@
+// Copyright 2023, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package rtmp
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/q191201771/naza/pkg/assert"
+	"github.com/q191201771/naza/pkg/nazamd5"
+)
+
+type testAuthProvider struct {
+	authMod string
+}
+
+func (p *testAuthProvider) AuthMod(appName string) string {
+	return p.authMod
+}
+
+func (p *testAuthProvider) Password(appName string, user string) (string, bool) {
+	if user == "chef" {
+		return "lal", true
+	}
+	return "", false
+}
+
+type testAuthServerObserver struct {
+	pubCh chan *ServerSession
+}
+
+func (o *testAuthServerObserver) OnRtmpConnect(session *ServerSession, opa ObjectPairArray) {}
+func (o *testAuthServerObserver) OnNewRtmpPubSession(session *ServerSession) error {
+	o.pubCh <- session
+	return nil
+}
+func (o *testAuthServerObserver) OnDelRtmpPubSession(session *ServerSession)       {}
+func (o *testAuthServerObserver) OnNewRtmpSubSession(session *ServerSession) error { return nil }
+func (o *testAuthServerObserver) OnDelRtmpSubSession(session *ServerSession)       {}
+
+func TestServerAuthAdobe(t *testing.T) {
+	observer := &testAuthServerObserver{pubCh: make(chan *ServerSession, 1)}
+	server := NewServer("127.0.0.1:0", observer)
+	server.SetAuthProvider(&testAuthProvider{authMod: AuthModAdobe})
+	assert.Equal(t, nil, server.Listen())
+	defer server.Dispose()
+	go server.RunLoop()
+	addr := server.ln.Addr().String()
+
+	// 使用ClientSession中已有的adobe鉴权逻辑
+	pushSession := NewPushSession(func(option *PushSessionOption) {
+		option.PushTimeoutMs = 3000
+	})
+	err := pushSession.Push(fmt.Sprintf("rtmp://chef:lal@%s/live/test110", addr))
+	assert.Equal(t, nil, err)
+	session := <-observer.pubCh
+	assert.Equal(t, false, strings.Contains(session.AppName(), "?"))
+	assert.Equal(t, "test110", session.StreamName())
+	pushSession.Dispose()
+
+	pushSession = NewPushSession(func(option *PushSessionOption) {
+		option.PushTimeoutMs = 1000
+	})
+	err = pushSession.Push(fmt.Sprintf("rtmp://chef:wrong@%s/live/test110", addr))
+	assert.IsNotNil(t, err)
+	pushSession.Dispose()
+}
+
+func TestServerAuthLlnw(t *testing.T) {
+	a := newServerAuthenticator(&testAuthProvider{authMod: AuthModLlnw})
+
+	result, description := a.check("live", "")
+	assert.Equal(t, serverAuthResultReject, result)
+	assert.Equal(t, true, strings.Contains(description, "code=403 need auth; authmod=llnw"))
+
+	result, description = a.check("live", "authmod=llnw&user=nobody")
+	assert.Equal(t, serverAuthResultReject, result)
+	assert.Equal(t, true, strings.Contains(description, "reason=nosuchuser"))
+
+	result, description = a.check("live", "authmod=llnw&user=chef")
+	assert.Equal(t, serverAuthResultReject, result)
+	pos := strings.Index(description, "nonce=")
+	assert.Equal(t, true, pos != -1)
+	nonce := description[pos+len("nonce="):]
+
+	// 与ffmpeg的rtmpproto.c中do_llnw_auth的计算方式相同
+	calc := func(password string) string {
+		ha1 := nazamd5.Md5([]byte("chef:live:" + password))
+		ha2 := nazamd5.Md5([]byte("publish:/live/_definst_"))
+		return nazamd5.Md5([]byte(fmt.Sprintf("%s:%s:00000001:abcd:auth:%s", ha1, nonce, ha2)))
+	}
+	query := fmt.Sprintf("authmod=llnw&user=chef&nonce=%s&cnonce=abcd&nc=00000001&response=%s", nonce, calc("lal"))
+	result, _ = a.check("live", query)
+	assert.Equal(t, serverAuthResultPass, result)
+
+	// nonce只能使用一次
+	result, description = a.check("live", query)
+	assert.Equal(t, serverAuthResultReject, result)
+	assert.Equal(t, true, strings.Contains(description, "reason=authfailed"))
+
+	a = newServerAuthenticator(&testAuthProvider{authMod: ""})
+	result, _ = a.check("live", "")
+	assert.Equal(t, serverAuthResultNotRequired, result)
+}
@@ -47,6 +47,27 @@ func (s *ServerSession) SetPubSessionObserver(observer IPubSessionObserver) {
 	s.avObserver = observer
 }
 
+// ISubSessionCtrlObserver 拉流端的播放控制信令，目前只有点播使用
+type ISubSessionCtrlObserver interface {
+	// OnSeek
+	//
+	// @param ms 跳转的目标位置，单位毫秒
+	OnSeek(ms int)
+
+	// OnPause
+	//
+	// @param pause true表示暂停，false表示恢复播放
+	// @param ms    暂停或恢复时的播放位置，单位毫秒
+	OnPause(pause bool, ms int)
+}
+
+// SetSubSessionCtrlObserver 注意，需要在 IServerSessionObserver.OnNewRtmpSubSession 回调中设置
+//
+// 没有设置时，seek，pause信令会被忽略
+func (s *ServerSession) SetSubSessionCtrlObserver(observer ISubSessionCtrlObserver) {
+	s.ctrlObserver = observer
+}
+
 type ServerSessionType int
 
 const (
@@ -58,10 +79,11 @@ const (
 type ServerSession struct {
 	url                    string
 	tcUrl                  string
-	streamNameWithRawQuery string // const after set
-	appName                string // const after set
-	streamName             string // const after set
-	rawQuery               string //const after set
+	streamNameWithRawQuery string   // const after set
+	appName                string   // const after set
+	streamName             string   // const after set
+	rawQuery               string   //const after set
+	fourCcList             []string // const after set. 对端在connect信令中声明的支持Enhanced RTMP的编码
 
 	observer      IServerSessionObserver
 	hs            HandshakeServer
@@ -74,6 +96,12 @@ type ServerSession struct {
 	// only for PubSession
 	avObserver IPubSessionObserver
 
+	// only for SubSession
+	ctrlObserver ISubSessionCtrlObserver
+
+	authenticator *serverAuthenticator // 为nil时不进行adobe与limelight鉴权
+	authPassed    bool
+
 	// IsFresh ShouldWaitVideoKeyFrame
 	//
 	// 只有sub类型需要
@@ -162,6 +190,16 @@ func (s *ServerSession) RawQuery() string {
 	return s.rawQuery
 }
 
+// SupportFourCc 对端是否在connect信令的`fourCcList`字段中声明了支持fourCc编码的Enhanced RTMP格式，比如 base.RtmpFourCcHevc
+func (s *ServerSession) SupportFourCc(fourCc string) bool {
+	for _, v := range s.fourCcList {
+		if v == fourCc || v == "*" {
+			return true
+		}
+	}
+	return false
+}
+
 // ----- IObject -------------------------------------------------------------------------------------------------------
 
 func (s *ServerSession) UniqueKey() string {
@@ -218,6 +256,8 @@ func (s *ServerSession) doMsg(stream *Stream) error {
 		return s.doCommandAmf3Message(stream)
 	case base.RtmpTypeIdMetadata:
 		return s.doDataMessageAmf0(stream)
+	case base.RtmpTypeIdMetadataAmf3:
+		return s.doDataMessageAmf3(stream)
 	case base.RtmpTypeIdAck:
 		return s.doAck(stream)
 	case base.RtmpTypeIdUserControl:
@@ -308,6 +348,29 @@ func (s *ServerSession) doDataMessageAmf0(stream *Stream) error {
 	//return nil
 }
 
+// doDataMessageAmf3 转换为amf0的metadata后，再交给上层
+func (s *ServerSession) doDataMessageAmf3(stream *Stream) error {
+	if s.sessionStat.BaseType() != base.SessionBaseTypePubStr {
+		return nazaerrors.Wrap(base.ErrRtmpUnexpectedMsg)
+	}
+
+	b, err := Amf3DataMessage2Metadata(stream.msg.buff.Bytes())
+	if err != nil {
+		return err
+	}
+	if b == nil {
+		Log.Debugf("[%s] < R data message amf3, ignore. %s", s.UniqueKey(), stream.toDebugString())
+		return nil
+	}
+
+	msg := stream.toAvMsg()
+	msg.Header.MsgTypeId = base.RtmpTypeIdMetadata
+	msg.Header.MsgLen = uint32(len(b))
+	msg.Payload = b
+	s.avObserver.OnReadRtmpAvMsg(msg)
+	return nil
+}
+
 func (s *ServerSession) doCommandMessage(stream *Stream) error {
 	cmd, err := stream.msg.readStringWithType()
 	if err != nil {
@@ -327,6 +390,10 @@ func (s *ServerSession) doCommandMessage(stream *Stream) error {
 		return s.doPublish(tid, stream)
 	case "play":
 		return s.doPlay(tid, stream)
+	case "seek":
+		return s.doSeek(tid, stream)
+	case "pause":
+		return s.doPause(tid, stream)
 	case "releaseStream":
 		fallthrough
 	case "FCPublish":
@@ -344,8 +411,10 @@ func (s *ServerSession) doCommandMessage(stream *Stream) error {
 }
 
 func (s *ServerSession) doCommandAmf3Message(stream *Stream) error {
-	//去除前面的0就是Amf0的数据
-	stream.msg.Skip(1)
+	// 去除前面的0就是amf0的数据，其中的value可能切换为amf3编码，amf0的读取函数内部会处理
+	if stream.msg.Len() > 0 && stream.msg.buff.Bytes()[0] == 0 {
+		stream.msg.Skip(1)
+	}
 	return s.doCommandMessage(stream)
 }
 
@@ -362,7 +431,19 @@ func (s *ServerSession) doConnect(tid int, stream *Stream) error {
 	if err != nil {
 		Log.Warnf("[%s] tcUrl not exist.", s.UniqueKey())
 	}
-	Log.Infof("[%s] < R connect('%s'). tcUrl=%s", s.UniqueKey(), s.appName, s.tcUrl)
+	if s.authenticator != nil {
+		if err = s.checkConnectAuth(tid, val); err != nil {
+			return err
+		}
+	}
+	if vs, ok := val.Find("fourCcList").([]interface{}); ok {
+		for _, v := range vs {
+			if fourCc, ok := v.(string); ok {
+				s.fourCcList = append(s.fourCcList, fourCc)
+			}
+		}
+	}
+	Log.Infof("[%s] < R connect('%s'). tcUrl=%s, fourCcList=%v", s.UniqueKey(), s.appName, s.tcUrl, s.fourCcList)
 
 	s.observer.OnRtmpConnect(s, val)
 
@@ -392,6 +473,52 @@ func (s *ServerSession) doConnect(tid int, stream *Stream) error {
 	return nil
 }
 
+// checkConnectAuth adobe与limelight鉴权，见server_auth.go
+//
+// 注意，connect阶段无法确定对端是推流还是拉流，所以只对以下两种情况进行鉴权：
+// - app中携带了authmod参数
+// - flashVer以`FMLE/`开头，也即推流端
+//
+// 其他情况在publish时检查是否已通过鉴权
+func (s *ServerSession) checkConnectAuth(tid int, opa ObjectPairArray) error {
+	appName, rawQuery := s.appName, ""
+	if pos := strings.IndexByte(s.appName, '?'); pos != -1 {
+		appName, rawQuery = s.appName[:pos], s.appName[pos+1:]
+	}
+	flashVer, _ := opa.FindString("flashVer")
+	if !strings.Contains(rawQuery, "authmod=") && !strings.HasPrefix(flashVer, "FMLE/") {
+		return nil
+	}
+
+	result, description := s.authenticator.check(appName, rawQuery)
+	switch result {
+	case serverAuthResultNotRequired:
+		return nil
+	case serverAuthResultPass:
+		Log.Infof("[%s] rtmp connect auth succ. app=%s", s.UniqueKey(), appName)
+		s.authPassed = true
+		// 去掉app和tcUrl中的鉴权参数
+		s.appName = appName
+		if pos := strings.IndexByte(s.tcUrl, '?'); pos != -1 {
+			s.tcUrl = s.tcUrl[:pos]
+		}
+		return nil
+	}
+
+	Log.Warnf("[%s] rtmp connect auth reject. app=%s, description=%s", s.UniqueKey(), s.appName, description)
+
+	// 注意，packer使用LocalChunkSize切分chunk
+	Log.Infof("[%s] > W SetChunkSize %d.", s.UniqueKey(), LocalChunkSize)
+	if err := s.packer.writeChunkSize(s.conn, LocalChunkSize); err != nil {
+		return err
+	}
+	Log.Infof("[%s] > W _error('NetConnection.Connect.Rejected').", s.UniqueKey())
+	if err := s.packer.writeConnectError(s.conn, tid, description); err != nil {
+		return err
+	}
+	return nazaerrors.Wrap(base.ErrRtmpAuthFailed)
+}
+
 func (s *ServerSession) doCreateStream(tid int, stream *Stream) error {
 	Log.Infof("[%s] < R createStream().", s.UniqueKey())
 	Log.Infof("[%s] > W _result().", s.UniqueKey())
@@ -402,6 +529,10 @@ func (s *ServerSession) doCreateStream(tid int, stream *Stream) error {
 }
 
 func (s *ServerSession) doPublish(tid int, stream *Stream) (err error) {
+	if !s.authPassed && s.authenticator.authMod(strings.Split(s.appName, "?")[0]) != "" {
+		Log.Warnf("[%s] publish without rtmp connect auth. app=%s", s.UniqueKey(), s.appName)
+		return nazaerrors.Wrap(base.ErrRtmpAuthFailed)
+	}
 	if err = stream.msg.readNull(); err != nil {
 		return err
 	}
@@ -482,6 +613,70 @@ func (s *ServerSession) doPlay(tid int, stream *Stream) (err error) {
 	return err
 }
 
+func (s *ServerSession) doSeek(tid int, stream *Stream) error {
+	if err := stream.msg.readNull(); err != nil {
+		return err
+	}
+	ms, err := stream.msg.readNumberWithType()
+	if err != nil {
+		return err
+	}
+	Log.Infof("[%s] < R seek(%d).", s.UniqueKey(), ms)
+	if s.ctrlObserver == nil {
+		Log.Warnf("[%s] seek not supported, ignore it.", s.UniqueKey())
+		return nil
+	}
+
+	// 先回复信令，再通知上层从新的位置开始发送数据
+	if err := s.packer.writeStreamBegin(s.conn, Msid1); err != nil {
+		return err
+	}
+	Log.Infof("[%s] > W onStatus('NetStream.Seek.Notify').", s.UniqueKey())
+	if err := s.packer.writeOnStatus(s.conn, Msid1, "NetStream.Seek.Notify", "Seeking"); err != nil {
+		return err
+	}
+	if err := s.packer.writeOnStatusPlay(s.conn, Msid1); err != nil {
+		return err
+	}
+	s.ctrlObserver.OnSeek(ms)
+	return nil
+}
+
+func (s *ServerSession) doPause(tid int, stream *Stream) error {
+	if err := stream.msg.readNull(); err != nil {
+		return err
+	}
+	pause, err := stream.msg.readBooleanWithType()
+	if err != nil {
+		return err
+	}
+	ms, err := stream.msg.readNumberWithType()
+	if err != nil {
+		return err
+	}
+	Log.Infof("[%s] < R pause(%t, %d).", s.UniqueKey(), pause, ms)
+	if s.ctrlObserver == nil {
+		Log.Warnf("[%s] pause not supported, ignore it.", s.UniqueKey())
+		return nil
+	}
+
+	if pause {
+		Log.Infof("[%s] > W onStatus('NetStream.Pause.Notify').", s.UniqueKey())
+		err = s.packer.writeOnStatus(s.conn, Msid1, "NetStream.Pause.Notify", "Paused")
+	} else {
+		if err = s.packer.writeStreamBegin(s.conn, Msid1); err != nil {
+			return err
+		}
+		Log.Infof("[%s] > W onStatus('NetStream.Unpause.Notify').", s.UniqueKey())
+		err = s.packer.writeOnStatus(s.conn, Msid1, "NetStream.Unpause.Notify", "Unpaused")
+	}
+	if err != nil {
+		return err
+	}
+	s.ctrlObserver.OnPause(pause, ms)
+	return nil
+}
+
 func (s *ServerSession) modConnProps() {
 	s.conn.ModWriteChanSize(wChanSize)
 
@@ -104,6 +104,14 @@ func (msg *StreamMsg) readNumberWithType() (int, error) {
 	return int(val), err
 }
 
+func (msg *StreamMsg) readBooleanWithType() (bool, error) {
+	val, l, err := Amf0.ReadBoolean(msg.buff.Bytes())
+	if err == nil {
+		msg.Skip(uint32(l))
+	}
+	return val, err
+}
+
 func (msg *StreamMsg) readObjectWithType() (ObjectPairArray, error) {
 	opa, l, err := Amf0.ReadObject(msg.buff.Bytes())
 	if err == nil {
@@ -28,8 +28,9 @@ func MswLsw2Ntp(msw, lsw uint64) uint64 {
 	return (msw << 32) | lsw
 }
 
-//func UnixNano2Ntp(v uint64) uint64 {
-//	msw := v / 1e9 + offset
-//	lsw := ((v % 1e9) << 32) / 1e9
-//	return (msw << 32) | lsw
-//}
+// UnixNano2Ntp 将Unix时间戳（单位纳秒）转换为ntp时间戳
+func UnixNano2Ntp(v uint64) uint64 {
+	msw := v/1e9 + ntpOffset
+	lsw := ((v % 1e9) << 32) / 1e9
+	return (msw << 32) | lsw
+}
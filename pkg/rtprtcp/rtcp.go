@@ -82,12 +82,42 @@ import (
 //        |                  profile-specific extensions                  |
 //        +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
 
+// ------------------------------------------------------
+// rfc4585 6.2.1 Generic NACK: PT=RTPFB=205, FMT=1
+// ------------------------------------------------------
+//
+//        0                   1                   2                   3
+//        0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+//        +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//        |V=2|P|   FMT   |       PT      |          length               |
+//        +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//        |                  SSRC of packet sender                        |
+//        +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//        |                  SSRC of media source                         |
+//        +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// FCI    |            PID                |             BLP               |
+//        +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//        :                             ...                               :
+//        +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//
+// PID: 丢失的包的seq
+// BLP: 第i位(从最低位开始)为1表示PID+i+1也丢失了
+
 const (
 	RtcpPacketTypeSr  = 200 // 0xc8 Sender Report
 	RtcpPacketTypeRr  = 201 // 0xc9 Receiver Report
 	RtcpPacketTypeApp = 204
 
-	RtcpHeaderLength = 4
+	// rfc4585 6.1 Common Packet Format for Feedback Messages
+	RtcpPacketTypeRtpfb = 205 // 0xcd Transport layer FB message
+	RtcpPacketTypePsfb  = 206 // 0xce Payload-specific FB message
+
+	RtcpRtpfbFmtNack = 1 // Generic NACK
+	RtcpPsfbFmtPli   = 1 // Picture Loss Indication
+
+	RtcpHeaderLength  = 4
+	RtcpSrLength      = 28 // 不包含report block
+	RtcpRrBlockLength = 24
 
 	RtcpVersion = 2
 )
@@ -133,6 +163,65 @@ func ParseSr(b []byte) Sr {
 	return s
 }
 
+// RrBlock rfc3550 6.4.1 report block
+type RrBlock struct {
+	MediaSsrc   uint32 // SSRC of source，即被统计的发送端的ssrc
+	Fraction    uint8
+	Lost        uint32 // 24b, cumulative number of packets lost
+	ExtendedSeq uint32
+	Jitter      uint32 // 单位为rtp时间戳
+	Lsr         uint32
+	Dlsr        uint32 // 单位为1/65536秒
+}
+
+// ParseRr rfc3550 6.4.2
+//
+// @param b rtcp rr包，包含包头，长度不足的report block会被忽略
+func ParseRr(b []byte) (senderSsrc uint32, blocks []RrBlock) {
+	if len(b) < RtcpHeaderLength+4 {
+		return
+	}
+	h := ParseRtcpHeader(b)
+	senderSsrc = bele.BeUint32(b[4:])
+	b = b[8:]
+	for i := 0; i < int(h.CountOrFormat) && len(b) >= RtcpRrBlockLength; i++ {
+		blocks = append(blocks, RrBlock{
+			MediaSsrc:   bele.BeUint32(b),
+			Fraction:    b[4],
+			Lost:        bele.BeUint24(b[5:]),
+			ExtendedSeq: bele.BeUint32(b[8:]),
+			Jitter:      bele.BeUint32(b[12:]),
+			Lsr:         bele.BeUint32(b[16:]),
+			Dlsr:        bele.BeUint32(b[20:]),
+		})
+		b = b[RtcpRrBlockLength:]
+	}
+	return
+}
+
+// ParseNack rfc4585 6.2.1
+//
+// @param b rtcp rtpfb generic nack包，包含包头，调用方保证PT和FMT是正确的
+// @return seqs: 对端请求重传的包的seq
+func ParseNack(b []byte) (senderSsrc, mediaSsrc uint32, seqs []uint16) {
+	if len(b) < RtcpHeaderLength+8 {
+		return
+	}
+	senderSsrc = bele.BeUint32(b[4:])
+	mediaSsrc = bele.BeUint32(b[8:])
+	for b = b[12:]; len(b) >= 4; b = b[4:] {
+		pid := bele.BeUint16(b)
+		blp := bele.BeUint16(b[2:])
+		seqs = append(seqs, pid)
+		for i := uint16(0); i < 16; i++ {
+			if blp&(1<<i) != 0 {
+				seqs = append(seqs, pid+i+1)
+			}
+		}
+	}
+	return
+}
+
 // PackTo @param out 传出参数，注意，调用方保证长度>=4
 func (r *RtcpHeader) PackTo(out []byte) {
 	out[0] = r.Version<<6 | r.Padding<<5 | r.CountOrFormat
@@ -0,0 +1,102 @@
+// Copyright 2023, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package rtprtcp
+
+import "sort"
+
+// 通过收到的rtp包的seq检测丢包，产生rtcp nack包需要的seq列表
+
+type NackProducer struct {
+	maxMissing      int   // 最多跟踪的丢失包个数，也即seq落后于最大seq超过该值的丢失包不再请求重传
+	maxRetries      int   // 每个丢失包最多请求重传的次数
+	retryIntervalNs int64 // 同一个丢失包两次请求重传的最小间隔
+	reorderWaitNs   int64 // 检测到丢包后，等待这么长时间还没有收到（可能只是乱序），才第一次请求重传
+
+	hasMaxSeq bool
+	maxSeq    uint16
+	missing   map[uint16]*nackItem
+}
+
+type nackItem struct {
+	detectUnixNano   int64
+	retries          int
+	lastNackUnixNano int64
+}
+
+func NewNackProducer(maxMissing int, maxRetries int, retryIntervalMs int, reorderWaitMs int) *NackProducer {
+	return &NackProducer{
+		maxMissing:      maxMissing,
+		maxRetries:      maxRetries,
+		retryIntervalNs: int64(retryIntervalMs) * 1e6,
+		reorderWaitNs:   int64(reorderWaitMs) * 1e6,
+		missing:         make(map[uint16]*nackItem),
+	}
+}
+
+// FeedRtpPacket 每次收到rtp包，都将seq序号以及收到时的本地时间传入这个函数
+func (n *NackProducer) FeedRtpPacket(seq uint16, nowUnixNano int64) {
+	if !n.hasMaxSeq {
+		n.hasMaxSeq = true
+		n.maxSeq = seq
+		return
+	}
+
+	diff := SubSeq(seq, n.maxSeq)
+	if diff <= 0 {
+		// 乱序或者重传的包
+		delete(n.missing, seq)
+		return
+	}
+
+	if diff-1 > n.maxMissing {
+		// 跳跃太大，可能是发送端重置了seq，不再请求重传之前的包
+		n.missing = make(map[uint16]*nackItem)
+	} else {
+		for s := n.maxSeq + 1; s != seq; s++ {
+			n.missing[s] = &nackItem{detectUnixNano: nowUnixNano}
+		}
+	}
+	n.maxSeq = seq
+
+	for s := range n.missing {
+		if SubSeq(n.maxSeq, s) > n.maxMissing {
+			delete(n.missing, s)
+		}
+	}
+}
+
+// Produce 获取当前需要请求重传的包
+//
+// @return: 按seq从小到大排序，没有需要请求重传的包时返回nil
+func (n *NackProducer) Produce(nowUnixNano int64) []uint16 {
+	var seqs []uint16
+	for s, item := range n.missing {
+		if item.retries == 0 && nowUnixNano-item.detectUnixNano < n.reorderWaitNs {
+			continue
+		}
+		if item.retries > 0 && nowUnixNano-item.lastNackUnixNano < n.retryIntervalNs {
+			continue
+		}
+		seqs = append(seqs, s)
+		item.retries++
+		item.lastNackUnixNano = nowUnixNano
+		if item.retries >= n.maxRetries {
+			delete(n.missing, s)
+		}
+	}
+	sort.Slice(seqs, func(i, j int) bool {
+		return CompareSeq(seqs[i], seqs[j]) < 0
+	})
+	return seqs
+}
+
+// MissingCount 当前还在等待重传的丢失包个数
+func (n *NackProducer) MissingCount() int {
+	return len(n.missing)
+}
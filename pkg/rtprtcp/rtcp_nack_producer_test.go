@@ -0,0 +1,112 @@
+// Copyright 2023, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package rtprtcp_test
+
+import (
+	"testing"
+
+	"github.com/q191201771/lal/pkg/rtprtcp"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestPackNack(t *testing.T) {
+	seqs := []uint16{65534, 65535, 2, 17, 20}
+	out := rtprtcp.PackNack(1, 0x12345678, seqs)
+	assert.Equal(t, 1, len(out))
+	b := out[0]
+	h := rtprtcp.ParseRtcpHeader(b)
+	assert.Equal(t, uint8(rtprtcp.RtcpPacketTypeRtpfb), h.PacketType)
+	assert.Equal(t, uint8(rtprtcp.RtcpRtpfbFmtNack), h.CountOrFormat)
+	// 65534的blp覆盖到65534+16=14，17和20使用第二个fci
+	assert.Equal(t, 20, len(b))
+	assert.Equal(t, uint16(len(b)/4-1), h.Length)
+
+	senderSsrc, mediaSsrc, parsed := rtprtcp.ParseNack(b)
+	assert.Equal(t, uint32(1), senderSsrc)
+	assert.Equal(t, uint32(0x12345678), mediaSsrc)
+	assert.Equal(t, seqs, parsed)
+
+	// 间隔17，每个seq都需要单独的fci，超过MTU时拆分为多个包
+	seqs = nil
+	for i := 0; i < 400; i++ {
+		seqs = append(seqs, uint16(i*17))
+	}
+	out = rtprtcp.PackNack(1, 0x12345678, seqs)
+	assert.Equal(t, 2, len(out))
+	var all []uint16
+	for _, b := range out {
+		assert.Equal(t, true, len(b) <= rtprtcp.MaxRtpRtcpPacketSize-28)
+		_, _, parsed = rtprtcp.ParseNack(b)
+		all = append(all, parsed...)
+	}
+	assert.Equal(t, seqs, all)
+}
+
+func TestNackProducer(t *testing.T) {
+	const ms = int64(1e6)
+	p := rtprtcp.NewNackProducer(100, 2, 40, 20)
+
+	p.FeedRtpPacket(65533, 0)
+	p.FeedRtpPacket(65534, 0)
+	assert.Equal(t, 0, len(p.Produce(0)))
+
+	// 丢失65535, 0, 1
+	p.FeedRtpPacket(2, 0)
+	assert.Equal(t, 3, p.MissingCount())
+	// 等待乱序包的时间内不请求
+	assert.Equal(t, 0, len(p.Produce(10*ms)))
+	assert.Equal(t, []uint16{65535, 0, 1}, p.Produce(20*ms))
+	// 重试间隔内不再请求
+	assert.Equal(t, 0, len(p.Produce(30*ms)))
+
+	// 乱序或重传到达
+	p.FeedRtpPacket(0, 30*ms)
+	assert.Equal(t, []uint16{65535, 1}, p.Produce(60*ms))
+	// 达到最大重试次数，放弃
+	assert.Equal(t, 0, p.MissingCount())
+
+	// 跳跃太大，不请求重传
+	p.FeedRtpPacket(1000, 60*ms)
+	assert.Equal(t, 0, p.MissingCount())
+
+	// 落后太多的丢失包不再请求重传
+	p.FeedRtpPacket(1002, 100*ms)
+	p.FeedRtpPacket(1090, 100*ms)
+	assert.Equal(t, 88, p.MissingCount())
+	p.FeedRtpPacket(1102, 100*ms)
+	assert.Equal(t, 0, len(p.Produce(110*ms)))
+	seqs := p.Produce(120 * ms)
+	assert.Equal(t, 98, len(seqs))
+	assert.Equal(t, uint16(1003), seqs[0])
+
+	// 等待期间乱序到达的包不会被请求
+	p = rtprtcp.NewNackProducer(100, 2, 40, 20)
+	p.FeedRtpPacket(1, 0)
+	p.FeedRtpPacket(4, 0)
+	p.FeedRtpPacket(2, 5*ms)
+	assert.Equal(t, []uint16{3}, p.Produce(20*ms))
+}
+
+func TestRtpRetransmitBuffer(t *testing.T) {
+	b := rtprtcp.NewRtpRetransmitBuffer(4)
+	_, ok := b.Get(0)
+	assert.Equal(t, false, ok)
+
+	h := rtprtcp.MakeDefaultRtpHeader()
+	for seq := uint16(0); seq < 6; seq++ {
+		h.Seq = seq
+		b.Put(rtprtcp.MakeRtpPacket(h, []byte{uint8(seq)}))
+	}
+	// 已经被覆盖
+	_, ok = b.Get(1)
+	assert.Equal(t, false, ok)
+	pkt, ok := b.Get(5)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, uint16(5), pkt.Header.Seq)
+}
@@ -48,3 +48,102 @@ func (r *Rr) Pack() []byte {
 
 	return b
 }
+
+// Pack 不包含report block
+func (s *Sr) Pack() []byte {
+	b := make([]byte, RtcpSrLength)
+
+	var h RtcpHeader
+	h.Version = RtcpVersion
+	h.Padding = 0
+	h.CountOrFormat = 0
+	h.PacketType = RtcpPacketTypeSr
+	h.Length = RtcpSrLength/4 - 1
+	h.PackTo(b)
+
+	bele.BePutUint32(b[4:], s.SenderSsrc)
+	bele.BePutUint32(b[8:], s.Msw)
+	bele.BePutUint32(b[12:], s.Lsw)
+	bele.BePutUint32(b[16:], s.Timestamp)
+	bele.BePutUint32(b[20:], s.PktCnt)
+	bele.BePutUint32(b[24:], s.OctetCnt)
+
+	return b
+}
+
+// PackPli rfc4585 6.3.1 Picture Loss Indication (PLI)
+//
+// 请求发送端发送关键帧
+func PackPli(senderSsrc, mediaSsrc uint32) []byte {
+	const lenInWords = 3
+
+	b := make([]byte, lenInWords*4)
+
+	var h RtcpHeader
+	h.Version = RtcpVersion
+	h.Padding = 0
+	h.CountOrFormat = RtcpPsfbFmtPli
+	h.PacketType = RtcpPacketTypePsfb
+	h.Length = lenInWords - 1
+	h.PackTo(b)
+
+	bele.BePutUint32(b[4:], senderSsrc)
+	bele.BePutUint32(b[8:], mediaSsrc)
+
+	return b
+}
+
+// nackMaxFciNum 单个nack包最多携带的fci个数，保证包的大小不超过MTU（1500减去IP和UDP头）
+const nackMaxFciNum = (MaxRtpRtcpPacketSize - 28 - 12) / 4
+
+// PackNack rfc4585 6.2.1 Generic NACK
+//
+// 请求发送端重传丢失的包
+//
+// @param seqs: 丢失的包的seq，按从小到大的顺序传入时，打包后的数据最紧凑
+//
+// @return: 丢失的包很多时，拆分为多个nack包，每个包的大小都不超过MTU
+func PackNack(senderSsrc, mediaSsrc uint32, seqs []uint16) (out [][]byte) {
+	var fci []uint16 // pid和blp交替存放
+	for _, seq := range seqs {
+		n := len(fci)
+		if n > 0 {
+			if d := SubSeq(seq, fci[n-2]); d >= 1 && d <= 16 {
+				fci[n-1] |= 1 << (d - 1)
+				continue
+			}
+		}
+		fci = append(fci, seq, 0)
+	}
+
+	for len(fci) > 0 {
+		n := len(fci) / 2
+		if n > nackMaxFciNum {
+			n = nackMaxFciNum
+		}
+		out = append(out, packNack(senderSsrc, mediaSsrc, fci[:n*2]))
+		fci = fci[n*2:]
+	}
+	return
+}
+
+func packNack(senderSsrc, mediaSsrc uint32, fci []uint16) []byte {
+	lenInWords := 3 + len(fci)/2
+	b := make([]byte, lenInWords*4)
+
+	var h RtcpHeader
+	h.Version = RtcpVersion
+	h.Padding = 0
+	h.CountOrFormat = RtcpRtpfbFmtNack
+	h.PacketType = RtcpPacketTypeRtpfb
+	h.Length = uint16(lenInWords - 1)
+	h.PackTo(b)
+
+	bele.BePutUint32(b[4:], senderSsrc)
+	bele.BePutUint32(b[8:], mediaSsrc)
+	for i, v := range fci {
+		bele.BePutUint16(b[12+i*2:], v)
+	}
+
+	return b
+}
@@ -40,6 +40,11 @@ func NewRrProducer(clockRate int) *RrProducer {
 	}
 }
 
+// SetSenderSsrc 设置rr包中本端的ssrc，默认为0
+func (r *RrProducer) SetSenderSsrc(ssrc uint32) {
+	r.senderSsrc = ssrc
+}
+
 // FeedRtpPacket 每次收到rtp包，都将seq序号传入这个函数
 func (r *RrProducer) FeedRtpPacket(seq uint16) {
 	r.received++
@@ -71,13 +76,7 @@ func (r *RrProducer) Produce(lsr uint32) []byte {
 		return nil
 	}
 
-	var lost uint32
-	expected := r.extendedSeq - uint32(r.baseSeq) + 1
-	if expected < r.received {
-		lost = 0
-	} else {
-		lost = expected - r.received
-	}
+	expected, lost := r.expectedAndLost()
 
 	var fraction uint8
 	expectedInterval := expected - r.expectedPrior
@@ -104,6 +103,33 @@ func (r *RrProducer) Produce(lsr uint32) []byte {
 	return rr.Pack()
 }
 
+// RrStat 接收统计
+type RrStat struct {
+	Received uint32 // 收到的rtp包数量
+	Lost     uint32 // 丢失的rtp包数量，根据seq计算，乱序和重复包可能导致该值偏小
+	Jitter   uint32
+}
+
+// GetStat 获取当前的接收统计，不影响 Produce 中的丢包率计算
+func (r *RrProducer) GetStat() RrStat {
+	var stat RrStat
+	if r.baseSeq == -1 {
+		return stat
+	}
+	_, stat.Lost = r.expectedAndLost()
+	stat.Received = r.received
+	stat.Jitter = r.getJitter()
+	return stat
+}
+
+func (r *RrProducer) expectedAndLost() (expected, lost uint32) {
+	expected = r.extendedSeq - uint32(r.baseSeq) + 1
+	if expected > r.received {
+		lost = expected - r.received
+	}
+	return
+}
+
 // @param timestamp 当前收到的rtp包头中的时间戳
 func (r *RrProducer) updateJitter(timestamp uint32) {
 	// rfc3550 6.4.1 SR: Sender Report RTCP Packet
@@ -0,0 +1,100 @@
+// Copyright 2023, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package rtprtcp
+
+// 通过发送的rtp包，产生rtcp sr包
+
+type SrProducer struct {
+	ssrc      uint32
+	clockRate int
+
+	packetCount uint32
+	octetCount  uint32
+
+	// 最新（时间戳最大）的rtp包的时间戳，以及发送时的本地时间。
+	// sr中的rtp时间戳由此按clockRate随本地时间线性外推得到，
+	// 每个track只使用自己的rtp时间戳，所以各track的初始时间戳可以互不相关（比如转发摄像头的rtp包），
+	// 各track的sr共用本地时间作为ntp时间，接收端据此做音视频同步
+	hasPacket     bool
+	lastTimestamp uint32
+	lastUnixNano  int64
+}
+
+func NewSrProducer(ssrc uint32, clockRate int) *SrProducer {
+	return &SrProducer{
+		ssrc:      ssrc,
+		clockRate: clockRate,
+	}
+}
+
+func (s *SrProducer) Ssrc() uint32 {
+	return s.ssrc
+}
+
+// FeedRtpPacket 每次发送rtp包，都将rtp包以及发送时的本地时间传入这个函数
+func (s *SrProducer) FeedRtpPacket(pkt RtpPacket, nowUnixNano int64) {
+	// 时间戳回退的包（比如视频的B帧）不更新对应关系
+	if !s.hasPacket || int32(pkt.Header.Timestamp-s.lastTimestamp) > 0 {
+		s.hasPacket = true
+		s.lastTimestamp = pkt.Header.Timestamp
+		s.lastUnixNano = nowUnixNano
+	}
+
+	offset := int(pkt.Header.payloadOffset)
+	if offset == 0 {
+		offset = RtpFixedHeaderLength
+	}
+	octet := len(pkt.Raw) - offset
+	if pkt.Header.Padding == 1 {
+		octet -= pkt.Header.paddingLength
+	}
+	s.packetCount++
+	if octet > 0 {
+		s.octetCount += uint32(octet)
+	}
+}
+
+// Produce 产生sr包
+//
+// @return: sr包的二进制数据，还没有发送过rtp包时返回nil
+func (s *SrProducer) Produce(nowUnixNano int64) []byte {
+	if !s.hasPacket {
+		return nil
+	}
+
+	// 分开计算秒和纳秒部分，避免长时间没有发送rtp包时溢出
+	elapsed := nowUnixNano - s.lastUnixNano
+	elapsedTs := elapsed/1e9*int64(s.clockRate) + elapsed%1e9*int64(s.clockRate)/1e9
+	ntp := UnixNano2Ntp(uint64(nowUnixNano))
+	sr := Sr{
+		SenderSsrc: s.ssrc,
+		Msw:        uint32(ntp >> 32),
+		Lsw:        uint32(ntp),
+		Timestamp:  s.lastTimestamp + uint32(elapsedTs),
+		PktCnt:     s.packetCount,
+		OctetCnt:   s.octetCount,
+	}
+	return sr.Pack()
+}
+
+// CalcRttMs rfc3550 6.4.1 通过rr包中的lsr和dlsr计算rtt
+//
+// @param nowUnixNano: 收到rr包时的本地时间
+// @return: 单位毫秒，对端还没有收到过sr时(lsr为0)返回-1
+func CalcRttMs(block RrBlock, nowUnixNano int64) int {
+	if block.Lsr == 0 {
+		return -1
+	}
+	middle := uint32(UnixNano2Ntp(uint64(nowUnixNano)) >> 16)
+	rtt := int32(middle - block.Lsr - block.Dlsr)
+	if rtt < 0 {
+		return 0
+	}
+	return int(int64(rtt) * 1000 / 65536)
+}
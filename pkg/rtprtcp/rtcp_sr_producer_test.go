@@ -0,0 +1,97 @@
+// Copyright 2023, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package rtprtcp_test
+
+import (
+	"testing"
+
+	"github.com/q191201771/lal/pkg/rtprtcp"
+	"github.com/q191201771/naza/pkg/assert"
+	"github.com/q191201771/naza/pkg/bele"
+)
+
+func TestSrProducer(t *testing.T) {
+	const base = int64(1700000000) * 1e9
+
+	p := rtprtcp.NewSrProducer(0x12345678, 90000)
+	assert.Equal(t, nil, p.Produce(base))
+
+	h := rtprtcp.MakeDefaultRtpHeader()
+	h.Ssrc = 0x12345678
+	h.Timestamp = 90000
+	p.FeedRtpPacket(rtprtcp.MakeRtpPacket(h, make([]byte, 100)), base)
+	h.Timestamp = 93000
+	p.FeedRtpPacket(rtprtcp.MakeRtpPacket(h, make([]byte, 50)), base+int64(33*1e6))
+
+	// 音频的初始时间戳与视频无关（比如转发摄像头的rtp包），sr只由音频自己的rtp包外推
+	ap := rtprtcp.NewSrProducer(1, 48000)
+	h.Ssrc = 1
+	audioStart := uint32(0xFFFFFF00) // 顺便测试回绕
+	h.Timestamp = audioStart
+	ap.FeedRtpPacket(rtprtcp.MakeRtpPacket(h, make([]byte, 10)), base+int64(30*1e6))
+	h.Timestamp = audioStart + 960
+	ap.FeedRtpPacket(rtprtcp.MakeRtpPacket(h, make([]byte, 10)), base+int64(50*1e6))
+	// 时间戳回退的包不影响对应关系
+	h.Timestamp = audioStart + 480
+	ap.FeedRtpPacket(rtprtcp.MakeRtpPacket(h, make([]byte, 10)), base+int64(60*1e6))
+	asr := rtprtcp.ParseSr(ap.Produce(base + int64(1500*1e6)))
+	assert.Equal(t, audioStart+960+1450*48, asr.Timestamp)
+	assert.Equal(t, uint32(3), asr.PktCnt)
+
+	// 1.5秒后，rtp时间戳按90000的时钟增长
+	b := p.Produce(base + int64(1500*1e6))
+	assert.Equal(t, rtprtcp.RtcpSrLength, len(b))
+	header := rtprtcp.ParseRtcpHeader(b)
+	assert.Equal(t, uint8(rtprtcp.RtcpPacketTypeSr), header.PacketType)
+	assert.Equal(t, uint16(6), header.Length)
+	sr := rtprtcp.ParseSr(b)
+	assert.Equal(t, uint32(0x12345678), sr.SenderSsrc)
+	assert.Equal(t, uint32(93000+1467*90), sr.Timestamp)
+	// 音视频的sr使用相同的ntp时间
+	assert.Equal(t, asr.Msw, sr.Msw)
+	assert.Equal(t, asr.Lsw, sr.Lsw)
+	assert.Equal(t, uint32(2), sr.PktCnt)
+	assert.Equal(t, uint32(150), sr.OctetCnt)
+	assert.Equal(t, uint64(base+int64(1500*1e6)), rtprtcp.MswLsw2UnixNano(uint64(sr.Msw), uint64(sr.Lsw))/1e6*1e6)
+
+	// 对端在sr之后200毫秒回复rr，其中dlsr为150毫秒，rtt为50毫秒
+	rr := make([]byte, 32)
+	rr[0] = 0x81
+	rr[1] = rtprtcp.RtcpPacketTypeRr
+	bele.BePutUint16(rr[2:], 7)
+	bele.BePutUint32(rr[4:], 1)
+	bele.BePutUint32(rr[8:], 0x12345678)
+	rr[12] = 10
+	bele.BePutUint24(rr[13:], 3)
+	bele.BePutUint32(rr[16:], 65536+100)
+	bele.BePutUint32(rr[20:], 900)
+	bele.BePutUint32(rr[24:], sr.GetMiddleNtp())
+	bele.BePutUint32(rr[28:], 150*65536/1000)
+	senderSsrc, blocks := rtprtcp.ParseRr(rr)
+	assert.Equal(t, uint32(1), senderSsrc)
+	assert.Equal(t, 1, len(blocks))
+	assert.Equal(t, rtprtcp.RrBlock{
+		MediaSsrc:   0x12345678,
+		Fraction:    10,
+		Lost:        3,
+		ExtendedSeq: 65536 + 100,
+		Jitter:      900,
+		Lsr:         sr.GetMiddleNtp(),
+		Dlsr:        150 * 65536 / 1000,
+	}, blocks[0])
+	rtt := rtprtcp.CalcRttMs(blocks[0], base+int64(1700*1e6))
+	assert.Equal(t, true, rtt >= 49 && rtt <= 51)
+
+	blocks[0].Lsr = 0
+	assert.Equal(t, -1, rtprtcp.CalcRttMs(blocks[0], base))
+
+	// 长度不足的report block被忽略
+	_, blocks = rtprtcp.ParseRr(rr[:20])
+	assert.Equal(t, 0, len(blocks))
+}
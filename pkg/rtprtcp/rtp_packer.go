@@ -57,7 +57,7 @@ func NewRtpPacker(payloadPacker IRtpPackerPayload, clockRate int, ssrc uint32, m
 // @param pkt:
 //
 // - pkt.Timestamp   绝对时间戳，单位毫秒。
-// - pkt.PayloadType rtp包头中的packet type。
+// - pkt.PayloadType base.AvPacketPtXXX，通过 base.AvPacketPt.RtpPayloadType 转换为rtp包头中的packet type。
 func (r *RtpPacker) Pack(pkt base.AvPacket) (out []RtpPacket) {
 	payloads := r.payloadPacker.Pack(pkt.Payload, r.option.MaxPayloadSize)
 	for i, payload := range payloads {
@@ -65,7 +65,7 @@ func (r *RtpPacker) Pack(pkt base.AvPacket) (out []RtpPacket) {
 		if i == len(payloads)-1 {
 			h.Mark = 1
 		}
-		h.PacketType = uint8(pkt.PayloadType)
+		h.PacketType = uint8(pkt.PayloadType.RtpPayloadType())
 		h.Seq = r.genSeq()
 		h.Timestamp = uint32(float64(pkt.Timestamp) * float64(r.clockRate) / 1000)
 		h.Ssrc = r.ssrc
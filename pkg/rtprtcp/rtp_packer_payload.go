@@ -15,3 +15,6 @@ type IRtpPackerPayload interface {
 }
 
 var _ IRtpPackerPayload = &RtpPackerPayloadAvcHevc{}
+var _ IRtpPackerPayload = &RtpPackerPayloadAac{}
+var _ IRtpPackerPayload = &RtpPackerPayloadRaw{}
+var _ IRtpPackerPayload = &RtpPackerPayloadMpa{}
@@ -0,0 +1,44 @@
+// Copyright 2023, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package rtprtcp
+
+// RtpPackerPayloadMpa MPEG Audio(比如mp3)，见rfc2250 3.5
+//
+// 每个rtp包的payload前有4字节的头：
+//
+//	MBZ          [16b] 固定为0
+//	Frag_offset  [16b] 当前包数据在帧中的偏移
+//
+// 帧数据大于rtp payload的最大大小时，拆分成多个rtp包，这些包的时间戳相同
+type RtpPackerPayloadMpa struct {
+}
+
+func NewRtpPackerPayloadMpa() *RtpPackerPayloadMpa {
+	return &RtpPackerPayloadMpa{}
+}
+
+func (r *RtpPackerPayloadMpa) Pack(in []byte, maxSize int) (out [][]byte) {
+	if in == nil || maxSize <= mpaHeaderSize {
+		return
+	}
+
+	maxDataSize := maxSize - mpaHeaderSize
+	for offset := 0; offset < len(in); offset += maxDataSize {
+		end := offset + maxDataSize
+		if end > len(in) {
+			end = len(in)
+		}
+		item := make([]byte, mpaHeaderSize+end-offset)
+		item[2] = uint8(offset >> 8)
+		item[3] = uint8(offset)
+		copy(item[mpaHeaderSize:], in[offset:end])
+		out = append(out, item)
+	}
+	return
+}
@@ -0,0 +1,35 @@
+// Copyright 2023, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package rtprtcp
+
+// RtpPackerPayloadRaw 不需要额外的rtp payload头，帧数据直接作为rtp payload，比如G711A/G711U
+//
+// 与 RtpUnpackerRaw 对应
+type RtpPackerPayloadRaw struct {
+}
+
+func NewRtpPackerPayloadRaw() *RtpPackerPayloadRaw {
+	return &RtpPackerPayloadRaw{}
+}
+
+func (r *RtpPackerPayloadRaw) Pack(in []byte, maxSize int) (out [][]byte) {
+	if in == nil || maxSize <= 0 {
+		return
+	}
+
+	// 注意，一帧打成一个rtp包，因为拆分成多个rtp包时，后面的包的时间戳需要按采样数递增
+	if len(in) > maxSize {
+		Log.Warnf("frame size bigger than rtp payload size while packing. len(in)=%d, maxSize=%d", len(in), maxSize)
+	}
+
+	item := make([]byte, len(in))
+	copy(item, in)
+	out = append(out, item)
+	return
+}
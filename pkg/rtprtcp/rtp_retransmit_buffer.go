@@ -0,0 +1,38 @@
+// Copyright 2023, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package rtprtcp
+
+// RtpRetransmitBuffer 缓存最近发送的rtp包，收到对端的nack时，从中取出被请求重传的包
+//
+// 按seq取模存放，新包会覆盖相同位置的旧包
+// 注意，只保存rtp包的引用，调用方保证放入之后不再修改rtp包的内存块
+type RtpRetransmitBuffer struct {
+	packets []RtpPacket
+}
+
+func NewRtpRetransmitBuffer(size int) *RtpRetransmitBuffer {
+	return &RtpRetransmitBuffer{
+		packets: make([]RtpPacket, size),
+	}
+}
+
+func (r *RtpRetransmitBuffer) Put(pkt RtpPacket) {
+	r.packets[int(pkt.Header.Seq)%len(r.packets)] = pkt
+}
+
+// Get
+//
+// @return ok: 包已经被覆盖或者从来没有放入过时返回false
+func (r *RtpRetransmitBuffer) Get(seq uint16) (pkt RtpPacket, ok bool) {
+	pkt = r.packets[int(seq)%len(r.packets)]
+	if pkt.Raw == nil || pkt.Header.Seq != seq {
+		return RtpPacket{}, false
+	}
+	return pkt, true
+}
@@ -11,6 +11,7 @@ package rtprtcp_test
 import (
 	"testing"
 
+	"github.com/q191201771/lal/pkg/base"
 	"github.com/q191201771/lal/pkg/rtprtcp"
 
 	"github.com/q191201771/naza/pkg/assert"
@@ -68,3 +69,18 @@ func TestSubSeq(t *testing.T) {
 
 func TestParseRtpHeader(t *testing.T) {
 }
+
+func TestRtpPackerG711U(t *testing.T) {
+	// 零值不是任何音频类型，G711U打包时使用rtp的静态payload type 0
+	assert.Equal(t, false, (&base.AvPacket{}).IsAudio())
+
+	packer := rtprtcp.NewRtpPacker(rtprtcp.NewRtpPackerPayloadRaw(), 8000, 1)
+	pkts := packer.Pack(base.AvPacket{
+		PayloadType: base.AvPacketPtG711U,
+		Timestamp:   20,
+		Payload:     []byte{1, 2, 3},
+	})
+	assert.Equal(t, 1, len(pkts))
+	assert.Equal(t, uint8(0), pkts[0].Header.PacketType)
+	assert.Equal(t, uint32(160), pkts[0].Header.Timestamp)
+}
@@ -10,6 +10,7 @@ package rtprtcp
 
 type RtpUnpackContainer struct {
 	unpackerProtocol IRtpUnpackerProtocol
+	onLoss           func()
 
 	list RtpPacketList
 }
@@ -23,6 +24,11 @@ func NewRtpUnpackContainer(maxSize int, unpackerProtocol IRtpUnpackerProtocol) *
 	return p
 }
 
+// SetOnLoss 文档请参考： IRtpUnpackLossNotify
+func (r *RtpUnpackContainer) SetOnLoss(onLoss func()) {
+	r.onLoss = onLoss
+}
+
 // Feed 输入收到的rtp包
 func (r *RtpUnpackContainer) Feed(pkt RtpPacket) {
 	// 过期的包
@@ -51,6 +57,11 @@ func (r *RtpUnpackContainer) Feed(pkt RtpPacket) {
 
 	// 缓存达到最大值
 	if r.list.Full() {
+		// 缺失的包等不到了，接下来要么跳过缺失的包，要么丢弃不完整的包
+		if r.onLoss != nil {
+			r.onLoss()
+		}
+
 		// 尝试合成一帧发生跳跃的帧
 		packed := r.tryUnpackOne()
 
@@ -19,9 +19,11 @@ import (
 var (
 	_ IRtpUnpacker         = &RtpUnpackContainer{}
 	_ IRtpUnpackContainer  = &RtpUnpackContainer{}
+	_ IRtpUnpackLossNotify = &RtpUnpackContainer{}
 	_ IRtpUnpackerProtocol = &RtpUnpackerAac{}
 	_ IRtpUnpackerProtocol = &RtpUnpackerAvcHevc{}
 	_ IRtpUnpackerProtocol = &RtpUnpackerRaw{}
+	_ IRtpUnpackerProtocol = &RtpUnpackerMpa{}
 )
 
 type IRtpUnpacker interface {
@@ -32,6 +34,12 @@ type IRtpUnpackContainer interface {
 	Feed(pkt RtpPacket)
 }
 
+// IRtpUnpackLossNotify 可选接口，IRtpUnpacker 的实现可以通过该接口通知上层发生了无法恢复的丢包
+type IRtpUnpackLossNotify interface {
+	// SetOnLoss 设置丢包回调。回调发生在跳过缺失的包合成下一帧之前，所以上层可以据此丢弃依赖于缺失数据的帧
+	SetOnLoss(onLoss func())
+}
+
 type IRtpUnpackerProtocol interface {
 	// CalcPositionIfNeeded 计算rtp包处于帧中的位置
 	CalcPositionIfNeeded(pkt *RtpPacket)
@@ -69,15 +77,17 @@ type IRtpUnpackerProtocol interface {
 //		  假如sps和pps是一个stapA包，则合并结果为一个AvPacket。
 type OnAvPacket func(pkt base.AvPacket)
 
-// DefaultRtpUnpackerFactory 目前支持AVC，HEVC和AAC MPEG4-GENERIC，业务方也可以自己实现IRtpUnpackerProtocol，甚至是IRtpUnpackContainer
+// DefaultRtpUnpackerFactory 目前支持AVC，HEVC，AAC MPEG4-GENERIC和G711A/G711U，业务方也可以自己实现IRtpUnpackerProtocol，甚至是IRtpUnpackContainer
 func DefaultRtpUnpackerFactory(payloadType base.AvPacketPt, clockRate int, maxSize int, onAvPacket OnAvPacket) IRtpUnpacker {
 	nazalog.Debugf("DefaultRtpUnpackerFactory. type=%d, clockRate=%d, maxSize=%d", payloadType, clockRate, maxSize)
 	var protocol IRtpUnpackerProtocol
 	switch payloadType {
 	case base.AvPacketPtAac:
 		protocol = NewRtpUnpackerAac(payloadType, clockRate, onAvPacket)
-	case base.AvPacketPtG711A:
+	case base.AvPacketPtG711A, base.AvPacketPtG711U, base.AvPacketPtOpus:
 		protocol = NewRtpUnpackerRaw(payloadType, clockRate, onAvPacket)
+	case base.AvPacketPtMp3:
+		protocol = NewRtpUnpackerMpa(payloadType, clockRate, onAvPacket)
 	case base.AvPacketPtAvc:
 		fallthrough
 	case base.AvPacketPtHevc:
@@ -0,0 +1,57 @@
+// Copyright 2023, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package rtprtcp
+
+import "github.com/q191201771/lal/pkg/base"
+
+const mpaHeaderSize = 4
+
+// RtpUnpackerMpa MPEG Audio(比如mp3)，格式见 RtpPackerPayloadMpa
+//
+// 与ffmpeg的处理方式相同，每个rtp包去掉4字节的头后直接回调，不做分片的合并，
+// 因为mp3帧通常小于rtp包的大小，另外mp3的解码器可以自行从数据流中找到帧的边界
+type RtpUnpackerMpa struct {
+	payloadType base.AvPacketPt
+	clockRate   int
+	onAvPacket  OnAvPacket
+}
+
+func NewRtpUnpackerMpa(payloadType base.AvPacketPt, clockRate int, onAvPacket OnAvPacket) *RtpUnpackerMpa {
+	return &RtpUnpackerMpa{
+		payloadType: payloadType,
+		clockRate:   clockRate,
+		onAvPacket:  onAvPacket,
+	}
+}
+
+func (unpacker *RtpUnpackerMpa) CalcPositionIfNeeded(pkt *RtpPacket) {
+	// noop
+}
+
+func (unpacker *RtpUnpackerMpa) TryUnpackOne(list *RtpPacketList) (unpackedFlag bool, unpackedSeq uint16) {
+	p := list.Head.Next // first
+	if p == nil {
+		return false, 0
+	}
+
+	b := p.Packet.Body()
+	if len(b) > mpaHeaderSize {
+		var outPkt base.AvPacket
+		outPkt.PayloadType = unpacker.payloadType
+		outPkt.Timestamp = int64(p.Packet.Header.Timestamp / uint32(unpacker.clockRate/1000))
+		outPkt.Payload = b[mpaHeaderSize:]
+		unpacker.onAvPacket(outPkt)
+	} else {
+		Log.Warnf("invalid mpa rtp packet. len=%d", len(b))
+	}
+
+	list.Head.Next = p.Next
+	list.Size--
+	return true, p.Packet.Header.Seq
+}
@@ -34,7 +34,7 @@ func (unpacker *RtpUnpackerRaw) TryUnpackOne(list *RtpPacketList) (unpackedFlag
 		return false, 0
 	}
 
-	// 暂时认为一个rtp为一帧数据(G711A/G711U)
+	// 暂时认为一个rtp为一帧数据(G711A/G711U/Opus)
 	b := p.Packet.Body()
 	var outPkt base.AvPacket
 	outPkt.PayloadType = unpacker.payloadType
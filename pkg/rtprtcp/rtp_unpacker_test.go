@@ -199,6 +199,37 @@ func testHelperAddPrefixLength(in []byte) (out []byte) {
 	return
 }
 
+func TestMpaCase1(t *testing.T) {
+	frame := make([]byte, 500)
+	for i := range frame {
+		frame[i] = uint8(i)
+	}
+	packer := NewRtpPacker(NewRtpPackerPayloadMpa(), 90000, 1)
+	rtpPackets := packer.Pack(base.AvPacket{
+		Timestamp:   100,
+		PayloadType: base.AvPacketPtMp3,
+		Payload:     frame,
+	})
+	assert.Equal(t, 1, len(rtpPackets))
+	assert.Equal(t, []byte{0, 0, 0, 0}, rtpPackets[0].Body()[:4])
+
+	outPkts := testHelperUnpack(base.AvPacketPtMp3, 90000, 128, rtpPackets)
+	assert.Equal(t, []base.AvPacket{
+		{
+			Timestamp:   100,
+			PayloadType: base.AvPacketPtMp3,
+			Payload:     frame,
+		},
+	}, outPkts)
+
+	// 一帧拆分成多个rtp包
+	out := NewRtpPackerPayloadMpa().Pack(frame, 204)
+	assert.Equal(t, 3, len(out))
+	assert.Equal(t, []byte{0, 0, 0, 200}, out[1][:4])
+	assert.Equal(t, []byte{0, 0, 1, 144}, out[2][:4])
+	assert.Equal(t, 4+100, len(out[2]))
+}
+
 // ---------------------------------------------------------------------------------------------------------------------
 
 func testHelperUnpack(payloadType base.AvPacketPt, clockRate int, maxSize int, rtpPackets []RtpPacket) []base.AvPacket {
@@ -64,7 +64,7 @@ func (a *AvPacketQueue) Feed(pkt base.AvPacket) {
 		pkt.Timestamp -= a.videoBaseTs
 
 		_ = a.videoQueue.PushBack(pkt)
-	case base.AvPacketPtG711A:
+	case base.AvPacketPtG711A, base.AvPacketPtG711U, base.AvPacketPtOpus, base.AvPacketPtMp3:
 		fallthrough
 	case base.AvPacketPtAac:
 		if pkt.Timestamp < a.audioBaseTs {
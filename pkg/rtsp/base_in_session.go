@@ -10,15 +10,19 @@ package rtsp
 
 import (
 	"encoding/hex"
+	"math/rand"
 	"net"
 	"sync"
+	"time"
 
 	"github.com/q191201771/naza/pkg/nazaatomic"
 
 	"github.com/q191201771/naza/pkg/nazabytes"
 	"github.com/q191201771/naza/pkg/nazaerrors"
 
+	"github.com/q191201771/lal/pkg/avc"
 	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/hevc"
 	"github.com/q191201771/lal/pkg/rtprtcp"
 	"github.com/q191201771/lal/pkg/sdp"
 	"github.com/q191201771/naza/pkg/nazanet"
@@ -45,6 +49,12 @@ type IBaseInSessionObserver interface {
 	OnAvPacket(pkt base.AvPacket)
 }
 
+// InLossConfig 输入类型的session通过UDP接收rtp时的丢包处理
+type InLossConfig struct {
+	InNackEnable        bool `json:"in_nack_enable"`           // 检测到丢包时，向发送端发送rtcp nack请求重传
+	InDropGopOnLossFlag bool `json:"in_drop_gop_on_loss_flag"` // 视频丢包无法恢复时，丢弃之后的视频帧直到下一个关键帧，避免花屏的帧进入remux
+}
+
 type BaseInSession struct {
 	cmdSession IInterleavedPacketWriter
 
@@ -61,11 +71,17 @@ type BaseInSession struct {
 
 	sessionStat base.BasicSessionStat
 
-	mu              sync.Mutex
-	sdpCtx          sdp.LogicContext // const after set
-	avPacketQueue   *AvPacketQueue
-	audioRrProducer *rtprtcp.RrProducer
-	videoRrProducer *rtprtcp.RrProducer
+	lossConfig InLossConfig // const after set
+	localSsrc  uint32       // 本端发送rr和nack时使用的ssrc
+
+	mu                sync.Mutex
+	sdpCtx            sdp.LogicContext // const after set
+	avPacketQueue     *AvPacketQueue
+	audioRrProducer   *rtprtcp.RrProducer
+	videoRrProducer   *rtprtcp.RrProducer
+	audioNackProducer *rtprtcp.NackProducer // 只在开启nack并且使用UDP传输时创建
+	videoNackProducer *rtprtcp.NackProducer
+	waitVideoKeyFrame bool // 视频丢包后，等待下一个关键帧
 
 	audioUnpacker rtprtcp.IRtpUnpacker
 	videoUnpacker rtprtcp.IRtpUnpacker
@@ -86,6 +102,7 @@ func NewBaseInSession(sessionType base.SessionType, cmdSession IInterleavedPacke
 	s := &BaseInSession{
 		sessionStat:      base.NewBasicSessionStat(sessionType, ""),
 		cmdSession:       cmdSession,
+		localSsrc:        rand.Uint32(),
 		waitChan:         make(chan error, 1),
 		dumpReadAudioRtp: base.NewLogDump(Log, 1),
 		dumpReadVideoRtp: base.NewLogDump(Log, 1),
@@ -101,6 +118,11 @@ func NewBaseInSessionWithObserver(sessionType base.SessionType, cmdSession IInte
 	return s
 }
 
+// SetLossConfig 调用方保证在 InitWithSdp 之前调用
+func (session *BaseInSession) SetLossConfig(config InLossConfig) {
+	session.lossConfig = config
+}
+
 func (session *BaseInSession) InitWithSdp(sdpCtx sdp.LogicContext) {
 	session.mu.Lock()
 	session.sdpCtx = sdpCtx
@@ -116,9 +138,18 @@ func (session *BaseInSession) InitWithSdp(sdpCtx sdp.LogicContext) {
 	} else {
 		Log.Warnf("[%s] video unpacker not support this type yet. logicCtx=%+v", session.UniqueKey(), session.sdpCtx)
 	}
+	if session.lossConfig.InDropGopOnLossFlag {
+		if n, ok := session.videoUnpacker.(rtprtcp.IRtpUnpackLossNotify); ok {
+			n.SetOnLoss(session.onVideoLoss)
+		}
+	}
 
+	session.mu.Lock()
 	session.audioRrProducer = rtprtcp.NewRrProducer(session.sdpCtx.AudioClockRate)
 	session.videoRrProducer = rtprtcp.NewRrProducer(session.sdpCtx.VideoClockRate)
+	session.audioRrProducer.SetSenderSsrc(session.localSsrc)
+	session.videoRrProducer.SetSenderSsrc(session.localSsrc)
+	session.mu.Unlock()
 
 	if session.sdpCtx.IsAudioUnpackable() && session.sdpCtx.IsVideoUnpackable() {
 		session.mu.Lock()
@@ -145,9 +176,19 @@ func (session *BaseInSession) SetupWithConn(uri string, rtpConn, rtcpConn *nazan
 	if session.sdpCtx.IsAudioUri(uri) {
 		session.audioRtpConn = rtpConn
 		session.audioRtcpConn = rtcpConn
+		if session.lossConfig.InNackEnable {
+			session.mu.Lock()
+			session.audioNackProducer = rtprtcp.NewNackProducer(unpackerItemMaxSize, nackMaxRetries, nackRetryIntervalMs, nackReorderWaitMs)
+			session.mu.Unlock()
+		}
 	} else if session.sdpCtx.IsVideoUri(uri) {
 		session.videoRtpConn = rtpConn
 		session.videoRtcpConn = rtcpConn
+		if session.lossConfig.InNackEnable {
+			session.mu.Lock()
+			session.videoNackProducer = rtprtcp.NewNackProducer(unpackerItemMaxSize, nackMaxRetries, nackRetryIntervalMs, nackReorderWaitMs)
+			session.mu.Unlock()
+		}
 	} else {
 		return nazaerrors.Wrap(base.ErrRtsp)
 	}
@@ -195,6 +236,19 @@ func (session *BaseInSession) GetSdp() sdp.LogicContext {
 	return session.sdpCtx
 }
 
+// GetRtpRecvStat 获取音频和视频rtp的接收统计(包含丢包数)，收到sdp之前返回零值
+func (session *BaseInSession) GetRtpRecvStat() (audio, video rtprtcp.RrStat) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	if session.audioRrProducer != nil {
+		audio = session.audioRrProducer.GetStat()
+	}
+	if session.videoRrProducer != nil {
+		video = session.videoRrProducer.GetStat()
+	}
+	return
+}
+
 func (session *BaseInSession) HandleInterleavedPacket(b []byte, channel int) {
 	switch channel {
 	case session.audioRtpChannel:
@@ -242,6 +296,11 @@ func (session *BaseInSession) IsAlive() (readAlive, writeAlive bool) {
 
 // ---------------------------------------------------------------------------------------------------------------------
 
+// readBytesSum 读取的数据量，供超时检查使用
+func (session *BaseInSession) readBytesSum() uint64 {
+	return session.sessionStat.ReadBytesSum()
+}
+
 func (session *BaseInSession) UniqueKey() string {
 	return session.sessionStat.UniqueKey()
 }
@@ -251,6 +310,14 @@ func (session *BaseInSession) onAvPacketUnpacked(pkt base.AvPacket) {
 	session.mu.Lock()
 	defer session.mu.Unlock()
 
+	if session.waitVideoKeyFrame && pkt.IsVideo() {
+		if !isVideoKeyPacket(pkt) {
+			return
+		}
+		Log.Infof("[%s] got video key frame after loss, resume. ts=%d", session.UniqueKey(), pkt.Timestamp)
+		session.waitVideoKeyFrame = false
+	}
+
 	if session.avPacketQueue != nil {
 		session.avPacketQueue.Feed(pkt)
 	} else {
@@ -258,6 +325,17 @@ func (session *BaseInSession) onAvPacketUnpacked(pkt base.AvPacket) {
 	}
 }
 
+// callback by RTPUnpacker
+func (session *BaseInSession) onVideoLoss() {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	if !session.waitVideoKeyFrame {
+		Log.Warnf("[%s] video rtp packet lost, drop video frames until next key frame.", session.UniqueKey())
+		session.waitVideoKeyFrame = true
+	}
+}
+
 // callback by avpacket queue
 func (session *BaseInSession) onAvPacket(pkt base.AvPacket) {
 	session.observer.OnAvPacket(pkt)
@@ -382,8 +460,13 @@ func (session *BaseInSession) handleRtpPacket(b []byte) error {
 		session.observer.OnRtpPacket(pkt)
 		session.mu.Lock()
 		session.audioRrProducer.FeedRtpPacket(h.Seq)
+		nackSeqs := feedNackProducer(session.audioNackProducer, h.Seq)
 		session.mu.Unlock()
 
+		if nackSeqs != nil {
+			session.writeNack(h.Ssrc, nackSeqs, session.audioRtcpConn)
+		}
+
 		if session.audioUnpacker != nil {
 			session.audioUnpacker.Feed(pkt)
 		}
@@ -397,8 +480,13 @@ func (session *BaseInSession) handleRtpPacket(b []byte) error {
 		session.observer.OnRtpPacket(pkt)
 		session.mu.Lock()
 		session.videoRrProducer.FeedRtpPacket(h.Seq)
+		nackSeqs := feedNackProducer(session.videoNackProducer, h.Seq)
 		session.mu.Unlock()
 
+		if nackSeqs != nil {
+			session.writeNack(h.Ssrc, nackSeqs, session.videoRtcpConn)
+		}
+
 		if session.videoUnpacker != nil {
 			session.videoUnpacker.Feed(pkt)
 		}
@@ -409,6 +497,20 @@ func (session *BaseInSession) handleRtpPacket(b []byte) error {
 	return nil
 }
 
+// writeNack 请求发送端重传丢失的包，只在UDP传输时使用
+func (session *BaseInSession) writeNack(mediaSsrc uint32, seqs []uint16, rtcpConn *nazanet.UdpConnection) {
+	if rtcpConn == nil {
+		return
+	}
+	for _, b := range rtprtcp.PackNack(session.localSsrc, mediaSsrc, seqs) {
+		if err := rtcpConn.Write(b); err != nil {
+			return
+		}
+		session.sessionStat.AddWriteBytes(len(b))
+	}
+	Log.Debugf("[%s] write rtcp nack. ssrc=%d, seqs=%v", session.UniqueKey(), mediaSsrc, seqs)
+}
+
 func (session *BaseInSession) dispose(err error) error {
 	var retErr error
 	session.disposeOnce.Do(func() {
@@ -433,3 +535,35 @@ func (session *BaseInSession) dispose(err error) error {
 	})
 	return retErr
 }
+
+// feedNackProducer 调用方持有锁，producer为nil时返回nil
+func feedNackProducer(producer *rtprtcp.NackProducer, seq uint16) []uint16 {
+	if producer == nil {
+		return nil
+	}
+	nowUnixNano := time.Now().UnixNano()
+	producer.FeedRtpPacket(seq, nowUnixNano)
+	if producer.MissingCount() == 0 {
+		return nil
+	}
+	return producer.Produce(nowUnixNano)
+}
+
+// isVideoKeyPacket 是否包含关键帧或者sps等序列头，丢包后从这样的帧开始恢复输出视频
+func isVideoKeyPacket(pkt base.AvPacket) bool {
+	var key bool
+	_ = avc.IterateNaluAvcc(pkt.Payload, func(nal []byte) {
+		if len(nal) == 0 {
+			return
+		}
+		switch pkt.PayloadType {
+		case base.AvPacketPtAvc:
+			t := avc.ParseNaluType(nal[0])
+			key = key || t == avc.NaluTypeIdrSlice || t == avc.NaluTypeSps
+		case base.AvPacketPtHevc:
+			t := hevc.ParseNaluType(nal[0])
+			key = key || hevc.IsIrapNalu(t) || t == hevc.NaluTypeVps || t == hevc.NaluTypeSps
+		}
+	})
+	return key
+}
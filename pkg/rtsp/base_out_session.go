@@ -12,6 +12,7 @@ import (
 	"encoding/hex"
 	"net"
 	"sync"
+	"time"
 
 	"github.com/q191201771/naza/pkg/nazaatomic"
 
@@ -24,6 +25,18 @@ import (
 	"github.com/q191201771/naza/pkg/nazanet"
 )
 
+// outTrackRtcp 输出的单路流(音频或视频)的rtcp状态
+type outTrackRtcp struct {
+	clockRate        int
+	srProducer       *rtprtcp.SrProducer // 发送第一个rtp包时创建，ssrc变化时重新创建
+	lastSrUnixNano   int64
+	retransmitBuffer *rtprtcp.RtpRetransmitBuffer // 只在UDP传输时创建，ssrc变化时重新创建
+
+	lost     uint32
+	jitterMs int
+	rttMs    int
+}
+
 // BaseOutSession out的含义是音视频由本端发送至对端
 type BaseOutSession struct {
 	cmdSession IInterleavedPacketWriter
@@ -38,9 +51,15 @@ type BaseOutSession struct {
 	audioRtcpChannel int
 	videoRtpChannel  int
 	videoRtcpChannel int
+	isUdp            nazaatomic.Bool // 超时检查协程会读取
 
 	sessionStat base.BasicSessionStat
 
+	// 发送sr以及处理对端的rr和nack，发送rtp的协程和读取rtcp的协程都会访问
+	rtcpMu    sync.Mutex
+	audioRtcp outTrackRtcp
+	videoRtcp outTrackRtcp
+
 	// only for debug log
 	debugLogMaxCount         int
 	loggedWriteAudioRtpCount int
@@ -67,6 +86,11 @@ func NewBaseOutSession(sessionType base.SessionType, cmdSession IInterleavedPack
 
 func (session *BaseOutSession) InitWithSdp(sdpCtx sdp.LogicContext) {
 	session.sdpCtx = sdpCtx
+
+	session.rtcpMu.Lock()
+	session.audioRtcp.clockRate = sdpCtx.AudioClockRate
+	session.videoRtcp.clockRate = sdpCtx.VideoClockRate
+	session.rtcpMu.Unlock()
 }
 
 func (session *BaseOutSession) SetupWithConn(uri string, rtpConn, rtcpConn *nazanet.UdpConnection) error {
@@ -80,6 +104,7 @@ func (session *BaseOutSession) SetupWithConn(uri string, rtpConn, rtcpConn *naza
 		return nazaerrors.Wrap(base.ErrRtsp)
 	}
 
+	session.isUdp.Store(true)
 	go rtpConn.RunLoop(session.onReadRtpPacket)
 	go rtcpConn.RunLoop(session.onReadRtcpPacket)
 
@@ -128,6 +153,7 @@ func (session *BaseOutSession) HandleInterleavedPacket(b []byte, channel int) {
 		fallthrough
 	case session.videoRtcpChannel:
 		Log.Debugf("[%s] read interleaved rtcp packet. b=%s", session.UniqueKey(), hex.Dump(nazabytes.Prefix(b, 32)))
+		session.handleRtcpPacket(b)
 	default:
 		Log.Errorf("[%s] read interleaved packet but channel invalid. channel=%d", session.UniqueKey(), channel)
 	}
@@ -150,6 +176,9 @@ func (session *BaseOutSession) WriteRtpPacket(packet rtprtcp.RtpPacket) error {
 		if session.audioRtpChannel != -1 {
 			err = session.cmdSession.WriteInterleavedPacket(packet.Raw, session.audioRtpChannel)
 		}
+		if err == nil {
+			session.feedOutTrack(packet, true)
+		}
 	} else if session.sdpCtx.IsVideoPayloadTypeOrigin(t) {
 		if session.loggedWriteVideoRtpCount < session.debugLogMaxCount {
 			Log.Debugf("[%s] LOGPACKET. write video rtp=%+v", session.UniqueKey(), packet.Header)
@@ -162,6 +191,9 @@ func (session *BaseOutSession) WriteRtpPacket(packet rtprtcp.RtpPacket) error {
 		if session.videoRtpChannel != -1 {
 			err = session.cmdSession.WriteInterleavedPacket(packet.Raw, session.videoRtpChannel)
 		}
+		if err == nil {
+			session.feedOutTrack(packet, false)
+		}
 	} else {
 		Log.Errorf("[%s] write rtp packet but type invalid. type=%d", session.UniqueKey(), t)
 		err = nazaerrors.Wrap(base.ErrRtsp)
@@ -176,7 +208,14 @@ func (session *BaseOutSession) WriteRtpPacket(packet rtprtcp.RtpPacket) error {
 // ----- ISessionStat --------------------------------------------------------------------------------------------------
 
 func (session *BaseOutSession) GetStat() base.StatSession {
-	return session.sessionStat.GetStat()
+	stat := session.sessionStat.GetStat()
+
+	session.rtcpMu.Lock()
+	stat.RtcpLost = session.audioRtcp.lost + session.videoRtcp.lost
+	stat.RtcpJitterMs = maxInt(session.audioRtcp.jitterMs, session.videoRtcp.jitterMs)
+	stat.RtcpRttMs = maxInt(session.audioRtcp.rttMs, session.videoRtcp.rttMs)
+	session.rtcpMu.Unlock()
+	return stat
 }
 
 func (session *BaseOutSession) UpdateStat(intervalSec uint32) {
@@ -189,12 +228,23 @@ func (session *BaseOutSession) IsAlive() (readAlive, writeAlive bool) {
 
 // ---------------------------------------------------------------------------------------------------------------------
 
+// activeBytesSum 供超时检查使用
+//
+// udp传输时，发送成功不能说明播放端还存活，所以只统计读取到的数据量（rtcp rr等），播放端需要发送rtcp或者信令保活；
+// interleaved传输时，播放端断开后tcp连接的写入会失败，所以发送的数据量也统计在内
+func (session *BaseOutSession) activeBytesSum() uint64 {
+	if session.isUdp.Load() {
+		return session.sessionStat.ReadBytesSum()
+	}
+	return session.sessionStat.ReadBytesSum() + session.sessionStat.WroteBytesSum()
+}
+
 func (session *BaseOutSession) UniqueKey() string {
 	return session.sessionStat.UniqueKey()
 }
 
 func (session *BaseOutSession) onReadRtpPacket(b []byte, rAddr *net.UDPAddr, err error) bool {
-	// TODO(chef): [fix] 在收到rtp和rtcp的地方，加入stat统计 202205
+	session.sessionStat.AddReadBytes(len(b))
 
 	if session.loggedReadRtpCount.Load() < int32(session.debugLogMaxCount) {
 		Log.Debugf("[%s] LOGPACKET. read rtp=%s", session.UniqueKey(), hex.Dump(nazabytes.Prefix(b, 32)))
@@ -204,15 +254,155 @@ func (session *BaseOutSession) onReadRtpPacket(b []byte, rAddr *net.UDPAddr, err
 }
 
 func (session *BaseOutSession) onReadRtcpPacket(b []byte, rAddr *net.UDPAddr, err error) bool {
-	// TODO chef: impl me
+	session.sessionStat.AddReadBytes(len(b))
 
 	if session.loggedReadRtcpCount.Load() < int32(session.debugLogMaxCount) {
 		Log.Debugf("[%s] LOGPACKET. read rtcp=%s", session.UniqueKey(), hex.Dump(nazabytes.Prefix(b, 32)))
 		session.loggedReadRtcpCount.Increment()
 	}
+	session.handleRtcpPacket(b)
 	return true
 }
 
+// feedOutTrack 每次发送rtp包后调用
+//
+// 每路流每隔 srIntervalMs 发送一个sr，第一个rtp包之后立即发送
+// UDP传输时，缓存rtp包用于响应对端的nack
+func (session *BaseOutSession) feedOutTrack(packet rtprtcp.RtpPacket, isAudio bool) {
+	nowUnixNano := time.Now().UnixNano()
+
+	session.rtcpMu.Lock()
+	track := &session.videoRtcp
+	rtpConn := session.videoRtpConn
+	if isAudio {
+		track = &session.audioRtcp
+		rtpConn = session.audioRtpConn
+	}
+	if track.srProducer == nil || track.srProducer.Ssrc() != packet.Header.Ssrc {
+		// 输入流重新开始，时间戳也重新开始
+		track.srProducer = rtprtcp.NewSrProducer(packet.Header.Ssrc, track.clockRate)
+		track.lastSrUnixNano = 0
+		if rtpConn != nil {
+			track.retransmitBuffer = rtprtcp.NewRtpRetransmitBuffer(retransmitBufferSize)
+		}
+	}
+	track.srProducer.FeedRtpPacket(packet, nowUnixNano)
+	if track.retransmitBuffer != nil {
+		track.retransmitBuffer.Put(packet)
+	}
+	var sr []byte
+	if nowUnixNano-track.lastSrUnixNano >= srIntervalMs*1e6 {
+		sr = track.srProducer.Produce(nowUnixNano)
+		track.lastSrUnixNano = nowUnixNano
+	}
+	session.rtcpMu.Unlock()
+
+	if sr == nil {
+		return
+	}
+	var err error
+	if isAudio {
+		err = session.writeRtcpPacket(sr, session.audioRtcpConn, session.audioRtcpChannel)
+	} else {
+		err = session.writeRtcpPacket(sr, session.videoRtcpConn, session.videoRtcpChannel)
+	}
+	if err == nil {
+		session.sessionStat.AddWriteBytes(len(sr))
+	}
+}
+
+func (session *BaseOutSession) writeRtcpPacket(b []byte, conn *nazanet.UdpConnection, channel int) error {
+	if conn != nil {
+		return conn.Write(b)
+	}
+	if channel != -1 {
+		return session.cmdSession.WriteInterleavedPacket(b, channel)
+	}
+	return nazaerrors.Wrap(base.ErrRtsp)
+}
+
+// handleRtcpPacket 处理对端发送的rtcp包，可能是多个rtcp包组成的复合包，目前只处理rr和generic nack
+func (session *BaseOutSession) handleRtcpPacket(b []byte) {
+	nowUnixNano := time.Now().UnixNano()
+	for len(b) >= rtprtcp.RtcpHeaderLength {
+		h := rtprtcp.ParseRtcpHeader(b)
+		n := (int(h.Length) + 1) * 4
+		if n > len(b) {
+			Log.Warnf("[%s] handleRtcpPacket but length invalid. len=%d, header=%+v", session.UniqueKey(), len(b), h)
+			return
+		}
+		switch {
+		case h.PacketType == rtprtcp.RtcpPacketTypeRr:
+			_, blocks := rtprtcp.ParseRr(b[:n])
+			for _, block := range blocks {
+				session.handleRrBlock(block, nowUnixNano)
+			}
+		case h.PacketType == rtprtcp.RtcpPacketTypeRtpfb && h.CountOrFormat == rtprtcp.RtcpRtpfbFmtNack:
+			_, mediaSsrc, seqs := rtprtcp.ParseNack(b[:n])
+			session.handleNack(mediaSsrc, seqs)
+		}
+		b = b[n:]
+	}
+}
+
+func (session *BaseOutSession) handleRrBlock(block rtprtcp.RrBlock, nowUnixNano int64) {
+	session.rtcpMu.Lock()
+	defer session.rtcpMu.Unlock()
+
+	track := session.trackBySsrc(block.MediaSsrc)
+	if track == nil {
+		return
+	}
+
+	track.lost = block.Lost
+	if track.clockRate > 0 {
+		track.jitterMs = int(uint64(block.Jitter) * 1000 / uint64(track.clockRate))
+	}
+	if rtt := rtprtcp.CalcRttMs(block, nowUnixNano); rtt >= 0 {
+		track.rttMs = rtt
+	}
+}
+
+// handleNack 从缓存中取出对端请求重传的rtp包，通过rtp连接重新发送，已经不在缓存中的包忽略
+func (session *BaseOutSession) handleNack(mediaSsrc uint32, seqs []uint16) {
+	session.rtcpMu.Lock()
+	track := session.trackBySsrc(mediaSsrc)
+	if track == nil || track.retransmitBuffer == nil {
+		session.rtcpMu.Unlock()
+		return
+	}
+	rtpConn := session.videoRtpConn
+	if track == &session.audioRtcp {
+		rtpConn = session.audioRtpConn
+	}
+	packets := make([]rtprtcp.RtpPacket, 0, len(seqs))
+	for _, seq := range seqs {
+		if pkt, ok := track.retransmitBuffer.Get(seq); ok {
+			packets = append(packets, pkt)
+		}
+	}
+	session.rtcpMu.Unlock()
+
+	Log.Debugf("[%s] read rtcp nack. ssrc=%d, seqs=%v, hit=%d", session.UniqueKey(), mediaSsrc, seqs, len(packets))
+	for _, pkt := range packets {
+		if err := rtpConn.Write(pkt.Raw); err != nil {
+			return
+		}
+		session.sessionStat.AddWriteBytes(len(pkt.Raw))
+	}
+}
+
+// trackBySsrc 调用方持有 rtcpMu ，没有对应的流时返回nil
+func (session *BaseOutSession) trackBySsrc(ssrc uint32) *outTrackRtcp {
+	switch {
+	case session.audioRtcp.srProducer != nil && session.audioRtcp.srProducer.Ssrc() == ssrc:
+		return &session.audioRtcp
+	case session.videoRtcp.srProducer != nil && session.videoRtcp.srProducer.Ssrc() == ssrc:
+		return &session.videoRtcp
+	}
+	return nil
+}
+
 func (session *BaseOutSession) dispose(err error) error {
 	var retErr error
 	session.disposeOnce.Do(func() {
@@ -237,3 +427,10 @@ func (session *BaseOutSession) dispose(err error) error {
 	})
 	return retErr
 }
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
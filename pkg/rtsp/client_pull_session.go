@@ -12,6 +12,7 @@ import (
 	"sync"
 
 	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/rtprtcp"
 	"github.com/q191201771/lal/pkg/sdp"
 	"github.com/q191201771/naza/pkg/nazaerrors"
 	"github.com/q191201771/naza/pkg/nazanet"
@@ -27,6 +28,8 @@ type PullSessionOption struct {
 	PullTimeoutMs int
 
 	OverTcp bool // 是否使用interleaved模式，也即是否通过rtsp command tcp连接传输rtp/rtcp数据
+
+	LossConfig InLossConfig // 使用UDP接收rtp时的丢包处理
 }
 
 var defaultPullSessionOption = PullSessionOption{
@@ -59,6 +62,7 @@ func NewPullSession(observer IPullSessionObserver, modOptions ...ModPullSessionO
 		waitChan:           make(chan error, 1),
 	}
 	baseInSession := NewBaseInSessionWithObserver(base.SessionTypeRtspPull, s, observer)
+	baseInSession.SetLossConfig(option.LossConfig)
 	cmdSession := NewClientCommandSession(CcstPullSession, baseInSession.UniqueKey(), s, func(opt *ClientCommandSessionOption) {
 		opt.DoTimeoutMs = option.PullTimeoutMs
 		opt.OverTcp = option.OverTcp
@@ -131,6 +135,10 @@ func (session *PullSession) GetSdp() sdp.LogicContext {
 	return session.baseInSession.GetSdp()
 }
 
+func (session *PullSession) GetRtpRecvStat() (audio, video rtprtcp.RrStat) {
+	return session.baseInSession.GetRtpRecvStat()
+}
+
 // ---------------------------------------------------------------------------------------------------------------------
 // IClientSessionLifecycle interface
 // ---------------------------------------------------------------------------------------------------------------------
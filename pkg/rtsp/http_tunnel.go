@@ -0,0 +1,305 @@
+// Copyright 2023, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package rtsp
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+)
+
+// http_tunnel.go
+//
+// RTSP over HTTP，即Apple的RTSP/RTP over HTTP隧道，用于只能通过http代理访问的客户端
+//
+// 客户端建立两条http连接，通过`x-sessioncookie`配对：
+//   - GET: 服务端到客户端方向，响应头之后的数据就是原始的RTSP响应以及interleaved RTP/RTCP
+//   - POST: 客户端到服务端方向，body是base64编码后的RTSP信令，服务端不回复响应
+//
+// 配对后的两条连接合成一个 net.Conn ，交给 ServerCommandSession 处理，后续逻辑和普通的RTSP TCP连接相同
+// 客户端可能在发送完信令后关闭POST连接，之后再发起新的POST，所以POST连接断开时不关闭session
+
+const (
+	HeaderSessionCookie = "x-sessioncookie"
+
+	HttpTunnelContentType = "application/x-rtsp-tunnelled"
+)
+
+// IsHttpTunnelRequest 判断http请求是否为RTSP over HTTP的GET或POST请求
+func IsHttpTunnelRequest(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet:
+		return r.Header.Get("Accept") == HttpTunnelContentType
+	case http.MethodPost:
+		return r.Header.Get("Content-Type") == HttpTunnelContentType
+	}
+	return false
+}
+
+// ServeHttpTunnel 处理RTSP over HTTP的GET和POST请求，可注册到 base.HttpServerManager.AddInterceptor
+//
+// GET请求会阻塞直到session结束
+func (s *Server) ServeHttpTunnel(w http.ResponseWriter, r *http.Request) {
+	cookie := r.Header.Get(HeaderSessionCookie)
+	if cookie == "" {
+		Log.Warnf("rtsp http tunnel without session cookie. method=%s, raddr=%s", r.Method, r.RemoteAddr)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		s.serveHttpTunnelGet(w, r, cookie)
+	case http.MethodPost:
+		s.serveHttpTunnelPost(w, r, cookie)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) serveHttpTunnelGet(w http.ResponseWriter, r *http.Request, cookie string) {
+	s.mutex.Lock()
+	_, exist := s.tunnels[cookie]
+	s.mutex.Unlock()
+	if exist {
+		Log.Warnf("rtsp http tunnel session cookie already exist. cookie=%s, raddr=%s", cookie, r.RemoteAddr)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	conn, ok := hijackHttpTunnel(w)
+	if !ok {
+		return
+	}
+	// 和普通http响应不同，不带Content-Length，连接关闭即结束
+	resp := "HTTP/1.0 200 OK\r\n" +
+		"Server: " + base.LalRtspOptionsResponseServer + "\r\n" +
+		"Connection: close\r\n" +
+		"Cache-Control: no-store\r\n" +
+		"Pragma: no-cache\r\n" +
+		"Content-Type: " + HttpTunnelContentType + "\r\n" +
+		"\r\n"
+	if _, err := conn.Write([]byte(resp)); err != nil {
+		_ = conn.Close()
+		return
+	}
+
+	tunnel := newHttpTunnelConn(conn)
+	s.mutex.Lock()
+	if _, exist = s.tunnels[cookie]; exist {
+		s.mutex.Unlock()
+		_ = tunnel.Close()
+		return
+	}
+	s.tunnels[cookie] = tunnel
+	s.mutex.Unlock()
+
+	Log.Infof("rtsp http tunnel get. cookie=%s, raddr=%s", cookie, r.RemoteAddr)
+	s.handleTcpConnect(tunnel)
+
+	s.mutex.Lock()
+	delete(s.tunnels, cookie)
+	s.mutex.Unlock()
+	_ = tunnel.Close()
+}
+
+func (s *Server) serveHttpTunnelPost(w http.ResponseWriter, r *http.Request, cookie string) {
+	s.mutex.Lock()
+	tunnel, exist := s.tunnels[cookie]
+	s.mutex.Unlock()
+	if !exist {
+		Log.Warnf("rtsp http tunnel get not found. cookie=%s, raddr=%s", cookie, r.RemoteAddr)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	conn, ok := hijackHttpTunnel(w)
+	if !ok {
+		return
+	}
+	Log.Infof("rtsp http tunnel post. cookie=%s, raddr=%s", cookie, r.RemoteAddr)
+	err := tunnel.feedPost(conn)
+	Log.Infof("rtsp http tunnel post done. cookie=%s, err=%+v", cookie, err)
+}
+
+func hijackHttpTunnel(w http.ResponseWriter) (net.Conn, bool) {
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		return nil, false
+	}
+	conn, bufrw, err := hj.Hijack()
+	if err != nil {
+		Log.Errorf("rtsp http tunnel hijack failed. err=%+v", err)
+		return nil, false
+	}
+	_ = conn.SetDeadline(time.Time{})
+	// 读缓冲中可能已经有POST的body数据
+	return &bufferedConn{Conn: conn, r: bufrw.Reader}, true
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// httpTunnelConn 将GET和POST两条连接合成一个 net.Conn
+//
+// 读取POST中解码后的数据，写入GET连接
+type httpTunnelConn struct {
+	getConn net.Conn
+	pr      *io.PipeReader
+	pw      *io.PipeWriter
+
+	mu       sync.Mutex
+	postConn net.Conn
+	closed   bool
+}
+
+func newHttpTunnelConn(getConn net.Conn) *httpTunnelConn {
+	pr, pw := io.Pipe()
+	return &httpTunnelConn{
+		getConn: getConn,
+		pr:      pr,
+		pw:      pw,
+	}
+}
+
+// feedPost 读取POST连接中的数据，直到POST连接断开
+func (t *httpTunnelConn) feedPost(conn net.Conn) error {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		_ = conn.Close()
+		return io.ErrClosedPipe
+	}
+	t.postConn = conn
+	t.mu.Unlock()
+
+	defer func() {
+		t.mu.Lock()
+		if t.postConn == conn {
+			t.postConn = nil
+		}
+		t.mu.Unlock()
+		_ = conn.Close()
+	}()
+
+	var d httpTunnelDecoder
+	buf := make([]byte, 4096)
+	for {
+		n, err := conn.Read(buf)
+		if n > 0 {
+			b, derr := d.feed(buf[:n])
+			if derr != nil {
+				return derr
+			}
+			if len(b) > 0 {
+				if _, werr := t.pw.Write(b); werr != nil {
+					return werr
+				}
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (t *httpTunnelConn) Read(b []byte) (int, error) {
+	return t.pr.Read(b)
+}
+
+func (t *httpTunnelConn) Write(b []byte) (int, error) {
+	return t.getConn.Write(b)
+}
+
+func (t *httpTunnelConn) Close() error {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return nil
+	}
+	t.closed = true
+	postConn := t.postConn
+	t.mu.Unlock()
+
+	_ = t.pw.Close()
+	if postConn != nil {
+		_ = postConn.Close()
+	}
+	return t.getConn.Close()
+}
+
+func (t *httpTunnelConn) LocalAddr() net.Addr {
+	return t.getConn.LocalAddr()
+}
+
+func (t *httpTunnelConn) RemoteAddr() net.Addr {
+	return t.getConn.RemoteAddr()
+}
+
+// SetDeadline 读取的数据来自POST连接，并且POST连接可能断开重连，所以只对GET连接的写生效
+func (t *httpTunnelConn) SetDeadline(tm time.Time) error {
+	return t.getConn.SetWriteDeadline(tm)
+}
+
+func (t *httpTunnelConn) SetReadDeadline(tm time.Time) error {
+	return nil
+}
+
+func (t *httpTunnelConn) SetWriteDeadline(tm time.Time) error {
+	return t.getConn.SetWriteDeadline(tm)
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// httpTunnelDecoder 流式解码POST body中的base64数据
+//
+// 客户端每条信令单独编码，所以数据中间可能出现填充字符`=`，另外忽略空白字符，按4字节一组解码
+type httpTunnelDecoder struct {
+	remain []byte
+}
+
+func (d *httpTunnelDecoder) feed(b []byte) ([]byte, error) {
+	for _, c := range b {
+		switch c {
+		case ' ', '\t', '\r', '\n':
+			continue
+		}
+		d.remain = append(d.remain, c)
+	}
+
+	n := len(d.remain) / 4 * 4
+	out := make([]byte, 0, n/4*3)
+	var dst [3]byte
+	for i := 0; i < n; i += 4 {
+		m, err := base64.StdEncoding.Decode(dst[:], d.remain[i:i+4])
+		if err != nil {
+			return nil, fmt.Errorf("%w. err=%v", base.ErrRtspHttpTunnel, err)
+		}
+		out = append(out, dst[:m]...)
+	}
+	d.remain = append(d.remain[:0], d.remain[n:]...)
+	return out, nil
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+type bufferedConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
@@ -0,0 +1,116 @@
+// Copyright 2023, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package rtsp_test
+
+import (
+	"bufio"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/q191201771/lal/pkg/rtsp"
+	"github.com/q191201771/naza/pkg/assert"
+	"github.com/q191201771/naza/pkg/nazahttp"
+)
+
+type testServerObserver struct {
+	testServerCommandSessionObserver
+}
+
+func (o *testServerObserver) OnNewRtspSessionConnect(session *rtsp.ServerCommandSession) {}
+
+func (o *testServerObserver) OnDelRtspSession(session *rtsp.ServerCommandSession) {}
+
+func (o *testServerObserver) OnDelRtspPubSession(session *rtsp.PubSession) {}
+
+func (o *testServerObserver) OnDelRtspSubSession(session *rtsp.SubSession) {}
+
+func TestHttpTunnel(t *testing.T) {
+	var observer testServerObserver
+	s := rtsp.NewServer("", &observer, rtsp.ServerAuthConfig{})
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !rtsp.IsHttpTunnelRequest(r) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		s.ServeHttpTunnel(w, r)
+	}))
+	defer hs.Close()
+	addr := strings.TrimPrefix(hs.URL, "http://")
+	uri := "rtsp://" + addr + "/live/test110"
+
+	// 没有x-sessioncookie
+	resp, err := http.Post(hs.URL+"/live/test110", rtsp.HttpTunnelContentType, nil)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	_ = resp.Body.Close()
+
+	getConn, err := net.Dial("tcp", addr)
+	assert.Equal(t, nil, err)
+	defer getConn.Close()
+	_, err = getConn.Write([]byte("GET /live/test110 HTTP/1.0\r\n" +
+		"x-sessioncookie: 191201771\r\n" +
+		"Accept: application/x-rtsp-tunnelled\r\n" +
+		"\r\n"))
+	assert.Equal(t, nil, err)
+	r := bufio.NewReader(getConn)
+	httpResp, err := nazahttp.ReadHttpResponseMessage(r)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "200", httpResp.StatusCode)
+	assert.Equal(t, rtsp.HttpTunnelContentType, httpResp.Headers.Get("Content-Type"))
+
+	postConn, err := net.Dial("tcp", addr)
+	assert.Equal(t, nil, err)
+	defer postConn.Close()
+	_, err = postConn.Write([]byte("POST /live/test110 HTTP/1.0\r\n" +
+		"x-sessioncookie: 191201771\r\n" +
+		"Content-Type: application/x-rtsp-tunnelled\r\n" +
+		"Content-Length: 32767\r\n" +
+		"\r\n"))
+	assert.Equal(t, nil, err)
+
+	// 每条信令单独编码，并且分多次发送
+	options := base64.StdEncoding.EncodeToString([]byte(rtsp.PackRequest(rtsp.MethodOptions, uri, map[string]string{rtsp.HeaderCSeq: "1"}, "")))
+	describe := base64.StdEncoding.EncodeToString([]byte(rtsp.PackRequest(rtsp.MethodDescribe, uri, map[string]string{rtsp.HeaderCSeq: "2"}, "")))
+	payload := options + "\r\n" + describe
+	_, err = postConn.Write([]byte(payload[:7]))
+	assert.Equal(t, nil, err)
+	_, err = postConn.Write([]byte(payload[7:]))
+	assert.Equal(t, nil, err)
+
+	rtspResp, err := nazahttp.ReadHttpResponseMessage(r)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "200", rtspResp.StatusCode)
+	assert.Equal(t, "1", rtspResp.Headers.Get(rtsp.HeaderCSeq))
+
+	rtspResp, err = nazahttp.ReadHttpResponseMessage(r)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "200", rtspResp.StatusCode)
+	assert.Equal(t, "2", rtspResp.Headers.Get(rtsp.HeaderCSeq))
+	assert.Equal(t, testSdp, string(rtspResp.Body))
+
+	// POST连接断开后，使用新的POST连接继续发送信令
+	_ = postConn.Close()
+	postConn2, err := net.Dial("tcp", addr)
+	assert.Equal(t, nil, err)
+	defer postConn2.Close()
+	options = base64.StdEncoding.EncodeToString([]byte(rtsp.PackRequest(rtsp.MethodOptions, uri, map[string]string{rtsp.HeaderCSeq: "3"}, "")))
+	_, err = postConn2.Write([]byte("POST /live/test110 HTTP/1.0\r\n" +
+		"x-sessioncookie: 191201771\r\n" +
+		"Content-Type: application/x-rtsp-tunnelled\r\n" +
+		"Content-Length: 32767\r\n" +
+		"\r\n" + options))
+	assert.Equal(t, nil, err)
+	rtspResp, err = nazahttp.ReadHttpResponseMessage(r)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "3", rtspResp.Headers.Get(rtsp.HeaderCSeq))
+}
@@ -0,0 +1,220 @@
+// Copyright 2023, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package rtsp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/rtprtcp"
+	"github.com/q191201771/lal/pkg/sdp"
+	"github.com/q191201771/naza/pkg/nazaerrors"
+	"github.com/q191201771/naza/pkg/nazanet"
+)
+
+// multicast.go
+//
+// rtsp sub session的UDP组播，即SETUP信令中的`Transport: RTP/AVP;multicast`
+//
+// 每路流从地址池中分配一个组播地址，同一路流的所有组播sub session共用这个地址，
+// 由上层为每路流创建一个 MulticastSender 发送rtp数据，sub session自身不发送数据
+//
+// 每路流使用的端口固定为：
+//   - 视频: rtp为 MulticastConfig.MulticastPort ，rtcp为+1
+//   - 音频: rtp为 MulticastConfig.MulticastPort+2 ，rtcp为+3
+
+type MulticastConfig struct {
+	MulticastEnable    bool   `json:"multicast_enable"`
+	MulticastAddrBegin string `json:"multicast_addr_begin"` // 组播地址池的起始地址，比如239.0.0.1
+	MulticastAddrCount int    `json:"multicast_addr_count"` // 组播地址池的地址个数，每路流占用一个
+	MulticastPort      int    `json:"multicast_port"`
+	MulticastTtl       int    `json:"multicast_ttl"`
+}
+
+// MulticastDest 一路流的组播目的地址
+type MulticastDest struct {
+	Ip           net.IP
+	VideoRtpPort int
+	AudioRtpPort int
+	Ttl          int
+}
+
+func (d MulticastDest) String() string {
+	return fmt.Sprintf("%s:%d-%d ttl=%d", d.Ip, d.VideoRtpPort, d.AudioRtpPort+1, d.Ttl)
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// MulticastAddrPool 组播地址池，按流的key分配（见 SubSession.SetMulticastKey），同一个key的多次分配使用引用计数
+type MulticastAddrPool struct {
+	config  MulticastConfig
+	beginIp uint32
+
+	mutex    sync.Mutex
+	key2Item map[string]*multicastAddrItem
+	used     []bool
+}
+
+type multicastAddrItem struct {
+	index    int
+	refCount int
+}
+
+func NewMulticastAddrPool(config MulticastConfig) (*MulticastAddrPool, error) {
+	ip := net.ParseIP(config.MulticastAddrBegin).To4()
+	if ip == nil || !ip.IsMulticast() {
+		return nil, fmt.Errorf("%w. addr=%s", base.ErrRtspMulticastConfig, config.MulticastAddrBegin)
+	}
+	beginIp := binary.BigEndian.Uint32(ip)
+	if config.MulticastAddrCount <= 0 || uint64(beginIp)+uint64(config.MulticastAddrCount)-1 > 0xEFFFFFFF {
+		return nil, fmt.Errorf("%w. addr=%s, count=%d", base.ErrRtspMulticastConfig, config.MulticastAddrBegin, config.MulticastAddrCount)
+	}
+	if config.MulticastPort <= 0 || config.MulticastPort+3 > 65535 {
+		return nil, fmt.Errorf("%w. port=%d", base.ErrRtspMulticastConfig, config.MulticastPort)
+	}
+	if config.MulticastTtl <= 0 || config.MulticastTtl > 255 {
+		return nil, fmt.Errorf("%w. ttl=%d", base.ErrRtspMulticastConfig, config.MulticastTtl)
+	}
+
+	return &MulticastAddrPool{
+		config:   config,
+		beginIp:  beginIp,
+		key2Item: make(map[string]*multicastAddrItem),
+		used:     make([]bool, config.MulticastAddrCount),
+	}, nil
+}
+
+// Acquire 获取`key`对应的组播地址，`key`第一次获取时从池中分配，之后增加引用计数
+func (p *MulticastAddrPool) Acquire(key string) (MulticastDest, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if item, ok := p.key2Item[key]; ok {
+		item.refCount++
+		return p.dest(item.index), nil
+	}
+
+	for i, used := range p.used {
+		if !used {
+			p.used[i] = true
+			p.key2Item[key] = &multicastAddrItem{index: i, refCount: 1}
+			return p.dest(i), nil
+		}
+	}
+	return MulticastDest{}, nazaerrors.Wrap(base.ErrRtspMulticastAddrExhausted)
+}
+
+// Release 与 Acquire 对应，引用计数为0时归还地址
+func (p *MulticastAddrPool) Release(key string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	item, ok := p.key2Item[key]
+	if !ok {
+		return
+	}
+	item.refCount--
+	if item.refCount == 0 {
+		p.used[item.index] = false
+		delete(p.key2Item, key)
+	}
+}
+
+func (p *MulticastAddrPool) dest(index int) MulticastDest {
+	ip := make(net.IP, 4)
+	binary.BigEndian.PutUint32(ip, p.beginIp+uint32(index))
+	return MulticastDest{
+		Ip:           ip,
+		VideoRtpPort: p.config.MulticastPort,
+		AudioRtpPort: p.config.MulticastPort + 2,
+		Ttl:          p.config.MulticastTtl,
+	}
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// MulticastSender 向一路流的组播地址发送rtp数据
+//
+// 由上层在有组播sub session时创建，同一路流的所有组播sub session共用，最后一个组播sub session离开时销毁
+type MulticastSender struct {
+	dest   MulticastDest
+	sdpCtx sdp.LogicContext
+
+	audioRtpConn *nazanet.UdpConnection
+	videoRtpConn *nazanet.UdpConnection
+
+	ShouldWaitVideoKeyFrame bool
+}
+
+func NewMulticastSender(dest MulticastDest, sdpCtx sdp.LogicContext) (*MulticastSender, error) {
+	s := &MulticastSender{
+		dest:   dest,
+		sdpCtx: sdpCtx,
+
+		ShouldWaitVideoKeyFrame: true,
+	}
+
+	var err error
+	if s.videoRtpConn, err = newMulticastConn(dest.Ip, dest.VideoRtpPort, dest.Ttl); err != nil {
+		return nil, err
+	}
+	if s.audioRtpConn, err = newMulticastConn(dest.Ip, dest.AudioRtpPort, dest.Ttl); err != nil {
+		_ = s.videoRtpConn.Dispose()
+		return nil, err
+	}
+	Log.Infof("lifecycle new rtsp MulticastSender. sender=%p, dest=%s", s, dest)
+	return s, nil
+}
+
+func (s *MulticastSender) WriteRtpPacket(packet rtprtcp.RtpPacket) error {
+	// 发送数据时，保证和sdp的原始类型对应
+	t := int(packet.Header.PacketType)
+	if s.sdpCtx.IsAudioPayloadTypeOrigin(t) {
+		return s.audioRtpConn.Write(packet.Raw)
+	} else if s.sdpCtx.IsVideoPayloadTypeOrigin(t) {
+		return s.videoRtpConn.Write(packet.Raw)
+	}
+	return nazaerrors.Wrap(base.ErrRtsp)
+}
+
+func (s *MulticastSender) Dest() MulticastDest {
+	return s.dest
+}
+
+func (s *MulticastSender) Dispose() error {
+	Log.Infof("lifecycle dispose rtsp MulticastSender. sender=%p, dest=%s", s, s.dest)
+	e1 := s.videoRtpConn.Dispose()
+	e2 := s.audioRtpConn.Dispose()
+	return nazaerrors.CombineErrors(e1, e2)
+}
+
+func newMulticastConn(ip net.IP, port int, ttl int) (*nazanet.UdpConnection, error) {
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, err
+	}
+	if err = setMulticastTtl(conn, ttl); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	c, err := nazanet.NewUdpConnection(func(option *nazanet.UdpConnectionOption) {
+		option.Conn = conn
+		option.RAddr = net.JoinHostPort(ip.String(), strconv.Itoa(port))
+		option.MaxReadPacketSize = rtprtcp.MaxRtpRtcpPacketSize
+	})
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
@@ -0,0 +1,71 @@
+// Copyright 2023, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package rtsp_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/rtsp"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestMulticastAddrPool(t *testing.T) {
+	config := rtsp.MulticastConfig{
+		MulticastEnable:    true,
+		MulticastAddrBegin: "239.0.0.254",
+		MulticastAddrCount: 2,
+		MulticastPort:      20000,
+		MulticastTtl:       16,
+	}
+	pool, err := rtsp.NewMulticastAddrPool(config)
+	assert.Equal(t, nil, err)
+
+	d1, err := pool.Acquire("test110")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "239.0.0.254", d1.Ip.String())
+	assert.Equal(t, 20000, d1.VideoRtpPort)
+	assert.Equal(t, 20002, d1.AudioRtpPort)
+	assert.Equal(t, 16, d1.Ttl)
+
+	// 同一路流使用相同的地址
+	d2, err := pool.Acquire("test110")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, d1.Ip.String(), d2.Ip.String())
+
+	d3, err := pool.Acquire("test220")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "239.0.0.255", d3.Ip.String())
+
+	_, err = pool.Acquire("test330")
+	assert.Equal(t, true, errors.Is(err, base.ErrRtspMulticastAddrExhausted))
+
+	// 引用计数为0时才归还
+	pool.Release("test110")
+	_, err = pool.Acquire("test330")
+	assert.Equal(t, true, errors.Is(err, base.ErrRtspMulticastAddrExhausted))
+	pool.Release("test110")
+	d4, err := pool.Acquire("test330")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "239.0.0.254", d4.Ip.String())
+
+	invalid := config
+	invalid.MulticastAddrBegin = "192.168.0.1"
+	_, err = rtsp.NewMulticastAddrPool(invalid)
+	assert.Equal(t, true, errors.Is(err, base.ErrRtspMulticastConfig))
+	invalid = config
+	invalid.MulticastAddrBegin = "239.255.255.255"
+	_, err = rtsp.NewMulticastAddrPool(invalid)
+	assert.Equal(t, true, errors.Is(err, base.ErrRtspMulticastConfig))
+	invalid = config
+	invalid.MulticastTtl = 0
+	_, err = rtsp.NewMulticastAddrPool(invalid)
+	assert.Equal(t, true, errors.Is(err, base.ErrRtspMulticastConfig))
+}
@@ -21,7 +21,7 @@ import (
 var ResponseOptionsTmpl = "RTSP/1.0 200 OK\r\n" +
 	"Server: " + base.LalRtspOptionsResponseServer + "\r\n" +
 	"CSeq: %s\r\n" +
-	"Public: DESCRIBE, ANNOUNCE, SETUP, PLAY, PAUSE, RECORD, TEARDOWN\r\n" +
+	"Public: DESCRIBE, ANNOUNCE, SETUP, PLAY, PAUSE, RECORD, TEARDOWN, GET_PARAMETER, SET_PARAMETER\r\n" +
 	"\r\n"
 
 // rfc2326 10.3 ANNOUNCE
@@ -63,10 +63,38 @@ var ResponseRecordTmpl = "RTSP/1.0 200 OK\r\n" +
 
 // rfc2326 10.5 PLAY
 
-// ResponsePlayTmpl CSeq Date
+// ResponsePlayTmpl CSeq, Date, Session
 var ResponsePlayTmpl = "RTSP/1.0 200 OK\r\n" +
 	"CSeq: %s\r\n" +
 	"Date: %s\r\n" +
+	"Session: %s\r\n" +
+	"\r\n"
+
+// rfc2326 10.6 PAUSE
+
+// ResponsePauseTmpl CSeq, Date, Session
+var ResponsePauseTmpl = "RTSP/1.0 200 OK\r\n" +
+	"CSeq: %s\r\n" +
+	"Date: %s\r\n" +
+	"Session: %s\r\n" +
+	"\r\n"
+
+// rfc2326 10.8 GET_PARAMETER
+// 不支持任何参数，只作为客户端的心跳使用
+
+// ResponseGetParameterTmpl CSeq, Session
+var ResponseGetParameterTmpl = "RTSP/1.0 200 OK\r\n" +
+	"CSeq: %s\r\n" +
+	"Session: %s\r\n" +
+	"Content-Length: 0\r\n" +
+	"\r\n"
+
+// rfc2326 10.9 SET_PARAMETER
+
+// ResponseSetParameterTmpl CSeq, Session
+var ResponseSetParameterTmpl = "RTSP/1.0 200 OK\r\n" +
+	"CSeq: %s\r\n" +
+	"Session: %s\r\n" +
 	"\r\n"
 
 // rfc2326 10.7 TEARDOWN
@@ -77,6 +105,11 @@ var ResponseTeardownTmpl = "RTSP/1.0 200 OK\r\n" +
 	"CSeq: %s\r\n" +
 	"\r\n"
 
+// ResponseStatusTmpl Status-Code, Reason-Phrase, CSeq
+var ResponseStatusTmpl = "RTSP/1.0 %d %s\r\n" +
+	"CSeq: %s\r\n" +
+	"\r\n"
+
 var ResponseAuthorizedTmpl = "RTSP/1.0 401 Unauthorized\r\n" +
 	"CSeq: %s\r\n" +
 	"Date: %s\r\n" +
@@ -96,19 +129,40 @@ func PackResponseDescribe(cseq, sdp string) string {
 	return fmt.Sprintf(ResponseDescribeTmpl, cseq, date, len(sdp), sdp)
 }
 
-func PackResponseSetup(cseq string, htv string) string {
+// PackResponseSetup
+//
+// @param session: Session header的值，可以携带timeout参数，比如`12345678;timeout=60`
+func PackResponseSetup(cseq, session, htv string) string {
 	date := time.Now().Format(time.RFC1123)
 
-	return fmt.Sprintf(ResponseSetupTmpl, cseq, date, sessionId, htv)
+	return fmt.Sprintf(ResponseSetupTmpl, cseq, date, session, htv)
 }
 
-func PackResponseRecord(cseq string) string {
-	return fmt.Sprintf(ResponseRecordTmpl, cseq, sessionId)
+func PackResponseRecord(cseq, session string) string {
+	return fmt.Sprintf(ResponseRecordTmpl, cseq, session)
 }
 
-func PackResponsePlay(cseq string) string {
+func PackResponsePlay(cseq, session string) string {
 	date := time.Now().Format(time.RFC1123)
-	return fmt.Sprintf(ResponsePlayTmpl, cseq, date)
+	return fmt.Sprintf(ResponsePlayTmpl, cseq, date, session)
+}
+
+func PackResponsePause(cseq, session string) string {
+	date := time.Now().Format(time.RFC1123)
+	return fmt.Sprintf(ResponsePauseTmpl, cseq, date, session)
+}
+
+func PackResponseGetParameter(cseq, session string) string {
+	return fmt.Sprintf(ResponseGetParameterTmpl, cseq, session)
+}
+
+func PackResponseSetParameter(cseq, session string) string {
+	return fmt.Sprintf(ResponseSetParameterTmpl, cseq, session)
+}
+
+// PackResponseStatus 非200的响应
+func PackResponseStatus(code int, reason, cseq string) string {
+	return fmt.Sprintf(ResponseStatusTmpl, code, reason, cseq)
 }
 
 func PackResponseTeardown(cseq string) string {
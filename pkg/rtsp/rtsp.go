@@ -9,6 +9,8 @@
 package rtsp
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"net"
 	"strconv"
@@ -23,7 +25,6 @@ import (
 )
 
 // TODO chef
-// - out缺少主动发送sr
 // - pull session回调有observer interface和on func回调两种方式，是否需要统一
 // - [refactor] BaseInSession和BaseOutSession有不少重复内容
 // - [refactor] PullSession和PushSession有不少重复内容
@@ -36,7 +37,9 @@ const (
 	MethodRecord       = "RECORD"
 	MethodPlay         = "PLAY"
 	MethodTeardown     = "TEARDOWN"
+	MethodPause        = "PAUSE"
 	MethodGetParameter = "GET_PARAMETER"
+	MethodSetParameter = "SET_PARAMETER"
 )
 
 const (
@@ -61,6 +64,8 @@ const (
 	HeaderTransportClientRecordTcpTmpl = "RTP/AVP/TCP;unicast;interleaved=%d-%d;mode=record"
 	HeaderTransportServerPlayTmpl      = "RTP/AVP/UDP;unicast;client_port=%d-%d;server_port=%d-%d"
 
+	HeaderTransportServerPlayMulticastTmpl = "RTP/AVP;multicast;destination=%s;port=%d-%d;ttl=%d" // ip, rtpPort, rtcpPort, ttl
+
 	//HeaderTransportServerPlayTCPTmpl   = "RTP/AVP/TCP;unicast;interleaved=%d-%d"
 
 	HeaderTransportServerRecordTmpl = "RTP/AVP/UDP;unicast;client_port=%d-%d;server_port=%d-%d;mode=record"
@@ -72,21 +77,43 @@ const (
 	TransportFieldClientPort  = "client_port"
 	TransportFieldServerPort  = "server_port"
 	TransportFieldInterleaved = "interleaved"
+	TransportFieldMulticast   = "multicast"
 )
 
 const (
 	Interleaved = uint8(0x24)
 )
 
-var (
-	// TODO chef: 参考协议标准，不要使用固定值
-	sessionId = "191201771"
+// rfc2326 7.1.1 Status-Code
+const (
+	StatusParameterNotUnderstood = 451
+	StatusSessionNotFound        = 454
+	StatusMethodNotValidInState  = 455
+	StatusUnsupportedTransport   = 461
+	StatusNotImplemented         = 501
+)
+
+// DefaultSessionTimeoutSec rfc2326 12.37 Session中timeout参数的默认值
+const DefaultSessionTimeoutSec = 60
 
+var (
 	minServerPort = uint16(30000)
 	maxServerPort = uint16(60000)
 
 	unpackerItemMaxSize = 1024
 
+	// 输出类型的session发送rtcp sr的间隔，rfc3550 6.2 建议的最小间隔为5秒
+	srIntervalMs int64 = 5000
+
+	// 输入类型的session请求重传丢失的包，每个包最多请求的次数，两次请求的间隔，
+	// 以及检测到丢包后第一次请求前等待乱序包的时间
+	nackMaxRetries      = 3
+	nackRetryIntervalMs = 40
+	nackReorderWaitMs   = 20
+
+	// 输出类型的session缓存最近发送的rtp包的个数，用于响应对端的nack
+	retransmitBufferSize = 1024
+
 	serverCommandSessionReadBufSize   = 256
 	serverCommandSessionWriteChanSize = 1024
 
@@ -108,6 +135,13 @@ type IInterleavedPacketWriter interface {
 
 var availUdpConnPool *nazanet.AvailUdpConnPool
 
+// genSessionId 生成随机的session id，rfc2326 3.4 要求至少8个字符，并且难以猜测
+func genSessionId() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return strings.ToUpper(hex.EncodeToString(b))
+}
+
 // 传入远端IP，RtpPort，RtcpPort，创建两个对应的RTP和RTCP的UDP连接对象，以及对应的本端端口
 func initConnWithClientPort(rHost string, rRtpPort, rRtcpPort uint16) (rtpConn, rtcpConn *nazanet.UdpConnection, lRtpPort, lRtcpPort uint16, err error) {
 	// NOTICE
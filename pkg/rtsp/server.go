@@ -11,6 +11,8 @@ package rtsp
 import (
 	"crypto/tls"
 	"net"
+	"sync"
+	"time"
 )
 
 type IServerObserver interface {
@@ -63,16 +65,47 @@ type Server struct {
 
 	ln   net.Listener
 	auth ServerAuthConfig
+
+	sessionTimeoutSec int
+	multicastPool     *MulticastAddrPool
+	inLossConfig      InLossConfig
+
+	mutex    sync.Mutex
+	sessions map[*ServerCommandSession]struct{}
+	tunnels  map[string]*httpTunnelConn // key: x-sessioncookie
 }
 
 func NewServer(addr string, observer IServerObserver, auth ServerAuthConfig) *Server {
 	return &Server{
-		addr:     addr,
-		observer: observer,
-		auth:     auth,
+		addr:              addr,
+		observer:          observer,
+		auth:              auth,
+		sessionTimeoutSec: DefaultSessionTimeoutSec,
+		sessions:          make(map[*ServerCommandSession]struct{}),
+		tunnels:           make(map[string]*httpTunnelConn),
 	}
 }
 
+// SetSessionTimeoutSec 设置session超时时间，通过SETUP响应中Session header的timeout参数告知客户端
+//
+// 客户端在超时时间内没有发送任何信令或rtp/rtcp数据时，服务端主动关闭session
+// 小于等于0时不做超时检查。需要在 RunLoop 之前调用，默认值为 DefaultSessionTimeoutSec
+func (s *Server) SetSessionTimeoutSec(sec int) {
+	s.sessionTimeoutSec = sec
+}
+
+// SetMulticastAddrPool 开启sub session的组播，需要在 RunLoop 之前调用
+//
+// 多个 Server 可以共用一个地址池，组播的rtp数据由上层通过 MulticastSender 发送，见 SubSession.MulticastDest
+func (s *Server) SetMulticastAddrPool(pool *MulticastAddrPool) {
+	s.multicastPool = pool
+}
+
+// SetInLossConfig 设置pub session通过UDP接收rtp时的丢包处理，需要在 RunLoop 之前调用
+func (s *Server) SetInLossConfig(config InLossConfig) {
+	s.inLossConfig = config
+}
+
 func (s *Server) Listen() (err error) {
 	s.ln, err = net.Listen("tcp", s.addr)
 	if err != nil {
@@ -97,6 +130,12 @@ func (s *Server) ListenWithTLS(certFile, keyFile string) (err error) {
 }
 
 func (s *Server) RunLoop() error {
+	if s.sessionTimeoutSec > 0 {
+		exitChan := make(chan struct{})
+		defer close(exitChan)
+		go s.runTimeoutCheckLoop(exitChan)
+	}
+
 	for {
 		conn, err := s.ln.Accept()
 		if err != nil {
@@ -141,11 +180,22 @@ func (s *Server) OnDelRtspSubSession(session *SubSession) {
 
 func (s *Server) handleTcpConnect(conn net.Conn) {
 	session := NewServerCommandSession(s, conn, s.auth)
+	session.sessionTimeoutSec = s.sessionTimeoutSec
+	session.multicastPool = s.multicastPool
+	session.inLossConfig = s.inLossConfig
 	s.observer.OnNewRtspSessionConnect(session)
 
+	s.mutex.Lock()
+	s.sessions[session] = struct{}{}
+	s.mutex.Unlock()
+
 	err := session.RunLoop()
 	Log.Info(err)
 
+	s.mutex.Lock()
+	delete(s.sessions, session)
+	s.mutex.Unlock()
+
 	if session.pubSession != nil {
 		s.observer.OnDelRtspPubSession(session.pubSession)
 		_ = session.pubSession.Dispose()
@@ -155,3 +205,27 @@ func (s *Server) handleTcpConnect(conn net.Conn) {
 	}
 	s.observer.OnDelRtspSession(session)
 }
+
+// runTimeoutCheckLoop 定时检查所有session是否超时
+func (s *Server) runTimeoutCheckLoop(exitChan <-chan struct{}) {
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+	for {
+		select {
+		case <-exitChan:
+			return
+		case now := <-t.C:
+			nowMs := now.UnixNano() / 1e6
+			s.mutex.Lock()
+			for session := range s.sessions {
+				if session.isTimeout(nowMs) {
+					Log.Warnf("[%s] session timeout. timeout=%ds", session.UniqueKey(), s.sessionTimeoutSec)
+					// 关闭连接后，handleTcpConnect 中的 RunLoop 退出，由 handleTcpConnect 做后续清理
+					delete(s.sessions, session)
+					_ = session.Dispose()
+				}
+			}
+			s.mutex.Unlock()
+		}
+	}
+}
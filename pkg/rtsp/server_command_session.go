@@ -13,6 +13,7 @@ import (
 	"fmt"
 	"net"
 	"strings"
+	"sync"
 
 	"github.com/q191201771/naza/pkg/nazaerrors"
 
@@ -63,18 +64,31 @@ type ServerCommandSession struct {
 	authConf     ServerAuthConfig
 	auth         Auth
 
+	sessionId         string             // const after ctor
+	sessionTimeoutSec int                // 小于等于0时不做超时检查，由 Server 在RunLoop之前设置
+	multicastPool     *MulticastAddrPool // 为nil时不支持组播，由 Server 在RunLoop之前设置
+	inLossConfig      InLossConfig       // 由 Server 在RunLoop之前设置
+
+	mu         sync.Mutex // 超时检查协程读取pubSession和subSession时使用
 	pubSession *PubSession
 	subSession *SubSession
 
 	describeSeq string // only for sub session
+	hasPlayed   bool   // only for sub session
+
+	// 以下只在超时检查协程中使用
+	prevActiveBytes uint64
+	prevActiveMs    int64
 }
 
 func NewServerCommandSession(observer IServerCommandSessionObserver, conn net.Conn, authConf ServerAuthConfig) *ServerCommandSession {
 	uk := base.GenUkRtspServerCommandSession()
 	s := &ServerCommandSession{
-		uniqueKey: uk,
-		observer:  observer,
-		authConf:  authConf,
+		uniqueKey:         uk,
+		observer:          observer,
+		authConf:          authConf,
+		sessionId:         genSessionId(),
+		sessionTimeoutSec: DefaultSessionTimeoutSec,
 		conn: connection.New(conn, func(option *connection.Option) {
 			option.ReadBufSize = serverCommandSessionReadBufSize
 			option.WriteChanSize = serverCommandSessionWriteChanSize
@@ -110,6 +124,11 @@ func (session *ServerCommandSession) RemoteAddr() string {
 	return session.conn.RemoteAddr().String()
 }
 
+// SessionId rfc2326 12.37 Session，每个连接随机生成
+func (session *ServerCommandSession) SessionId() string {
+	return session.sessionId
+}
+
 // ----- ISessionStat --------------------------------------------------------------------------------------------------
 
 func (session *ServerCommandSession) UpdateStat(intervalSec uint32) {
@@ -184,6 +203,14 @@ Loop:
 		Log.Debugf("[%s] read http request. method=%s, uri=%s, version=%s, headers=%+v, body=%s",
 			session.uniqueKey, requestCtx.Method, requestCtx.Uri, requestCtx.Version, requestCtx.Headers, string(requestCtx.Body))
 
+		if !session.isSessionMatched(requestCtx) {
+			Log.Warnf("[%s] session id mismatch. method=%s, session=%s", session.uniqueKey, requestCtx.Method, requestCtx.Headers.Get(HeaderSession))
+			if err = session.writeStatus(StatusSessionNotFound, "Session Not Found", requestCtx); err != nil {
+				break Loop
+			}
+			continue
+		}
+
 		var handleMsgErr error
 		switch requestCtx.Method {
 		case MethodOptions:
@@ -204,12 +231,22 @@ Loop:
 		case MethodPlay:
 			// sub
 			handleMsgErr = session.handlePlay(requestCtx)
+		case MethodPause:
+			// sub
+			handleMsgErr = session.handlePause(requestCtx)
+		case MethodGetParameter:
+			// pub, sub
+			handleMsgErr = session.handleGetParameter(requestCtx)
+		case MethodSetParameter:
+			// pub, sub
+			handleMsgErr = session.handleSetParameter(requestCtx)
 		case MethodTeardown:
 			// pub
 			handleMsgErr = session.handleTeardown(requestCtx)
 			break Loop
 		default:
 			Log.Errorf("[%s] unknown rtsp message. method=%s", session.uniqueKey, requestCtx.Method)
+			handleMsgErr = session.writeStatus(StatusNotImplemented, "Not Implemented", requestCtx)
 		}
 		if handleMsgErr != nil {
 			Log.Errorf("[%s] handle rtsp message error. err=%+v, ctx=%+v", session.uniqueKey, handleMsgErr, requestCtx)
@@ -245,7 +282,12 @@ func (session *ServerCommandSession) handleAnnounce(requestCtx nazahttp.HttpReqM
 		return err
 	}
 
-	session.pubSession = NewPubSession(urlCtx, session)
+	pubSession := NewPubSession(urlCtx, session)
+	pubSession.header = requestCtx.Headers
+	pubSession.baseInSession.SetLossConfig(session.inLossConfig)
+	session.mu.Lock()
+	session.pubSession = pubSession
+	session.mu.Unlock()
 	Log.Infof("[%s] link new PubSession. [%s]", session.uniqueKey, session.pubSession.UniqueKey())
 	session.pubSession.InitWithSdp(sdpCtx)
 
@@ -282,7 +324,11 @@ func (session *ServerCommandSession) handleDescribe(requestCtx nazahttp.HttpReqM
 
 	session.describeSeq = requestCtx.Headers.Get(HeaderCSeq)
 
-	session.subSession = NewSubSession(urlCtx, session)
+	subSession := NewSubSession(urlCtx, session)
+	subSession.header = requestCtx.Headers
+	session.mu.Lock()
+	session.subSession = subSession
+	session.mu.Unlock()
 	Log.Infof("[%s] link new SubSession. [%s]", session.uniqueKey, session.subSession.UniqueKey())
 	ok, rawSdp := session.observer.OnNewRtspSubSessionDescribe(session.subSession)
 	if !ok {
@@ -347,8 +393,12 @@ func (session *ServerCommandSession) handleSetup(requestCtx nazahttp.HttpReqMsgC
 	remoteAddr := session.conn.RemoteAddr().String()
 	host, _, _ := net.SplitHostPort(remoteAddr)
 
-	// 是否为interleaved模式
 	htv := requestCtx.Headers.Get(HeaderTransport)
+	if strings.Contains(htv, TransportFieldMulticast) {
+		return session.handleSetupMulticast(requestCtx)
+	}
+
+	// 是否为interleaved模式
 	if strings.Contains(htv, TransportFieldInterleaved) {
 		rtpChannel, rtcpChannel, err := parseRtpRtcpChannel(htv)
 		if err != nil {
@@ -370,7 +420,7 @@ func (session *ServerCommandSession) handleSetup(requestCtx nazahttp.HttpReqMsgC
 			return nazaerrors.Wrap(base.ErrRtsp)
 		}
 
-		resp := PackResponseSetup(requestCtx.Headers.Get(HeaderCSeq), htv)
+		resp := PackResponseSetup(requestCtx.Headers.Get(HeaderCSeq), session.sessionHeader(), htv)
 		_, err = session.conn.Write([]byte(resp))
 		return err
 	}
@@ -405,14 +455,32 @@ func (session *ServerCommandSession) handleSetup(requestCtx nazahttp.HttpReqMsgC
 		return nazaerrors.Wrap(base.ErrRtsp)
 	}
 
-	resp := PackResponseSetup(requestCtx.Headers.Get(HeaderCSeq), htv)
+	resp := PackResponseSetup(requestCtx.Headers.Get(HeaderCSeq), session.sessionHeader(), htv)
+	_, err = session.conn.Write([]byte(resp))
+	return err
+}
+
+// handleSetupMulticast 只支持sub session
+func (session *ServerCommandSession) handleSetupMulticast(requestCtx nazahttp.HttpReqMsgCtx) error {
+	if session.multicastPool == nil || session.subSession == nil {
+		Log.Warnf("[%s] multicast not supported. transport=%s", session.uniqueKey, requestCtx.Headers.Get(HeaderTransport))
+		return session.writeStatus(StatusUnsupportedTransport, "Unsupported Transport", requestCtx)
+	}
+
+	htv, err := session.subSession.SetupWithMulticast(requestCtx.Uri, session.multicastPool)
+	if err != nil {
+		Log.Errorf("[%s] setup multicast error. err=%+v", session.uniqueKey, err)
+		return session.writeStatus(StatusUnsupportedTransport, "Unsupported Transport", requestCtx)
+	}
+
+	resp := PackResponseSetup(requestCtx.Headers.Get(HeaderCSeq), session.sessionHeader(), htv)
 	_, err = session.conn.Write([]byte(resp))
 	return err
 }
 
 func (session *ServerCommandSession) handleRecord(requestCtx nazahttp.HttpReqMsgCtx) error {
 	Log.Infof("[%s] < R RECORD", session.uniqueKey)
-	resp := PackResponseRecord(requestCtx.Headers.Get(HeaderCSeq))
+	resp := PackResponseRecord(requestCtx.Headers.Get(HeaderCSeq), session.sessionId)
 	_, err := session.conn.Write([]byte(resp))
 	return err
 }
@@ -426,11 +494,56 @@ func (session *ServerCommandSession) handlePlay(requestCtx nazahttp.HttpReqMsgCt
 		return base.ErrRtsp
 	}
 
-	// TODO(chef): [opt] 上层关闭，可以考虑回复非200状态码再关闭
-	if err := session.observer.OnNewRtspSubSessionPlay(session.subSession); err != nil {
-		return err
+	if !session.hasPlayed {
+		// TODO(chef): [opt] 上层关闭，可以考虑回复非200状态码再关闭
+		if err := session.observer.OnNewRtspSubSessionPlay(session.subSession); err != nil {
+			return err
+		}
+		session.hasPlayed = true
+	} else if session.subSession.paused.Load() {
+		// 暂停后恢复，数据从当前位置继续发送，客户端在下一个关键帧后恢复画面
+		Log.Infof("[%s] resume SubSession. [%s]", session.uniqueKey, session.subSession.UniqueKey())
+		session.subSession.paused.Store(false)
 	}
-	resp := PackResponsePlay(requestCtx.Headers.Get(HeaderCSeq))
+
+	resp := PackResponsePlay(requestCtx.Headers.Get(HeaderCSeq), session.sessionId)
+	_, err := session.conn.Write([]byte(resp))
+	return err
+}
+
+func (session *ServerCommandSession) handlePause(requestCtx nazahttp.HttpReqMsgCtx) error {
+	Log.Infof("[%s] < R PAUSE", session.uniqueKey)
+
+	// 只支持sub session，并且需要在PLAY之后
+	if session.subSession == nil || !session.hasPlayed {
+		Log.Warnf("[%s] handlePause but subSession not playing.", session.uniqueKey)
+		return session.writeStatus(StatusMethodNotValidInState, "Method Not Valid in This State", requestCtx)
+	}
+
+	Log.Infof("[%s] pause SubSession. [%s]", session.uniqueKey, session.subSession.UniqueKey())
+	session.subSession.paused.Store(true)
+
+	resp := PackResponsePause(requestCtx.Headers.Get(HeaderCSeq), session.sessionId)
+	_, err := session.conn.Write([]byte(resp))
+	return err
+}
+
+// handleGetParameter 不支持任何参数，客户端(比如VLC、NVR)一般用作心跳
+func (session *ServerCommandSession) handleGetParameter(requestCtx nazahttp.HttpReqMsgCtx) error {
+	Log.Debugf("[%s] < R GET_PARAMETER", session.uniqueKey)
+	resp := PackResponseGetParameter(requestCtx.Headers.Get(HeaderCSeq), session.sessionId)
+	_, err := session.conn.Write([]byte(resp))
+	return err
+}
+
+// handleSetParameter 不支持任何参数，body为空时作为心跳处理
+func (session *ServerCommandSession) handleSetParameter(requestCtx nazahttp.HttpReqMsgCtx) error {
+	Log.Debugf("[%s] < R SET_PARAMETER", session.uniqueKey)
+	if len(requestCtx.Body) != 0 {
+		Log.Warnf("[%s] set parameter not supported. body=%s", session.uniqueKey, string(requestCtx.Body))
+		return session.writeStatus(StatusParameterNotUnderstood, "Parameter Not Understood", requestCtx)
+	}
+	resp := PackResponseSetParameter(requestCtx.Headers.Get(HeaderCSeq), session.sessionId)
 	_, err := session.conn.Write([]byte(resp))
 	return err
 }
@@ -441,3 +554,58 @@ func (session *ServerCommandSession) handleTeardown(requestCtx nazahttp.HttpReqM
 	_, err := session.conn.Write([]byte(resp))
 	return err
 }
+
+func (session *ServerCommandSession) writeStatus(code int, reason string, requestCtx nazahttp.HttpReqMsgCtx) error {
+	resp := PackResponseStatus(code, reason, requestCtx.Headers.Get(HeaderCSeq))
+	_, err := session.conn.Write([]byte(resp))
+	return err
+}
+
+// sessionHeader SETUP响应中Session header的值，携带timeout参数
+func (session *ServerCommandSession) sessionHeader() string {
+	if session.sessionTimeoutSec <= 0 {
+		return session.sessionId
+	}
+	return fmt.Sprintf("%s;timeout=%d", session.sessionId, session.sessionTimeoutSec)
+}
+
+// isSessionMatched 会话内的信令如果携带了Session header，需要与本端分配的session id一致
+func (session *ServerCommandSession) isSessionMatched(requestCtx nazahttp.HttpReqMsgCtx) bool {
+	switch requestCtx.Method {
+	case MethodPlay, MethodPause, MethodRecord, MethodTeardown, MethodGetParameter, MethodSetParameter:
+	default:
+		return true
+	}
+	v := requestCtx.Headers.Get(HeaderSession)
+	if v == "" {
+		return true
+	}
+	return strings.TrimSpace(strings.Split(v, ";")[0]) == session.sessionId
+}
+
+// isTimeout 由 Server 的超时检查协程定时调用
+//
+// 信令连接、udp rtp/rtcp连接在超时时间内都没有读取到数据，并且interleaved的sub session也没有发送数据时，认为超时，
+// udp和组播的sub session发送数据不算活跃，见 SubSession.activeBytesSum
+func (session *ServerCommandSession) isTimeout(nowMs int64) bool {
+	if session.sessionTimeoutSec <= 0 {
+		return false
+	}
+
+	activeBytes := session.conn.GetStat().ReadBytesSum
+	session.mu.Lock()
+	if session.pubSession != nil {
+		activeBytes += session.pubSession.baseInSession.readBytesSum()
+	}
+	if session.subSession != nil {
+		activeBytes += session.subSession.activeBytesSum()
+	}
+	session.mu.Unlock()
+
+	if session.prevActiveMs == 0 || activeBytes != session.prevActiveBytes {
+		session.prevActiveBytes = activeBytes
+		session.prevActiveMs = nowMs
+		return false
+	}
+	return nowMs-session.prevActiveMs >= int64(session.sessionTimeoutSec)*1000
+}
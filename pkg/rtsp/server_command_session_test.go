@@ -0,0 +1,120 @@
+// Copyright 2023, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package rtsp_test
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/q191201771/lal/pkg/rtsp"
+	"github.com/q191201771/naza/pkg/assert"
+	"github.com/q191201771/naza/pkg/nazahttp"
+)
+
+var testSdp = "v=0\r\n" +
+	"o=- 0 0 IN IP4 127.0.0.1\r\n" +
+	"s=No Name\r\n" +
+	"t=0 0\r\n" +
+	"m=video 0 RTP/AVP 96\r\n" +
+	"a=rtpmap:96 H264/90000\r\n" +
+	"a=fmtp:96 packetization-mode=1; sprop-parameter-sets=Z2QAIKzZQMApsBEAAAMAAQAAAwAyDxgxlg==,aOvssiw=; profile-level-id=640020\r\n" +
+	"a=control:streamid=0\r\n"
+
+type testServerCommandSessionObserver struct {
+	playCount int
+}
+
+func (o *testServerCommandSessionObserver) OnNewRtspPubSession(session *rtsp.PubSession) error {
+	return nil
+}
+
+func (o *testServerCommandSessionObserver) OnNewRtspSubSessionDescribe(session *rtsp.SubSession) (ok bool, sdp []byte) {
+	return true, []byte(testSdp)
+}
+
+func (o *testServerCommandSessionObserver) OnNewRtspSubSessionPlay(session *rtsp.SubSession) error {
+	o.playCount++
+	return nil
+}
+
+func TestServerCommandSession(t *testing.T) {
+	var observer testServerCommandSessionObserver
+	c, s := net.Pipe()
+	session := rtsp.NewServerCommandSession(&observer, s, rtsp.ServerAuthConfig{})
+	go session.RunLoop()
+	defer session.Dispose()
+
+	r := bufio.NewReader(c)
+	uri := "rtsp://127.0.0.1:5544/live/test110"
+	request := func(method, cseq string, headers map[string]string, body string) nazahttp.HttpRespMsgCtx {
+		h := map[string]string{rtsp.HeaderCSeq: cseq}
+		for k, v := range headers {
+			h[k] = v
+		}
+		if body != "" {
+			h[rtsp.HeaderContentLength] = strconv.Itoa(len(body))
+		}
+		_, err := c.Write([]byte(rtsp.PackRequest(method, uri, h, body)))
+		assert.Equal(t, nil, err)
+		resp, err := nazahttp.ReadHttpResponseMessage(r)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, cseq, resp.Headers.Get(rtsp.HeaderCSeq))
+		return resp
+	}
+
+	resp := request(rtsp.MethodOptions, "1", nil, "")
+	assert.Equal(t, "200", resp.StatusCode)
+	assert.Equal(t, true, strings.Contains(resp.Headers.Get(rtsp.HeaderPublic), rtsp.MethodGetParameter))
+
+	resp = request(rtsp.MethodPause, "2", nil, "")
+	assert.Equal(t, "455", resp.StatusCode)
+
+	resp = request(rtsp.MethodDescribe, "3", nil, "")
+	assert.Equal(t, "200", resp.StatusCode)
+
+	uri += "/streamid=0"
+	// 没有设置组播地址池
+	resp = request(rtsp.MethodSetup, "4", map[string]string{rtsp.HeaderTransport: "RTP/AVP;multicast"}, "")
+	assert.Equal(t, "461", resp.StatusCode)
+
+	resp = request(rtsp.MethodSetup, "4", map[string]string{rtsp.HeaderTransport: "RTP/AVP/TCP;unicast;interleaved=0-1"}, "")
+	assert.Equal(t, "200", resp.StatusCode)
+	assert.Equal(t, session.SessionId()+";timeout=60", resp.Headers.Get(rtsp.HeaderSession))
+	assert.Equal(t, 16, len(session.SessionId()))
+
+	resp = request(rtsp.MethodPlay, "5", map[string]string{rtsp.HeaderSession: "191201771"}, "")
+	assert.Equal(t, "454", resp.StatusCode)
+	assert.Equal(t, 0, observer.playCount)
+
+	sessionHeader := map[string]string{rtsp.HeaderSession: session.SessionId()}
+	resp = request(rtsp.MethodPlay, "6", sessionHeader, "")
+	assert.Equal(t, "200", resp.StatusCode)
+	assert.Equal(t, 1, observer.playCount)
+
+	resp = request(rtsp.MethodGetParameter, "7", sessionHeader, "")
+	assert.Equal(t, "200", resp.StatusCode)
+	assert.Equal(t, session.SessionId(), resp.Headers.Get(rtsp.HeaderSession))
+
+	resp = request(rtsp.MethodSetParameter, "8", sessionHeader, "a: b")
+	assert.Equal(t, "451", resp.StatusCode)
+
+	resp = request(rtsp.MethodPause, "9", sessionHeader, "")
+	assert.Equal(t, "200", resp.StatusCode)
+
+	// 恢复播放时不再回调上层
+	resp = request(rtsp.MethodPlay, "10", sessionHeader, "")
+	assert.Equal(t, "200", resp.StatusCode)
+	assert.Equal(t, 1, observer.playCount)
+
+	resp = request("REDIRECT", "11", nil, "")
+	assert.Equal(t, "501", resp.StatusCode)
+}
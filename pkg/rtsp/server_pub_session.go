@@ -13,6 +13,7 @@ import (
 	"github.com/q191201771/naza/pkg/nazanet"
 
 	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/rtprtcp"
 	"github.com/q191201771/lal/pkg/sdp"
 )
 
@@ -24,6 +25,7 @@ type PubSession struct {
 	urlCtx        base.UrlContext
 	cmdSession    *ServerCommandSession
 	baseInSession *BaseInSession
+	header        map[string][]string // ANNOUNCE信令的header
 
 	observer IPubSessionObserver
 }
@@ -66,6 +68,10 @@ func (session *PubSession) GetSdp() sdp.LogicContext {
 	return session.baseInSession.GetSdp()
 }
 
+func (session *PubSession) GetRtpRecvStat() (audio, video rtprtcp.RrStat) {
+	return session.baseInSession.GetRtpRecvStat()
+}
+
 func (session *PubSession) HandleInterleavedPacket(b []byte, channel int) {
 	session.baseInSession.HandleInterleavedPacket(b, channel)
 }
@@ -75,7 +81,7 @@ func (session *PubSession) Url() string {
 }
 
 func (session *PubSession) Header() map[string][]string {
-	return nil
+	return session.header
 }
 
 func (session *PubSession) AppName() string {
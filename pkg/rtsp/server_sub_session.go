@@ -9,9 +9,13 @@
 package rtsp
 
 import (
+	"fmt"
+	"sync"
+
 	"github.com/q191201771/lal/pkg/base"
 	"github.com/q191201771/lal/pkg/rtprtcp"
 	"github.com/q191201771/lal/pkg/sdp"
+	"github.com/q191201771/naza/pkg/nazaatomic"
 	"github.com/q191201771/naza/pkg/nazaerrors"
 	"github.com/q191201771/naza/pkg/nazanet"
 )
@@ -20,6 +24,15 @@ type SubSession struct {
 	urlCtx         base.UrlContext
 	cmdSession     *ServerCommandSession
 	baseOutSession *BaseOutSession
+	header         map[string][]string // DESCRIBE信令的header
+	paused         nazaatomic.Bool     // 收到PAUSE信令后，直到再次收到PLAY信令前，不发送数据
+
+	// 组播时使用，数据由上层的 MulticastSender 发送
+	multicastKey         string // 见 SetMulticastKey
+	multicastPool        *MulticastAddrPool
+	multicastDest        *MulticastDest
+	multicastReleaseOnce sync.Once
+	isMulticast          nazaatomic.Bool // 超时检查协程读取
 
 	ShouldWaitVideoKeyFrame bool
 }
@@ -55,17 +68,92 @@ func (session *SubSession) SetupWithChannel(uri string, rtpChannel, rtcpChannel
 	return session.baseOutSession.SetupWithChannel(uri, rtpChannel, rtcpChannel)
 }
 
+// SetMulticastKey 设置从组播地址池分配地址时使用的key，默认为`<appName>/<streamName>`
+//
+// 同一个key的组播sub session使用相同的组播地址。鉴权修改了流名称，或者上层映射流时忽略了appName时，
+// 上层应该在 OnNewRtspSubSessionDescribe 中设置为实际所属流的key
+func (session *SubSession) SetMulticastKey(key string) {
+	session.multicastKey = key
+}
+
+// SetupWithMulticast 供 ServerCommandSession 调用
+//
+// 第一次调用时从地址池中分配组播地址，同一路流的所有组播sub session使用相同的地址
+//
+// @return htv: SETUP响应中的Transport
+func (session *SubSession) SetupWithMulticast(uri string, pool *MulticastAddrPool) (htv string, err error) {
+	if session.multicastDest == nil {
+		if session.multicastKey == "" {
+			session.multicastKey = session.AppName() + "/" + session.StreamName()
+		}
+		dest, err := pool.Acquire(session.multicastKey)
+		if err != nil {
+			return "", err
+		}
+		session.multicastPool = pool
+		session.multicastDest = &dest
+		session.isMulticast.Store(true)
+	}
+
+	dest := session.multicastDest
+	var rtpPort int
+	if session.baseOutSession.sdpCtx.IsVideoUri(uri) {
+		rtpPort = dest.VideoRtpPort
+	} else if session.baseOutSession.sdpCtx.IsAudioUri(uri) {
+		rtpPort = dest.AudioRtpPort
+	} else {
+		return "", nazaerrors.Wrap(base.ErrRtsp)
+	}
+	return fmt.Sprintf(HeaderTransportServerPlayMulticastTmpl, dest.Ip.String(), rtpPort, rtpPort+1, dest.Ttl), nil
+}
+
+// MulticastDest 是否为组播，以及组播的目的地址
+func (session *SubSession) MulticastDest() (MulticastDest, bool) {
+	if session.multicastDest == nil {
+		return MulticastDest{}, false
+	}
+	return *session.multicastDest, true
+}
+
 func (session *SubSession) WriteRtpPacket(packet rtprtcp.RtpPacket) {
+	if session.paused.Load() {
+		return
+	}
+	if session.multicastDest != nil {
+		// 组播时数据由上层的 MulticastSender 统一发送，这里只做统计，不用于超时检查，见 activeBytesSum
+		session.baseOutSession.sessionStat.AddWriteBytes(len(packet.Raw))
+		return
+	}
 	session.baseOutSession.WriteRtpPacket(packet)
 }
 
+// IsPaused 是否处于暂停状态，暂停期间 WriteRtpPacket 直接丢弃数据
+func (session *SubSession) IsPaused() bool {
+	return session.paused.Load()
+}
+
 func (session *SubSession) Dispose() error {
 	Log.Infof("[%s] lifecycle dispose rtsp SubSession. session=%p", session.UniqueKey(), session)
+	if session.multicastPool != nil {
+		session.multicastReleaseOnce.Do(func() {
+			session.multicastPool.Release(session.multicastKey)
+		})
+	}
 	e1 := session.baseOutSession.Dispose()
 	e2 := session.cmdSession.Dispose()
 	return nazaerrors.CombineErrors(e1, e2)
 }
 
+// activeBytesSum 供超时检查使用
+//
+// 组播的数据不是发给某个播放端的，发送成功不能说明播放端还存活，所以只依靠信令保活
+func (session *SubSession) activeBytesSum() uint64 {
+	if session.isMulticast.Load() {
+		return 0
+	}
+	return session.baseOutSession.activeBytesSum()
+}
+
 func (session *SubSession) HandleInterleavedPacket(b []byte, channel int) {
 	session.baseOutSession.HandleInterleavedPacket(b, channel)
 }
@@ -75,7 +163,7 @@ func (session *SubSession) Url() string {
 }
 
 func (session *SubSession) Header() map[string][]string {
-	return nil
+	return session.header
 }
 
 func (session *SubSession) AppName() string {
@@ -22,6 +22,20 @@ import (
 )
 
 func Pack(vps, sps, pps, asc []byte) (ctx LogicContext, err error) {
+	audioPt := base.AvPacketPtUnknown
+	if asc != nil {
+		audioPt = base.AvPacketPtAac
+	}
+	return PackWithAudioPt(vps, sps, pps, audioPt, asc)
+}
+
+// PackWithAudioPt 与 Pack 相同，区别是可以指定音频的类型
+//
+// @param audioPt:
+//   - base.AvPacketPtAac 时需要传入asc
+//   - base.AvPacketPtG711A，base.AvPacketPtG711U，base.AvPacketPtOpus，base.AvPacketPtMp3 时不需要asc
+//   - base.AvPacketPtUnknown 表示没有音频
+func PackWithAudioPt(vps, sps, pps []byte, audioPt base.AvPacketPt, asc []byte) (ctx LogicContext, err error) {
 	// 判断音频、视频是否存在，以及视频是H264还是H265
 	var hasAudio, hasVideo, isHevc bool
 	if sps != nil && pps != nil {
@@ -30,7 +44,10 @@ func Pack(vps, sps, pps, asc []byte) (ctx LogicContext, err error) {
 			isHevc = true
 		}
 	}
-	if asc != nil {
+	switch audioPt {
+	case base.AvPacketPtAac:
+		hasAudio = asc != nil
+	case base.AvPacketPtG711A, base.AvPacketPtG711U, base.AvPacketPtOpus, base.AvPacketPtMp3:
 		hasAudio = true
 	}
 
@@ -41,7 +58,7 @@ func Pack(vps, sps, pps, asc []byte) (ctx LogicContext, err error) {
 
 	// 判断AAC的采样率
 	var samplingFrequency int
-	if asc != nil {
+	if hasAudio && audioPt == base.AvPacketPtAac {
 		var ascCtx *aac.AscContext
 		ascCtx, err = aac.NewAscContext(asc)
 		if err != nil {
@@ -84,13 +101,42 @@ a=control:streamid=%d
 	}
 
 	if hasAudio {
-		tmpl := `m=audio 0 RTP/AVP 97
+		switch audioPt {
+		case base.AvPacketPtAac:
+			tmpl := `m=audio 0 RTP/AVP 97
 b=AS:128
 a=rtpmap:97 MPEG4-GENERIC/%d/2
 a=fmtp:97 profile-level-id=1;mode=AAC-hbr;sizelength=13;indexlength=3;indexdeltalength=3; config=%s
 a=control:streamid=%d
 `
-		sdpStr += fmt.Sprintf(tmpl, samplingFrequency, hex.EncodeToString(asc), streamid)
+			sdpStr += fmt.Sprintf(tmpl, samplingFrequency, hex.EncodeToString(asc), streamid)
+		case base.AvPacketPtG711A, base.AvPacketPtG711U:
+			// 使用rtp的静态payload type，PCMA为8，PCMU为0
+			name := ARtpMapEncodingNameG711A
+			if audioPt == base.AvPacketPtG711U {
+				name = ARtpMapEncodingNameG711U
+			}
+			tmpl := `m=audio 0 RTP/AVP %d
+a=rtpmap:%d %s/8000/1
+a=control:streamid=%d
+`
+			sdpStr += fmt.Sprintf(tmpl, audioPt.RtpPayloadType(), audioPt.RtpPayloadType(), name, streamid)
+		case base.AvPacketPtOpus:
+			// 见rfc7587，rtpmap中的时钟频率固定为48000，声道数固定为2
+			tmpl := `m=audio 0 RTP/AVP 111
+a=rtpmap:111 opus/48000/2
+a=fmtp:111 minptime=10;useinbandfec=1
+a=control:streamid=%d
+`
+			sdpStr += fmt.Sprintf(tmpl, streamid)
+		case base.AvPacketPtMp3:
+			// 见rfc3551，MPA使用静态payload type 14，时钟频率固定为90000
+			tmpl := `m=audio 0 RTP/AVP 14
+a=rtpmap:14 MPA/90000
+a=control:streamid=%d
+`
+			sdpStr += fmt.Sprintf(tmpl, streamid)
+		}
 	}
 
 	raw := []byte(strings.ReplaceAll(sdpStr, "\n", "\r\n"))
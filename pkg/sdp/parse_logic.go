@@ -52,7 +52,11 @@ func (lc *LogicContext) IsPayloadTypeOrigin(t int) bool {
 }
 
 func (lc *LogicContext) IsAudioUnpackable() bool {
-	return lc.audioPayloadTypeBase == base.AvPacketPtAac && lc.Asc != nil
+	return (lc.audioPayloadTypeBase == base.AvPacketPtAac && lc.Asc != nil) ||
+		lc.audioPayloadTypeBase == base.AvPacketPtG711A ||
+		lc.audioPayloadTypeBase == base.AvPacketPtG711U ||
+		lc.audioPayloadTypeBase == base.AvPacketPtOpus ||
+		lc.audioPayloadTypeBase == base.AvPacketPtMp3
 }
 
 func (lc *LogicContext) IsVideoUnpackable() bool {
@@ -101,6 +105,9 @@ func (lc *LogicContext) makeSetupUri(uri string, aControl string) string {
 
 func ParseSdp2LogicContext(b []byte) (LogicContext, error) {
 	var ret LogicContext
+	// 注意，未知类型需要显式初始化为 base.AvPacketPtUnknown
+	ret.audioPayloadTypeBase = base.AvPacketPtUnknown
+	ret.videoPayloadTypeBase = base.AvPacketPtUnknown
 
 	c, err := ParseSdp2RawContext(b)
 	if err != nil {
@@ -129,6 +136,14 @@ func ParseSdp2LogicContext(b []byte) (LogicContext, error) {
 				// 例子:a=rtpmap:8 PCMA/8000/1
 				// rtmpmap中有PCMA字段表示G711A
 				ret.audioPayloadTypeBase = base.AvPacketPtG711A
+			} else if strings.EqualFold(md.ARtpMap.EncodingName, ARtpMapEncodingNameG711U) {
+				ret.audioPayloadTypeBase = base.AvPacketPtG711U
+			} else if strings.EqualFold(md.ARtpMap.EncodingName, ARtpMapEncodingNameOpus) {
+				// 例子:a=rtpmap:111 opus/48000/2
+				ret.audioPayloadTypeBase = base.AvPacketPtOpus
+			} else if strings.EqualFold(md.ARtpMap.EncodingName, ARtpMapEncodingNameMpa) {
+				// 例子:a=rtpmap:14 MPA/90000
+				ret.audioPayloadTypeBase = base.AvPacketPtMp3
 			} else {
 				if md.M.PT == 8 {
 					// ffmpeg推流情况下不会填充rtpmap字段,m中pt值为8也可以表示是PCMA,采样率默认为8000Hz
@@ -138,6 +153,20 @@ func ParseSdp2LogicContext(b []byte) (LogicContext, error) {
 					if ret.AudioClockRate == 0 {
 						ret.AudioClockRate = 8000
 					}
+				} else if md.M.PT == 0 {
+					// 同上，RFC3551中表明G711U固定pt值为0
+					ret.audioPayloadTypeBase = base.AvPacketPtG711U
+					ret.audioPayloadTypeOrigin = 0
+					if ret.AudioClockRate == 0 {
+						ret.AudioClockRate = 8000
+					}
+				} else if md.M.PT == 14 {
+					// 同上，RFC3551中表明MPA固定pt值为14，时钟频率为90000
+					ret.audioPayloadTypeBase = base.AvPacketPtMp3
+					ret.audioPayloadTypeOrigin = 14
+					if ret.AudioClockRate == 0 {
+						ret.AudioClockRate = 90000
+					}
 				} else {
 					ret.audioPayloadTypeBase = base.AvPacketPtUnknown
 				}
@@ -678,7 +678,37 @@ a=control:trackID=2
 	golden = strings.ReplaceAll(golden, "\n", "\r\n")
 	ctx, err := ParseSdp2LogicContext([]byte(golden))
 	assert.Equal(t, nil, err)
-	_ = ctx
+	assert.Equal(t, true, ctx.IsAudioUnpackable())
+	assert.Equal(t, base.AvPacketPtG711U, ctx.GetAudioPayloadTypeBase())
+	assert.Equal(t, 8000, ctx.AudioClockRate)
+}
+
+func TestPackWithAudioPt(t *testing.T) {
+	ctx, err := PackWithAudioPt(nil, nil, nil, base.AvPacketPtG711A, nil)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, ctx.IsAudioUnpackable())
+	assert.Equal(t, base.AvPacketPtG711A, ctx.GetAudioPayloadTypeBase())
+	assert.Equal(t, true, ctx.IsAudioPayloadTypeOrigin(8))
+	assert.Equal(t, 8000, ctx.AudioClockRate)
+
+	ctx, err = PackWithAudioPt(nil, nil, nil, base.AvPacketPtG711U, nil)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, base.AvPacketPtG711U, ctx.GetAudioPayloadTypeBase())
+	assert.Equal(t, true, ctx.IsAudioPayloadTypeOrigin(0))
+
+	ctx, err = PackWithAudioPt(nil, nil, nil, base.AvPacketPtOpus, nil)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, ctx.IsAudioUnpackable())
+	assert.Equal(t, base.AvPacketPtOpus, ctx.GetAudioPayloadTypeBase())
+	assert.Equal(t, true, ctx.IsAudioPayloadTypeOrigin(111))
+	assert.Equal(t, 48000, ctx.AudioClockRate)
+
+	ctx, err = PackWithAudioPt(nil, nil, nil, base.AvPacketPtMp3, nil)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, ctx.IsAudioUnpackable())
+	assert.Equal(t, base.AvPacketPtMp3, ctx.GetAudioPayloadTypeBase())
+	assert.Equal(t, true, ctx.IsAudioPayloadTypeOrigin(14))
+	assert.Equal(t, 90000, ctx.AudioClockRate)
 }
 
 // 见 #251
@@ -701,5 +731,6 @@ a=control:streamid=1
 	ctx, err := ParseSdp2LogicContext([]byte(golden))
 	assert.Equal(t, nil, err)
 	assert.Equal(t, true, ctx.IsAudioPayloadTypeOrigin(8))
-	assert.Equal(t, false, ctx.IsAudioUnpackable())
+	assert.Equal(t, true, ctx.IsAudioUnpackable())
+	assert.Equal(t, base.AvPacketPtG711A, ctx.GetAudioPayloadTypeBase())
 }
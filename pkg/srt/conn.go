@@ -0,0 +1,556 @@
+// Copyright 2023, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package srt
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+)
+
+// conn 一个已经完成握手的srt连接
+//
+// 接收相关的状态只在 runLoop 所在的协程中访问，发送相关的状态由 Write 和 runLoop 共同访问，使用 sendMutex 保护
+type conn struct {
+	uniqueKey string
+	pconn     *net.UDPConn
+	raddr     *net.UDPAddr
+	stat      *base.BasicSessionStat
+
+	localSockId uint32
+	peerSockId  uint32
+	latency     time.Duration
+	idleTimeout time.Duration
+	startTime   time.Time
+
+	onData   func(b []byte)
+	onDelete func(c *conn) // 连接关闭时通知 Server
+
+	inCh        chan []byte
+	closeCh     chan struct{}
+	disposeOnce sync.Once
+	closeErr    error
+
+	// 接收
+	recvBuf        map[uint32]*recvItem
+	nextDeliverSeq uint32
+	maxRecvSeq     uint32
+	lossList       map[uint32]time.Time // key是丢失的序号，value是上次发送NAK的时间
+	lastAckSeq     uint32
+	ackNo          uint32
+	ackTimes       map[uint32]time.Time
+	rtt            time.Duration
+	rttVar         time.Duration
+	tsbpdBase      time.Time
+	tsLast         uint32
+	tsHigh         int64
+	lastRecvTime   time.Time
+
+	// 发送
+	sendMutex    sync.Mutex
+	nextSendSeq  uint32
+	msgNo        uint32
+	sendBuf      []*sendItem
+	lastSendTime time.Time
+}
+
+type recvItem struct {
+	payload   []byte
+	deliverAt time.Time
+}
+
+type sendItem struct {
+	seq    uint32
+	raw    []byte
+	sentAt time.Time
+}
+
+type connOption struct {
+	UniqueKey   string
+	PConn       *net.UDPConn
+	RAddr       *net.UDPAddr
+	Stat        *base.BasicSessionStat
+	LocalSockId uint32
+	PeerSockId  uint32
+	Isn         uint32
+	Latency     time.Duration
+	IdleTimeout time.Duration
+}
+
+const (
+	connInChanSize = 1024
+
+	// 单次检测到丢包时，最多记录的丢失个数，防止异常的序号导致内存暴涨
+	maxLossPerGap = 8192
+
+	maxSendBufSize = 8192
+)
+
+func newConn(option connOption) *conn {
+	now := time.Now()
+	isn := option.Isn & maxSeq
+	return &conn{
+		uniqueKey:      option.UniqueKey,
+		pconn:          option.PConn,
+		raddr:          option.RAddr,
+		stat:           option.Stat,
+		localSockId:    option.LocalSockId,
+		peerSockId:     option.PeerSockId,
+		latency:        option.Latency,
+		idleTimeout:    option.IdleTimeout,
+		startTime:      now,
+		inCh:           make(chan []byte, connInChanSize),
+		closeCh:        make(chan struct{}),
+		recvBuf:        make(map[uint32]*recvItem),
+		nextDeliverSeq: isn,
+		maxRecvSeq:     (isn - 1) & maxSeq,
+		lossList:       make(map[uint32]time.Time),
+		lastAckSeq:     isn,
+		ackTimes:       make(map[uint32]time.Time),
+		rtt:            100 * time.Millisecond,
+		rttVar:         50 * time.Millisecond,
+		lastRecvTime:   now,
+		nextSendSeq:    isn,
+		lastSendTime:   now,
+	}
+}
+
+// runLoop 阻塞直到连接关闭
+func (c *conn) runLoop() error {
+	ticker := time.NewTicker(ackIntervalMs * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.closeCh:
+			return c.closeErr
+		case b := <-c.inCh:
+			c.handlePacket(b)
+		case <-ticker.C:
+			c.onTick(time.Now())
+		}
+	}
+}
+
+// feed 由 Server 的读协程调用
+//
+// @param b: 函数调用结束后，内部继续持有该内存块
+func (c *conn) feed(b []byte) {
+	select {
+	case c.inCh <- b:
+	default:
+		// 队列满了直接丢弃，丢失的包后续会通过NAK重传
+		Log.Warnf("[%s] srt conn in chan full, drop packet.", c.uniqueKey)
+	}
+}
+
+// Write 将数据切分成多个data packet发送
+//
+// 注意，live模式下srt是基于消息的，每个data packet都是一个完整的消息，所以调用方应保证`b`按ts packet对齐
+func (c *conn) Write(b []byte) error {
+	select {
+	case <-c.closeCh:
+		return base.ErrSessionNotStarted
+	default:
+	}
+
+	c.sendMutex.Lock()
+	defer c.sendMutex.Unlock()
+
+	now := time.Now()
+	for len(b) > 0 {
+		n := MaxPayloadSize
+		if n > len(b) {
+			n = len(b)
+		}
+		c.msgNo = (c.msgNo + 1) & 0x03FFFFFF
+		if c.msgNo == 0 {
+			c.msgNo = 1
+		}
+		pkt := Packet{
+			Seq:       c.nextSendSeq,
+			Position:  packetPositionSolo,
+			MsgNo:     c.msgNo,
+			Timestamp: c.timestamp(now),
+			DstSockId: c.peerSockId,
+			Payload:   b[:n],
+		}
+		raw := pkt.Pack()
+		if err := c.send(raw, now); err != nil {
+			return err
+		}
+		c.stat.AddWriteBytes(len(raw))
+
+		c.sendBuf = append(c.sendBuf, &sendItem{seq: c.nextSendSeq, raw: raw, sentAt: now})
+		if len(c.sendBuf) > maxSendBufSize {
+			c.sendBuf = c.sendBuf[1:]
+		}
+		c.nextSendSeq = seqInc(c.nextSendSeq)
+		b = b[n:]
+	}
+	return nil
+}
+
+func (c *conn) dispose(err error) {
+	c.disposeOnce.Do(func() {
+		if err == nil {
+			// 本端主动关闭，通知对端
+			_ = c.sendControl(ctrlTypeShutdown, 0, make([]byte, 4))
+		}
+		c.closeErr = err
+		close(c.closeCh)
+		if c.onDelete != nil {
+			c.onDelete(c)
+		}
+	})
+}
+
+// ----- 接收 ----------------------------------------------------------------------------------------------------------
+
+func (c *conn) handlePacket(b []byte) {
+	pkt, err := ParsePacket(b)
+	if err != nil {
+		Log.Warnf("[%s] parse srt packet failed. err=%+v", c.uniqueKey, err)
+		return
+	}
+
+	now := time.Now()
+	c.lastRecvTime = now
+
+	if !pkt.IsControl {
+		c.stat.AddReadBytes(len(b))
+		c.handleDataPacket(pkt, now)
+		c.deliver(now)
+		return
+	}
+
+	switch pkt.CtrlType {
+	case ctrlTypeKeepalive:
+		// noop
+	case ctrlTypeAck:
+		c.handleAck(pkt)
+	case ctrlTypeNak:
+		c.handleNak(pkt, now)
+	case ctrlTypeAckAck:
+		c.handleAckAck(pkt, now)
+	case ctrlTypeShutdown:
+		Log.Infof("[%s] < R shutdown.", c.uniqueKey)
+		c.dispose(base.ErrSrtPeerShutdown)
+	case ctrlTypeDropReq:
+		// 对端发送端放弃重传了，我们依靠TLPKTDROP跳过这些包
+	case ctrlTypeHandshake:
+		// 握手由 Server 处理，这里不会收到
+	default:
+		Log.Debugf("[%s] unknown control type. type=%d", c.uniqueKey, pkt.CtrlType)
+	}
+}
+
+func (c *conn) handleDataPacket(pkt Packet, now time.Time) {
+	seq := pkt.Seq
+
+	if seqLess(seq, c.nextDeliverSeq) {
+		return
+	}
+	if _, ok := c.recvBuf[seq]; ok {
+		return
+	}
+
+	if seqLess(c.maxRecvSeq, seq) {
+		// 中间有空洞，记录丢包，并立即发送一次NAK
+		first := seqInc(c.maxRecvSeq)
+		if first != seq {
+			n := 0
+			last := first
+			for s := first; s != seq && n < maxLossPerGap; s = seqInc(s) {
+				c.lossList[s] = now
+				last = s
+				n++
+			}
+			_ = c.sendControl(ctrlTypeNak, 0, packLossList([][2]uint32{{first, last}}))
+		}
+		c.maxRecvSeq = seq
+	} else {
+		delete(c.lossList, seq)
+	}
+
+	payload := make([]byte, len(pkt.Payload))
+	copy(payload, pkt.Payload)
+	c.recvBuf[seq] = &recvItem{
+		payload:   payload,
+		deliverAt: c.tsbpdTime(pkt.Timestamp, now),
+	}
+}
+
+// tsbpdTime 根据对端的时间戳计算该包应该交付给上层的时间
+//
+// 以第一个包到达的时间作为基准，不处理时钟漂移
+func (c *conn) tsbpdTime(ts uint32, now time.Time) time.Time {
+	var ts64 int64
+	switch {
+	case ts < c.tsLast && c.tsLast-ts > 0x80000000:
+		// 回绕
+		c.tsHigh += 1 << 32
+		ts64 = c.tsHigh + int64(ts)
+		c.tsLast = ts
+	case ts > c.tsLast && ts-c.tsLast > 0x80000000:
+		// 回绕前的包（比如重传包）在回绕后才到达
+		ts64 = c.tsHigh - (1 << 32) + int64(ts)
+	default:
+		ts64 = c.tsHigh + int64(ts)
+		if ts > c.tsLast {
+			c.tsLast = ts
+		}
+	}
+
+	if c.tsbpdBase.IsZero() {
+		c.tsbpdBase = now.Add(-time.Duration(ts64) * time.Microsecond)
+	}
+	return c.tsbpdBase.Add(time.Duration(ts64)*time.Microsecond + c.latency)
+}
+
+func (c *conn) deliver(now time.Time) {
+	for len(c.recvBuf) > 0 {
+		item, ok := c.recvBuf[c.nextDeliverSeq]
+		if ok {
+			if now.Before(item.deliverAt) {
+				return
+			}
+			delete(c.recvBuf, c.nextDeliverSeq)
+			c.nextDeliverSeq = seqInc(c.nextDeliverSeq)
+			if c.onData != nil {
+				c.onData(item.payload)
+			}
+			continue
+		}
+
+		// TLPKTDROP 期望的包还没到，如果后面已经有包到了交付时间，则放弃等待
+		var (
+			found bool
+			next  uint32
+		)
+		for seq, it := range c.recvBuf {
+			if now.Before(it.deliverAt) {
+				continue
+			}
+			if !found || seqLess(seq, next) {
+				next = seq
+				found = true
+			}
+		}
+		if !found {
+			return
+		}
+		dropped := seqDiff(c.nextDeliverSeq, next)
+		for s := c.nextDeliverSeq; s != next; s = seqInc(s) {
+			delete(c.lossList, s)
+		}
+		Log.Warnf("[%s] too late, drop packets. from=%d, count=%d", c.uniqueKey, c.nextDeliverSeq, dropped)
+		c.nextDeliverSeq = next
+	}
+}
+
+func (c *conn) onTick(now time.Time) {
+	if now.Sub(c.lastRecvTime) > c.idleTimeout {
+		Log.Warnf("[%s] srt peer idle timeout. last recv time=%s", c.uniqueKey, c.lastRecvTime.String())
+		c.dispose(base.ErrSrtPeerIdleTimeout)
+		return
+	}
+
+	c.deliver(now)
+	c.sendAckIfNeeded(now)
+	c.sendPeriodicNak(now)
+	c.cleanSendBuf(now)
+
+	c.sendMutex.Lock()
+	idle := now.Sub(c.lastSendTime)
+	c.sendMutex.Unlock()
+	if idle > keepaliveIntervalMs*time.Millisecond {
+		_ = c.sendControl(ctrlTypeKeepalive, 0, nil)
+	}
+}
+
+func (c *conn) sendAckIfNeeded(now time.Time) {
+	ackSeq := c.nextDeliverSeq
+	for {
+		if _, ok := c.recvBuf[ackSeq]; !ok {
+			break
+		}
+		ackSeq = seqInc(ackSeq)
+	}
+	if ackSeq == c.lastAckSeq {
+		return
+	}
+	c.lastAckSeq = ackSeq
+
+	c.ackNo++
+	c.ackTimes[c.ackNo] = now
+	for no, t := range c.ackTimes {
+		if now.Sub(t) > c.idleTimeout {
+			delete(c.ackTimes, no)
+		}
+	}
+
+	cif := ackCif{
+		LastAckSeq:   ackSeq,
+		Rtt:          uint32(c.rtt / time.Microsecond),
+		RttVar:       uint32(c.rttVar / time.Microsecond),
+		AvailBufSize: defaultFlowWindow,
+	}
+	_ = c.sendControl(ctrlTypeAck, 0, cif.Pack(), c.ackNo)
+}
+
+func (c *conn) sendPeriodicNak(now time.Time) {
+	if len(c.lossList) == 0 {
+		return
+	}
+
+	interval := c.rtt + 4*c.rttVar
+	if interval < minNakIntervalMs*time.Millisecond {
+		interval = minNakIntervalMs * time.Millisecond
+	}
+
+	var seqs []uint32
+	for seq, t := range c.lossList {
+		if now.Sub(t) >= interval {
+			seqs = append(seqs, seq)
+			c.lossList[seq] = now
+		}
+	}
+	if len(seqs) == 0 {
+		return
+	}
+	from := c.nextDeliverSeq
+	sort.Slice(seqs, func(i, j int) bool {
+		return seqDiff(from, seqs[i]) < seqDiff(from, seqs[j])
+	})
+
+	var ranges [][2]uint32
+	for _, seq := range seqs {
+		if len(ranges) > 0 && seqInc(ranges[len(ranges)-1][1]) == seq {
+			ranges[len(ranges)-1][1] = seq
+			continue
+		}
+		ranges = append(ranges, [2]uint32{seq, seq})
+	}
+	_ = c.sendControl(ctrlTypeNak, 0, packLossList(ranges))
+}
+
+func (c *conn) handleAckAck(pkt Packet, now time.Time) {
+	t, ok := c.ackTimes[pkt.TypeSpecific]
+	if !ok {
+		return
+	}
+	delete(c.ackTimes, pkt.TypeSpecific)
+
+	sample := now.Sub(t)
+	diff := c.rtt - sample
+	if diff < 0 {
+		diff = -diff
+	}
+	c.rttVar = (c.rttVar*3 + diff) / 4
+	c.rtt = (c.rtt*7 + sample) / 8
+}
+
+// ----- 发送 ----------------------------------------------------------------------------------------------------------
+
+func (c *conn) handleAck(pkt Packet) {
+	cif, err := parseAckCif(pkt.Payload)
+	if err != nil {
+		return
+	}
+
+	c.sendMutex.Lock()
+	i := 0
+	for ; i < len(c.sendBuf); i++ {
+		if !seqLess(c.sendBuf[i].seq, cif.LastAckSeq) {
+			break
+		}
+	}
+	c.sendBuf = c.sendBuf[i:]
+	c.sendMutex.Unlock()
+
+	// Light ACK不需要回复ACKACK
+	if len(pkt.Payload) >= 16 {
+		_ = c.sendControl(ctrlTypeAckAck, 0, make([]byte, 4), pkt.TypeSpecific)
+	}
+}
+
+func (c *conn) handleNak(pkt Packet, now time.Time) {
+	ranges := parseLossList(pkt.Payload)
+
+	c.sendMutex.Lock()
+	defer c.sendMutex.Unlock()
+
+	for _, r := range ranges {
+		for _, item := range c.sendBuf {
+			if seqLess(item.seq, r[0]) || seqLess(r[1], item.seq) {
+				continue
+			}
+			// 设置重传标志
+			item.raw[4] |= 0x04
+			if err := c.send(item.raw, now); err != nil {
+				return
+			}
+			c.stat.AddWriteBytes(len(item.raw))
+		}
+	}
+}
+
+func (c *conn) cleanSendBuf(now time.Time) {
+	keep := c.latency + sendBufferExtraKeepMs*time.Millisecond
+
+	c.sendMutex.Lock()
+	defer c.sendMutex.Unlock()
+
+	i := 0
+	for ; i < len(c.sendBuf); i++ {
+		if now.Sub(c.sendBuf[i].sentAt) < keep {
+			break
+		}
+	}
+	c.sendBuf = c.sendBuf[i:]
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// sendControl
+//
+// @param typeSpecific: 可选参数，不填时为0
+func (c *conn) sendControl(ctrlType uint16, subtype uint16, cif []byte, typeSpecific ...uint32) error {
+	pkt := Packet{
+		IsControl:   true,
+		CtrlType:    ctrlType,
+		CtrlSubtype: subtype,
+		DstSockId:   c.peerSockId,
+		Payload:     cif,
+	}
+	if len(typeSpecific) > 0 {
+		pkt.TypeSpecific = typeSpecific[0]
+	}
+	now := time.Now()
+	pkt.Timestamp = c.timestamp(now)
+
+	c.sendMutex.Lock()
+	defer c.sendMutex.Unlock()
+	return c.send(pkt.Pack(), now)
+}
+
+// send 调用方需持有 sendMutex
+func (c *conn) send(b []byte, now time.Time) error {
+	c.lastSendTime = now
+	_, err := c.pconn.WriteToUDP(b, c.raddr)
+	return err
+}
+
+func (c *conn) timestamp(now time.Time) uint32 {
+	return uint32(now.Sub(c.startTime) / time.Microsecond)
+}
@@ -0,0 +1,202 @@
+// Copyright 2023, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package srt
+
+import (
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/naza/pkg/bele"
+)
+
+// Handshake CIF -------------------------------------------------------------------------------------------------------
+// <draft-sharabayko-srt-01.txt> <3.2.1. Handshake>
+//
+// Version                  [32b]
+// Encryption Field         [16b]
+// Extension Field          [16b]
+// Initial Packet Sequence  [32b]
+// Maximum Transmission Unit Size [32b]
+// Maximum Flow Window Size [32b]
+// Handshake Type           [32b]
+// SRT Socket ID            [32b]
+// SYN Cookie               [32b]
+// Peer IP Address          [128b]
+// -----loop-----
+// Extension Type           [16b]
+// Extension Length         [16b] 单位为4字节
+// Extension Contents       [Extension Length*32b]
+// ---------------------------------------------------------------------------------------------------------------------
+
+const (
+	hsCifSize = 48
+
+	hsTypeDone       = uint32(0xFFFFFFFD)
+	hsTypeAgreement  = uint32(0xFFFFFFFE)
+	hsTypeConclusion = uint32(0xFFFFFFFF)
+	hsTypeWaveahand  = uint32(0x00000000)
+	hsTypeInduction  = uint32(0x00000001)
+
+	// 拒绝连接时，Handshake Type字段填写 hsTypeRejectBase + 原因
+	hsTypeRejectBase = uint32(1000)
+
+	// HSv5 induction阶段，listener回复的Extension Field固定值
+	hsInductionMagic = uint16(0x4A17)
+
+	// conclusion阶段，Extension Field中的flag
+	hsExtFlagHsReq  = uint16(0x1)
+	hsExtFlagKmReq  = uint16(0x2)
+	hsExtFlagConfig = uint16(0x4)
+
+	// Extension Type
+	hsExtTypeHsReq  = uint16(1)
+	hsExtTypeHsRsp  = uint16(2)
+	hsExtTypeKmReq  = uint16(3)
+	hsExtTypeKmRsp  = uint16(4)
+	hsExtTypeSid    = uint16(5)
+	hsExtTypeFilter = uint16(7)
+)
+
+// 拒绝原因，参考libsrt SRT_REJECT_REASON 以及 access-control.md
+const (
+	RejectReasonUnknown  = uint32(0)
+	RejectReasonSystem   = uint32(1)
+	RejectReasonPeer     = uint32(2)
+	RejectReasonResource = uint32(3)
+	RejectReasonRogue    = uint32(4)
+	RejectReasonVersion  = uint32(8)
+	RejectReasonUnsecure = uint32(11)
+	RejectReasonFilter   = uint32(14)
+
+	RejectReasonBadRequest = uint32(1400)
+	RejectReasonForbidden  = uint32(1403)
+	RejectReasonNotFound   = uint32(1404)
+	RejectReasonConflict   = uint32(1409)
+)
+
+type handshake struct {
+	Version        uint32
+	EncryptionFlag uint16
+	ExtensionField uint16
+	Isn            uint32
+	Mtu            uint32
+	FlowWindow     uint32
+	Type           uint32
+	SockId         uint32
+	Cookie         uint32
+	PeerIp         [16]byte
+
+	// conclusion阶段的扩展
+	HasHsExt     bool
+	SrtVersion   uint32
+	SrtFlags     uint32
+	RecvTsbpdMs  uint16 // 发送方作为接收端时的延迟
+	SendTsbpdMs  uint16 // 发送方作为发送端时的延迟
+	HasKmReq     bool
+	HasFilter    bool
+	StreamId     string
+	HasStreamId  bool
+	extensionRaw []byte
+}
+
+func parseHandshake(b []byte) (hs handshake, err error) {
+	if len(b) < hsCifSize {
+		return hs, base.NewErrSrtInvalidPacket(len(b))
+	}
+	hs.Version = bele.BeUint32(b)
+	hs.EncryptionFlag = bele.BeUint16(b[4:])
+	hs.ExtensionField = bele.BeUint16(b[6:])
+	hs.Isn = bele.BeUint32(b[8:]) & maxSeq
+	hs.Mtu = bele.BeUint32(b[12:])
+	hs.FlowWindow = bele.BeUint32(b[16:])
+	hs.Type = bele.BeUint32(b[20:])
+	hs.SockId = bele.BeUint32(b[24:])
+	hs.Cookie = bele.BeUint32(b[28:])
+	copy(hs.PeerIp[:], b[32:48])
+
+	ext := b[hsCifSize:]
+	hs.extensionRaw = ext
+	for len(ext) >= 4 {
+		typ := bele.BeUint16(ext)
+		l := int(bele.BeUint16(ext[2:])) * 4
+		ext = ext[4:]
+		if l > len(ext) {
+			return hs, base.NewErrSrtInvalidPacket(len(b))
+		}
+		content := ext[:l]
+		ext = ext[l:]
+
+		switch typ {
+		case hsExtTypeHsReq, hsExtTypeHsRsp:
+			if len(content) < 12 {
+				return hs, base.NewErrSrtInvalidPacket(len(b))
+			}
+			hs.HasHsExt = true
+			hs.SrtVersion = bele.BeUint32(content)
+			hs.SrtFlags = bele.BeUint32(content[4:])
+			hs.RecvTsbpdMs = bele.BeUint16(content[8:])
+			hs.SendTsbpdMs = bele.BeUint16(content[10:])
+		case hsExtTypeKmReq:
+			hs.HasKmReq = true
+		case hsExtTypeSid:
+			hs.HasStreamId = true
+			hs.StreamId = decodeStreamIdExt(content)
+		case hsExtTypeFilter:
+			hs.HasFilter = true
+		}
+	}
+	return
+}
+
+func (hs *handshake) Pack() []byte {
+	out := make([]byte, hsCifSize, hsCifSize+16)
+	bele.BePutUint32(out, hs.Version)
+	bele.BePutUint16(out[4:], hs.EncryptionFlag)
+	bele.BePutUint16(out[6:], hs.ExtensionField)
+	bele.BePutUint32(out[8:], hs.Isn)
+	bele.BePutUint32(out[12:], hs.Mtu)
+	bele.BePutUint32(out[16:], hs.FlowWindow)
+	bele.BePutUint32(out[20:], hs.Type)
+	bele.BePutUint32(out[24:], hs.SockId)
+	bele.BePutUint32(out[28:], hs.Cookie)
+	copy(out[32:48], hs.PeerIp[:])
+
+	if hs.HasHsExt {
+		// listener只会回复HSRSP
+		out = append(out, 0, byte(hsExtTypeHsRsp), 0, 3)
+		out = appendUint32(out, hs.SrtVersion)
+		out = appendUint32(out, hs.SrtFlags)
+		out = appendUint32(out, uint32(hs.RecvTsbpdMs)<<16|uint32(hs.SendTsbpdMs))
+	}
+	return out
+}
+
+// decodeStreamIdExt
+//
+// 注意，stream id按4字节分组，每组内部的字节序是反的，长度不足4字节整数倍时补0
+func decodeStreamIdExt(b []byte) string {
+	out := make([]byte, 0, len(b))
+	for i := 0; i+4 <= len(b); i += 4 {
+		out = append(out, b[i+3], b[i+2], b[i+1], b[i])
+	}
+	for len(out) > 0 && out[len(out)-1] == 0 {
+		out = out[:len(out)-1]
+	}
+	return string(out)
+}
+
+func encodeStreamIdExt(streamId string) []byte {
+	b := []byte(streamId)
+	for len(b)%4 != 0 {
+		b = append(b, 0)
+	}
+	out := make([]byte, len(b))
+	for i := 0; i+4 <= len(b); i += 4 {
+		out[i], out[i+1], out[i+2], out[i+3] = b[i+3], b[i+2], b[i+1], b[i]
+	}
+	return out
+}
@@ -0,0 +1,209 @@
+// Copyright 2023, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package srt
+
+import (
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/naza/pkg/bele"
+)
+
+// Packet Header -------------------------------------------------------------------------------------------------------
+// <draft-sharabayko-srt-01.txt> <3. Packet Structure>
+//
+// Data packet:
+// 0                   1                   2                   3
+// 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |0|                    Packet Sequence Number                   |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |P P|O|K K|R|                   Message Number                  |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |                           Timestamp                           |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |                     Destination Socket ID                     |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//
+// Control packet:
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |1|         Control Type        |            Subtype            |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |                   Type-specific Information                   |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |                           Timestamp                           |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |                     Destination Socket ID                     |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// ---------------------------------------------------------------------------------------------------------------------
+
+const (
+	ctrlTypeHandshake = 0x0000
+	ctrlTypeKeepalive = 0x0001
+	ctrlTypeAck       = 0x0002
+	ctrlTypeNak       = 0x0003
+	ctrlTypeShutdown  = 0x0005
+	ctrlTypeAckAck    = 0x0006
+	ctrlTypeDropReq   = 0x0007
+)
+
+// 整个报文作为一条消息，即PP为0b11
+const packetPositionSolo = 0x3
+
+type Packet struct {
+	IsControl bool
+
+	// data packet
+	Seq        uint32
+	Position   uint8
+	InOrder    bool
+	Retransmit bool
+	MsgNo      uint32
+
+	// control packet
+	CtrlType     uint16
+	CtrlSubtype  uint16
+	TypeSpecific uint32
+
+	Timestamp uint32 // 单位微秒，相对于连接建立的时间
+	DstSockId uint32
+
+	// data packet的负载，或者control packet的CIF(Control Information Field)
+	Payload []byte
+}
+
+// ParsePacket
+//
+// 注意，返回的 Packet.Payload 引用了参数`b`的内存块
+func ParsePacket(b []byte) (pkt Packet, err error) {
+	if len(b) < headerSize {
+		return pkt, base.NewErrSrtInvalidPacket(len(b))
+	}
+
+	w0 := bele.BeUint32(b)
+	w1 := bele.BeUint32(b[4:])
+	pkt.Timestamp = bele.BeUint32(b[8:])
+	pkt.DstSockId = bele.BeUint32(b[12:])
+	pkt.Payload = b[headerSize:]
+
+	if w0&0x80000000 != 0 {
+		pkt.IsControl = true
+		pkt.CtrlType = uint16((w0 >> 16) & 0x7FFF)
+		pkt.CtrlSubtype = uint16(w0)
+		pkt.TypeSpecific = w1
+	} else {
+		pkt.Seq = w0
+		pkt.Position = uint8(w1 >> 30)
+		pkt.InOrder = (w1>>29)&0x1 == 1
+		pkt.Retransmit = (w1>>26)&0x1 == 1
+		pkt.MsgNo = w1 & 0x03FFFFFF
+	}
+	return
+}
+
+// Pack 序列化，内存块为独立申请
+func (pkt *Packet) Pack() []byte {
+	out := make([]byte, headerSize+len(pkt.Payload))
+	if pkt.IsControl {
+		bele.BePutUint32(out, 0x80000000|uint32(pkt.CtrlType)<<16|uint32(pkt.CtrlSubtype))
+		bele.BePutUint32(out[4:], pkt.TypeSpecific)
+	} else {
+		bele.BePutUint32(out, pkt.Seq&maxSeq)
+		w1 := uint32(pkt.Position)<<30 | pkt.MsgNo&0x03FFFFFF
+		if pkt.InOrder {
+			w1 |= 1 << 29
+		}
+		if pkt.Retransmit {
+			w1 |= 1 << 26
+		}
+		bele.BePutUint32(out[4:], w1)
+	}
+	bele.BePutUint32(out[8:], pkt.Timestamp)
+	bele.BePutUint32(out[12:], pkt.DstSockId)
+	copy(out[headerSize:], pkt.Payload)
+	return out
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// ackCif Full ACK的CIF
+//
+// <draft-sharabayko-srt-01.txt> <3.2.4. ACK (Acknowledgment)>
+type ackCif struct {
+	LastAckSeq       uint32 // 接收端期望收到的下一个序号，即该序号之前的都收到了
+	Rtt              uint32 // 单位微秒
+	RttVar           uint32
+	AvailBufSize     uint32 // 单位packet个数
+	PacketsRecvRate  uint32 // 单位packet/s
+	EstLinkCapacity  uint32
+	ReceivingRateBps uint32 // 单位bytes/s
+}
+
+func (a *ackCif) Pack() []byte {
+	out := make([]byte, 28)
+	bele.BePutUint32(out, a.LastAckSeq)
+	bele.BePutUint32(out[4:], a.Rtt)
+	bele.BePutUint32(out[8:], a.RttVar)
+	bele.BePutUint32(out[12:], a.AvailBufSize)
+	bele.BePutUint32(out[16:], a.PacketsRecvRate)
+	bele.BePutUint32(out[20:], a.EstLinkCapacity)
+	bele.BePutUint32(out[24:], a.ReceivingRateBps)
+	return out
+}
+
+// parseAckCif 注意，Light ACK只有LastAckSeq字段
+func parseAckCif(b []byte) (a ackCif, err error) {
+	if len(b) < 4 {
+		return a, base.NewErrSrtInvalidPacket(len(b))
+	}
+	a.LastAckSeq = bele.BeUint32(b) & maxSeq
+	if len(b) >= 16 {
+		a.Rtt = bele.BeUint32(b[4:])
+		a.RttVar = bele.BeUint32(b[8:])
+		a.AvailBufSize = bele.BeUint32(b[12:])
+	}
+	return
+}
+
+// packLossList NAK的CIF
+//
+// <draft-sharabayko-srt-01.txt> <Appendix A. Packet Sequence List Coding>
+// 单个序号直接写入，连续的序号区间写入起始序号（最高位置1）和结束序号
+func packLossList(ranges [][2]uint32) []byte {
+	out := make([]byte, 0, len(ranges)*8)
+	for _, r := range ranges {
+		if r[0] == r[1] {
+			out = appendUint32(out, r[0])
+		} else {
+			out = appendUint32(out, r[0]|0x80000000)
+			out = appendUint32(out, r[1])
+		}
+	}
+	return out
+}
+
+func parseLossList(b []byte) (ranges [][2]uint32) {
+	for len(b) >= 4 {
+		v := bele.BeUint32(b)
+		b = b[4:]
+		if v&0x80000000 != 0 {
+			if len(b) < 4 {
+				break
+			}
+			end := bele.BeUint32(b) & maxSeq
+			b = b[4:]
+			ranges = append(ranges, [2]uint32{v & maxSeq, end})
+		} else {
+			ranges = append(ranges, [2]uint32{v, v})
+		}
+	}
+	return
+}
+
+func appendUint32(out []byte, v uint32) []byte {
+	return append(out, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
@@ -0,0 +1,121 @@
+// Copyright 2023, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package srt
+
+import (
+	"fmt"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/mpegts"
+)
+
+// PubSession srt推流，负载为mpegts
+type PubSession struct {
+	conn     *conn
+	urlCtx   base.UrlContext
+	streamId StreamId
+	unpacker *mpegts.TsUnpacker
+
+	sessionStat base.BasicSessionStat
+}
+
+func NewPubSession(option connOption, streamId StreamId, localAddr string) *PubSession {
+	s := &PubSession{
+		streamId:    streamId,
+		unpacker:    mpegts.NewTsUnpacker(),
+		sessionStat: base.NewBasicSessionStat(base.SessionTypeSrtPub, option.RAddr.String()),
+	}
+	s.urlCtx, _ = base.ParseUrl(buildUrl(localAddr, streamId), -1)
+
+	option.UniqueKey = s.UniqueKey()
+	option.Stat = &s.sessionStat
+	s.conn = newConn(option)
+	s.conn.onData = s.unpacker.FeedMpegts
+
+	Log.Infof("[%s] lifecycle new srt PubSession. session=%p, remote addr=%s, stream id=%+v", s.UniqueKey(), s, option.RAddr.String(), streamId)
+	return s
+}
+
+// WithOnAvPacket 设置音视频的回调
+//
+//	@param onAvPacket: 见 mpegts.TsUnpacker 的注释
+func (session *PubSession) WithOnAvPacket(onAvPacket base.OnAvPacketFunc) *PubSession {
+	session.unpacker.WithOnAvPacket(onAvPacket)
+	return session
+}
+
+// ----- IServerSessionLifecycle ---------------------------------------------------------------------------------------
+
+func (session *PubSession) RunLoop() error {
+	err := session.conn.runLoop()
+	session.unpacker.Flush()
+	return err
+}
+
+func (session *PubSession) Dispose() error {
+	Log.Infof("[%s] lifecycle dispose srt PubSession.", session.UniqueKey())
+	session.conn.dispose(nil)
+	return nil
+}
+
+// ----- ISessionUrlContext --------------------------------------------------------------------------------------------
+
+func (session *PubSession) Url() string {
+	return session.urlCtx.Url
+}
+
+func (session *PubSession) AppName() string {
+	return session.urlCtx.PathWithoutLastItem
+}
+
+func (session *PubSession) StreamName() string {
+	return session.urlCtx.LastItemOfPath
+}
+
+func (session *PubSession) RawQuery() string {
+	return session.urlCtx.RawQuery
+}
+
+func (session *PubSession) Header() map[string][]string {
+	return nil
+}
+
+// StreamId 握手时对端携带的streamid
+func (session *PubSession) StreamId() StreamId {
+	return session.streamId
+}
+
+// ----- IObject -------------------------------------------------------------------------------------------------------
+
+func (session *PubSession) UniqueKey() string {
+	return session.sessionStat.UniqueKey()
+}
+
+// ----- ISessionStat --------------------------------------------------------------------------------------------------
+
+func (session *PubSession) UpdateStat(intervalSec uint32) {
+	session.sessionStat.UpdateStat(intervalSec)
+}
+
+func (session *PubSession) GetStat() base.StatSession {
+	return session.sessionStat.GetStat()
+}
+
+func (session *PubSession) IsAlive() (readAlive, writeAlive bool) {
+	return session.sessionStat.IsAlive()
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func buildUrl(localAddr string, streamId StreamId) string {
+	if streamId.RawQuery == "" {
+		return fmt.Sprintf("srt://%s/%s", localAddr, streamId.Resource)
+	}
+	return fmt.Sprintf("srt://%s/%s?%s", localAddr, streamId.Resource, streamId.RawQuery)
+}
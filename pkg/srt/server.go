@@ -0,0 +1,385 @@
+// Copyright 2023, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package srt
+
+import (
+	"fmt"
+	"hash/crc32"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/q191201771/naza/pkg/bele"
+)
+
+type IServerObserver interface {
+	// OnNewSrtPubSession
+	//
+	// 上层代码应该在这个事件回调中注册音视频数据的监听
+	//
+	// @return 上层如果想拒绝这个连接，则回调中返回不为nil的error值，此时不会再触发 OnDelSrtPubSession
+	//
+	OnNewSrtPubSession(session *PubSession) error
+	OnDelSrtPubSession(session *PubSession)
+
+	OnNewSrtSubSession(session *SubSession) error
+	OnDelSrtSubSession(session *SubSession)
+}
+
+type ServerConfig struct {
+	LatencyMs         int `json:"latency_ms"`           // TSBPD延迟，会与对端协商取较大值
+	PeerIdleTimeoutMs int `json:"peer_idle_timeout_ms"` // 超过这个时间没有收到对端的任何数据，则关闭连接
+}
+
+type Server struct {
+	addr     string
+	observer IServerObserver
+	config   ServerConfig
+
+	pconn *net.UDPConn
+
+	mutex sync.Mutex
+	conns map[uint32]*conn // key为本端socket id
+	// 握手完成的连接，key为对端地址加对端socket id，用于对端重发conclusion时回复相同的内容
+	established map[string]*establishedItem
+	// 正在等待上层处理的conclusion，key同established，处理完成之前对端重发的conclusion直接忽略
+	accepting map[string]struct{}
+
+	cookieSecret uint32
+}
+
+type establishedItem struct {
+	localSockId uint32
+	resp        []byte
+}
+
+func NewServer(addr string, observer IServerObserver, config ServerConfig) *Server {
+	if config.LatencyMs <= 0 {
+		config.LatencyMs = DefaultLatencyMs
+	}
+	if config.PeerIdleTimeoutMs <= 0 {
+		config.PeerIdleTimeoutMs = DefaultPeerIdleTimeoutMs
+	}
+	return &Server{
+		addr:         addr,
+		observer:     observer,
+		config:       config,
+		conns:        make(map[uint32]*conn),
+		established:  make(map[string]*establishedItem),
+		accepting:    make(map[string]struct{}),
+		cookieSecret: rand.Uint32(),
+	}
+}
+
+func (server *Server) Listen() (err error) {
+	laddr, err := net.ResolveUDPAddr("udp", server.addr)
+	if err != nil {
+		return
+	}
+	if server.pconn, err = net.ListenUDP("udp", laddr); err != nil {
+		return
+	}
+	Log.Infof("start srt server listen. addr=%s", server.addr)
+	return
+}
+
+func (server *Server) RunLoop() error {
+	buf := make([]byte, 65536)
+	for {
+		n, raddr, err := server.pconn.ReadFromUDP(buf)
+		if err != nil {
+			return err
+		}
+		if n < headerSize {
+			continue
+		}
+		b := buf[:n]
+
+		dstSockId := bele.BeUint32(b[12:])
+		if dstSockId == 0 {
+			server.handleHandshake(b, raddr)
+			continue
+		}
+
+		server.mutex.Lock()
+		c, ok := server.conns[dstSockId]
+		server.mutex.Unlock()
+		if !ok {
+			continue
+		}
+		if c.raddr.Port != raddr.Port || !c.raddr.IP.Equal(raddr.IP) {
+			continue
+		}
+		// 连接的协程异步处理，所以需要拷贝
+		c.feed(append([]byte(nil), b...))
+	}
+}
+
+func (server *Server) Dispose() {
+	if server.pconn == nil {
+		return
+	}
+	if err := server.pconn.Close(); err != nil {
+		Log.Error(err)
+	}
+
+	server.mutex.Lock()
+	conns := make([]*conn, 0, len(server.conns))
+	for _, c := range server.conns {
+		conns = append(conns, c)
+	}
+	server.mutex.Unlock()
+
+	for _, c := range conns {
+		c.dispose(nil)
+	}
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func (server *Server) handleHandshake(b []byte, raddr *net.UDPAddr) {
+	pkt, err := ParsePacket(b)
+	if err != nil || !pkt.IsControl || pkt.CtrlType != ctrlTypeHandshake {
+		return
+	}
+	hs, err := parseHandshake(pkt.Payload)
+	if err != nil {
+		Log.Warnf("parse srt handshake failed. remote addr=%s, err=%+v", raddr.String(), err)
+		return
+	}
+
+	switch hs.Type {
+	case hsTypeInduction:
+		server.handleInduction(hs, raddr)
+	case hsTypeConclusion:
+		server.handleConclusion(hs, raddr)
+	default:
+		Log.Warnf("unsupported srt handshake type. remote addr=%s, type=%d", raddr.String(), hs.Type)
+	}
+}
+
+func (server *Server) handleInduction(hs handshake, raddr *net.UDPAddr) {
+	Log.Debugf("< R srt handshake induction. remote addr=%s, sock id=%d", raddr.String(), hs.SockId)
+
+	resp := handshake{
+		Version:        5,
+		ExtensionField: hsInductionMagic,
+		Isn:            hs.Isn,
+		Mtu:            defaultMtu,
+		FlowWindow:     defaultFlowWindow,
+		Type:           hsTypeInduction,
+		Cookie:         server.genCookie(raddr, time.Now()),
+		PeerIp:         hs.PeerIp,
+	}
+	server.writeHandshake(resp, hs.SockId, raddr)
+}
+
+func (server *Server) handleConclusion(hs handshake, raddr *net.UDPAddr) {
+	key := fmt.Sprintf("%s-%d", raddr.String(), hs.SockId)
+
+	// 对端没有收到我们的回复，重发了conclusion
+	server.mutex.Lock()
+	item, ok := server.established[key]
+	server.mutex.Unlock()
+	if ok {
+		_, _ = server.pconn.WriteToUDP(item.resp, raddr)
+		return
+	}
+
+	if !server.checkCookie(raddr, hs.Cookie) {
+		Log.Warnf("invalid srt handshake cookie. remote addr=%s", raddr.String())
+		return
+	}
+
+	if hs.Version != 5 || !hs.HasHsExt {
+		server.reject(hs, raddr, RejectReasonVersion)
+		return
+	}
+	if hs.HasKmReq || hs.EncryptionFlag != 0 {
+		Log.Warnf("srt encryption is not supported. remote addr=%s", raddr.String())
+		server.reject(hs, raddr, RejectReasonUnsecure)
+		return
+	}
+	if hs.HasFilter {
+		Log.Warnf("srt packet filter is not supported. remote addr=%s", raddr.String())
+		server.reject(hs, raddr, RejectReasonFilter)
+		return
+	}
+
+	sid, err := ParseStreamId(hs.StreamId)
+	if err != nil {
+		Log.Warnf("parse srt stream id failed. remote addr=%s, err=%+v", raddr.String(), err)
+		server.reject(hs, raddr, RejectReasonBadRequest)
+		return
+	}
+
+	// 上层回调中可能有耗时的操作(比如http回调鉴权)，放到单独的协程中处理，避免阻塞读取协程
+	server.mutex.Lock()
+	if _, ok := server.accepting[key]; ok {
+		server.mutex.Unlock()
+		return
+	}
+	server.accepting[key] = struct{}{}
+	server.mutex.Unlock()
+
+	go server.acceptConclusion(hs, raddr, key, sid)
+}
+
+// acceptConclusion 回调上层并回复conclusion，在单独的协程中执行
+func (server *Server) acceptConclusion(hs handshake, raddr *net.UDPAddr, key string, sid StreamId) {
+	defer func() {
+		server.mutex.Lock()
+		delete(server.accepting, key)
+		server.mutex.Unlock()
+	}()
+
+	localSockId := server.allocSockId()
+
+	// 延迟取双方的较大值
+	recvLatency := maxUint16(uint16(server.config.LatencyMs), hs.SendTsbpdMs)
+	sendLatency := maxUint16(uint16(server.config.LatencyMs), hs.RecvTsbpdMs)
+
+	option := connOption{
+		PConn:       server.pconn,
+		RAddr:       raddr,
+		LocalSockId: localSockId,
+		PeerSockId:  hs.SockId,
+		Isn:         hs.Isn,
+		Latency:     time.Duration(recvLatency) * time.Millisecond,
+		IdleTimeout: time.Duration(server.config.PeerIdleTimeoutMs) * time.Millisecond,
+	}
+	localAddr := server.pconn.LocalAddr().String()
+
+	var (
+		c        *conn
+		runLoop  func()
+		observed error
+	)
+	if sid.IsPublish() {
+		session := NewPubSession(option, sid, localAddr)
+		c = session.conn
+		observed = server.observer.OnNewSrtPubSession(session)
+		runLoop = func() {
+			_ = session.RunLoop()
+			server.observer.OnDelSrtPubSession(session)
+		}
+	} else {
+		option.Latency = time.Duration(sendLatency) * time.Millisecond
+		session := NewSubSession(option, sid, localAddr)
+		c = session.conn
+		observed = server.observer.OnNewSrtSubSession(session)
+		runLoop = func() {
+			_ = session.RunLoop()
+			server.observer.OnDelSrtSubSession(session)
+		}
+	}
+	if observed != nil {
+		Log.Warnf("[%s] srt session rejected by observer. err=%+v", c.uniqueKey, observed)
+		server.reject(hs, raddr, RejectReasonForbidden)
+		return
+	}
+
+	resp := handshake{
+		Version:        5,
+		ExtensionField: hsExtFlagHsReq,
+		Isn:            hs.Isn,
+		Mtu:            minUint32(hs.Mtu, defaultMtu),
+		FlowWindow:     minUint32(hs.FlowWindow, defaultFlowWindow),
+		Type:           hsTypeConclusion,
+		SockId:         localSockId,
+		PeerIp:         hs.PeerIp,
+		HasHsExt:       true,
+		SrtVersion:     srtVersion,
+		SrtFlags:       hsFlagTsbpdSnd | hsFlagTsbpdRcv | hsFlagTlpktDrop | hsFlagPeriodicNak | hsFlagRexmitFlag,
+		RecvTsbpdMs:    recvLatency,
+		SendTsbpdMs:    sendLatency,
+	}
+	respPkt := Packet{
+		IsControl: true,
+		CtrlType:  ctrlTypeHandshake,
+		DstSockId: hs.SockId,
+		Payload:   resp.Pack(),
+	}
+	raw := respPkt.Pack()
+
+	c.onDelete = func(c *conn) {
+		server.mutex.Lock()
+		delete(server.conns, c.localSockId)
+		delete(server.established, key)
+		server.mutex.Unlock()
+	}
+	server.mutex.Lock()
+	server.conns[localSockId] = c
+	server.established[key] = &establishedItem{localSockId: localSockId, resp: raw}
+	server.mutex.Unlock()
+
+	Log.Infof("[%s] srt handshake succ. remote addr=%s, stream id=%s, latency=%d", c.uniqueKey, raddr.String(), hs.StreamId, recvLatency)
+	_, _ = server.pconn.WriteToUDP(raw, raddr)
+
+	go runLoop()
+}
+
+func (server *Server) reject(hs handshake, raddr *net.UDPAddr, reason uint32) {
+	Log.Warnf("> W srt handshake reject. remote addr=%s, reason=%d", raddr.String(), reason)
+	resp := handshake{
+		Version: 5,
+		Isn:     hs.Isn,
+		Mtu:     defaultMtu,
+		Type:    hsTypeRejectBase + reason,
+		PeerIp:  hs.PeerIp,
+	}
+	server.writeHandshake(resp, hs.SockId, raddr)
+}
+
+func (server *Server) writeHandshake(hs handshake, dstSockId uint32, raddr *net.UDPAddr) {
+	pkt := Packet{
+		IsControl: true,
+		CtrlType:  ctrlTypeHandshake,
+		DstSockId: dstSockId,
+		Payload:   hs.Pack(),
+	}
+	_, _ = server.pconn.WriteToUDP(pkt.Pack(), raddr)
+}
+
+// genCookie 根据对端地址和时间（分钟级）生成cookie，用于防止伪造源地址
+func (server *Server) genCookie(raddr *net.UDPAddr, t time.Time) uint32 {
+	s := fmt.Sprintf("%s-%d-%d", raddr.String(), server.cookieSecret, t.Unix()/60)
+	return crc32.ChecksumIEEE([]byte(s))
+}
+
+func (server *Server) checkCookie(raddr *net.UDPAddr, cookie uint32) bool {
+	now := time.Now()
+	return cookie == server.genCookie(raddr, now) || cookie == server.genCookie(raddr, now.Add(-time.Minute))
+}
+
+func (server *Server) allocSockId() uint32 {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	for {
+		id := rand.Uint32() & 0x3FFFFFFF
+		if _, ok := server.conns[id]; id != 0 && !ok {
+			return id
+		}
+	}
+}
+
+func maxUint16(a, b uint16) uint16 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func minUint32(a, b uint32) uint32 {
+	if a != 0 && a < b {
+		return a
+	}
+	return b
+}
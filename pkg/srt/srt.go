@@ -0,0 +1,75 @@
+// Copyright 2023, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package srt
+
+// 参考文档：
+// - <draft-sharabayko-srt-01.txt> Haivision SRT Protocol
+// - https://github.com/Haivision/srt/blob/master/docs/features/access-control.md
+//
+// 实现的子集：
+// - 仅支持作为listener，接受caller的连接（HSv5握手）
+// - 仅支持live模式（TSBPD，too-late packet drop，周期性NAK）
+// - 不支持加密，对端携带KMREQ时拒绝连接
+// - 负载为mpegts，publish时每个data packet包含1~7个188字节的ts packet
+
+const (
+	// MaxPayloadSize 每个data packet的最大负载，7个ts packet
+	MaxPayloadSize = 1316
+
+	// 参考libsrt，握手时通告的默认值
+	defaultMtu        = 1500
+	defaultFlowWindow = 8192
+
+	headerSize = 16
+
+	// srt版本号，1.4.1
+	srtVersion = 0x010401
+
+	// handshake extension中的flags
+	hsFlagTsbpdSnd     = 0x00000001
+	hsFlagTsbpdRcv     = 0x00000002
+	hsFlagCrypt        = 0x00000004
+	hsFlagTlpktDrop    = 0x00000008
+	hsFlagPeriodicNak  = 0x00000010
+	hsFlagRexmitFlag   = 0x00000020
+	hsFlagStream       = 0x00000040
+	hsFlagPacketFilter = 0x00000080
+
+	// 时间相关，单位毫秒
+	ackIntervalMs       = 10
+	minNakIntervalMs    = 20
+	keepaliveIntervalMs = 1000
+
+	// 发送缓存中的数据在超过latency后，再额外保留一段时间用于重传
+	sendBufferExtraKeepMs = 1000
+
+	maxSeq       = uint32(0x7FFFFFFF)
+	seqThreshold = uint32(0x3FFFFFFF)
+)
+
+// seqLess 考虑回绕的情况下，a是否在b之前
+func seqLess(a, b uint32) bool {
+	if a == b {
+		return false
+	}
+	if a < b {
+		return b-a < seqThreshold
+	}
+	return a-b > seqThreshold
+}
+
+func seqInc(a uint32) uint32 {
+	return (a + 1) & maxSeq
+}
+
+// seqDiff b-a，考虑回绕
+func seqDiff(a, b uint32) int {
+	d := int32((b - a) << 1)
+	return int(d >> 1)
+}
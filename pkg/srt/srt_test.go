@@ -0,0 +1,337 @@
+// Copyright 2023, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package srt
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/mpegts"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestSeq(t *testing.T) {
+	assert.Equal(t, true, seqLess(1, 2))
+	assert.Equal(t, false, seqLess(2, 1))
+	assert.Equal(t, false, seqLess(2, 2))
+	assert.Equal(t, true, seqLess(maxSeq, 0))
+	assert.Equal(t, false, seqLess(0, maxSeq))
+	assert.Equal(t, uint32(0), seqInc(maxSeq))
+	assert.Equal(t, 2, seqDiff(maxSeq, 1))
+	assert.Equal(t, -2, seqDiff(1, maxSeq))
+}
+
+func TestParseStreamId(t *testing.T) {
+	golden := []struct {
+		in       string
+		resource string
+		mode     string
+		rawQuery string
+		isPub    bool
+	}{
+		{"#!::r=live/test110,m=publish", "live/test110", StreamIdModePublish, "", true},
+		{"#!::h=example.com,r=live/test110?token=abc,m=request,u=chef", "live/test110", StreamIdModeRequest, "token=abc&u=chef", false},
+		{"#!::r=live/test110", "live/test110", StreamIdModeRequest, "", false},
+		{"live/test110?m=publish&token=abc", "live/test110", StreamIdModePublish, "token=abc", true},
+		{"/live/test110/", "live/test110", StreamIdModeRequest, "", false},
+	}
+	for _, item := range golden {
+		sid, err := ParseStreamId(item.in)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, item.resource, sid.Resource)
+		assert.Equal(t, item.mode, sid.Mode)
+		assert.Equal(t, item.rawQuery, sid.RawQuery)
+		assert.Equal(t, item.isPub, sid.IsPublish())
+	}
+
+	for _, in := range []string{"", "#!::r", "#!::r=test110", "#!::r=live/test110,m=xxx"} {
+		_, err := ParseStreamId(in)
+		assert.Equal(t, true, errors.Is(err, base.ErrSrtInvalidStreamId))
+	}
+}
+
+func TestLossList(t *testing.T) {
+	ranges := [][2]uint32{{1, 1}, {3, 10}, {maxSeq, maxSeq}}
+	assert.Equal(t, ranges, parseLossList(packLossList(ranges)))
+}
+
+func TestStreamIdExt(t *testing.T) {
+	for _, s := range []string{"#!::r=live/test110,m=publish", "abc", "abcd"} {
+		assert.Equal(t, s, decodeStreamIdExt(encodeStreamIdExt(s)))
+	}
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+type testObserver struct {
+	mutex     sync.Mutex
+	pubs      []*PubSession
+	packets   []base.AvPacket
+	delPubCh  chan struct{}
+	newSubCh  chan *SubSession
+	rejectPub bool
+}
+
+func (o *testObserver) OnNewSrtPubSession(session *PubSession) error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if o.rejectPub {
+		return base.ErrDupInStream
+	}
+	o.pubs = append(o.pubs, session)
+	session.WithOnAvPacket(func(pkt *base.AvPacket) {
+		p := *pkt
+		p.Payload = append([]byte(nil), pkt.Payload...)
+		o.mutex.Lock()
+		o.packets = append(o.packets, p)
+		o.mutex.Unlock()
+	})
+	return nil
+}
+
+func (o *testObserver) setRejectPub(v bool) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	o.rejectPub = v
+}
+
+func (o *testObserver) OnDelSrtPubSession(session *PubSession) {
+	o.delPubCh <- struct{}{}
+}
+
+func (o *testObserver) OnNewSrtSubSession(session *SubSession) error {
+	o.newSubCh <- session
+	return nil
+}
+
+func (o *testObserver) OnDelSrtSubSession(session *SubSession) {
+}
+
+// testCaller 测试用的srt caller，只实现了握手和收发data packet
+type testCaller struct {
+	t          *testing.T
+	conn       *net.UDPConn
+	sockId     uint32
+	peerSockId uint32
+	isn        uint32
+}
+
+func newTestCaller(t *testing.T, addr string) *testCaller {
+	raddr, err := net.ResolveUDPAddr("udp", addr)
+	assert.Equal(t, nil, err)
+	conn, err := net.DialUDP("udp", nil, raddr)
+	assert.Equal(t, nil, err)
+	return &testCaller{t: t, conn: conn, sockId: 0x1234, isn: maxSeq - 2}
+}
+
+// handshake 返回对端回复的Handshake Type
+func (c *testCaller) handshake(streamId string, withKm bool) uint32 {
+	// induction
+	hs := handshake{Version: 4, ExtensionField: 2, Isn: c.isn, Mtu: 1500, FlowWindow: 8192, Type: hsTypeInduction, SockId: c.sockId}
+	c.writeControl(ctrlTypeHandshake, 0, hs.Pack(), 0)
+	resp := c.readHandshake()
+	assert.Equal(c.t, hsTypeInduction, resp.Type)
+	assert.Equal(c.t, uint32(5), resp.Version)
+	assert.Equal(c.t, hsInductionMagic, resp.ExtensionField)
+
+	// conclusion
+	hs.Version = 5
+	hs.Type = hsTypeConclusion
+	hs.Cookie = resp.Cookie
+	hs.ExtensionField = hsExtFlagHsReq
+	cif := hs.Pack()
+	cif = append(cif, 0, byte(hsExtTypeHsReq), 0, 3)
+	cif = appendUint32(cif, srtVersion)
+	cif = appendUint32(cif, hsFlagTsbpdSnd|hsFlagTsbpdRcv|hsFlagTlpktDrop|hsFlagPeriodicNak|hsFlagRexmitFlag)
+	cif = appendUint32(cif, 20<<16|20)
+	sid := encodeStreamIdExt(streamId)
+	cif = append(cif, 0, byte(hsExtTypeSid), 0, byte(len(sid)/4))
+	cif = append(cif, sid...)
+	if withKm {
+		cif = append(cif, 0, byte(hsExtTypeKmReq), 0, 1, 0, 0, 0, 0)
+	}
+	c.writeControl(ctrlTypeHandshake, 0, cif, 0)
+	resp = c.readHandshake()
+	if resp.Type == hsTypeConclusion {
+		assert.Equal(c.t, true, resp.HasHsExt)
+		assert.Equal(c.t, c.isn, resp.Isn)
+		// 延迟取双方的较大值
+		assert.Equal(c.t, uint16(DefaultLatencyMs), resp.RecvTsbpdMs)
+		c.peerSockId = resp.SockId
+	}
+	return resp.Type
+}
+
+func (c *testCaller) writeControl(ctrlType uint16, typeSpecific uint32, cif []byte, dst uint32) {
+	pkt := Packet{IsControl: true, CtrlType: ctrlType, TypeSpecific: typeSpecific, DstSockId: dst, Payload: cif}
+	_, err := c.conn.Write(pkt.Pack())
+	assert.Equal(c.t, nil, err)
+}
+
+func (c *testCaller) writeData(seq uint32, ts uint32, payload []byte) {
+	pkt := Packet{Seq: seq, Position: packetPositionSolo, MsgNo: 1, Timestamp: ts, DstSockId: c.peerSockId, Payload: payload}
+	_, err := c.conn.Write(pkt.Pack())
+	assert.Equal(c.t, nil, err)
+}
+
+func (c *testCaller) read() Packet {
+	b := make([]byte, 2048)
+	_ = c.conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	n, err := c.conn.Read(b)
+	assert.Equal(c.t, nil, err)
+	pkt, err := ParsePacket(b[:n])
+	assert.Equal(c.t, nil, err)
+	assert.Equal(c.t, c.sockId, pkt.DstSockId)
+	return pkt
+}
+
+func (c *testCaller) readHandshake() handshake {
+	for {
+		pkt := c.read()
+		if pkt.IsControl && pkt.CtrlType == ctrlTypeHandshake {
+			hs, err := parseHandshake(pkt.Payload)
+			assert.Equal(c.t, nil, err)
+			return hs
+		}
+	}
+}
+
+func TestServer(t *testing.T) {
+	observer := &testObserver{
+		delPubCh: make(chan struct{}, 1),
+		newSubCh: make(chan *SubSession, 1),
+	}
+	server := NewServer("127.0.0.1:0", observer, ServerConfig{})
+	assert.Equal(t, nil, server.Listen())
+	addr := server.pconn.LocalAddr().String()
+	go server.RunLoop()
+	defer server.Dispose()
+
+	// 不支持加密
+	assert.Equal(t, hsTypeRejectBase+RejectReasonUnsecure, newTestCaller(t, addr).handshake("#!::r=live/test110,m=publish", true))
+	// streamid不合法
+	assert.Equal(t, hsTypeRejectBase+RejectReasonBadRequest, newTestCaller(t, addr).handshake("#!::r=test110", false))
+	// 上层拒绝
+	observer.setRejectPub(true)
+	assert.Equal(t, hsTypeRejectBase+RejectReasonForbidden, newTestCaller(t, addr).handshake("#!::r=live/test110,m=publish", false))
+	observer.setRejectPub(false)
+
+	// ----- 推流 -----
+	pub := newTestCaller(t, addr)
+	assert.Equal(t, hsTypeConclusion, pub.handshake("#!::r=live/test110?token=abc,m=publish", false))
+	observer.mutex.Lock()
+	assert.Equal(t, 1, len(observer.pubs))
+	pubSession := observer.pubs[0]
+	observer.mutex.Unlock()
+	assert.Equal(t, "live", pubSession.AppName())
+	assert.Equal(t, "test110", pubSession.StreamName())
+	assert.Equal(t, "token=abc", pubSession.RawQuery())
+
+	var ts []byte
+	ts = append(ts, mpegts.FixedFragmentHeader...)
+	video := []byte{0, 0, 0, 1, 0x65}
+	for i := 0; i < 4000; i++ {
+		video = append(video, byte(i))
+	}
+	for i := 0; i < 3; i++ {
+		frame := mpegts.Frame{Pts: uint64(i*40) * 90, Dts: uint64(i*40) * 90, Pid: mpegts.PidVideo, Sid: mpegts.StreamIdVideo, Key: true, Raw: video}
+		ts = append(ts, frame.Pack()...)
+	}
+	var chunks [][]byte
+	for len(ts) > 0 {
+		n := MaxPayloadSize
+		if n > len(ts) {
+			n = len(ts)
+		}
+		chunks = append(chunks, ts[:n])
+		ts = ts[n:]
+	}
+
+	// 故意不发送第2个包，序号跨越回绕点，检查NAK以及重传
+	lost := 1
+	for i, chunk := range chunks {
+		if i == lost {
+			continue
+		}
+		pub.writeData((pub.isn+uint32(i))&maxSeq, uint32(i*1000), chunk)
+	}
+	for {
+		pkt := pub.read()
+		if pkt.IsControl && pkt.CtrlType == ctrlTypeNak {
+			ranges := parseLossList(pkt.Payload)
+			assert.Equal(t, [][2]uint32{{(pub.isn + uint32(lost)) & maxSeq, (pub.isn + uint32(lost)) & maxSeq}}, ranges)
+			break
+		}
+	}
+	pub.writeData((pub.isn+uint32(lost))&maxSeq, uint32(lost*1000), chunks[lost])
+
+	// 等待TSBPD交付，以及ACK
+	var ackSeq uint32
+	for ackSeq != (pub.isn+uint32(len(chunks)))&maxSeq {
+		pkt := pub.read()
+		if pkt.IsControl && pkt.CtrlType == ctrlTypeAck {
+			cif, err := parseAckCif(pkt.Payload)
+			assert.Equal(t, nil, err)
+			ackSeq = cif.LastAckSeq
+		}
+	}
+	time.Sleep(time.Duration(DefaultLatencyMs+100) * time.Millisecond)
+
+	observer.mutex.Lock()
+	assert.Equal(t, 3, len(observer.packets))
+	for _, p := range observer.packets {
+		assert.Equal(t, base.AvPacketPtAvc, p.PayloadType)
+		assert.Equal(t, video, p.Payload)
+	}
+	observer.mutex.Unlock()
+
+	// ----- 拉流 -----
+	sub := newTestCaller(t, addr)
+	sub.sockId = 0x5678
+	assert.Equal(t, hsTypeConclusion, sub.handshake("#!::r=live/test110", false))
+	subSession := <-observer.newSubCh
+	assert.Equal(t, "test110", subSession.StreamName())
+
+	data := make([]byte, MaxPayloadSize+188)
+	subSession.Write(data)
+	var seqs []uint32
+	for len(seqs) < 2 {
+		pkt := sub.read()
+		if !pkt.IsControl {
+			seqs = append(seqs, pkt.Seq)
+			assert.Equal(t, false, pkt.Retransmit)
+		}
+	}
+	assert.Equal(t, []uint32{sub.isn, seqInc(sub.isn)}, seqs)
+
+	// 请求重传第一个包
+	sub.writeControl(ctrlTypeNak, 0, packLossList([][2]uint32{{sub.isn, sub.isn}}), sub.peerSockId)
+	for {
+		pkt := sub.read()
+		if !pkt.IsControl {
+			assert.Equal(t, sub.isn, pkt.Seq)
+			assert.Equal(t, true, pkt.Retransmit)
+			assert.Equal(t, MaxPayloadSize, len(pkt.Payload))
+			break
+		}
+	}
+
+	// 对端关闭
+	pub.writeControl(ctrlTypeShutdown, 0, make([]byte, 4), pub.peerSockId)
+	select {
+	case <-observer.delPubCh:
+	case <-time.After(3 * time.Second):
+		t.Fatal("wait del pub session timeout")
+	}
+	_ = subSession.Dispose()
+}
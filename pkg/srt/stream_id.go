@@ -0,0 +1,114 @@
+// Copyright 2023, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package srt
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/q191201771/lal/pkg/base"
+)
+
+// StreamId 解析后的streamid
+//
+// 参考 https://github.com/Haivision/srt/blob/master/docs/features/access-control.md
+//
+// 支持以下几种格式：
+//
+//	#!::r=live/test110,m=publish
+//	#!::h=example.com,r=live/test110?token=xxx,m=request,u=chef
+//	live/test110?m=publish&token=xxx
+//
+// 其中r字段中携带的参数，以及除r、m、h之外的其他字段，都会合并到 RawQuery 中
+type StreamId struct {
+	Host     string // h
+	Resource string // r，不包含参数部分
+	Mode     string // m，request、publish或bidirectional，为空时按request处理
+	UserName string // u
+	RawQuery string
+}
+
+const (
+	streamIdPrefix = "#!::"
+
+	StreamIdModeRequest       = "request"
+	StreamIdModePublish       = "publish"
+	StreamIdModeBidirectional = "bidirectional"
+)
+
+func ParseStreamId(s string) (sid StreamId, err error) {
+	values := url.Values{}
+
+	if strings.HasPrefix(s, streamIdPrefix) {
+		for _, item := range strings.Split(s[len(streamIdPrefix):], ",") {
+			kv := strings.SplitN(item, "=", 2)
+			if len(kv) != 2 {
+				return sid, base.NewErrSrtInvalidStreamId(s)
+			}
+			k, v := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+			switch k {
+			case "r":
+				sid.Resource = v
+			case "m":
+				sid.Mode = v
+			case "h":
+				sid.Host = v
+			case "u":
+				sid.UserName = v
+				values.Add(k, v)
+			default:
+				values.Add(k, v)
+			}
+		}
+	} else {
+		sid.Resource = s
+	}
+
+	// r字段中可能携带参数
+	if index := strings.IndexByte(sid.Resource, '?'); index != -1 {
+		q, err := url.ParseQuery(sid.Resource[index+1:])
+		if err != nil {
+			return sid, base.NewErrSrtInvalidStreamId(s)
+		}
+		for k, vs := range q {
+			if k == "m" && sid.Mode == "" && len(vs) > 0 {
+				sid.Mode = vs[0]
+				continue
+			}
+			for _, v := range vs {
+				values.Add(k, v)
+			}
+		}
+		sid.Resource = sid.Resource[:index]
+	}
+
+	sid.Resource = strings.Trim(sid.Resource, "/")
+	if sid.Resource == "" || !strings.Contains(sid.Resource, "/") {
+		return sid, base.NewErrSrtInvalidStreamId(s)
+	}
+
+	switch sid.Mode {
+	case "":
+		sid.Mode = StreamIdModeRequest
+	case StreamIdModeRequest, StreamIdModePublish, StreamIdModeBidirectional:
+		// noop
+	default:
+		return sid, base.NewErrSrtInvalidStreamId(s)
+	}
+
+	sid.RawQuery = values.Encode()
+	return sid, nil
+}
+
+// IsPublish 对端是否为推流
+//
+// 注意，bidirectional按推流处理
+func (sid *StreamId) IsPublish() bool {
+	return sid.Mode == StreamIdModePublish || sid.Mode == StreamIdModeBidirectional
+}
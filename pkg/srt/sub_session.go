@@ -0,0 +1,110 @@
+// Copyright 2023, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package srt
+
+import (
+	"github.com/q191201771/lal/pkg/base"
+)
+
+// SubSession srt拉流，负载为mpegts
+type SubSession struct {
+	conn     *conn
+	urlCtx   base.UrlContext
+	streamId StreamId
+
+	IsFresh            bool
+	ShouldWaitBoundary bool
+
+	sessionStat base.BasicSessionStat
+}
+
+func NewSubSession(option connOption, streamId StreamId, localAddr string) *SubSession {
+	s := &SubSession{
+		streamId:           streamId,
+		IsFresh:            true,
+		ShouldWaitBoundary: true,
+		sessionStat:        base.NewBasicSessionStat(base.SessionTypeSrtSub, option.RAddr.String()),
+	}
+	s.urlCtx, _ = base.ParseUrl(buildUrl(localAddr, streamId), -1)
+
+	option.UniqueKey = s.UniqueKey()
+	option.Stat = &s.sessionStat
+	s.conn = newConn(option)
+
+	Log.Infof("[%s] lifecycle new srt SubSession. session=%p, remote addr=%s, stream id=%+v", s.UniqueKey(), s, option.RAddr.String(), streamId)
+	return s
+}
+
+// Write
+//
+// @param b: mpegts数据，需按188字节对齐。函数调用结束后，内部不持有该内存块
+func (session *SubSession) Write(b []byte) {
+	if err := session.conn.Write(b); err != nil {
+		Log.Debugf("[%s] write failed. err=%+v", session.UniqueKey(), err)
+	}
+}
+
+// ----- IServerSessionLifecycle ---------------------------------------------------------------------------------------
+
+func (session *SubSession) RunLoop() error {
+	return session.conn.runLoop()
+}
+
+func (session *SubSession) Dispose() error {
+	Log.Infof("[%s] lifecycle dispose srt SubSession.", session.UniqueKey())
+	session.conn.dispose(nil)
+	return nil
+}
+
+// ----- ISessionUrlContext --------------------------------------------------------------------------------------------
+
+func (session *SubSession) Url() string {
+	return session.urlCtx.Url
+}
+
+func (session *SubSession) AppName() string {
+	return session.urlCtx.PathWithoutLastItem
+}
+
+func (session *SubSession) StreamName() string {
+	return session.urlCtx.LastItemOfPath
+}
+
+func (session *SubSession) RawQuery() string {
+	return session.urlCtx.RawQuery
+}
+
+func (session *SubSession) Header() map[string][]string {
+	return nil
+}
+
+// StreamId 握手时对端携带的streamid
+func (session *SubSession) StreamId() StreamId {
+	return session.streamId
+}
+
+// ----- IObject -------------------------------------------------------------------------------------------------------
+
+func (session *SubSession) UniqueKey() string {
+	return session.sessionStat.UniqueKey()
+}
+
+// ----- ISessionStat --------------------------------------------------------------------------------------------------
+
+func (session *SubSession) UpdateStat(intervalSec uint32) {
+	session.sessionStat.UpdateStat(intervalSec)
+}
+
+func (session *SubSession) GetStat() base.StatSession {
+	return session.sessionStat.GetStat()
+}
+
+func (session *SubSession) IsAlive() (readAlive, writeAlive bool) {
+	return session.sessionStat.IsAlive()
+}
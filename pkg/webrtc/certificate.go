@@ -0,0 +1,68 @@
+// Copyright 2023, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package webrtc
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// Certificate DTLS使用的自签名证书
+//
+// WebRTC不校验证书链，对端通过SDP中a=fingerprint的证书指纹来验证身份
+type Certificate struct {
+	Der         []byte
+	PrivateKey  *ecdsa.PrivateKey
+	Fingerprint string // sha-256指纹，例如 AB:CD:...
+}
+
+func NewCertificate() (*Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 62))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "lal"},
+		NotBefore:    now.Add(-24 * time.Hour),
+		NotAfter:     now.AddDate(1, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	return &Certificate{
+		Der:         der,
+		PrivateKey:  key,
+		Fingerprint: calcFingerprint(der),
+	}, nil
+}
+
+func calcFingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	items := make([]string, len(sum))
+	for i, b := range sum {
+		items[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(items, ":")
+}
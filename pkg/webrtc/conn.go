@@ -0,0 +1,311 @@
+// Copyright 2023, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package webrtc
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+)
+
+// conn 一个WebRTC对端，负责ICE、DTLS、SRTP，pub和sub共用
+//
+// 接收相关的状态只在 runLoop 所在的协程中访问，发送由 runLoop 和上层的协程共同访问，使用 mu 保护
+type conn struct {
+	uniqueKey string
+	pconn     *net.UDPConn
+
+	localUfrag  string
+	localPwd    string
+	remoteUfrag string
+	idleTimeout time.Duration
+
+	dtls *dtlsServer
+
+	onRtp         func(b []byte)              // 解密后的rtp
+	onRtcp        func(b []byte)              // 解密后的rtcp
+	onEstablished func()                      // DTLS握手完成，可以开始收发SRTP
+	onTick        func(now time.Time)         // 在 runLoop 所在的协程中回调
+	onAddr        func(c *conn, raddr string) // 对端地址确定或改变时通知 Server
+	onDelete      func(c *conn)               // 连接关闭时通知 Server
+
+	inCh         chan inPacket
+	closeCh      chan struct{}
+	disposeOnce  sync.Once
+	closeErr     error
+	lastRecvTime time.Time
+
+	mu         sync.Mutex
+	raddr      *net.UDPAddr
+	localSrtp  *srtpContext // 加密本端发送的数据
+	remoteSrtp *srtpContext // 解密对端发送的数据
+}
+
+type inPacket struct {
+	b     []byte
+	raddr *net.UDPAddr
+}
+
+type connOption struct {
+	UniqueKey         string
+	PConn             *net.UDPConn
+	Cert              *Certificate
+	LocalUfrag        string
+	LocalPwd          string
+	RemoteUfrag       string
+	RemoteFingerprint string
+	IdleTimeout       time.Duration
+}
+
+const (
+	connInChanSize     = 1024
+	connTickIntervalMs = 500
+)
+
+func newConn(option connOption) *conn {
+	c := &conn{
+		uniqueKey:    option.UniqueKey,
+		pconn:        option.PConn,
+		localUfrag:   option.LocalUfrag,
+		localPwd:     option.LocalPwd,
+		remoteUfrag:  option.RemoteUfrag,
+		idleTimeout:  option.IdleTimeout,
+		inCh:         make(chan inPacket, connInChanSize),
+		closeCh:      make(chan struct{}),
+		lastRecvTime: time.Now(),
+	}
+	c.dtls = newDtlsServer(option.Cert, option.RemoteFingerprint, c.write)
+	return c
+}
+
+// runLoop 阻塞直到连接关闭
+func (c *conn) runLoop() error {
+	ticker := time.NewTicker(connTickIntervalMs * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.closeCh:
+			return c.closeErr
+		case pkt := <-c.inCh:
+			c.handlePacket(pkt.b, pkt.raddr)
+		case now := <-ticker.C:
+			if now.Sub(c.lastRecvTime) > c.idleTimeout {
+				Log.Warnf("[%s] webrtc peer idle timeout.", c.uniqueKey)
+				c.dispose(base.ErrWebrtcPeerIdleTimeout)
+				continue
+			}
+			if c.onTick != nil {
+				c.onTick(now)
+			}
+		}
+	}
+}
+
+// feed 由 Server 的读协程调用
+//
+// @param b: 函数调用结束后，内部继续持有该内存块
+func (c *conn) feed(b []byte, raddr *net.UDPAddr) {
+	select {
+	case c.inCh <- inPacket{b: b, raddr: raddr}:
+	default:
+		Log.Warnf("[%s] webrtc conn in chan full, drop packet.", c.uniqueKey)
+	}
+}
+
+// IsEstablished DTLS握手是否完成
+func (c *conn) IsEstablished() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.localSrtp != nil
+}
+
+// WriteRtp 加密并发送，握手完成前直接丢弃
+func (c *conn) WriteRtp(b []byte) error {
+	c.mu.Lock()
+	srtp := c.localSrtp
+	c.mu.Unlock()
+	if srtp == nil {
+		return base.ErrSessionNotStarted
+	}
+	out, err := srtp.EncryptRtp(b)
+	if err != nil {
+		return err
+	}
+	return c.write(out)
+}
+
+// WriteRtcp 加密并发送，握手完成前直接丢弃
+func (c *conn) WriteRtcp(b []byte) error {
+	c.mu.Lock()
+	srtp := c.localSrtp
+	c.mu.Unlock()
+	if srtp == nil {
+		return base.ErrSessionNotStarted
+	}
+	out, err := srtp.EncryptRtcp(b)
+	if err != nil {
+		return err
+	}
+	return c.write(out)
+}
+
+// RemoteAddr ICE选中的对端地址，还没有选中时为空
+func (c *conn) RemoteAddr() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.raddr == nil {
+		return ""
+	}
+	return c.raddr.String()
+}
+
+func (c *conn) dispose(err error) {
+	c.disposeOnce.Do(func() {
+		if err == nil {
+			// 本端主动关闭，通知对端
+			c.dtls.Close()
+		}
+		c.closeErr = err
+		close(c.closeCh)
+		if c.onDelete != nil {
+			c.onDelete(c)
+		}
+	})
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func (c *conn) handlePacket(b []byte, raddr *net.UDPAddr) {
+	c.lastRecvTime = time.Now()
+
+	switch {
+	case isStunPacket(b):
+		c.handleStun(b, raddr)
+	case isDtlsPacket(b):
+		c.handleDtls(b)
+	case isRtpOrRtcpPacket(b):
+		c.handleSrtp(b)
+	}
+}
+
+func (c *conn) handleStun(b []byte, raddr *net.UDPAddr) {
+	msg, err := parseStunMessage(b)
+	if err != nil || msg.Type != stunTypeBindingRequest {
+		return
+	}
+	if msg.Username() != c.localUfrag+":"+c.remoteUfrag || !msg.CheckIntegrity([]byte(c.localPwd)) {
+		Log.Warnf("[%s] invalid stun binding request. username=%s", c.uniqueKey, msg.Username())
+		return
+	}
+
+	c.mu.Lock()
+	changed := c.raddr == nil || c.raddr.Port != raddr.Port || !c.raddr.IP.Equal(raddr.IP)
+	// ICE-lite模式下，以对端提名(USE-CANDIDATE)或者第一次收到的地址作为传输地址
+	if changed && (c.raddr == nil || msg.HasUseCandidate()) {
+		c.raddr = raddr
+	} else {
+		changed = false
+	}
+	c.mu.Unlock()
+
+	if changed {
+		Log.Infof("[%s] webrtc ice selected. remote addr=%s", c.uniqueKey, raddr.String())
+		if c.onAddr != nil {
+			c.onAddr(c, raddr.String())
+		}
+	}
+
+	resp := packStunBindingSuccess(msg.TransactionId, raddr, []byte(c.localPwd))
+	if _, err = c.pconn.WriteToUDP(resp, raddr); err != nil {
+		Log.Warnf("[%s] write stun binding response failed. err=%+v", c.uniqueKey, err)
+	}
+}
+
+func (c *conn) handleDtls(b []byte) {
+	established, err := c.dtls.Handle(b)
+	if err != nil {
+		Log.Warnf("[%s] webrtc dtls failed. err=%+v", c.uniqueKey, err)
+		c.dispose(err)
+		return
+	}
+	if !established {
+		return
+	}
+
+	// 本端是DTLS服务端，使用client的密钥解密，使用server的密钥加密
+	km := c.dtls.ExportSrtpKeyingMaterial()
+	clientKey := km[:srtpMasterKeyLength]
+	serverKey := km[srtpMasterKeyLength : 2*srtpMasterKeyLength]
+	clientSalt := km[2*srtpMasterKeyLength : 2*srtpMasterKeyLength+srtpMasterSaltLength]
+	serverSalt := km[2*srtpMasterKeyLength+srtpMasterSaltLength:]
+
+	remoteSrtp, err := newSrtpContext(clientKey, clientSalt)
+	if err != nil {
+		c.dispose(err)
+		return
+	}
+	localSrtp, err := newSrtpContext(serverKey, serverSalt)
+	if err != nil {
+		c.dispose(err)
+		return
+	}
+	c.mu.Lock()
+	c.remoteSrtp = remoteSrtp
+	c.localSrtp = localSrtp
+	c.mu.Unlock()
+
+	Log.Infof("[%s] webrtc dtls handshake succ.", c.uniqueKey)
+	if c.onEstablished != nil {
+		c.onEstablished()
+	}
+}
+
+func (c *conn) handleSrtp(b []byte) {
+	c.mu.Lock()
+	srtp := c.remoteSrtp
+	c.mu.Unlock()
+	if srtp == nil {
+		return
+	}
+
+	if isRtcpPacket(b) {
+		out, err := srtp.DecryptRtcp(b)
+		if err != nil {
+			Log.Debugf("[%s] decrypt srtcp failed. err=%+v", c.uniqueKey, err)
+			return
+		}
+		if c.onRtcp != nil {
+			c.onRtcp(out)
+		}
+		return
+	}
+
+	out, err := srtp.DecryptRtp(b)
+	if err != nil {
+		Log.Debugf("[%s] decrypt srtp failed. err=%+v", c.uniqueKey, err)
+		return
+	}
+	if c.onRtp != nil {
+		c.onRtp(out)
+	}
+}
+
+func (c *conn) write(b []byte) error {
+	c.mu.Lock()
+	raddr := c.raddr
+	c.mu.Unlock()
+	if raddr == nil {
+		return base.ErrSessionNotStarted
+	}
+	_, err := c.pconn.WriteToUDP(b, raddr)
+	return err
+}
@@ -0,0 +1,794 @@
+// Copyright 2023, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package webrtc
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"strings"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/naza/pkg/bele"
+)
+
+// rfc6347 DTLS1.2，只实现了服务端角色
+//
+// 握手流程(没有使用HelloVerifyRequest)：
+//
+//   Client                                Server
+//   ClientHello            -------->
+//                                         ServerHello
+//                                         Certificate
+//                                         ServerKeyExchange
+//                                         CertificateRequest
+//                          <--------      ServerHelloDone
+//   Certificate
+//   ClientKeyExchange
+//   CertificateVerify
+//   [ChangeCipherSpec]
+//   Finished               -------->
+//                                         [ChangeCipherSpec]
+//                          <--------      Finished
+//
+// 重传由对端驱动：对端重传它的flight时，说明它没有收到我们上一次的flight，此时我们重新发送
+
+const (
+	dtlsRecordHeaderLength    = 13
+	dtlsHandshakeHeaderLength = 12
+	dtlsVersion12             = 0xFEFD
+	dtlsMaxDatagramSize       = 1200
+	dtlsMaxHandshakeLength    = 65536
+
+	dtlsContentTypeChangeCipherSpec = 20
+	dtlsContentTypeAlert            = 21
+	dtlsContentTypeHandshake        = 22
+	dtlsContentTypeApplicationData  = 23
+
+	dtlsHandshakeTypeClientHello        = 1
+	dtlsHandshakeTypeServerHello        = 2
+	dtlsHandshakeTypeCertificate        = 11
+	dtlsHandshakeTypeServerKeyExchange  = 12
+	dtlsHandshakeTypeCertificateRequest = 13
+	dtlsHandshakeTypeServerHelloDone    = 14
+	dtlsHandshakeTypeCertificateVerify  = 15
+	dtlsHandshakeTypeClientKeyExchange  = 16
+	dtlsHandshakeTypeFinished           = 20
+
+	dtlsAlertLevelWarning     = 1
+	dtlsAlertLevelFatal       = 2
+	dtlsAlertCloseNotify      = 0
+	dtlsAlertHandshakeFailure = 40
+
+	dtlsCipherSuiteEcdheEcdsaAes128GcmSha256  = 0xC02B
+	dtlsCipherSuiteEmptyRenegotiationInfoScsv = 0x00FF
+
+	dtlsExtSupportedGroups        = 10
+	dtlsExtEcPointFormats         = 11
+	dtlsExtUseSrtp                = 14
+	dtlsExtExtendedMasterSecret   = 23
+	dtlsExtRenegotiationInfo      = 0xFF01
+	dtlsSrtpProfileAes128CmSha180 = 0x0001
+
+	dtlsCurveSecp256r1 = 23
+	dtlsCurveX25519    = 29
+
+	dtlsSignatureEcdsaSecp256r1Sha256 = 0x0403
+	dtlsSignatureEcdsaSecp384r1Sha384 = 0x0503
+	dtlsSignatureRsaPkcs1Sha256       = 0x0401
+	dtlsSignatureRsaPssRsaeSha256     = 0x0804
+
+	dtlsMasterSecretLength  = 48
+	dtlsVerifyDataLength    = 12
+	dtlsGcmKeyLength        = 16
+	dtlsGcmImplicitIvLength = 4
+	dtlsGcmExplicitIvLength = 8
+	dtlsGcmTagLength        = 16
+
+	// 两个方向各一份 master key和master salt
+	srtpKeyingMaterialLength = 2 * (srtpMasterKeyLength + srtpMasterSaltLength)
+)
+
+type dtlsState int
+
+const (
+	dtlsStateWaitClientHello dtlsState = iota
+	dtlsStateWaitClientFinished
+	dtlsStateEstablished
+)
+
+type dtlsServer struct {
+	cert              *Certificate
+	remoteFingerprint string // 对端SDP中的sha-256证书指纹，为空时不校验
+	write             func(b []byte) error
+
+	state      dtlsState
+	recvMsgSeq uint16
+	sendMsgSeq uint16
+	writeEpoch uint16
+	writeSeq   [2]uint64
+	assemblies map[uint16]*dtlsAssembly
+	lastFlight []dtlsFlightItem
+
+	clientRandom      []byte
+	serverRandom      []byte
+	transcript        []byte // 用于计算Finished等的握手消息记录
+	curve             uint16
+	ecdhKey           *ecdh.PrivateKey
+	useEms            bool
+	renegotiationInfo bool
+	clientCert        *x509.Certificate
+	certVerified      bool
+	gotKeyExchange    bool
+
+	masterSecret []byte
+	clientAead   cipher.AEAD
+	serverAead   cipher.AEAD
+	clientIv     []byte
+	serverIv     []byte
+}
+
+type dtlsAssembly struct {
+	typ    byte
+	epoch  uint16
+	body   []byte
+	filled []bool
+	n      int
+}
+
+type dtlsFlightItem struct {
+	epoch   uint16
+	typ     byte
+	payload []byte
+}
+
+func newDtlsServer(cert *Certificate, remoteFingerprint string, write func(b []byte) error) *dtlsServer {
+	return &dtlsServer{
+		cert:              cert,
+		remoteFingerprint: remoteFingerprint,
+		write:             write,
+		assemblies:        make(map[uint16]*dtlsAssembly),
+	}
+}
+
+// Handle 处理收到的一个UDP包，包中可能包含多个record
+//
+// @return established: 本次调用是否完成了握手
+func (d *dtlsServer) Handle(b []byte) (established bool, err error) {
+	var retransmitted bool
+	for len(b) >= dtlsRecordHeaderLength {
+		typ := b[0]
+		epoch := bele.BeUint16(b[3:])
+		length := int(bele.BeUint16(b[11:]))
+		if dtlsRecordHeaderLength+length > len(b) {
+			return false, base.NewErrWebrtcDtls("invalid record length")
+		}
+		header := b[:dtlsRecordHeaderLength]
+		fragment := b[dtlsRecordHeaderLength : dtlsRecordHeaderLength+length]
+		b = b[dtlsRecordHeaderLength+length:]
+
+		switch epoch {
+		case 0:
+			// noop
+		case 1:
+			if d.clientAead == nil {
+				// 还没有协商出密钥，可能是乱序，丢弃，等待对端重传
+				continue
+			}
+			if fragment, err = d.decrypt(header, fragment); err != nil {
+				Log.Warnf("dtls decrypt record failed. type=%d, err=%+v", typ, err)
+				continue
+			}
+		default:
+			continue
+		}
+
+		switch typ {
+		case dtlsContentTypeChangeCipherSpec:
+			// noop，是否收到Finished由epoch保证
+		case dtlsContentTypeAlert:
+			if len(fragment) >= 2 && (fragment[0] == dtlsAlertLevelFatal || fragment[1] == dtlsAlertCloseNotify) {
+				return false, base.NewErrWebrtcDtlsAlert(fragment[0], fragment[1])
+			}
+		case dtlsContentTypeHandshake:
+			var done bool
+			if done, err = d.handleHandshakeRecord(fragment, epoch, &retransmitted); err != nil {
+				d.sendAlert(dtlsAlertLevelFatal, dtlsAlertHandshakeFailure)
+				return false, err
+			}
+			established = established || done
+		case dtlsContentTypeApplicationData:
+			// 不支持data channel，忽略
+		}
+	}
+	return established, nil
+}
+
+func (d *dtlsServer) IsEstablished() bool {
+	return d.state == dtlsStateEstablished
+}
+
+// ExportSrtpKeyingMaterial rfc5764 4.2
+//
+// @return 依次为client master key, server master key, client master salt, server master salt
+func (d *dtlsServer) ExportSrtpKeyingMaterial() []byte {
+	return prf(d.masterSecret, prfLabelSrtpExporter, concatBytes(d.clientRandom, d.serverRandom), srtpKeyingMaterialLength)
+}
+
+func (d *dtlsServer) Close() {
+	if d.state == dtlsStateEstablished {
+		d.sendAlert(dtlsAlertLevelWarning, dtlsAlertCloseNotify)
+	}
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func (d *dtlsServer) handleHandshakeRecord(p []byte, epoch uint16, retransmitted *bool) (established bool, err error) {
+	for len(p) >= dtlsHandshakeHeaderLength {
+		typ := p[0]
+		length := int(bele.BeUint24(p[1:]))
+		msgSeq := bele.BeUint16(p[4:])
+		fragOffset := int(bele.BeUint24(p[6:]))
+		fragLength := int(bele.BeUint24(p[9:]))
+		if dtlsHandshakeHeaderLength+fragLength > len(p) || fragOffset+fragLength > length || length > dtlsMaxHandshakeLength {
+			return false, base.NewErrWebrtcDtls("invalid handshake fragment")
+		}
+		fragment := p[dtlsHandshakeHeaderLength : dtlsHandshakeHeaderLength+fragLength]
+		p = p[dtlsHandshakeHeaderLength+fragLength:]
+
+		if msgSeq < d.recvMsgSeq {
+			// 对端重传了已经处理过的消息，说明它没有收到我们上一次发送的flight
+			if !*retransmitted && d.lastFlight != nil {
+				Log.Debugf("dtls retransmit last flight. msg seq=%d", msgSeq)
+				d.sendFlight()
+				*retransmitted = true
+			}
+			continue
+		}
+
+		a, ok := d.assemblies[msgSeq]
+		if !ok {
+			a = &dtlsAssembly{
+				typ:    typ,
+				epoch:  epoch,
+				body:   make([]byte, length),
+				filled: make([]bool, length),
+			}
+			d.assemblies[msgSeq] = a
+		}
+		if a.typ != typ || len(a.body) != length {
+			return false, base.NewErrWebrtcDtls("handshake fragment mismatch")
+		}
+		copy(a.body[fragOffset:], fragment)
+		for i := fragOffset; i < fragOffset+fragLength; i++ {
+			if !a.filled[i] {
+				a.filled[i] = true
+				a.n++
+			}
+		}
+
+		// 按顺序处理已经完整的消息
+		for {
+			a, ok = d.assemblies[d.recvMsgSeq]
+			if !ok || a.n != len(a.body) {
+				break
+			}
+			delete(d.assemblies, d.recvMsgSeq)
+			d.recvMsgSeq++
+
+			var done bool
+			if done, err = d.handleMessage(a.typ, a.epoch, a.body); err != nil {
+				return false, err
+			}
+			established = established || done
+		}
+	}
+	return established, nil
+}
+
+func (d *dtlsServer) handleMessage(typ byte, epoch uint16, body []byte) (established bool, err error) {
+	switch d.state {
+	case dtlsStateWaitClientHello:
+		if typ != dtlsHandshakeTypeClientHello {
+			return false, base.NewErrWebrtcDtls("expect client hello")
+		}
+		return false, d.handleClientHello(body)
+	case dtlsStateWaitClientFinished:
+		switch typ {
+		case dtlsHandshakeTypeCertificate:
+			if d.gotKeyExchange {
+				return false, base.NewErrWebrtcDtls("unexpected certificate")
+			}
+			return false, d.handleCertificate(body)
+		case dtlsHandshakeTypeClientKeyExchange:
+			return false, d.handleClientKeyExchange(body)
+		case dtlsHandshakeTypeCertificateVerify:
+			return false, d.handleCertificateVerify(body)
+		case dtlsHandshakeTypeFinished:
+			if epoch != 1 {
+				return false, base.NewErrWebrtcDtls("finished not encrypted")
+			}
+			if err = d.handleFinished(body); err != nil {
+				return false, err
+			}
+			return true, nil
+		}
+	}
+	return false, base.NewErrWebrtcDtls("unexpected handshake message")
+}
+
+func (d *dtlsServer) handleClientHello(body []byte) error {
+	r := dtlsReader{b: body}
+	r.skip(2) // client_version
+	d.clientRandom = append([]byte(nil), r.read(32)...)
+	r.readVector8() // session_id
+	r.readVector8() // cookie
+	suites := dtlsReader{b: r.readVector16()}
+	r.readVector8() // compression_methods
+	exts := dtlsReader{b: r.readVector16()}
+	if r.err {
+		return base.NewErrWebrtcDtls("invalid client hello")
+	}
+
+	var hasSuite bool
+	for !suites.done() && !suites.err {
+		switch suites.readUint16() {
+		case dtlsCipherSuiteEcdheEcdsaAes128GcmSha256:
+			hasSuite = true
+		case dtlsCipherSuiteEmptyRenegotiationInfoScsv:
+			// rfc5746 3.6，和renegotiation_info扩展等价
+			d.renegotiationInfo = true
+		}
+	}
+	if !hasSuite {
+		return base.NewErrWebrtcDtls("no supported cipher suite")
+	}
+
+	var hasSrtpProfile bool
+	d.curve = dtlsCurveSecp256r1
+	for !exts.done() && !exts.err {
+		t := exts.readUint16()
+		v := dtlsReader{b: exts.readVector16()}
+		switch t {
+		case dtlsExtUseSrtp:
+			profiles := dtlsReader{b: v.readVector16()}
+			for !profiles.done() && !profiles.err {
+				if profiles.readUint16() == dtlsSrtpProfileAes128CmSha180 {
+					hasSrtpProfile = true
+				}
+			}
+		case dtlsExtSupportedGroups:
+			groups := dtlsReader{b: v.readVector16()}
+			var hasX25519, hasP256 bool
+			for !groups.done() && !groups.err {
+				switch groups.readUint16() {
+				case dtlsCurveX25519:
+					hasX25519 = true
+				case dtlsCurveSecp256r1:
+					hasP256 = true
+				}
+			}
+			if hasX25519 {
+				d.curve = dtlsCurveX25519
+			} else if !hasP256 {
+				return base.NewErrWebrtcDtls("no supported curve")
+			}
+		case dtlsExtExtendedMasterSecret:
+			d.useEms = true
+		case dtlsExtRenegotiationInfo:
+			d.renegotiationInfo = true
+		}
+	}
+	if !hasSrtpProfile {
+		return base.NewErrWebrtcDtls("no supported srtp profile")
+	}
+
+	d.appendTranscript(dtlsHandshakeTypeClientHello, d.recvMsgSeq-1, body)
+
+	var err error
+	d.serverRandom = make([]byte, 32)
+	if _, err = rand.Read(d.serverRandom); err != nil {
+		return err
+	}
+	if d.curve == dtlsCurveX25519 {
+		d.ecdhKey, err = ecdh.X25519().GenerateKey(rand.Reader)
+	} else {
+		d.ecdhKey, err = ecdh.P256().GenerateKey(rand.Reader)
+	}
+	if err != nil {
+		return err
+	}
+
+	// ServerHello
+	var w dtlsWriter
+	w.writeUint16(dtlsVersion12)
+	w.write(d.serverRandom)
+	w.writeUint8(0) // session_id
+	w.writeUint16(dtlsCipherSuiteEcdheEcdsaAes128GcmSha256)
+	w.writeUint8(0) // compression_method
+	var ext dtlsWriter
+	ext.writeUint16(dtlsExtUseSrtp)
+	ext.writeUint16(5)
+	ext.writeUint16(2)
+	ext.writeUint16(dtlsSrtpProfileAes128CmSha180)
+	ext.writeUint8(0) // mki
+	ext.writeUint16(dtlsExtEcPointFormats)
+	ext.writeUint16(2)
+	ext.writeUint8(1)
+	ext.writeUint8(0) // uncompressed
+	if d.useEms {
+		ext.writeUint16(dtlsExtExtendedMasterSecret)
+		ext.writeUint16(0)
+	}
+	if d.renegotiationInfo {
+		ext.writeUint16(dtlsExtRenegotiationInfo)
+		ext.writeUint16(1)
+		ext.writeUint8(0)
+	}
+	w.writeVector16(ext.b)
+	serverHello := w.b
+
+	// Certificate
+	w = dtlsWriter{}
+	w.writeUint24(uint32(3 + len(d.cert.Der)))
+	w.writeUint24(uint32(len(d.cert.Der)))
+	w.write(d.cert.Der)
+	certificate := w.b
+
+	// ServerKeyExchange
+	w = dtlsWriter{}
+	w.writeUint8(3) // named_curve
+	w.writeUint16(d.curve)
+	w.writeVector8(d.ecdhKey.PublicKey().Bytes())
+	params := w.b
+	digest := sha256.Sum256(concatBytes(d.clientRandom, d.serverRandom, params))
+	sig, err := ecdsa.SignASN1(rand.Reader, d.cert.PrivateKey, digest[:])
+	if err != nil {
+		return err
+	}
+	w.writeUint16(dtlsSignatureEcdsaSecp256r1Sha256)
+	w.writeVector16(sig)
+	serverKeyExchange := w.b
+
+	// CertificateRequest，用于校验对端证书和SDP中的指纹是否匹配
+	w = dtlsWriter{}
+	w.writeVector8([]byte{64, 1}) // ecdsa_sign, rsa_sign
+	var algs dtlsWriter
+	for _, alg := range []uint16{dtlsSignatureEcdsaSecp256r1Sha256, dtlsSignatureEcdsaSecp384r1Sha384, dtlsSignatureRsaPkcs1Sha256, dtlsSignatureRsaPssRsaeSha256} {
+		algs.writeUint16(alg)
+	}
+	w.writeVector16(algs.b)
+	w.writeUint16(0) // certificate_authorities
+	certificateRequest := w.b
+
+	d.lastFlight = []dtlsFlightItem{
+		d.newHandshakeItem(0, dtlsHandshakeTypeServerHello, serverHello),
+		d.newHandshakeItem(0, dtlsHandshakeTypeCertificate, certificate),
+		d.newHandshakeItem(0, dtlsHandshakeTypeServerKeyExchange, serverKeyExchange),
+		d.newHandshakeItem(0, dtlsHandshakeTypeCertificateRequest, certificateRequest),
+		d.newHandshakeItem(0, dtlsHandshakeTypeServerHelloDone, nil),
+	}
+	d.state = dtlsStateWaitClientFinished
+	d.sendFlight()
+	return nil
+}
+
+func (d *dtlsServer) handleCertificate(body []byte) error {
+	r := dtlsReader{b: body}
+	list := dtlsReader{b: r.readVector24()}
+	if r.err {
+		return base.NewErrWebrtcDtls("invalid certificate")
+	}
+	if !list.done() {
+		der := list.readVector24()
+		if list.err {
+			return base.NewErrWebrtcDtls("invalid certificate")
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return err
+		}
+		if d.remoteFingerprint != "" && !strings.EqualFold(calcFingerprint(der), d.remoteFingerprint) {
+			return base.NewErrWebrtcDtls("certificate fingerprint mismatch")
+		}
+		d.clientCert = cert
+	}
+	d.appendTranscript(dtlsHandshakeTypeCertificate, d.recvMsgSeq-1, body)
+	return nil
+}
+
+func (d *dtlsServer) handleClientKeyExchange(body []byte) error {
+	if d.remoteFingerprint != "" && d.clientCert == nil {
+		return base.NewErrWebrtcDtls("no client certificate")
+	}
+
+	r := dtlsReader{b: body}
+	pub := r.readVector8()
+	if r.err {
+		return base.NewErrWebrtcDtls("invalid client key exchange")
+	}
+	peer, err := d.ecdhKey.Curve().NewPublicKey(pub)
+	if err != nil {
+		return err
+	}
+	preMasterSecret, err := d.ecdhKey.ECDH(peer)
+	if err != nil {
+		return err
+	}
+	d.appendTranscript(dtlsHandshakeTypeClientKeyExchange, d.recvMsgSeq-1, body)
+	d.gotKeyExchange = true
+
+	if d.useEms {
+		sessionHash := sha256.Sum256(d.transcript)
+		d.masterSecret = prf(preMasterSecret, prfLabelExtendedMasterSecret, sessionHash[:], dtlsMasterSecretLength)
+	} else {
+		d.masterSecret = prf(preMasterSecret, prfLabelMasterSecret, concatBytes(d.clientRandom, d.serverRandom), dtlsMasterSecretLength)
+	}
+
+	keyBlock := prf(d.masterSecret, prfLabelKeyExpansion, concatBytes(d.serverRandom, d.clientRandom), 2*(dtlsGcmKeyLength+dtlsGcmImplicitIvLength))
+	if d.clientAead, err = newGcm(keyBlock[:dtlsGcmKeyLength]); err != nil {
+		return err
+	}
+	if d.serverAead, err = newGcm(keyBlock[dtlsGcmKeyLength : 2*dtlsGcmKeyLength]); err != nil {
+		return err
+	}
+	d.clientIv = keyBlock[2*dtlsGcmKeyLength : 2*dtlsGcmKeyLength+dtlsGcmImplicitIvLength]
+	d.serverIv = keyBlock[2*dtlsGcmKeyLength+dtlsGcmImplicitIvLength:]
+	return nil
+}
+
+func (d *dtlsServer) handleCertificateVerify(body []byte) error {
+	if d.clientCert == nil || !d.gotKeyExchange {
+		return base.NewErrWebrtcDtls("unexpected certificate verify")
+	}
+	r := dtlsReader{b: body}
+	alg := r.readUint16()
+	sig := r.readVector16()
+	if r.err {
+		return base.NewErrWebrtcDtls("invalid certificate verify")
+	}
+	var sigAlg x509.SignatureAlgorithm
+	switch alg {
+	case dtlsSignatureEcdsaSecp256r1Sha256:
+		sigAlg = x509.ECDSAWithSHA256
+	case dtlsSignatureEcdsaSecp384r1Sha384:
+		sigAlg = x509.ECDSAWithSHA384
+	case dtlsSignatureRsaPkcs1Sha256:
+		sigAlg = x509.SHA256WithRSA
+	case dtlsSignatureRsaPssRsaeSha256:
+		sigAlg = x509.SHA256WithRSAPSS
+	default:
+		return base.NewErrWebrtcDtls("unsupported signature algorithm")
+	}
+	if err := d.clientCert.CheckSignature(sigAlg, d.transcript, sig); err != nil {
+		return err
+	}
+	d.certVerified = true
+	d.appendTranscript(dtlsHandshakeTypeCertificateVerify, d.recvMsgSeq-1, body)
+	return nil
+}
+
+func (d *dtlsServer) handleFinished(body []byte) error {
+	if !d.gotKeyExchange || (d.clientCert != nil && !d.certVerified) {
+		return base.NewErrWebrtcDtls("unexpected finished")
+	}
+	h := sha256.Sum256(d.transcript)
+	expected := prf(d.masterSecret, prfLabelClientFinished, h[:], dtlsVerifyDataLength)
+	if !hmac.Equal(expected, body) {
+		return base.NewErrWebrtcDtls("verify data mismatch")
+	}
+	d.appendTranscript(dtlsHandshakeTypeFinished, d.recvMsgSeq-1, body)
+
+	h = sha256.Sum256(d.transcript)
+	verifyData := prf(d.masterSecret, prfLabelServerFinished, h[:], dtlsVerifyDataLength)
+
+	d.lastFlight = []dtlsFlightItem{
+		{epoch: 0, typ: dtlsContentTypeChangeCipherSpec, payload: []byte{1}},
+		d.newHandshakeItem(1, dtlsHandshakeTypeFinished, verifyData),
+	}
+	d.writeEpoch = 1
+	d.state = dtlsStateEstablished
+	d.sendFlight()
+	return nil
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func (d *dtlsServer) newHandshakeItem(epoch uint16, typ byte, body []byte) dtlsFlightItem {
+	msg := packDtlsHandshake(typ, d.sendMsgSeq, body)
+	d.sendMsgSeq++
+	d.transcript = append(d.transcript, msg...)
+	return dtlsFlightItem{epoch: epoch, typ: dtlsContentTypeHandshake, payload: msg}
+}
+
+func (d *dtlsServer) appendTranscript(typ byte, msgSeq uint16, body []byte) {
+	d.transcript = append(d.transcript, packDtlsHandshake(typ, msgSeq, body)...)
+}
+
+// sendFlight 发送(或重传)上一次的flight，多个record尽量合并在一个UDP包中
+//
+// 注意，重传时使用新的record序号，避免被对端的重放检测丢弃
+func (d *dtlsServer) sendFlight() {
+	var datagram []byte
+	for _, item := range d.lastFlight {
+		record := d.packRecord(item.epoch, item.typ, item.payload)
+		if len(datagram) != 0 && len(datagram)+len(record) > dtlsMaxDatagramSize {
+			_ = d.write(datagram)
+			datagram = nil
+		}
+		datagram = append(datagram, record...)
+	}
+	if len(datagram) != 0 {
+		_ = d.write(datagram)
+	}
+}
+
+func (d *dtlsServer) sendAlert(level, desc byte) {
+	_ = d.write(d.packRecord(d.writeEpoch, dtlsContentTypeAlert, []byte{level, desc}))
+}
+
+func (d *dtlsServer) packRecord(epoch uint16, typ byte, payload []byte) []byte {
+	seq := d.writeSeq[epoch]
+	d.writeSeq[epoch]++
+
+	out := make([]byte, dtlsRecordHeaderLength, dtlsRecordHeaderLength+dtlsGcmExplicitIvLength+len(payload)+dtlsGcmTagLength)
+	out[0] = typ
+	bele.BePutUint16(out[1:], dtlsVersion12)
+	bele.BePutUint16(out[3:], epoch)
+	putUint48(out[5:], seq)
+
+	if epoch == 0 {
+		bele.BePutUint16(out[11:], uint16(len(payload)))
+		return append(out, payload...)
+	}
+
+	explicitIv := out[3:11]
+	nonce := concatBytes(d.serverIv, explicitIv)
+	aad := makeDtlsAad(out, len(payload))
+	out = append(out, explicitIv...)
+	out = d.serverAead.Seal(out, nonce, payload, aad)
+	bele.BePutUint16(out[11:], uint16(len(out)-dtlsRecordHeaderLength))
+	return out
+}
+
+func (d *dtlsServer) decrypt(header []byte, fragment []byte) ([]byte, error) {
+	if len(fragment) < dtlsGcmExplicitIvLength+dtlsGcmTagLength {
+		return nil, base.NewErrWebrtcDtls("invalid encrypted record")
+	}
+	nonce := concatBytes(d.clientIv, fragment[:dtlsGcmExplicitIvLength])
+	aad := makeDtlsAad(header, len(fragment)-dtlsGcmExplicitIvLength-dtlsGcmTagLength)
+	return d.clientAead.Open(nil, nonce, fragment[dtlsGcmExplicitIvLength:], aad)
+}
+
+// makeDtlsAad additional_data = seq_num(epoch+sequence_number) + type + version + length
+func makeDtlsAad(header []byte, plainLength int) []byte {
+	aad := make([]byte, 13)
+	copy(aad, header[3:11])
+	aad[8] = header[0]
+	copy(aad[9:11], header[1:3])
+	bele.BePutUint16(aad[11:], uint16(plainLength))
+	return aad
+}
+
+func packDtlsHandshake(typ byte, msgSeq uint16, body []byte) []byte {
+	out := make([]byte, dtlsHandshakeHeaderLength, dtlsHandshakeHeaderLength+len(body))
+	out[0] = typ
+	bele.BePutUint24(out[1:], uint32(len(body)))
+	bele.BePutUint16(out[4:], msgSeq)
+	bele.BePutUint24(out[6:], 0)
+	bele.BePutUint24(out[9:], uint32(len(body)))
+	return append(out, body...)
+}
+
+func newGcm(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func putUint48(b []byte, v uint64) {
+	for i := 0; i < 6; i++ {
+		b[i] = byte(v >> (8 * (5 - i)))
+	}
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// dtlsReader 解析TLS格式的数据，越界时设置err，后续的读取都返回零值
+type dtlsReader struct {
+	b   []byte
+	err bool
+}
+
+func (r *dtlsReader) done() bool {
+	return len(r.b) == 0
+}
+
+func (r *dtlsReader) read(n int) []byte {
+	if r.err || n > len(r.b) {
+		r.err = true
+		return nil
+	}
+	v := r.b[:n]
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *dtlsReader) skip(n int) {
+	r.read(n)
+}
+
+func (r *dtlsReader) readUint8() byte {
+	v := r.read(1)
+	if v == nil {
+		return 0
+	}
+	return v[0]
+}
+
+func (r *dtlsReader) readUint16() uint16 {
+	v := r.read(2)
+	if v == nil {
+		return 0
+	}
+	return bele.BeUint16(v)
+}
+
+func (r *dtlsReader) readVector8() []byte {
+	return r.read(int(r.readUint8()))
+}
+
+func (r *dtlsReader) readVector16() []byte {
+	return r.read(int(r.readUint16()))
+}
+
+func (r *dtlsReader) readVector24() []byte {
+	v := r.read(3)
+	if v == nil {
+		return nil
+	}
+	return r.read(int(bele.BeUint24(v)))
+}
+
+type dtlsWriter struct {
+	b []byte
+}
+
+func (w *dtlsWriter) write(v []byte) {
+	w.b = append(w.b, v...)
+}
+
+func (w *dtlsWriter) writeUint8(v byte) {
+	w.b = append(w.b, v)
+}
+
+func (w *dtlsWriter) writeUint16(v uint16) {
+	w.b = append(w.b, byte(v>>8), byte(v))
+}
+
+func (w *dtlsWriter) writeUint24(v uint32) {
+	w.b = append(w.b, byte(v>>16), byte(v>>8), byte(v))
+}
+
+func (w *dtlsWriter) writeVector8(v []byte) {
+	w.writeUint8(byte(len(v)))
+	w.write(v)
+}
+
+func (w *dtlsWriter) writeVector16(v []byte) {
+	w.writeUint16(uint16(len(v)))
+	w.write(v)
+}
@@ -0,0 +1,60 @@
+// Copyright 2023, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package webrtc
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+)
+
+// rfc5246 5. HMAC and the Pseudorandom Function
+//
+// TLS1.2中，TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256使用P_SHA256
+func prf(secret []byte, label string, seed []byte, n int) []byte {
+	labelAndSeed := make([]byte, 0, len(label)+len(seed))
+	labelAndSeed = append(labelAndSeed, label...)
+	labelAndSeed = append(labelAndSeed, seed...)
+
+	out := make([]byte, 0, n+sha256.Size)
+	h := hmac.New(sha256.New, secret)
+	h.Write(labelAndSeed)
+	a := h.Sum(nil) // A(1)
+	for len(out) < n {
+		h.Reset()
+		h.Write(a)
+		h.Write(labelAndSeed)
+		out = h.Sum(out)
+
+		h.Reset()
+		h.Write(a)
+		a = h.Sum(nil)
+	}
+	return out[:n]
+}
+
+const (
+	prfLabelMasterSecret         = "master secret"
+	prfLabelExtendedMasterSecret = "extended master secret" // rfc7627
+	prfLabelKeyExpansion         = "key expansion"
+	prfLabelClientFinished       = "client finished"
+	prfLabelServerFinished       = "server finished"
+	prfLabelSrtpExporter         = "EXTRACTOR-dtls_srtp" // rfc5764 4.2
+)
+
+func concatBytes(bs ...[]byte) []byte {
+	var n int
+	for _, b := range bs {
+		n += len(b)
+	}
+	out := make([]byte, 0, n)
+	for _, b := range bs {
+		out = append(out, b...)
+	}
+	return out
+}
@@ -0,0 +1,193 @@
+// Copyright 2023, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package webrtc
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/rtprtcp"
+	"github.com/q191201771/lal/pkg/rtsp"
+	"github.com/q191201771/lal/pkg/sdp"
+	"github.com/q191201771/naza/pkg/bele"
+)
+
+// PubSession WHIP推流
+//
+// 解密后的rtp、rtcp交给 rtsp.BaseInSession 处理，所以上层可以像使用rtsp推流一样使用，
+// 也即通过 SetObserver 获取 sdp.LogicContext 、 rtprtcp.RtpPacket 以及 base.AvPacket
+type PubSession struct {
+	urlCtx        base.UrlContext
+	conn          *conn
+	baseInSession *rtsp.BaseInSession
+
+	videoPayloadType int // 没有时为-1
+	audioPayloadType int
+
+	// 以下字段只在 conn.runLoop 所在的协程中访问
+	localSsrc   uint32
+	videoSsrc   uint32
+	pliInterval time.Duration
+	lastPliTime time.Time
+}
+
+// 和 packLogicSdp 中的streamid对应
+const (
+	videoRtpChannel  = 0
+	videoRtcpChannel = 1
+	audioRtpChannel  = 2
+	audioRtcpChannel = 3
+)
+
+func NewPubSession(urlCtx base.UrlContext, option connOption, audio, video *negotiatedMedia, pliInterval time.Duration) (*PubSession, error) {
+	sdpCtx, err := sdp.ParseSdp2LogicContext(packLogicSdp(audio, video))
+	if err != nil {
+		return nil, err
+	}
+
+	s := &PubSession{
+		urlCtx:           urlCtx,
+		videoPayloadType: -1,
+		audioPayloadType: -1,
+		localSsrc:        rand.Uint32(),
+		pliInterval:      pliInterval,
+	}
+	s.baseInSession = rtsp.NewBaseInSession(base.SessionTypeWebrtcPub, s)
+	s.baseInSession.InitWithSdp(sdpCtx)
+	if video != nil {
+		s.videoPayloadType = video.codecs[0].PayloadType
+		s.videoSsrc = video.offer.Ssrc
+		_ = s.baseInSession.SetupWithChannel(sdpCtx.MakeVideoSetupUri(urlCtx.Url), videoRtpChannel, videoRtcpChannel)
+	}
+	if audio != nil {
+		s.audioPayloadType = audio.codecs[0].PayloadType
+		_ = s.baseInSession.SetupWithChannel(sdpCtx.MakeAudioSetupUri(urlCtx.Url), audioRtpChannel, audioRtcpChannel)
+	}
+
+	option.UniqueKey = s.UniqueKey()
+	s.conn = newConn(option)
+	s.conn.onRtp = s.onRtp
+	s.conn.onRtcp = s.onRtcp
+	s.conn.onTick = s.onTick
+
+	Log.Infof("[%s] lifecycle new webrtc PubSession. session=%p, url=%s", s.UniqueKey(), s, urlCtx.Url)
+	return s, nil
+}
+
+// SetObserver 上层应该在 IServerObserver.OnNewWebrtcPubSession 中调用该函数，此时对端还不能发送数据
+func (session *PubSession) SetObserver(observer rtsp.IBaseInSessionObserver) {
+	session.baseInSession.SetObserver(observer)
+}
+
+func (session *PubSession) GetSdp() sdp.LogicContext {
+	return session.baseInSession.GetSdp()
+}
+
+// ----- IServerSessionLifecycle ---------------------------------------------------------------------------------------
+
+func (session *PubSession) RunLoop() error {
+	return session.conn.runLoop()
+}
+
+func (session *PubSession) Dispose() error {
+	Log.Infof("[%s] lifecycle dispose webrtc PubSession. session=%p", session.UniqueKey(), session)
+	session.conn.dispose(nil)
+	return session.baseInSession.Dispose()
+}
+
+// ----- ISessionUrlContext --------------------------------------------------------------------------------------------
+
+func (session *PubSession) Url() string {
+	return session.urlCtx.Url
+}
+
+func (session *PubSession) AppName() string {
+	return session.urlCtx.PathWithoutLastItem
+}
+
+func (session *PubSession) StreamName() string {
+	return session.urlCtx.LastItemOfPath
+}
+
+func (session *PubSession) RawQuery() string {
+	return session.urlCtx.RawQuery
+}
+
+func (session *PubSession) Header() map[string][]string {
+	return nil
+}
+
+// ----- IObject -------------------------------------------------------------------------------------------------------
+
+func (session *PubSession) UniqueKey() string {
+	return session.baseInSession.UniqueKey()
+}
+
+// ----- ISessionStat --------------------------------------------------------------------------------------------------
+
+func (session *PubSession) GetStat() base.StatSession {
+	stat := session.baseInSession.GetStat()
+	stat.RemoteAddr = session.conn.RemoteAddr()
+	return stat
+}
+
+func (session *PubSession) UpdateStat(intervalSec uint32) {
+	session.baseInSession.UpdateStat(intervalSec)
+}
+
+func (session *PubSession) IsAlive() (readAlive, writeAlive bool) {
+	return session.baseInSession.IsAlive()
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// WriteInterleavedPacket rtsp.IInterleavedPacketWriter, callback by rtsp.BaseInSession
+//
+// BaseInSession只会发送rtcp rr
+func (session *PubSession) WriteInterleavedPacket(packet []byte, channel int) error {
+	return session.conn.WriteRtcp(packet)
+}
+
+func (session *PubSession) onRtp(b []byte) {
+	pt := int(b[1] & 0x7F)
+	switch pt {
+	case session.videoPayloadType:
+		session.videoSsrc = bele.BeUint32(b[8:])
+		session.baseInSession.HandleInterleavedPacket(b, videoRtpChannel)
+	case session.audioPayloadType:
+		session.baseInSession.HandleInterleavedPacket(b, audioRtpChannel)
+	default:
+		// 没有协商的pt，比如对端发送的padding探测包，忽略
+	}
+}
+
+func (session *PubSession) onRtcp(b []byte) {
+	// 只有sr需要处理， rtsp.BaseInSession 会回复rr
+	if b[1] != rtprtcp.RtcpPacketTypeSr {
+		return
+	}
+	channel := videoRtcpChannel
+	if session.videoPayloadType == -1 {
+		channel = audioRtcpChannel
+	}
+	session.baseInSession.HandleInterleavedPacket(b, channel)
+}
+
+// onTick 定时发送PLI请求关键帧，使得后加入的拉流端可以尽快看到画面
+func (session *PubSession) onTick(now time.Time) {
+	if session.videoPayloadType == -1 || session.videoSsrc == 0 || session.pliInterval <= 0 {
+		return
+	}
+	if now.Sub(session.lastPliTime) < session.pliInterval {
+		return
+	}
+	session.lastPliTime = now
+	_ = session.conn.WriteRtcp(rtprtcp.PackPli(session.localSsrc, session.videoSsrc))
+}
@@ -0,0 +1,448 @@
+// Copyright 2023, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package webrtc
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/sdp"
+)
+
+// rfc8829 JSEP 中offer/answer相关的部分
+//
+// 注意，pkg/sdp只支持rtsp场景下每个m只有一个pt的情况，所以webrtc的sdp在这里单独解析，
+// 单行的解析复用 sdp.ParseARtpMap 和 sdp.ParseAFmtPBase
+
+const (
+	sdpDirectionSendRecv = "sendrecv"
+	sdpDirectionSendOnly = "sendonly"
+	sdpDirectionRecvOnly = "recvonly"
+	sdpDirectionInactive = "inactive"
+
+	codecNameOpus = "opus"
+)
+
+type sdpCodec struct {
+	PayloadType        int
+	EncodingName       string
+	ClockRate          int
+	EncodingParameters string
+	Fmtp               string            // a=fmtp中pt后面的原始内容，没有时为空
+	FmtpParameters     map[string]string // 解析失败或者没有时为nil
+}
+
+type sdpMedia struct {
+	Kind      string // audio或video
+	Port      int
+	Protocol  string
+	Mid       string
+	Direction string
+	Codecs    []sdpCodec // 按m行中pt的顺序排列
+	Ssrc      uint32     // 第一个a=ssrc，没有时为0
+
+	IceUfrag    string
+	IcePwd      string
+	Fingerprint string
+}
+
+type sessionDescription struct {
+	IceUfrag    string
+	IcePwd      string
+	Fingerprint string // 只支持sha-256，不包含算法前缀
+	Medias      []sdpMedia
+}
+
+// parseOffer 解析对端的offer
+//
+// ice-ufrag、ice-pwd、fingerprint可能在session级别，也可能在media级别，这里统一提升到session级别
+func parseOffer(b []byte) (sd sessionDescription, err error) {
+	var (
+		media       *sdpMedia
+		fingerprint string
+	)
+
+	lines := strings.Split(strings.ReplaceAll(string(b), "\r\n", "\n"), "\n")
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "m="):
+			items := strings.Fields(strings.TrimPrefix(line, "m="))
+			if len(items) < 3 {
+				return sd, fmt.Errorf("%w. invalid m line: %s", base.ErrWebrtcInvalidSdp, line)
+			}
+			sd.Medias = append(sd.Medias, sdpMedia{
+				Kind:      items[0],
+				Protocol:  items[2],
+				Direction: sdpDirectionSendRecv,
+			})
+			media = &sd.Medias[len(sd.Medias)-1]
+			media.Port, _ = strconv.Atoi(items[1])
+			for _, item := range items[3:] {
+				if pt, e := strconv.Atoi(item); e == nil {
+					media.Codecs = append(media.Codecs, sdpCodec{PayloadType: pt})
+				}
+			}
+		case strings.HasPrefix(line, "a="):
+			key, value := line[2:], ""
+			if i := strings.Index(key, ":"); i != -1 {
+				key, value = key[:i], key[i+1:]
+			}
+
+			switch key {
+			case "ice-ufrag":
+				if media == nil {
+					sd.IceUfrag = value
+				} else {
+					media.IceUfrag = value
+				}
+			case "ice-pwd":
+				if media == nil {
+					sd.IcePwd = value
+				} else {
+					media.IcePwd = value
+				}
+			case "fingerprint":
+				items := strings.Fields(value)
+				if len(items) != 2 || !strings.EqualFold(items[0], "sha-256") {
+					continue
+				}
+				if media == nil {
+					fingerprint = items[1]
+				} else {
+					media.Fingerprint = items[1]
+				}
+			}
+			if media == nil {
+				continue
+			}
+
+			switch key {
+			case "mid":
+				media.Mid = value
+			case sdpDirectionSendRecv, sdpDirectionSendOnly, sdpDirectionRecvOnly, sdpDirectionInactive:
+				media.Direction = key
+			case "rtpmap":
+				rtpmap, e := sdp.ParseARtpMap(line)
+				if e != nil {
+					continue
+				}
+				for i := range media.Codecs {
+					if media.Codecs[i].PayloadType == rtpmap.PayloadType {
+						media.Codecs[i].EncodingName = rtpmap.EncodingName
+						media.Codecs[i].ClockRate = rtpmap.ClockRate
+						media.Codecs[i].EncodingParameters = rtpmap.EncodingParameters
+					}
+				}
+			case "fmtp":
+				items := strings.SplitN(value, " ", 2)
+				if len(items) != 2 {
+					continue
+				}
+				pt, e := strconv.Atoi(items[0])
+				if e != nil {
+					continue
+				}
+				for i := range media.Codecs {
+					if media.Codecs[i].PayloadType == pt {
+						media.Codecs[i].Fmtp = items[1]
+						// 有些参数不是key=value的形式，解析失败时只保留原始内容
+						if f, e := sdp.ParseAFmtPBase(line); e == nil {
+							media.Codecs[i].FmtpParameters = f.Parameters
+						}
+					}
+				}
+			case "ssrc":
+				if media.Ssrc == 0 {
+					items := strings.SplitN(value, " ", 2)
+					if v, e := strconv.ParseUint(items[0], 10, 32); e == nil {
+						media.Ssrc = uint32(v)
+					}
+				}
+			}
+		}
+	}
+
+	for i := range sd.Medias {
+		m := &sd.Medias[i]
+		if sd.IceUfrag == "" {
+			sd.IceUfrag = m.IceUfrag
+		}
+		if sd.IcePwd == "" {
+			sd.IcePwd = m.IcePwd
+		}
+		if fingerprint == "" {
+			fingerprint = m.Fingerprint
+		}
+	}
+	sd.Fingerprint = fingerprint
+
+	if len(sd.Medias) == 0 || sd.IceUfrag == "" || sd.IcePwd == "" {
+		return sd, fmt.Errorf("%w. ice-ufrag, ice-pwd or m line not exist", base.ErrWebrtcInvalidSdp)
+	}
+	if sd.Fingerprint == "" {
+		return sd, fmt.Errorf("%w. sha-256 fingerprint not exist", base.ErrWebrtcInvalidSdp)
+	}
+	return sd, nil
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// negotiatedMedia 协商后接受的一个m
+type negotiatedMedia struct {
+	offer  *sdpMedia
+	codecs []sdpCodec // 接受的编码，pub时只有一个
+	ssrc   uint32     // 本端发送时使用的ssrc，只有sub使用
+}
+
+// negotiatePub WHIP场景，对端发送，本端接收
+//
+// 视频优先选择H264(packetization-mode=1)，其次H265，音频选择opus或PCMA，每种媒体只接受一个编码
+func negotiatePub(offer *sessionDescription) (audio, video *negotiatedMedia) {
+	for i := range offer.Medias {
+		m := &offer.Medias[i]
+		if m.Port == 0 || (m.Direction != sdpDirectionSendOnly && m.Direction != sdpDirectionSendRecv) {
+			continue
+		}
+		switch m.Kind {
+		case "video":
+			if video != nil {
+				continue
+			}
+			codecs := selectVideoCodecs(m.Codecs)
+			if len(codecs) != 0 {
+				video = &negotiatedMedia{offer: m, codecs: codecs[:1]}
+			}
+		case "audio":
+			if audio != nil {
+				continue
+			}
+			codecs := selectAudioCodecs(m.Codecs)
+			if len(codecs) != 0 {
+				audio = &negotiatedMedia{offer: m, codecs: codecs[:1]}
+			}
+		}
+	}
+	return
+}
+
+// negotiateSub WHEP场景，本端发送，对端接收
+//
+// 接受对端支持的所有可用编码，在拿到流的实际编码后再决定使用哪个pt，见 SubSession.FeedSdp
+func negotiateSub(offer *sessionDescription) (audio, video *negotiatedMedia) {
+	for i := range offer.Medias {
+		m := &offer.Medias[i]
+		if m.Port == 0 || (m.Direction != sdpDirectionRecvOnly && m.Direction != sdpDirectionSendRecv) {
+			continue
+		}
+		switch m.Kind {
+		case "video":
+			if video != nil {
+				continue
+			}
+			codecs := selectVideoCodecs(m.Codecs)
+			if len(codecs) != 0 {
+				video = &negotiatedMedia{offer: m, codecs: codecs}
+			}
+		case "audio":
+			if audio != nil {
+				continue
+			}
+			codecs := selectAudioCodecs(m.Codecs)
+			if len(codecs) != 0 {
+				audio = &negotiatedMedia{offer: m, codecs: codecs}
+			}
+		}
+	}
+	return
+}
+
+// selectVideoCodecs 按H264、H265的顺序返回，每种编码只取一个
+func selectVideoCodecs(codecs []sdpCodec) []sdpCodec {
+	var ret []sdpCodec
+	for _, name := range []string{sdp.ARtpMapEncodingNameH264, sdp.ARtpMapEncodingNameH265} {
+		for _, c := range codecs {
+			if !strings.EqualFold(c.EncodingName, name) || c.ClockRate != 90000 {
+				continue
+			}
+			// H264只支持FU-A分片的方式
+			if name == sdp.ARtpMapEncodingNameH264 && c.FmtpParameters["packetization-mode"] != "1" {
+				continue
+			}
+			ret = append(ret, c)
+			break
+		}
+	}
+	return ret
+}
+
+// selectAudioCodecs 按opus、PCMA的顺序返回，每种编码只取一个
+func selectAudioCodecs(codecs []sdpCodec) []sdpCodec {
+	var ret []sdpCodec
+	for _, name := range []string{codecNameOpus, sdp.ARtpMapEncodingNameG711A} {
+		for _, c := range codecs {
+			if strings.EqualFold(c.EncodingName, name) {
+				ret = append(ret, c)
+				break
+			}
+		}
+	}
+	return ret
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+type answerParam struct {
+	IceUfrag    string
+	IcePwd      string
+	Fingerprint string
+	Candidates  []string // ip:port
+	IsPub       bool
+}
+
+// packAnswer
+//
+// 没有被接受的m，端口设置为0，并且按rfc3264的要求保持m的数量和顺序与offer一致
+func packAnswer(offer *sessionDescription, audio, video *negotiatedMedia, param answerParam) []byte {
+	var mids []string
+	for _, nm := range []*negotiatedMedia{audio, video} {
+		if nm != nil {
+			mids = append(mids, nm.offer.Mid)
+		}
+	}
+
+	var sb strings.Builder
+	sb.WriteString("v=0\r\n")
+	sb.WriteString("o=- 0 0 IN IP4 127.0.0.1\r\n")
+	sb.WriteString("s=-\r\n")
+	sb.WriteString("t=0 0\r\n")
+	sb.WriteString(fmt.Sprintf("a=tool:%s\r\n", base.LalPackSdp))
+	sb.WriteString("a=ice-lite\r\n")
+	if len(mids) != 0 {
+		sb.WriteString(fmt.Sprintf("a=group:BUNDLE %s\r\n", strings.Join(mids, " ")))
+	}
+	sb.WriteString("a=msid-semantic: WMS lal\r\n")
+
+	for i := range offer.Medias {
+		m := &offer.Medias[i]
+
+		var nm *negotiatedMedia
+		if audio != nil && audio.offer == m {
+			nm = audio
+		} else if video != nil && video.offer == m {
+			nm = video
+		}
+
+		if nm == nil {
+			pt := "0"
+			if len(m.Codecs) != 0 {
+				pt = strconv.Itoa(m.Codecs[0].PayloadType)
+			}
+			sb.WriteString(fmt.Sprintf("m=%s 0 %s %s\r\n", m.Kind, m.Protocol, pt))
+			sb.WriteString("c=IN IP4 0.0.0.0\r\n")
+			if m.Mid != "" {
+				sb.WriteString(fmt.Sprintf("a=mid:%s\r\n", m.Mid))
+			}
+			sb.WriteString("a=inactive\r\n")
+			continue
+		}
+
+		pts := make([]string, len(nm.codecs))
+		for j, c := range nm.codecs {
+			pts[j] = strconv.Itoa(c.PayloadType)
+		}
+		sb.WriteString(fmt.Sprintf("m=%s 9 UDP/TLS/RTP/SAVPF %s\r\n", m.Kind, strings.Join(pts, " ")))
+		sb.WriteString("c=IN IP4 0.0.0.0\r\n")
+		sb.WriteString(fmt.Sprintf("a=mid:%s\r\n", m.Mid))
+		sb.WriteString(fmt.Sprintf("a=ice-ufrag:%s\r\n", param.IceUfrag))
+		sb.WriteString(fmt.Sprintf("a=ice-pwd:%s\r\n", param.IcePwd))
+		sb.WriteString(fmt.Sprintf("a=fingerprint:sha-256 %s\r\n", param.Fingerprint))
+		sb.WriteString("a=setup:passive\r\n")
+		if param.IsPub {
+			sb.WriteString("a=recvonly\r\n")
+		} else {
+			sb.WriteString("a=sendonly\r\n")
+		}
+		sb.WriteString("a=rtcp-mux\r\n")
+		sb.WriteString("a=rtcp-rsize\r\n")
+		for _, c := range nm.codecs {
+			if c.EncodingParameters != "" {
+				sb.WriteString(fmt.Sprintf("a=rtpmap:%d %s/%d/%s\r\n", c.PayloadType, c.EncodingName, c.ClockRate, c.EncodingParameters))
+			} else {
+				sb.WriteString(fmt.Sprintf("a=rtpmap:%d %s/%d\r\n", c.PayloadType, c.EncodingName, c.ClockRate))
+			}
+			if m.Kind == "video" {
+				sb.WriteString(fmt.Sprintf("a=rtcp-fb:%d nack\r\n", c.PayloadType))
+				sb.WriteString(fmt.Sprintf("a=rtcp-fb:%d nack pli\r\n", c.PayloadType))
+			}
+			if c.Fmtp != "" {
+				sb.WriteString(fmt.Sprintf("a=fmtp:%d %s\r\n", c.PayloadType, c.Fmtp))
+			}
+		}
+		if !param.IsPub {
+			sb.WriteString(fmt.Sprintf("a=msid:lal lal-%s\r\n", m.Kind))
+			sb.WriteString(fmt.Sprintf("a=ssrc:%d cname:lal\r\n", nm.ssrc))
+			sb.WriteString(fmt.Sprintf("a=ssrc:%d msid:lal lal-%s\r\n", nm.ssrc, m.Kind))
+		}
+		for j, c := range param.Candidates {
+			host, port, err := splitHostPort(c)
+			if err != nil {
+				continue
+			}
+			sb.WriteString(fmt.Sprintf("a=candidate:%d 1 udp 2130706431 %s %d typ host\r\n", j+1, host, port))
+		}
+		sb.WriteString("a=end-of-candidates\r\n")
+	}
+	return []byte(sb.String())
+}
+
+// packLogicSdp 生成rtsp风格的sdp，用于创建 sdp.LogicContext，供 rtsp.BaseInSession 和group中的其他协议使用
+//
+// 视频固定使用streamid=0，音频固定使用streamid=1
+func packLogicSdp(audio, video *negotiatedMedia) []byte {
+	var sb strings.Builder
+	sb.WriteString("v=0\r\n")
+	sb.WriteString("o=- 0 0 IN IP4 127.0.0.1\r\n")
+	sb.WriteString("s=No Name\r\n")
+	sb.WriteString("c=IN IP4 127.0.0.1\r\n")
+	sb.WriteString("t=0 0\r\n")
+	sb.WriteString(fmt.Sprintf("a=tool:%s\r\n", base.LalPackSdp))
+
+	write := func(kind string, c sdpCodec, streamid int) {
+		sb.WriteString(fmt.Sprintf("m=%s 0 RTP/AVP %d\r\n", kind, c.PayloadType))
+		if c.EncodingParameters != "" {
+			sb.WriteString(fmt.Sprintf("a=rtpmap:%d %s/%d/%s\r\n", c.PayloadType, c.EncodingName, c.ClockRate, c.EncodingParameters))
+		} else {
+			sb.WriteString(fmt.Sprintf("a=rtpmap:%d %s/%d\r\n", c.PayloadType, c.EncodingName, c.ClockRate))
+		}
+		if c.FmtpParameters != nil {
+			sb.WriteString(fmt.Sprintf("a=fmtp:%d %s\r\n", c.PayloadType, c.Fmtp))
+		}
+		sb.WriteString(fmt.Sprintf("a=control:streamid=%d\r\n", streamid))
+	}
+	if video != nil {
+		write("video", video.codecs[0], 0)
+	}
+	if audio != nil {
+		write("audio", audio.codecs[0], 1)
+	}
+	return []byte(sb.String())
+}
+
+func splitHostPort(addr string) (host string, port int, err error) {
+	var p string
+	if host, p, err = net.SplitHostPort(addr); err != nil {
+		return
+	}
+	port, err = strconv.Atoi(p)
+	return
+}
@@ -0,0 +1,377 @@
+// Copyright 2023, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package webrtc
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+)
+
+type IServerObserver interface {
+	// OnNewWebrtcPubSession
+	//
+	// 上层代码应该在这个事件回调中调用 PubSession.SetObserver 注册音视频数据的监听
+	//
+	// @return 上层如果想拒绝这个连接，则回调中返回不为nil的error值，此时不会再触发 OnDelWebrtcPubSession
+	//
+	OnNewWebrtcPubSession(session *PubSession) error
+	OnDelWebrtcPubSession(session *PubSession)
+
+	OnNewWebrtcSubSession(session *SubSession) error
+	OnDelWebrtcSubSession(session *SubSession)
+}
+
+type ServerConfig struct {
+	CandidateIps      []string `json:"candidate_ips"`        // answer中candidate使用的ip，为空时使用本机所有非回环地址
+	PeerIdleTimeoutMs int      `json:"peer_idle_timeout_ms"` // 超过这个时间没有收到对端的任何数据，则关闭连接
+	PliIntervalMs     int      `json:"pli_interval_ms"`      // 推流时，定时向对端请求关键帧的间隔
+}
+
+// Server 所有WebRTC会话共用一个UDP端口
+//
+// HTTP信令部分通过 WhipHandler 和 WhepHandler 注册到上层的HTTP服务中
+type Server struct {
+	addr     string
+	observer IServerObserver
+	config   ServerConfig
+
+	pconn      *net.UDPConn
+	cert       *Certificate
+	candidates []string
+
+	mutex    sync.Mutex
+	ufrags   map[string]*conn           // key为本端ice-ufrag，用于STUN
+	addrs    map[string]*conn           // key为ICE选中的对端地址，用于DTLS、SRTP
+	sessions map[string]sessionDisposer // key为session的UniqueKey，用于HTTP DELETE
+}
+
+type sessionDisposer interface {
+	Dispose() error
+}
+
+const (
+	maxSdpSize = 64 * 1024
+
+	iceUfragLength = 8
+	icePwdLength   = 24
+)
+
+func NewServer(addr string, observer IServerObserver, config ServerConfig) *Server {
+	if config.PeerIdleTimeoutMs <= 0 {
+		config.PeerIdleTimeoutMs = DefaultPeerIdleTimeoutMs
+	}
+	if config.PliIntervalMs <= 0 {
+		config.PliIntervalMs = DefaultPliIntervalMs
+	}
+	return &Server{
+		addr:     addr,
+		observer: observer,
+		config:   config,
+		ufrags:   make(map[string]*conn),
+		addrs:    make(map[string]*conn),
+		sessions: make(map[string]sessionDisposer),
+	}
+}
+
+func (server *Server) Listen() (err error) {
+	if server.cert, err = NewCertificate(); err != nil {
+		return
+	}
+	laddr, err := net.ResolveUDPAddr("udp", server.addr)
+	if err != nil {
+		return
+	}
+	if server.pconn, err = net.ListenUDP("udp", laddr); err != nil {
+		return
+	}
+
+	port := server.pconn.LocalAddr().(*net.UDPAddr).Port
+	for _, ip := range server.candidateIps(laddr) {
+		server.candidates = append(server.candidates, net.JoinHostPort(ip, strconv.Itoa(port)))
+	}
+	Log.Infof("start webrtc server listen. addr=%s, candidates=%+v", server.addr, server.candidates)
+	return
+}
+
+func (server *Server) RunLoop() error {
+	buf := make([]byte, 65536)
+	for {
+		n, raddr, err := server.pconn.ReadFromUDP(buf)
+		if err != nil {
+			return err
+		}
+		b := buf[:n]
+
+		var (
+			c  *conn
+			ok bool
+		)
+		if isStunPacket(b) {
+			msg, err := parseStunMessage(b)
+			if err != nil {
+				continue
+			}
+			localUfrag := strings.SplitN(msg.Username(), ":", 2)[0]
+			server.mutex.Lock()
+			c, ok = server.ufrags[localUfrag]
+			server.mutex.Unlock()
+		} else {
+			server.mutex.Lock()
+			c, ok = server.addrs[raddr.String()]
+			server.mutex.Unlock()
+		}
+		if !ok {
+			continue
+		}
+		// 连接的协程异步处理，所以需要拷贝
+		c.feed(append([]byte(nil), b...), raddr)
+	}
+}
+
+func (server *Server) Dispose() {
+	if server.pconn == nil {
+		return
+	}
+	if err := server.pconn.Close(); err != nil {
+		Log.Error(err)
+	}
+
+	server.mutex.Lock()
+	sessions := make([]sessionDisposer, 0, len(server.sessions))
+	for _, s := range server.sessions {
+		sessions = append(sessions, s)
+	}
+	server.mutex.Unlock()
+
+	for _, s := range sessions {
+		_ = s.Dispose()
+	}
+}
+
+// WhipHandler 推流的HTTP信令
+//
+// @param urlPattern: 注册到HTTP服务时使用的pattern，请求路径中去掉该前缀后的部分作为`appName/streamName`
+func (server *Server) WhipHandler(urlPattern string) base.Handler {
+	return func(w http.ResponseWriter, r *http.Request) {
+		server.serveHttp(w, r, urlPattern, true)
+	}
+}
+
+// WhepHandler 拉流的HTTP信令，参数含义见 WhipHandler
+func (server *Server) WhepHandler(urlPattern string) base.Handler {
+	return func(w http.ResponseWriter, r *http.Request) {
+		server.serveHttp(w, r, urlPattern, false)
+	}
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func (server *Server) serveHttp(w http.ResponseWriter, r *http.Request, urlPattern string, isPub bool) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	switch r.Method {
+	case http.MethodOptions:
+		w.Header().Set("Access-Control-Allow-Methods", "POST, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Expose-Headers", "Location")
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodPost:
+		server.handleOffer(w, r, urlPattern, isPub)
+	case http.MethodDelete:
+		server.mutex.Lock()
+		s, ok := server.sessions[r.URL.Query().Get("session_id")]
+		server.mutex.Unlock()
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_ = s.Dispose()
+		w.WriteHeader(http.StatusOK)
+	default:
+		// 不支持Trickle ICE和ICE restart，所以PATCH也返回405
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (server *Server) handleOffer(w http.ResponseWriter, r *http.Request, urlPattern string, isPub bool) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxSdpSize))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	offer, err := parseOffer(body)
+	if err != nil {
+		Log.Warnf("parse webrtc offer failed. remote addr=%s, err=%+v", r.RemoteAddr, err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	streamPath := strings.Trim(strings.TrimPrefix(r.URL.Path, urlPattern), "/")
+	if streamPath == "" {
+		Log.Warnf("invalid webrtc stream path. path=%s", r.URL.Path)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	rawUrl := fmt.Sprintf("webrtc://%s/%s", r.Host, streamPath)
+	if r.URL.RawQuery != "" {
+		rawUrl += "?" + r.URL.RawQuery
+	}
+	urlCtx, err := base.ParseUrl(rawUrl, -1)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var audio, video *negotiatedMedia
+	if isPub {
+		audio, video = negotiatePub(&offer)
+	} else {
+		audio, video = negotiateSub(&offer)
+	}
+	if audio == nil && video == nil {
+		Log.Warnf("no codec negotiated. remote addr=%s, offer=%s", r.RemoteAddr, string(body))
+		w.WriteHeader(http.StatusNotAcceptable)
+		return
+	}
+
+	option := connOption{
+		PConn:             server.pconn,
+		Cert:              server.cert,
+		LocalUfrag:        genIceString(iceUfragLength),
+		LocalPwd:          genIceString(icePwdLength),
+		RemoteUfrag:       offer.IceUfrag,
+		RemoteFingerprint: offer.Fingerprint,
+		IdleTimeout:       time.Duration(server.config.PeerIdleTimeoutMs) * time.Millisecond,
+	}
+
+	var (
+		c        *conn
+		session  sessionDisposer
+		uk       string
+		runLoop  func()
+		observed error
+	)
+	if isPub {
+		s, err := NewPubSession(urlCtx, option, audio, video, time.Duration(server.config.PliIntervalMs)*time.Millisecond)
+		if err != nil {
+			Log.Warnf("new webrtc pub session failed. err=%+v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		c, session, uk = s.conn, s, s.UniqueKey()
+		observed = server.observer.OnNewWebrtcPubSession(s)
+		runLoop = func() {
+			_ = s.RunLoop()
+			server.observer.OnDelWebrtcPubSession(s)
+		}
+	} else {
+		s := NewSubSession(urlCtx, option, audio, video)
+		c, session, uk = s.conn, s, s.UniqueKey()
+		observed = server.observer.OnNewWebrtcSubSession(s)
+		runLoop = func() {
+			_ = s.RunLoop()
+			server.observer.OnDelWebrtcSubSession(s)
+		}
+	}
+	if observed != nil {
+		Log.Warnf("[%s] webrtc session rejected by observer. err=%+v", uk, observed)
+		_ = session.Dispose()
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	answer := packAnswer(&offer, audio, video, answerParam{
+		IceUfrag:    option.LocalUfrag,
+		IcePwd:      option.LocalPwd,
+		Fingerprint: server.cert.Fingerprint,
+		Candidates:  server.candidates,
+		IsPub:       isPub,
+	})
+
+	c.onAddr = server.onAddr
+	c.onDelete = func(c *conn) {
+		server.mutex.Lock()
+		delete(server.ufrags, c.localUfrag)
+		delete(server.sessions, uk)
+		for k, v := range server.addrs {
+			if v == c {
+				delete(server.addrs, k)
+			}
+		}
+		server.mutex.Unlock()
+	}
+	server.mutex.Lock()
+	server.ufrags[option.LocalUfrag] = c
+	server.sessions[uk] = session
+	server.mutex.Unlock()
+
+	go runLoop()
+
+	Log.Infof("[%s] webrtc offer/answer succ. remote addr=%s, url=%s", uk, r.RemoteAddr, urlCtx.Url)
+	w.Header().Set("Content-Type", "application/sdp")
+	w.Header().Set("Location", fmt.Sprintf("%s?session_id=%s", r.URL.Path, uk))
+	w.WriteHeader(http.StatusCreated)
+	_, _ = w.Write(answer)
+}
+
+// onAddr 对端地址确定或改变时，更新地址到连接的映射
+func (server *Server) onAddr(c *conn, raddr string) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	for k, v := range server.addrs {
+		if v == c {
+			delete(server.addrs, k)
+		}
+	}
+	server.addrs[raddr] = c
+}
+
+func (server *Server) candidateIps(laddr *net.UDPAddr) []string {
+	if len(server.config.CandidateIps) != 0 {
+		return server.config.CandidateIps
+	}
+	if laddr.IP != nil && !laddr.IP.IsUnspecified() {
+		return []string{laddr.IP.String()}
+	}
+
+	var ret []string
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		Log.Warnf("get interface addrs failed. err=%+v", err)
+		return nil
+	}
+	for _, a := range addrs {
+		ipnet, ok := a.(*net.IPNet)
+		if !ok || ipnet.IP.IsLoopback() || ipnet.IP.To4() == nil {
+			continue
+		}
+		ret = append(ret, ipnet.IP.String())
+	}
+	return ret
+}
+
+// genIceString rfc8839 5.4 ice-char为ALPHA / DIGIT / "+" / "/"，这里只使用字母和数字
+func genIceString(n int) string {
+	const chars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	for i := range b {
+		b[i] = chars[int(b[i])%len(chars)]
+	}
+	return string(b)
+}
@@ -0,0 +1,330 @@
+// Copyright 2023, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package webrtc
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha1"
+	"hash"
+	"sync"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/rtprtcp"
+	"github.com/q191201771/naza/pkg/bele"
+)
+
+// rfc3711，只实现了 SRTP_AES128_CM_HMAC_SHA1_80
+
+const (
+	srtpMasterKeyLength  = 16
+	srtpMasterSaltLength = 14
+	srtpAuthKeyLength    = 20
+	srtpAuthTagLength    = 10
+	srtcpIndexLength     = 4
+
+	srtpLabelEncryption  = 0
+	srtpLabelAuth        = 1
+	srtpLabelSalt        = 2
+	srtcpLabelEncryption = 3
+	srtcpLabelAuth       = 4
+	srtcpLabelSalt       = 5
+)
+
+// srtpContext 一个方向的SRTP和SRTCP加解密上下文
+//
+// 本端发送和接收分别使用不同的上下文
+type srtpContext struct {
+	mu sync.Mutex
+
+	srtpBlock   cipher.Block
+	srtpSalt    []byte
+	srtpAuth    hash.Hash
+	srtcpBlock  cipher.Block
+	srtcpSalt   []byte
+	srtcpAuth   hash.Hash
+	srtcpIndex  uint32 // 发送SRTCP时使用
+	ssrcStates  map[uint32]*srtpSsrcState
+	scratchAuth []byte
+}
+
+type srtpSsrcState struct {
+	inited bool
+	roc    uint32
+	seq    uint16 // 收到或发送过的最大序号
+}
+
+func newSrtpContext(masterKey, masterSalt []byte) (*srtpContext, error) {
+	if len(masterKey) != srtpMasterKeyLength || len(masterSalt) != srtpMasterSaltLength {
+		return nil, base.ErrWebrtc
+	}
+
+	derive := func(label byte, n int) ([]byte, error) {
+		return srtpDeriveKey(masterKey, masterSalt, label, n)
+	}
+
+	c := &srtpContext{
+		ssrcStates: make(map[uint32]*srtpSsrcState),
+	}
+	var (
+		key, authKey []byte
+		err          error
+	)
+	if key, err = derive(srtpLabelEncryption, srtpMasterKeyLength); err != nil {
+		return nil, err
+	}
+	if c.srtpBlock, err = aes.NewCipher(key); err != nil {
+		return nil, err
+	}
+	if authKey, err = derive(srtpLabelAuth, srtpAuthKeyLength); err != nil {
+		return nil, err
+	}
+	c.srtpAuth = hmac.New(sha1.New, authKey)
+	if c.srtpSalt, err = derive(srtpLabelSalt, srtpMasterSaltLength); err != nil {
+		return nil, err
+	}
+
+	if key, err = derive(srtcpLabelEncryption, srtpMasterKeyLength); err != nil {
+		return nil, err
+	}
+	if c.srtcpBlock, err = aes.NewCipher(key); err != nil {
+		return nil, err
+	}
+	if authKey, err = derive(srtcpLabelAuth, srtpAuthKeyLength); err != nil {
+		return nil, err
+	}
+	c.srtcpAuth = hmac.New(sha1.New, authKey)
+	if c.srtcpSalt, err = derive(srtcpLabelSalt, srtpMasterSaltLength); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// EncryptRtp 返回新的内存块，不修改输入
+func (c *srtpContext) EncryptRtp(b []byte) ([]byte, error) {
+	h, err := rtprtcp.ParseRtpHeader(b)
+	if err != nil {
+		return nil, err
+	}
+	headerLength := rtpHeaderLength(b)
+	if headerLength == 0 {
+		return nil, base.ErrWebrtc
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s := c.getSsrcState(h.Ssrc)
+	if !s.inited {
+		s.inited = true
+		s.seq = h.Seq
+	} else if h.Seq < s.seq && s.seq-h.Seq > 0x8000 {
+		s.roc++
+		s.seq = h.Seq
+	} else if h.Seq > s.seq && h.Seq-s.seq < 0x8000 {
+		s.seq = h.Seq
+	}
+	roc := s.roc
+	// 序号回绕前的乱序包
+	if h.Seq > s.seq && h.Seq-s.seq >= 0x8000 && roc > 0 {
+		roc--
+	}
+
+	out := make([]byte, len(b), len(b)+srtpAuthTagLength)
+	copy(out, b[:headerLength])
+	c.xorKeyStream(c.srtpBlock, c.srtpSalt, h.Ssrc, uint64(roc)<<16|uint64(h.Seq), 8, out[headerLength:], b[headerLength:])
+	return append(out, c.rtpAuthTag(out, roc)...), nil
+}
+
+// DecryptRtp 返回新的内存块，不修改输入
+func (c *srtpContext) DecryptRtp(b []byte) ([]byte, error) {
+	if len(b) < rtprtcp.RtpFixedHeaderLength+srtpAuthTagLength {
+		return nil, base.ErrWebrtc
+	}
+	body := b[:len(b)-srtpAuthTagLength]
+	h, err := rtprtcp.ParseRtpHeader(body)
+	if err != nil {
+		return nil, err
+	}
+	headerLength := rtpHeaderLength(body)
+	if headerLength == 0 {
+		return nil, base.ErrWebrtc
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// rfc3711 3.3.1 估算roc
+	s := c.getSsrcState(h.Ssrc)
+	var v uint32
+	if !s.inited {
+		v = 0
+	} else if s.seq < 0x8000 {
+		if h.Seq > s.seq && h.Seq-s.seq > 0x8000 {
+			v = s.roc - 1
+		} else {
+			v = s.roc
+		}
+	} else {
+		if s.seq-0x8000 > h.Seq {
+			v = s.roc + 1
+		} else {
+			v = s.roc
+		}
+	}
+
+	if !hmac.Equal(c.rtpAuthTag(body, v), b[len(body):]) {
+		return nil, base.ErrWebrtcSrtpAuthFailed
+	}
+
+	// 校验通过后再更新状态
+	if !s.inited {
+		s.inited = true
+		s.roc = v
+		s.seq = h.Seq
+	} else if v == s.roc+1 {
+		s.roc = v
+		s.seq = h.Seq
+	} else if v == s.roc && h.Seq > s.seq {
+		s.seq = h.Seq
+	}
+
+	out := make([]byte, len(body))
+	copy(out, body[:headerLength])
+	c.xorKeyStream(c.srtpBlock, c.srtpSalt, h.Ssrc, uint64(v)<<16|uint64(h.Seq), 8, out[headerLength:], body[headerLength:])
+	return out, nil
+}
+
+// EncryptRtcp 返回新的内存块，不修改输入
+func (c *srtpContext) EncryptRtcp(b []byte) ([]byte, error) {
+	if len(b) < 8 {
+		return nil, base.ErrWebrtc
+	}
+	ssrc := bele.BeUint32(b[4:])
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.srtcpIndex = (c.srtcpIndex + 1) & 0x7FFFFFFF
+	index := c.srtcpIndex
+
+	out := make([]byte, len(b), len(b)+srtcpIndexLength+srtpAuthTagLength)
+	copy(out, b[:8])
+	c.xorKeyStream(c.srtcpBlock, c.srtcpSalt, ssrc, uint64(index), 10, out[8:], b[8:])
+	var e [4]byte
+	bele.BePutUint32(e[:], index|0x80000000)
+	out = append(out, e[:]...)
+	return append(out, c.rtcpAuthTag(out)...), nil
+}
+
+// DecryptRtcp 返回新的内存块，不修改输入
+func (c *srtpContext) DecryptRtcp(b []byte) ([]byte, error) {
+	if len(b) < 8+srtcpIndexLength+srtpAuthTagLength {
+		return nil, base.ErrWebrtc
+	}
+	authed := b[:len(b)-srtpAuthTagLength]
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !hmac.Equal(c.rtcpAuthTag(authed), b[len(authed):]) {
+		return nil, base.ErrWebrtcSrtpAuthFailed
+	}
+
+	e := bele.BeUint32(authed[len(authed)-srtcpIndexLength:])
+	body := authed[:len(authed)-srtcpIndexLength]
+	out := make([]byte, len(body))
+	copy(out, body)
+	if e&0x80000000 != 0 {
+		ssrc := bele.BeUint32(body[4:])
+		c.xorKeyStream(c.srtcpBlock, c.srtcpSalt, ssrc, uint64(e&0x7FFFFFFF), 10, out[8:], body[8:])
+	}
+	return out, nil
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func (c *srtpContext) getSsrcState(ssrc uint32) *srtpSsrcState {
+	s, ok := c.ssrcStates[ssrc]
+	if !ok {
+		s = &srtpSsrcState{}
+		c.ssrcStates[ssrc] = s
+	}
+	return s
+}
+
+// xorKeyStream AES-CM加解密
+//
+// IV = (salt * 2^16) XOR (SSRC * 2^64) XOR (index * 2^16)
+//
+// @param indexPos: index写入IV的起始位置，SRTP的index为48位，SRTCP的index为32位
+func (c *srtpContext) xorKeyStream(block cipher.Block, salt []byte, ssrc uint32, index uint64, indexPos int, dst, src []byte) {
+	var iv [16]byte
+	copy(iv[:], salt)
+	var tmp [8]byte
+	bele.BePutUint32(tmp[:], ssrc)
+	for i := 0; i < 4; i++ {
+		iv[4+i] ^= tmp[i]
+	}
+	bele.BePutUint64(tmp[:], index)
+	for i := indexPos; i < 14; i++ {
+		iv[i] ^= tmp[8-(14-i)]
+	}
+	cipher.NewCTR(block, iv[:]).XORKeyStream(dst, src)
+}
+
+func (c *srtpContext) rtpAuthTag(b []byte, roc uint32) []byte {
+	var r [4]byte
+	bele.BePutUint32(r[:], roc)
+	c.srtpAuth.Reset()
+	c.srtpAuth.Write(b)
+	c.srtpAuth.Write(r[:])
+	c.scratchAuth = c.srtpAuth.Sum(c.scratchAuth[:0])
+	return append([]byte(nil), c.scratchAuth[:srtpAuthTagLength]...)
+}
+
+func (c *srtpContext) rtcpAuthTag(b []byte) []byte {
+	c.srtcpAuth.Reset()
+	c.srtcpAuth.Write(b)
+	c.scratchAuth = c.srtcpAuth.Sum(c.scratchAuth[:0])
+	return append([]byte(nil), c.scratchAuth[:srtpAuthTagLength]...)
+}
+
+// rtpHeaderLength 包含csrc和extension的rtp头长度，不合法时返回0
+func rtpHeaderLength(b []byte) int {
+	if len(b) < rtprtcp.RtpFixedHeaderLength {
+		return 0
+	}
+	n := rtprtcp.RtpFixedHeaderLength + 4*int(b[0]&0xF)
+	if b[0]&0x10 != 0 {
+		if n+4 > len(b) {
+			return 0
+		}
+		n += 4 + 4*int(bele.BeUint16(b[n+2:]))
+	}
+	if n > len(b) {
+		return 0
+	}
+	return n
+}
+
+// srtpDeriveKey rfc3711 4.3，key_derivation_rate为0
+func srtpDeriveKey(masterKey, masterSalt []byte, label byte, n int) ([]byte, error) {
+	block, err := aes.NewCipher(masterKey)
+	if err != nil {
+		return nil, err
+	}
+	var iv [16]byte
+	copy(iv[:], masterSalt)
+	iv[7] ^= label
+	out := make([]byte, n)
+	cipher.NewCTR(block, iv[:]).XORKeyStream(out, out)
+	return out, nil
+}
@@ -0,0 +1,187 @@
+// Copyright 2023, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package webrtc
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"hash/crc32"
+	"net"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/naza/pkg/bele"
+)
+
+// rfc5389
+
+const (
+	stunHeaderLength = 20
+	stunMagicCookie  = 0x2112A442
+
+	stunTypeBindingRequest       = 0x0001
+	stunTypeBindingSuccess       = 0x0101
+	stunAttrUsername             = 0x0006
+	stunAttrMessageIntegrity     = 0x0008
+	stunAttrXorMappedAddress     = 0x0020
+	stunAttrUseCandidate         = 0x0025
+	stunAttrFingerprint          = 0x8028
+	stunFingerprintXor           = 0x5354554e
+	stunMessageIntegrityLength   = 20
+	stunAttrMessageIntegritySize = 4 + stunMessageIntegrityLength
+	stunAttrFingerprintSize      = 4 + 4
+)
+
+type stunAttr struct {
+	Type  uint16
+	Value []byte
+}
+
+type stunMessage struct {
+	Type          uint16
+	TransactionId [12]byte
+	Attrs         []stunAttr
+
+	raw             []byte
+	integrityOffset int // MESSAGE-INTEGRITY属性在raw中的位置，-1表示不存在
+}
+
+func parseStunMessage(b []byte) (msg stunMessage, err error) {
+	if len(b) < stunHeaderLength || b[0]&0xC0 != 0 || bele.BeUint32(b[4:]) != stunMagicCookie {
+		return msg, base.ErrWebrtcStun
+	}
+	length := int(bele.BeUint16(b[2:]))
+	if length%4 != 0 || stunHeaderLength+length > len(b) {
+		return msg, base.ErrWebrtcStun
+	}
+
+	msg.Type = bele.BeUint16(b)
+	copy(msg.TransactionId[:], b[8:20])
+	msg.raw = b[:stunHeaderLength+length]
+	msg.integrityOffset = -1
+
+	pos := stunHeaderLength
+	for pos+4 <= len(msg.raw) {
+		t := bele.BeUint16(msg.raw[pos:])
+		l := int(bele.BeUint16(msg.raw[pos+2:]))
+		if pos+4+l > len(msg.raw) {
+			return msg, base.ErrWebrtcStun
+		}
+		if t == stunAttrMessageIntegrity {
+			msg.integrityOffset = pos
+		}
+		msg.Attrs = append(msg.Attrs, stunAttr{Type: t, Value: msg.raw[pos+4 : pos+4+l]})
+		pos += 4 + (l+3)/4*4
+	}
+	return msg, nil
+}
+
+func (msg *stunMessage) GetAttr(t uint16) ([]byte, bool) {
+	for _, attr := range msg.Attrs {
+		if attr.Type == t {
+			return attr.Value, true
+		}
+	}
+	return nil, false
+}
+
+func (msg *stunMessage) Username() string {
+	v, _ := msg.GetAttr(stunAttrUsername)
+	return string(v)
+}
+
+func (msg *stunMessage) HasUseCandidate() bool {
+	_, ok := msg.GetAttr(stunAttrUseCandidate)
+	return ok
+}
+
+// CheckIntegrity 使用短期凭证校验MESSAGE-INTEGRITY，key为本端的ice-pwd
+func (msg *stunMessage) CheckIntegrity(key []byte) bool {
+	if msg.integrityOffset < 0 || msg.integrityOffset+stunAttrMessageIntegritySize > len(msg.raw) {
+		return false
+	}
+	// 计算时，头部中的长度只算到MESSAGE-INTEGRITY属性为止，不包含后面的FINGERPRINT
+	b := append([]byte(nil), msg.raw[:msg.integrityOffset]...)
+	bele.BePutUint16(b[2:], uint16(msg.integrityOffset-stunHeaderLength+stunAttrMessageIntegritySize))
+	expected := stunIntegrity(b, key)
+	return hmac.Equal(expected, msg.raw[msg.integrityOffset+4:msg.integrityOffset+stunAttrMessageIntegritySize])
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// packStunMessage
+//
+// @param key: 不为nil时，在末尾添加MESSAGE-INTEGRITY
+//
+// 末尾总是添加FINGERPRINT
+func packStunMessage(typ uint16, transactionId [12]byte, attrs []stunAttr, key []byte) []byte {
+	out := make([]byte, stunHeaderLength, 128)
+	bele.BePutUint16(out, typ)
+	bele.BePutUint32(out[4:], stunMagicCookie)
+	copy(out[8:], transactionId[:])
+
+	for _, attr := range attrs {
+		out = appendStunAttr(out, attr.Type, attr.Value)
+	}
+
+	if key != nil {
+		// 计算时，头部中的长度需要包含MESSAGE-INTEGRITY属性
+		bele.BePutUint16(out[2:], uint16(len(out)-stunHeaderLength+stunAttrMessageIntegritySize))
+		out = appendStunAttr(out, stunAttrMessageIntegrity, stunIntegrity(out, key))
+	}
+
+	bele.BePutUint16(out[2:], uint16(len(out)-stunHeaderLength+stunAttrFingerprintSize))
+	fp := make([]byte, 4)
+	bele.BePutUint32(fp, crc32.ChecksumIEEE(out)^stunFingerprintXor)
+	out = appendStunAttr(out, stunAttrFingerprint, fp)
+	return out
+}
+
+func packStunBindingSuccess(transactionId [12]byte, raddr *net.UDPAddr, key []byte) []byte {
+	return packStunMessage(stunTypeBindingSuccess, transactionId, []stunAttr{
+		{Type: stunAttrXorMappedAddress, Value: packXorMappedAddress(transactionId, raddr)},
+	}, key)
+}
+
+func packXorMappedAddress(transactionId [12]byte, addr *net.UDPAddr) []byte {
+	var xorKey [16]byte
+	bele.BePutUint32(xorKey[:], stunMagicCookie)
+	copy(xorKey[4:], transactionId[:])
+
+	ip := addr.IP.To4()
+	family := byte(1)
+	if ip == nil {
+		ip = addr.IP.To16()
+		family = 2
+	}
+	out := make([]byte, 4+len(ip))
+	out[1] = family
+	bele.BePutUint16(out[2:], uint16(addr.Port)^uint16(stunMagicCookie>>16))
+	for i := range ip {
+		out[4+i] = ip[i] ^ xorKey[i]
+	}
+	return out
+}
+
+func appendStunAttr(out []byte, typ uint16, value []byte) []byte {
+	var h [4]byte
+	bele.BePutUint16(h[:], typ)
+	bele.BePutUint16(h[2:], uint16(len(value)))
+	out = append(out, h[:]...)
+	out = append(out, value...)
+	for len(out)%4 != 0 {
+		out = append(out, 0)
+	}
+	return out
+}
+
+func stunIntegrity(b []byte, key []byte) []byte {
+	h := hmac.New(sha1.New, key)
+	h.Write(b)
+	return h.Sum(nil)
+}
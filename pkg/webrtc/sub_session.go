@@ -0,0 +1,226 @@
+// Copyright 2023, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package webrtc
+
+import (
+	"math/rand"
+	"strings"
+	"sync"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/rtprtcp"
+	"github.com/q191201771/lal/pkg/rtsp"
+	"github.com/q191201771/lal/pkg/sdp"
+	"github.com/q191201771/naza/pkg/bele"
+)
+
+// SubSession WHEP拉流
+//
+// 上层像使用rtsp拉流一样，通过 FeedSdp 和 WriteRtpPacket 喂入rtp数据，
+// 内部通过 rtsp.BaseOutSession 按sdp区分音视频，然后将pt和ssrc改写成与对端协商的值后加密发送
+type SubSession struct {
+	urlCtx         base.UrlContext
+	conn           *conn
+	baseOutSession *rtsp.BaseOutSession
+
+	audio *negotiatedMedia
+	video *negotiatedMedia
+
+	mu               sync.Mutex
+	audioPayloadType int // 根据流的实际编码选择的pt，没有时为-1
+	videoPayloadType int
+
+	ShouldWaitVideoKeyFrame bool
+}
+
+func NewSubSession(urlCtx base.UrlContext, option connOption, audio, video *negotiatedMedia) *SubSession {
+	s := &SubSession{
+		urlCtx:                  urlCtx,
+		audio:                   audio,
+		video:                   video,
+		audioPayloadType:        -1,
+		videoPayloadType:        -1,
+		ShouldWaitVideoKeyFrame: true,
+	}
+	if audio != nil {
+		audio.ssrc = rand.Uint32()
+	}
+	if video != nil {
+		video.ssrc = rand.Uint32()
+	}
+	s.baseOutSession = rtsp.NewBaseOutSession(base.SessionTypeWebrtcSub, s)
+
+	option.UniqueKey = s.UniqueKey()
+	s.conn = newConn(option)
+
+	Log.Infof("[%s] lifecycle new webrtc SubSession. session=%p, url=%s", s.UniqueKey(), s, urlCtx.Url)
+	return s
+}
+
+// FeedSdp 供上层调用，流的编码确定或者改变时调用
+//
+// 流的编码不在协商结果中时，对应的音频或视频不发送
+func (session *SubSession) FeedSdp(sdpCtx sdp.LogicContext) {
+	session.baseOutSession.InitWithSdp(sdpCtx)
+
+	audioPayloadType, videoPayloadType := -1, -1
+	if session.video != nil && sdpCtx.HasVideoAControl() {
+		var name string
+		switch sdpCtx.GetVideoPayloadTypeBase() {
+		case base.AvPacketPtAvc:
+			name = sdp.ARtpMapEncodingNameH264
+		case base.AvPacketPtHevc:
+			name = sdp.ARtpMapEncodingNameH265
+		}
+		if c, ok := findCodec(session.video.codecs, name); ok {
+			videoPayloadType = c.PayloadType
+			_ = session.baseOutSession.SetupWithChannel(sdpCtx.MakeVideoSetupUri(session.urlCtx.Url), videoRtpChannel, videoRtcpChannel)
+		}
+	}
+	if session.audio != nil && sdpCtx.HasAudioAControl() {
+		if c, ok := findCodec(session.audio.codecs, audioEncodingName(sdpCtx)); ok {
+			audioPayloadType = c.PayloadType
+			_ = session.baseOutSession.SetupWithChannel(sdpCtx.MakeAudioSetupUri(session.urlCtx.Url), audioRtpChannel, audioRtcpChannel)
+		}
+	}
+	if videoPayloadType == -1 && sdpCtx.HasVideoAControl() {
+		Log.Warnf("[%s] video codec not negotiated, ignore video. type=%s", session.UniqueKey(), sdpCtx.GetVideoPayloadTypeBase().ReadableString())
+	}
+	if audioPayloadType == -1 && sdpCtx.HasAudioAControl() {
+		Log.Warnf("[%s] audio codec not negotiated, ignore audio. type=%s", session.UniqueKey(), audioEncodingName(sdpCtx))
+	}
+
+	session.mu.Lock()
+	session.audioPayloadType = audioPayloadType
+	session.videoPayloadType = videoPayloadType
+	session.mu.Unlock()
+}
+
+// WriteRtpPacket 供上层调用
+//
+// DTLS握手完成之前的数据直接丢弃，并且继续等待视频关键帧
+func (session *SubSession) WriteRtpPacket(packet rtprtcp.RtpPacket) {
+	if !session.conn.IsEstablished() {
+		session.ShouldWaitVideoKeyFrame = true
+		return
+	}
+	_ = session.baseOutSession.WriteRtpPacket(packet)
+}
+
+// ----- IServerSessionLifecycle ---------------------------------------------------------------------------------------
+
+func (session *SubSession) RunLoop() error {
+	return session.conn.runLoop()
+}
+
+func (session *SubSession) Dispose() error {
+	Log.Infof("[%s] lifecycle dispose webrtc SubSession. session=%p", session.UniqueKey(), session)
+	session.conn.dispose(nil)
+	return session.baseOutSession.Dispose()
+}
+
+// ----- ISessionUrlContext --------------------------------------------------------------------------------------------
+
+func (session *SubSession) Url() string {
+	return session.urlCtx.Url
+}
+
+func (session *SubSession) AppName() string {
+	return session.urlCtx.PathWithoutLastItem
+}
+
+func (session *SubSession) StreamName() string {
+	return session.urlCtx.LastItemOfPath
+}
+
+func (session *SubSession) RawQuery() string {
+	return session.urlCtx.RawQuery
+}
+
+func (session *SubSession) Header() map[string][]string {
+	return nil
+}
+
+// ----- IObject -------------------------------------------------------------------------------------------------------
+
+func (session *SubSession) UniqueKey() string {
+	return session.baseOutSession.UniqueKey()
+}
+
+// ----- ISessionStat --------------------------------------------------------------------------------------------------
+
+func (session *SubSession) GetStat() base.StatSession {
+	stat := session.baseOutSession.GetStat()
+	stat.RemoteAddr = session.conn.RemoteAddr()
+	return stat
+}
+
+func (session *SubSession) UpdateStat(intervalSec uint32) {
+	session.baseOutSession.UpdateStat(intervalSec)
+}
+
+func (session *SubSession) IsAlive() (readAlive, writeAlive bool) {
+	return session.baseOutSession.IsAlive()
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// WriteInterleavedPacket rtsp.IInterleavedPacketWriter, callback by rtsp.BaseOutSession
+func (session *SubSession) WriteInterleavedPacket(packet []byte, channel int) error {
+	var (
+		pt   int
+		ssrc uint32
+	)
+	session.mu.Lock()
+	switch channel {
+	case videoRtpChannel:
+		pt, ssrc = session.videoPayloadType, session.video.ssrc
+	case audioRtpChannel:
+		pt, ssrc = session.audioPayloadType, session.audio.ssrc
+	default:
+		pt = -1
+	}
+	session.mu.Unlock()
+	if pt == -1 || len(packet) < rtprtcp.RtpFixedHeaderLength {
+		return nil
+	}
+
+	// packet可能被多个session共享，所以拷贝后再修改
+	b := make([]byte, len(packet))
+	copy(b, packet)
+	b[1] = b[1]&0x80 | byte(pt)
+	bele.BePutUint32(b[8:], ssrc)
+	return session.conn.WriteRtp(b)
+}
+
+func findCodec(codecs []sdpCodec, name string) (sdpCodec, bool) {
+	for _, c := range codecs {
+		if name != "" && strings.EqualFold(c.EncodingName, name) {
+			return c, true
+		}
+	}
+	return sdpCodec{}, false
+}
+
+// audioEncodingName sdp.LogicContext 只识别了部分音频编码，所以从原始sdp中获取
+func audioEncodingName(sdpCtx sdp.LogicContext) string {
+	if sdpCtx.GetAudioPayloadTypeBase() == base.AvPacketPtG711A {
+		return sdp.ARtpMapEncodingNameG711A
+	}
+	rawCtx, err := sdp.ParseSdp2RawContext(sdpCtx.RawSdp)
+	if err != nil {
+		return ""
+	}
+	for _, md := range rawCtx.MediaDescList {
+		if md.M.Media == "audio" {
+			return md.ARtpMap.EncodingName
+		}
+	}
+	return ""
+}
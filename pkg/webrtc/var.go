@@ -0,0 +1,19 @@
+// Copyright 2023, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package webrtc
+
+import "github.com/q191201771/naza/pkg/nazalog"
+
+var Log = nazalog.GetGlobalLogger()
+
+var (
+	// ServerConfig 中相应字段为0时使用的默认值
+	DefaultPeerIdleTimeoutMs = 10000
+	DefaultPliIntervalMs     = 3000
+)
@@ -0,0 +1,50 @@
+// Copyright 2023, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package webrtc
+
+// webrtc.go
+//
+// 实现WHIP(推流)和WHEP(拉流)两种基于HTTP信令的WebRTC会话，相关文档：
+//
+//   - WHIP: draft-ietf-wish-whip
+//   - WHEP: draft-murillo-whep
+//   - ICE:  rfc8445 rfc8839，服务端为ICE-lite模式，只响应对端的连通性检查
+//   - STUN: rfc5389
+//   - DTLS: rfc6347，只实现了服务端角色，以及TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256这一种加密套件
+//   - SRTP: rfc3711 rfc5764，只实现了SRTP_AES128_CM_HMAC_SHA1_80
+//
+// 所有会话共用一个UDP端口，根据STUN中的ufrag以及对端地址区分会话，音视频使用BUNDLE和rtcp-mux。
+//
+// 流程：
+//
+//   HTTP POST offer -> 生成answer(包含本端的ufrag、pwd、证书指纹、candidate) -> 201 Created
+//   -> 对端发送STUN Binding Request -> 对端作为DTLS客户端发起握手 -> 导出SRTP密钥 -> 收发SRTP/SRTCP
+//   HTTP DELETE Location -> 关闭会话
+//
+// TODO(chef):
+//   - 不支持data channel，不支持simulcast、rtx、fec
+//   - 不支持Trickle ICE(PATCH)，answer中已经包含了全部candidate
+
+// 根据第一个字节区分复用在同一个端口上的协议，见rfc7983
+func isStunPacket(b []byte) bool {
+	return len(b) > 0 && b[0] <= 3
+}
+
+func isDtlsPacket(b []byte) bool {
+	return len(b) > 0 && b[0] >= 20 && b[0] <= 63
+}
+
+func isRtpOrRtcpPacket(b []byte) bool {
+	return len(b) > 0 && b[0] >= 128 && b[0] <= 191
+}
+
+// isRtcpPacket rtcp-mux时区分rtp和rtcp，见rfc5761
+func isRtcpPacket(b []byte) bool {
+	return len(b) > 1 && b[1] >= 192 && b[1] <= 223
+}
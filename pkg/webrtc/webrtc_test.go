@@ -0,0 +1,193 @@
+// Copyright 2023, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package webrtc
+
+import (
+	"bytes"
+	"encoding/hex"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/rtprtcp"
+	"github.com/q191201771/lal/pkg/sdp"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func mustHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func TestSrtpDeriveKey(t *testing.T) {
+	// rfc3711 B.3
+	masterKey := mustHex("E1F97A0D3E018BE0D64FA32C06DE4139")
+	masterSalt := mustHex("0EC675AD498AFEEBB6960B3AABE6")
+
+	key, err := srtpDeriveKey(masterKey, masterSalt, srtpLabelEncryption, srtpMasterKeyLength)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, mustHex("C61E7A93744F39EE10734AFE3FF7A087"), key)
+
+	salt, err := srtpDeriveKey(masterKey, masterSalt, srtpLabelSalt, srtpMasterSaltLength)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, mustHex("30CBBC08863D8C85D49DB34A9AE1"), salt)
+
+	auth, err := srtpDeriveKey(masterKey, masterSalt, srtpLabelAuth, srtpAuthKeyLength)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, mustHex("CEBE321F6FF7716B6FD4AB49AF256A156D38BAA4"), auth)
+}
+
+func TestSrtp(t *testing.T) {
+	masterKey := mustHex("E1F97A0D3E018BE0D64FA32C06DE4139")
+	masterSalt := mustHex("0EC675AD498AFEEBB6960B3AABE6")
+	sender, err := newSrtpContext(masterKey, masterSalt)
+	assert.Equal(t, nil, err)
+	receiver, err := newSrtpContext(masterKey, masterSalt)
+	assert.Equal(t, nil, err)
+
+	rtp := []byte{0x80, 0x60, 0x00, 0x01, 0x00, 0x00, 0x00, 0x10, 0x11, 0x22, 0x33, 0x44, 'h', 'e', 'l', 'l', 'o'}
+	enc, err := sender.EncryptRtp(rtp)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, len(rtp)+srtpAuthTagLength, len(enc))
+	assert.Equal(t, rtp[:rtprtcp.RtpFixedHeaderLength], enc[:rtprtcp.RtpFixedHeaderLength])
+	assert.Equal(t, false, bytes.Equal(rtp[rtprtcp.RtpFixedHeaderLength:], enc[rtprtcp.RtpFixedHeaderLength:len(rtp)]))
+	dec, err := receiver.DecryptRtp(enc)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, rtp, dec)
+
+	// 篡改后校验失败
+	enc[len(enc)-1] ^= 0xFF
+	_, err = receiver.DecryptRtp(enc)
+	assert.IsNotNil(t, err)
+
+	rtcp := rtprtcp.PackPli(1, 2)
+	enc, err = sender.EncryptRtcp(rtcp)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, len(rtcp)+srtcpIndexLength+srtpAuthTagLength, len(enc))
+	dec, err = receiver.DecryptRtcp(enc)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, rtcp, dec)
+}
+
+func TestStun(t *testing.T) {
+	var tid [12]byte
+	copy(tid[:], "0123456789ab")
+	key := []byte("localpwd")
+	req := packStunMessage(stunTypeBindingRequest, tid, []stunAttr{
+		{Type: stunAttrUsername, Value: []byte("local:remote")},
+		{Type: stunAttrUseCandidate},
+	}, key)
+	assert.Equal(t, true, isStunPacket(req))
+
+	msg, err := parseStunMessage(req)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, uint16(stunTypeBindingRequest), msg.Type)
+	assert.Equal(t, tid, msg.TransactionId)
+	assert.Equal(t, "local:remote", msg.Username())
+	assert.Equal(t, true, msg.HasUseCandidate())
+	assert.Equal(t, true, msg.CheckIntegrity(key))
+	assert.Equal(t, false, msg.CheckIntegrity([]byte("otherpwd")))
+
+	raddr := &net.UDPAddr{IP: net.IPv4(192, 168, 1, 100), Port: 50000}
+	resp := packStunBindingSuccess(tid, raddr, key)
+	msg, err = parseStunMessage(resp)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, uint16(stunTypeBindingSuccess), msg.Type)
+	assert.Equal(t, true, msg.CheckIntegrity(key))
+	v, ok := msg.GetAttr(stunAttrXorMappedAddress)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, packXorMappedAddress(tid, raddr), v)
+
+	_, err = parseStunMessage([]byte{0, 1, 0, 0})
+	assert.Equal(t, base.ErrWebrtcStun, err)
+}
+
+var goldenOffer = "v=0\r\n" +
+	"o=- 4611731400430051336 2 IN IP4 127.0.0.1\r\n" +
+	"s=-\r\n" +
+	"t=0 0\r\n" +
+	"a=group:BUNDLE 0 1\r\n" +
+	"a=msid-semantic: WMS\r\n" +
+	"m=audio 9 UDP/TLS/RTP/SAVPF 111 8\r\n" +
+	"c=IN IP4 0.0.0.0\r\n" +
+	"a=ice-ufrag:abcd\r\n" +
+	"a=ice-pwd:0123456789abcdefghijkl\r\n" +
+	"a=fingerprint:sha-256 AA:BB:CC:DD:EE:FF:00:11:22:33:44:55:66:77:88:99:AA:BB:CC:DD:EE:FF:00:11:22:33:44:55:66:77:88:99\r\n" +
+	"a=setup:actpass\r\n" +
+	"a=mid:0\r\n" +
+	"a=sendonly\r\n" +
+	"a=rtcp-mux\r\n" +
+	"a=rtpmap:111 opus/48000/2\r\n" +
+	"a=fmtp:111 minptime=10;useinbandfec=1\r\n" +
+	"a=rtpmap:8 PCMA/8000\r\n" +
+	"a=ssrc:1111 cname:test\r\n" +
+	"m=video 9 UDP/TLS/RTP/SAVPF 96 102\r\n" +
+	"c=IN IP4 0.0.0.0\r\n" +
+	"a=ice-ufrag:abcd\r\n" +
+	"a=ice-pwd:0123456789abcdefghijkl\r\n" +
+	"a=fingerprint:sha-256 AA:BB:CC:DD:EE:FF:00:11:22:33:44:55:66:77:88:99:AA:BB:CC:DD:EE:FF:00:11:22:33:44:55:66:77:88:99\r\n" +
+	"a=setup:actpass\r\n" +
+	"a=mid:1\r\n" +
+	"a=sendonly\r\n" +
+	"a=rtcp-mux\r\n" +
+	"a=rtpmap:96 VP8/90000\r\n" +
+	"a=rtpmap:102 H264/90000\r\n" +
+	"a=fmtp:102 level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42e01f\r\n" +
+	"a=ssrc:2222 cname:test\r\n"
+
+func TestSdp(t *testing.T) {
+	offer, err := parseOffer([]byte(goldenOffer))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "abcd", offer.IceUfrag)
+	assert.Equal(t, "0123456789abcdefghijkl", offer.IcePwd)
+	assert.Equal(t, 2, len(offer.Medias))
+
+	audio, video := negotiatePub(&offer)
+	assert.IsNotNil(t, audio)
+	assert.IsNotNil(t, video)
+	assert.Equal(t, 1, len(audio.codecs))
+	assert.Equal(t, 111, audio.codecs[0].PayloadType)
+	assert.Equal(t, 1, len(video.codecs))
+	assert.Equal(t, 102, video.codecs[0].PayloadType)
+	assert.Equal(t, uint32(2222), video.offer.Ssrc)
+
+	// 推流的offer不能用于拉流
+	audio, video = negotiateSub(&offer)
+	assert.Equal(t, true, audio == nil && video == nil)
+
+	audio, video = negotiatePub(&offer)
+	answer := string(packAnswer(&offer, audio, video, answerParam{
+		IceUfrag:    "wxyz",
+		IcePwd:      "zyxwvutsrqponmlkjihgfedc",
+		Fingerprint: "sha-256 00:11",
+		Candidates:  []string{"127.0.0.1:8000"},
+		IsPub:       true,
+	}))
+	assert.Equal(t, true, strings.Contains(answer, "a=ice-lite\r\n"))
+	assert.Equal(t, true, strings.Contains(answer, "a=group:BUNDLE 0 1\r\n"))
+	assert.Equal(t, true, strings.Contains(answer, "a=setup:passive\r\n"))
+	assert.Equal(t, true, strings.Contains(answer, "a=recvonly\r\n"))
+	assert.Equal(t, true, strings.Contains(answer, "a=rtpmap:102 H264/90000\r\n"))
+	assert.Equal(t, false, strings.Contains(answer, "VP8"))
+	assert.Equal(t, true, strings.Contains(answer, "a=candidate:1 1 udp 2130706431 127.0.0.1 8000 typ host\r\n"))
+
+	// 转换成 rtsp 风格的sdp，交给 rtsp.BaseInSession 处理
+	logicCtx, err := sdp.ParseSdp2LogicContext(packLogicSdp(audio, video))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, logicCtx.IsVideoPayloadTypeOrigin(102))
+	assert.Equal(t, true, logicCtx.IsAudioPayloadTypeOrigin(111))
+
+	// 缺少ice信息
+	_, err = parseOffer([]byte(strings.Replace(goldenOffer, "a=ice-pwd:", "a=x-ice-pwd:", -1)))
+	assert.IsNotNil(t, err)
+}